@@ -0,0 +1,442 @@
+// Package acme obtains and renews the TLS certificates from the certificate
+// authorities supporting the ACME protocol, such as Let's Encrypt.
+//
+// See https://datatracker.ietf.org/doc/html/rfc8555.
+package acme
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/AdguardTeam/golibs/logutil/slogutil"
+	"github.com/AdguardTeam/golibs/netutil"
+	"github.com/AdguardTeam/golibs/service"
+	"github.com/AdguardTeam/golibs/timeutil"
+	"golang.org/x/crypto/acme"
+)
+
+// Default values of the manager parameters.
+const (
+	// DefaultDirectoryURL is the default URL of the ACME directory.
+	DefaultDirectoryURL = acme.LetsEncryptURL
+
+	// DefaultRenewBefore is the default period before the expiration of the
+	// certificate, within which it's renewed.
+	DefaultRenewBefore = 30 * timeutil.Day
+)
+
+// Intervals of the background refreshes.
+const (
+	// checkInterval is the interval between the checks of the certificate.
+	checkInterval = 12 * time.Hour
+
+	// retryInterval is the interval between the attempts to obtain the
+	// certificate after a failure.
+	retryInterval = 1 * time.Hour
+)
+
+// Names of the files within [Config.Dir].
+const (
+	accountKeyFile  = "account.key"
+	certificateFile = "certificate.pem"
+	privateKeyFile  = "private.key"
+)
+
+// Permissions of the created files and directories.
+const (
+	dirPerm  os.FileMode = 0o700
+	filePerm os.FileMode = 0o600
+)
+
+// ChallengeType is the type of the ACME challenge used to prove the control
+// over the domain.
+type ChallengeType string
+
+// Supported challenge types.
+const (
+	// ChallengeTypeHTTP01 is the challenge served by [Manager.ServeHTTP] on
+	// port 80.
+	//
+	// See https://datatracker.ietf.org/doc/html/rfc8555#section-8.3.
+	ChallengeTypeHTTP01 ChallengeType = "http-01"
+
+	// ChallengeTypeDNS01 is the challenge published as the TXT record using
+	// [TXTUpdater].
+	//
+	// See https://datatracker.ietf.org/doc/html/rfc8555#section-8.4.
+	ChallengeTypeDNS01 ChallengeType = "dns-01"
+)
+
+// TXTUpdater publishes the TXT records of the DNS-01 challenges.
+type TXTUpdater interface {
+	// AddTXT adds the TXT record with value to the fully-qualified name.
+	AddTXT(ctx context.Context, name, value string) (err error)
+
+	// RemoveTXT removes the TXT record with value from the fully-qualified
+	// name.
+	RemoveTXT(ctx context.Context, name, value string) (err error)
+}
+
+// Config is the configuration of the manager.
+type Config struct {
+	// Logger is used to log the operation of the manager.  It must not be nil.
+	Logger *slog.Logger
+
+	// HTTPClient is used to communicate with the ACME server.  If nil,
+	// [http.DefaultClient] is used.
+	HTTPClient *http.Client
+
+	// TXTUpdater publishes the DNS-01 challenges.  It must not be nil if
+	// ChallengeType is [ChallengeTypeDNS01].
+	TXTUpdater TXTUpdater
+
+	// OnCertificate is called each time a new certificate is stored.  It must
+	// not be nil.
+	OnCertificate func(ctx context.Context)
+
+	// DirectoryURL is the URL of the ACME directory.  If empty,
+	// [DefaultDirectoryURL] is used.
+	DirectoryURL string
+
+	// Email is the contact address of the account.  If empty, the account has
+	// no contacts.
+	Email string
+
+	// Dir is the directory to store the account key, the certificate, and its
+	// private key in.  It's created if it doesn't exist.  It must not be
+	// empty.
+	Dir string
+
+	// Domain is the domain name to obtain the certificate for.  It must be a
+	// valid domain name.
+	Domain string
+
+	// ChallengeType is the type of challenge to use.  It must be one of the
+	// supported types.
+	ChallengeType ChallengeType
+
+	// RenewBefore is the period before the expiration of the certificate,
+	// within which it's renewed.  If zero, [DefaultRenewBefore] is used.
+	RenewBefore time.Duration
+}
+
+// Manager obtains the certificate for a domain and keeps it renewed.
+type Manager struct {
+	logger *slog.Logger
+	client *acme.Client
+	txt    TXTUpdater
+	onCert func(ctx context.Context)
+
+	// tokensMu protects tokens.
+	tokensMu *sync.Mutex
+
+	// tokens maps the paths of the pending HTTP-01 challenges to the responses.
+	tokens map[string]string
+
+	// refreshMu serializes the refreshes.
+	refreshMu *sync.Mutex
+
+	// mu protects cancel and done.
+	mu *sync.Mutex
+
+	// cancel stops the background refreshes.  It's nil when they aren't
+	// running.
+	cancel context.CancelFunc
+
+	// done is closed when the background refreshes stop.
+	done chan struct{}
+
+	email       string
+	dir         string
+	domain      string
+	challenge   ChallengeType
+	renewBefore time.Duration
+
+	// registered is true if the account is registered with the server.  It's
+	// protected by refreshMu.
+	registered bool
+}
+
+// New returns a new properly initialized *Manager.  It creates the account key
+// within conf.Dir, if there is none.
+func New(conf *Config) (m *Manager, err error) {
+	defer func() { err = errors.Annotate(err, "acme: %w") }()
+
+	err = validateConfig(conf)
+	if err != nil {
+		// Don't wrap the error, because it's informative enough as is.
+		return nil, err
+	}
+
+	err = os.MkdirAll(conf.Dir, dirPerm)
+	if err != nil {
+		return nil, fmt.Errorf("creating dir: %w", err)
+	}
+
+	key, err := loadOrCreateKey(filepath.Join(conf.Dir, accountKeyFile))
+	if err != nil {
+		return nil, fmt.Errorf("account key: %w", err)
+	}
+
+	dirURL := conf.DirectoryURL
+	if dirURL == "" {
+		dirURL = DefaultDirectoryURL
+	}
+
+	renewBefore := conf.RenewBefore
+	if renewBefore == 0 {
+		renewBefore = DefaultRenewBefore
+	}
+
+	return &Manager{
+		logger: conf.Logger,
+		client: &acme.Client{
+			Key:          key,
+			HTTPClient:   conf.HTTPClient,
+			DirectoryURL: dirURL,
+			UserAgent:    "AdGuardHome",
+		},
+		txt:         conf.TXTUpdater,
+		onCert:      conf.OnCertificate,
+		tokensMu:    &sync.Mutex{},
+		tokens:      map[string]string{},
+		refreshMu:   &sync.Mutex{},
+		mu:          &sync.Mutex{},
+		email:       conf.Email,
+		dir:         conf.Dir,
+		domain:      conf.Domain,
+		challenge:   conf.ChallengeType,
+		renewBefore: renewBefore,
+	}, nil
+}
+
+// validateConfig returns an error if conf isn't valid.
+func validateConfig(conf *Config) (err error) {
+	if conf.Dir == "" {
+		return errors.Error("empty dir")
+	}
+
+	err = netutil.ValidateDomainName(conf.Domain)
+	if err != nil {
+		return fmt.Errorf("domain: %w", err)
+	}
+
+	switch conf.ChallengeType {
+	case ChallengeTypeHTTP01:
+		// Go on.
+	case ChallengeTypeDNS01:
+		if conf.TXTUpdater == nil {
+			return errors.Error("no txt updater for dns-01 challenge")
+		}
+	default:
+		return fmt.Errorf("challenge type: %w: %q", errors.ErrBadEnumValue, conf.ChallengeType)
+	}
+
+	return nil
+}
+
+// loadOrCreateKey loads the PEM-encoded ECDSA key from the file at path or
+// generates a new one and writes it there, if there is no such file.
+func loadOrCreateKey(path string) (key crypto.Signer, err error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		var ecKey *ecdsa.PrivateKey
+		ecKey, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			return nil, fmt.Errorf("generating: %w", err)
+		}
+
+		return ecKey, writeKey(path, ecKey)
+	} else if err != nil {
+		// Don't wrap the error, because it's informative enough as is.
+		return nil, err
+	}
+
+	b, _ := pem.Decode(data)
+	if b == nil {
+		return nil, errors.Error("no pem block")
+	}
+
+	parsed, err := x509.ParsePKCS8PrivateKey(b.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("parsing: %w", err)
+	}
+
+	key, ok := parsed.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("unsupported key type %T", parsed)
+	}
+
+	return key, nil
+}
+
+// CertificatePath returns the path to the PEM-encoded certificate chain.
+func (m *Manager) CertificatePath() (path string) {
+	return filepath.Join(m.dir, certificateFile)
+}
+
+// PrivateKeyPath returns the path to the PEM-encoded private key of the
+// certificate.
+func (m *Manager) PrivateKeyPath() (path string) {
+	return filepath.Join(m.dir, privateKeyFile)
+}
+
+// type check
+var _ service.Interface = (*Manager)(nil)
+
+// Start implements the [service.Interface] interface for *Manager.  It starts
+// refreshing the certificate in the background, the first refresh is performed
+// immediately.
+func (m *Manager) Start(ctx context.Context) (err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.cancel != nil {
+		return nil
+	}
+
+	ctx, m.cancel = context.WithCancel(context.WithoutCancel(ctx))
+	m.done = make(chan struct{})
+
+	go m.refreshLoop(ctx, m.done)
+
+	m.logger.InfoContext(ctx, "started", "domain", m.domain, "challenge", m.challenge)
+
+	return nil
+}
+
+// Shutdown implements the [service.Interface] interface for *Manager.  It stops
+// the background refreshes and waits for them to finish.
+func (m *Manager) Shutdown(ctx context.Context) (err error) {
+	m.mu.Lock()
+	cancel, done := m.cancel, m.done
+	m.cancel, m.done = nil, nil
+	m.mu.Unlock()
+
+	if cancel == nil {
+		return nil
+	}
+
+	cancel()
+
+	select {
+	case <-done:
+		m.logger.InfoContext(ctx, "stopped")
+
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("acme: shutting down: %w", ctx.Err())
+	}
+}
+
+// refreshLoop refreshes the certificate until ctx is canceled and closes done
+// then.  It's intended to be used as a goroutine.
+func (m *Manager) refreshLoop(ctx context.Context, done chan struct{}) {
+	defer close(done)
+	defer slogutil.RecoverAndLog(ctx, m.logger)
+
+	for {
+		next := checkInterval
+
+		err := m.Refresh(ctx)
+		if err != nil {
+			m.logger.ErrorContext(ctx, "refreshing", "retry_in", retryInterval, slogutil.KeyError, err)
+
+			next = retryInterval
+		}
+
+		t := time.NewTimer(next)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+
+			return
+		case <-t.C:
+			// Go on.
+		}
+	}
+}
+
+// Refresh obtains a new certificate if there is no valid one stored or if it's
+// about to expire.  It calls [Config.OnCertificate] if a new certificate is
+// stored.
+func (m *Manager) Refresh(ctx context.Context) (err error) {
+	m.refreshMu.Lock()
+	defer m.refreshMu.Unlock()
+
+	notAfter, err := m.storedNotAfter()
+	if err != nil {
+		m.logger.DebugContext(ctx, "no usable certificate", slogutil.KeyError, err)
+	} else if time.Until(notAfter) > m.renewBefore {
+		m.logger.DebugContext(ctx, "certificate is up to date", "not_after", notAfter)
+
+		return nil
+	}
+
+	m.logger.InfoContext(ctx, "obtaining certificate", "domain", m.domain)
+
+	err = m.obtain(ctx)
+	if err != nil {
+		return fmt.Errorf("acme: obtaining certificate for %q: %w", m.domain, err)
+	}
+
+	m.logger.InfoContext(ctx, "certificate stored", "path", m.CertificatePath())
+
+	m.onCert(ctx)
+
+	return nil
+}
+
+// storedNotAfter returns the expiration time of the stored certificate.  err is
+// not nil if there is no stored certificate for the domain or if it doesn't
+// match the stored private key.
+func (m *Manager) storedNotAfter() (notAfter time.Time, err error) {
+	data, err := os.ReadFile(m.CertificatePath())
+	if err != nil {
+		// Don't wrap the error, because it's informative enough as is.
+		return time.Time{}, err
+	}
+
+	keyData, err := os.ReadFile(m.PrivateKeyPath())
+	if err != nil {
+		// Don't wrap the error, because it's informative enough as is.
+		return time.Time{}, err
+	}
+
+	_, err = tls.X509KeyPair(data, keyData)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("key pair: %w", err)
+	}
+
+	b, _ := pem.Decode(data)
+	if b == nil {
+		return time.Time{}, errors.Error("no pem block")
+	}
+
+	cert, err := x509.ParseCertificate(b.Bytes)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("parsing: %w", err)
+	}
+
+	err = cert.VerifyHostname(m.domain)
+	if err != nil {
+		// Don't wrap the error, because it's informative enough as is.
+		return time.Time{}, err
+	}
+
+	return cert.NotAfter, nil
+}
@@ -0,0 +1,386 @@
+package acme_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/acme"
+	"github.com/AdguardTeam/golibs/logutil/slogutil"
+	"github.com/AdguardTeam/golibs/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testTimeout is the common timeout for tests.
+const testTimeout = 1 * time.Second
+
+// testDomain is the domain to obtain the certificates for in tests.
+const testDomain = "agh.example"
+
+// testToken is the token of the challenges issued by the test server.
+const testToken = "test-token"
+
+// testTXTUpdater is a [acme.TXTUpdater] for tests.
+type testTXTUpdater struct {
+	mu      *sync.Mutex
+	records map[string]string
+}
+
+// type check
+var _ acme.TXTUpdater = (*testTXTUpdater)(nil)
+
+// AddTXT implements the [acme.TXTUpdater] interface for *testTXTUpdater.
+func (u *testTXTUpdater) AddTXT(_ context.Context, name, value string) (err error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	u.records[name] = value
+
+	return nil
+}
+
+// RemoveTXT implements the [acme.TXTUpdater] interface for *testTXTUpdater.
+func (u *testTXTUpdater) RemoveTXT(_ context.Context, name, _ string) (err error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	delete(u.records, name)
+
+	return nil
+}
+
+// testServer is a minimal ACME server, which issues the certificates once the
+// challenge is validated by validate.
+type testServer struct {
+	*httptest.Server
+
+	// validate returns true if the challenge of type typ is completed.
+	validate func(typ string) (ok bool)
+
+	ca    *x509.Certificate
+	caKey *ecdsa.PrivateKey
+
+	mu    *sync.Mutex
+	valid bool
+	cert  []byte
+}
+
+// newTestServer returns a started *testServer.
+func newTestServer(t *testing.T) (srv *testServer) {
+	t.Helper()
+
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	caTmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+
+	caDER, err := x509.CreateCertificate(rand.Reader, caTmpl, caTmpl, caKey.Public(), caKey)
+	require.NoError(t, err)
+
+	ca, err := x509.ParseCertificate(caDER)
+	require.NoError(t, err)
+
+	srv = &testServer{
+		ca:    ca,
+		caKey: caKey,
+		mu:    &sync.Mutex{},
+	}
+
+	srv.Server = httptest.NewServer(srv)
+	t.Cleanup(srv.Close)
+
+	return srv
+}
+
+// ServeHTTP implements the [http.Handler] interface for *testServer.
+func (srv *testServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	pt := testutil.PanicT{}
+
+	w.Header().Set("Replay-Nonce", "nonce")
+
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+
+	u := srv.URL
+	authz := map[string]any{
+		"status":     "pending",
+		"identifier": map[string]any{"type": "dns", "value": testDomain},
+		"challenges": []any{map[string]any{
+			"type":  "http-01",
+			"url":   u + "/chal/http-01",
+			"token": testToken,
+		}, map[string]any{
+			"type":  "dns-01",
+			"url":   u + "/chal/dns-01",
+			"token": testToken,
+		}},
+	}
+	if srv.valid {
+		authz["status"] = "valid"
+	}
+
+	order := map[string]any{
+		"status":         "ready",
+		"identifiers":    []any{map[string]any{"type": "dns", "value": testDomain}},
+		"authorizations": []string{u + "/authz"},
+		"finalize":       u + "/finalize",
+	}
+
+	var resp any
+	switch p := r.URL.Path; {
+	case p == "/directory":
+		resp = map[string]any{
+			"newNonce":   u + "/nonce",
+			"newAccount": u + "/account",
+			"newOrder":   u + "/order",
+		}
+	case p == "/nonce":
+		return
+	case p == "/account":
+		w.Header().Set("Location", u+"/account/1")
+		w.WriteHeader(http.StatusCreated)
+		resp = map[string]any{"status": "valid"}
+	case p == "/order":
+		w.Header().Set("Location", u+"/order/1")
+		w.WriteHeader(http.StatusCreated)
+		resp = order
+	case p == "/order/1":
+		resp = order
+	case p == "/authz":
+		resp = authz
+	case strings.HasPrefix(p, "/chal/"):
+		typ := strings.TrimPrefix(p, "/chal/")
+		srv.valid = srv.validate(typ)
+		resp = map[string]any{"type": typ, "url": u + p, "token": testToken, "status": "processing"}
+	case p == "/finalize":
+		srv.cert = srv.issue(pt, r)
+		order["status"] = "valid"
+		order["certificate"] = u + "/cert"
+		resp = order
+	case p == "/cert":
+		_ = pem.Encode(w, &pem.Block{Type: "CERTIFICATE", Bytes: srv.cert})
+		_ = pem.Encode(w, &pem.Block{Type: "CERTIFICATE", Bytes: srv.ca.Raw})
+
+		return
+	default:
+		http.NotFound(w, r)
+
+		return
+	}
+
+	err := json.NewEncoder(w).Encode(resp)
+	require.NoError(pt, err)
+}
+
+// issue returns the certificate for the CSR from the finalization request r.
+func (srv *testServer) issue(t testutil.PanicT, r *http.Request) (der []byte) {
+	jws := &struct {
+		Payload string `json:"payload"`
+	}{}
+	err := json.NewDecoder(r.Body).Decode(jws)
+	require.NoError(t, err)
+
+	payload, err := base64.RawURLEncoding.DecodeString(jws.Payload)
+	require.NoError(t, err)
+
+	req := &struct {
+		CSR string `json:"csr"`
+	}{}
+	err = json.Unmarshal(payload, req)
+	require.NoError(t, err)
+
+	csrDER, err := base64.RawURLEncoding.DecodeString(req.CSR)
+	require.NoError(t, err)
+
+	csr, err := x509.ParseCertificateRequest(csrDER)
+	require.NoError(t, err)
+
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		DNSNames:     csr.DNSNames,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(90 * 24 * time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+
+	der, err = x509.CreateCertificate(rand.Reader, tmpl, srv.ca, csr.PublicKey, srv.caKey)
+	require.NoError(t, err)
+
+	return der
+}
+
+func TestManager_Refresh(t *testing.T) {
+	testCases := []struct {
+		name      string
+		challenge acme.ChallengeType
+	}{{
+		name:      "http-01",
+		challenge: acme.ChallengeTypeHTTP01,
+	}, {
+		name:      "dns-01",
+		challenge: acme.ChallengeTypeDNS01,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			srv := newTestServer(t)
+			txt := &testTXTUpdater{
+				mu:      &sync.Mutex{},
+				records: map[string]string{},
+			}
+
+			var m *acme.Manager
+			srv.validate = func(typ string) (ok bool) {
+				if typ != string(tc.challenge) {
+					return false
+				}
+
+				if typ == string(acme.ChallengeTypeDNS01) {
+					txt.mu.Lock()
+					defer txt.mu.Unlock()
+
+					return txt.records["_acme-challenge."+testDomain] != ""
+				}
+
+				rw := httptest.NewRecorder()
+				m.ServeHTTP(rw, httptest.NewRequest(
+					http.MethodGet,
+					"/.well-known/acme-challenge/"+testToken,
+					nil,
+				))
+
+				return rw.Code == http.StatusOK &&
+					strings.HasPrefix(rw.Body.String(), testToken+".")
+			}
+
+			certs := make(chan struct{}, 2)
+
+			var err error
+			m, err = acme.New(&acme.Config{
+				Logger:        slogutil.NewDiscardLogger(),
+				TXTUpdater:    txt,
+				OnCertificate: func(_ context.Context) { certs <- struct{}{} },
+				DirectoryURL:  srv.URL + "/directory",
+				Email:         "admin@agh.example",
+				Dir:           t.TempDir(),
+				Domain:        testDomain,
+				ChallengeType: tc.challenge,
+			})
+			require.NoError(t, err)
+
+			ctx := testutil.ContextWithTimeout(t, testTimeout)
+			err = m.Refresh(ctx)
+			require.NoError(t, err)
+
+			testutil.RequireReceive(t, certs, testTimeout)
+
+			pair, err := tls.LoadX509KeyPair(m.CertificatePath(), m.PrivateKeyPath())
+			require.NoError(t, err)
+			require.Len(t, pair.Certificate, 2)
+
+			leaf, err := x509.ParseCertificate(pair.Certificate[0])
+			require.NoError(t, err)
+
+			assert.Equal(t, []string{testDomain}, leaf.DNSNames)
+
+			// The challenge responses are withdrawn after the validation.
+			assert.Empty(t, txt.records)
+
+			// The certificate isn't about to expire, so it's not obtained
+			// again.
+			err = m.Refresh(ctx)
+			require.NoError(t, err)
+
+			assert.Empty(t, certs)
+			assert.NoFileExists(t, m.CertificatePath()+".tmp")
+			assert.NoFileExists(t, m.PrivateKeyPath()+".tmp")
+
+			// The certificate not matching the stored private key is obtained
+			// again.
+			writeTestKey(t, m.PrivateKeyPath())
+
+			err = m.Refresh(ctx)
+			require.NoError(t, err)
+
+			testutil.RequireReceive(t, certs, testTimeout)
+
+			_, err = tls.LoadX509KeyPair(m.CertificatePath(), m.PrivateKeyPath())
+			require.NoError(t, err)
+		})
+	}
+}
+
+// writeTestKey writes a new PEM-encoded private key into the file at path.
+func writeTestKey(t *testing.T, path string) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	require.NoError(t, err)
+
+	data := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+	err = os.WriteFile(path, data, 0o600)
+	require.NoError(t, err)
+}
+
+func TestNew(t *testing.T) {
+	dir := t.TempDir()
+	conf := &acme.Config{
+		Logger:        slogutil.NewDiscardLogger(),
+		OnCertificate: func(_ context.Context) {},
+		Dir:           dir,
+		Domain:        testDomain,
+		ChallengeType: acme.ChallengeTypeHTTP01,
+	}
+
+	_, err := acme.New(conf)
+	require.NoError(t, err)
+
+	key, err := os.ReadFile(dir + "/account.key")
+	require.NoError(t, err)
+
+	// The account key is reused.
+	_, err = acme.New(conf)
+	require.NoError(t, err)
+
+	reused, err := os.ReadFile(dir + "/account.key")
+	require.NoError(t, err)
+
+	assert.Equal(t, key, reused)
+
+	conf.ChallengeType = acme.ChallengeTypeDNS01
+	_, err = acme.New(conf)
+	assert.Error(t, err)
+
+	conf.ChallengeType = acme.ChallengeTypeHTTP01
+	conf.Domain = "bad domain"
+	_, err = acme.New(conf)
+	assert.Error(t, err)
+}
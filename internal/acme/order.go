@@ -0,0 +1,298 @@
+package acme
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"os"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/aghhttp"
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/AdguardTeam/golibs/httphdr"
+	"github.com/AdguardTeam/golibs/logutil/slogutil"
+	"github.com/google/renameio/v2/maybe"
+	"golang.org/x/crypto/acme"
+)
+
+// dnsChallengeLabel is the label prepended to the domain name to get the name
+// of the TXT record of the DNS-01 challenge.
+const dnsChallengeLabel = "_acme-challenge."
+
+// obtain orders a new certificate for the domain and stores it along with its
+// private key.  m.refreshMu is expected to be locked.
+func (m *Manager) obtain(ctx context.Context) (err error) {
+	err = m.register(ctx)
+	if err != nil {
+		return fmt.Errorf("registering account: %w", err)
+	}
+
+	order, err := m.client.AuthorizeOrder(ctx, acme.DomainIDs(m.domain))
+	if err != nil {
+		return fmt.Errorf("creating order: %w", err)
+	}
+
+	for _, u := range order.AuthzURLs {
+		err = m.authorize(ctx, u)
+		if err != nil {
+			// Don't wrap the error, because it's informative enough as is.
+			return err
+		}
+	}
+
+	order, err = m.client.WaitOrder(ctx, order.URI)
+	if err != nil {
+		return fmt.Errorf("waiting for order: %w", err)
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return fmt.Errorf("generating key: %w", err)
+	}
+
+	csr, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		DNSNames: []string{m.domain},
+	}, key)
+	if err != nil {
+		return fmt.Errorf("creating csr: %w", err)
+	}
+
+	der, _, err := m.client.CreateOrderCert(ctx, order.FinalizeURL, csr, true)
+	if err != nil {
+		return fmt.Errorf("finalizing order: %w", err)
+	}
+
+	return m.store(der, key)
+}
+
+// register registers the account with the server, unless it's already done.
+// m.refreshMu is expected to be locked.
+func (m *Manager) register(ctx context.Context) (err error) {
+	if m.registered {
+		return nil
+	}
+
+	acct := &acme.Account{}
+	if m.email != "" {
+		acct.Contact = []string{"mailto:" + m.email}
+	}
+
+	_, err = m.client.Register(ctx, acct, acme.AcceptTOS)
+	if err != nil && !errors.Is(err, acme.ErrAccountAlreadyExists) {
+		// Don't wrap the error, because it's informative enough as is.
+		return err
+	}
+
+	m.registered = true
+
+	return nil
+}
+
+// authorize completes the challenge of the authorization at u, unless it's
+// already valid.
+func (m *Manager) authorize(ctx context.Context, u string) (err error) {
+	z, err := m.client.GetAuthorization(ctx, u)
+	if err != nil {
+		return fmt.Errorf("getting authorization: %w", err)
+	} else if z.Status == acme.StatusValid {
+		return nil
+	}
+
+	var chal *acme.Challenge
+	for _, c := range z.Challenges {
+		if c.Type == string(m.challenge) {
+			chal = c
+
+			break
+		}
+	}
+
+	if chal == nil {
+		return fmt.Errorf("no %s challenge offered for %q", m.challenge, z.Identifier.Value)
+	}
+
+	cleanup, err := m.prepare(ctx, z.Identifier.Value, chal)
+	if err != nil {
+		return fmt.Errorf("preparing %s challenge: %w", m.challenge, err)
+	}
+	defer func() { err = errors.WithDeferred(err, cleanup(ctx)) }()
+
+	_, err = m.client.Accept(ctx, chal)
+	if err != nil {
+		return fmt.Errorf("accepting challenge: %w", err)
+	}
+
+	_, err = m.client.WaitAuthorization(ctx, z.URI)
+	if err != nil {
+		return fmt.Errorf("waiting for authorization: %w", err)
+	}
+
+	return nil
+}
+
+// prepare makes the response to chal for domain available to the server.
+// cleanup withdraws it.
+func (m *Manager) prepare(
+	ctx context.Context,
+	domain string,
+	chal *acme.Challenge,
+) (cleanup func(ctx context.Context) (err error), err error) {
+	if m.challenge == ChallengeTypeDNS01 {
+		var val string
+		val, err = m.client.DNS01ChallengeRecord(chal.Token)
+		if err != nil {
+			// Don't wrap the error, because it's informative enough as is.
+			return nil, err
+		}
+
+		name := dnsChallengeLabel + domain
+		err = m.txt.AddTXT(ctx, name, val)
+		if err != nil {
+			// Don't wrap the error, because it's informative enough as is.
+			return nil, err
+		}
+
+		return func(ctx context.Context) (err error) {
+			return m.txt.RemoveTXT(ctx, name, val)
+		}, nil
+	}
+
+	resp, err := m.client.HTTP01ChallengeResponse(chal.Token)
+	if err != nil {
+		// Don't wrap the error, because it's informative enough as is.
+		return nil, err
+	}
+
+	p := m.client.HTTP01ChallengePath(chal.Token)
+
+	m.tokensMu.Lock()
+	defer m.tokensMu.Unlock()
+
+	m.tokens[p] = resp
+
+	return func(_ context.Context) (err error) {
+		m.tokensMu.Lock()
+		defer m.tokensMu.Unlock()
+
+		delete(m.tokens, p)
+
+		return nil
+	}, nil
+}
+
+// ServeHTTP implements the [http.Handler] interface for *Manager.  It responds
+// to the pending HTTP-01 challenges.  It's intended to be registered for the
+// "/.well-known/acme-challenge/" path.
+func (m *Manager) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	m.tokensMu.Lock()
+	resp, ok := m.tokens[r.URL.Path]
+	m.tokensMu.Unlock()
+
+	if !ok {
+		http.NotFound(w, r)
+
+		return
+	}
+
+	m.logger.DebugContext(r.Context(), "responding to challenge", "path", r.URL.Path)
+
+	w.Header().Set(httphdr.ContentType, aghhttp.HdrValTextPlain)
+	_, err := w.Write([]byte(resp))
+	if err != nil {
+		m.logger.DebugContext(r.Context(), "writing challenge", slogutil.KeyError, err)
+	}
+}
+
+// store writes the certificate chain der and its private key key into the
+// directory.  Both files are written next to the current ones first and only
+// then renamed, so that a failed write doesn't leave the certificate with the
+// private key of another one.  An interrupted rename is caught by
+// [Manager.storedNotAfter], which checks the stored pair.
+func (m *Manager) store(der [][]byte, key crypto.Signer) (err error) {
+	keyData, err := encodeKey(key)
+	if err != nil {
+		return fmt.Errorf("encoding private key: %w", err)
+	}
+
+	buf := &bytes.Buffer{}
+	for _, c := range der {
+		err = pem.Encode(buf, &pem.Block{Type: "CERTIFICATE", Bytes: c})
+		if err != nil {
+			return fmt.Errorf("encoding certificate: %w", err)
+		}
+	}
+
+	keyPath, certPath := m.PrivateKeyPath(), m.CertificatePath()
+	keyTmp, certTmp := keyPath+tmpSuffix, certPath+tmpSuffix
+	defer func() {
+		if err != nil {
+			err = errors.WithDeferred(err, removeTemp(keyTmp, certTmp))
+		}
+	}()
+
+	err = os.WriteFile(keyTmp, keyData, filePerm)
+	if err != nil {
+		return fmt.Errorf("writing private key: %w", err)
+	}
+
+	err = os.WriteFile(certTmp, buf.Bytes(), filePerm)
+	if err != nil {
+		return fmt.Errorf("writing certificate: %w", err)
+	}
+
+	err = os.Rename(keyTmp, keyPath)
+	if err != nil {
+		return fmt.Errorf("replacing private key: %w", err)
+	}
+
+	err = os.Rename(certTmp, certPath)
+	if err != nil {
+		return fmt.Errorf("replacing certificate: %w", err)
+	}
+
+	return nil
+}
+
+// tmpSuffix is the suffix of the files written by [Manager.store] before
+// replacing the stored ones.
+const tmpSuffix = ".tmp"
+
+// removeTemp removes the temporary files at paths, if any.
+func removeTemp(paths ...string) (err error) {
+	var errs []error
+	for _, p := range paths {
+		rmErr := os.Remove(p)
+		if rmErr != nil && !errors.Is(rmErr, os.ErrNotExist) {
+			errs = append(errs, rmErr)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// writeKey writes key into the file at path in the PEM-encoded PKCS #8 form.
+func writeKey(path string, key crypto.Signer) (err error) {
+	data, err := encodeKey(key)
+	if err != nil {
+		// Don't wrap the error, because it's informative enough as is.
+		return err
+	}
+
+	return maybe.WriteFile(path, data, filePerm)
+}
+
+// encodeKey returns key in the PEM-encoded PKCS #8 form.
+func encodeKey(key crypto.Signer) (data []byte, err error) {
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, fmt.Errorf("encoding: %w", err)
+	}
+
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), nil
+}
@@ -0,0 +1,25 @@
+package client
+
+// Info is the information about a persistent client used by the DNS server.
+type Info struct {
+	// Name is the name of the client.
+	Name string
+
+	// Tags are the tags of the client.
+	Tags []string
+
+	// AllowedDomains are the domains, including their subdomains, which are
+	// resolved for the client in [ModeAllowlistOnly] in addition to the ones
+	// allowlisted by the filtering rules.
+	AllowedDomains []string
+
+	// UID is the unique identifier of the client.
+	UID UID
+
+	// CacheSize is the size of the DNS cache for the responses of the client's
+	// custom upstreams in bytes.  If zero, such responses aren't cached.
+	CacheSize uint32
+
+	// Mode is the current temporary mode of the client.
+	Mode Mode
+}
@@ -0,0 +1,52 @@
+package client
+
+import (
+	"fmt"
+	"time"
+)
+
+// Mode is the temporary mode of a persistent client.
+type Mode uint8
+
+const (
+	// ModeNormal means that the client's requests are processed according to
+	// its settings.
+	ModeNormal Mode = iota
+
+	// ModePaused means that the protection is paused for the client.
+	ModePaused
+
+	// ModeAllowlistOnly means that only the allowlisted domains are resolved
+	// for the client and all other requests are blocked.
+	ModeAllowlistOnly
+)
+
+// String implements the [fmt.Stringer] interface for Mode.
+func (m Mode) String() (s string) {
+	switch m {
+	case ModeNormal:
+		return "normal"
+	case ModePaused:
+		return "paused"
+	case ModeAllowlistOnly:
+		return "allowlist_only"
+	default:
+		return fmt.Sprintf("!bad_mode_%d", m)
+	}
+}
+
+// ModeAt returns the mode of the client at now.  The explicitly set temporary
+// modes take precedence over the bedtime schedule, and the allowlist-only
+// period takes precedence over the pause.
+func (c *Persistent) ModeAt(now time.Time) (m Mode) {
+	switch {
+	case now.Before(c.AllowlistOnlyUntil):
+		return ModeAllowlistOnly
+	case now.Before(c.PausedUntil):
+		return ModePaused
+	case c.Bedtime != nil && c.Bedtime.Contains(now):
+		return ModeAllowlistOnly
+	default:
+		return ModeNormal
+	}
+}
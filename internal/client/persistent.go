@@ -9,8 +9,10 @@ import (
 	"net/netip"
 	"slices"
 	"strings"
+	"time"
 
 	"github.com/AdguardTeam/AdGuardHome/internal/filtering"
+	"github.com/AdguardTeam/AdGuardHome/internal/schedule"
 	"github.com/AdguardTeam/dnsproxy/proxy"
 	"github.com/AdguardTeam/dnsproxy/upstream"
 	"github.com/AdguardTeam/golibs/errors"
@@ -71,6 +73,11 @@ type Persistent struct {
 	// must not be nil after initialization.
 	BlockedServices *filtering.BlockedServices
 
+	// FilteringSchedule is the schedule during which filtering is paused for
+	// the client, if FilteringEnabled is true.  If it's nil, filtering is
+	// never paused.
+	FilteringSchedule *schedule.Weekly
+
 	// Name of the persistent client.  Must not be empty.
 	Name string
 
@@ -98,6 +105,24 @@ type Persistent struct {
 	// (IP, subnet, MAC, or ClientID).
 	ClientIDs []string
 
+	// Bedtime is the recurring schedule during which only the allowlisted
+	// domains are resolved for the client.  If it's nil, there is no bedtime.
+	Bedtime *schedule.Weekly
+
+	// AllowedDomains are the domains, including their subdomains, which are
+	// resolved for the client in [ModeAllowlistOnly] in addition to the ones
+	// allowlisted by the filtering rules.
+	AllowedDomains []string
+
+	// PausedUntil is the time until which the protection is paused for the
+	// client.  The zero value means that the protection isn't paused.
+	PausedUntil time.Time
+
+	// AllowlistOnlyUntil is the time until which only the allowlisted domains
+	// are resolved for the client.  The zero value means that there is no
+	// such period.
+	AllowlistOnlyUntil time.Time
+
 	// UID is the unique identifier of the persistent client.
 	UID UID
 
@@ -163,6 +188,16 @@ func (c *Persistent) validate(ctx context.Context, l *slog.Logger, allTags []str
 		}
 	}
 
+	for i, d := range c.AllowedDomains {
+		d = strings.ToLower(d)
+		err = netutil.ValidateDomainName(d)
+		if err != nil {
+			return fmt.Errorf("allowed domain at index %d: %w", i, err)
+		}
+
+		c.AllowedDomains[i] = d
+	}
+
 	// TODO(s.chzhen):  Move to the constructor.
 	slices.Sort(c.Tags)
 
@@ -302,6 +337,9 @@ func (c *Persistent) ShallowClone() (clone *Persistent) {
 	*clone = *c
 
 	clone.BlockedServices = c.BlockedServices.Clone()
+	clone.FilteringSchedule = c.FilteringSchedule.Clone()
+	clone.Bedtime = c.Bedtime.Clone()
+	clone.AllowedDomains = slices.Clone(c.AllowedDomains)
 	clone.Tags = slices.Clone(c.Tags)
 	clone.Upstreams = slices.Clone(c.Upstreams)
 
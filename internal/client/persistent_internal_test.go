@@ -2,9 +2,12 @@ package client
 
 import (
 	"testing"
+	"time"
 
+	"github.com/AdguardTeam/AdGuardHome/internal/schedule"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
 )
 
 func TestPersistent_EqualIDs(t *testing.T) {
@@ -122,3 +125,82 @@ func TestPersistent_EqualIDs(t *testing.T) {
 		})
 	}
 }
+
+func TestPersistent_ModeAt(t *testing.T) {
+	const bedtimeYAML = `
+mon:
+    start: 21h
+    end: 24h
+time_zone: UTC
+`
+
+	bedtime := &schedule.Weekly{}
+	err := yaml.Unmarshal([]byte(bedtimeYAML), bedtime)
+	require.NoError(t, err)
+
+	monday := time.Date(2024, time.December, 2, 0, 0, 0, 0, time.UTC)
+	evening := monday.Add(22 * time.Hour)
+	noon := monday.Add(12 * time.Hour)
+
+	testCases := []struct {
+		cli  *Persistent
+		now  time.Time
+		name string
+		want Mode
+	}{{
+		cli:  &Persistent{},
+		now:  evening,
+		name: "normal",
+		want: ModeNormal,
+	}, {
+		cli: &Persistent{
+			PausedUntil: noon.Add(30 * time.Minute),
+		},
+		now:  noon,
+		name: "paused",
+		want: ModePaused,
+	}, {
+		cli: &Persistent{
+			PausedUntil: noon.Add(30 * time.Minute),
+		},
+		now:  noon.Add(time.Hour),
+		name: "pause_expired",
+		want: ModeNormal,
+	}, {
+		cli: &Persistent{
+			PausedUntil:        noon.Add(30 * time.Minute),
+			AllowlistOnlyUntil: noon.Add(30 * time.Minute),
+		},
+		now:  noon,
+		name: "allowlist_only",
+		want: ModeAllowlistOnly,
+	}, {
+		cli: &Persistent{
+			Bedtime: bedtime,
+		},
+		now:  evening,
+		name: "bedtime",
+		want: ModeAllowlistOnly,
+	}, {
+		cli: &Persistent{
+			Bedtime: bedtime,
+		},
+		now:  noon,
+		name: "not_bedtime",
+		want: ModeNormal,
+	}, {
+		cli: &Persistent{
+			Bedtime:     bedtime,
+			PausedUntil: evening.Add(30 * time.Minute),
+		},
+		now:  evening,
+		name: "paused_at_bedtime",
+		want: ModePaused,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.want, tc.cli.ModeAt(tc.now))
+		})
+	}
+}
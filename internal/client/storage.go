@@ -1,6 +1,7 @@
 package client
 
 import (
+	"cmp"
 	"context"
 	"fmt"
 	"log/slog"
@@ -353,6 +354,11 @@ func (s *Storage) UpdateDHCP(ctx context.Context) {
 
 	added := 0
 	for _, l := range s.dhcp.Leases() {
+		if !l.IP.IsValid() {
+			// Skip the leases of the delegated prefixes.
+			continue
+		}
+
 		s.runtimeIndex.setInfo(l.IP, src, []string{l.Hostname})
 		added++
 	}
@@ -441,6 +447,38 @@ func (s *Storage) FindByName(name string) (p *Persistent, ok bool) {
 	return nil, false
 }
 
+// FindInfo finds persistent client by string representation of the client ID,
+// IP address, or MAC and returns the information about it used by the DNS
+// server.  Unlike [Storage.Find], it doesn't copy the client, so it's cheap
+// enough to be called for every DNS request.  info must not be modified.
+func (s *Storage) FindInfo(id string) (info *Info, ok bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	p, ok := s.index.find(id)
+	if !ok {
+		return nil, false
+	}
+
+	info = &Info{
+		Name:           p.Name,
+		Tags:           p.Tags,
+		AllowedDomains: p.AllowedDomains,
+		UID:            p.UID,
+		Mode:           p.ModeAt(time.Now()),
+	}
+
+	if p.UpstreamsCacheEnabled {
+		info.CacheSize = cmp.Or(p.UpstreamsCacheSize, defaultUpstreamsCacheSize)
+	}
+
+	return info, true
+}
+
+// defaultUpstreamsCacheSize is the size of the cache for the custom upstreams
+// of a client, which has the cache enabled but its size unset, in bytes.
+const defaultUpstreamsCacheSize = 64 * 1024
+
 // Find finds persistent client by string representation of the client ID, IP
 // address, or MAC.  And returns its shallow copy.
 //
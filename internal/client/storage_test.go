@@ -911,6 +911,37 @@ func TestStorage_FindLoose(t *testing.T) {
 	}
 }
 
+func TestStorage_FindInfo(t *testing.T) {
+	const cliIP = "1.1.1.1"
+
+	s := newStorage(t, []*client.Persistent{{
+		Name:                  "client",
+		IPs:                   []netip.Addr{netip.MustParseAddr(cliIP)},
+		Tags:                  []string{"device_pc"},
+		AllowedDomains:        []string{"example.org"},
+		UpstreamsCacheEnabled: true,
+	}})
+
+	p, ok := s.FindByName("client")
+	require.True(t, ok)
+
+	info, ok := s.FindInfo(cliIP)
+	require.True(t, ok)
+
+	assert.Equal(t, &client.Info{
+		Name:           "client",
+		Tags:           []string{"device_pc"},
+		AllowedDomains: []string{"example.org"},
+		UID:            p.UID,
+		CacheSize:      64 * 1024,
+		Mode:           client.ModeNormal,
+	}, info)
+
+	info, ok = s.FindInfo("2.2.2.2")
+	assert.False(t, ok)
+	assert.Nil(t, info)
+}
+
 func TestStorage_FindByName(t *testing.T) {
 	const (
 		cliIP1 = "1.1.1.1"
@@ -0,0 +1,594 @@
+// Package ddns publishes the hostnames of the DHCP clients and the ACME
+// challenges into an external authoritative DNS server using the dynamic
+// updates.
+//
+// See https://datatracker.ietf.org/doc/html/rfc2136.
+package ddns
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/netip"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/AdguardTeam/golibs/netutil"
+	"github.com/miekg/dns"
+)
+
+// Default values of the updater parameters.
+const (
+	// DefaultTTL is the default TTL of the published records.
+	DefaultTTL = 5 * time.Minute
+
+	// DefaultTimeout is the default timeout of a single update.
+	DefaultTimeout = 5 * time.Second
+)
+
+// tsigFudge is the permitted time difference in seconds between the server and
+// AdGuard Home for the signed updates.
+//
+// See https://datatracker.ietf.org/doc/html/rfc8945#section-10.
+const tsigFudge = 300
+
+// TSIGKey is the key to sign the updates with.
+//
+// See https://datatracker.ietf.org/doc/html/rfc8945.
+type TSIGKey struct {
+	// Name is the name of the key as known to the server.  It must not be
+	// empty.
+	Name string
+
+	// Algorithm is the name of the HMAC algorithm, e.g. "hmac-sha256".  If
+	// empty, hmac-sha256 is used.
+	Algorithm string
+
+	// Secret is the base64-encoded secret of the key.  It must not be empty.
+	Secret string
+}
+
+// algorithms are the supported TSIG algorithms.
+var algorithms = []string{
+	dns.HmacSHA1,
+	dns.HmacSHA224,
+	dns.HmacSHA256,
+	dns.HmacSHA384,
+	dns.HmacSHA512,
+}
+
+// validate returns an error if k isn't a valid key.  It also normalizes the
+// names.
+func (k *TSIGKey) validate() (err error) {
+	if k.Name == "" {
+		return errors.Error("empty key name")
+	}
+
+	k.Name = dns.CanonicalName(k.Name)
+
+	if k.Algorithm == "" {
+		k.Algorithm = dns.HmacSHA256
+	} else {
+		k.Algorithm = dns.CanonicalName(k.Algorithm)
+	}
+
+	valid := false
+	for _, alg := range algorithms {
+		valid = valid || k.Algorithm == alg
+	}
+
+	if !valid {
+		return fmt.Errorf("unsupported algorithm %q", k.Algorithm)
+	}
+
+	secret, err := base64.StdEncoding.DecodeString(k.Secret)
+	if err != nil {
+		return fmt.Errorf("decoding secret: %w", err)
+	} else if len(secret) == 0 {
+		return errors.Error("empty secret")
+	}
+
+	return nil
+}
+
+// Config is the configuration of the updater.
+type Config struct {
+	// Logger is used to log the updates.  It must not be nil.
+	Logger *slog.Logger
+
+	// TSIG is the key to sign the updates with.  If nil, the updates aren't
+	// signed.
+	TSIG *TSIGKey
+
+	// Server is the address of the primary authoritative server to send the
+	// updates to.  It must be valid.
+	Server netip.AddrPort
+
+	// Zone is the forward zone to publish the A and AAAA records in, e.g.
+	// "lan.example.com".  It must not be empty.
+	Zone string
+
+	// ReverseZones are the zones to publish the PTR records in, e.g.
+	// "1.168.192.in-addr.arpa".  The most specific zone containing the record
+	// is used.  If there is none, the PTR record isn't published.
+	ReverseZones []string
+
+	// TTL is the TTL of the published records.  If zero, [DefaultTTL] is used.
+	TTL time.Duration
+
+	// Timeout is the timeout of a single update.  If zero, [DefaultTimeout] is
+	// used.
+	Timeout time.Duration
+}
+
+// Updater publishes the forward and reverse records of the hosts.
+type Updater struct {
+	logger       *slog.Logger
+	client       *dns.Client
+	tsig         *TSIGKey
+	server       string
+	zone         string
+	reverseZones []string
+	ttl          uint32
+}
+
+// New returns a new properly initialized *Updater.
+func New(conf *Config) (u *Updater, err error) {
+	defer func() { err = errors.Annotate(err, "ddns: %w") }()
+
+	if !conf.Server.IsValid() {
+		return nil, errors.Error("invalid server address")
+	}
+
+	err = netutil.ValidateDomainName(strings.TrimSuffix(conf.Zone, "."))
+	if err != nil {
+		return nil, fmt.Errorf("zone: %w", err)
+	}
+
+	reverseZones := make([]string, 0, len(conf.ReverseZones))
+	for i, z := range conf.ReverseZones {
+		err = netutil.ValidateDomainName(strings.TrimSuffix(z, "."))
+		if err != nil {
+			return nil, fmt.Errorf("reverse zone at index %d: %w", i, err)
+		}
+
+		reverseZones = append(reverseZones, dns.CanonicalName(z))
+	}
+
+	timeout := conf.Timeout
+	if timeout == 0 {
+		timeout = DefaultTimeout
+	}
+
+	ttl := conf.TTL
+	if ttl == 0 {
+		ttl = DefaultTTL
+	}
+
+	client := &dns.Client{
+		Net:     "udp",
+		Timeout: timeout,
+	}
+
+	var tsig *TSIGKey
+	if conf.TSIG != nil {
+		tsig = &TSIGKey{}
+		*tsig = *conf.TSIG
+
+		err = tsig.validate()
+		if err != nil {
+			return nil, fmt.Errorf("tsig key: %w", err)
+		}
+
+		client.TsigSecret = map[string]string{tsig.Name: tsig.Secret}
+	}
+
+	return &Updater{
+		logger:       conf.Logger,
+		client:       client,
+		tsig:         tsig,
+		server:       conf.Server.String(),
+		zone:         dns.CanonicalName(conf.Zone),
+		reverseZones: reverseZones,
+		ttl:          uint32(ttl.Seconds()),
+	}, nil
+}
+
+// Identifier types of the DHCP clients used in the DHCID records.
+//
+// See https://datatracker.ietf.org/doc/html/rfc4701#section-3.3.
+const (
+	// IDTypeHWAddr means that the identifier is the hardware type followed by
+	// the hardware address of the client.
+	IDTypeHWAddr uint16 = 0x0000
+
+	// IDTypeClientID means that the identifier is the DHCPv4 client
+	// identifier option of the client.
+	IDTypeClientID uint16 = 0x0001
+
+	// IDTypeDUID means that the identifier is the DUID of the client.
+	IDTypeDUID uint16 = 0x0002
+)
+
+// digestTypeSHA256 is the SHA-256 digest type of the DHCID records.
+//
+// See https://datatracker.ietf.org/doc/html/rfc4701#section-3.4.
+const digestTypeSHA256 = 1
+
+// hwTypeEthernet is the hardware type of the Ethernet addresses.
+//
+// See https://www.iana.org/assignments/arp-parameters/arp-parameters.xhtml.
+const hwTypeEthernet = 1
+
+// ClientID is the identifier of the DHCP client owning the published name.  It
+// is used to compute the DHCID record, which guards the name against being
+// overwritten by another client.
+//
+// See https://datatracker.ietf.org/doc/html/rfc4703.
+type ClientID struct {
+	// Data is the identifier of the client, which must not be empty.  Its
+	// format depends on Type.
+	Data []byte
+
+	// Type is the type of the identifier, see [IDTypeHWAddr], [IDTypeClientID],
+	// and [IDTypeDUID].
+	Type uint16
+}
+
+// NewHWAddrID returns the identifier of the DHCP client with the Ethernet
+// address mac.
+func NewHWAddrID(mac net.HardwareAddr) (id ClientID) {
+	return ClientID{
+		Data: append([]byte{hwTypeEthernet}, mac...),
+		Type: IDTypeHWAddr,
+	}
+}
+
+// NewDUIDID returns the identifier of the DHCPv6 client with duid.
+func NewDUIDID(duid []byte) (id ClientID) {
+	return ClientID{
+		Data: slices.Clone(duid),
+		Type: IDTypeDUID,
+	}
+}
+
+// dhcid returns the DHCID record of name for the client with id.
+//
+// See https://datatracker.ietf.org/doc/html/rfc4701#section-3.
+func (u *Updater) dhcid(name string, id ClientID) (rr *dns.DHCID, err error) {
+	if len(id.Data) == 0 {
+		return nil, errors.Error("empty client identifier")
+	}
+
+	wire := make([]byte, 256)
+	n, err := dns.PackDomainName(strings.ToLower(name), wire, 0, nil, false)
+	if err != nil {
+		return nil, fmt.Errorf("packing name: %w", err)
+	}
+
+	h := sha256.New()
+	_, _ = h.Write(id.Data)
+	_, _ = h.Write(wire[:n])
+
+	rdata := binary.BigEndian.AppendUint16(nil, id.Type)
+	rdata = append(rdata, digestTypeSHA256)
+	rdata = h.Sum(rdata)
+
+	return &dns.DHCID{
+		Hdr:    u.header(name, dns.TypeDHCID),
+		Digest: base64.StdEncoding.EncodeToString(rdata),
+	}, nil
+}
+
+// Publish replaces the address records of host and the PTR record of ip with
+// the ones pointing to each other.  host is a single label, which is published
+// within the forward zone.  The address records are only added if the name is
+// not in use or is owned by the client with id, so that the names of the other
+// clients and the manually added records are never replaced.
+//
+// See https://datatracker.ietf.org/doc/html/rfc4703#section-5.3.
+func (u *Updater) Publish(ctx context.Context, host string, ip netip.Addr, id ClientID) (err error) {
+	defer func() { err = errors.Annotate(err, "publishing %q: %w", host) }()
+
+	name, addrRR, err := u.addrRecord(host, ip)
+	if err != nil {
+		// Don't wrap the error, because it's informative enough as is.
+		return err
+	}
+
+	dhcidRR, err := u.dhcid(name, id)
+	if err != nil {
+		// Don't wrap the error, because it's informative enough as is.
+		return err
+	}
+
+	err = u.publishAddr(ctx, addrRR, dhcidRR)
+	if err != nil {
+		return fmt.Errorf("forward zone: %w", err)
+	}
+
+	ptrName, zone := u.reverse(ip)
+	if zone == "" {
+		return nil
+	}
+
+	ptr := &dns.PTR{
+		Hdr: u.header(ptrName, dns.TypePTR),
+		Ptr: name,
+	}
+
+	rev := (&dns.Msg{}).SetUpdate(zone)
+	rev.RemoveRRset([]dns.RR{ptr})
+	rev.Insert([]dns.RR{ptr})
+
+	err = u.exchange(ctx, rev)
+	if err != nil {
+		return fmt.Errorf("reverse zone %q: %w", zone, err)
+	}
+
+	return nil
+}
+
+// ErrNameConflict is returned by [Updater.Publish] when the name is already in
+// use by another client or isn't managed by the updater.
+const ErrNameConflict = errors.Error("name is in use by another client")
+
+// publishAddr adds addrRR along with dhcidRR if the name isn't in use yet, or
+// replaces the address records of the same type if the name is owned by the
+// same client.
+func (u *Updater) publishAddr(ctx context.Context, addrRR dns.RR, dhcidRR *dns.DHCID) (err error) {
+	add := (&dns.Msg{}).SetUpdate(u.zone)
+	add.NameNotUsed([]dns.RR{addrRR})
+	add.Insert([]dns.RR{addrRR, dhcidRR})
+
+	rcode, err := u.send(ctx, add)
+	if err != nil {
+		// Don't wrap the error, because it's informative enough as is.
+		return err
+	} else if rcode != dns.RcodeYXDomain {
+		return rcodeError(rcode)
+	}
+
+	replace := (&dns.Msg{}).SetUpdate(u.zone)
+	// Use a copy, since Used resets the TTL of the record.
+	replace.Used([]dns.RR{dns.Copy(dhcidRR)})
+	replace.RemoveRRset([]dns.RR{addrRR})
+	replace.Insert([]dns.RR{addrRR})
+
+	rcode, err = u.send(ctx, replace)
+	if err != nil {
+		// Don't wrap the error, because it's informative enough as is.
+		return err
+	} else if rcode == dns.RcodeNXRrset {
+		return ErrNameConflict
+	}
+
+	return rcodeError(rcode)
+}
+
+// Withdraw removes the address record of host pointing to ip and the PTR
+// record of ip pointing to host, if there are any.  The address record is only
+// removed if the name is owned by the client with id, and the DHCID record is
+// removed along with the last address record.
+//
+// See https://datatracker.ietf.org/doc/html/rfc4703#section-5.5.
+func (u *Updater) Withdraw(ctx context.Context, host string, ip netip.Addr, id ClientID) (err error) {
+	defer func() { err = errors.Annotate(err, "withdrawing %q: %w", host) }()
+
+	name, addrRR, err := u.addrRecord(host, ip)
+	if err != nil {
+		// Don't wrap the error, because it's informative enough as is.
+		return err
+	}
+
+	dhcidRR, err := u.dhcid(name, id)
+	if err != nil {
+		// Don't wrap the error, because it's informative enough as is.
+		return err
+	}
+
+	err = u.withdrawAddr(ctx, addrRR, dhcidRR)
+	if err != nil {
+		return fmt.Errorf("forward zone: %w", err)
+	}
+
+	ptrName, zone := u.reverse(ip)
+	if zone == "" {
+		return nil
+	}
+
+	rev := (&dns.Msg{}).SetUpdate(zone)
+	rev.Remove([]dns.RR{&dns.PTR{
+		Hdr: u.header(ptrName, dns.TypePTR),
+		Ptr: name,
+	}})
+
+	err = u.exchange(ctx, rev)
+	if err != nil {
+		return fmt.Errorf("reverse zone %q: %w", zone, err)
+	}
+
+	return nil
+}
+
+// withdrawAddr removes addrRR if the name is owned by the client with dhcidRR,
+// and then removes dhcidRR itself if there are no address records left.  The
+// records of the other owners are kept silently.
+func (u *Updater) withdrawAddr(ctx context.Context, addrRR dns.RR, dhcidRR *dns.DHCID) (err error) {
+	rm := (&dns.Msg{}).SetUpdate(u.zone)
+	rm.Used([]dns.RR{dns.Copy(dhcidRR)})
+	rm.Remove([]dns.RR{addrRR})
+
+	rcode, err := u.send(ctx, rm)
+	if err != nil {
+		// Don't wrap the error, because it's informative enough as is.
+		return err
+	} else if rcode == dns.RcodeNXRrset {
+		u.logger.DebugContext(ctx, "not owned, keeping", "name", addrRR.Header().Name)
+
+		return nil
+	} else if rcode != dns.RcodeSuccess {
+		return rcodeError(rcode)
+	}
+
+	name := addrRR.Header().Name
+	cleanup := (&dns.Msg{}).SetUpdate(u.zone)
+	cleanup.Used([]dns.RR{dns.Copy(dhcidRR)})
+	cleanup.RRsetNotUsed([]dns.RR{
+		&dns.ANY{Hdr: dns.RR_Header{Name: name, Rrtype: dns.TypeA}},
+		&dns.ANY{Hdr: dns.RR_Header{Name: name, Rrtype: dns.TypeAAAA}},
+	})
+	cleanup.RemoveRRset([]dns.RR{dhcidRR})
+
+	rcode, err = u.send(ctx, cleanup)
+	if err != nil {
+		// Don't wrap the error, because it's informative enough as is.
+		return err
+	} else if rcode == dns.RcodeNXRrset || rcode == dns.RcodeYXRrset {
+		// The name still has the other address records or is owned by
+		// someone else now.
+		return nil
+	}
+
+	return rcodeError(rcode)
+}
+
+// AddTXT adds the TXT record with value to name, e.g. for the ACME DNS-01
+// challenge.  name must be within the forward zone.  The other TXT records of
+// name are kept, since there may be several challenges for it at once.
+func (u *Updater) AddTXT(ctx context.Context, name, value string) (err error) {
+	defer func() { err = errors.Annotate(err, "adding txt to %q: %w", name) }()
+
+	rr, err := u.txtRecord(name, value)
+	if err != nil {
+		// Don't wrap the error, because it's informative enough as is.
+		return err
+	}
+
+	msg := (&dns.Msg{}).SetUpdate(u.zone)
+	msg.Insert([]dns.RR{rr})
+
+	return u.exchange(ctx, msg)
+}
+
+// RemoveTXT removes the TXT record with value from name, if there is one.
+// name must be within the forward zone.
+func (u *Updater) RemoveTXT(ctx context.Context, name, value string) (err error) {
+	defer func() { err = errors.Annotate(err, "removing txt from %q: %w", name) }()
+
+	rr, err := u.txtRecord(name, value)
+	if err != nil {
+		// Don't wrap the error, because it's informative enough as is.
+		return err
+	}
+
+	msg := (&dns.Msg{}).SetUpdate(u.zone)
+	msg.Remove([]dns.RR{rr})
+
+	return u.exchange(ctx, msg)
+}
+
+// txtRecord returns the TXT record of name with value.  name must be within
+// the forward zone.
+func (u *Updater) txtRecord(name, value string) (rr dns.RR, err error) {
+	name = dns.CanonicalName(name)
+	if !dns.IsSubDomain(u.zone, name) {
+		return nil, fmt.Errorf("not within zone %q", u.zone)
+	}
+
+	return &dns.TXT{Hdr: u.header(name, dns.TypeTXT), Txt: []string{value}}, nil
+}
+
+// addrRecord returns the fully-qualified name of host within the forward zone
+// and its A or AAAA record for ip.
+func (u *Updater) addrRecord(host string, ip netip.Addr) (name string, rr dns.RR, err error) {
+	err = netutil.ValidateHostnameLabel(host)
+	if err != nil {
+		// Don't wrap the error, because it's informative enough as is.
+		return "", nil, err
+	}
+
+	name = dns.CanonicalName(host + "." + u.zone)
+	if ip.Is4() {
+		return name, &dns.A{Hdr: u.header(name, dns.TypeA), A: ip.AsSlice()}, nil
+	} else if ip.Is6() {
+		return name, &dns.AAAA{Hdr: u.header(name, dns.TypeAAAA), AAAA: ip.AsSlice()}, nil
+	}
+
+	return "", nil, fmt.Errorf("invalid ip %v", ip)
+}
+
+// reverse returns the PTR name of ip and the most specific reverse zone
+// containing it.  zone is empty if there is no such zone.
+func (u *Updater) reverse(ip netip.Addr) (ptrName, zone string) {
+	ptrName, err := netutil.IPToReversedAddr(ip.AsSlice())
+	if err != nil {
+		return "", ""
+	}
+
+	ptrName = dns.Fqdn(ptrName)
+	for _, z := range u.reverseZones {
+		if dns.IsSubDomain(z, ptrName) && len(z) > len(zone) {
+			zone = z
+		}
+	}
+
+	return ptrName, zone
+}
+
+// header returns the header of the record with name and type typ added by the
+// updater.
+func (u *Updater) header(name string, typ uint16) (hdr dns.RR_Header) {
+	return dns.RR_Header{
+		Name:   name,
+		Rrtype: typ,
+		Class:  dns.ClassINET,
+		Ttl:    u.ttl,
+	}
+}
+
+// exchange sends the update msg and checks the response.
+func (u *Updater) exchange(ctx context.Context, msg *dns.Msg) (err error) {
+	rcode, err := u.send(ctx, msg)
+	if err != nil {
+		// Don't wrap the error, because it's informative enough as is.
+		return err
+	}
+
+	return rcodeError(rcode)
+}
+
+// rcodeError returns an error describing rcode, unless it's a success.
+func rcodeError(rcode int) (err error) {
+	if rcode == dns.RcodeSuccess {
+		return nil
+	}
+
+	return fmt.Errorf("server responded with %s", dns.RcodeToString[rcode])
+}
+
+// send signs the update msg, if needed, sends it to the server, and returns
+// the response code.
+func (u *Updater) send(ctx context.Context, msg *dns.Msg) (rcode int, err error) {
+	if u.tsig != nil {
+		msg.SetTsig(u.tsig.Name, u.tsig.Algorithm, tsigFudge, time.Now().Unix())
+	}
+
+	resp, _, err := u.client.ExchangeContext(ctx, msg, u.server)
+	if err != nil {
+		// Don't wrap the error, because it's informative enough as is.
+		return 0, err
+	}
+
+	if resp.Rcode == dns.RcodeSuccess {
+		u.logger.DebugContext(ctx, "updated", "zone", msg.Question[0].Name, "records", len(msg.Ns))
+	}
+
+	return resp.Rcode, nil
+}
@@ -0,0 +1,285 @@
+package ddns_test
+
+import (
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/ddns"
+	"github.com/AdguardTeam/golibs/logutil/slogutil"
+	"github.com/AdguardTeam/golibs/testutil"
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testTimeout is the common timeout for tests.
+const testTimeout = 1 * time.Second
+
+// Common key parameters for tests.
+const (
+	testKeyName   = "agh-key."
+	testKeySecret = "c2VjcmV0LXNlY3JldC1zZWNyZXQ="
+)
+
+// startServer starts the DNS server accepting the updates signed with the test
+// key and returns its address, the channel receiving the accepted updates, and
+// the channel of the response codes for the next updates.  The updates are
+// responded with success if the latter is empty.
+func startServer(t *testing.T) (addr netip.AddrPort, updates chan *dns.Msg, rcodes chan int) {
+	t.Helper()
+
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	updates = make(chan *dns.Msg, 4)
+	rcodes = make(chan int, 4)
+	srv := &dns.Server{
+		PacketConn: pc,
+		TsigSecret: map[string]string{testKeyName: testKeySecret},
+		// Accept the updates, which are rejected by default.
+		MsgAcceptFunc: func(_ dns.Header) (act dns.MsgAcceptAction) { return dns.MsgAccept },
+		Handler: dns.HandlerFunc(func(w dns.ResponseWriter, req *dns.Msg) {
+			resp := (&dns.Msg{}).SetReply(req)
+			if req.IsTsig() == nil || w.TsigStatus() != nil {
+				resp.Rcode = dns.RcodeNotAuth
+			} else {
+				resp.SetTsig(testKeyName, dns.HmacSHA256, 300, time.Now().Unix())
+				updates <- req
+
+				select {
+				case resp.Rcode = <-rcodes:
+				default:
+				}
+			}
+
+			_ = w.WriteMsg(resp)
+		}),
+	}
+
+	started := make(chan struct{})
+	srv.NotifyStartedFunc = func() { close(started) }
+
+	go func() { _ = srv.ActivateAndServe() }()
+	testutil.RequireReceive(t, started, testTimeout)
+	testutil.CleanupAndRequireSuccess(t, srv.Shutdown)
+
+	return netip.MustParseAddrPort(pc.LocalAddr().String()), updates, rcodes
+}
+
+func TestUpdater(t *testing.T) {
+	addr, updates, rcodes := startServer(t)
+
+	u, err := ddns.New(&ddns.Config{
+		Logger: slogutil.NewDiscardLogger(),
+		TSIG: &ddns.TSIGKey{
+			Name:   "AGH-key",
+			Secret: testKeySecret,
+		},
+		Server:       addr,
+		Zone:         "lan.example",
+		ReverseZones: []string{"168.192.in-addr.arpa", "1.168.192.in-addr.arpa"},
+		TTL:          time.Minute,
+		Timeout:      testTimeout,
+	})
+	require.NoError(t, err)
+
+	ip := netip.MustParseAddr("192.168.1.2")
+
+	id := ddns.NewHWAddrID(net.HardwareAddr{0x01, 0x02, 0x03, 0x04, 0x05, 0x06})
+	v6IP := netip.MustParseAddr("2001:db8::2")
+
+	t.Run("publish", func(t *testing.T) {
+		ctx := testutil.ContextWithTimeout(t, testTimeout)
+		err = u.Publish(ctx, "host", ip, id)
+		require.NoError(t, err)
+
+		fwd, _ := testutil.RequireReceive(t, updates, testTimeout)
+		assert.Equal(t, "lan.example.", fwd.Question[0].Name)
+		require.Len(t, fwd.Answer, 1)
+		require.Len(t, fwd.Ns, 2)
+
+		assert.Equal(t, "host.lan.example.\t0\tNONE\tANY\t", fwd.Answer[0].String())
+		assert.Equal(t, "host.lan.example.\t60\tIN\tA\t192.168.1.2", fwd.Ns[0].String())
+		assert.Equal(t, dns.TypeDHCID, fwd.Ns[1].Header().Rrtype)
+
+		rev, _ := testutil.RequireReceive(t, updates, testTimeout)
+		assert.Equal(t, "1.168.192.in-addr.arpa.", rev.Question[0].Name)
+		require.Len(t, rev.Ns, 2)
+
+		assert.Equal(t, "2.1.168.192.in-addr.arpa.\t60\tIN\tPTR\thost.lan.example.", rev.Ns[1].String())
+	})
+
+	t.Run("publish_owned", func(t *testing.T) {
+		rcodes <- dns.RcodeYXDomain
+
+		ctx := testutil.ContextWithTimeout(t, testTimeout)
+		err = u.Publish(ctx, "host", ip, id)
+		require.NoError(t, err)
+
+		_, _ = testutil.RequireReceive(t, updates, testTimeout)
+		replace, _ := testutil.RequireReceive(t, updates, testTimeout)
+		require.Len(t, replace.Answer, 1)
+		require.Len(t, replace.Ns, 2)
+
+		assert.Equal(t, dns.TypeDHCID, replace.Answer[0].Header().Rrtype)
+		assert.Equal(t, uint16(dns.ClassANY), replace.Ns[0].Header().Class)
+
+		_, _ = testutil.RequireReceive(t, updates, testTimeout)
+	})
+
+	t.Run("publish_conflict", func(t *testing.T) {
+		rcodes <- dns.RcodeYXDomain
+		rcodes <- dns.RcodeNXRrset
+
+		ctx := testutil.ContextWithTimeout(t, testTimeout)
+		err = u.Publish(ctx, "host", ip, id)
+		assert.ErrorIs(t, err, ddns.ErrNameConflict)
+
+		// Neither the address nor the PTR records are replaced.
+		_, _ = testutil.RequireReceive(t, updates, testTimeout)
+		_, _ = testutil.RequireReceive(t, updates, testTimeout)
+		assert.Empty(t, updates)
+	})
+
+	t.Run("withdraw", func(t *testing.T) {
+		ctx := testutil.ContextWithTimeout(t, testTimeout)
+		err = u.Withdraw(ctx, "host", v6IP, id)
+		require.NoError(t, err)
+
+		// There is no reverse zone for the address, so only the forward one is
+		// updated.
+		rm, _ := testutil.RequireReceive(t, updates, testTimeout)
+		require.Len(t, rm.Answer, 1)
+		require.Len(t, rm.Ns, 1)
+
+		assert.Equal(t, dns.TypeDHCID, rm.Answer[0].Header().Rrtype)
+		assert.Equal(t, "host.lan.example.\t0\tNONE\tAAAA\t2001:db8::2", rm.Ns[0].String())
+
+		cleanup, _ := testutil.RequireReceive(t, updates, testTimeout)
+		require.Len(t, cleanup.Answer, 3)
+		require.Len(t, cleanup.Ns, 1)
+
+		assert.Equal(t, dns.TypeDHCID, cleanup.Ns[0].Header().Rrtype)
+		assert.Equal(t, uint16(dns.ClassANY), cleanup.Ns[0].Header().Class)
+	})
+
+	t.Run("withdraw_not_owned", func(t *testing.T) {
+		rcodes <- dns.RcodeNXRrset
+
+		ctx := testutil.ContextWithTimeout(t, testTimeout)
+		err = u.Withdraw(ctx, "host", v6IP, id)
+		require.NoError(t, err)
+
+		_, _ = testutil.RequireReceive(t, updates, testTimeout)
+		assert.Empty(t, updates)
+	})
+
+	t.Run("txt", func(t *testing.T) {
+		const name = "_acme-challenge.host.lan.example"
+
+		ctx := testutil.ContextWithTimeout(t, testTimeout)
+		err = u.AddTXT(ctx, name, "token")
+		require.NoError(t, err)
+
+		add, _ := testutil.RequireReceive(t, updates, testTimeout)
+		require.Len(t, add.Ns, 1)
+
+		assert.Equal(t, "_acme-challenge.host.lan.example.\t60\tIN\tTXT\t\"token\"", add.Ns[0].String())
+
+		err = u.RemoveTXT(ctx, name, "token")
+		require.NoError(t, err)
+
+		rm, _ := testutil.RequireReceive(t, updates, testTimeout)
+		require.Len(t, rm.Ns, 1)
+
+		assert.Equal(t, "_acme-challenge.host.lan.example.\t0\tNONE\tTXT\t\"token\"", rm.Ns[0].String())
+
+		err = u.AddTXT(ctx, "_acme-challenge.other.example", "token")
+		assert.Error(t, err)
+	})
+
+	t.Run("bad_key", func(t *testing.T) {
+		var bad *ddns.Updater
+		bad, err = ddns.New(&ddns.Config{
+			Logger: slogutil.NewDiscardLogger(),
+			TSIG: &ddns.TSIGKey{
+				Name:   testKeyName,
+				Secret: "b3RoZXItc2VjcmV0",
+			},
+			Server:  addr,
+			Zone:    "lan.example",
+			Timeout: testTimeout,
+		})
+		require.NoError(t, err)
+
+		ctx := testutil.ContextWithTimeout(t, testTimeout)
+		err = bad.Publish(ctx, "host", ip, id)
+		assert.Error(t, err)
+	})
+}
+
+func TestUpdater_Publish_dhcid(t *testing.T) {
+	addr, updates, _ := startServer(t)
+
+	u, err := ddns.New(&ddns.Config{
+		Logger: slogutil.NewDiscardLogger(),
+		TSIG: &ddns.TSIGKey{
+			Name:   testKeyName,
+			Secret: testKeySecret,
+		},
+		Server:  addr,
+		Zone:    "example.com",
+		Timeout: testTimeout,
+	})
+	require.NoError(t, err)
+
+	// The test vectors are from RFC 4701.
+	//
+	// See https://datatracker.ietf.org/doc/html/rfc4701#section-3.6.
+	testCases := []struct {
+		id     ddns.ClientID
+		ip     netip.Addr
+		name   string
+		host   string
+		digest string
+	}{{
+		id: ddns.NewDUIDID([]byte{
+			0x00, 0x01, 0x00, 0x06, 0x41, 0x2d, 0xf1, 0x66, 0x01, 0x02, 0x03, 0x04, 0x05, 0x06,
+		}),
+		ip:     netip.MustParseAddr("2001:db8::1234:5678"),
+		name:   "duid",
+		host:   "chi6",
+		digest: "AAIBY2/AuCccgoJbsaxcQc9TUapptP69lOjxfNuVAA2kjEA=",
+	}, {
+		id:     ddns.NewHWAddrID(net.HardwareAddr{0x01, 0x02, 0x03, 0x04, 0x05, 0x06}),
+		ip:     netip.MustParseAddr("192.0.2.2"),
+		name:   "hwaddr",
+		host:   "client",
+		digest: "AAABxLmlskllE0MVjd57zHcWmEH3pCQ6VytcKD//7es/deY=",
+	}, {
+		id: ddns.ClientID{
+			Data: []byte{0x01, 0x07, 0x08, 0x09, 0x0a, 0x0b, 0x0c},
+			Type: ddns.IDTypeClientID,
+		},
+		ip:     netip.MustParseAddr("192.0.2.3"),
+		name:   "client_id",
+		host:   "chi",
+		digest: "AAEBOSD+XR3Os/0LozeXVqcNc7FwCfQdWL3b/NaiUDlW2No=",
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := testutil.ContextWithTimeout(t, testTimeout)
+			err = u.Publish(ctx, tc.host, tc.ip, tc.id)
+			require.NoError(t, err)
+
+			fwd, _ := testutil.RequireReceive(t, updates, testTimeout)
+			require.Len(t, fwd.Ns, 2)
+
+			dhcid := testutil.RequireTypeAssert[*dns.DHCID](t, fwd.Ns[1])
+			assert.Equal(t, tc.digest, dhcid.Digest)
+		})
+	}
+}
@@ -0,0 +1,172 @@
+//go:build darwin || freebsd || linux || openbsd
+
+package dhcpd
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"path"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/google/gopacket/layers"
+	"github.com/insomniacslk/dhcp/dhcpv4"
+)
+
+// v4Class is a prepared client class of the DHCPv4 server.
+type v4Class struct {
+	// conf is the configuration of the class.
+	conf *ClientClassConfig
+
+	// hwAddrPrefix is the parsed prefix of the hardware address.  It's empty
+	// if the class doesn't match the clients by it.
+	hwAddrPrefix net.HardwareAddr
+
+	// opts are the parsed options of the class.
+	opts layers.DHCPOptions
+}
+
+// newV4Classes validates conf and prepares the client classes from it.
+func newV4Classes(conf []*ClientClassConfig) (classes []*v4Class, err error) {
+	for i, cc := range conf {
+		var c *v4Class
+		c, err = newV4Class(cc)
+		if err != nil {
+			return nil, fmt.Errorf("class at index %d: %w", i, err)
+		}
+
+		classes = append(classes, c)
+	}
+
+	return classes, nil
+}
+
+// newV4Class validates cc and prepares the client class from it.
+func newV4Class(cc *ClientClassConfig) (c *v4Class, err error) {
+	switch {
+	case cc == nil:
+		return nil, errNilConfig
+	case cc.Name == "":
+		return nil, errors.Error("empty class name")
+	case cc.MACPrefix == "" && cc.VendorClass == "" && cc.UserClass == "" && cc.Hostname == "":
+		return nil, fmt.Errorf("class %q: no criteria", cc.Name)
+	}
+
+	defer func() { err = errors.Annotate(err, "class %q: %w", cc.Name) }()
+
+	c = &v4Class{
+		conf: cc,
+	}
+
+	if cc.MACPrefix != "" {
+		c.hwAddrPrefix, err = parseMACPrefix(cc.MACPrefix)
+		if err != nil {
+			return nil, fmt.Errorf("mac prefix: %w", err)
+		}
+	}
+
+	if _, err = path.Match(cc.Hostname, ""); err != nil {
+		return nil, fmt.Errorf("hostname pattern: %w", err)
+	}
+
+	if err = cc.Boot.validate(); err != nil {
+		return nil, fmt.Errorf("boot: %w", err)
+	}
+
+	c.opts, err = parseHostOptions(cc.Options)
+	if err != nil {
+		return nil, fmt.Errorf("options: %w", err)
+	}
+
+	return c, nil
+}
+
+// matches returns true if req matches all the criteria of c.
+func (c *v4Class) matches(req *dhcpv4.DHCPv4) (ok bool) {
+	switch {
+	case !bytes.HasPrefix(req.ClientHWAddr, c.hwAddrPrefix):
+		return false
+	case !strings.HasPrefix(req.ClassIdentifier(), c.conf.VendorClass):
+		return false
+	case c.conf.UserClass != "" && !slices.Contains(userClasses(req), c.conf.UserClass):
+		return false
+	case c.conf.Hostname == "":
+		return true
+	}
+
+	ok, _ = path.Match(strings.ToLower(c.conf.Hostname), strings.ToLower(req.HostName()))
+
+	return ok
+}
+
+// userClasses returns the user classes from the user class option of req.
+// Since some clients send a single class without the length prefix, the whole
+// option data is also considered a class.
+func userClasses(req *dhcpv4.DHCPv4) (classes []string) {
+	data := req.Options.Get(dhcpv4.OptionUserClassInformation)
+	if len(data) == 0 {
+		return nil
+	}
+
+	return append([]string{string(data)}, req.UserClass()...)
+}
+
+// classOptions returns the options of the classes matching req in the
+// configured order.
+func (s *v4Server) classOptions(req *dhcpv4.DHCPv4) (opts layers.DHCPOptions) {
+	for _, c := range s.classes {
+		if c.matches(req) {
+			opts = append(opts, c.opts...)
+		}
+	}
+
+	return opts
+}
+
+// hostOptions returns the options specific to the client sending req: the
+// options of the matching classes followed by the options of its static lease,
+// if any.  The later options take precedence.
+func (s *v4Server) hostOptions(req *dhcpv4.DHCPv4) (opts layers.DHCPOptions) {
+	opts = s.classOptions(req)
+
+	s.leasesLock.Lock()
+	defer s.leasesLock.Unlock()
+
+	l := s.findLease(req.ClientHWAddr)
+	if l != nil && l.IsStatic {
+		opts = append(opts, l.Options...)
+	}
+
+	return opts
+}
+
+// leaseDuration returns the duration of the lease for the client with the host
+// options.
+func (s *v4Server) leaseDuration(host layers.DHCPOptions) (d time.Duration) {
+	d = s.conf.leaseTime
+	for _, o := range host {
+		if o.Type == layers.DHCPOptLeaseTime && len(o.Data) == 4 {
+			d = time.Duration(binary.BigEndian.Uint32(o.Data)) * time.Second
+		}
+	}
+
+	return d
+}
+
+// bootConfig returns the network boot configuration for the client sending
+// req.  The fields set in the boot configurations of the matching classes
+// replace the ones of the server.
+func (s *v4Server) bootConfig(req *dhcpv4.DHCPv4) (boot *BootConfig) {
+	boot = s.conf.Boot
+	for _, c := range s.classes {
+		if c.matches(req) {
+			boot = boot.merge(c.conf.Boot)
+		}
+	}
+
+	return boot
+}
@@ -2,12 +2,15 @@ package dhcpd
 
 import (
 	"fmt"
+	"log/slog"
 	"net"
 	"net/netip"
+	"path/filepath"
 	"time"
 
 	"github.com/AdguardTeam/AdGuardHome/internal/aghhttp"
 	"github.com/AdguardTeam/AdGuardHome/internal/aghnet"
+	"github.com/AdguardTeam/AdGuardHome/internal/arpdb"
 	"github.com/AdguardTeam/AdGuardHome/internal/dhcpsvc"
 	"github.com/AdguardTeam/golibs/errors"
 )
@@ -35,6 +38,25 @@ type ServerConfig struct {
 	Conf4 V4ServerConf `yaml:"dhcpv4"`
 	Conf6 V6ServerConf `yaml:"dhcpv6"`
 
+	// Scopes are the additional DHCPv4 scopes served on other network
+	// interfaces, such as VLAN sub-interfaces, when the server is enabled.
+	Scopes []*ScopeConfig `yaml:"scopes"`
+
+	// TFTP is the configuration of the built-in TFTP server.
+	TFTP TFTPConfig `yaml:"tftp"`
+
+	// Hooks is the configuration of the hooks run on the lease events.  If
+	// nil, no hooks are run.
+	Hooks *HooksConfig `yaml:"hooks,omitempty"`
+
+	// Logger is used to log the events of the additional scopes.  If nil, the
+	// default logger is used.
+	Logger *slog.Logger `yaml:"-"`
+
+	// ARPDB is used to find the hardware addresses of the devices using the
+	// conflicting addresses.  If nil, those aren't looked up.
+	ARPDB arpdb.Interface `yaml:"-"`
+
 	// WorkDir is used to store DHCP leases.
 	//
 	// Deprecated:  Remove it when migration of DHCP leases will not be needed.
@@ -47,6 +69,251 @@ type ServerConfig struct {
 	dbFilePath string `yaml:"-"`
 }
 
+// ScopeConfig is the configuration of an additional DHCPv4 scope served on a
+// separate network interface.
+type ScopeConfig struct {
+	// InterfaceName is the name of the network interface to serve the scope
+	// on.  It must be unique among the scopes and differ from the interface of
+	// the main configuration.  For a relayed scope, it only names the scope.
+	InterfaceName string `yaml:"interface_name" json:"interface_name"`
+
+	GatewayIP  netip.Addr `yaml:"gateway_ip" json:"gateway_ip"`
+	SubnetMask netip.Addr `yaml:"subnet_mask" json:"subnet_mask"`
+	RangeStart netip.Addr `yaml:"range_start" json:"range_start"`
+	RangeEnd   netip.Addr `yaml:"range_end" json:"range_end"`
+
+	// Options are the custom DHCPv4 options in the same format as
+	// [V4ServerConf.Options].
+	Options []string `yaml:"options" json:"options"`
+
+	// ServerIP is the address of AdGuard Home reachable by the relay agents.
+	// It must be set for a relayed scope.
+	ServerIP netip.Addr `yaml:"server_ip,omitempty" json:"server_ip,omitempty"`
+
+	// LeaseDuration is the lease duration in seconds.  If zero,
+	// [DefaultDHCPLeaseTTL] is used.
+	LeaseDuration uint32 `yaml:"lease_duration" json:"lease_duration"`
+
+	// Relayed is true if the clients of the scope are in a remote subnet and
+	// only reachable through DHCP relay agents, which forward the requests to
+	// AdGuard Home.  The scope of a relayed request is chosen by the address of
+	// the relay agent.
+	Relayed bool `yaml:"relayed" json:"relayed"`
+
+	// Classes are the client classes of the scope.  The options of all the
+	// classes matching a client are applied in the given order.
+	Classes []*ClientClassConfig `yaml:"classes,omitempty" json:"classes,omitempty"`
+
+	// Boot is the network boot configuration of the scope.  It may be nil.
+	Boot *BootConfig `yaml:"boot,omitempty" json:"boot,omitempty"`
+}
+
+// ClientClassConfig is the configuration of a class of DHCPv4 clients of a
+// scope with its own options.  A client matches the class if it matches all of
+// the non-empty criteria.
+type ClientClassConfig struct {
+	// Name is the name of the class.  It must not be empty.
+	Name string `yaml:"name" json:"name"`
+
+	// MACPrefix is the prefix of the hardware address, such as the OUI of the
+	// manufacturer, e.g. "00:1a:2b".
+	MACPrefix string `yaml:"mac_prefix,omitempty" json:"mac_prefix,omitempty"`
+
+	// VendorClass is the prefix of the vendor class identifier, option 60.
+	VendorClass string `yaml:"vendor_class,omitempty" json:"vendor_class,omitempty"`
+
+	// UserClass is the user class, option 77.
+	UserClass string `yaml:"user_class,omitempty" json:"user_class,omitempty"`
+
+	// Hostname is the case-insensitive glob pattern of the client hostname.
+	Hostname string `yaml:"hostname,omitempty" json:"hostname,omitempty"`
+
+	// Options are the DHCPv4 options for the matching clients in the same
+	// format as [V4ServerConf.Options].  They take precedence over the options
+	// of the scope.
+	Options []string `yaml:"options" json:"options"`
+
+	// Boot is the network boot configuration for the matching clients.  The
+	// fields set in it replace the ones of the scope.  It may be nil.
+	Boot *BootConfig `yaml:"boot,omitempty" json:"boot,omitempty"`
+}
+
+// BootConfig is the network boot configuration.  The architecture-specific
+// file names are chosen by the client system architecture option, option 93,
+// and take precedence over Filename.
+type BootConfig struct {
+	// NextServer is the address of the TFTP server to load the boot file
+	// from.  If not set, the address of the DHCP server is used.
+	NextServer netip.Addr `yaml:"next_server,omitempty" json:"next_server,omitempty"`
+
+	// Filename is the default boot file name.
+	Filename string `yaml:"filename,omitempty" json:"filename,omitempty"`
+
+	// FilenameBIOS is the boot file name for the legacy BIOS clients.
+	FilenameBIOS string `yaml:"filename_bios,omitempty" json:"filename_bios,omitempty"`
+
+	// FilenameUEFIx64 is the boot file name for the x86-64 UEFI clients.
+	FilenameUEFIx64 string `yaml:"filename_uefi_x64,omitempty" json:"filename_uefi_x64,omitempty"`
+
+	// FilenameARM64 is the boot file name for the ARM64 UEFI clients.
+	FilenameARM64 string `yaml:"filename_arm64,omitempty" json:"filename_arm64,omitempty"`
+}
+
+// maxBootFilenameLen is the maximum length of the boot file name, which must
+// fit into the file field of the DHCPv4 message.
+const maxBootFilenameLen = 128
+
+// validate returns an error if c is not a valid configuration.  c may be nil.
+func (c *BootConfig) validate() (err error) {
+	if c == nil {
+		return nil
+	}
+
+	if c.NextServer.IsValid() && !c.NextServer.Is4() {
+		return fmt.Errorf("next server %v is not an IPv4 address", c.NextServer)
+	}
+
+	for _, name := range []string{c.Filename, c.FilenameBIOS, c.FilenameUEFIx64, c.FilenameARM64} {
+		if len(name) > maxBootFilenameLen {
+			return fmt.Errorf("filename %q is longer than %d bytes", name, maxBootFilenameLen)
+		}
+	}
+
+	return nil
+}
+
+// archFilenames returns the architecture-specific boot file names of c.
+func (c *BootConfig) archFilenames() (names map[dhcpsvc.ClientArch]string) {
+	names = map[dhcpsvc.ClientArch]string{}
+	if c.FilenameBIOS != "" {
+		names[dhcpsvc.ArchBIOS] = c.FilenameBIOS
+	}
+
+	if c.FilenameUEFIx64 != "" {
+		// Many x86-64 clients send the EFI BC type due to the mistake in RFC
+		// 4578.
+		names[dhcpsvc.ArchUEFIx64] = c.FilenameUEFIx64
+		names[dhcpsvc.ArchUEFIBC] = c.FilenameUEFIx64
+	}
+
+	if c.FilenameARM64 != "" {
+		names[dhcpsvc.ArchUEFIARM64] = c.FilenameARM64
+	}
+
+	return names
+}
+
+// toInternal converts c into the DHCP service boot configuration.  c may be
+// nil.
+func (c *BootConfig) toInternal() (boot *dhcpsvc.BootConfig) {
+	if c == nil {
+		return nil
+	}
+
+	return &dhcpsvc.BootConfig{
+		ArchFilenames: c.archFilenames(),
+		NextServer:    c.NextServer,
+		Filename:      c.Filename,
+	}
+}
+
+// merge returns the boot configuration of c with the fields set in other
+// replacing the ones of c.  Any of c and other may be nil.
+func (c *BootConfig) merge(other *BootConfig) (merged *BootConfig) {
+	switch {
+	case other == nil:
+		return c
+	case c == nil:
+		return other
+	}
+
+	merged = &BootConfig{}
+	*merged = *c
+
+	if other.NextServer.IsValid() {
+		merged.NextServer = other.NextServer
+	}
+
+	if other.Filename != "" {
+		merged.Filename = other.Filename
+	}
+
+	if other.FilenameBIOS != "" {
+		merged.FilenameBIOS = other.FilenameBIOS
+	}
+
+	if other.FilenameUEFIx64 != "" {
+		merged.FilenameUEFIx64 = other.FilenameUEFIx64
+	}
+
+	if other.FilenameARM64 != "" {
+		merged.FilenameARM64 = other.FilenameARM64
+	}
+
+	return merged
+}
+
+// filename returns the boot file name for the client of the first of arches,
+// for which it's configured, or the default one.  c may be nil.
+func (c *BootConfig) filename(arches []dhcpsvc.ClientArch) (name string) {
+	if c == nil {
+		return ""
+	}
+
+	names := c.archFilenames()
+	for _, arch := range arches {
+		if name = names[arch]; name != "" {
+			return name
+		}
+	}
+
+	return c.Filename
+}
+
+// TFTPConfig is the configuration of the built-in read-only TFTP server
+// serving the network boot files.
+type TFTPConfig struct {
+	// Root is the directory to serve the files from relative to the working
+	// directory.  If empty, [DefaultTFTPRoot] is used.
+	Root string `yaml:"root"`
+
+	// ListenAddrs are the addresses to listen on.  If empty, the IPv4
+	// addresses of the DHCP interface and the server addresses of the
+	// additional scopes are used.
+	ListenAddrs []netip.Addr `yaml:"listen_addrs,omitempty"`
+
+	// Enabled defines if the TFTP server is started along with the DHCP
+	// server.
+	Enabled bool `yaml:"enabled"`
+}
+
+// DefaultTFTPRoot is the default directory of the TFTP server relative to the
+// working directory.
+const DefaultTFTPRoot = "tftp"
+
+// root returns the root directory of the TFTP server relative to the working
+// directory.
+func (c *TFTPConfig) root() (root string) {
+	if c.Root == "" {
+		return DefaultTFTPRoot
+	}
+
+	return c.Root
+}
+
+// validate returns an error if c is not a valid configuration.
+func (c *TFTPConfig) validate() (err error) {
+	if !c.Enabled {
+		return nil
+	}
+
+	if root := c.root(); !filepath.IsLocal(root) {
+		return fmt.Errorf("tftp: root %q must be a relative path within the working directory", root)
+	}
+
+	return nil
+}
+
 // DHCPServer - DHCP server interface
 type DHCPServer interface {
 	// ResetLeases resets leases.
@@ -107,6 +374,16 @@ type V4ServerConf struct {
 	// 0: disable
 	ICMPTimeout uint32 `yaml:"icmp_timeout_msec" json:"-"`
 
+	// QuarantineDuration is the time in seconds, during which the address
+	// found to be in use by another device isn't leased.  If zero, the lease
+	// duration is used.
+	QuarantineDuration uint32 `yaml:"quarantine_duration" json:"-"`
+
+	// PoolWarningThreshold is the percentage of the range addresses leased or
+	// quarantined, on reaching which the warning about the exhaustion of the
+	// pool is logged.  If zero, [DefaultPoolWarningThreshold] is used.
+	PoolWarningThreshold uint8 `yaml:"pool_warning_threshold" json:"-"`
+
 	// Custom Options.
 	//
 	// Option with arbitrary hexadecimal data:
@@ -117,6 +394,13 @@ type V4ServerConf struct {
 	//     DEC_CODE ip IP_ADDR
 	Options []string `yaml:"options" json:"-"`
 
+	// Boot is the network boot configuration.  It may be nil.
+	Boot *BootConfig `yaml:"boot,omitempty" json:"-"`
+
+	// Classes are the client classes.  The options of all the classes matching
+	// a client are applied in the given order.
+	Classes []*ClientClassConfig `yaml:"classes,omitempty" json:"-"`
+
 	ipRange *ipRange
 
 	leaseTime  time.Duration // the time during which a dynamic lease is considered valid
@@ -133,8 +417,53 @@ type V4ServerConf struct {
 	// TODO(a.garipov): This is utter madness and must be refactored.  It just
 	// begs for deadlock bugs and other nastiness.
 	notify func(uint32)
+
+	// notifyLease signals the change of a single lease along with a copy of
+	// it.  Unlike notify, it may be called within locked sections.  It may be
+	// nil.
+	notifyLease func(flags uint32, l *dhcpsvc.Lease)
+
+	// handleRelayed handles the request in pkt received on conn from a relay
+	// agent serving a subnet other than the one of the server.  It may be nil,
+	// in which case such requests are ignored.
+	handleRelayed func(conn net.PacketConn, pkt []byte)
+
+	// arpDB is used to find the hardware addresses of the devices using the
+	// conflicting addresses.  It may be nil.
+	arpDB arpdb.Interface
+
+	// notifyPool is called with the usage of the dynamic range once it reaches
+	// PoolWarningThreshold.  It may be called within locked sections.  It may
+	// be nil.
+	notifyPool func(u *poolUsage)
+
+	// quarantineTime is the time during which a conflicting address isn't
+	// leased.
+	quarantineTime time.Duration
 }
 
+// poolUsage is the usage of the dynamic range.
+type poolUsage struct {
+	// size is the number of addresses within the range.
+	size uint64
+
+	// leased is the number of addresses within the range leased to the
+	// clients.
+	leased uint64
+
+	// quarantined is the number of addresses within the range found to be in
+	// use by other devices.
+	quarantined uint64
+
+	// nearlyExhausted is true if the percentage of the leased and quarantined
+	// addresses reaches the configured threshold.
+	nearlyExhausted bool
+}
+
+// DefaultPoolWarningThreshold is the default percentage of the leased or
+// quarantined range addresses, on reaching which the warning is logged.
+const DefaultPoolWarningThreshold = 90
+
 // errNilConfig is an error returned by validation method if the config is nil.
 const errNilConfig errors.Error = "nil config"
 
@@ -207,6 +536,11 @@ func (c *V4ServerConf) Validate() (err error) {
 		)
 	}
 
+	err = c.Boot.validate()
+	if err != nil {
+		return fmt.Errorf("boot: %w", err)
+	}
+
 	if !c.subnet.Contains(rangeStart) {
 		return fmt.Errorf("range start %v is outside network %v",
 			c.RangeStart,
@@ -221,6 +555,10 @@ func (c *V4ServerConf) Validate() (err error) {
 		)
 	}
 
+	if c.PoolWarningThreshold > 100 {
+		return fmt.Errorf("pool warning threshold %d is greater than 100", c.PoolWarningThreshold)
+	}
+
 	return nil
 }
 
@@ -238,10 +576,57 @@ type V6ServerConf struct {
 	RASLAACOnly  bool `yaml:"ra_slaac_only" json:"-"`  // send ICMPv6.RA packets without MO flags
 	RAAllowSLAAC bool `yaml:"ra_allow_slaac" json:"-"` // send ICMPv6.RA packets with MO flags
 
+	// PrefixDelegation is the configuration of the prefixes delegated to the
+	// requesting routers.  If nil, the prefixes aren't delegated.
+	PrefixDelegation *PrefixDelegationConfig `yaml:"prefix_delegation,omitempty" json:"-"`
+
 	ipStart    net.IP        // starting IP address for dynamic leases
 	leaseTime  time.Duration // the time during which a dynamic lease is considered valid
 	dnsIPAddrs []net.IP      // IPv6 addresses to return to DHCP clients as DNS server addresses
 
 	// Server calls this function when leases data changes
 	notify func(uint32)
+
+	// notifyLease signals the change of a single lease along with a copy of
+	// it.  Unlike notify, it may be called within locked sections.  It may be
+	// nil.
+	notifyLease func(flags uint32, l *dhcpsvc.Lease)
+}
+
+// PrefixDelegationConfig is the configuration of the DHCPv6 prefix delegation.
+// The prefixes of PrefixLen bits are carved out of Pool, so that a /56 pool
+// with a /60 prefix length delegates sixteen prefixes.
+//
+// See https://datatracker.ietf.org/doc/html/rfc8415#section-6.3.
+type PrefixDelegationConfig struct {
+	// Pool is the prefix to delegate the prefixes from.
+	Pool netip.Prefix `yaml:"pool"`
+
+	// PrefixLen is the length of the delegated prefixes.  It must be greater
+	// than the length of Pool and not greater than 64.
+	PrefixLen int `yaml:"prefix_len"`
+}
+
+// maxDelegatedPrefixes is the maximum number of prefixes within the prefix
+// delegation pool.
+const maxDelegatedPrefixes = 1 << 16
+
+// validate returns an error if c is not a valid configuration.  c may be nil.
+func (c *PrefixDelegationConfig) validate() (err error) {
+	if c == nil {
+		return nil
+	}
+
+	switch pool := c.Pool; {
+	case !pool.IsValid() || !pool.Addr().Is6() || pool.Addr().Is4In6():
+		return fmt.Errorf("pool %v is not a valid IPv6 prefix", pool)
+	case pool.Masked() != pool:
+		return fmt.Errorf("pool %v has host bits set", pool)
+	case c.PrefixLen <= pool.Bits() || c.PrefixLen > 64:
+		return fmt.Errorf("prefix length %d must be within (%d, 64]", c.PrefixLen, pool.Bits())
+	case c.PrefixLen-pool.Bits() > 16:
+		return fmt.Errorf("pool %v contains more than %d /%d prefixes", pool, maxDelegatedPrefixes, c.PrefixLen)
+	default:
+		return nil
+	}
 }
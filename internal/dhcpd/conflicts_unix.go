@@ -0,0 +1,223 @@
+//go:build darwin || freebsd || linux || openbsd
+
+package dhcpd
+
+import (
+	"cmp"
+	"maps"
+	"net"
+	"net/netip"
+	"slices"
+	"time"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/dhcpsvc"
+	"github.com/AdguardTeam/golibs/log"
+)
+
+// conflictReason is the way the address conflict is detected.
+type conflictReason string
+
+// Supported conflict reasons.
+const (
+	// conflictReasonDeclined means that the client declined the address, since
+	// it found the address in use.
+	conflictReasonDeclined conflictReason = "declined"
+
+	// conflictReasonICMP means that the address replied to the ICMP echo
+	// request before being offered.
+	conflictReasonICMP conflictReason = "icmp"
+
+	// conflictReasonUnknown means that the conflict is restored from the
+	// quarantined lease, which doesn't keep the details of the conflict.
+	conflictReasonUnknown conflictReason = "unknown"
+)
+
+// addrConflict is an address of the range found to be in use by a device,
+// which didn't lease it.
+type addrConflict struct {
+	// detected is the time the conflict is detected at.
+	detected time.Time
+
+	// until is the time the quarantine of the address ends at.
+	until time.Time
+
+	// hwAddr is the hardware address of the device using the address as
+	// reported by ARP.  It's nil if unknown.
+	hwAddr net.HardwareAddr
+
+	// clientHWAddr is the hardware address of the client the address was
+	// offered to or declined by.
+	clientHWAddr net.HardwareAddr
+
+	// ip is the conflicting address.
+	ip netip.Addr
+
+	// reason is the way the conflict is detected.
+	reason conflictReason
+}
+
+// blocklistLease quarantines the address of l found to be in use by another
+// device and records the conflict.  mac is the hardware address of the client
+// the address was offered to or declined by.  s.leasesLock is expected to be
+// locked.
+func (s *v4Server) blocklistLease(l *dhcpsvc.Lease, mac net.HardwareAddr, reason conflictReason) {
+	now := time.Now()
+
+	l.HWAddr = make(net.HardwareAddr, defaultHwAddrLen)
+	l.Hostname = ""
+	l.Expiry = now.Add(s.conf.quarantineTime)
+
+	s.removeEndedConflicts(now)
+	s.conflicts[l.IP] = &addrConflict{
+		detected:     now,
+		until:        l.Expiry,
+		clientHWAddr: slices.Clone(mac),
+		ip:           l.IP,
+		reason:       reason,
+	}
+
+	log.Info("dhcpv4: ip conflict: %s is in use, detected by %s of %s", l.IP, reason, mac)
+
+	if s.conf.arpDB != nil {
+		go s.resolveConflict(l.IP)
+	}
+
+	s.checkPoolUsage(now)
+}
+
+// restoreConflict records the conflict for the quarantined lease l added back
+// on reset.  The details of the conflict are taken from prev, if it has them,
+// since the leases database doesn't keep those.  Otherwise, the detection time
+// is estimated from the end of the quarantine.  s.leasesLock is expected to be
+// locked.
+func (s *v4Server) restoreConflict(l *dhcpsvc.Lease, prev map[netip.Addr]*addrConflict, now time.Time) {
+	if !l.Expiry.After(now) {
+		return
+	}
+
+	c, ok := prev[l.IP]
+	if !ok {
+		c = &addrConflict{
+			detected: l.Expiry.Add(-s.conf.quarantineTime),
+			ip:       l.IP,
+			reason:   conflictReasonUnknown,
+		}
+	}
+
+	c.until = l.Expiry
+	s.conflicts[l.IP] = c
+}
+
+// removeEndedConflicts removes the conflicts, the quarantine of which ended
+// before now.  s.leasesLock is expected to be locked.
+func (s *v4Server) removeEndedConflicts(now time.Time) {
+	maps.DeleteFunc(s.conflicts, func(_ netip.Addr, c *addrConflict) (del bool) {
+		return c.until.Before(now)
+	})
+}
+
+// resolveConflict looks up the hardware address of the device using the
+// conflicting ip in the ARP table.  It's intended to be used as a goroutine.
+func (s *v4Server) resolveConflict(ip netip.Addr) {
+	defer log.OnPanic("dhcpv4: resolving conflict")
+
+	err := s.conf.arpDB.Refresh()
+	if err != nil {
+		log.Debug("dhcpv4: resolving conflict for %s: refreshing arp: %s", ip, err)
+
+		return
+	}
+
+	for _, n := range s.conf.arpDB.Neighbors() {
+		if n.IP != ip {
+			continue
+		}
+
+		s.leasesLock.Lock()
+		defer s.leasesLock.Unlock()
+
+		if c, ok := s.conflicts[ip]; ok {
+			c.hwAddr = slices.Clone(n.MAC)
+			log.Info("dhcpv4: ip conflict: %s is in use by %s", ip, n.MAC)
+		}
+
+		return
+	}
+}
+
+// poolUsage returns the usage of the dynamic range at now.  s.leasesLock is
+// expected to be locked.
+func (s *v4Server) poolUsage(now time.Time) (u *poolUsage) {
+	threshold := uint64(cmp.Or(s.conf.PoolWarningThreshold, DefaultPoolWarningThreshold))
+
+	r := s.conf.ipRange
+	u = &poolUsage{
+		size: r.size(),
+	}
+
+	for _, l := range s.leases {
+		if !r.contains(l.IP.AsSlice()) {
+			continue
+		}
+
+		switch {
+		case s.isBlocklisted(l) && l.Expiry.After(now):
+			u.quarantined++
+		case l.IsStatic, l.Expiry.After(now):
+			u.leased++
+		}
+	}
+
+	u.nearlyExhausted = u.size > 0 && (u.leased+u.quarantined)*100 >= u.size*threshold
+
+	return u
+}
+
+// checkPoolUsage logs the warning and alerts the other components once the
+// usage of the dynamic range reaches the configured threshold.  s.leasesLock
+// is expected to be locked.
+func (s *v4Server) checkPoolUsage(now time.Time) {
+	u := s.poolUsage(now)
+	if u.nearlyExhausted && !s.poolWarned {
+		log.Info(
+			"dhcpv4: warning: pool is nearly exhausted: %d leased and %d quarantined of %d",
+			u.leased,
+			u.quarantined,
+			u.size,
+		)
+
+		if s.conf.notifyPool != nil {
+			s.conf.notifyPool(u)
+		}
+	}
+
+	s.poolWarned = u.nearlyExhausted
+}
+
+// conflictList returns the address conflicts with the quarantine not ended yet,
+// sorted by the detection time, and the current usage of the dynamic range.
+// It's safe for concurrent use.
+func (s *v4Server) conflictList() (conflicts []*addrConflict, u *poolUsage) {
+	if s.conf == nil {
+		return []*addrConflict{}, &poolUsage{}
+	}
+
+	now := time.Now()
+
+	s.leasesLock.Lock()
+	defer s.leasesLock.Unlock()
+
+	s.removeEndedConflicts(now)
+
+	conflicts = make([]*addrConflict, 0, len(s.conflicts))
+	for _, c := range s.conflicts {
+		cc := *c
+		conflicts = append(conflicts, &cc)
+	}
+
+	slices.SortFunc(conflicts, func(a, b *addrConflict) (res int) {
+		return a.detected.Compare(b.detected)
+	})
+
+	return conflicts, s.poolUsage(now)
+}
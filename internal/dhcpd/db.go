@@ -3,6 +3,8 @@
 package dhcpd
 
 import (
+	"encoding/binary"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"net"
@@ -16,6 +18,7 @@ import (
 	"github.com/AdguardTeam/AdGuardHome/internal/dhcpsvc"
 	"github.com/AdguardTeam/golibs/errors"
 	"github.com/AdguardTeam/golibs/log"
+	"github.com/google/gopacket/layers"
 	"github.com/google/renameio/v2/maybe"
 )
 
@@ -43,6 +46,30 @@ type dbLease struct {
 	Hostname string     `json:"hostname"`
 	HWAddr   string     `json:"mac"`
 	IsStatic bool       `json:"static"`
+
+	// Prefix is the delegated IPv6 prefix, if any.  IP is empty for the
+	// leases of the delegated prefixes.
+	Prefix string `json:"prefix,omitempty"`
+
+	// DUID is the hexadecimal-encoded DUID of the client holding the
+	// delegated prefix, if any.
+	DUID string `json:"duid,omitempty"`
+
+	// IAID is the identifier of the identity association of the delegated
+	// prefix, if any.
+	IAID uint32 `json:"iaid,omitempty"`
+
+	// Options are the DHCPv4 options of the static lease, if any.
+	Options []*dbOption `json:"options,omitempty"`
+}
+
+// dbOption is the structure of stored DHCP option of a lease.
+type dbOption struct {
+	// Data is the hexadecimal-encoded data of the option.
+	Data string `json:"data"`
+
+	// Code is the code of the option.
+	Code uint8 `json:"code"`
 }
 
 // fromLease converts *dhcpsvc.Lease to *dbLease.
@@ -56,13 +83,28 @@ func fromLease(l *dhcpsvc.Lease) (dl *dbLease) {
 		expiryStr = l.Expiry.Format(time.RFC3339)
 	}
 
-	return &dbLease{
+	dl = &dbLease{
 		Expiry:   expiryStr,
 		Hostname: l.Hostname,
 		HWAddr:   l.HWAddr.String(),
 		IP:       l.IP,
 		IsStatic: l.IsStatic,
 	}
+
+	if l.Prefix.IsValid() {
+		dl.Prefix = l.Prefix.String()
+		dl.DUID = hex.EncodeToString(l.DUID)
+		dl.IAID = binary.BigEndian.Uint32(l.IAID[:])
+	}
+
+	for _, o := range l.Options {
+		dl.Options = append(dl.Options, &dbOption{
+			Data: hex.EncodeToString(o.Data),
+			Code: uint8(o.Type),
+		})
+	}
+
+	return dl
 }
 
 // toLease converts *dbLease to *dhcpsvc.Lease.
@@ -80,11 +122,40 @@ func (dl *dbLease) toLease() (l *dhcpsvc.Lease, err error) {
 		}
 	}
 
+	var pref netip.Prefix
+	var duid []byte
+	if dl.Prefix != "" {
+		pref, err = netip.ParsePrefix(dl.Prefix)
+		if err != nil {
+			return nil, fmt.Errorf("parsing prefix: %w", err)
+		}
+
+		duid, err = hex.DecodeString(dl.DUID)
+		if err != nil {
+			return nil, fmt.Errorf("parsing duid: %w", err)
+		}
+	}
+
+	var opts layers.DHCPOptions
+	for i, o := range dl.Options {
+		var data []byte
+		data, err = hex.DecodeString(o.Data)
+		if err != nil {
+			return nil, fmt.Errorf("parsing option at index %d: %w", i, err)
+		}
+
+		opts = append(opts, layers.NewDHCPOption(layers.DHCPOpt(o.Code), data))
+	}
+
 	return &dhcpsvc.Lease{
 		Expiry:   expiry,
 		IP:       dl.IP,
+		Prefix:   pref,
+		DUID:     duid,
+		IAID:     [4]byte(binary.BigEndian.AppendUint32(nil, dl.IAID)),
 		Hostname: dl.Hostname,
 		HWAddr:   mac,
+		Options:  opts,
 		IsStatic: dl.IsStatic,
 	}, nil
 }
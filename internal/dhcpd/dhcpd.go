@@ -2,14 +2,20 @@
 package dhcpd
 
 import (
+	"context"
 	"fmt"
+	"log/slog"
 	"net"
 	"net/netip"
 	"path/filepath"
+	"slices"
 	"time"
 
+	"github.com/AdguardTeam/AdGuardHome/internal/aghnet"
 	"github.com/AdguardTeam/AdGuardHome/internal/dhcpsvc"
+	"github.com/AdguardTeam/AdGuardHome/internal/tftp"
 	"github.com/AdguardTeam/golibs/log"
+	"github.com/AdguardTeam/golibs/logutil/slogutil"
 	"github.com/AdguardTeam/golibs/timeutil"
 )
 
@@ -27,8 +33,10 @@ const (
 	defaultBackoff     time.Duration = 500 * time.Millisecond
 )
 
-// OnLeaseChangedT is a callback for lease changes.
-type OnLeaseChangedT func(flags int)
+// OnLeaseChangedT is a callback for lease changes.  l is a copy of the changed
+// lease, if the change concerns a single lease, and nil otherwise.  It's called
+// synchronously, so it must not block.
+type OnLeaseChangedT func(flags int, l *dhcpsvc.Lease)
 
 // flags for onLeaseChanged()
 const (
@@ -37,6 +45,11 @@ const (
 	LeaseChangedRemovedStatic
 	LeaseChangedRemovedAll
 
+	// LeaseChangedReleased means that the dynamic lease is released or
+	// declined by the client, or the delegated prefix is released.  It's only
+	// reported along with the lease.
+	LeaseChangedReleased
+
 	LeaseChangedDBStore
 )
 
@@ -78,6 +91,10 @@ type Interface interface {
 	// due to an assumption that a DHCP client must always have an IP address.
 	HostByIP(ip netip.Addr) (host string)
 
+	// StaticHostByIP is like [Interface.HostByIP] but only considers the static
+	// leases.
+	StaticHostByIP(ip netip.Addr) (host string)
+
 	// IPByHost returns the IP address of the DHCP client with the given
 	// hostname.  The address will be netip.Addr{} if there is no such client,
 	// due to an assumption that a DHCP client must always have an IP address.
@@ -91,10 +108,22 @@ type server struct {
 	srv4 DHCPServer
 	srv6 DHCPServer
 
+	// scopes serves the additional DHCPv4 scopes.  It's [dhcpsvc.Empty] if
+	// there are none or the server is disabled.
+	scopes dhcpsvc.Interface
+
+	// tftp serves the network boot files.  It's nil if the TFTP server is
+	// disabled.
+	tftp *tftp.Server
+
 	// TODO(a.garipov): Either create a separate type for the internal config or
 	// just put the config values into Server.
 	conf *ServerConfig
 
+	// hooks run on the lease events.  It's nil if there are no hooks
+	// configured.
+	hooks *leaseHooks
+
 	// Called when the leases DB is modified
 	onLeaseChanged []OnLeaseChangedT
 }
@@ -116,6 +145,14 @@ func Create(conf *ServerConfig) (s *server, err error) {
 
 			LocalDomainName: conf.LocalDomainName,
 
+			Scopes: conf.Scopes,
+			TFTP:   conf.TFTP,
+			Hooks:  conf.Hooks,
+			Logger: conf.Logger,
+			ARPDB:  conf.ARPDB,
+
+			WorkDir:    conf.WorkDir,
+			DataDir:    conf.DataDir,
 			dbFilePath: filepath.Join(conf.DataDir, dataFilename),
 		},
 	}
@@ -137,6 +174,24 @@ func Create(conf *ServerConfig) (s *server, err error) {
 		return nil, fmt.Errorf("neither dhcpv4 nor dhcpv6 srv is configured")
 	}
 
+	s.scopes, err = newScopes(s.conf, s.onLeaseNotify)
+	if err != nil {
+		// Don't wrap the error, because it's informative enough as is.
+		return nil, err
+	}
+
+	err = conf.TFTP.validate()
+	if err != nil {
+		// Don't wrap the error, because it's informative enough as is.
+		return nil, err
+	}
+
+	err = s.startHooks(conf)
+	if err != nil {
+		// Don't wrap the error, because it's informative enough as is.
+		return nil, err
+	}
+
 	// Migrate leases db if needed.
 	err = migrateDB(conf)
 	if err != nil {
@@ -161,6 +216,10 @@ func (s *server) setServers(conf *ServerConfig) (v4Enabled, v6Enabled bool, err
 	v4conf := conf.Conf4
 	v4conf.InterfaceName = s.conf.InterfaceName
 	v4conf.notify = s.onNotify
+	v4conf.notifyLease = s.onLeaseNotify
+	v4conf.notifyPool = s.onPoolExhausted
+	v4conf.handleRelayed = s.handleRelayedV4
+	v4conf.arpDB = conf.ARPDB
 	v4conf.Enabled = s.conf.Enabled && v4conf.RangeStart.IsValid()
 
 	s.srv4, err = v4Create(&v4conf)
@@ -175,6 +234,7 @@ func (s *server) setServers(conf *ServerConfig) (v4Enabled, v6Enabled bool, err
 	v6conf := conf.Conf6
 	v6conf.InterfaceName = s.conf.InterfaceName
 	v6conf.notify = s.onNotify
+	v6conf.notifyLease = s.onLeaseNotify
 	v6conf.Enabled = s.conf.Enabled && len(v6conf.RangeStart) != 0
 
 	s.srv6, err = v6Create(v6conf)
@@ -204,6 +264,11 @@ func (s *server) resetLeases() (err error) {
 		}
 	}
 
+	err = s.scopes.Reset(context.Background())
+	if err != nil {
+		return err
+	}
+
 	return s.dbStore()
 }
 
@@ -223,7 +288,24 @@ func (s *server) onNotify(flags uint32) {
 
 func (s *server) notify(flags int) {
 	for _, f := range s.onLeaseChanged {
-		f(flags)
+		f(flags, nil)
+	}
+}
+
+// onLeaseNotify is called by the servers on the changes of the single lease l.
+// It may be called within the locked sections.
+func (s *server) onLeaseNotify(flags uint32, l *dhcpsvc.Lease) {
+	for _, f := range s.onLeaseChanged {
+		f(int(flags), l)
+	}
+}
+
+// onPoolExhausted is called by the DHCPv4 server once the usage of its dynamic
+// range u reaches the configured threshold.  It may be called within the
+// locked sections.
+func (s *server) onPoolExhausted(u *poolUsage) {
+	if s.hooks != nil {
+		s.hooks.onPoolExhausted(u)
 	}
 }
 
@@ -232,6 +314,9 @@ func (s *server) WriteDiskConfig(c *ServerConfig) {
 	c.Enabled = s.conf.Enabled
 	c.InterfaceName = s.conf.InterfaceName
 	c.LocalDomainName = s.conf.LocalDomainName
+	c.Scopes = slices.Clone(s.conf.Scopes)
+	c.TFTP = s.conf.TFTP
+	c.Hooks = s.conf.Hooks
 
 	s.srv4.WriteDiskConfig4(&c.Conf4)
 	s.srv6.WriteDiskConfig6(&c.Conf6)
@@ -249,6 +334,116 @@ func (s *server) Start() (err error) {
 		return err
 	}
 
+	err = s.scopes.Start(context.Background())
+	if err != nil {
+		return fmt.Errorf("starting scopes: %w", err)
+	}
+
+	if s.conf.TFTP.Enabled {
+		// Don't wrap the error, because it's informative enough as is.
+		return s.startTFTP(context.Background())
+	}
+
+	return nil
+}
+
+// startTFTP creates the TFTP server serving the files from the root directory
+// within the working directory on the addresses from [server.tftpAddrs] and
+// starts it.
+func (s *server) startTFTP(ctx context.Context) (err error) {
+	l := s.conf.Logger
+	if l == nil {
+		l = slog.Default()
+	}
+
+	l = l.With(slogutil.KeyPrefix, "tftp")
+
+	addrs := s.tftpAddrs(ctx, l)
+	if len(addrs) == 0 {
+		l.WarnContext(ctx, "no addresses to listen on")
+
+		return nil
+	}
+
+	addrPorts := make([]netip.AddrPort, 0, len(addrs))
+	for _, addr := range addrs {
+		addrPorts = append(addrPorts, netip.AddrPortFrom(addr, tftp.DefaultPort))
+	}
+
+	s.tftp, err = tftp.New(&tftp.Config{
+		Logger: l,
+		Root:   filepath.Join(s.conf.WorkDir, s.conf.TFTP.root()),
+		Addrs:  addrPorts,
+	})
+	if err != nil {
+		// Don't wrap the error, because it's informative enough as is.
+		return err
+	}
+
+	// Don't wrap the error, because it's informative enough as is.
+	return s.tftp.Start(ctx)
+}
+
+// tftpAddrs returns the addresses for the TFTP server to listen on.  Unless
+// configured explicitly, those are the IPv4 addresses of the DHCP interface and
+// the addresses of the server in the additional scopes, so that the files
+// aren't served on the other networks.
+func (s *server) tftpAddrs(ctx context.Context, l *slog.Logger) (addrs []netip.Addr) {
+	if len(s.conf.TFTP.ListenAddrs) > 0 {
+		return s.conf.TFTP.ListenAddrs
+	}
+
+	ifaceName := s.conf.InterfaceName
+	iface, err := net.InterfaceByName(ifaceName)
+	if err != nil {
+		l.WarnContext(ctx, "finding interface", "iface", ifaceName, slogutil.KeyError, err)
+	} else {
+		var ips []net.IP
+		ips, err = aghnet.IfaceIPAddrs(iface, aghnet.IPVersion4)
+		if err != nil {
+			l.WarnContext(ctx, "getting addresses", "iface", ifaceName, slogutil.KeyError, err)
+		}
+
+		for _, ip := range ips {
+			if addr, ok := netip.AddrFromSlice(ip.To4()); ok {
+				addrs = append(addrs, addr)
+			}
+		}
+	}
+
+	for _, sc := range s.conf.Scopes {
+		if sc.ServerIP.IsValid() {
+			addrs = append(addrs, sc.ServerIP)
+		} else {
+			addrs = append(addrs, sc.GatewayIP)
+		}
+	}
+
+	slices.SortFunc(addrs, netip.Addr.Compare)
+
+	return slices.Compact(addrs)
+}
+
+// startHooks starts running the lease event hooks configured in conf, if any.
+func (s *server) startHooks(conf *ServerConfig) (err error) {
+	l := conf.Logger
+	if l == nil {
+		l = slog.Default()
+	}
+
+	l = l.With(slogutil.KeyPrefix, "dhcp_hooks")
+	h, err := newLeaseHooks(conf.Hooks, l)
+	if err != nil || h == nil {
+		return err
+	}
+
+	s.hooks = h
+	s.onLeaseChanged = append(s.onLeaseChanged, h.onLeaseChanged)
+
+	// The hooks are run for the whole lifetime of the process, since so is the
+	// server.
+	go h.serve(context.Background())
+
 	return nil
 }
 
@@ -264,40 +459,118 @@ func (s *server) Stop() (err error) {
 		return err
 	}
 
+	err = s.scopes.Shutdown(context.Background())
+	if err != nil {
+		return fmt.Errorf("stopping scopes: %w", err)
+	}
+
+	if s.tftp != nil {
+		err = s.tftp.Shutdown(context.Background())
+		s.tftp = nil
+
+		// Don't wrap the error, because it's informative enough as is.
+		return err
+	}
+
 	return nil
 }
 
 // Leases returns the list of active DHCP leases.
 func (s *server) Leases() (leases []*dhcpsvc.Lease) {
-	return append(s.srv4.GetLeases(LeasesAll), s.srv6.GetLeases(LeasesAll)...)
+	leases = append(s.srv4.GetLeases(LeasesAll), s.srv6.GetLeases(LeasesAll)...)
+
+	return append(leases, s.scopes.Leases()...)
 }
 
 // MACByIP returns a MAC address by the IP address of its lease, if there is
 // one.
 func (s *server) MACByIP(ip netip.Addr) (mac net.HardwareAddr) {
-	if ip.Is4() {
-		return s.srv4.FindMACbyIP(ip)
+	if !ip.Is4() {
+		return s.srv6.FindMACbyIP(ip)
+	}
+
+	if mac = s.srv4.FindMACbyIP(ip); mac != nil {
+		return mac
 	}
 
-	return s.srv6.FindMACbyIP(ip)
+	return s.scopes.MACByIP(ip)
 }
 
 // HostByIP implements the [Interface] interface for *server.
 //
 // TODO(e.burkov):  Implement this method for DHCPv6.
 func (s *server) HostByIP(ip netip.Addr) (host string) {
-	if ip.Is4() {
-		return s.srv4.HostByIP(ip)
+	if !ip.Is4() {
+		return ""
+	}
+
+	if host = s.srv4.HostByIP(ip); host != "" {
+		return host
+	}
+
+	return s.scopes.HostByIP(ip)
+}
+
+// StaticHostByIP implements the [Interface] interface for *server.
+//
+// TODO(e.burkov):  Implement this method for DHCPv6.
+func (s *server) StaticHostByIP(ip netip.Addr) (host string) {
+	if !ip.Is4() {
+		return ""
+	}
+
+	for _, l := range s.srv4.GetLeases(LeasesStatic) {
+		if l.IP == ip {
+			return l.Hostname
+		}
+	}
+
+	for _, l := range s.scopes.Leases() {
+		if l.IsStatic && l.IP == ip {
+			return l.Hostname
+		}
 	}
 
 	return ""
 }
 
+// relayedHandlerV4 is the DHCP service handling the DHCPv4 requests from relay
+// agents received by the other servers.
+type relayedHandlerV4 interface {
+	// HandleRelayedV4 handles the request from a relay agent in pkt received on
+	// conn and sends the response, if any, using conn.
+	HandleRelayedV4(ctx context.Context, conn net.PacketConn, pkt []byte) (err error)
+}
+
+// type check
+var _ relayedHandlerV4 = (*dhcpsvc.DHCPServer)(nil)
+
+// handleRelayedV4 passes the request in pkt received by the DHCPv4 server of
+// the main interface on conn from a relay agent of another subnet to the
+// additional scopes.  The request is ignored if there are no scopes.
+func (s *server) handleRelayedV4(conn net.PacketConn, pkt []byte) {
+	h, ok := s.scopes.(relayedHandlerV4)
+	if !ok {
+		log.Debug("dhcpv4: no scopes for relayed request")
+
+		return
+	}
+
+	err := h.HandleRelayedV4(context.Background(), conn, pkt)
+	if err != nil {
+		log.Debug("dhcpv4: handling relayed request: %s", err)
+	}
+}
+
 // IPByHost implements the [Interface] interface for *server.
 //
 // TODO(e.burkov):  Implement this method for DHCPv6.
 func (s *server) IPByHost(host string) (ip netip.Addr) {
-	return s.srv4.IPByHost(host)
+	if ip = s.srv4.IPByHost(host); ip.IsValid() {
+		return ip
+	}
+
+	return s.scopes.IPByHost(host)
 }
 
 // AddStaticLease - add static v4 lease
@@ -11,7 +11,9 @@ import (
 	"time"
 
 	"github.com/AdguardTeam/AdGuardHome/internal/dhcpsvc"
+	"github.com/AdguardTeam/golibs/logutil/slogutil"
 	"github.com/AdguardTeam/golibs/testutil"
+	"github.com/google/gopacket/layers"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -54,6 +56,9 @@ func TestDB(t *testing.T) {
 		Hostname: "static-2.local",
 		HWAddr:   net.HardwareAddr{0xAA, 0xAA, 0xAA, 0xAA, 0xAA, 0xBB},
 		IP:       netip.MustParseAddr("192.168.10.101"),
+		Options: layers.DHCPOptions{
+			layers.NewDHCPOption(layers.DHCPOptDNS, []byte{1, 1, 1, 1}),
+		},
 	}}
 
 	srv4, ok := s.srv4.(*v4Server)
@@ -83,6 +88,7 @@ func TestDB(t *testing.T) {
 
 	assert.Equal(t, leases[1].HWAddr, ll[1].HWAddr)
 	assert.Equal(t, leases[1].IP, ll[1].IP)
+	assert.Equal(t, leases[1].Options, ll[1].Options)
 	assert.True(t, ll[1].IsStatic)
 }
 
@@ -137,3 +143,54 @@ func cloneUDPAddr(a *net.UDPAddr) (clone *net.UDPAddr) {
 		Zone: a.Zone,
 	}
 }
+
+func TestServer_tftpAddrs(t *testing.T) {
+	const testTimeout = 1 * time.Second
+
+	serverIP := netip.MustParseAddr("192.168.10.2")
+	scopeIP := netip.MustParseAddr("192.168.20.1")
+	scopes := []*ScopeConfig{{
+		InterfaceName: "eth0.20",
+		GatewayIP:     scopeIP,
+	}, {
+		InterfaceName: "relayed-30",
+		ServerIP:      serverIP,
+	}, {
+		InterfaceName: "relayed-40",
+		ServerIP:      serverIP,
+	}}
+
+	testCases := []struct {
+		name string
+		conf TFTPConfig
+		want []netip.Addr
+	}{{
+		name: "default",
+		conf: TFTPConfig{Enabled: true},
+		want: []netip.Addr{serverIP, scopeIP},
+	}, {
+		name: "explicit",
+		conf: TFTPConfig{
+			ListenAddrs: []netip.Addr{netip.MustParseAddr("192.168.10.5")},
+			Enabled:     true,
+		},
+		want: []netip.Addr{netip.MustParseAddr("192.168.10.5")},
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			s := &server{
+				conf: &ServerConfig{
+					// Use the non-existent interface to only get the addresses
+					// of the scopes.
+					InterfaceName: "non-existent",
+					Scopes:        scopes,
+					TFTP:          tc.conf,
+				},
+			}
+
+			ctx := testutil.ContextWithTimeout(t, testTimeout)
+			assert.Equal(t, tc.want, s.tftpAddrs(ctx, slogutil.NewDiscardLogger()))
+		})
+	}
+}
@@ -0,0 +1,420 @@
+package dhcpd
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/netip"
+	"os"
+	"os/exec"
+	"strconv"
+	"time"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/aghhttp"
+	"github.com/AdguardTeam/AdGuardHome/internal/aghos"
+	"github.com/AdguardTeam/AdGuardHome/internal/ddns"
+	"github.com/AdguardTeam/AdGuardHome/internal/dhcpsvc"
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/AdguardTeam/golibs/httphdr"
+	"github.com/AdguardTeam/golibs/logutil/slogutil"
+	"github.com/AdguardTeam/golibs/timeutil"
+)
+
+// HooksConfig is the configuration of the hooks run on the lease events.  The
+// hooks are run one at a time in the order of the events.
+type HooksConfig struct {
+	// DDNS is the configuration of publishing the hostnames of the clients
+	// into an external authoritative DNS server.  If nil, the hostnames aren't
+	// published.
+	DDNS *DDNSConfig `yaml:"ddns"`
+
+	// Script is the path to the executable run on each lease event.  The event
+	// is passed within the environment variables, see [leaseEnv].  If empty,
+	// no executable is run.
+	Script string `yaml:"script"`
+
+	// URL is the HTTP endpoint the lease events are posted to as JSON, see
+	// [leaseEventJSON] and [poolEventJSON].  If empty, the events aren't
+	// posted.
+	URL string `yaml:"url"`
+
+	// Timeout is the timeout of running a single hook.  If zero,
+	// [defaultHookTimeout] is used.
+	Timeout timeutil.Duration `yaml:"timeout"`
+}
+
+// DDNSConfig is the configuration of the dynamic DNS updates.
+type DDNSConfig struct {
+	// Server is the address of the primary authoritative server, e.g.
+	// "192.0.2.53:53".
+	Server netip.AddrPort `yaml:"server"`
+
+	// Zone is the forward zone to publish the hostnames in.
+	Zone string `yaml:"zone"`
+
+	// ReverseZones are the zones to publish the PTR records in.
+	ReverseZones []string `yaml:"reverse_zones"`
+
+	// TSIGKeyName is the name of the key to sign the updates with.  If empty,
+	// the updates aren't signed.
+	TSIGKeyName string `yaml:"tsig_key_name"`
+
+	// TSIGAlgorithm is the HMAC algorithm of the key.  If empty, hmac-sha256
+	// is used.
+	TSIGAlgorithm string `yaml:"tsig_algorithm"`
+
+	// TSIGSecret is the base64-encoded secret of the key.
+	TSIGSecret string `yaml:"tsig_secret"`
+
+	// TTL is the TTL of the published records.  If zero, [ddns.DefaultTTL] is
+	// used.
+	TTL timeutil.Duration `yaml:"ttl"`
+}
+
+// toInternal returns the configuration of the updater for c.
+func (c *DDNSConfig) toInternal(l *slog.Logger, timeout time.Duration) (conf *ddns.Config) {
+	conf = &ddns.Config{
+		Logger:       l,
+		Server:       c.Server,
+		Zone:         c.Zone,
+		ReverseZones: c.ReverseZones,
+		TTL:          c.TTL.Duration,
+		Timeout:      timeout,
+	}
+
+	if c.TSIGKeyName != "" {
+		conf.TSIG = &ddns.TSIGKey{
+			Name:      c.TSIGKeyName,
+			Algorithm: c.TSIGAlgorithm,
+			Secret:    c.TSIGSecret,
+		}
+	}
+
+	return conf
+}
+
+// defaultHookTimeout is the default timeout of running a single hook.
+const defaultHookTimeout = 10 * time.Second
+
+// maxPendingEvents is the maximum number of the lease events waiting for the
+// hooks.  The events exceeding it are dropped.
+const maxPendingEvents = 256
+
+// Names of the lease events passed to the hooks.
+const (
+	leaseEventGranted       = "granted"
+	leaseEventReleased      = "released"
+	leaseEventStaticAdded   = "static_added"
+	leaseEventStaticRemoved = "static_removed"
+
+	// leaseEventPoolExhausted is the event of the DHCPv4 dynamic range
+	// reaching the configured usage threshold.  It has no lease.
+	leaseEventPoolExhausted = "pool_nearly_exhausted"
+)
+
+// leaseEventName returns the name of the lease event with flags.  name is
+// empty if the flags don't describe the change of a single lease.
+func leaseEventName(flags int) (name string) {
+	switch flags {
+	case LeaseChangedAdded:
+		return leaseEventGranted
+	case LeaseChangedReleased:
+		return leaseEventReleased
+	case LeaseChangedAddedStatic:
+		return leaseEventStaticAdded
+	case LeaseChangedRemovedStatic:
+		return leaseEventStaticRemoved
+	default:
+		return ""
+	}
+}
+
+// leaseEvent is a single lease event to run the hooks for.
+type leaseEvent struct {
+	// lease is the copy of the changed lease.  It's nil for
+	// [leaseEventPoolExhausted].
+	lease *dhcpsvc.Lease
+
+	// pool is the usage of the dynamic range for [leaseEventPoolExhausted].
+	pool *poolUsage
+
+	// name is the name of the event.
+	name string
+}
+
+// leaseEventJSON is the body of the request posted to [HooksConfig.URL].
+type leaseEventJSON struct {
+	Event    string `json:"event"`
+	HWAddr   string `json:"mac"`
+	IP       string `json:"ip,omitempty"`
+	Prefix   string `json:"prefix,omitempty"`
+	Hostname string `json:"hostname,omitempty"`
+	Expires  string `json:"expires,omitempty"`
+	IsStatic bool   `json:"static"`
+}
+
+// poolEventJSON is the body of the request posted to [HooksConfig.URL] for
+// [leaseEventPoolExhausted].
+type poolEventJSON struct {
+	Event       string `json:"event"`
+	Size        uint64 `json:"size"`
+	Leased      uint64 `json:"leased"`
+	Quarantined uint64 `json:"quarantined"`
+}
+
+// leaseHooks runs the hooks on the lease events in a separate goroutine.
+type leaseHooks struct {
+	logger  *slog.Logger
+	updater *ddns.Updater
+	client  *http.Client
+	events  chan *leaseEvent
+	script  string
+	url     string
+	timeout time.Duration
+}
+
+// newLeaseHooks returns the hooks configured by conf.  h is nil if there are no
+// hooks configured.
+func newLeaseHooks(conf *HooksConfig, l *slog.Logger) (h *leaseHooks, err error) {
+	if conf == nil || (conf.DDNS == nil && conf.Script == "" && conf.URL == "") {
+		return nil, nil
+	}
+
+	timeout := conf.Timeout.Duration
+	if timeout == 0 {
+		timeout = defaultHookTimeout
+	}
+
+	h = &leaseHooks{
+		logger: l,
+		client: &http.Client{
+			Timeout: timeout,
+		},
+		events:  make(chan *leaseEvent, maxPendingEvents),
+		script:  conf.Script,
+		url:     conf.URL,
+		timeout: timeout,
+	}
+
+	if conf.DDNS != nil {
+		h.updater, err = ddns.New(conf.DDNS.toInternal(l.With(slogutil.KeyPrefix, "ddns"), timeout))
+		if err != nil {
+			return nil, fmt.Errorf("hooks: %w", err)
+		}
+	}
+
+	return h, nil
+}
+
+// onLeaseChanged queues the hooks for the change of the single lease l.  It
+// doesn't block, so the event is dropped if there are too many pending ones.
+// It implements [OnLeaseChangedT].
+func (h *leaseHooks) onLeaseChanged(flags int, l *dhcpsvc.Lease) {
+	name := leaseEventName(flags)
+	if l == nil || name == "" {
+		return
+	}
+
+	h.queue(&leaseEvent{lease: l, name: name})
+}
+
+// onPoolExhausted queues the hooks for the DHCPv4 dynamic range with usage u
+// reaching the configured threshold.  It doesn't block, so the event is
+// dropped if there are too many pending ones.
+func (h *leaseHooks) onPoolExhausted(u *poolUsage) {
+	h.queue(&leaseEvent{pool: u, name: leaseEventPoolExhausted})
+}
+
+// queue queues ev without blocking.  ev is dropped if there are too many
+// pending events.
+func (h *leaseHooks) queue(ev *leaseEvent) {
+	select {
+	case h.events <- ev:
+		// Go on.
+	default:
+		h.logger.Warn("too many pending events, dropping", "event", ev.name)
+	}
+}
+
+// serve runs the hooks for the queued events until ctx is canceled.  It's
+// intended to be used as a goroutine.
+func (h *leaseHooks) serve(ctx context.Context) {
+	defer slogutil.RecoverAndLog(ctx, h.logger)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case ev := <-h.events:
+			h.handle(ctx, ev)
+		}
+	}
+}
+
+// handle runs all the hooks for ev and logs the errors.
+func (h *leaseHooks) handle(ctx context.Context, ev *leaseEvent) {
+	var errs []error
+	if h.script != "" {
+		errs = append(errs, h.runScript(ctx, ev))
+	}
+
+	if h.url != "" {
+		errs = append(errs, h.post(ctx, ev))
+	}
+
+	if h.updater != nil {
+		errs = append(errs, h.updateDNS(ctx, ev))
+	}
+
+	err := errors.Join(errs...)
+	if err != nil {
+		h.logger.ErrorContext(ctx, "running hooks", "event", ev.name, slogutil.KeyError, err)
+	}
+}
+
+// leaseEnv returns the environment variables describing ev for the script.
+func leaseEnv(ev *leaseEvent) (env []string) {
+	if u := ev.pool; u != nil {
+		return []string{
+			"ADGUARD_HOME_LEASE_EVENT=" + ev.name,
+			"ADGUARD_HOME_POOL_SIZE=" + strconv.FormatUint(u.size, 10),
+			"ADGUARD_HOME_POOL_LEASED=" + strconv.FormatUint(u.leased, 10),
+			"ADGUARD_HOME_POOL_QUARANTINED=" + strconv.FormatUint(u.quarantined, 10),
+		}
+	}
+
+	l := ev.lease
+
+	env = []string{
+		"ADGUARD_HOME_LEASE_EVENT=" + ev.name,
+		"ADGUARD_HOME_LEASE_MAC=" + l.HWAddr.String(),
+		"ADGUARD_HOME_LEASE_HOSTNAME=" + l.Hostname,
+		"ADGUARD_HOME_LEASE_STATIC=" + strconv.FormatBool(l.IsStatic),
+	}
+
+	if l.IP.IsValid() {
+		env = append(env, "ADGUARD_HOME_LEASE_IP="+l.IP.String())
+	}
+
+	if l.Prefix.IsValid() {
+		env = append(env, "ADGUARD_HOME_LEASE_PREFIX="+l.Prefix.String())
+	}
+
+	if !l.IsStatic {
+		env = append(env, "ADGUARD_HOME_LEASE_EXPIRES="+l.Expiry.Format(time.RFC3339))
+	}
+
+	return env
+}
+
+// runScript runs the script for ev.
+func (h *leaseHooks) runScript(ctx context.Context, ev *leaseEvent) (err error) {
+	ctx, cancel := context.WithTimeout(ctx, h.timeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, h.script)
+	cmd.Env = append(os.Environ(), leaseEnv(ev)...)
+
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		out = out[:min(len(out), aghos.MaxCmdOutputSize)]
+
+		return fmt.Errorf("running script: %w; output: %q", err, out)
+	}
+
+	return nil
+}
+
+// post posts ev to the URL.
+func (h *leaseHooks) post(ctx context.Context, ev *leaseEvent) (err error) {
+	defer func() { err = errors.Annotate(err, "posting event: %w") }()
+
+	data, err := json.Marshal(eventBody(ev))
+	if err != nil {
+		return fmt.Errorf("encoding: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, h.url, bytes.NewReader(data))
+	if err != nil {
+		// Don't wrap the error, because it's informative enough as is.
+		return err
+	}
+
+	req.Header.Set(httphdr.ContentType, aghhttp.HdrValApplicationJSON)
+
+	resp, err := h.client.Do(req)
+	if err != nil {
+		// Don't wrap the error, because it's informative enough as is.
+		return err
+	}
+	defer func() { err = errors.WithDeferred(err, resp.Body.Close()) }()
+
+	if resp.StatusCode < http.StatusOK || resp.StatusCode >= http.StatusMultipleChoices {
+		return fmt.Errorf("unexpected status code %d", resp.StatusCode)
+	}
+
+	return nil
+}
+
+// eventBody returns the body of the request describing ev.
+func eventBody(ev *leaseEvent) (body any) {
+	if u := ev.pool; u != nil {
+		return &poolEventJSON{
+			Event:       ev.name,
+			Size:        u.size,
+			Leased:      u.leased,
+			Quarantined: u.quarantined,
+		}
+	}
+
+	l := ev.lease
+	lj := &leaseEventJSON{
+		Event:    ev.name,
+		HWAddr:   l.HWAddr.String(),
+		Hostname: l.Hostname,
+		IsStatic: l.IsStatic,
+	}
+
+	if l.IP.IsValid() {
+		lj.IP = l.IP.String()
+	}
+
+	if l.Prefix.IsValid() {
+		lj.Prefix = l.Prefix.String()
+	}
+
+	if !l.IsStatic {
+		lj.Expires = l.Expiry.Format(time.RFC3339)
+	}
+
+	return lj
+}
+
+// updateDNS publishes or withdraws the hostname of the client from ev.
+func (h *leaseHooks) updateDNS(ctx context.Context, ev *leaseEvent) (err error) {
+	l := ev.lease
+	if l == nil || l.Hostname == "" || !l.IP.IsValid() {
+		return nil
+	}
+
+	id := leaseClientID(l)
+	switch ev.name {
+	case leaseEventGranted, leaseEventStaticAdded:
+		return h.updater.Publish(ctx, l.Hostname, l.IP, id)
+	default:
+		return h.updater.Withdraw(ctx, l.Hostname, l.IP, id)
+	}
+}
+
+// leaseClientID returns the identifier of the client holding l for the DHCID
+// records.  The DUID is preferred over the hardware address.
+func leaseClientID(l *dhcpsvc.Lease) (id ddns.ClientID) {
+	if len(l.DUID) > 0 {
+		return ddns.NewDUIDID(l.DUID)
+	}
+
+	return ddns.NewHWAddrID(l.HWAddr)
+}
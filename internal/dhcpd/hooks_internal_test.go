@@ -0,0 +1,134 @@
+package dhcpd
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/dhcpsvc"
+	"github.com/AdguardTeam/golibs/logutil/slogutil"
+	"github.com/AdguardTeam/golibs/testutil"
+	"github.com/AdguardTeam/golibs/timeutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLeaseHooks_post(t *testing.T) {
+	const testTimeout = 1 * time.Second
+
+	events := make(chan *leaseEventJSON, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ev := &leaseEventJSON{}
+		err := json.NewDecoder(r.Body).Decode(ev)
+		require.NoError(testutil.PanicT{}, err)
+
+		events <- ev
+	}))
+	t.Cleanup(srv.Close)
+
+	h, err := newLeaseHooks(&HooksConfig{
+		URL:     srv.URL,
+		Timeout: timeutil.Duration{Duration: testTimeout},
+	}, slogutil.NewDiscardLogger())
+	require.NoError(t, err)
+	require.NotNil(t, h)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	go h.serve(ctx)
+
+	expiry := time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC)
+	lease := &dhcpsvc.Lease{
+		Expiry:   expiry,
+		Hostname: "host",
+		HWAddr:   net.HardwareAddr{0xAA, 0xAA, 0xAA, 0xAA, 0xAA, 0xAA},
+		IP:       netip.MustParseAddr("192.168.10.100"),
+	}
+
+	// Shouldn't be posted, since the change doesn't concern a single lease.
+	h.onLeaseChanged(LeaseChangedRemovedAll, nil)
+	h.onLeaseChanged(LeaseChangedReleased, lease)
+
+	got, _ := testutil.RequireReceive(t, events, testTimeout)
+	assert.Equal(t, &leaseEventJSON{
+		Event:    leaseEventReleased,
+		HWAddr:   "aa:aa:aa:aa:aa:aa",
+		IP:       "192.168.10.100",
+		Hostname: "host",
+		Expires:  expiry.Format(time.RFC3339),
+	}, got)
+}
+
+func TestNewLeaseHooks(t *testing.T) {
+	l := slogutil.NewDiscardLogger()
+
+	h, err := newLeaseHooks(&HooksConfig{}, l)
+	require.NoError(t, err)
+
+	assert.Nil(t, h)
+
+	_, err = newLeaseHooks(&HooksConfig{
+		DDNS: &DDNSConfig{
+			Server: netip.MustParseAddrPort("192.0.2.53:53"),
+			Zone:   "lan.example",
+		},
+	}, l)
+	require.NoError(t, err)
+
+	_, err = newLeaseHooks(&HooksConfig{
+		DDNS: &DDNSConfig{
+			Server:      netip.MustParseAddrPort("192.0.2.53:53"),
+			Zone:        "lan.example",
+			TSIGKeyName: "key",
+			TSIGSecret:  "not base64",
+		},
+	}, l)
+	assert.Error(t, err)
+}
+
+func TestLeaseHooks_onPoolExhausted(t *testing.T) {
+	const testTimeout = 1 * time.Second
+
+	events := make(chan *poolEventJSON, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ev := &poolEventJSON{}
+		err := json.NewDecoder(r.Body).Decode(ev)
+		require.NoError(testutil.PanicT{}, err)
+
+		events <- ev
+	}))
+	t.Cleanup(srv.Close)
+
+	h, err := newLeaseHooks(&HooksConfig{
+		URL:     srv.URL,
+		Timeout: timeutil.Duration{Duration: testTimeout},
+	}, slogutil.NewDiscardLogger())
+	require.NoError(t, err)
+	require.NotNil(t, h)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	go h.serve(ctx)
+
+	h.onPoolExhausted(&poolUsage{
+		size:            10,
+		leased:          8,
+		quarantined:     1,
+		nearlyExhausted: true,
+	})
+
+	got, _ := testutil.RequireReceive(t, events, testTimeout)
+	assert.Equal(t, &poolEventJSON{
+		Event:       leaseEventPoolExhausted,
+		Size:        10,
+		Leased:      8,
+		Quarantined: 1,
+	}, got)
+}
@@ -3,6 +3,8 @@
 package dhcpd
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -10,6 +12,7 @@ import (
 	"net/http"
 	"net/netip"
 	"os"
+	"path/filepath"
 	"slices"
 	"time"
 
@@ -18,6 +21,7 @@ import (
 	"github.com/AdguardTeam/AdGuardHome/internal/aghnet"
 	"github.com/AdguardTeam/AdGuardHome/internal/dhcpsvc"
 	"github.com/AdguardTeam/golibs/errors"
+	"github.com/AdguardTeam/golibs/httphdr"
 	"github.com/AdguardTeam/golibs/log"
 	"github.com/AdguardTeam/golibs/netutil"
 )
@@ -75,6 +79,15 @@ type leaseStatic struct {
 	HWAddr   string     `json:"mac"`
 	IP       netip.Addr `json:"ip"`
 	Hostname string     `json:"hostname"`
+
+	// CircuitID and RemoteID are the relay agent information suboptions the
+	// lease is bound to.  They are only supported in the additional scopes.
+	CircuitID string `json:"circuit_id,omitempty"`
+	RemoteID  string `json:"remote_id,omitempty"`
+
+	// Options are the DHCPv4 options for the client in the same format as
+	// [V4ServerConf.Options].
+	Options []string `json:"options,omitempty"`
 }
 
 // leasesToStatic converts list of leases to their JSON form.
@@ -83,9 +96,12 @@ func leasesToStatic(leases []*dhcpsvc.Lease) (static []*leaseStatic) {
 
 	for i, l := range leases {
 		static[i] = &leaseStatic{
-			HWAddr:   l.HWAddr.String(),
-			IP:       l.IP,
-			Hostname: l.Hostname,
+			HWAddr:    l.HWAddr.String(),
+			IP:        l.IP,
+			Hostname:  l.Hostname,
+			CircuitID: l.CircuitID,
+			RemoteID:  l.RemoteID,
+			Options:   formatScopeOptions(l.Options),
 		}
 	}
 
@@ -99,11 +115,19 @@ func (l *leaseStatic) toLease() (lease *dhcpsvc.Lease, err error) {
 		return nil, fmt.Errorf("couldn't parse MAC address: %w", err)
 	}
 
+	opts, err := parseHostOptions(l.Options)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't parse options: %w", err)
+	}
+
 	return &dhcpsvc.Lease{
-		HWAddr:   addr,
-		IP:       l.IP,
-		Hostname: l.Hostname,
-		IsStatic: true,
+		HWAddr:    addr,
+		IP:        l.IP,
+		Hostname:  l.Hostname,
+		CircuitID: l.CircuitID,
+		RemoteID:  l.RemoteID,
+		Options:   opts,
+		IsStatic:  true,
 	}, nil
 }
 
@@ -113,6 +137,10 @@ type leaseDynamic struct {
 	IP       netip.Addr `json:"ip"`
 	Hostname string     `json:"hostname"`
 	Expiry   string     `json:"expires"`
+
+	// Prefix is the IPv6 prefix delegated to the client, if any.  IP is empty
+	// for such leases.
+	Prefix string `json:"prefix,omitempty"`
 }
 
 // leasesToDynamic converts list of leases to their JSON form.
@@ -120,6 +148,11 @@ func leasesToDynamic(leases []*dhcpsvc.Lease) (dynamic []*leaseDynamic) {
 	dynamic = make([]*leaseDynamic, len(leases))
 
 	for i, l := range leases {
+		var pref string
+		if l.Prefix.IsValid() {
+			pref = l.Prefix.String()
+		}
+
 		dynamic[i] = &leaseDynamic{
 			HWAddr:   l.HWAddr.String(),
 			IP:       l.IP,
@@ -129,6 +162,7 @@ func leasesToDynamic(leases []*dhcpsvc.Lease) (dynamic []*leaseDynamic) {
 			//
 			// See https://github.com/AdguardTeam/AdGuardHome/issues/2692.
 			Expiry: l.Expiry.Format(time.RFC3339),
+			Prefix: pref,
 		}
 	}
 
@@ -242,15 +276,32 @@ func (s *server) handleDHCPSetConfigV4(
 
 	// Set the default values for the fields not configurable via web API.
 	c4 := &V4ServerConf{
-		notify:      s.onNotify,
-		ICMPTimeout: s.conf.Conf4.ICMPTimeout,
-		Options:     s.conf.Conf4.Options,
+		notify:        s.onNotify,
+		notifyLease:   s.onLeaseNotify,
+		notifyPool:    s.onPoolExhausted,
+		handleRelayed: s.handleRelayedV4,
+		arpDB:         s.conf.ARPDB,
+		ICMPTimeout:   s.conf.Conf4.ICMPTimeout,
+		Options:       s.conf.Conf4.Options,
+		Boot:          s.conf.Conf4.Boot,
+		Classes:       s.conf.Conf4.Classes,
+
+		QuarantineDuration:   s.conf.Conf4.QuarantineDuration,
+		PoolWarningThreshold: s.conf.Conf4.PoolWarningThreshold,
 	}
 
 	s.srv4.WriteDiskConfig4(c4)
 	v4Conf.notify = c4.notify
+	v4Conf.notifyLease = c4.notifyLease
+	v4Conf.notifyPool = c4.notifyPool
+	v4Conf.handleRelayed = c4.handleRelayed
+	v4Conf.arpDB = c4.arpDB
 	v4Conf.ICMPTimeout = c4.ICMPTimeout
+	v4Conf.QuarantineDuration = c4.QuarantineDuration
+	v4Conf.PoolWarningThreshold = c4.PoolWarningThreshold
 	v4Conf.Options = c4.Options
+	v4Conf.Boot = c4.Boot
+	v4Conf.Classes = c4.Classes
 
 	srv4, err := v4Create(v4Conf)
 
@@ -270,16 +321,19 @@ func (s *server) handleDHCPSetConfigV6(
 		v6Conf.Enabled = false
 	}
 
-	// Don't overwrite the RA/SLAAC settings from the config file.
+	// Don't overwrite the RA/SLAAC and prefix delegation settings from the
+	// config file.
 	//
 	// TODO(a.garipov): Perhaps include them into the request to allow
 	// changing them from the HTTP API?
 	v6Conf.RASLAACOnly = s.conf.Conf6.RASLAACOnly
 	v6Conf.RAAllowSLAAC = s.conf.Conf6.RAAllowSLAAC
+	v6Conf.PrefixDelegation = s.conf.Conf6.PrefixDelegation
 
 	enabled = v6Conf.Enabled
 	v6Conf.InterfaceName = conf.InterfaceName
 	v6Conf.notify = s.onNotify
+	v6Conf.notifyLease = s.onLeaseNotify
 
 	srv6, err = v6Create(v6Conf)
 
@@ -327,6 +381,15 @@ func (s *server) handleDHCPSetConfig(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Validate the scopes against the new configuration before stopping
+	// anything, since the main interface must not be used by them.
+	_, err = scopesConfig(s.confWithJSON(conf))
+	if err != nil {
+		aghhttp.Error(r, w, http.StatusBadRequest, "%s", err)
+
+		return
+	}
+
 	err = s.Stop()
 	if err != nil {
 		aghhttp.Error(r, w, http.StatusInternalServerError, "stopping dhcp: %s", err)
@@ -334,7 +397,17 @@ func (s *server) handleDHCPSetConfig(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	prevConf, prevSrv4, prevSrv6 := *s.conf, s.srv4, s.srv6
 	s.setConfFromJSON(conf, srv4, srv6)
+
+	err = s.setScopes(s.conf.Scopes)
+	if err != nil {
+		s.restoreConf(&prevConf, prevSrv4, prevSrv6)
+		aghhttp.Error(r, w, http.StatusInternalServerError, "%s", err)
+
+		return
+	}
+
 	s.conf.ConfigModified()
 
 	err = s.dbLoad()
@@ -353,17 +426,29 @@ func (s *server) handleDHCPSetConfig(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-// setConfFromJSON sets configuration parameters in s from the new configuration
-// decoded from JSON.
-func (s *server) setConfFromJSON(conf *dhcpServerConfigJSON, srv4, srv6 DHCPServer) {
+// confWithJSON returns a copy of the configuration of s with the parameters
+// from the new configuration decoded from JSON applied.
+func (s *server) confWithJSON(conf *dhcpServerConfigJSON) (c *ServerConfig) {
+	c = &ServerConfig{}
+	*c = *s.conf
+
 	if conf.Enabled != aghalg.NBNull {
-		s.conf.Enabled = conf.Enabled == aghalg.NBTrue
+		c.Enabled = conf.Enabled == aghalg.NBTrue
 	}
 
 	if conf.InterfaceName != "" {
-		s.conf.InterfaceName = conf.InterfaceName
+		c.InterfaceName = conf.InterfaceName
 	}
 
+	return c
+}
+
+// setConfFromJSON sets configuration parameters in s from the new configuration
+// decoded from JSON.
+func (s *server) setConfFromJSON(conf *dhcpServerConfigJSON, srv4, srv6 DHCPServer) {
+	c := s.confWithJSON(conf)
+	s.conf.Enabled, s.conf.InterfaceName = c.Enabled, c.InterfaceName
+
 	if srv4 != nil {
 		s.srv4 = srv4
 	}
@@ -373,6 +458,30 @@ func (s *server) setConfFromJSON(conf *dhcpServerConfigJSON, srv4, srv6 DHCPServ
 	}
 }
 
+// restoreConf restores the configuration and the servers of the stopped s to
+// the previous ones and starts them again, if the DHCP server was enabled.
+func (s *server) restoreConf(prevConf *ServerConfig, srv4, srv6 DHCPServer) {
+	s.conf.Enabled, s.conf.InterfaceName = prevConf.Enabled, prevConf.InterfaceName
+	s.srv4, s.srv6 = srv4, srv6
+
+	var err error
+	s.scopes, err = newScopes(s.conf, s.onLeaseNotify)
+	if err != nil {
+		log.Error("dhcp: restoring scopes: %s", err)
+
+		s.scopes = dhcpsvc.Empty{}
+	}
+
+	if !s.conf.Enabled {
+		return
+	}
+
+	err = s.Start()
+	if err != nil {
+		log.Error("dhcp: restarting after failed reconfiguration: %s", err)
+	}
+}
+
 type netInterfaceJSON struct {
 	Name         string       `json:"name"`
 	HardwareAddr string       `json:"hardware_address"`
@@ -623,18 +732,37 @@ func (s *server) parseLease(r io.Reader) (srv DHCPServer, lease *dhcpsvc.Lease,
 // handleDHCPAddStaticLease is the handler for the POST
 // /control/dhcp/add_static_lease HTTP API.
 func (s *server) handleDHCPAddStaticLease(w http.ResponseWriter, r *http.Request) {
-	srv, lease, err := s.parseLease(r.Body)
+	_, lease, err := s.parseLease(r.Body)
 	if err != nil {
 		aghhttp.Error(r, w, http.StatusBadRequest, "%s", err)
 
 		return
 	}
 
-	if err = srv.AddStaticLease(lease); err != nil {
+	err = s.addStaticLease(r.Context(), lease)
+	if err != nil {
 		aghhttp.Error(r, w, http.StatusBadRequest, "%s", err)
 	}
 }
 
+// addStaticLease adds the static lease into the additional scope containing it
+// or into the server of its address family.
+func (s *server) addStaticLease(ctx context.Context, lease *dhcpsvc.Lease) (err error) {
+	inScopes, err := s.isScopeLease(lease)
+	if err != nil {
+		// Don't wrap the error, because it's informative enough as is.
+		return err
+	}
+
+	if inScopes {
+		return s.scopes.AddLease(ctx, lease)
+	} else if lease.IP.Is4() {
+		return s.srv4.AddStaticLease(lease)
+	}
+
+	return s.srv6.AddStaticLease(lease)
+}
+
 // handleDHCPRemoveStaticLease is the handler for the POST
 // /control/dhcp/remove_static_lease HTTP API.
 func (s *server) handleDHCPRemoveStaticLease(w http.ResponseWriter, r *http.Request) {
@@ -645,7 +773,20 @@ func (s *server) handleDHCPRemoveStaticLease(w http.ResponseWriter, r *http.Requ
 		return
 	}
 
-	if err = srv.RemoveStaticLease(lease); err != nil {
+	inScopes, err := s.isScopeLease(lease)
+	if err != nil {
+		aghhttp.Error(r, w, http.StatusBadRequest, "%s", err)
+
+		return
+	}
+
+	if inScopes {
+		err = s.scopes.RemoveLease(r.Context(), lease)
+	} else {
+		err = srv.RemoveStaticLease(lease)
+	}
+
+	if err != nil {
 		aghhttp.Error(r, w, http.StatusBadRequest, "%s", err)
 	}
 }
@@ -660,9 +801,195 @@ func (s *server) handleDHCPUpdateStaticLease(w http.ResponseWriter, r *http.Requ
 		return
 	}
 
-	if err = srv.UpdateStaticLease(lease); err != nil {
+	inScopes, err := s.isScopeLease(lease)
+	if err != nil {
 		aghhttp.Error(r, w, http.StatusBadRequest, "%s", err)
+
+		return
 	}
+
+	if inScopes {
+		err = s.scopes.UpdateStaticLease(r.Context(), lease)
+	} else {
+		err = srv.UpdateStaticLease(lease)
+	}
+
+	if err != nil {
+		aghhttp.Error(r, w, http.StatusBadRequest, "%s", err)
+	}
+}
+
+// importLeaseError is the JSON form of an error of importing the lease from a
+// single line.
+type importLeaseError struct {
+	Error string `json:"error"`
+	Line  int    `json:"line"`
+}
+
+// importLeasesResp is the response to the POST
+// /control/dhcp/import_static_leases HTTP API.
+type importLeasesResp struct {
+	Errors   []*importLeaseError `json:"errors"`
+	Imported int                 `json:"imported"`
+}
+
+// handleDHCPImportStaticLeases is the handler for the POST
+// /control/dhcp/import_static_leases HTTP API.  The leases in the format from
+// the query are added one by one, so the valid ones are added even if some of
+// the others aren't.
+func (s *server) handleDHCPImportStaticLeases(w http.ResponseWriter, r *http.Request) {
+	f, err := parseLeaseFormat(r.URL.Query().Get("format"))
+	if err != nil {
+		aghhttp.Error(r, w, http.StatusBadRequest, "%s", err)
+
+		return
+	}
+
+	leases, lineErrs, err := parseLeases(f, r.Body)
+	if err != nil {
+		aghhttp.Error(r, w, http.StatusBadRequest, "%s", err)
+
+		return
+	}
+
+	imported := 0
+	for _, l := range leases {
+		err = s.addStaticLease(r.Context(), l.lease)
+		if err != nil {
+			lineErrs = append(lineErrs, &lineError{err: err, line: l.line})
+		} else {
+			imported++
+		}
+	}
+
+	slices.SortStableFunc(lineErrs, func(a, b *lineError) (res int) {
+		return a.line - b.line
+	})
+
+	resp := &importLeasesResp{
+		Errors:   make([]*importLeaseError, 0, len(lineErrs)),
+		Imported: imported,
+	}
+
+	for _, le := range lineErrs {
+		resp.Errors = append(resp.Errors, &importLeaseError{
+			Error: le.err.Error(),
+			Line:  le.line,
+		})
+	}
+
+	aghhttp.WriteJSONResponseOK(w, r, resp)
+}
+
+// handleDHCPExportLeases is the handler for the GET /control/dhcp/export_leases
+// HTTP API.  It writes both static and dynamic leases in the format from the
+// query.
+func (s *server) handleDHCPExportLeases(w http.ResponseWriter, r *http.Request) {
+	f, err := parseLeaseFormat(r.URL.Query().Get("format"))
+	if err != nil {
+		aghhttp.Error(r, w, http.StatusBadRequest, "%s", err)
+
+		return
+	}
+
+	leases := s.Leases()
+	slices.SortStableFunc(leases, func(a, b *dhcpsvc.Lease) (res int) {
+		return a.IP.Compare(b.IP)
+	})
+
+	b := &bytes.Buffer{}
+	err = writeLeases(b, f, leases)
+	if err != nil {
+		aghhttp.Error(r, w, http.StatusInternalServerError, "writing leases: %s", err)
+
+		return
+	}
+
+	w.Header().Set(httphdr.ContentType, aghhttp.HdrValTextPlain)
+	_, err = w.Write(b.Bytes())
+	if err != nil {
+		log.Debug("dhcp: writing exported leases: %s", err)
+	}
+}
+
+// conflictJSON is the JSON form of the address found to be in use by another
+// device.
+type conflictJSON struct {
+	IP               netip.Addr `json:"ip"`
+	HWAddr           string     `json:"mac"`
+	ClientHWAddr     string     `json:"client_mac"`
+	Reason           string     `json:"reason"`
+	Detected         string     `json:"detected"`
+	QuarantinedUntil string     `json:"quarantined_until"`
+}
+
+// poolUsageJSON is the JSON form of the usage of the DHCPv4 dynamic range.
+type poolUsageJSON struct {
+	Size            uint64 `json:"size"`
+	Leased          uint64 `json:"leased"`
+	Quarantined     uint64 `json:"quarantined"`
+	NearlyExhausted bool   `json:"nearly_exhausted"`
+}
+
+// conflictsResp is the response to the GET /control/dhcp/conflicts HTTP API.
+type conflictsResp struct {
+	Pool      *poolUsageJSON  `json:"pool"`
+	Conflicts []*conflictJSON `json:"conflicts"`
+}
+
+// handleDHCPConflicts is the handler for the GET /control/dhcp/conflicts HTTP
+// API.
+func (s *server) handleDHCPConflicts(w http.ResponseWriter, r *http.Request) {
+	srv4, ok := s.srv4.(*v4Server)
+	if !ok {
+		aghhttp.Error(r, w, http.StatusInternalServerError, "dhcpv4 server is not initialized")
+
+		return
+	}
+
+	conflicts, u := srv4.conflictList()
+	for _, c := range s.scopes.Conflicts() {
+		conflicts = append(conflicts, &addrConflict{
+			detected:     c.Detected,
+			until:        c.Until,
+			hwAddr:       c.HWAddr,
+			clientHWAddr: c.ClientHWAddr,
+			ip:           c.IP,
+			reason:       conflictReason(c.Reason),
+		})
+	}
+
+	slices.SortStableFunc(conflicts, func(a, b *addrConflict) (res int) {
+		return a.detected.Compare(b.detected)
+	})
+
+	resp := &conflictsResp{
+		Pool: &poolUsageJSON{
+			Size:            u.size,
+			Leased:          u.leased,
+			Quarantined:     u.quarantined,
+			NearlyExhausted: u.nearlyExhausted,
+		},
+		Conflicts: make([]*conflictJSON, 0, len(conflicts)),
+	}
+
+	for _, c := range conflicts {
+		var hwAddr string
+		if c.hwAddr != nil {
+			hwAddr = c.hwAddr.String()
+		}
+
+		resp.Conflicts = append(resp.Conflicts, &conflictJSON{
+			IP:               c.ip,
+			HWAddr:           hwAddr,
+			ClientHWAddr:     c.clientHWAddr.String(),
+			Reason:           string(c.reason),
+			Detected:         c.detected.Format(time.RFC3339),
+			QuarantinedUntil: c.until.Format(time.RFC3339),
+		})
+	}
+
+	aghhttp.WriteJSONResponseOK(w, r, resp)
 }
 
 func (s *server) handleReset(w http.ResponseWriter, r *http.Request) {
@@ -678,6 +1005,12 @@ func (s *server) handleReset(w http.ResponseWriter, r *http.Request) {
 		log.Error("dhcp: removing db: %s", err)
 	}
 
+	err = os.Remove(filepath.Join(s.conf.DataDir, scopesDataFilename))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		log.Error("dhcp: removing scopes db: %s", err)
+	}
+
+	s.scopes = dhcpsvc.Empty{}
 	s.conf = &ServerConfig{
 		ConfigModified: s.conf.ConfigModified,
 
@@ -685,6 +1018,9 @@ func (s *server) handleReset(w http.ResponseWriter, r *http.Request) {
 
 		LocalDomainName: s.conf.LocalDomainName,
 
+		Logger: s.conf.Logger,
+		ARPDB:  s.conf.ARPDB,
+
 		DataDir:    s.conf.DataDir,
 		dbFilePath: s.conf.dbFilePath,
 	}
@@ -693,12 +1029,16 @@ func (s *server) handleReset(w http.ResponseWriter, r *http.Request) {
 		LeaseDuration: DefaultDHCPLeaseTTL,
 		ICMPTimeout:   DefaultDHCPTimeoutICMP,
 		notify:        s.onNotify,
+		notifyLease:   s.onLeaseNotify,
+		notifyPool:    s.onPoolExhausted,
+		arpDB:         s.conf.ARPDB,
 	}
 	s.srv4, _ = v4Create(v4conf)
 
 	v6conf := V6ServerConf{
 		LeaseDuration: DefaultDHCPLeaseTTL,
 		notify:        s.onNotify,
+		notifyLease:   s.onLeaseNotify,
 	}
 	s.srv6, _ = v6Create(v6conf)
 
@@ -715,6 +1055,127 @@ func (s *server) handleResetLeases(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// scopeJSON is the JSON representation of an additional DHCPv4 scope with its
+// leases.
+type scopeJSON struct {
+	*ScopeConfig
+
+	Leases       []*leaseDynamic `json:"leases"`
+	StaticLeases []*leaseStatic  `json:"static_leases"`
+}
+
+// scopesJSON is the response to the GET /control/dhcp/scopes HTTP API.
+type scopesJSON struct {
+	Scopes []*scopeJSON `json:"scopes"`
+}
+
+// scopeRemoveJSON is the request to the POST /control/dhcp/scopes/remove HTTP
+// API.
+type scopeRemoveJSON struct {
+	InterfaceName string `json:"interface_name"`
+}
+
+// handleDHCPScopes is the handler for the GET /control/dhcp/scopes HTTP API.
+func (s *server) handleDHCPScopes(w http.ResponseWriter, r *http.Request) {
+	leases := s.scopes.Leases()
+
+	resp := &scopesJSON{
+		Scopes: make([]*scopeJSON, 0, len(s.conf.Scopes)),
+	}
+
+	for _, sc := range s.conf.Scopes {
+		subnet := sc.subnet()
+
+		var dynamic, static []*dhcpsvc.Lease
+		for _, l := range leases {
+			if !subnet.IsValid() || !subnet.Contains(l.IP) {
+				continue
+			} else if l.IsStatic {
+				static = append(static, l)
+			} else {
+				dynamic = append(dynamic, l)
+			}
+		}
+
+		resp.Scopes = append(resp.Scopes, &scopeJSON{
+			ScopeConfig:  sc,
+			Leases:       leasesToDynamic(dynamic),
+			StaticLeases: leasesToStatic(static),
+		})
+	}
+
+	aghhttp.WriteJSONResponseOK(w, r, resp)
+}
+
+// handleDHCPSetScope is the handler for the POST /control/dhcp/scopes/set HTTP
+// API.  It adds the scope or replaces the one with the same interface name.
+func (s *server) handleDHCPSetScope(w http.ResponseWriter, r *http.Request) {
+	sc := &ScopeConfig{}
+	err := json.NewDecoder(r.Body).Decode(sc)
+	if err != nil {
+		aghhttp.Error(r, w, http.StatusBadRequest, "decoding json: %s", err)
+
+		return
+	}
+
+	scopes := slices.Clone(s.conf.Scopes)
+	i := slices.IndexFunc(scopes, func(c *ScopeConfig) (ok bool) {
+		return c.InterfaceName == sc.InterfaceName
+	})
+	if i >= 0 {
+		scopes[i] = sc
+	} else {
+		scopes = append(scopes, sc)
+	}
+
+	s.applyScopes(w, r, scopes)
+}
+
+// handleDHCPRemoveScope is the handler for the POST /control/dhcp/scopes/remove
+// HTTP API.
+func (s *server) handleDHCPRemoveScope(w http.ResponseWriter, r *http.Request) {
+	req := &scopeRemoveJSON{}
+	err := json.NewDecoder(r.Body).Decode(req)
+	if err != nil {
+		aghhttp.Error(r, w, http.StatusBadRequest, "decoding json: %s", err)
+
+		return
+	}
+
+	scopes := slices.DeleteFunc(slices.Clone(s.conf.Scopes), func(c *ScopeConfig) (ok bool) {
+		return c.InterfaceName == req.InterfaceName
+	})
+	if len(scopes) == len(s.conf.Scopes) {
+		aghhttp.Error(r, w, http.StatusBadRequest, "no scope for interface %q", req.InterfaceName)
+
+		return
+	}
+
+	s.applyScopes(w, r, scopes)
+}
+
+// applyScopes replaces the additional scopes of s with scopes, starts them if
+// the server is enabled, and writes the error response, if any.
+func (s *server) applyScopes(w http.ResponseWriter, r *http.Request, scopes []*ScopeConfig) {
+	err := s.setScopes(scopes)
+	if err != nil {
+		aghhttp.Error(r, w, http.StatusBadRequest, "%s", err)
+
+		return
+	}
+
+	s.conf.ConfigModified()
+
+	if !s.conf.Enabled {
+		return
+	}
+
+	err = s.scopes.Start(r.Context())
+	if err != nil {
+		aghhttp.Error(r, w, http.StatusInternalServerError, "starting scopes: %s", err)
+	}
+}
+
 func (s *server) registerHandlers() {
 	if s.conf.HTTPRegister == nil {
 		return
@@ -727,6 +1188,12 @@ func (s *server) registerHandlers() {
 	s.conf.HTTPRegister(http.MethodPost, "/control/dhcp/add_static_lease", s.handleDHCPAddStaticLease)
 	s.conf.HTTPRegister(http.MethodPost, "/control/dhcp/remove_static_lease", s.handleDHCPRemoveStaticLease)
 	s.conf.HTTPRegister(http.MethodPost, "/control/dhcp/update_static_lease", s.handleDHCPUpdateStaticLease)
+	s.conf.HTTPRegister(http.MethodPost, "/control/dhcp/import_static_leases", s.handleDHCPImportStaticLeases)
+	s.conf.HTTPRegister(http.MethodGet, "/control/dhcp/export_leases", s.handleDHCPExportLeases)
+	s.conf.HTTPRegister(http.MethodGet, "/control/dhcp/conflicts", s.handleDHCPConflicts)
 	s.conf.HTTPRegister(http.MethodPost, "/control/dhcp/reset", s.handleReset)
 	s.conf.HTTPRegister(http.MethodPost, "/control/dhcp/reset_leases", s.handleResetLeases)
+	s.conf.HTTPRegister(http.MethodGet, "/control/dhcp/scopes", s.handleDHCPScopes)
+	s.conf.HTTPRegister(http.MethodPost, "/control/dhcp/scopes/set", s.handleDHCPSetScope)
+	s.conf.HTTPRegister(http.MethodPost, "/control/dhcp/scopes/remove", s.handleDHCPRemoveScope)
 }
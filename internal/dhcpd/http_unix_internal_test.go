@@ -8,6 +8,7 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"net/netip"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -317,3 +318,53 @@ func TestServer_HandleUpdateStaticLease_validation(t *testing.T) {
 		})
 	}
 }
+
+func TestServer_handleDHCPImportStaticLeases(t *testing.T) {
+	s, err := Create(&ServerConfig{
+		Enabled:        true,
+		Conf4:          *defaultV4ServerConf(),
+		Conf6:          V6ServerConf{},
+		DataDir:        t.TempDir(),
+		ConfigModified: func() {},
+	})
+	require.NoError(t, err)
+
+	const conf = `# Static leases.
+dhcp-host=11:11:11:11:11:11,192.168.10.10,printer,infinite
+dhcp-host=22:22:22:22:22:22,set:known,192.168.10.20
+dhcp-host=33:33:33:33:33:33,printer-2
+dhcp-host=44:44:44:44:44:44,10.0.0.1,outside
+domain=lan
+`
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodPost, "/control/dhcp/import_static_leases?format=dnsmasq", strings.NewReader(conf))
+	s.handleDHCPImportStaticLeases(w, r)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	resp := &importLeasesResp{}
+	err = json.NewDecoder(w.Body).Decode(resp)
+	require.NoError(t, err)
+
+	assert.Equal(t, 2, resp.Imported)
+	require.Len(t, resp.Errors, 2)
+
+	assert.Equal(t, 4, resp.Errors[0].Line)
+	assert.Equal(t, "no ip address", resp.Errors[0].Error)
+	assert.Equal(t, 5, resp.Errors[1].Line)
+
+	w = httptest.NewRecorder()
+	r = httptest.NewRequest(http.MethodGet, "/control/dhcp/export_leases?format=csv", nil)
+	s.handleDHCPExportLeases(w, r)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	assert.Equal(t, "mac,ip,hostname,expires\n"+
+		"11:11:11:11:11:11,192.168.10.10,printer,\n"+
+		"22:22:22:22:22:22,192.168.10.20,,\n", w.Body.String())
+
+	w = httptest.NewRecorder()
+	r = httptest.NewRequest(http.MethodGet, "/control/dhcp/export_leases?format=bad", nil)
+	s.handleDHCPExportLeases(w, r)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
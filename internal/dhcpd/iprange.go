@@ -113,6 +113,17 @@ func (r *ipRange) offset(ip net.IP) (offset uint64, ok bool) {
 	return offsetInt.Uint64(), true
 }
 
+// size returns the number of addresses within r.
+func (r *ipRange) size() (n uint64) {
+	if r == nil {
+		return 0
+	}
+
+	// Assume that the range was checked against maxRangeLen during
+	// construction.
+	return (&big.Int{}).Sub(r.end, r.start).Uint64() + 1
+}
+
 // String implements the fmt.Stringer interface for *ipRange.
 func (r *ipRange) String() (s string) {
 	return fmt.Sprintf("%s-%s", r.start, r.end)
@@ -147,3 +147,11 @@ func TestIPRange_Offset(t *testing.T) {
 		})
 	}
 }
+
+func TestIPRange_Size(t *testing.T) {
+	r, err := newIPRange(net.IP{192, 168, 0, 100}, net.IP{192, 168, 0, 200})
+	require.NoError(t, err)
+
+	assert.Equal(t, uint64(101), r.size())
+	assert.Equal(t, uint64(0), (*ipRange)(nil).size())
+}
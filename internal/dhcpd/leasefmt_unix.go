@@ -0,0 +1,586 @@
+//go:build darwin || freebsd || linux || openbsd
+
+package dhcpd
+
+import (
+	"bufio"
+	"cmp"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/dhcpsvc"
+	"github.com/AdguardTeam/golibs/errors"
+)
+
+// leaseFormat is the text format of the imported and exported leases.
+type leaseFormat string
+
+// Supported lease formats.
+const (
+	// leaseFormatCSV is the comma-separated values with the hardware address,
+	// the IP address, the hostname, and the expiration time of the lease, one
+	// lease per line.  The expiration time is empty for static leases.
+	leaseFormatCSV leaseFormat = "csv"
+
+	// leaseFormatDnsmasq is the dhcp-host lines of the dnsmasq configuration.
+	leaseFormatDnsmasq leaseFormat = "dnsmasq"
+
+	// leaseFormatISC is the host declarations of the ISC DHCP server
+	// configuration.
+	leaseFormatISC leaseFormat = "isc"
+)
+
+// parseLeaseFormat returns the lease format named s.
+func parseLeaseFormat(s string) (f leaseFormat, err error) {
+	switch f = leaseFormat(s); f {
+	case leaseFormatCSV, leaseFormatDnsmasq, leaseFormatISC:
+		return f, nil
+	default:
+		return "", fmt.Errorf("unsupported lease format %q", s)
+	}
+}
+
+// csvHeader is the header of the leases in [leaseFormatCSV].
+var csvHeader = []string{"mac", "ip", "hostname", "expires"}
+
+// lineLease is a static lease parsed from the imported text.
+type lineLease struct {
+	// lease is the parsed lease.
+	lease *dhcpsvc.Lease
+
+	// line is the number of the line the lease starts at.
+	line int
+}
+
+// lineError is an error related to a single line of the imported text.
+type lineError struct {
+	// err is the underlying error.
+	err error
+
+	// line is the number of the line.
+	line int
+}
+
+// type check
+var _ error = (*lineError)(nil)
+
+// Error implements the [error] interface for *lineError.
+func (e *lineError) Error() (msg string) {
+	return fmt.Sprintf("line %d: %s", e.line, e.err)
+}
+
+// type check
+var _ errors.Wrapper = (*lineError)(nil)
+
+// Unwrap implements the [errors.Wrapper] interface for *lineError.
+func (e *lineError) Unwrap() (unwrapped error) {
+	return e.err
+}
+
+// newImportedLease returns a new static lease with the given fields in text
+// form.  The values aren't validated beyond parsing, since the servers validate
+// the added leases themselves.
+func newImportedLease(mac, ip, hostname string) (l *dhcpsvc.Lease, err error) {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		// Don't wrap the error, because it's informative enough as is.
+		return nil, err
+	}
+
+	static := &leaseStatic{
+		HWAddr:   mac,
+		IP:       addr.Unmap(),
+		Hostname: hostname,
+	}
+
+	return static.toLease()
+}
+
+// parseLeases parses the static leases in format f from r.  lineErrs contains
+// the errors of parsing the separate leases, which are skipped.  err is
+// returned if r can't be read.
+func parseLeases(
+	f leaseFormat,
+	r io.Reader,
+) (leases []*lineLease, lineErrs []*lineError, err error) {
+	switch f {
+	case leaseFormatCSV:
+		return parseCSVLeases(r)
+	case leaseFormatDnsmasq:
+		return parseDnsmasqLeases(r)
+	case leaseFormatISC:
+		return parseISCLeases(r)
+	default:
+		panic(fmt.Errorf("parsing leases: %w: %q", errors.ErrBadEnumValue, f))
+	}
+}
+
+// parseCSVLeases parses the static leases in [leaseFormatCSV] from r.  The
+// header line is optional.  The dynamic leases, i.e. the ones with the
+// expiration time, are skipped.
+func parseCSVLeases(r io.Reader) (leases []*lineLease, lineErrs []*lineError, err error) {
+	cr := csv.NewReader(r)
+	cr.Comment = '#'
+	cr.FieldsPerRecord = -1
+	cr.TrimLeadingSpace = true
+
+	for {
+		var rec []string
+		rec, err = cr.Read()
+		if errors.Is(err, io.EOF) {
+			return leases, lineErrs, nil
+		}
+
+		if pe := (&csv.ParseError{}); errors.As(err, &pe) {
+			lineErrs = append(lineErrs, &lineError{err: pe.Err, line: pe.StartLine})
+
+			continue
+		} else if err != nil {
+			return nil, nil, fmt.Errorf("reading csv: %w", err)
+		}
+
+		line, _ := cr.FieldPos(0)
+		if strings.EqualFold(rec[0], csvHeader[0]) {
+			continue
+		}
+
+		if len(rec) < 2 || len(rec) > len(csvHeader) {
+			err = fmt.Errorf("want from 2 to %d fields, got %d", len(csvHeader), len(rec))
+			lineErrs = append(lineErrs, &lineError{err: err, line: line})
+
+			continue
+		}
+
+		rec = append(rec, "", "")
+		if rec[3] != "" {
+			continue
+		}
+
+		var l *dhcpsvc.Lease
+		l, err = newImportedLease(rec[0], rec[1], rec[2])
+		if err != nil {
+			lineErrs = append(lineErrs, &lineError{err: err, line: line})
+
+			continue
+		}
+
+		leases = append(leases, &lineLease{lease: l, line: line})
+	}
+}
+
+// dnsmasqHostPrefix is the prefix of the dnsmasq configuration lines
+// describing the hosts.
+const dnsmasqHostPrefix = "dhcp-host="
+
+// parseDnsmasqLeases parses the dhcp-host lines of the dnsmasq configuration
+// from r.  The other lines are skipped.
+func parseDnsmasqLeases(r io.Reader) (leases []*lineLease, lineErrs []*lineError, err error) {
+	s := bufio.NewScanner(r)
+	for line := 1; s.Scan(); line++ {
+		text := strings.TrimSpace(s.Text())
+		text = strings.TrimPrefix(text, "--")
+
+		fields, ok := strings.CutPrefix(text, dnsmasqHostPrefix)
+		if !ok {
+			continue
+		}
+
+		var l *dhcpsvc.Lease
+		l, err = parseDnsmasqHost(fields)
+		if err != nil {
+			lineErrs = append(lineErrs, &lineError{err: err, line: line})
+
+			continue
+		}
+
+		leases = append(leases, &lineLease{lease: l, line: line})
+	}
+
+	err = s.Err()
+	if err != nil {
+		return nil, nil, fmt.Errorf("reading dnsmasq configuration: %w", err)
+	}
+
+	return leases, lineErrs, nil
+}
+
+// parseDnsmasqHost parses the comma-separated fields of a single dhcp-host
+// line.  The tags, client identifiers, and lease times are ignored.
+//
+// See https://thekelleys.org.uk/dnsmasq/docs/dnsmasq-man.html.
+func parseDnsmasqHost(fields string) (l *dhcpsvc.Lease, err error) {
+	var mac, ip, hostname string
+	for _, f := range strings.Split(fields, ",") {
+		f = strings.TrimSpace(f)
+
+		switch {
+		case
+			f == "",
+			f == "infinite",
+			strings.HasPrefix(f, "id:"),
+			strings.HasPrefix(f, "set:"),
+			strings.HasPrefix(f, "tag:"),
+			strings.HasPrefix(f, "net:"),
+			isDnsmasqLeaseTime(f):
+			continue
+		case f == "ignore":
+			return nil, errors.Error("ignored hosts are not supported")
+		case strings.HasPrefix(f, "[") && strings.HasSuffix(f, "]"):
+			f = f[1 : len(f)-1]
+			err = setDnsmasqField(&ip, f, "ip address")
+		case isMAC(f):
+			err = setDnsmasqField(&mac, f, "hardware address")
+		case isIP(f):
+			err = setDnsmasqField(&ip, f, "ip address")
+		default:
+			err = setDnsmasqField(&hostname, f, "hostname")
+		}
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if mac == "" {
+		return nil, errors.Error("no hardware address")
+	} else if ip == "" {
+		return nil, errors.Error("no ip address")
+	}
+
+	return newImportedLease(mac, ip, hostname)
+}
+
+// setDnsmasqField sets the field of the dhcp-host line pointed by ptr to val,
+// if it isn't set yet.  name is used in the error message.
+func setDnsmasqField(ptr *string, val, name string) (err error) {
+	if *ptr != "" {
+		return fmt.Errorf("multiple values for %s: %q and %q", name, *ptr, val)
+	}
+
+	*ptr = val
+
+	return nil
+}
+
+// isDnsmasqLeaseTime returns true if s is a lease time, e.g. "45m" or "3600".
+func isDnsmasqLeaseTime(s string) (ok bool) {
+	s = strings.TrimRight(s, "smhdw")
+	_, err := strconv.ParseUint(s, 10, 32)
+
+	return err == nil
+}
+
+// isMAC returns true if s is a hardware address.
+func isMAC(s string) (ok bool) {
+	_, err := net.ParseMAC(s)
+
+	return err == nil
+}
+
+// isIP returns true if s is an IP address.
+func isIP(s string) (ok bool) {
+	_, err := netip.ParseAddr(s)
+
+	return err == nil
+}
+
+// iscToken is a single token of the ISC DHCP server configuration.
+type iscToken struct {
+	// text is the text of the token without the quotes.
+	text string
+
+	// line is the number of the line the token is at.
+	line int
+
+	// quoted is true if the token is a quoted string.
+	quoted bool
+}
+
+// tokenizeISC splits the ISC DHCP server configuration in data into tokens.
+// The comments are skipped.
+func tokenizeISC(data string) (tokens []*iscToken, err error) {
+	line := 1
+	for i := 0; i < len(data); {
+		switch c := data[i]; c {
+		case '\n':
+			line++
+			i++
+		case ' ', '\t', '\r':
+			i++
+		case '#':
+			end := strings.IndexByte(data[i:], '\n')
+			if end < 0 {
+				return tokens, nil
+			}
+
+			i += end
+		case '{', '}', ';', ',':
+			tokens = append(tokens, &iscToken{text: string(c), line: line})
+			i++
+		case '"':
+			end := strings.IndexByte(data[i+1:], '"')
+			if end < 0 {
+				return nil, &lineError{err: errors.Error("unterminated string"), line: line}
+			}
+
+			text := data[i+1 : i+1+end]
+			tokens = append(tokens, &iscToken{text: text, line: line, quoted: true})
+			line += strings.Count(text, "\n")
+			i += end + 2
+		default:
+			end := strings.IndexAny(data[i:], " \t\r\n{};,\"#")
+			if end < 0 {
+				end = len(data) - i
+			}
+
+			tokens = append(tokens, &iscToken{text: data[i : i+end], line: line})
+			i += end
+		}
+	}
+
+	return tokens, nil
+}
+
+// parseISCLeases parses the host declarations of the ISC DHCP server
+// configuration from r.  The declarations may be nested within other ones,
+// such as groups and subnets.  The other declarations are skipped.
+func parseISCLeases(r io.Reader) (leases []*lineLease, lineErrs []*lineError, err error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, nil, fmt.Errorf("reading isc configuration: %w", err)
+	}
+
+	tokens, err := tokenizeISC(string(data))
+	if le := (&lineError{}); errors.As(err, &le) {
+		return nil, []*lineError{le}, nil
+	}
+
+	for i := 0; i < len(tokens); i++ {
+		t := tokens[i]
+		if t.quoted || t.text != "host" {
+			continue
+		}
+
+		var l *dhcpsvc.Lease
+		var n int
+		l, n, err = parseISCHost(tokens[i+1:])
+		if err != nil {
+			lineErrs = append(lineErrs, &lineError{err: err, line: t.line})
+		} else {
+			leases = append(leases, &lineLease{lease: l, line: t.line})
+		}
+
+		if n == 0 {
+			// The declaration isn't terminated, so there is nothing to parse
+			// after it.
+			break
+		}
+
+		i += n
+	}
+
+	return leases, lineErrs, nil
+}
+
+// parseISCHost parses the host declaration from tokens following the "host"
+// keyword.  n is the number of the tokens in the declaration, or zero if it
+// isn't terminated.
+//
+// See https://kb.isc.org/docs/isc-dhcp-44-manual-pages-dhcpdconf.
+func parseISCHost(tokens []*iscToken) (l *dhcpsvc.Lease, n int, err error) {
+	if len(tokens) < 2 || tokens[1].text != "{" || tokens[1].quoted {
+		return nil, min(len(tokens), 1), errors.Error("bad host declaration")
+	}
+
+	name := tokens[0].text
+
+	var mac, ip, hostname string
+	var stmt []*iscToken
+	for i, t := range tokens[2:] {
+		if !t.quoted {
+			switch t.text {
+			case "{":
+				return nil, 0, errors.Error("unexpected block within host declaration")
+			case "}":
+				if mac == "" {
+					err = errors.Error("no hardware ethernet statement")
+				} else if ip == "" {
+					err = errors.Error("no fixed-address statement")
+				} else {
+					hostname = cmp.Or(hostname, name)
+					l, err = newImportedLease(mac, ip, hostname)
+				}
+
+				return l, i + 3, err
+			case ";":
+				mac, ip, hostname = iscHostField(stmt, mac, ip, hostname)
+				stmt = stmt[:0]
+
+				continue
+			}
+		}
+
+		stmt = append(stmt, t)
+	}
+
+	return nil, 0, errors.Error("unterminated host declaration")
+}
+
+// iscHostField updates the fields of the host from the statement stmt, if it
+// describes one of them.
+func iscHostField(stmt []*iscToken, mac, ip, hostname string) (newMAC, newIP, newHost string) {
+	if len(stmt) < 2 {
+		return mac, ip, hostname
+	}
+
+	switch stmt[0].text {
+	case "hardware":
+		if len(stmt) == 3 && stmt[1].text == "ethernet" {
+			mac = stmt[2].text
+		}
+	case "fixed-address", "fixed-address6":
+		// Only the first address of the list is used.
+		ip = stmt[1].text
+	case "option":
+		if len(stmt) == 3 && stmt[1].text == "host-name" {
+			hostname = stmt[2].text
+		}
+	}
+
+	return mac, ip, hostname
+}
+
+// writeLeases writes leases to w in format f.  The dynamic leases are written
+// as comments in the formats, which can't contain them, so that they aren't
+// imported back.  The delegated prefixes aren't written.
+func writeLeases(w io.Writer, f leaseFormat, leases []*dhcpsvc.Lease) (err error) {
+	switch f {
+	case leaseFormatCSV:
+		return writeCSVLeases(w, leases)
+	case leaseFormatDnsmasq:
+		return writeTextLeases(w, leases, dnsmasqHost)
+	case leaseFormatISC:
+		return writeTextLeases(w, leases, newISCHostFunc())
+	default:
+		panic(fmt.Errorf("writing leases: %w: %q", errors.ErrBadEnumValue, f))
+	}
+}
+
+// writeCSVLeases writes leases to w in [leaseFormatCSV] with the header.
+func writeCSVLeases(w io.Writer, leases []*dhcpsvc.Lease) (err error) {
+	cw := csv.NewWriter(w)
+
+	err = cw.Write(csvHeader)
+	if err != nil {
+		return fmt.Errorf("writing header: %w", err)
+	}
+
+	for _, l := range leases {
+		if !l.IP.IsValid() {
+			continue
+		}
+
+		var expires string
+		if !l.IsStatic {
+			expires = l.Expiry.Format(time.RFC3339)
+		}
+
+		err = cw.Write([]string{l.HWAddr.String(), l.IP.String(), l.Hostname, expires})
+		if err != nil {
+			return fmt.Errorf("writing lease: %w", err)
+		}
+	}
+
+	cw.Flush()
+
+	return cw.Error()
+}
+
+// writeTextLeases writes leases to w using the text of the entry returned by
+// entry for each lease.
+func writeTextLeases(w io.Writer, leases []*dhcpsvc.Lease, entry func(l *dhcpsvc.Lease) string) (err error) {
+	b := &strings.Builder{}
+	for _, l := range leases {
+		if !l.IP.IsValid() {
+			continue
+		}
+
+		text := entry(l)
+		if l.IsStatic {
+			b.WriteString(text)
+
+			continue
+		}
+
+		_, _ = fmt.Fprintf(b, "# Dynamic lease, expires at %s.\n", l.Expiry.Format(time.RFC3339))
+		for _, line := range strings.SplitAfter(strings.TrimSuffix(text, "\n"), "\n") {
+			b.WriteString("# ")
+			b.WriteString(line)
+		}
+
+		b.WriteByte('\n')
+	}
+
+	_, err = io.WriteString(w, b.String())
+
+	return err
+}
+
+// dnsmasqHost returns the dhcp-host line of the dnsmasq configuration for l.
+func dnsmasqHost(l *dhcpsvc.Lease) (line string) {
+	ip := l.IP.String()
+	if l.IP.Is6() {
+		ip = "[" + ip + "]"
+	}
+
+	fields := []string{l.HWAddr.String(), ip}
+	if l.Hostname != "" {
+		fields = append(fields, l.Hostname)
+	}
+
+	return dnsmasqHostPrefix + strings.Join(fields, ",") + "\n"
+}
+
+// newISCHostFunc returns a function returning the host declaration of the ISC
+// DHCP server configuration for a lease.  The names of the declarations are
+// unique across the calls of the returned function.
+func newISCHostFunc() (f func(l *dhcpsvc.Lease) (decl string)) {
+	names := map[string]struct{}{}
+
+	return func(l *dhcpsvc.Lease) (decl string) {
+		base := cmp.Or(l.Hostname, strings.ReplaceAll(l.HWAddr.String(), ":", "-"))
+		name := base
+		for i := 1; ; i++ {
+			if _, ok := names[name]; !ok {
+				break
+			}
+
+			name = fmt.Sprintf("%s-%d", base, i)
+		}
+
+		names[name] = struct{}{}
+
+		b := &strings.Builder{}
+		_, _ = fmt.Fprintf(b, "host %s {\n", name)
+		_, _ = fmt.Fprintf(b, "\thardware ethernet %s;\n", l.HWAddr)
+		if l.IP.Is4() {
+			_, _ = fmt.Fprintf(b, "\tfixed-address %s;\n", l.IP)
+		} else {
+			_, _ = fmt.Fprintf(b, "\tfixed-address6 %s;\n", l.IP)
+		}
+
+		if l.Hostname != "" {
+			_, _ = fmt.Fprintf(b, "\toption host-name %q;\n", l.Hostname)
+		}
+
+		b.WriteString("}\n")
+
+		return b.String()
+	}
+}
@@ -0,0 +1,171 @@
+//go:build darwin || freebsd || linux || openbsd
+
+package dhcpd
+
+import (
+	"net"
+	"net/netip"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/dhcpsvc"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseLeases(t *testing.T) {
+	const iscConf = `subnet 192.168.1.0 netmask 255.255.255.0 {
+  range 192.168.1.100 192.168.1.200;
+
+  # host commented { hardware ethernet 00:00:00:00:00:00; }
+  host printer {
+    hardware ethernet 11:11:11:11:11:11;
+    fixed-address 192.168.1.10;
+  }
+
+  group {
+    host nas {
+      option host-name "storage";
+      hardware ethernet 22:22:22:22:22:22;
+      fixed-address 192.168.1.20, 192.168.1.21;
+    }
+  }
+
+  host broken {
+    fixed-address 192.168.1.30;
+  }
+}
+`
+
+	const csvConf = `mac,ip,hostname,expires
+11:11:11:11:11:11,192.168.1.10,printer
+22:22:22:22:22:22,192.168.1.20,nas,2024-01-01T00:00:00Z
+33:33:33:33:33:33,bad-ip,host
+`
+
+	testCases := []struct {
+		name        string
+		format      leaseFormat
+		in          string
+		wantLeases  []*lineLease
+		wantErrLine []int
+	}{{
+		name:   "isc",
+		format: leaseFormatISC,
+		in:     iscConf,
+		wantLeases: []*lineLease{{
+			lease: &dhcpsvc.Lease{
+				HWAddr:   net.HardwareAddr{0x11, 0x11, 0x11, 0x11, 0x11, 0x11},
+				IP:       netip.MustParseAddr("192.168.1.10"),
+				Hostname: "printer",
+				IsStatic: true,
+			},
+			line: 5,
+		}, {
+			lease: &dhcpsvc.Lease{
+				HWAddr:   net.HardwareAddr{0x22, 0x22, 0x22, 0x22, 0x22, 0x22},
+				IP:       netip.MustParseAddr("192.168.1.20"),
+				Hostname: "storage",
+				IsStatic: true,
+			},
+			line: 11,
+		}},
+		wantErrLine: []int{18},
+	}, {
+		name:   "csv",
+		format: leaseFormatCSV,
+		in:     csvConf,
+		wantLeases: []*lineLease{{
+			lease: &dhcpsvc.Lease{
+				HWAddr:   net.HardwareAddr{0x11, 0x11, 0x11, 0x11, 0x11, 0x11},
+				IP:       netip.MustParseAddr("192.168.1.10"),
+				Hostname: "printer",
+				IsStatic: true,
+			},
+			line: 2,
+		}},
+		wantErrLine: []int{4},
+	}, {
+		name:        "isc_unterminated",
+		format:      leaseFormatISC,
+		in:          "host a {\n  hardware ethernet 11:11:11:11:11:11;\n",
+		wantLeases:  nil,
+		wantErrLine: []int{1},
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			leases, lineErrs, err := parseLeases(tc.format, strings.NewReader(tc.in))
+			require.NoError(t, err)
+
+			assert.Equal(t, tc.wantLeases, leases)
+
+			errLines := make([]int, 0, len(lineErrs))
+			for _, le := range lineErrs {
+				errLines = append(errLines, le.line)
+			}
+
+			assert.Equal(t, tc.wantErrLine, errLines)
+		})
+	}
+}
+
+func TestWriteLeases(t *testing.T) {
+	leases := []*dhcpsvc.Lease{{
+		HWAddr:   net.HardwareAddr{0x11, 0x11, 0x11, 0x11, 0x11, 0x11},
+		IP:       netip.MustParseAddr("192.168.1.10"),
+		Hostname: "printer",
+		IsStatic: true,
+	}, {
+		HWAddr: net.HardwareAddr{0x22, 0x22, 0x22, 0x22, 0x22, 0x22},
+		IP:     netip.MustParseAddr("2001:db8::2"),
+		Expiry: time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC),
+	}, {
+		HWAddr: net.HardwareAddr{0x33, 0x33, 0x33, 0x33, 0x33, 0x33},
+		Prefix: netip.MustParsePrefix("2001:db8:1::/64"),
+	}}
+
+	testCases := []struct {
+		name   string
+		format leaseFormat
+		want   string
+	}{{
+		name:   "dnsmasq",
+		format: leaseFormatDnsmasq,
+		want: "dhcp-host=11:11:11:11:11:11,192.168.1.10,printer\n" +
+			"# Dynamic lease, expires at 2024-01-01T00:00:00Z.\n" +
+			"# dhcp-host=22:22:22:22:22:22,[2001:db8::2]\n",
+	}, {
+		name:   "isc",
+		format: leaseFormatISC,
+		want: "host printer {\n" +
+			"\thardware ethernet 11:11:11:11:11:11;\n" +
+			"\tfixed-address 192.168.1.10;\n" +
+			"\toption host-name \"printer\";\n" +
+			"}\n" +
+			"# Dynamic lease, expires at 2024-01-01T00:00:00Z.\n" +
+			"# host 22-22-22-22-22-22 {\n" +
+			"# \thardware ethernet 22:22:22:22:22:22;\n" +
+			"# \tfixed-address6 2001:db8::2;\n" +
+			"# }\n",
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			b := &strings.Builder{}
+			err := writeLeases(b, tc.format, leases)
+			require.NoError(t, err)
+
+			assert.Equal(t, tc.want, b.String())
+
+			// Only the static leases are imported back.
+			var imported []*lineLease
+			imported, _, err = parseLeases(tc.format, strings.NewReader(b.String()))
+			require.NoError(t, err)
+			require.Len(t, imported, 1)
+
+			assert.Equal(t, leases[0], imported[0].lease)
+		})
+	}
+}
@@ -3,6 +3,7 @@
 package dhcpd
 
 import (
+	"encoding/binary"
 	"encoding/hex"
 	"fmt"
 	"net"
@@ -14,6 +15,7 @@ import (
 	"github.com/AdguardTeam/golibs/log"
 	"github.com/AdguardTeam/golibs/netutil"
 	"github.com/AdguardTeam/golibs/timeutil"
+	"github.com/google/gopacket/layers"
 	"github.com/insomniacslk/dhcp/dhcpv4"
 )
 
@@ -194,6 +196,65 @@ func parseDHCPOption(s string) (code dhcpv4.OptionCode, val dhcpv4.OptionValue,
 	return dhcpv4.GenericOptionCode(code64), val, nil
 }
 
+// parseScopeOptions parses the custom options of an additional scope.  The
+// options with the del type become the zero-length options, which delete the
+// corresponding implicit ones.
+func parseScopeOptions(opts []string) (parsed layers.DHCPOptions, err error) {
+	for _, o := range opts {
+		var code dhcpv4.OptionCode
+		var val dhcpv4.OptionValue
+		code, val, err = parseDHCPOption(o)
+		if err != nil {
+			// Don't wrap the error, because it's informative enough as is.
+			return nil, err
+		}
+
+		parsed = append(parsed, layers.NewDHCPOption(layers.DHCPOpt(code.Code()), val.ToBytes()))
+	}
+
+	return parsed, nil
+}
+
+// parseHostOptions parses the options specific to a client, such as the ones
+// of a static lease or a client class.  Unlike [parseScopeOptions], it requires
+// the lease time option, if any, to be positive, since it also sets the
+// duration of the lease.
+func parseHostOptions(opts []string) (parsed layers.DHCPOptions, err error) {
+	parsed, err = parseScopeOptions(opts)
+	if err != nil {
+		// Don't wrap the error, because it's informative enough as is.
+		return nil, err
+	}
+
+	for _, o := range parsed {
+		if o.Type != layers.DHCPOptLeaseTime {
+			continue
+		}
+
+		if len(o.Data) != 4 {
+			return nil, fmt.Errorf("lease time: bad data length %d, want 4", len(o.Data))
+		} else if binary.BigEndian.Uint32(o.Data) == 0 {
+			return nil, errors.Error("lease time: must be positive")
+		}
+	}
+
+	return parsed, nil
+}
+
+// formatScopeOptions returns the string form of opts, which is parsed back by
+// [parseScopeOptions].
+func formatScopeOptions(opts layers.DHCPOptions) (strs []string) {
+	for _, o := range opts {
+		if len(o.Data) == 0 {
+			strs = append(strs, fmt.Sprintf("%d %s", o.Type, typDel))
+		} else {
+			strs = append(strs, fmt.Sprintf("%d %s %x", o.Type, typHex, o.Data))
+		}
+	}
+
+	return strs
+}
+
 // prepareOptions builds the set of DHCP options according to host requirements
 // document and values from conf.
 func (s *v4Server) prepareOptions() {
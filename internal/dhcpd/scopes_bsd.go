@@ -0,0 +1,14 @@
+//go:build darwin || freebsd || openbsd
+
+package dhcpd
+
+import "github.com/AdguardTeam/golibs/errors"
+
+// validateScopesOS returns an error, since serving several network interfaces
+// on the same port requires binding the sockets to the interfaces, which isn't
+// supported on this platform yet.
+//
+// TODO(e.burkov):  Use IP_BOUND_IF on macOS and IP_RECVIF on BSDs.
+func validateScopesOS() (err error) {
+	return errors.Error("additional scopes are only supported on linux")
+}
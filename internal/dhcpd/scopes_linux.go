@@ -0,0 +1,9 @@
+//go:build linux
+
+package dhcpd
+
+// validateScopesOS returns nil, since the sockets can be bound to the network
+// interfaces on Linux.
+func validateScopesOS() (err error) {
+	return nil
+}
@@ -0,0 +1,175 @@
+//go:build linux
+
+package dhcpd
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/aghalg"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestServer_handleDHCPScopes(t *testing.T) {
+	s, err := Create(&ServerConfig{
+		Enabled:         false,
+		InterfaceName:   "eth0",
+		LocalDomainName: "lan",
+		Conf4:           *defaultV4ServerConf(),
+		DataDir:         t.TempDir(),
+		ConfigModified:  func() {},
+	})
+	require.NoError(t, err)
+
+	guest := &ScopeConfig{
+		InterfaceName: "eth0.20",
+		GatewayIP:     netip.MustParseAddr("192.168.20.1"),
+		SubnetMask:    netip.MustParseAddr("255.255.255.0"),
+		RangeStart:    netip.MustParseAddr("192.168.20.100"),
+		RangeEnd:      netip.MustParseAddr("192.168.20.200"),
+		Options:       []string{"6 ips 192.168.20.1"},
+		LeaseDuration: 3600,
+		Classes: []*ClientClassConfig{{
+			Name:        "pxe",
+			VendorClass: "PXEClient",
+			Options:     []string{"67 text pxelinux.0"},
+			Boot:        &BootConfig{FilenameUEFIx64: "ipxe.efi"},
+		}},
+		Boot: &BootConfig{
+			NextServer: netip.MustParseAddr("192.168.20.2"),
+			Filename:   "undionly.kpxe",
+		},
+	}
+
+	post := func(t *testing.T, handler http.HandlerFunc, body any) (w *httptest.ResponseRecorder) {
+		t.Helper()
+
+		b := &bytes.Buffer{}
+		err = json.NewEncoder(b).Encode(body)
+		require.NoError(t, err)
+
+		r, reqErr := http.NewRequest(http.MethodPost, "", b)
+		require.NoError(t, reqErr)
+
+		w = httptest.NewRecorder()
+		handler(w, r)
+
+		return w
+	}
+
+	getScopes := func(t *testing.T) (resp *scopesJSON) {
+		t.Helper()
+
+		r, reqErr := http.NewRequest(http.MethodGet, "", nil)
+		require.NoError(t, reqErr)
+
+		w := httptest.NewRecorder()
+		s.handleDHCPScopes(w, r)
+		require.Equal(t, http.StatusOK, w.Code)
+
+		resp = &scopesJSON{}
+		err = json.NewDecoder(w.Body).Decode(resp)
+		require.NoError(t, err)
+
+		return resp
+	}
+
+	t.Run("set", func(t *testing.T) {
+		w := post(t, s.handleDHCPSetScope, guest)
+		require.Equal(t, http.StatusOK, w.Code)
+
+		resp := getScopes(t)
+		require.Len(t, resp.Scopes, 1)
+
+		assert.Equal(t, guest, resp.Scopes[0].ScopeConfig)
+		assert.Empty(t, resp.Scopes[0].Leases)
+	})
+
+	t.Run("replace", func(t *testing.T) {
+		replaced := *guest
+		replaced.LeaseDuration = 7200
+
+		w := post(t, s.handleDHCPSetScope, &replaced)
+		require.Equal(t, http.StatusOK, w.Code)
+
+		resp := getScopes(t)
+		require.Len(t, resp.Scopes, 1)
+
+		assert.Equal(t, uint32(7200), resp.Scopes[0].LeaseDuration)
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		bad := *guest
+		bad.InterfaceName = "eth0.30"
+		bad.Options = []string{"bad option"}
+
+		w := post(t, s.handleDHCPSetScope, &bad)
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+
+		dup := *guest
+		dup.InterfaceName = "eth0"
+
+		w = post(t, s.handleDHCPSetScope, &dup)
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+
+		noCriteria := *guest
+		noCriteria.Classes = []*ClientClassConfig{{Name: "all"}}
+
+		w = post(t, s.handleDHCPSetScope, &noCriteria)
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+
+		assert.Len(t, getScopes(t).Scopes, 1)
+	})
+
+	t.Run("set_config_scope_interface", func(t *testing.T) {
+		w := post(t, s.handleDHCPSetConfig, &dhcpServerConfigJSON{
+			InterfaceName: guest.InterfaceName,
+			Enabled:       aghalg.NBFalse,
+		})
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+
+		assert.Equal(t, "eth0", s.conf.InterfaceName)
+		assert.Len(t, getScopes(t).Scopes, 1)
+	})
+
+	t.Run("static_lease", func(t *testing.T) {
+		lease := &leaseStatic{
+			HWAddr:    "aa:aa:aa:aa:aa:aa",
+			IP:        netip.MustParseAddr("192.168.20.10"),
+			Hostname:  "relayed-client",
+			CircuitID: "port-1",
+		}
+
+		// The scopes aren't running, since the server is disabled.
+		w := post(t, s.handleDHCPAddStaticLease, lease)
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+
+		lease.IP = netip.MustParseAddr("192.168.10.10")
+		w = post(t, s.handleDHCPAddStaticLease, lease)
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+
+		lease.CircuitID = ""
+		lease.Options = []string{"51 dur 0s"}
+		w = post(t, s.handleDHCPAddStaticLease, lease)
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+
+		lease.Options = []string{"6 ips 1.1.1.1"}
+		w = post(t, s.handleDHCPAddStaticLease, lease)
+		assert.Equal(t, http.StatusOK, w.Code)
+	})
+
+	t.Run("remove", func(t *testing.T) {
+		w := post(t, s.handleDHCPRemoveScope, &scopeRemoveJSON{InterfaceName: "eth0.30"})
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+
+		w = post(t, s.handleDHCPRemoveScope, &scopeRemoveJSON{InterfaceName: guest.InterfaceName})
+		require.Equal(t, http.StatusOK, w.Code)
+
+		assert.Empty(t, getScopes(t).Scopes)
+	})
+}
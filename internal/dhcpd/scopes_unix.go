@@ -0,0 +1,280 @@
+//go:build darwin || freebsd || linux || openbsd
+
+package dhcpd
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/netip"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/dhcpsvc"
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/AdguardTeam/golibs/logutil/slogutil"
+)
+
+// scopesDataFilename is the name of the file with the leases of the additional
+// DHCPv4 scopes.
+const scopesDataFilename = "leases_scopes.json"
+
+// newScopes returns the DHCP service serving the additional scopes from conf.
+// notifyLease is called on the changes of the leases of the scopes.
+// svc is [dhcpsvc.Empty] if there are no scopes or the DHCP server is disabled.
+func newScopes(
+	conf *ServerConfig,
+	notifyLease func(flags uint32, l *dhcpsvc.Lease),
+) (svc dhcpsvc.Interface, err error) {
+	svcConf, err := scopesConfig(conf)
+	if err != nil {
+		// Don't wrap the error, because it's informative enough as is.
+		return nil, err
+	} else if svcConf == nil {
+		return dhcpsvc.Empty{}, nil
+	}
+
+	svcConf.OnLeaseEvent = func(_ context.Context, ev dhcpsvc.LeaseEvent, l *dhcpsvc.Lease) {
+		var flags uint32 = LeaseChangedAdded
+		if ev == dhcpsvc.LeaseEventReleased {
+			flags = LeaseChangedReleased
+		}
+
+		notifyLease(flags, l)
+	}
+
+	srv, err := dhcpsvc.New(context.Background(), svcConf)
+	if err != nil {
+		return nil, fmt.Errorf("creating scopes: %w", err)
+	}
+
+	return srv, nil
+}
+
+// scopesConfig returns the validated configuration of the DHCP service serving
+// the additional scopes from conf.  The scopes are validated even if the DHCP
+// server is disabled, but svcConf is nil in that case as well as if there are
+// no scopes.
+func scopesConfig(conf *ServerConfig) (svcConf *dhcpsvc.Config, err error) {
+	defer func() { err = errors.Annotate(err, "configuring scopes: %w") }()
+
+	if len(conf.Scopes) == 0 {
+		return nil, nil
+	}
+
+	err = validateScopesOS()
+	if err != nil {
+		// Don't wrap the error, because it's annotated with the deferred call.
+		return nil, err
+	}
+
+	ifaces := make(map[string]*dhcpsvc.InterfaceConfig, len(conf.Scopes))
+
+	var errs []error
+	for i, sc := range conf.Scopes {
+		name := sc.InterfaceName
+		if _, ok := ifaces[name]; ok || name == conf.InterfaceName {
+			errs = append(errs, fmt.Errorf("scope at index %d: duplicate interface %q", i, name))
+
+			continue
+		}
+
+		ifaces[name], err = sc.toInterfaceConfig()
+		if err != nil {
+			errs = append(errs, fmt.Errorf("scope at index %d: %w", i, err))
+		}
+	}
+
+	if err = errors.Join(errs...); err != nil {
+		return nil, err
+	}
+
+	l := conf.Logger
+	if l == nil {
+		l = slog.Default()
+	}
+
+	svcConf = &dhcpsvc.Config{
+		Interfaces:         ifaces,
+		Logger:             l.With(slogutil.KeyPrefix, "dhcp_scopes"),
+		LocalDomainName:    conf.LocalDomainName,
+		DBFilePath:         filepath.Join(conf.DataDir, scopesDataFilename),
+		ARPDB:              conf.ARPDB,
+		ICMPTimeout:        time.Duration(conf.Conf4.ICMPTimeout) * time.Millisecond,
+		QuarantineDuration: time.Duration(conf.Conf4.QuarantineDuration) * time.Second,
+		Enabled:            true,
+	}
+
+	err = svcConf.Validate()
+	if err != nil {
+		// Don't wrap the error, because it's informative enough as is.
+		return nil, err
+	} else if !conf.Enabled {
+		return nil, nil
+	}
+
+	return svcConf, nil
+}
+
+// toInterfaceConfig converts sc into the DHCP service configuration of the
+// network interface.
+func (sc *ScopeConfig) toInterfaceConfig() (ic *dhcpsvc.InterfaceConfig, err error) {
+	if sc.InterfaceName == "" {
+		return nil, errors.Error("empty interface name")
+	}
+
+	opts, err := parseScopeOptions(sc.Options)
+	if err != nil {
+		return nil, fmt.Errorf("interface %q: %w", sc.InterfaceName, err)
+	}
+
+	classes := make([]*dhcpsvc.ClientClass, 0, len(sc.Classes))
+	for i, cc := range sc.Classes {
+		var c *dhcpsvc.ClientClass
+		c, err = cc.toClientClass()
+		if err != nil {
+			return nil, fmt.Errorf("interface %q: class at index %d: %w", sc.InterfaceName, i, err)
+		}
+
+		classes = append(classes, c)
+	}
+
+	leaseDur := sc.LeaseDuration
+	if leaseDur == 0 {
+		leaseDur = DefaultDHCPLeaseTTL
+	}
+
+	return &dhcpsvc.InterfaceConfig{
+		IPv4: &dhcpsvc.IPv4Config{
+			GatewayIP:     sc.GatewayIP,
+			SubnetMask:    sc.SubnetMask,
+			RangeStart:    sc.RangeStart,
+			RangeEnd:      sc.RangeEnd,
+			Options:       opts,
+			ServerIP:      sc.ServerIP,
+			LeaseDuration: time.Duration(leaseDur) * time.Second,
+			Relayed:       sc.Relayed,
+			Classes:       classes,
+			Boot:          sc.Boot.toInternal(),
+			Enabled:       true,
+		},
+		IPv6: &dhcpsvc.IPv6Config{
+			Enabled: false,
+		},
+	}, nil
+}
+
+// toClientClass converts cc into the DHCP service client class.
+func (cc *ClientClassConfig) toClientClass() (c *dhcpsvc.ClientClass, err error) {
+	if cc == nil {
+		return nil, errors.Error("no class")
+	}
+
+	var prefix net.HardwareAddr
+	if cc.MACPrefix != "" {
+		prefix, err = parseMACPrefix(cc.MACPrefix)
+		if err != nil {
+			return nil, fmt.Errorf("mac prefix: %w", err)
+		}
+	}
+
+	opts, err := parseHostOptions(cc.Options)
+	if err != nil {
+		// Don't wrap the error, because it's informative enough as is.
+		return nil, err
+	}
+
+	return &dhcpsvc.ClientClass{
+		Name:            cc.Name,
+		HWAddrPrefix:    prefix,
+		VendorClass:     cc.VendorClass,
+		UserClass:       cc.UserClass,
+		HostnamePattern: cc.Hostname,
+		Options:         opts,
+		Boot:            cc.Boot.toInternal(),
+	}, nil
+}
+
+// parseMACPrefix parses the colon-, hyphen-, or dot-separated prefix of a
+// hardware address, e.g. "00:1a:2b".
+func parseMACPrefix(s string) (prefix net.HardwareAddr, err error) {
+	hexStr := strings.NewReplacer(":", "", "-", "", ".", "").Replace(s)
+	prefix, err = hex.DecodeString(hexStr)
+	if err != nil {
+		return nil, err
+	} else if len(prefix) == 0 {
+		return nil, errors.Error("empty prefix")
+	}
+
+	return prefix, nil
+}
+
+// subnet returns the subnet of sc.  It returns an invalid prefix if sc isn't
+// valid.
+func (sc *ScopeConfig) subnet() (subnet netip.Prefix) {
+	if !sc.SubnetMask.Is4() {
+		return netip.Prefix{}
+	}
+
+	maskLen, _ := net.IPMask(sc.SubnetMask.AsSlice()).Size()
+
+	return netip.PrefixFrom(sc.GatewayIP, maskLen).Masked()
+}
+
+// isScopeLease returns true if lease belongs to one of the additional scopes.
+// It returns an error if lease is bound to the relay agent information but
+// doesn't belong to a running scope, since only the scopes support it.
+func (s *server) isScopeLease(lease *dhcpsvc.Lease) (ok bool, err error) {
+	ok = slices.ContainsFunc(s.conf.Scopes, func(sc *ScopeConfig) (contains bool) {
+		subnet := sc.subnet()
+
+		return subnet.IsValid() && subnet.Contains(lease.IP)
+	})
+
+	if _, isEmpty := s.scopes.(dhcpsvc.Empty); ok && isEmpty {
+		return false, errors.Error("scopes are only available when dhcp server is enabled")
+	} else if ok {
+		return true, nil
+	}
+
+	if lease.CircuitID != "" || lease.RemoteID != "" {
+		return false, errors.Error("relay agent information is only supported in scopes")
+	}
+
+	return false, nil
+}
+
+// setScopes replaces the additional scopes of s with scopes.  The new scopes
+// aren't started.
+func (s *server) setScopes(scopes []*ScopeConfig) (err error) {
+	conf := *s.conf
+	conf.Scopes = scopes
+
+	// Validate the configuration before stopping the current scopes.
+	_, err = scopesConfig(&conf)
+	if err != nil {
+		// Don't wrap the error, because it's informative enough as is.
+		return err
+	}
+
+	err = s.scopes.Shutdown(context.Background())
+	if err != nil {
+		return fmt.Errorf("stopping scopes: %w", err)
+	}
+
+	s.conf.Scopes = scopes
+
+	s.scopes, err = newScopes(s.conf, s.onLeaseNotify)
+	if err != nil {
+		s.scopes = dhcpsvc.Empty{}
+
+		// Don't wrap the error, because it's informative enough as is.
+		return err
+	}
+
+	return nil
+}
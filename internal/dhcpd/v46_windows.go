@@ -30,5 +30,9 @@ func (winServer) Stop() (err error)                                    { return
 func (winServer) HostByIP(_ netip.Addr) (host string)                  { return "" }
 func (winServer) IPByHost(_ string) (ip netip.Addr)                    { return netip.Addr{} }
 
+func newScopes(_ *ServerConfig, _ func(uint32, *dhcpsvc.Lease)) (svc dhcpsvc.Interface, err error) {
+	return dhcpsvc.Empty{}, nil
+}
+
 func v4Create(_ *V4ServerConf) (s DHCPServer, err error) { return winServer{}, nil }
 func v6Create(_ V6ServerConf) (s DHCPServer, err error)  { return winServer{}, nil }
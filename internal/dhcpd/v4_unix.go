@@ -19,6 +19,7 @@ import (
 	"github.com/AdguardTeam/golibs/netutil"
 	"github.com/AdguardTeam/golibs/timeutil"
 	"github.com/go-ping/ping"
+	"github.com/google/gopacket/layers"
 	"github.com/insomniacslk/dhcp/dhcpv4"
 	"github.com/insomniacslk/dhcp/dhcpv4/server4"
 )
@@ -39,7 +40,8 @@ type v4Server struct {
 	// have intersections with [implicitOpts].
 	explicitOpts dhcpv4.Options
 
-	// leasesLock protects leases, hostsIndex, ipIndex, and leasedOffsets.
+	// leasesLock protects leases, hostsIndex, ipIndex, leasedOffsets,
+	// conflicts, and poolWarned.
 	leasesLock sync.Mutex
 
 	// leasedOffsets contains offsets from conf.ipRange.start that have been
@@ -54,12 +56,31 @@ type v4Server struct {
 
 	// ipIndex is an index of leases by their IP addresses.
 	ipIndex map[netip.Addr]*dhcpsvc.Lease
+
+	// conflicts are the quarantined addresses found to be in use by other
+	// devices.
+	conflicts map[netip.Addr]*addrConflict
+
+	// classes are the client classes prepared from the configuration.
+	classes []*v4Class
+
+	// poolWarned is true if the warning about the exhaustion of the dynamic
+	// range is already logged.
+	poolWarned bool
 }
 
 func (s *v4Server) enabled() (ok bool) {
 	return s.conf != nil && s.conf.Enabled
 }
 
+// notifyLease signals the change of the single lease l to the other
+// components, if needed.
+func (s *v4Server) notifyLease(flags uint32, l *dhcpsvc.Lease) {
+	if s.conf.notifyLease != nil {
+		s.conf.notifyLease(flags, l.Clone())
+	}
+}
+
 // WriteDiskConfig4 - write configuration
 func (s *v4Server) WriteDiskConfig4(c *V4ServerConf) {
 	if s.conf != nil {
@@ -155,8 +176,11 @@ func (s *v4Server) ResetLeases(leases []*dhcpsvc.Lease) (err error) {
 	s.leasedOffsets = newBitSet()
 	s.hostsIndex = make(map[string]*dhcpsvc.Lease, len(leases))
 	s.ipIndex = make(map[netip.Addr]*dhcpsvc.Lease, len(leases))
+	prevConflicts := s.conflicts
+	s.conflicts = map[netip.Addr]*addrConflict{}
 	s.leases = nil
 
+	now := time.Now()
 	for _, l := range leases {
 		if !l.IsStatic {
 			l.Hostname = s.validHostnameForClient(l.Hostname, l.IP)
@@ -168,6 +192,10 @@ func (s *v4Server) ResetLeases(leases []*dhcpsvc.Lease) (err error) {
 
 			continue
 		}
+
+		if s.isBlocklisted(l) {
+			s.restoreConflict(l, prevConflicts, now)
+		}
 	}
 
 	return nil
@@ -236,7 +264,7 @@ func (s *v4Server) FindMACbyIP(ip netip.Addr) (mac net.HardwareAddr) {
 	s.leasesLock.Lock()
 	defer s.leasesLock.Unlock()
 
-	if l, ok := s.ipIndex[ip]; ok {
+	if l, ok := s.ipIndex[ip]; ok && !s.isBlocklisted(l) {
 		if l.IsStatic || l.Expiry.After(now) {
 			return l.HWAddr
 		}
@@ -248,13 +276,6 @@ func (s *v4Server) FindMACbyIP(ip netip.Addr) (mac net.HardwareAddr) {
 // defaultHwAddrLen is the default length of a hardware (MAC) address.
 const defaultHwAddrLen = 6
 
-// Add the specified IP to the black list for a time period
-func (s *v4Server) blocklistLease(l *dhcpsvc.Lease) {
-	l.HWAddr = make(net.HardwareAddr, defaultHwAddrLen)
-	l.Hostname = ""
-	l.Expiry = time.Now().Add(s.conf.leaseTime)
-}
-
 // rmLeaseByIndex removes a lease by its index in the leases slice.
 func (s *v4Server) rmLeaseByIndex(i int) {
 	n := len(s.leases)
@@ -431,12 +452,14 @@ func (s *v4Server) AddStaticLease(l *dhcpsvc.Lease) (err error) {
 
 	s.conf.notify(LeaseChangedDBStore)
 	s.conf.notify(LeaseChangedAddedStatic)
+	s.notifyLease(LeaseChangedAddedStatic, l)
 
 	return nil
 }
 
 // UpdateStaticLease updates IP, hostname of the static lease.
 func (s *v4Server) UpdateStaticLease(l *dhcpsvc.Lease) (err error) {
+	var prev *dhcpsvc.Lease
 	defer func() {
 		if err != nil {
 			err = errors.Annotate(err, "dhcpv4: updating static lease: %w")
@@ -446,6 +469,8 @@ func (s *v4Server) UpdateStaticLease(l *dhcpsvc.Lease) (err error) {
 
 		s.conf.notify(LeaseChangedDBStore)
 		s.conf.notify(LeaseChangedRemovedStatic)
+		s.notifyLease(LeaseChangedRemovedStatic, prev)
+		s.notifyLease(LeaseChangedAddedStatic, l)
 	}()
 
 	s.leasesLock.Lock()
@@ -456,6 +481,8 @@ func (s *v4Server) UpdateStaticLease(l *dhcpsvc.Lease) (err error) {
 		return fmt.Errorf("can't find lease %s", l.HWAddr)
 	}
 
+	prev = found.Clone()
+
 	err = s.validateStaticLease(l)
 	if err != nil {
 		return err
@@ -553,6 +580,7 @@ func (s *v4Server) RemoveStaticLease(l *dhcpsvc.Lease) (err error) {
 
 		s.conf.notify(LeaseChangedDBStore)
 		s.conf.notify(LeaseChangedRemovedStatic)
+		s.notifyLease(LeaseChangedRemovedStatic, l)
 	}()
 
 	s.leasesLock.Lock()
@@ -657,9 +685,7 @@ func (s *v4Server) reserveLease(mac net.HardwareAddr) (l *dhcpsvc.Lease, err err
 			return nil, nil
 		}
 
-		copy(s.leases[i].HWAddr, mac)
-
-		return s.leases[i], nil
+		return s.reuseExpiredLease(s.leases[i], mac), nil
 	}
 
 	netIP, ok := netip.AddrFromSlice(nextIP)
@@ -677,10 +703,28 @@ func (s *v4Server) reserveLease(mac net.HardwareAddr) (l *dhcpsvc.Lease, err err
 	return l, nil
 }
 
-// commitLease refreshes l's values.  It takes the desired hostname into account
-// when setting it into the lease, but generates a unique one if the provided
-// can't be used.
-func (s *v4Server) commitLease(l *dhcpsvc.Lease, hostname string) {
+// reuseExpiredLease reassigns the expired dynamic lease l to the client with
+// mac.  The previous holder of l is reported as released, so that its hostname
+// is withdrawn.  s.leasesLock is expected to be locked.
+func (s *v4Server) reuseExpiredLease(l *dhcpsvc.Lease, mac net.HardwareAddr) (reused *dhcpsvc.Lease) {
+	if !s.isBlocklisted(l) {
+		s.notifyLease(LeaseChangedReleased, l)
+	}
+
+	if s.hostsIndex[l.Hostname] == l {
+		delete(s.hostsIndex, l.Hostname)
+	}
+
+	l.HWAddr = slices.Clone(mac)
+	l.Hostname = ""
+
+	return l
+}
+
+// commitLease refreshes l's values and extends it for leaseTime.  It takes the
+// desired hostname into account when setting it into the lease, but generates a
+// unique one if the provided can't be used.
+func (s *v4Server) commitLease(l *dhcpsvc.Lease, hostname string, leaseTime time.Duration) {
 	prev := l.Hostname
 	hostname = s.validHostnameForClient(hostname, l.IP)
 
@@ -694,14 +738,19 @@ func (s *v4Server) commitLease(l *dhcpsvc.Lease, hostname string) {
 			hostname = prev
 		}
 	}
-	if l.Hostname != hostname {
-		l.Hostname = hostname
-	}
-
-	l.Expiry = time.Now().Add(s.conf.leaseTime)
-	if prev != "" && prev != l.Hostname {
+	now := time.Now()
+	if prev != "" && prev != hostname {
 		delete(s.hostsIndex, prev)
+
+		if l.Expiry.After(now) {
+			// Report the previous hostname of the active lease as released, so
+			// that it's withdrawn.
+			s.notifyLease(LeaseChangedReleased, l)
+		}
 	}
+
+	l.Hostname = hostname
+	l.Expiry = now.Add(leaseTime)
 	if l.Hostname != "" {
 		s.hostsIndex[l.Hostname] = l
 	}
@@ -724,7 +773,7 @@ func (s *v4Server) allocateLease(mac net.HardwareAddr) (l *dhcpsvc.Lease, err er
 			return l, nil
 		}
 
-		s.blocklistLease(l)
+		s.blocklistLease(l, mac, conflictReasonICMP)
 	}
 }
 
@@ -973,6 +1022,9 @@ func (s *v4Server) handleRequest(req, resp *dhcpv4.DHCPv4) (lease *dhcpsvc.Lease
 	s.leasesLock.Lock()
 	defer s.leasesLock.Unlock()
 
+	// Report the lease after it's committed.
+	defer s.notifyLease(LeaseChangedAdded, lease)
+
 	if lease.IsStatic {
 		if lease.Hostname != "" {
 			// TODO(e.burkov):  This option is used to update the server's DNS
@@ -984,7 +1036,8 @@ func (s *v4Server) handleRequest(req, resp *dhcpv4.DHCPv4) (lease *dhcpsvc.Lease
 		return lease, needsReply
 	}
 
-	s.commitLease(lease, hostname)
+	s.commitLease(lease, hostname, s.leaseDuration(s.classOptions(req)))
+	s.checkPoolUsage(time.Now())
 
 	if isRequested {
 		resp.UpdateOption(dhcpv4.OptHostName(lease.Hostname))
@@ -1018,6 +1071,17 @@ func (s *v4Server) handleDecline(req, resp *dhcpv4.DHCPv4) (err error) {
 		return fmt.Errorf("removing old lease for %s: %w", mac, err)
 	}
 
+	s.notifyLease(LeaseChangedReleased, oldLease)
+
+	// See https://datatracker.ietf.org/doc/html/rfc2131#section-4.3.3.
+	declined := &dhcpsvc.Lease{IP: oldLease.IP}
+	err = s.addLease(declined)
+	if err != nil {
+		return fmt.Errorf("quarantining declined ip %s: %w", oldLease.IP, err)
+	}
+
+	s.blocklistLease(declined, mac, conflictReasonDeclined)
+
 	newLease, err := s.allocateLease(mac)
 	if err != nil {
 		return fmt.Errorf("allocating new lease for %s: %w", mac, err)
@@ -1030,13 +1094,9 @@ func (s *v4Server) handleDecline(req, resp *dhcpv4.DHCPv4) (err error) {
 		return nil
 	}
 
-	newLease.Hostname = oldLease.Hostname
-	newLease.Expiry = time.Now().Add(s.conf.leaseTime)
-
-	err = s.addLease(newLease)
-	if err != nil {
-		return fmt.Errorf("adding new lease for %s: %w", mac, err)
-	}
+	// The new lease is already added by allocateLease, so only commit it.
+	s.commitLease(newLease, oldLease.Hostname, s.leaseDuration(s.classOptions(req)))
+	s.notifyLease(LeaseChangedAdded, newLease)
 
 	log.Info("dhcpv4: changed IP from %s to %s for %s", reqIP, newLease.IP, mac)
 
@@ -1072,8 +1132,6 @@ func (s *v4Server) handleRelease(req, resp *dhcpv4.DHCPv4) (err error) {
 		reqIP = req.ClientIPAddr
 	}
 
-	// TODO(a.garipov): Add a separate notification type for dynamic lease
-	// removal?
 	defer s.conf.notify(LeaseChangedDBStore)
 
 	n := 0
@@ -1099,6 +1157,7 @@ func (s *v4Server) handleRelease(req, resp *dhcpv4.DHCPv4) (err error) {
 			return
 		}
 
+		s.notifyLease(LeaseChangedReleased, l)
 		n++
 	}
 
@@ -1196,7 +1255,8 @@ func (s *v4Server) handle(req, resp *dhcpv4.DHCPv4) (rCode int) {
 
 	handler := messageHandlers[req.MessageType()]
 	if handler == nil {
-		s.updateOptions(req, resp)
+		s.updateOptions(req, resp, s.hostOptions(req))
+		s.setBoot(req, resp)
 
 		return 1
 	}
@@ -1216,21 +1276,55 @@ func (s *v4Server) handle(req, resp *dhcpv4.DHCPv4) (rCode int) {
 		resp.YourIPAddr = l.IP.AsSlice()
 	}
 
-	s.updateOptions(req, resp)
+	s.updateOptions(req, resp, s.hostOptions(req))
+	s.setBoot(req, resp)
 
 	return 1
 }
 
+// pxeClassID is the prefix of the vendor class identifier sent by the PXE
+// clients.
+const pxeClassID = "PXEClient"
+
+// setBoot sets the network boot parameters of resp for the client sending req,
+// if configured.
+func (s *v4Server) setBoot(req, resp *dhcpv4.DHCPv4) {
+	var arches []dhcpsvc.ClientArch
+	for _, arch := range req.ClientArch() {
+		arches = append(arches, dhcpsvc.ClientArch(arch))
+	}
+
+	boot := s.bootConfig(req)
+	name := boot.filename(arches)
+	if name == "" {
+		return
+	}
+
+	next := boot.NextServer
+	if !next.IsValid() {
+		next = s.conf.dnsIPAddrs[0]
+	}
+
+	resp.ServerIPAddr = next.AsSlice()
+	resp.BootFileName = name
+
+	// PXE clients ignore the offers without the vendor class identifier.
+	if strings.HasPrefix(req.ClassIdentifier(), pxeClassID) {
+		resp.UpdateOption(dhcpv4.OptClassIdentifier(pxeClassID))
+	}
+}
+
 // updateOptions updates the options of the response in accordance with the
-// request and RFC 2131.
+// request and RFC 2131.  host are the options specific to the client, see
+// [v4Server.hostOptions].
 //
 // See https://datatracker.ietf.org/doc/html/rfc2131#section-4.3.1.
-func (s *v4Server) updateOptions(req, resp *dhcpv4.DHCPv4) {
+func (s *v4Server) updateOptions(req, resp *dhcpv4.DHCPv4, host layers.DHCPOptions) {
 	// Set IP address lease time for all DHCPOFFER messages and DHCPACK messages
 	// replied for DHCPREQUEST.
 	//
 	// TODO(e.burkov):  Inspect why this is always set to configured value.
-	resp.UpdateOption(dhcpv4.OptIPAddressLeaseTime(s.conf.leaseTime))
+	resp.UpdateOption(dhcpv4.OptIPAddressLeaseTime(s.leaseDuration(host)))
 
 	// If the server recognizes the parameter as a parameter defined in the Host
 	// Requirements Document, the server MUST include the default value for that
@@ -1252,6 +1346,27 @@ func (s *v4Server) updateOptions(req, resp *dhcpv4.DHCPv4) {
 			delete(resp.Options, code)
 		}
 	}
+
+	// The options specific to the client take precedence over the ones of the
+	// server.  The lease time is already set above.
+	for _, o := range host {
+		switch code := uint8(o.Type); {
+		case o.Type == layers.DHCPOptLeaseTime:
+			continue
+		case len(o.Data) == 0:
+			delete(resp.Options, code)
+		default:
+			resp.Options[code] = o.Data
+		}
+	}
+}
+
+// isForeignRelayed returns true if req is sent by a relay agent, which address
+// is outside of the subnet of s.
+func (s *v4Server) isForeignRelayed(req *dhcpv4.DHCPv4) (ok bool) {
+	giaddr, ok := netip.AddrFromSlice(req.GatewayIPAddr.To4())
+
+	return ok && !giaddr.IsUnspecified() && !s.conf.subnet.Contains(giaddr)
 }
 
 // client(0.0.0.0:68) -> (Request:ClientMAC,Type=Discover,ClientID,ReqIP,HostName) -> server(255.255.255.255:67)
@@ -1288,6 +1403,17 @@ func (s *v4Server) packetHandler(conn net.PacketConn, peer net.Addr, req *dhcpv4
 		return
 	}
 
+	if s.isForeignRelayed(req) {
+		// The socket bound to the main interface receives the unicast requests
+		// from the relay agents arriving on it, so pass the requests for the
+		// other subnets to the additional scopes.
+		if s.conf.handleRelayed != nil {
+			s.conf.handleRelayed(conn, req.ToBytes())
+		}
+
+		return
+	}
+
 	r := s.handle(req, resp)
 	if r < 0 {
 		return
@@ -1412,6 +1538,7 @@ func v4Create(conf *V4ServerConf) (srv *v4Server, err error) {
 	s := &v4Server{
 		hostsIndex: map[string]*dhcpsvc.Lease{},
 		ipIndex:    map[netip.Addr]*dhcpsvc.Lease{},
+		conflicts:  map[netip.Addr]*addrConflict{},
 	}
 
 	err = conf.Validate()
@@ -1434,6 +1561,16 @@ func v4Create(conf *V4ServerConf) (srv *v4Server, err error) {
 		s.conf.leaseTime = time.Second * time.Duration(conf.LeaseDuration)
 	}
 
+	s.conf.quarantineTime = s.conf.leaseTime
+	if conf.QuarantineDuration != 0 {
+		s.conf.quarantineTime = time.Second * time.Duration(conf.QuarantineDuration)
+	}
+
+	s.classes, err = newV4Classes(conf.Classes)
+	if err != nil {
+		return s, fmt.Errorf("dhcpv4: %w", err)
+	}
+
 	s.prepareOptions()
 
 	return s, nil
@@ -11,11 +11,14 @@ import (
 	"time"
 
 	"github.com/AdguardTeam/AdGuardHome/internal/aghnet"
+	"github.com/AdguardTeam/AdGuardHome/internal/arpdb"
 	"github.com/AdguardTeam/AdGuardHome/internal/dhcpsvc"
 	"github.com/AdguardTeam/golibs/netutil"
 	"github.com/AdguardTeam/golibs/stringutil"
 	"github.com/AdguardTeam/golibs/testutil"
+	"github.com/google/gopacket/layers"
 	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/insomniacslk/dhcp/iana"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -489,7 +492,7 @@ func TestV4Server_updateOptions(t *testing.T) {
 		require.IsType(t, (*v4Server)(nil), s)
 
 		t.Run(tc.name, func(t *testing.T) {
-			s.updateOptions(req, resp)
+			s.updateOptions(req, resp, nil)
 
 			for c, v := range tc.wantOpts {
 				if v == nil {
@@ -504,6 +507,202 @@ func TestV4Server_updateOptions(t *testing.T) {
 	}
 }
 
+func TestV4Server_setBoot(t *testing.T) {
+	nextServer := netip.MustParseAddr("192.168.10.5")
+	boot := &BootConfig{
+		Filename:        "undionly.kpxe",
+		FilenameUEFIx64: "ipxe.efi",
+	}
+
+	testCases := []struct {
+		boot      *BootConfig
+		name      string
+		wantFile  string
+		wantNext  netip.Addr
+		reqMods   []dhcpv4.Modifier
+		wantClass bool
+	}{{
+		boot:      nil,
+		name:      "no_boot",
+		wantFile:  "",
+		wantNext:  netip.IPv4Unspecified(),
+		reqMods:   nil,
+		wantClass: false,
+	}, {
+		boot:      boot,
+		name:      "default",
+		wantFile:  "undionly.kpxe",
+		wantNext:  DefaultSelfIP,
+		reqMods:   nil,
+		wantClass: false,
+	}, {
+		boot:     boot,
+		name:     "uefi_pxe",
+		wantFile: "ipxe.efi",
+		wantNext: DefaultSelfIP,
+		reqMods: []dhcpv4.Modifier{
+			dhcpv4.WithOption(dhcpv4.OptClientArch(iana.EFI_BC)),
+			dhcpv4.WithOption(dhcpv4.OptClassIdentifier("PXEClient:Arch:00009")),
+		},
+		wantClass: true,
+	}, {
+		boot: &BootConfig{
+			NextServer: nextServer,
+			Filename:   "undionly.kpxe",
+		},
+		name:      "next_server",
+		wantFile:  "undionly.kpxe",
+		wantNext:  nextServer,
+		reqMods:   nil,
+		wantClass: false,
+	}}
+
+	for _, tc := range testCases {
+		conf := defaultV4ServerConf()
+		conf.Boot = tc.boot
+
+		s, err := v4Create(conf)
+		require.NoError(t, err)
+
+		req, err := dhcpv4.NewDiscovery(net.HardwareAddr{0xAA, 0xAA, 0xAA, 0xAA, 0xAA, 0xAA}, tc.reqMods...)
+		require.NoError(t, err)
+
+		resp, err := dhcpv4.NewReplyFromRequest(req)
+		require.NoError(t, err)
+
+		t.Run(tc.name, func(t *testing.T) {
+			s.setBoot(req, resp)
+
+			assert.Equal(t, tc.wantFile, resp.BootFileName)
+			assert.Equal(t, net.IP(tc.wantNext.AsSlice()), resp.ServerIPAddr.To4())
+			assert.Equal(t, tc.wantClass, resp.ClassIdentifier() == "PXEClient")
+		})
+	}
+}
+
+func TestV4Server_handle_hostOptions(t *testing.T) {
+	classDNS := net.IP{1, 1, 1, 1}
+	staticDNS := net.IP{8, 8, 8, 8}
+	staticMAC := net.HardwareAddr{0xAA, 0xAA, 0xAA, 0xAA, 0xAA, 0xAA}
+	dynamicMAC := net.HardwareAddr{0xBB, 0xBB, 0xBB, 0xBB, 0xBB, 0xBB}
+
+	conf := defaultV4ServerConf()
+	conf.Boot = &BootConfig{
+		Filename: "undionly.kpxe",
+	}
+	conf.Classes = []*ClientClassConfig{{
+		Name:     "printers",
+		Hostname: "Printer-*",
+		Options:  []string{"6 ips 1.1.1.1", "51 dur 1h"},
+		Boot: &BootConfig{
+			Filename: "printer.bin",
+		},
+	}}
+
+	s, err := v4Create(conf)
+	require.NoError(t, err)
+
+	s.implicitOpts.Update(dhcpv4.OptDNS(DefaultSelfIP.AsSlice()))
+
+	err = s.AddStaticLease(&dhcpsvc.Lease{
+		Hostname: "static-printer",
+		HWAddr:   staticMAC,
+		IP:       netip.MustParseAddr("192.168.10.10"),
+		Options: layers.DHCPOptions{
+			layers.NewDHCPOption(layers.DHCPOptDNS, staticDNS),
+		},
+		IsStatic: true,
+	})
+	require.NoError(t, err)
+
+	testCases := []struct {
+		name      string
+		hostname  string
+		wantFile  string
+		wantDNS   []byte
+		mac       net.HardwareAddr
+		wantLease time.Duration
+	}{{
+		name:      "no_match",
+		hostname:  "laptop",
+		wantFile:  "undionly.kpxe",
+		wantDNS:   DefaultSelfIP.AsSlice(),
+		mac:       dynamicMAC,
+		wantLease: s.conf.leaseTime,
+	}, {
+		name:      "class",
+		hostname:  "printer-1",
+		wantFile:  "printer.bin",
+		wantDNS:   classDNS,
+		mac:       dynamicMAC,
+		wantLease: time.Hour,
+	}, {
+		name:      "static_lease",
+		hostname:  "printer-2",
+		wantFile:  "printer.bin",
+		wantDNS:   staticDNS,
+		mac:       staticMAC,
+		wantLease: time.Hour,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req, reqErr := dhcpv4.NewDiscovery(
+				tc.mac,
+				dhcpv4.WithOption(dhcpv4.OptHostName(tc.hostname)),
+				dhcpv4.WithRequestedOptions(dhcpv4.OptionDomainNameServer),
+			)
+			require.NoError(t, reqErr)
+
+			resp, reqErr := dhcpv4.NewReplyFromRequest(req)
+			require.NoError(t, reqErr)
+
+			require.Equal(t, 1, s.handle(req, resp))
+
+			assert.Equal(t, tc.wantDNS, resp.Options.Get(dhcpv4.OptionDomainNameServer))
+			assert.Equal(t, tc.wantLease, resp.IPAddressLeaseTime(0))
+			assert.Equal(t, tc.wantFile, resp.BootFileName)
+		})
+	}
+}
+
+func TestNewV4Classes(t *testing.T) {
+	testCases := []struct {
+		name       string
+		wantErrMsg string
+		conf       []*ClientClassConfig
+	}{{
+		name:       "valid",
+		wantErrMsg: "",
+		conf: []*ClientClassConfig{{
+			Name:      "phones",
+			MACPrefix: "00:1a:2b",
+			Options:   []string{"51 dur 1h"},
+		}},
+	}, {
+		name:       "no_criteria",
+		wantErrMsg: `class at index 0: class "phones": no criteria`,
+		conf: []*ClientClassConfig{{
+			Name: "phones",
+		}},
+	}, {
+		name:       "zero_lease_time",
+		wantErrMsg: `class at index 0: class "phones": options: lease time: must be positive`,
+		conf: []*ClientClassConfig{{
+			Name:      "phones",
+			MACPrefix: "00:1a:2b",
+			Options:   []string{"51 dur 0s"},
+		}},
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := newV4Classes(tc.conf)
+			testutil.AssertErrorMsg(t, tc.wantErrMsg, err)
+		})
+	}
+}
+
 func TestV4StaticLease_Get(t *testing.T) {
 	sIface := defaultSrv(t)
 
@@ -767,6 +966,72 @@ func (fc *fakePacketConn) WriteTo(p []byte, addr net.Addr) (n int, err error) {
 	return fc.writeTo(p, addr)
 }
 
+func TestV4Server_packetHandler_relayed(t *testing.T) {
+	mac := net.HardwareAddr{0xAA, 0xAA, 0xAA, 0xAA, 0xAA, 0xAA}
+
+	testCases := []struct {
+		giaddr      netip.Addr
+		name        string
+		wantRelayed bool
+	}{{
+		giaddr:      netip.MustParseAddr("10.0.20.1"),
+		name:        "other_subnet",
+		wantRelayed: true,
+	}, {
+		giaddr:      netip.MustParseAddr("192.168.10.5"),
+		name:        "own_subnet",
+		wantRelayed: false,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var relayed []byte
+			conf := defaultV4ServerConf()
+			conf.handleRelayed = func(_ net.PacketConn, pkt []byte) { relayed = pkt }
+
+			s, err := v4Create(conf)
+			require.NoError(t, err)
+
+			var written net.Addr
+			conn := &fakePacketConn{
+				writeTo: func(p []byte, addr net.Addr) (n int, err error) {
+					written = addr
+
+					return len(p), nil
+				},
+			}
+
+			req, err := dhcpv4.NewDiscovery(mac)
+			require.NoError(t, err)
+
+			req.GatewayIPAddr = net.IP(tc.giaddr.AsSlice())
+
+			s.packetHandler(conn, &net.UDPAddr{}, req)
+
+			if !tc.wantRelayed {
+				assert.Nil(t, relayed)
+				assert.Equal(t, &net.UDPAddr{
+					IP:   net.IP(tc.giaddr.AsSlice()),
+					Port: dhcpv4.ServerPort,
+				}, written)
+
+				return
+			}
+
+			assert.Nil(t, written)
+			assert.Empty(t, s.GetLeases(LeasesAll))
+
+			require.NotNil(t, relayed)
+
+			got, err := dhcpv4.FromBytes(relayed)
+			require.NoError(t, err)
+
+			assert.Equal(t, mac, got.ClientHWAddr)
+			assert.Equal(t, net.IP(tc.giaddr.AsSlice()), got.GatewayIPAddr.To4())
+		})
+	}
+}
+
 func TestV4Server_FindMACbyIP(t *testing.T) {
 	const (
 		staticName  = "static-client"
@@ -832,6 +1097,18 @@ func TestV4Server_FindMACbyIP(t *testing.T) {
 	}
 }
 
+// testARPDB is the [arpdb.Interface] implementation for tests.
+type testARPDB []arpdb.Neighbor
+
+// type check
+var _ arpdb.Interface = testARPDB(nil)
+
+// Refresh implements the [arpdb.Interface] interface for testARPDB.
+func (testARPDB) Refresh() (err error) { return nil }
+
+// Neighbors implements the [arpdb.Interface] interface for testARPDB.
+func (arp testARPDB) Neighbors() (ns []arpdb.Neighbor) { return arp }
+
 func TestV4Server_handleDecline(t *testing.T) {
 	const (
 		dynamicName = "dynamic-client"
@@ -840,12 +1117,20 @@ func TestV4Server_handleDecline(t *testing.T) {
 
 	dynamicIP := netip.MustParseAddr("192.168.10.200")
 	dynamicMAC := net.HardwareAddr{0xAA, 0xAA, 0xAA, 0xAA, 0xAA, 0xAA}
+	rogueMAC := net.HardwareAddr{0xBB, 0xBB, 0xBB, 0xBB, 0xBB, 0xBB}
+
+	const testTimeout = 1 * time.Second
 
 	s := defaultSrv(t)
 
 	s4, ok := s.(*v4Server)
 	require.True(t, ok)
 
+	s4.conf.arpDB = testARPDB{{
+		IP:  dynamicIP,
+		MAC: rogueMAC,
+	}}
+
 	s4.leases = []*dhcpsvc.Lease{{
 		Hostname: dynamicName,
 		HWAddr:   dynamicMAC,
@@ -872,6 +1157,30 @@ func TestV4Server_handleDecline(t *testing.T) {
 	}
 
 	require.Equal(t, wantResp, resp)
+
+	// The hardware address of the device using the declined address is looked
+	// up asynchronously.
+	require.Eventually(t, func() (ok bool) {
+		conflicts, _ := s4.conflictList()
+
+		return len(conflicts) == 1 && conflicts[0].hwAddr != nil
+	}, testTimeout, testTimeout/10)
+
+	conflicts, u := s4.conflictList()
+	require.Len(t, conflicts, 1)
+
+	c := conflicts[0]
+	assert.Equal(t, dynamicIP, c.ip)
+	assert.Equal(t, rogueMAC, c.hwAddr)
+	assert.Equal(t, dynamicMAC, c.clientHWAddr)
+	assert.Equal(t, conflictReasonDeclined, c.reason)
+
+	assert.Equal(t, uint64(1), u.quarantined)
+	assert.Equal(t, uint64(1), u.leased)
+	assert.False(t, u.nearlyExhausted)
+
+	// The declined address isn't offered again.
+	assert.Nil(t, s4.FindMACbyIP(dynamicIP))
 }
 
 func TestV4Server_handleRelease(t *testing.T) {
@@ -914,3 +1223,119 @@ func TestV4Server_handleRelease(t *testing.T) {
 
 	require.Equal(t, wantResp, resp)
 }
+
+func TestV4Server_releasedEvents(t *testing.T) {
+	type event struct {
+		hostname string
+		hwAddr   net.HardwareAddr
+		flags    uint32
+	}
+
+	var events []event
+
+	conf := defaultV4ServerConf()
+	conf.RangeEnd = conf.RangeStart.Next()
+	conf.notifyLease = func(flags uint32, l *dhcpsvc.Lease) {
+		events = append(events, event{
+			hostname: l.Hostname,
+			hwAddr:   l.HWAddr,
+			flags:    flags,
+		})
+	}
+
+	s, err := v4Create(conf)
+	require.NoError(t, err)
+
+	oldMAC := net.HardwareAddr{0xAA, 0xAA, 0xAA, 0xAA, 0xAA, 0xAA}
+	newMAC := net.HardwareAddr{0xBB, 0xBB, 0xBB, 0xBB, 0xBB, 0xBB}
+
+	err = s.ResetLeases([]*dhcpsvc.Lease{{
+		Expiry:   time.Now().Add(-time.Hour),
+		Hostname: "old-host",
+		HWAddr:   oldMAC,
+		IP:       conf.RangeStart,
+	}, {
+		Expiry:   time.Now().Add(time.Hour),
+		Hostname: "active-host",
+		HWAddr:   net.HardwareAddr{0xCC, 0xCC, 0xCC, 0xCC, 0xCC, 0xCC},
+		IP:       conf.RangeEnd,
+	}})
+	require.NoError(t, err)
+
+	s.leasesLock.Lock()
+	defer s.leasesLock.Unlock()
+
+	l, err := s.reserveLease(newMAC)
+	require.NoError(t, err)
+	require.NotNil(t, l)
+
+	assert.Equal(t, newMAC, l.HWAddr)
+	assert.Empty(t, l.Hostname)
+
+	// The old hardware address isn't overwritten in place.
+	assert.Equal(t, []event{{
+		hostname: "old-host",
+		hwAddr:   oldMAC,
+		flags:    LeaseChangedReleased,
+	}}, events)
+
+	events = nil
+	s.commitLease(l, "first-host", time.Hour)
+	assert.Empty(t, events)
+
+	s.commitLease(l, "second-host", time.Hour)
+	assert.Equal(t, []event{{
+		hostname: "first-host",
+		hwAddr:   newMAC,
+		flags:    LeaseChangedReleased,
+	}}, events)
+
+	assert.Equal(t, "second-host", l.Hostname)
+	assert.NotContains(t, s.hostsIndex, "first-host")
+}
+
+func TestV4Server_ResetLeases_conflicts(t *testing.T) {
+	s, err := v4Create(defaultV4ServerConf())
+	require.NoError(t, err)
+
+	ip := DefaultRangeStart
+	until := time.Now().Add(time.Hour).Truncate(time.Second)
+	quarantined := func() (l *dhcpsvc.Lease) {
+		return &dhcpsvc.Lease{
+			Expiry: until,
+			HWAddr: make(net.HardwareAddr, defaultHwAddrLen),
+			IP:     ip,
+		}
+	}
+
+	err = s.ResetLeases([]*dhcpsvc.Lease{quarantined()})
+	require.NoError(t, err)
+
+	conflicts, u := s.conflictList()
+	require.Len(t, conflicts, 1)
+
+	assert.Equal(t, ip, conflicts[0].ip)
+	assert.Equal(t, until, conflicts[0].until)
+	assert.Equal(t, conflictReasonUnknown, conflicts[0].reason)
+	assert.Equal(t, uint64(1), u.quarantined)
+
+	clientMAC := net.HardwareAddr{0xAA, 0xAA, 0xAA, 0xAA, 0xAA, 0xAA}
+	s.conflicts[ip].reason = conflictReasonDeclined
+	s.conflicts[ip].clientHWAddr = clientMAC
+
+	// The details known before the reset are kept.
+	err = s.ResetLeases([]*dhcpsvc.Lease{quarantined()})
+	require.NoError(t, err)
+
+	conflicts, _ = s.conflictList()
+	require.Len(t, conflicts, 1)
+
+	assert.Equal(t, conflictReasonDeclined, conflicts[0].reason)
+	assert.Equal(t, clientMAC, conflicts[0].clientHWAddr)
+
+	err = s.ResetLeases(nil)
+	require.NoError(t, err)
+
+	conflicts, _ = s.conflictList()
+	assert.Empty(t, conflicts)
+}
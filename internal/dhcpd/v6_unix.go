@@ -7,6 +7,7 @@ import (
 	"fmt"
 	"net"
 	"net/netip"
+	"slices"
 	"sync"
 	"time"
 
@@ -32,11 +33,24 @@ type v6Server struct {
 	sid  dhcpv6.DUID
 	srv  *server6.Server
 
-	leases     []*dhcpsvc.Lease
+	leases []*dhcpsvc.Lease
+
+	// prefixLeases are the leases of the delegated prefixes.  Each client has
+	// at most one such lease.
+	prefixLeases []*dhcpsvc.Lease
+
 	leasesLock sync.Mutex
 	ipAddrs    [256]byte
 }
 
+// notifyLease signals the change of the single lease l to the other
+// components, if needed.
+func (s *v6Server) notifyLease(flags uint32, l *dhcpsvc.Lease) {
+	if s.conf.notifyLease != nil {
+		s.conf.notifyLease(flags, l.Clone())
+	}
+}
+
 // WriteDiskConfig4 - write configuration
 func (s *v6Server) WriteDiskConfig4(c *V4ServerConf) {
 }
@@ -95,7 +109,14 @@ func (s *v6Server) ResetLeases(leases []*dhcpsvc.Lease) (err error) {
 	defer s.leasesLock.Unlock()
 
 	s.leases = nil
+	s.prefixLeases = nil
 	for _, l := range leases {
+		if l.Prefix.IsValid() {
+			s.resetPrefixLease(l)
+
+			continue
+		}
+
 		ip := net.IP(l.IP.AsSlice())
 		if !l.IsStatic && !ip6InRange(s.conf.ipStart, ip) {
 
@@ -110,6 +131,19 @@ func (s *v6Server) ResetLeases(leases []*dhcpsvc.Lease) (err error) {
 	return nil
 }
 
+// resetPrefixLease adds the stored prefix lease l, if it's within the current
+// prefix delegation pool.
+func (s *v6Server) resetPrefixLease(l *dhcpsvc.Lease) {
+	pd := s.conf.PrefixDelegation
+	if pd == nil || !pd.contains(l.Prefix) {
+		log.Debug("dhcpv6: skipping a lease with prefix %v: not within current pool", l.Prefix)
+
+		return
+	}
+
+	s.prefixLeases = append(s.prefixLeases, l)
+}
+
 // GetLeases returns the list of current DHCP leases.  It is safe for concurrent
 // use.
 func (s *v6Server) GetLeases(flags GetLeasesFlags) (leases []*dhcpsvc.Lease) {
@@ -132,12 +166,19 @@ func (s *v6Server) GetLeases(flags GetLeasesFlags) (leases []*dhcpsvc.Lease) {
 		}
 	}
 
+	if (flags & LeasesDynamic) != 0 {
+		for _, l := range s.prefixLeases {
+			leases = append(leases, l.Clone())
+		}
+	}
+
 	return leases
 }
 
-// getLeasesRef returns the actual leases slice.  For internal use only.
+// getLeasesRef returns the leases of addresses and prefixes.  For internal use
+// only.
 func (s *v6Server) getLeasesRef() []*dhcpsvc.Lease {
-	return s.leases
+	return slices.Concat(s.leases, s.prefixLeases)
 }
 
 // FindMACbyIP implements the [Interface] for *v6Server.
@@ -234,12 +275,14 @@ func (s *v6Server) AddStaticLease(l *dhcpsvc.Lease) (err error) {
 	s.leasesLock.Unlock()
 
 	s.conf.notify(LeaseChangedAddedStatic)
+	s.notifyLease(LeaseChangedAddedStatic, l)
 
 	return nil
 }
 
 // UpdateStaticLease updates IP, hostname of the static lease.
 func (s *v6Server) UpdateStaticLease(l *dhcpsvc.Lease) (err error) {
+	var prev *dhcpsvc.Lease
 	defer func() {
 		if err != nil {
 			err = errors.Annotate(err, "dhcpv6: updating static lease: %w")
@@ -249,6 +292,8 @@ func (s *v6Server) UpdateStaticLease(l *dhcpsvc.Lease) (err error) {
 
 		s.conf.notify(LeaseChangedDBStore)
 		s.conf.notify(LeaseChangedRemovedStatic)
+		s.notifyLease(LeaseChangedRemovedStatic, prev)
+		s.notifyLease(LeaseChangedAddedStatic, l)
 	}()
 
 	s.leasesLock.Lock()
@@ -259,6 +304,8 @@ func (s *v6Server) UpdateStaticLease(l *dhcpsvc.Lease) (err error) {
 		return fmt.Errorf("can't find lease %s", l.HWAddr)
 	}
 
+	prev = found.Clone()
+
 	err = s.rmLease(found)
 	if err != nil {
 		return fmt.Errorf("removing previous lease for %s (%s): %w", l.IP, l.HWAddr, err)
@@ -291,6 +338,7 @@ func (s *v6Server) RemoveStaticLease(l *dhcpsvc.Lease) (err error) {
 	s.conf.notify(LeaseChangedDBStore)
 	s.leasesLock.Unlock()
 	s.conf.notify(LeaseChangedRemovedStatic)
+	s.notifyLease(LeaseChangedRemovedStatic, l)
 	return nil
 }
 
@@ -376,9 +424,15 @@ func (s *v6Server) reserveLease(mac net.HardwareAddr) *dhcpsvc.Lease {
 			return nil
 		}
 
-		copy(s.leases[i].HWAddr, mac)
+		// Report the previous holder of the expired lease as released, so that
+		// the information about it is withdrawn.
+		expired := s.leases[i]
+		s.notifyLease(LeaseChangedReleased, expired)
+
+		expired.HWAddr = slices.Clone(mac)
+		expired.Hostname = ""
 
-		return s.leases[i]
+		return expired
 	}
 
 	netIP, ok := netip.AddrFromSlice(ip)
@@ -398,6 +452,7 @@ func (s *v6Server) commitDynamicLease(l *dhcpsvc.Lease) {
 
 	s.leasesLock.Lock()
 	s.conf.notify(LeaseChangedDBStore)
+	s.notifyLease(LeaseChangedAdded, l)
 	s.leasesLock.Unlock()
 	s.conf.notify(LeaseChangedAdded)
 }
@@ -488,14 +543,15 @@ func (s *v6Server) commitLease(msg *dhcpv6.Message, lease *dhcpsvc.Lease) time.D
 	return lifetime
 }
 
-// Find a lease associated with MAC and prepare response
+// Find the leases associated with MAC and prepare response
 func (s *v6Server) process(msg *dhcpv6.Message, req, resp dhcpv6.DHCPv6) bool {
 	switch msg.Type() {
 	case dhcpv6.MessageTypeSolicit,
 		dhcpv6.MessageTypeRequest,
 		dhcpv6.MessageTypeConfirm,
 		dhcpv6.MessageTypeRenew,
-		dhcpv6.MessageTypeRebind:
+		dhcpv6.MessageTypeRebind,
+		dhcpv6.MessageTypeRelease:
 		// continue
 
 	default:
@@ -509,6 +565,42 @@ func (s *v6Server) process(msg *dhcpv6.Message, req, resp dhcpv6.DHCPv6) bool {
 		return false
 	}
 
+	if msg.Type() == dhcpv6.MessageTypeRelease {
+		s.release(msg, mac, resp)
+
+		return true
+	}
+
+	// Requesting routers may ask for the prefixes only.
+	withAddr := msg.Options.OneIANA() != nil || msg.Options.OneIAPD() == nil
+	if withAddr && !s.processIANA(msg, mac, resp) {
+		return false
+	}
+
+	delegated := s.processIAPD(msg, mac, resp)
+	if !withAddr && !delegated {
+		return false
+	}
+
+	if msg.IsOptionRequested(dhcpv6.OptionDNSRecursiveNameServer) {
+		resp.UpdateOption(dhcpv6.OptDNS(s.conf.dnsIPAddrs...))
+	}
+
+	fqdn := msg.GetOneOption(dhcpv6.OptionFQDN)
+	if fqdn != nil {
+		resp.AddOption(fqdn)
+	}
+
+	resp.AddOption(&dhcpv6.OptStatusCode{
+		StatusCode:    iana.StatusSuccess,
+		StatusMessage: "success",
+	})
+	return true
+}
+
+// processIANA finds the address lease of the client with mac and adds the
+// IA_NA option into resp.  ok is false if there is no lease for the client.
+func (s *v6Server) processIANA(msg *dhcpv6.Message, mac net.HardwareAddr, resp dhcpv6.DHCPv6) (ok bool) {
 	var lease *dhcpsvc.Lease
 	func() {
 		s.leasesLock.Lock()
@@ -533,7 +625,7 @@ func (s *v6Server) process(msg *dhcpv6.Message, req, resp dhcpv6.DHCPv6) bool {
 		}
 	}
 
-	err = s.checkIA(msg, lease)
+	err := s.checkIA(msg, lease)
 	if err != nil {
 		log.Debug("dhcpv6: %s", err)
 
@@ -562,19 +654,6 @@ func (s *v6Server) process(msg *dhcpv6.Message, req, resp dhcpv6.DHCPv6) bool {
 	}
 	resp.AddOption(oia)
 
-	if msg.IsOptionRequested(dhcpv6.OptionDNSRecursiveNameServer) {
-		resp.UpdateOption(dhcpv6.OptDNS(s.conf.dnsIPAddrs...))
-	}
-
-	fqdn := msg.GetOneOption(dhcpv6.OptionFQDN)
-	if fqdn != nil {
-		resp.AddOption(fqdn)
-	}
-
-	resp.AddOption(&dhcpv6.OptStatusCode{
-		StatusCode:    iana.StatusSuccess,
-		StatusMessage: "success",
-	})
 	return true
 }
 
@@ -590,6 +669,9 @@ func (s *v6Server) process(msg *dhcpv6.Message, req, resp dhcpv6.DHCPv6) bool {
 //
 // 3.
 // fe80::* --(Release + ClientID+ServerID+IANA(IAAddress))-> ff02::1:2
+//
+// The requesting routers may also add IAPD to the messages to get the
+// prefixes delegated, see [v6Server.processIAPD].
 func (s *v6Server) packetHandler(conn net.PacketConn, peer net.Addr, req dhcpv6.DHCPv6) {
 	msg, err := req.GetInnerMessage()
 	if err != nil {
@@ -800,6 +882,11 @@ func v6Create(conf V6ServerConf) (DHCPServer, error) {
 		return s, fmt.Errorf("dhcpv6: invalid range-start IP: %s", conf.RangeStart)
 	}
 
+	err := conf.PrefixDelegation.validate()
+	if err != nil {
+		return s, fmt.Errorf("dhcpv6: prefix delegation: %w", err)
+	}
+
 	if conf.LeaseDuration == 0 {
 		s.conf.leaseTime = timeutil.Day
 		s.conf.LeaseDuration = uint32(s.conf.leaseTime.Seconds())
@@ -380,3 +380,158 @@ func TestV6_FindMACbyIP(t *testing.T) {
 		})
 	}
 }
+
+func TestV6_prefixDelegation(t *testing.T) {
+	sIface, err := v6Create(V6ServerConf{
+		Enabled:    true,
+		RangeStart: net.ParseIP("2001::2"),
+		PrefixDelegation: &PrefixDelegationConfig{
+			Pool:      netip.MustParsePrefix("2001:db8:1200::/56"),
+			PrefixLen: 60,
+		},
+		notify: notify6,
+	})
+	require.NoError(t, err)
+
+	s, ok := sIface.(*v6Server)
+	require.True(t, ok)
+
+	s.sid = &dhcpv6.DUIDLL{
+		HWType:        iana.HWTypeEthernet,
+		LinkLayerAddr: net.HardwareAddr{0xAA, 0xAA, 0xAA, 0xAA, 0xAA, 0xAA},
+	}
+
+	// exchangeIA processes the message of type typ with IA_PD having iaid from
+	// the client with mac and returns the response.
+	exchangeIA := func(
+		t *testing.T,
+		typ dhcpv6.MessageType,
+		mac net.HardwareAddr,
+		iaid [4]byte,
+		prefixes ...*dhcpv6.OptIAPrefix,
+	) (resp *dhcpv6.Message) {
+		t.Helper()
+
+		req, sErr := dhcpv6.NewSolicit(mac, dhcpv6.WithIAPD(iaid, prefixes...))
+		require.NoError(t, sErr)
+
+		// Request the prefix only, as the routers usually do.
+		req.Options.Del(dhcpv6.OptionIANA)
+
+		if typ == dhcpv6.MessageTypeSolicit {
+			resp, sErr = dhcpv6.NewAdvertiseFromSolicit(req)
+		} else {
+			req.MessageType = typ
+			resp, sErr = dhcpv6.NewReplyFromMessage(req)
+		}
+		require.NoError(t, sErr)
+
+		s.process(req, req, resp)
+
+		return resp
+	}
+
+	// exchange is like exchangeIA but uses the same identity association.
+	exchange := func(
+		t *testing.T,
+		typ dhcpv6.MessageType,
+		mac net.HardwareAddr,
+		prefixes ...*dhcpv6.OptIAPrefix,
+	) (resp *dhcpv6.Message) {
+		t.Helper()
+
+		return exchangeIA(t, typ, mac, [4]byte{1, 2, 3, 4}, prefixes...)
+	}
+
+	// delegated returns the prefix delegated within resp.
+	delegated := func(t *testing.T, resp *dhcpv6.Message) (pref *dhcpv6.OptIAPrefix) {
+		t.Helper()
+
+		iapd := resp.Options.OneIAPD()
+		require.NotNil(t, iapd)
+
+		prefixes := iapd.Options.Prefixes()
+		require.Len(t, prefixes, 1)
+
+		return prefixes[0]
+	}
+
+	mac := net.HardwareAddr{0xAA, 0xAA, 0xAA, 0xAA, 0xAA, 0xAA}
+	anotherMAC := net.HardwareAddr{0xBB, 0xBB, 0xBB, 0xBB, 0xBB, 0xBB}
+
+	var pref *dhcpv6.OptIAPrefix
+	t.Run("solicit", func(t *testing.T) {
+		pref = delegated(t, exchange(t, dhcpv6.MessageTypeSolicit, mac))
+		assert.Equal(t, "2001:db8:1200::/60", pref.Prefix.String())
+
+		another := delegated(t, exchange(t, dhcpv6.MessageTypeSolicit, anotherMAC))
+		assert.Equal(t, "2001:db8:1200:10::/60", another.Prefix.String())
+	})
+
+	t.Run("request", func(t *testing.T) {
+		resp := exchange(t, dhcpv6.MessageTypeRequest, mac, pref)
+		assert.Equal(t, pref.Prefix.String(), delegated(t, resp).Prefix.String())
+		assert.Nil(t, resp.Options.OneIANA())
+
+		ls := s.GetLeases(LeasesDynamic)
+		require.Len(t, ls, 2)
+
+		assert.Equal(t, pref.Prefix.String(), ls[0].Prefix.String())
+		assert.Equal(t, mac, ls[0].HWAddr)
+		assert.False(t, ls[0].IP.IsValid())
+		assert.True(t, ls[0].Expiry.After(time.Now()))
+	})
+
+	t.Run("renew", func(t *testing.T) {
+		resp := exchange(t, dhcpv6.MessageTypeRenew, mac, pref)
+		assert.Equal(t, pref.Prefix.String(), delegated(t, resp).Prefix.String())
+	})
+
+	t.Run("another_iaid", func(t *testing.T) {
+		iaid := [4]byte{5, 6, 7, 8}
+
+		resp := exchangeIA(t, dhcpv6.MessageTypeRenew, mac, iaid, pref)
+		iapd := resp.Options.OneIAPD()
+		require.NotNil(t, iapd)
+		require.NotNil(t, iapd.Options.Status())
+
+		assert.Equal(t, iana.StatusNoBinding, iapd.Options.Status().StatusCode)
+
+		another := delegated(t, exchangeIA(t, dhcpv6.MessageTypeSolicit, mac, iaid))
+		assert.Equal(t, "2001:db8:1200:20::/60", another.Prefix.String())
+
+		// Only the prefix of the identity association is released.
+		resp = exchangeIA(t, dhcpv6.MessageTypeRelease, mac, iaid, pref)
+		iapd = resp.Options.OneIAPD()
+		require.NotNil(t, iapd)
+		require.NotNil(t, iapd.Options.Status())
+
+		assert.Equal(t, iana.StatusNoBinding, iapd.Options.Status().StatusCode)
+
+		resp = exchangeIA(t, dhcpv6.MessageTypeRelease, mac, iaid, another)
+		assert.Nil(t, resp.Options.OneIAPD())
+
+		assert.Len(t, s.GetLeases(LeasesDynamic), 2)
+	})
+
+	t.Run("renew_no_binding", func(t *testing.T) {
+		resp := exchange(t, dhcpv6.MessageTypeRenew, anotherMAC, pref)
+
+		iapd := resp.Options.OneIAPD()
+		require.NotNil(t, iapd)
+		require.NotNil(t, iapd.Options.Status())
+
+		assert.Equal(t, iana.StatusNoBinding, iapd.Options.Status().StatusCode)
+		assert.Empty(t, iapd.Options.Prefixes())
+	})
+
+	t.Run("release", func(t *testing.T) {
+		resp := exchange(t, dhcpv6.MessageTypeRelease, mac, pref)
+		assert.Nil(t, resp.Options.OneIAPD())
+
+		ls := s.GetLeases(LeasesDynamic)
+		require.Len(t, ls, 1)
+
+		assert.Equal(t, anotherMAC, ls[0].HWAddr)
+	})
+}
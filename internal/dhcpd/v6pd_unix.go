@@ -0,0 +1,286 @@
+//go:build darwin || freebsd || linux || openbsd
+
+package dhcpd
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"net/netip"
+	"slices"
+	"time"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/dhcpsvc"
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/AdguardTeam/golibs/log"
+	"github.com/insomniacslk/dhcp/dhcpv6"
+	"github.com/insomniacslk/dhcp/iana"
+)
+
+// errNoBinding is returned when the client renews or rebinds a prefix, which
+// isn't delegated to it.
+const errNoBinding errors.Error = "no binding"
+
+// prefixAt returns the i-th prefix of the pool.  i must be less than the
+// number of the prefixes within the pool.
+func (c *PrefixDelegationConfig) prefixAt(i uint64) (pref netip.Prefix) {
+	// The delegated prefixes are at most /64, so the index only affects the
+	// upper half of the address.
+	addr := c.Pool.Addr().As16()
+	hi := binary.BigEndian.Uint64(addr[:8])
+	binary.BigEndian.PutUint64(addr[:8], hi|i<<(64-c.PrefixLen))
+
+	return netip.PrefixFrom(netip.AddrFrom16(addr), c.PrefixLen)
+}
+
+// size returns the number of the prefixes within the pool.
+func (c *PrefixDelegationConfig) size() (n uint64) {
+	return 1 << (c.PrefixLen - c.Pool.Bits())
+}
+
+// contains returns true if pref is one of the prefixes within the pool.
+func (c *PrefixDelegationConfig) contains(pref netip.Prefix) (ok bool) {
+	return pref.Bits() == c.PrefixLen && pref.Masked() == pref && c.Pool.Contains(pref.Addr())
+}
+
+// clientDUID returns the DUID of the client sending msg.  duid is nil if msg
+// has no client identifier option.
+func clientDUID(msg *dhcpv6.Message) (duid []byte) {
+	cid := msg.Options.ClientID()
+	if cid == nil {
+		return nil
+	}
+
+	return cid.ToBytes()
+}
+
+// findPrefixLease returns the lease of the prefix delegated to the client with
+// duid within the identity association with iaid.  The hardware address isn't
+// used, since a router may have several identity associations and may change
+// its interfaces.  s.leasesLock is expected to be locked.
+//
+// See https://datatracker.ietf.org/doc/html/rfc8415#section-12.
+func (s *v6Server) findPrefixLease(duid []byte, iaid [4]byte) (lease *dhcpsvc.Lease) {
+	for _, l := range s.prefixLeases {
+		if l.IAID == iaid && bytes.Equal(duid, l.DUID) {
+			return l
+		}
+	}
+
+	return nil
+}
+
+// reservePrefix returns a new prefix lease for the identity association with
+// iaid of the client with duid and mac, reusing an expired one if the pool is
+// exhausted.  lease is nil if there are no available prefixes.  s.leasesLock
+// is expected to be locked.
+func (s *v6Server) reservePrefix(duid []byte, iaid [4]byte, mac net.HardwareAddr) (lease *dhcpsvc.Lease) {
+	pd := s.conf.PrefixDelegation
+
+	used := make(map[netip.Prefix]struct{}, len(s.prefixLeases))
+	for _, l := range s.prefixLeases {
+		used[l.Prefix] = struct{}{}
+	}
+
+	for i := range pd.size() {
+		pref := pd.prefixAt(i)
+		if _, ok := used[pref]; ok {
+			continue
+		}
+
+		lease = &dhcpsvc.Lease{
+			HWAddr: slices.Clone(mac),
+			Prefix: pref,
+			DUID:   slices.Clone(duid),
+			IAID:   iaid,
+		}
+		s.prefixLeases = append(s.prefixLeases, lease)
+		log.Debug("dhcpv6: added prefix lease %s <-> %s", pref, mac)
+
+		return lease
+	}
+
+	now := time.Now()
+	for _, l := range s.prefixLeases {
+		if !l.Expiry.After(now) {
+			// Report the previous holder of the expired prefix as released.
+			s.notifyLease(LeaseChangedReleased, l)
+
+			l.HWAddr = slices.Clone(mac)
+			l.DUID = slices.Clone(duid)
+			l.IAID = iaid
+			l.Hostname = ""
+
+			return l
+		}
+	}
+
+	return nil
+}
+
+// prefixLease returns the prefix lease for the identity association riapd of
+// the client with duid and mac sending msg and commits it, if needed.
+// s.leasesLock is expected to be locked.
+func (s *v6Server) prefixLease(
+	msg *dhcpv6.Message,
+	duid []byte,
+	mac net.HardwareAddr,
+	riapd *dhcpv6.OptIAPD,
+) (lease *dhcpsvc.Lease, err error) {
+	lease = s.findPrefixLease(duid, riapd.IaId)
+
+	switch msg.Type() {
+	case dhcpv6.MessageTypeSolicit, dhcpv6.MessageTypeRequest:
+		if lease == nil {
+			lease = s.reservePrefix(duid, riapd.IaId, mac)
+		}
+
+		if lease == nil {
+			return nil, errors.Error("no prefixes available")
+		}
+	case dhcpv6.MessageTypeRenew, dhcpv6.MessageTypeRebind:
+		// See https://datatracker.ietf.org/doc/html/rfc8415#section-18.3.4.
+		if lease == nil || !hasPrefix(riapd, lease.Prefix) {
+			return nil, errNoBinding
+		}
+	default:
+		return nil, fmt.Errorf("unexpected message type %s", msg.Type())
+	}
+
+	if msg.Type() != dhcpv6.MessageTypeSolicit {
+		lease.Expiry = time.Now().Add(s.conf.leaseTime)
+		s.conf.notify(LeaseChangedDBStore)
+		s.notifyLease(LeaseChangedAdded, lease)
+	}
+
+	return lease, nil
+}
+
+// hasPrefix returns true if iapd contains pref.
+func hasPrefix(iapd *dhcpv6.OptIAPD, pref netip.Prefix) (ok bool) {
+	for _, p := range iapd.Options.Prefixes() {
+		if p.Prefix != nil && p.Prefix.String() == pref.String() {
+			return true
+		}
+	}
+
+	return false
+}
+
+// processIAPD adds the IA_PD options with the prefixes delegated to the client
+// with mac into resp for each IA_PD option of msg.  ok is true if at least one
+// prefix is delegated.
+func (s *v6Server) processIAPD(msg *dhcpv6.Message, mac net.HardwareAddr, resp dhcpv6.DHCPv6) (ok bool) {
+	if msg.Type() == dhcpv6.MessageTypeConfirm {
+		return false
+	}
+
+	duid := clientDUID(msg)
+	for _, riapd := range msg.Options.IAPD() {
+		ok = s.delegatePrefix(msg, duid, mac, riapd, resp) || ok
+	}
+
+	return ok
+}
+
+// delegatePrefix adds the IA_PD option with the prefix delegated to the client
+// with duid and mac within the identity association riapd into resp.  ok is
+// true if the prefix is delegated.
+func (s *v6Server) delegatePrefix(
+	msg *dhcpv6.Message,
+	duid []byte,
+	mac net.HardwareAddr,
+	riapd *dhcpv6.OptIAPD,
+	resp dhcpv6.DHCPv6,
+) (ok bool) {
+	oiapd := &dhcpv6.OptIAPD{
+		IaId: riapd.IaId,
+	}
+	defer resp.AddOption(oiapd)
+
+	if s.conf.PrefixDelegation == nil {
+		oiapd.Options.Add(&dhcpv6.OptStatusCode{
+			StatusCode:    iana.StatusNoPrefixAvail,
+			StatusMessage: "prefix delegation is disabled",
+		})
+
+		return false
+	}
+
+	s.leasesLock.Lock()
+	defer s.leasesLock.Unlock()
+
+	lease, err := s.prefixLease(msg, duid, mac, riapd)
+	if err != nil {
+		log.Debug("dhcpv6: delegating prefix to %s: %s", mac, err)
+
+		code := iana.StatusNoPrefixAvail
+		if errors.Is(err, errNoBinding) {
+			code = iana.StatusNoBinding
+		}
+
+		oiapd.Options.Add(&dhcpv6.OptStatusCode{
+			StatusCode:    code,
+			StatusMessage: err.Error(),
+		})
+
+		return false
+	}
+
+	lifetime := s.conf.leaseTime
+	oiapd.T1 = lifetime / 2
+	oiapd.T2 = time.Duration(float32(lifetime) / 1.5)
+	oiapd.Options.Add(&dhcpv6.OptIAPrefix{
+		PreferredLifetime: lifetime,
+		ValidLifetime:     lifetime,
+		Prefix: &net.IPNet{
+			IP:   lease.Prefix.Addr().AsSlice(),
+			Mask: net.CIDRMask(lease.Prefix.Bits(), net.IPv6len*8),
+		},
+	})
+
+	return true
+}
+
+// release removes the prefix leases of the client with mac released within
+// msg and adds the status into resp.  The address leases aren't released,
+// since those are bound to the hardware address of the client.
+//
+// See https://datatracker.ietf.org/doc/html/rfc8415#section-18.3.7.
+func (s *v6Server) release(msg *dhcpv6.Message, mac net.HardwareAddr, resp dhcpv6.DHCPv6) {
+	duid := clientDUID(msg)
+
+	s.leasesLock.Lock()
+	defer s.leasesLock.Unlock()
+
+	for _, riapd := range msg.Options.IAPD() {
+		i := slices.IndexFunc(s.prefixLeases, func(l *dhcpsvc.Lease) (ok bool) {
+			return l.IAID == riapd.IaId && bytes.Equal(l.DUID, duid) && hasPrefix(riapd, l.Prefix)
+		})
+		if i < 0 {
+			oiapd := &dhcpv6.OptIAPD{
+				IaId: riapd.IaId,
+			}
+			oiapd.Options.Add(&dhcpv6.OptStatusCode{
+				StatusCode:    iana.StatusNoBinding,
+				StatusMessage: errNoBinding.Error(),
+			})
+			resp.AddOption(oiapd)
+
+			continue
+		}
+
+		released := s.prefixLeases[i]
+		log.Debug("dhcpv6: released prefix %s of %s", released.Prefix, mac)
+
+		s.prefixLeases = slices.Delete(s.prefixLeases, i, i+1)
+		s.conf.notify(LeaseChangedDBStore)
+		s.notifyLease(LeaseChangedReleased, released)
+	}
+
+	resp.AddOption(&dhcpv6.OptStatusCode{
+		StatusCode:    iana.StatusSuccess,
+		StatusMessage: "success",
+	})
+}
@@ -0,0 +1,166 @@
+package dhcpsvc
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net/netip"
+	"strings"
+
+	"github.com/google/gopacket/layers"
+)
+
+// ClientArch is the client system architecture type sent within the client
+// system architecture option.
+//
+// See https://datatracker.ietf.org/doc/html/rfc4578#section-2.1.
+type ClientArch uint16
+
+// Client system architecture types.
+//
+// See https://www.iana.org/assignments/dhcpv6-parameters/dhcpv6-parameters.xhtml#processor-architecture.
+const (
+	ArchBIOS      ClientArch = 0
+	ArchUEFIx86   ClientArch = 6
+	ArchUEFIx64   ClientArch = 7
+	ArchUEFIBC    ClientArch = 9
+	ArchUEFIARM32 ClientArch = 10
+	ArchUEFIARM64 ClientArch = 11
+)
+
+// optClientArch is the code of the client system architecture option.
+//
+// See https://datatracker.ietf.org/doc/html/rfc4578#section-2.1.
+const optClientArch layers.DHCPOpt = 93
+
+// pxeClassPrefix is the prefix of the vendor class identifier sent by the PXE
+// clients.
+const pxeClassPrefix = "PXEClient"
+
+// BootConfig is the network boot configuration.
+type BootConfig struct {
+	// ArchFilenames are the boot file names for the client system
+	// architectures.  They take precedence over Filename for the clients
+	// sending the client system architecture option.
+	ArchFilenames map[ClientArch]string
+
+	// NextServer is the address of the server to load the boot file from, sent
+	// within the siaddr field.  If not set, the server identifier is used.
+	NextServer netip.Addr
+
+	// Filename is the default boot file name.
+	Filename string
+}
+
+// maxFilenameLen is the maximum length of the boot file name, which must fit
+// into the file field of the message.
+const maxFilenameLen = 128
+
+// validate returns an error in c, if any.  c may be nil.
+func (c *BootConfig) validate() (err error) {
+	if c == nil {
+		return nil
+	}
+
+	if c.NextServer.IsValid() && !c.NextServer.Is4() {
+		return newMustErr("next server", "be a valid ipv4", c.NextServer)
+	}
+
+	if len(c.Filename) > maxFilenameLen {
+		return fmt.Errorf("filename: too long: %d bytes, max %d", len(c.Filename), maxFilenameLen)
+	}
+
+	for arch, name := range c.ArchFilenames {
+		if len(name) > maxFilenameLen {
+			return fmt.Errorf("filename for arch %d: too long: %d bytes, max %d", arch, len(name), maxFilenameLen)
+		}
+	}
+
+	return nil
+}
+
+// merge returns the boot configuration of c with the fields set in other
+// replacing the ones of c.  Any of c and other may be nil.
+func (c *BootConfig) merge(other *BootConfig) (merged *BootConfig) {
+	switch {
+	case other == nil:
+		return c
+	case c == nil:
+		return other
+	}
+
+	merged = &BootConfig{
+		ArchFilenames: make(map[ClientArch]string, len(c.ArchFilenames)+len(other.ArchFilenames)),
+		NextServer:    c.NextServer,
+		Filename:      c.Filename,
+	}
+
+	for arch, name := range c.ArchFilenames {
+		merged.ArchFilenames[arch] = name
+	}
+
+	for arch, name := range other.ArchFilenames {
+		merged.ArchFilenames[arch] = name
+	}
+
+	if other.NextServer.IsValid() {
+		merged.NextServer = other.NextServer
+	}
+
+	if other.Filename != "" {
+		merged.Filename = other.Filename
+	}
+
+	return merged
+}
+
+// filename returns the boot file name for the client sending req.  name is
+// empty if there is no boot file for the client.
+func (c *BootConfig) filename(req *layers.DHCPv4) (name string) {
+	if c == nil {
+		return ""
+	}
+
+	// The option may contain several architectures, the first one is
+	// preferred.
+	data, _ := optionV4(req.Options, optClientArch)
+	for ; len(data) >= 2; data = data[2:] {
+		if name = c.ArchFilenames[ClientArch(binary.BigEndian.Uint16(data))]; name != "" {
+			return name
+		}
+	}
+
+	return c.Filename
+}
+
+// setBoot sets the network boot parameters of resp to the ones configured for
+// the client sending req, if any.
+func (iface *dhcpInterfaceV4) setBoot(req, resp *layers.DHCPv4) {
+	boot := iface.boot
+	for _, c := range iface.classes {
+		if c.matches(req) {
+			boot = boot.merge(c.Boot)
+		}
+	}
+
+	name := boot.filename(req)
+	if name == "" {
+		return
+	}
+
+	next := boot.NextServer
+	if !next.IsValid() {
+		next = iface.serverID
+	}
+
+	resp.NextServerIP = next.AsSlice()
+	resp.File = []byte(name)
+
+	// PXE clients ignore the offers without the vendor class identifier.
+	vendor, _ := optionV4(req.Options, layers.DHCPOptClassID)
+	if strings.HasPrefix(string(vendor), pxeClassPrefix) {
+		resp.Options = append(
+			resp.Options,
+			layers.NewDHCPOption(layers.DHCPOptClassID, []byte(pxeClassPrefix)),
+		)
+	}
+}
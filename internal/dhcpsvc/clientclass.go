@@ -0,0 +1,203 @@
+package dhcpsvc
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"path"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/google/gopacket/layers"
+)
+
+// optUserClass is the code of the user class option.
+//
+// See https://datatracker.ietf.org/doc/html/rfc3004#section-4.
+const optUserClass layers.DHCPOpt = 77
+
+// ClientClass is a set of DHCPv4 options for the clients matching all of its
+// criteria.  At least one criterion must be set.
+type ClientClass struct {
+	// Name is the name of the class used for logging.  It must not be empty.
+	Name string
+
+	// HWAddrPrefix matches the clients by the prefix of the hardware address,
+	// such as the OUI of the manufacturer.
+	HWAddrPrefix net.HardwareAddr
+
+	// VendorClass matches the clients by the prefix of the vendor class
+	// identifier option.
+	VendorClass string
+
+	// UserClass matches the clients sending the user class option with the
+	// equal class.
+	UserClass string
+
+	// HostnamePattern matches the clients by the hostname option using the
+	// [path.Match] syntax.  The match is case-insensitive.
+	HostnamePattern string
+
+	// Options are the options to send to the matching clients.  They take
+	// precedence over the options of the interface.  The options having a zero
+	// value within the Length field are treated as deletions of the
+	// corresponding options.  The lease time option also changes the duration
+	// of the leases given to the matching clients.
+	Options layers.DHCPOptions
+
+	// Boot is the network boot configuration for the matching clients.  The
+	// fields set in it replace the ones of the interface.  It may be nil.
+	Boot *BootConfig
+}
+
+// validate returns an error in c, if any.
+func (c *ClientClass) validate() (err error) {
+	switch {
+	case c == nil:
+		return errNilConfig
+	case c.Name == "":
+		return errors.Error("empty class name")
+	case len(c.HWAddrPrefix) == 0 &&
+		c.VendorClass == "" &&
+		c.UserClass == "" &&
+		c.HostnamePattern == "":
+		return fmt.Errorf("class %q: no criteria", c.Name)
+	}
+
+	if _, err = path.Match(c.HostnamePattern, ""); err != nil {
+		return fmt.Errorf("class %q: hostname pattern: %w", c.Name, err)
+	}
+
+	if err = c.Boot.validate(); err != nil {
+		return fmt.Errorf("class %q: boot: %w", c.Name, err)
+	}
+
+	if err = validateHostOptions(c.Options); err != nil {
+		return fmt.Errorf("class %q: options: %w", c.Name, err)
+	}
+
+	return nil
+}
+
+// validateHostOptions returns an error if opts can't be used as the options
+// specific to a client.  The lease time option, if any, must be a positive
+// 32-bit number of seconds, since it also sets the duration of the lease.
+func validateHostOptions(opts layers.DHCPOptions) (err error) {
+	for _, o := range opts {
+		if o.Type != layers.DHCPOptLeaseTime {
+			continue
+		}
+
+		if len(o.Data) != 4 {
+			return fmt.Errorf("lease time: bad data length %d, want 4", len(o.Data))
+		} else if binary.BigEndian.Uint32(o.Data) == 0 {
+			return errors.Error("lease time: must be positive")
+		}
+	}
+
+	return nil
+}
+
+// matches returns true if req matches all the criteria of c.
+func (c *ClientClass) matches(req *layers.DHCPv4) (ok bool) {
+	if len(c.HWAddrPrefix) > 0 && !bytes.HasPrefix(req.ClientHWAddr, c.HWAddrPrefix) {
+		return false
+	}
+
+	if c.VendorClass != "" {
+		data, _ := optionV4(req.Options, layers.DHCPOptClassID)
+		if !strings.HasPrefix(string(data), c.VendorClass) {
+			return false
+		}
+	}
+
+	if c.UserClass != "" && !slices.Contains(userClasses(req), c.UserClass) {
+		return false
+	}
+
+	if c.HostnamePattern != "" {
+		data, _ := optionV4(req.Options, layers.DHCPOptHostname)
+		name := strings.ToLower(string(data))
+		if ok, _ = path.Match(strings.ToLower(c.HostnamePattern), name); !ok {
+			return false
+		}
+	}
+
+	return true
+}
+
+// userClasses returns the user classes from the user class option of req.
+// Since some clients send a single class without the length prefix, the whole
+// option data is also considered a class.
+func userClasses(req *layers.DHCPv4) (classes []string) {
+	data, ok := optionV4(req.Options, optUserClass)
+	if !ok || len(data) == 0 {
+		return nil
+	}
+
+	classes = append(classes, string(data))
+	for len(data) > 0 {
+		l := int(data[0])
+		if l == 0 || len(data) < 1+l {
+			break
+		}
+
+		classes = append(classes, string(data[1:1+l]))
+		data = data[1+l:]
+	}
+
+	return classes
+}
+
+// hostOptions returns the options specific to the client sending req: the
+// options of the matching classes in the configured order followed by the
+// options of the static lease l, if any.  The later options take precedence.
+func (iface *dhcpInterfaceV4) hostOptions(req *layers.DHCPv4, l *Lease) (opts layers.DHCPOptions) {
+	for _, c := range iface.classes {
+		if c.matches(req) {
+			opts = append(opts, c.Options...)
+		}
+	}
+
+	if l != nil && l.IsStatic {
+		opts = append(opts, l.Options...)
+	}
+
+	return opts
+}
+
+// leaseDuration returns the duration of the lease for the client with the host
+// options.
+func (iface *dhcpInterfaceV4) leaseDuration(host layers.DHCPOptions) (d time.Duration) {
+	d = iface.common.leaseTTL
+	for _, o := range host {
+		if o.Type == layers.DHCPOptLeaseTime && len(o.Data) == 4 {
+			d = time.Duration(binary.BigEndian.Uint32(o.Data)) * time.Second
+		}
+	}
+
+	return d
+}
+
+// mergeOptions returns opts with the options from host replacing the ones with
+// the same code.  The options from host having a zero Length are deletions.
+// The lease time option is skipped, since it's reported according to
+// [dhcpInterfaceV4.leaseDuration].  opts may be modified.
+func mergeOptions(opts, host layers.DHCPOptions) (merged layers.DHCPOptions) {
+	merged = opts
+	for _, o := range host {
+		if o.Type == layers.DHCPOptLeaseTime {
+			continue
+		}
+
+		merged = slices.DeleteFunc(merged, func(m layers.DHCPOption) (ok bool) { return m.Type == o.Type })
+		if o.Length > 0 {
+			merged = append(merged, o)
+		}
+	}
+
+	return merged
+}
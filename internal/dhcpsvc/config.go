@@ -8,6 +8,7 @@ import (
 	"slices"
 	"time"
 
+	"github.com/AdguardTeam/AdGuardHome/internal/arpdb"
 	"github.com/AdguardTeam/golibs/errors"
 	"github.com/AdguardTeam/golibs/netutil"
 )
@@ -21,6 +22,19 @@ type Config struct {
 	// Logger will be used to log the DHCP events.
 	Logger *slog.Logger
 
+	// ARPDB is used to find the hardware addresses of the devices using the
+	// conflicting addresses.  If nil, they aren't looked up.
+	ARPDB arpdb.Interface
+
+	// OnLeaseEvent is called with a copy of the lease when it's granted or
+	// released.  If nil, the lease events aren't reported.
+	OnLeaseEvent OnLeaseEventFunc
+
+	// PacketListener is used to open the connections to serve DHCP on the
+	// network interfaces.  If nil, the connections listen on the unspecified
+	// address bound to the network interface.
+	PacketListener PacketListener
+
 	// LocalDomainName is the top-level domain name to use for resolving DHCP
 	// clients' hostnames.
 	LocalDomainName string
@@ -31,6 +45,11 @@ type Config struct {
 	// ICMPTimeout is the timeout for checking another DHCP server's presence.
 	ICMPTimeout time.Duration
 
+	// QuarantineDuration is the time during which an address declined by a
+	// client or found in use isn't offered.  If zero, the lease duration of the
+	// interface is used.
+	QuarantineDuration time.Duration
+
 	// Enabled is the state of the service, whether it is enabled or not.
 	Enabled bool
 }
@@ -61,6 +80,11 @@ func (conf *Config) Validate() (err error) {
 		errs = append(errs, err)
 	}
 
+	if conf.QuarantineDuration < 0 {
+		err = newMustErr("quarantine duration", "be non-negative", conf.QuarantineDuration)
+		errs = append(errs, err)
+	}
+
 	err = netutil.ValidateDomainName(conf.LocalDomainName)
 	if err != nil {
 		// Don't wrap the error since it's informative enough as is.
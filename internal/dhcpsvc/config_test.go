@@ -1,11 +1,14 @@
 package dhcpsvc_test
 
 import (
+	"net/netip"
 	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/AdguardTeam/AdGuardHome/internal/dhcpsvc"
 	"github.com/AdguardTeam/golibs/testutil"
+	"github.com/google/gopacket/layers"
 )
 
 func TestConfig_Validate(t *testing.T) {
@@ -88,6 +91,35 @@ func TestConfig_Validate(t *testing.T) {
 		},
 		name:       "nil_ipv6",
 		wantErrMsg: `interface "eth0": ipv6: config is nil`,
+	}, {
+		conf: &dhcpsvc.Config{
+			Enabled:         true,
+			LocalDomainName: testLocalTLD,
+			Interfaces: map[string]*dhcpsvc.InterfaceConfig{
+				"eth0": {
+					IPv4: &dhcpsvc.IPv4Config{
+						Enabled:       true,
+						GatewayIP:     netip.MustParseAddr("192.168.0.1"),
+						SubnetMask:    netip.MustParseAddr("255.255.255.0"),
+						RangeStart:    netip.MustParseAddr("192.168.0.2"),
+						RangeEnd:      netip.MustParseAddr("192.168.0.254"),
+						LeaseDuration: 1 * time.Hour,
+						Classes: []*dhcpsvc.ClientClass{{
+							Name:        "zero",
+							VendorClass: "PXEClient",
+							Options: layers.DHCPOptions{
+								layers.NewDHCPOption(layers.DHCPOptLeaseTime, []byte{0, 0, 0, 0}),
+							},
+						}},
+					},
+					IPv6: &dhcpsvc.IPv6Config{Enabled: false},
+				},
+			},
+			DBFilePath: leasesPath,
+		},
+		name: "zero_class_lease_time",
+		wantErrMsg: `interface "eth0": ipv4: class at index 0: class "zero": options: ` +
+			`lease time: must be positive`,
 	}}
 
 	for _, tc := range testCases {
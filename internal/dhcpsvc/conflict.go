@@ -0,0 +1,147 @@
+package dhcpsvc
+
+import (
+	"cmp"
+	"context"
+	"maps"
+	"net"
+	"net/netip"
+	"slices"
+	"time"
+
+	"github.com/AdguardTeam/golibs/logutil/slogutil"
+)
+
+// ConflictReason is the way the address conflict is detected.
+type ConflictReason string
+
+// Supported conflict reasons.
+const (
+	// ConflictReasonDeclined means that the client declined the address, since
+	// it found the address in use.
+	ConflictReasonDeclined ConflictReason = "declined"
+
+	// ConflictReasonICMP means that the address replied to the ICMP echo
+	// request before being offered.
+	ConflictReasonICMP ConflictReason = "icmp"
+)
+
+// Conflict is an address of the range found to be in use by a device, which
+// didn't lease it.  Such addresses aren't offered until the quarantine ends.
+type Conflict struct {
+	// Detected is the time the conflict is detected at.
+	Detected time.Time
+
+	// Until is the time the quarantine of the address ends at.
+	Until time.Time
+
+	// HWAddr is the hardware address of the device using the address as
+	// reported by ARP.  It's nil if unknown.
+	HWAddr net.HardwareAddr
+
+	// ClientHWAddr is the hardware address of the client the address was
+	// offered to or declined by.
+	ClientHWAddr net.HardwareAddr
+
+	// IP is the conflicting address.
+	IP netip.Addr
+
+	// Reason is the way the conflict is detected.
+	Reason ConflictReason
+}
+
+// clone returns a deep copy of c.
+func (c *Conflict) clone() (cc *Conflict) {
+	cc = &Conflict{}
+	*cc = *c
+	cc.HWAddr = slices.Clone(c.HWAddr)
+	cc.ClientHWAddr = slices.Clone(c.ClientHWAddr)
+
+	return cc
+}
+
+// addConflict quarantines ip of iface found to be in use by another device and
+// records the conflict.  mac is the hardware address of the client the address
+// was offered to or declined by.  It expects [DHCPServer.leasesMu] to be
+// locked.
+func (srv *DHCPServer) addConflict(
+	ctx context.Context,
+	iface *dhcpInterfaceV4,
+	ip netip.Addr,
+	mac net.HardwareAddr,
+	reason ConflictReason,
+) {
+	now := time.Now()
+	maps.DeleteFunc(iface.conflicts, func(_ netip.Addr, c *Conflict) (del bool) {
+		return c.Until.Before(now)
+	})
+
+	iface.conflicts[ip] = &Conflict{
+		Detected:     now,
+		Until:        now.Add(cmp.Or(srv.quarantineDuration, iface.common.leaseTTL)),
+		ClientHWAddr: slices.Clone(mac),
+		IP:           ip,
+		Reason:       reason,
+	}
+
+	iface.common.logger.WarnContext(ctx, "address conflict", "ip", ip, "reason", reason, "mac", mac)
+
+	if srv.arpDB != nil {
+		go srv.resolveConflict(context.WithoutCancel(ctx), iface, ip)
+	}
+}
+
+// resolveConflict looks up the hardware address of the device using the
+// conflicting ip of iface in the ARP table.  It's intended to be used as a
+// goroutine.
+func (srv *DHCPServer) resolveConflict(ctx context.Context, iface *dhcpInterfaceV4, ip netip.Addr) {
+	l := iface.common.logger
+	defer slogutil.RecoverAndLog(ctx, l)
+
+	err := srv.arpDB.Refresh()
+	if err != nil {
+		l.DebugContext(ctx, "refreshing arp", "ip", ip, slogutil.KeyError, err)
+
+		return
+	}
+
+	for _, n := range srv.arpDB.Neighbors() {
+		if n.IP != ip {
+			continue
+		}
+
+		srv.leasesMu.Lock()
+		defer srv.leasesMu.Unlock()
+
+		if c, ok := iface.conflicts[ip]; ok {
+			c.HWAddr = slices.Clone(n.MAC)
+			l.WarnContext(ctx, "address conflict resolved", "ip", ip, "device_mac", n.MAC)
+		}
+
+		return
+	}
+}
+
+// Conflicts returns the address conflicts of all the IPv4 interfaces with the
+// quarantine not ended yet, sorted by the detection time.  It's safe for
+// concurrent use.
+func (srv *DHCPServer) Conflicts() (conflicts []*Conflict) {
+	now := time.Now()
+
+	srv.leasesMu.RLock()
+	defer srv.leasesMu.RUnlock()
+
+	for _, iface := range srv.interfaces4 {
+		for _, c := range iface.conflicts {
+			if c.Until.After(now) {
+				conflicts = append(conflicts, c.clone())
+			}
+		}
+	}
+
+	slices.SortFunc(conflicts, func(a, b *Conflict) (res int) {
+		return a.Detected.Compare(b.Detected)
+	})
+
+	return conflicts
+}
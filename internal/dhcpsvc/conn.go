@@ -0,0 +1,65 @@
+package dhcpsvc
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/netip"
+)
+
+const (
+	// ServerPortV4 is the standard DHCPv4 server port.
+	ServerPortV4 uint16 = 67
+
+	// ClientPortV4 is the standard DHCPv4 client port.
+	ClientPortV4 uint16 = 68
+)
+
+// PacketListener opens packet connections to serve DHCP on network interfaces.
+type PacketListener interface {
+	// ListenPacket returns a connection receiving the packets sent to port from
+	// the network interface with the given name.  The connection must be able
+	// to send broadcast packets.  An empty ifaceName means the connection
+	// receives the packets from any network interface.
+	//
+	// The connection should also implement [HardwareAddrWriter] to unicast the
+	// responses to the clients that have no address yet, otherwise such
+	// responses are broadcast.
+	ListenPacket(ctx context.Context, ifaceName string, port uint16) (conn net.PacketConn, err error)
+}
+
+// HardwareAddrWriter is implemented by the packet connections able to send
+// packets to the hosts that have no IP address configured yet.  See RFC 2131,
+// section 4.1.
+type HardwareAddrWriter interface {
+	// WriteToHardwareAddr writes b to addr, delivering the packet to the host
+	// with hwAddr on the link layer.
+	WriteToHardwareAddr(b []byte, addr *net.UDPAddr, hwAddr net.HardwareAddr) (n int, err error)
+}
+
+// defaultPacketListener is the default [PacketListener] listening on the
+// unspecified address bound to the network interface, where supported.
+type defaultPacketListener struct{}
+
+// type check
+var _ PacketListener = defaultPacketListener{}
+
+// ListenPacket implements the [PacketListener] interface for
+// defaultPacketListener.
+func (defaultPacketListener) ListenPacket(
+	ctx context.Context,
+	ifaceName string,
+	port uint16,
+) (conn net.PacketConn, err error) {
+	lc := &net.ListenConfig{
+		Control: controlFunc(ifaceName),
+	}
+
+	addr := netip.AddrPortFrom(netip.IPv4Unspecified(), port)
+	conn, err = lc.ListenPacket(ctx, "udp4", addr.String())
+	if err != nil {
+		return nil, fmt.Errorf("listening on %s: %w", ifaceName, err)
+	}
+
+	return newPacketConn(conn, ifaceName), nil
+}
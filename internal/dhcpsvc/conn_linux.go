@@ -0,0 +1,135 @@
+//go:build linux
+
+package dhcpsvc
+
+import (
+	"fmt"
+	"net"
+	"syscall"
+	"unsafe"
+
+	"github.com/AdguardTeam/golibs/errors"
+	"golang.org/x/sys/unix"
+)
+
+// controlFunc returns the function configuring the socket to be bound to the
+// network interface with the given name, so that several interfaces are served
+// on the same port.  The socket isn't bound if ifaceName is empty.
+func controlFunc(ifaceName string) (f func(network, address string, c syscall.RawConn) (err error)) {
+	return func(_, _ string, c syscall.RawConn) (err error) {
+		var opErr error
+		err = c.Control(func(fd uintptr) {
+			opErr = unix.SetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_REUSEADDR, 1)
+			if opErr != nil || ifaceName == "" {
+				return
+			}
+
+			opErr = unix.BindToDevice(int(fd), ifaceName)
+		})
+
+		return errors.Join(err, opErr)
+	}
+}
+
+// newPacketConn returns the connection unicasting the packets to the hosts
+// without IP addresses on the network interface with the given name.  conn is
+// returned as is if ifaceName is empty or conn isn't a UDP connection.
+func newPacketConn(conn net.PacketConn, ifaceName string) (c net.PacketConn) {
+	udpConn, ok := conn.(*net.UDPConn)
+	if !ok || ifaceName == "" {
+		return conn
+	}
+
+	return &arpConn{
+		UDPConn:   udpConn,
+		ifaceName: ifaceName,
+	}
+}
+
+// atfCom is the ATF_COM flag of the ARP entry, which marks the entry as
+// completed.  It's not defined in the unix package.
+const atfCom int32 = 0x02
+
+// rawSockaddr is the generic socket address as defined by struct sockaddr.
+type rawSockaddr struct {
+	family uint16
+	data   [14]byte
+}
+
+// arpReq is the request for the SIOCSARP ioctl as defined by struct arpreq.
+type arpReq struct {
+	protoAddr rawSockaddr
+	hwAddr    rawSockaddr
+	flags     int32
+	netmask   rawSockaddr
+	dev       [unix.IFNAMSIZ]byte
+}
+
+// arpConn is a UDP connection bound to a network interface, which unicasts the
+// packets to the hosts without IP addresses by adding the ARP entries for them
+// before sending, the same way dnsmasq does.
+type arpConn struct {
+	*net.UDPConn
+
+	// ifaceName is the name of the network interface the connection is bound
+	// to.
+	ifaceName string
+}
+
+// type check
+var _ HardwareAddrWriter = (*arpConn)(nil)
+
+// WriteToHardwareAddr implements the [HardwareAddrWriter] interface for
+// *arpConn.
+func (c *arpConn) WriteToHardwareAddr(
+	b []byte,
+	addr *net.UDPAddr,
+	hwAddr net.HardwareAddr,
+) (n int, err error) {
+	err = c.setARPEntry(addr.IP, hwAddr)
+	if err != nil {
+		return 0, fmt.Errorf("adding arp entry for %s: %w", addr.IP, err)
+	}
+
+	return c.WriteTo(b, addr)
+}
+
+// setARPEntry adds the ARP entry resolving ip to hwAddr on the network
+// interface of c.
+func (c *arpConn) setARPEntry(ip net.IP, hwAddr net.HardwareAddr) (err error) {
+	ip4 := ip.To4()
+	if ip4 == nil || len(hwAddr) > len(rawSockaddr{}.data) {
+		return fmt.Errorf("bad addresses %s and %s", ip, hwAddr)
+	}
+
+	req := &arpReq{
+		protoAddr: rawSockaddr{family: unix.AF_INET},
+		hwAddr:    rawSockaddr{family: unix.ARPHRD_ETHER},
+		flags:     atfCom,
+	}
+
+	// The address of struct sockaddr_in goes after the 2-byte port.
+	copy(req.protoAddr.data[2:], ip4)
+	copy(req.hwAddr.data[:], hwAddr)
+	copy(req.dev[:unix.IFNAMSIZ-1], c.ifaceName)
+
+	rc, err := c.SyscallConn()
+	if err != nil {
+		return fmt.Errorf("getting raw conn: %w", err)
+	}
+
+	var opErr error
+	err = rc.Control(func(fd uintptr) {
+		_, _, errno := unix.Syscall(
+			unix.SYS_IOCTL,
+			fd,
+			unix.SIOCSARP,
+			uintptr(unsafe.Pointer(req)),
+		)
+		if errno != 0 {
+			opErr = errno
+		}
+	})
+
+	return errors.Join(err, opErr)
+}
@@ -0,0 +1,24 @@
+//go:build !linux
+
+package dhcpsvc
+
+import (
+	"net"
+	"syscall"
+)
+
+// controlFunc returns nil, since binding sockets to network interfaces isn't
+// supported on this platform.
+//
+// TODO(e.burkov):  Use IP_BOUND_IF on macOS and IP_RECVIF on BSDs.
+func controlFunc(_ string) (f func(network, address string, c syscall.RawConn) (err error)) {
+	return nil
+}
+
+// newPacketConn returns conn as is, since unicasting the packets to the hosts
+// without IP addresses isn't supported on this platform.
+//
+// TODO(e.burkov):  Support BPF devices.
+func newPacketConn(conn net.PacketConn, _ string) (c net.PacketConn) {
+	return conn
+}
@@ -2,6 +2,7 @@ package dhcpsvc
 
 import (
 	"context"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"io/fs"
@@ -14,6 +15,7 @@ import (
 
 	"github.com/AdguardTeam/golibs/errors"
 	"github.com/AdguardTeam/golibs/logutil/slogutil"
+	"github.com/google/gopacket/layers"
 	"github.com/google/renameio/v2/maybe"
 )
 
@@ -34,11 +36,23 @@ type dataLeases struct {
 
 // dbLease is the structure of stored lease.
 type dbLease struct {
-	Expiry   string     `json:"expires"`
-	IP       netip.Addr `json:"ip"`
-	Hostname string     `json:"hostname"`
-	HWAddr   string     `json:"mac"`
-	IsStatic bool       `json:"static"`
+	Expiry    string      `json:"expires"`
+	IP        netip.Addr  `json:"ip"`
+	Hostname  string      `json:"hostname"`
+	HWAddr    string      `json:"mac"`
+	CircuitID string      `json:"circuit_id,omitempty"`
+	RemoteID  string      `json:"remote_id,omitempty"`
+	Options   []*dbOption `json:"options,omitempty"`
+	IsStatic  bool        `json:"static"`
+}
+
+// dbOption is the structure of stored DHCP option of a lease.
+type dbOption struct {
+	// Data is the hexadecimal-encoded data of the option.
+	Data string `json:"data"`
+
+	// Code is the code of the option.
+	Code uint8 `json:"code"`
 }
 
 // compareNames returns the result of comparing the hostnames of dl and other
@@ -59,12 +73,27 @@ func toDBLease(l *Lease) (dl *dbLease) {
 	}
 
 	return &dbLease{
-		Expiry:   expiryStr,
-		Hostname: l.Hostname,
-		HWAddr:   l.HWAddr.String(),
-		IP:       l.IP,
-		IsStatic: l.IsStatic,
+		Expiry:    expiryStr,
+		Hostname:  l.Hostname,
+		HWAddr:    l.HWAddr.String(),
+		IP:        l.IP,
+		CircuitID: l.CircuitID,
+		RemoteID:  l.RemoteID,
+		Options:   toDBOptions(l.Options),
+		IsStatic:  l.IsStatic,
+	}
+}
+
+// toDBOptions converts opts to the stored options.
+func toDBOptions(opts layers.DHCPOptions) (dbOpts []*dbOption) {
+	for _, o := range opts {
+		dbOpts = append(dbOpts, &dbOption{
+			Data: hex.EncodeToString(o.Data),
+			Code: uint8(o.Type),
+		})
 	}
+
+	return dbOpts
 }
 
 // toInternal converts dl to *Lease.
@@ -82,12 +111,26 @@ func (dl *dbLease) toInternal() (l *Lease, err error) {
 		}
 	}
 
+	var opts layers.DHCPOptions
+	for i, o := range dl.Options {
+		var data []byte
+		data, err = hex.DecodeString(o.Data)
+		if err != nil {
+			return nil, fmt.Errorf("parsing option at index %d: %w", i, err)
+		}
+
+		opts = append(opts, layers.NewDHCPOption(layers.DHCPOpt(o.Code), data))
+	}
+
 	return &Lease{
-		Expiry:   expiry,
-		IP:       dl.IP,
-		Hostname: dl.Hostname,
-		HWAddr:   mac,
-		IsStatic: dl.IsStatic,
+		Expiry:    expiry,
+		IP:        dl.IP,
+		Hostname:  dl.Hostname,
+		HWAddr:    mac,
+		CircuitID: dl.CircuitID,
+		RemoteID:  dl.RemoteID,
+		Options:   opts,
+		IsStatic:  dl.IsStatic,
 	}, nil
 }
 
@@ -73,6 +73,10 @@ type Interface interface {
 	//
 	// TODO(e.burkov):  If it's really needed?
 	Reset(ctx context.Context) (err error)
+
+	// Conflicts returns the addresses found to be in use by other devices,
+	// which are still quarantined, sorted by the detection time.
+	Conflicts() (conflicts []*Conflict)
 }
 
 // Empty is an [Interface] implementation that does nothing.
@@ -119,3 +123,6 @@ func (Empty) RemoveLease(_ context.Context, _ *Lease) (err error) { return nil }
 
 // Reset implements the [Interface] interface for Empty.
 func (Empty) Reset(_ context.Context) (err error) { return nil }
+
+// Conflicts implements the [Interface] interface for Empty.
+func (Empty) Conflicts() (conflicts []*Conflict) { return nil }
@@ -89,6 +89,14 @@ func (iface *netInterface) updateLease(l *Lease) (prev *Lease, err error) {
 	return prev, nil
 }
 
+// leaseByMAC returns the lease of the client with mac within iface.  It returns
+// false if there is no such lease.
+func (iface *netInterface) leaseByMAC(mac net.HardwareAddr) (l *Lease, ok bool) {
+	l, ok = iface.leases[macToKey(mac)]
+
+	return l, ok
+}
+
 // removeLease removes an existing lease from iface.  It returns an error if
 // there is no lease equal to l.
 func (iface *netInterface) removeLease(l *Lease) (err error) {
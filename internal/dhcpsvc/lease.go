@@ -1,10 +1,13 @@
 package dhcpsvc
 
 import (
+	"context"
 	"net"
 	"net/netip"
 	"slices"
 	"time"
+
+	"github.com/google/gopacket/layers"
 )
 
 // Lease is a DHCP lease.
@@ -26,10 +29,76 @@ type Lease struct {
 	// HWAddr is the physical hardware address (MAC address).
 	HWAddr net.HardwareAddr
 
+	// CircuitID is the Agent Circuit ID suboption of the relay agent
+	// information option.  If set for a static lease, the lease is given to
+	// any client connected through the matching circuit.
+	//
+	// See https://datatracker.ietf.org/doc/html/rfc3046#section-3.1.
+	CircuitID string
+
+	// RemoteID is the Agent Remote ID suboption of the relay agent information
+	// option.  If set for a static lease, the lease is given to any client
+	// relayed with the matching remote ID.
+	//
+	// See https://datatracker.ietf.org/doc/html/rfc3046#section-3.2.
+	RemoteID string
+
+	// Options are the DHCPv4 options to send to the client holding a static
+	// lease.  They take precedence over the options of the interface and the
+	// client classes.  The options having a zero value within the Length field
+	// are treated as deletions of the corresponding options.
+	Options layers.DHCPOptions
+
+	// Prefix is the IPv6 prefix delegated to the client, which is a requesting
+	// router.  IP isn't set for such leases.
+	//
+	// See https://datatracker.ietf.org/doc/html/rfc8415#section-6.3.
+	Prefix netip.Prefix
+
+	// DUID is the DHCP unique identifier of the requesting router holding the
+	// delegated prefix.  Together with IAID, it identifies the binding of the
+	// prefix.
+	//
+	// See https://datatracker.ietf.org/doc/html/rfc8415#section-11.
+	DUID []byte
+
+	// IAID is the identifier of the identity association of the delegated
+	// prefix.  It's unique among the identity associations of the client.
+	IAID [4]byte
+
 	// IsStatic defines if the lease is static.
 	IsStatic bool
 }
 
+// LeaseEvent is the type of a change of a lease reported to
+// [Config.OnLeaseEvent].
+type LeaseEvent uint8
+
+const (
+	// LeaseEventGranted means that the lease is acknowledged to the client.
+	LeaseEventGranted LeaseEvent = iota + 1
+
+	// LeaseEventReleased means that the lease is released or declined by the
+	// client, or has expired.
+	LeaseEventReleased
+)
+
+// OnLeaseEventFunc is called with a copy of the lease l on the event ev.  It's
+// called while the leases are locked, so it must not block or call the methods
+// of the server.
+type OnLeaseEventFunc func(ctx context.Context, ev LeaseEvent, l *Lease)
+
+// matchesRelay returns true if l is a static lease bound to the relay agent
+// information in info.  All the suboptions set in l must match.
+func (l *Lease) matchesRelay(info relayAgentInfo) (ok bool) {
+	if !l.IsStatic || (l.CircuitID == "" && l.RemoteID == "") {
+		return false
+	}
+
+	return (l.CircuitID == "" || l.CircuitID == info.circuitID) &&
+		(l.RemoteID == "" || l.RemoteID == info.remoteID)
+}
+
 // Clone returns a deep copy of l.
 func (l *Lease) Clone() (clone *Lease) {
 	if l == nil {
@@ -37,10 +106,31 @@ func (l *Lease) Clone() (clone *Lease) {
 	}
 
 	return &Lease{
-		Expiry:   l.Expiry,
-		Hostname: l.Hostname,
-		HWAddr:   slices.Clone(l.HWAddr),
-		IP:       l.IP,
-		IsStatic: l.IsStatic,
+		Expiry:    l.Expiry,
+		Hostname:  l.Hostname,
+		HWAddr:    slices.Clone(l.HWAddr),
+		IP:        l.IP,
+		CircuitID: l.CircuitID,
+		RemoteID:  l.RemoteID,
+		Options:   cloneOptions(l.Options),
+		Prefix:    l.Prefix,
+		DUID:      slices.Clone(l.DUID),
+		IAID:      l.IAID,
+		IsStatic:  l.IsStatic,
 	}
 }
+
+// cloneOptions returns a deep copy of opts.
+func cloneOptions(opts layers.DHCPOptions) (clone layers.DHCPOptions) {
+	if opts == nil {
+		return nil
+	}
+
+	clone = make(layers.DHCPOptions, 0, len(opts))
+	for _, o := range opts {
+		o.Data = slices.Clone(o.Data)
+		clone = append(clone, o)
+	}
+
+	return clone
+}
@@ -12,7 +12,9 @@ import (
 	"sync/atomic"
 	"time"
 
+	"github.com/AdguardTeam/AdGuardHome/internal/arpdb"
 	"github.com/AdguardTeam/golibs/errors"
+	"github.com/AdguardTeam/golibs/logutil/slogutil"
 )
 
 // DHCPServer is a DHCP server for both IPv4 and IPv6 address families.
@@ -47,10 +49,41 @@ type DHCPServer struct {
 	// interfaces6 is the set of IPv6 interfaces sorted by interface name.
 	interfaces6 dhcpInterfacesV6
 
+	// conf is the configuration the server is created with.
+	conf *Config
+
+	// listener opens the connections to serve DHCP on.
+	listener PacketListener
+
+	// relayConn is the connection receiving the requests from relay agents for
+	// the relayed subnets.  It's nil if there are none or the server isn't
+	// started.
+	relayConn net.PacketConn
+
+	// wg tracks the goroutines serving DHCP.
+	wg *sync.WaitGroup
+
+	// done is closed when the server is shut down.
+	done chan struct{}
+
+	// onLeaseEvent is called on the lease events.  It may be nil.
+	onLeaseEvent OnLeaseEventFunc
+
+	// arpDB is used to find the hardware addresses of the devices using the
+	// conflicting addresses.  It may be nil.
+	arpDB arpdb.Interface
+
 	// icmpTimeout is the timeout for checking another DHCP server's presence.
 	icmpTimeout time.Duration
+
+	// quarantineDuration is the time during which a conflicting address isn't
+	// offered.  If zero, the lease duration of the interface is used.
+	quarantineDuration time.Duration
 }
 
+// sweepInterval is the interval between the removals of the expired leases.
+const sweepInterval = 1 * time.Minute
+
 // New creates a new DHCP server with the given configuration.  It returns an
 // error if the given configuration can't be used.
 //
@@ -73,16 +106,27 @@ func New(ctx context.Context, conf *Config) (srv *DHCPServer, err error) {
 	enabled := &atomic.Bool{}
 	enabled.Store(conf.Enabled)
 
+	listener := conf.PacketListener
+	if listener == nil {
+		listener = defaultPacketListener{}
+	}
+
 	srv = &DHCPServer{
-		enabled:     enabled,
-		logger:      l,
-		localTLD:    conf.LocalDomainName,
-		leasesMu:    &sync.RWMutex{},
-		leases:      newLeaseIndex(),
-		interfaces4: ifaces4,
-		interfaces6: ifaces6,
-		icmpTimeout: conf.ICMPTimeout,
-		dbFilePath:  conf.DBFilePath,
+		enabled:            enabled,
+		logger:             l,
+		conf:               conf,
+		localTLD:           conf.LocalDomainName,
+		leasesMu:           &sync.RWMutex{},
+		leases:             newLeaseIndex(),
+		interfaces4:        ifaces4,
+		interfaces6:        ifaces6,
+		listener:           listener,
+		wg:                 &sync.WaitGroup{},
+		onLeaseEvent:       conf.OnLeaseEvent,
+		arpDB:              conf.ARPDB,
+		icmpTimeout:        conf.ICMPTimeout,
+		quarantineDuration: conf.QuarantineDuration,
+		dbFilePath:         conf.DBFilePath,
 	}
 
 	err = srv.dbLoad(ctx)
@@ -132,9 +176,191 @@ func newInterfaces(
 }
 
 // type check
+var _ Interface = (*DHCPServer)(nil)
+
+// Config implements the [agh.ServiceWithConfig] interface for *DHCPServer.
+func (srv *DHCPServer) Config() (conf *Config) {
+	return srv.conf
+}
+
+// Start implements the [service.Interface] interface for *DHCPServer.  It opens
+// the connections on the DHCPv4 interfaces and starts serving them.
 //
-// TODO(e.burkov):  Uncomment when the [Interface] interface is implemented.
-// var _ Interface = (*DHCPServer)(nil)
+// TODO(e.burkov):  Serve DHCPv6.
+func (srv *DHCPServer) Start(ctx context.Context) (err error) {
+	defer func() { err = errors.Annotate(err, "starting dhcp server: %w") }()
+
+	var errs []error
+	hasRelayed := false
+	for _, iface := range srv.interfaces4 {
+		if iface.relayed {
+			hasRelayed = true
+
+			continue
+		}
+
+		iface.setServerID(ctx)
+		iface.conn, err = srv.listener.ListenPacket(ctx, iface.common.name, ServerPortV4)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("interface %q: %w", iface.common.name, err))
+		}
+	}
+
+	if hasRelayed {
+		srv.relayConn, err = srv.listener.ListenPacket(ctx, "", ServerPortV4)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("relayed subnets: %w", err))
+		}
+	}
+
+	if err = errors.Join(errs...); err != nil {
+		err = errors.WithDeferred(err, srv.closeConns())
+		srv.resetConns()
+
+		return err
+	}
+
+	srv.done = make(chan struct{})
+	for _, iface := range srv.interfaces4 {
+		if iface.conn != nil {
+			srv.wg.Add(1)
+			go srv.serveV4(context.WithoutCancel(ctx), iface.conn, iface)
+		}
+	}
+
+	if srv.relayConn != nil {
+		srv.wg.Add(1)
+		go srv.serveV4(context.WithoutCancel(ctx), srv.relayConn, nil)
+	}
+
+	srv.wg.Add(1)
+	go srv.sweepExpired(context.WithoutCancel(ctx))
+
+	srv.logger.InfoContext(ctx, "started", "ifaces_v4", len(srv.interfaces4))
+
+	return nil
+}
+
+// Shutdown implements the [service.Interface] interface for *DHCPServer.  It
+// closes the connections and waits for the serving goroutines to finish.
+func (srv *DHCPServer) Shutdown(ctx context.Context) (err error) {
+	if srv.done == nil {
+		return nil
+	}
+
+	close(srv.done)
+	err = srv.closeConns()
+	srv.wg.Wait()
+	srv.resetConns()
+	srv.done = nil
+
+	srv.logger.InfoContext(ctx, "stopped")
+
+	return errors.Annotate(err, "shutting down dhcp server: %w")
+}
+
+// closeConns closes the opened connections of the DHCPv4 interfaces.
+func (srv *DHCPServer) closeConns() (err error) {
+	var errs []error
+	for _, iface := range srv.interfaces4 {
+		if iface.conn == nil {
+			continue
+		}
+
+		err = iface.conn.Close()
+		if err != nil {
+			errs = append(errs, fmt.Errorf("interface %q: %w", iface.common.name, err))
+		}
+	}
+
+	if srv.relayConn != nil {
+		err = srv.relayConn.Close()
+		if err != nil {
+			errs = append(errs, fmt.Errorf("relayed subnets: %w", err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// resetConns forgets the closed connections of the DHCPv4 interfaces.  It must
+// only be called when the interfaces aren't served.
+func (srv *DHCPServer) resetConns() {
+	for _, iface := range srv.interfaces4 {
+		iface.conn = nil
+	}
+
+	srv.relayConn = nil
+}
+
+// sweepExpired periodically removes the expired dynamic leases until the
+// server is shut down.
+func (srv *DHCPServer) sweepExpired(ctx context.Context) {
+	defer srv.wg.Done()
+	defer slogutil.RecoverAndLog(ctx, srv.logger)
+
+	ticker := time.NewTicker(sweepInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-srv.done:
+			return
+		case now := <-ticker.C:
+			srv.removeExpired(ctx, now)
+		}
+	}
+}
+
+// removeExpired removes the dynamic leases expired before now and stores the
+// leases if any were removed.
+func (srv *DHCPServer) removeExpired(ctx context.Context, now time.Time) {
+	srv.leasesMu.Lock()
+	defer srv.leasesMu.Unlock()
+
+	var expired []*Lease
+	srv.leases.rangeLeases(func(l *Lease) (cont bool) {
+		if !l.IsStatic && l.Expiry.Before(now) {
+			expired = append(expired, l)
+		}
+
+		return true
+	})
+
+	if len(expired) == 0 {
+		return
+	}
+
+	for _, l := range expired {
+		iface, err := srv.ifaceForAddr(l.IP)
+		if err == nil {
+			err = srv.leases.remove(l, iface)
+		}
+
+		if err != nil {
+			srv.logger.ErrorContext(ctx, "removing expired lease", slogutil.KeyError, err)
+
+			continue
+		}
+
+		srv.leaseEvent(ctx, LeaseEventReleased, l)
+	}
+
+	err := srv.dbStore(ctx)
+	if err != nil {
+		srv.logger.ErrorContext(ctx, "storing leases", slogutil.KeyError, err)
+	}
+
+	srv.logger.DebugContext(ctx, "removed expired leases", "count", len(expired))
+}
+
+// leaseEvent reports the event ev of the lease l, if needed.  srv.leasesMu is
+// expected to be locked.
+func (srv *DHCPServer) leaseEvent(ctx context.Context, ev LeaseEvent, l *Lease) {
+	if srv.onLeaseEvent != nil {
+		srv.onLeaseEvent(ctx, ev, l.Clone())
+	}
+}
 
 // Enabled implements the [Interface] interface for *DHCPServer.
 func (srv *DHCPServer) Enabled() (ok bool) {
@@ -226,6 +452,11 @@ func (srv *DHCPServer) resetLeases() {
 func (srv *DHCPServer) AddLease(ctx context.Context, l *Lease) (err error) {
 	defer func() { err = errors.Annotate(err, "adding lease: %w") }()
 
+	err = validateHostOptions(l.Options)
+	if err != nil {
+		return fmt.Errorf("options: %w", err)
+	}
+
 	addr := l.IP
 	iface, err := srv.ifaceForAddr(addr)
 	if err != nil {
@@ -265,6 +496,11 @@ func (srv *DHCPServer) AddLease(ctx context.Context, l *Lease) (err error) {
 func (srv *DHCPServer) UpdateStaticLease(ctx context.Context, l *Lease) (err error) {
 	defer func() { err = errors.Annotate(err, "updating static lease: %w") }()
 
+	err = validateHostOptions(l.Options)
+	if err != nil {
+		return fmt.Errorf("options: %w", err)
+	}
+
 	addr := l.IP
 	iface, err := srv.ifaceForAddr(addr)
 	if err != nil {
@@ -10,6 +10,7 @@ import (
 	"time"
 
 	"github.com/AdguardTeam/golibs/errors"
+	"github.com/AdguardTeam/golibs/logutil/slogutil"
 	"github.com/AdguardTeam/golibs/netutil"
 	"github.com/google/gopacket/layers"
 )
@@ -36,9 +37,28 @@ type IPv4Config struct {
 	// the corresponding options, either implicit or explicit.
 	Options layers.DHCPOptions
 
+	// ServerIP is the address of the server reachable by the relay agents.  It
+	// is used as the server identifier and the DNS server address of the
+	// relayed subnet.  It must be set if Relayed is true.
+	ServerIP netip.Addr
+
 	// LeaseDuration is the TTL of a DHCP lease.
 	LeaseDuration time.Duration
 
+	// Relayed is true if the clients of the subnet are only reachable through
+	// DHCP relay agents.  No connection is opened on the network interface of
+	// a relayed subnet, so its name only identifies the subnet, and the
+	// requests are chosen by the relay agent address.
+	Relayed bool
+
+	// Classes are the client classes of the subnet.  The options of all the
+	// classes matching a client are applied in the given order.
+	Classes []*ClientClass
+
+	// Boot is the network boot configuration of the subnet.  It may be nil,
+	// and may be overridden by the classes.
+	Boot *BootConfig
+
 	// Enabled is the state of the DHCPv4 service, whether it is enabled or not
 	// on the specific interface.
 	Enabled bool
@@ -74,11 +94,27 @@ func (c *IPv4Config) validate() (err error) {
 		errs = append(errs, err)
 	}
 
+	if c.Relayed && !c.ServerIP.Is4() {
+		err = newMustErr("server ip", "be a valid ipv4 for relayed subnet", c.ServerIP)
+		errs = append(errs, err)
+	}
+
 	if c.LeaseDuration <= 0 {
 		err = newMustErr("icmp timeout", "be positive", c.LeaseDuration)
 		errs = append(errs, err)
 	}
 
+	if err = c.Boot.validate(); err != nil {
+		errs = append(errs, fmt.Errorf("boot: %w", err))
+	}
+
+	for i, cc := range c.Classes {
+		err = cc.validate()
+		if err != nil {
+			errs = append(errs, fmt.Errorf("class at index %d: %w", i, err))
+		}
+	}
+
 	return errors.Join(errs...)
 }
 
@@ -105,6 +141,31 @@ type dhcpInterfaceV4 struct {
 	// explicitOpts are the user-configured options.  It must not have
 	// intersections with implicitOpts.
 	explicitOpts layers.DHCPOptions
+
+	// conn is the connection to serve DHCPv4 on.  It's nil until the server
+	// is started.
+	conn net.PacketConn
+
+	// conflicts are the addresses declined by clients or found in use, which
+	// aren't offered until their quarantine ends.  It's protected by
+	// [DHCPServer.leasesMu].
+	conflicts map[netip.Addr]*Conflict
+
+	// serverID is the address identifying the server on the interface.  It's
+	// the configured server address for a relayed subnet, the address of the
+	// network interface within subnet, if any, and the gateway address
+	// otherwise.
+	serverID netip.Addr
+
+	// relayed is true if the clients of the subnet are only reachable through
+	// DHCP relay agents.
+	relayed bool
+
+	// classes are the client classes of the subnet.
+	classes []*ClientClass
+
+	// boot is the network boot configuration of the subnet, if any.
+	boot *BootConfig
 }
 
 // newDHCPInterfaceV4 creates a new DHCP interface for IPv4 address family with
@@ -149,12 +210,70 @@ func newDHCPInterfaceV4(
 		subnet:    subnet,
 		addrSpace: addrSpace,
 		common:    newNetInterface(name, l, conf.LeaseDuration),
+		conflicts: map[netip.Addr]*Conflict{},
+		serverID:  conf.GatewayIP,
+		relayed:   conf.Relayed,
+		classes:   conf.Classes,
+		boot:      conf.Boot,
 	}
 	i.implicitOpts, i.explicitOpts = conf.options(ctx, l)
 
+	if conf.Relayed {
+		i.serverID = conf.ServerIP
+		i.setDNSOption(conf.ServerIP)
+	}
+
 	return i, nil
 }
 
+// setServerID sets the server identifier of iface to the address of the network
+// interface within the subnet, if any.  It also announces that address as the
+// DNS server, unless the option is configured explicitly.
+func (iface *dhcpInterfaceV4) setServerID(ctx context.Context) {
+	l := iface.common.logger
+
+	netIface, err := net.InterfaceByName(iface.common.name)
+	if err != nil {
+		l.DebugContext(ctx, "using gateway as server id", slogutil.KeyError, err)
+
+		return
+	}
+
+	addrs, err := netIface.Addrs()
+	if err != nil {
+		l.DebugContext(ctx, "using gateway as server id", slogutil.KeyError, err)
+
+		return
+	}
+
+	for _, addr := range addrs {
+		ipNet, ok := addr.(*net.IPNet)
+		if !ok {
+			continue
+		}
+
+		ip, ok := netip.AddrFromSlice(ipNet.IP.To4())
+		if ok && iface.subnet.Contains(ip) {
+			iface.serverID = ip
+			iface.setDNSOption(ip)
+
+			return
+		}
+	}
+}
+
+// setDNSOption adds the implicit option announcing ip as the DNS server, unless
+// the option is configured explicitly.
+func (iface *dhcpInterfaceV4) setDNSOption(ip netip.Addr) {
+	isDNS := func(o layers.DHCPOption) (ok bool) { return o.Type == layers.DHCPOptDNS }
+	if slices.ContainsFunc(iface.explicitOpts, isDNS) {
+		return
+	}
+
+	iface.implicitOpts = slices.DeleteFunc(iface.implicitOpts, isDNS)
+	iface.implicitOpts = append(iface.implicitOpts, layers.NewDHCPOption(layers.DHCPOptDNS, ip.AsSlice()))
+}
+
 // dhcpInterfacesV4 is a slice of network interfaces of IPv4 address family.
 type dhcpInterfacesV4 []*dhcpInterfaceV4
 
@@ -0,0 +1,200 @@
+package dhcpsvc
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"slices"
+
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/AdguardTeam/golibs/logutil/slogutil"
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+// optRelayAgentInfo is the code of the relay agent information option.
+//
+// See https://datatracker.ietf.org/doc/html/rfc3046#section-2.
+const optRelayAgentInfo layers.DHCPOpt = 82
+
+// The codes of the suboptions of the relay agent information option.
+//
+// See https://datatracker.ietf.org/doc/html/rfc3046#section-2.0.
+const (
+	subOptCircuitID byte = 1
+	subOptRemoteID  byte = 2
+)
+
+// relayAgentInfo is the relay agent information option of a request.
+type relayAgentInfo struct {
+	// circuitID is the Agent Circuit ID suboption, if any.
+	circuitID string
+
+	// remoteID is the Agent Remote ID suboption, if any.
+	remoteID string
+
+	// raw is the data of the whole option to echo in the response.  It's nil
+	// if the request has no such option.
+	raw []byte
+}
+
+// newRelayAgentInfo returns the relay agent information of msg.  The malformed
+// suboptions are ignored, but the option is still echoed.
+func newRelayAgentInfo(msg *layers.DHCPv4) (info relayAgentInfo) {
+	data, ok := optionV4(msg.Options, optRelayAgentInfo)
+	if !ok || len(data) == 0 {
+		return relayAgentInfo{}
+	}
+
+	info.raw = slices.Clone(data)
+	for len(data) >= 2 {
+		code, l := data[0], int(data[1])
+		if len(data) < 2+l {
+			break
+		}
+
+		switch val := string(data[2 : 2+l]); code {
+		case subOptCircuitID:
+			info.circuitID = val
+		case subOptRemoteID:
+			info.remoteID = val
+		default:
+			// Go on.
+		}
+
+		data = data[2+l:]
+	}
+
+	return info
+}
+
+// HandleRelayedV4 handles the DHCPv4 request from a relay agent in pkt, which
+// is received on conn by another server, and sends the response, if any, using
+// conn.  It returns an error if pkt isn't a relayed request.
+func (srv *DHCPServer) HandleRelayedV4(
+	ctx context.Context,
+	conn net.PacketConn,
+	pkt []byte,
+) (err error) {
+	req := &layers.DHCPv4{}
+	err = req.DecodeFromBytes(pkt, gopacket.NilDecodeFeedback)
+	if err != nil {
+		return fmt.Errorf("decoding packet: %w", err)
+	}
+
+	if !ipFromField(req.RelayAgentIP).IsValid() {
+		return errors.Error("not a relayed request")
+	}
+
+	// Don't wrap the error, because it's informative enough as is.
+	return srv.respondV4(ctx, conn, nil, req)
+}
+
+// scopeV4 returns the interface to handle req received on recv, which is nil
+// for the connection receiving only the relayed requests.  The requests from
+// relay agents are handled by the interface with the subnet containing the
+// relay agent address.  ok is false if req should be ignored.
+func (srv *DHCPServer) scopeV4(recv *dhcpInterfaceV4, req *layers.DHCPv4) (iface *dhcpInterfaceV4, ok bool) {
+	giaddr := ipFromField(req.RelayAgentIP)
+	if !giaddr.IsValid() {
+		return recv, recv != nil
+	}
+
+	i := slices.IndexFunc(srv.interfaces4, func(iface *dhcpInterfaceV4) (contains bool) {
+		return iface.subnet.Contains(giaddr)
+	})
+	if i < 0 {
+		return nil, false
+	}
+
+	return srv.interfaces4[i], true
+}
+
+// bindRelayLease gives the static lease bound to the relay agent information in
+// info to the client with mac by replacing the hardware address of the lease.
+// The dynamic lease of the client, if any, is removed.  If several static
+// leases match info, the one with the lowest address is used.
+func (srv *DHCPServer) bindRelayLease(
+	ctx context.Context,
+	iface *dhcpInterfaceV4,
+	info relayAgentInfo,
+	mac net.HardwareAddr,
+) {
+	if info.circuitID == "" && info.remoteID == "" {
+		return
+	}
+
+	srv.leasesMu.Lock()
+	defer srv.leasesMu.Unlock()
+
+	bound := relayLease(iface.common, info)
+	if bound == nil || bytes.Equal(bound.HWAddr, mac) {
+		return
+	}
+
+	l := iface.common.logger
+	own, _ := iface.common.leaseByMAC(mac)
+	if own != nil && own.IsStatic {
+		l.WarnContext(ctx, "static lease of client conflicts with relay", "mac", mac)
+
+		return
+	}
+
+	rebound := bound.Clone()
+	rebound.HWAddr = slices.Clone(mac)
+
+	err := srv.rebindLease(iface, own, bound, rebound)
+	if err != nil {
+		l.ErrorContext(ctx, "rebinding lease", slogutil.KeyError, err)
+
+		return
+	}
+
+	err = srv.dbStore(ctx)
+	if err != nil {
+		l.ErrorContext(ctx, "storing leases", slogutil.KeyError, err)
+	}
+
+	l.InfoContext(ctx, "rebound lease to relayed client", "ip", rebound.IP, "mac", mac)
+}
+
+// relayLease returns the static lease of iface bound to the relay agent
+// information in info with the lowest address, if any.
+func relayLease(iface *netInterface, info relayAgentInfo) (bound *Lease) {
+	for _, l := range iface.leases {
+		if l.matchesRelay(info) && (bound == nil || l.IP.Less(bound.IP)) {
+			bound = l
+		}
+	}
+
+	return bound
+}
+
+// rebindLease replaces bound with rebound in the leases of iface.  own is the
+// dynamic lease of the client, which is removed first, if not nil.  The
+// removed leases are restored on error.  srv.leasesMu must be locked.
+func (srv *DHCPServer) rebindLease(iface *dhcpInterfaceV4, own, bound, rebound *Lease) (err error) {
+	if own != nil {
+		err = srv.leases.remove(own, iface.common)
+		if err != nil {
+			return fmt.Errorf("removing lease of client: %w", err)
+		}
+	}
+
+	err = srv.leases.remove(bound, iface.common)
+	if err == nil {
+		err = srv.leases.add(rebound, iface.common)
+		if err == nil {
+			return nil
+		}
+
+		err = errors.WithDeferred(err, srv.leases.add(bound, iface.common))
+	}
+
+	if own != nil {
+		err = errors.WithDeferred(err, srv.leases.add(own, iface.common))
+	}
+
+	return err
+}
@@ -0,0 +1,88 @@
+package dhcpsvc
+
+import (
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/AdguardTeam/golibs/logutil/slogutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRelayLease(t *testing.T) {
+	iface := newNetInterface("eth0", slogutil.NewDiscardLogger(), time.Hour)
+
+	for i, ip := range []string{"10.0.0.30", "10.0.0.10", "10.0.0.20"} {
+		l := &Lease{
+			IP:        netip.MustParseAddr(ip),
+			HWAddr:    net.HardwareAddr{0xAA, 0xAA, 0xAA, 0xAA, 0xAA, byte(i)},
+			CircuitID: "port-1",
+			IsStatic:  true,
+		}
+		require.NoError(t, iface.addLease(l))
+	}
+
+	// Repeat to make sure the result doesn't depend on the iteration order.
+	for range 10 {
+		bound := relayLease(iface, relayAgentInfo{circuitID: "port-1"})
+		require.NotNil(t, bound)
+
+		assert.Equal(t, netip.MustParseAddr("10.0.0.10"), bound.IP)
+	}
+
+	assert.Nil(t, relayLease(iface, relayAgentInfo{circuitID: "port-2"}))
+}
+
+func TestDHCPServer_rebindLease_rollback(t *testing.T) {
+	common := newNetInterface("eth0", slogutil.NewDiscardLogger(), time.Hour)
+	iface := &dhcpInterfaceV4{common: common}
+	srv := &DHCPServer{leases: newLeaseIndex()}
+
+	mac := net.HardwareAddr{0xBB, 0xBB, 0xBB, 0xBB, 0xBB, 0xBB}
+	own := &Lease{
+		IP:       netip.MustParseAddr("10.0.0.100"),
+		Hostname: "own",
+		HWAddr:   mac,
+	}
+	bound := &Lease{
+		IP:        netip.MustParseAddr("10.0.0.10"),
+		Hostname:  "bound",
+		HWAddr:    net.HardwareAddr{0xAA, 0xAA, 0xAA, 0xAA, 0xAA, 0xAA},
+		CircuitID: "port-1",
+		IsStatic:  true,
+	}
+
+	require.NoError(t, srv.leases.add(own, common))
+	require.NoError(t, srv.leases.add(bound, common))
+
+	rebound := bound.Clone()
+	rebound.HWAddr = mac
+
+	// Don't pass the lease of the client to make adding the rebound lease
+	// fail.
+	err := srv.rebindLease(iface, nil, bound, rebound)
+	require.Error(t, err)
+
+	got, ok := srv.leases.leaseByAddr(bound.IP)
+	require.True(t, ok)
+
+	assert.Same(t, bound, got)
+
+	got, ok = common.leaseByMAC(bound.HWAddr)
+	require.True(t, ok)
+
+	assert.Same(t, bound, got)
+
+	err = srv.rebindLease(iface, own, bound, rebound)
+	require.NoError(t, err)
+
+	got, ok = common.leaseByMAC(mac)
+	require.True(t, ok)
+
+	assert.Same(t, rebound, got)
+
+	_, ok = srv.leases.leaseByAddr(own.IP)
+	assert.False(t, ok)
+}
@@ -0,0 +1,754 @@
+package dhcpsvc
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"net/netip"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/aghnet"
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/AdguardTeam/golibs/logutil/slogutil"
+	"github.com/AdguardTeam/golibs/netutil"
+	"github.com/go-ping/ping"
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+const (
+	// offerDuration is the time an offered address is reserved for the client
+	// until it requests it.
+	offerDuration = 1 * time.Minute
+
+	// maxProbeAttempts is the maximum number of addresses probed with ICMP
+	// while choosing an address to offer.
+	maxProbeAttempts = 5
+
+	// minPacketLenV4 is the minimum length of a BOOTP message, which some
+	// clients require.
+	//
+	// See https://datatracker.ietf.org/doc/html/rfc1542#section-2.1.
+	minPacketLenV4 = 300
+
+	// maxPacketLenV4 is the size of the buffer to read DHCPv4 messages into.
+	maxPacketLenV4 = 4096
+
+	// flagBroadcast is the BROADCAST flag of the BOOTP message.
+	//
+	// See https://datatracker.ietf.org/doc/html/rfc2131#section-2.
+	flagBroadcast uint16 = 1 << 15
+)
+
+// optionV4 returns the data of the first option with code in opts.
+func optionV4(opts layers.DHCPOptions, code layers.DHCPOpt) (data []byte, ok bool) {
+	i := slices.IndexFunc(opts, func(o layers.DHCPOption) (found bool) { return o.Type == code })
+	if i < 0 {
+		return nil, false
+	}
+
+	return opts[i].Data, true
+}
+
+// addrOptionV4 returns the IPv4 address from the option with code in opts.
+func addrOptionV4(opts layers.DHCPOptions, code layers.DHCPOpt) (ip netip.Addr) {
+	data, ok := optionV4(opts, code)
+	if !ok || len(data) != net.IPv4len {
+		return netip.Addr{}
+	}
+
+	return netip.AddrFrom4([4]byte(data))
+}
+
+// msgTypeV4 returns the DHCP message type of msg.
+func msgTypeV4(msg *layers.DHCPv4) (typ layers.DHCPMsgType) {
+	data, ok := optionV4(msg.Options, layers.DHCPOptMessageType)
+	if !ok || len(data) != 1 {
+		return layers.DHCPMsgTypeUnspecified
+	}
+
+	return layers.DHCPMsgType(data[0])
+}
+
+// ipFromField returns the IPv4 address from a BOOTP message field.  It returns
+// an invalid address if the field is unset.
+func ipFromField(ip net.IP) (addr netip.Addr) {
+	addr, ok := netip.AddrFromSlice(ip.To4())
+	if !ok || addr.IsUnspecified() {
+		return netip.Addr{}
+	}
+
+	return addr
+}
+
+// serveV4 reads and handles DHCPv4 messages received on conn of recv until the
+// connection is closed.  recv is nil for the connection receiving only the
+// relayed requests.
+func (srv *DHCPServer) serveV4(ctx context.Context, conn net.PacketConn, recv *dhcpInterfaceV4) {
+	defer srv.wg.Done()
+
+	l := srv.logger
+	if recv != nil {
+		l = recv.common.logger
+	}
+
+	defer slogutil.RecoverAndLog(ctx, l)
+
+	buf := make([]byte, maxPacketLenV4)
+	for {
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+
+			l.WarnContext(ctx, "reading packet", slogutil.KeyError, err)
+
+			continue
+		}
+
+		req := &layers.DHCPv4{}
+		err = req.DecodeFromBytes(buf[:n], gopacket.NilDecodeFeedback)
+		if err != nil {
+			l.DebugContext(ctx, "decoding packet", slogutil.KeyError, err)
+
+			continue
+		}
+
+		err = srv.respondV4(ctx, conn, recv, req)
+		if err != nil {
+			l.WarnContext(ctx, "responding", slogutil.KeyError, err)
+		}
+	}
+}
+
+// respondV4 handles req received on conn of recv and sends the response, if
+// any, using the same connection.
+func (srv *DHCPServer) respondV4(
+	ctx context.Context,
+	conn net.PacketConn,
+	recv *dhcpInterfaceV4,
+	req *layers.DHCPv4,
+) (err error) {
+	iface, ok := srv.scopeV4(recv, req)
+	if !ok {
+		srv.logger.DebugContext(ctx, "no subnet for request", "giaddr", req.RelayAgentIP)
+
+		return nil
+	}
+
+	resp := srv.handleV4(ctx, iface, req)
+	if resp == nil {
+		return nil
+	}
+
+	buf := gopacket.NewSerializeBuffer()
+	err = resp.SerializeTo(buf, gopacket.SerializeOptions{FixLengths: true})
+	if err != nil {
+		return fmt.Errorf("serializing response: %w", err)
+	}
+
+	hwWriter, canUnicast := conn.(HardwareAddrWriter)
+	dst, hwAddr := responseAddrV4(req, resp, canUnicast)
+	if hwAddr != nil {
+		_, err = hwWriter.WriteToHardwareAddr(buf.Bytes(), net.UDPAddrFromAddrPort(dst), hwAddr)
+	} else {
+		_, err = conn.WriteTo(buf.Bytes(), net.UDPAddrFromAddrPort(dst))
+	}
+
+	if err != nil {
+		return fmt.Errorf("writing response to %s: %w", dst, err)
+	}
+
+	return nil
+}
+
+// handleV4 returns the response to req received on iface.  resp is nil if req
+// should be left unanswered.
+func (srv *DHCPServer) handleV4(
+	ctx context.Context,
+	iface *dhcpInterfaceV4,
+	req *layers.DHCPv4,
+) (resp *layers.DHCPv4) {
+	l := iface.common.logger
+
+	mac := req.ClientHWAddr
+	if req.Operation != layers.DHCPOpRequest ||
+		req.HardwareType != layers.LinkTypeEthernet ||
+		netutil.ValidateMAC(mac) != nil ||
+		len(mac) > 16 {
+		l.DebugContext(ctx, "bad message", "op", req.Operation, "mac", mac)
+
+		return nil
+	}
+
+	// Detach the hardware address from the packet buffer.
+	mac = slices.Clone(mac)
+
+	typ := msgTypeV4(req)
+	l.DebugContext(ctx, "received", "type", typ, "mac", mac, "xid", req.Xid)
+
+	switch typ {
+	case layers.DHCPMsgTypeDiscover:
+		srv.bindRelayLease(ctx, iface, newRelayAgentInfo(req), mac)
+
+		return srv.handleDiscover(ctx, iface, req, mac)
+	case layers.DHCPMsgTypeRequest:
+		srv.bindRelayLease(ctx, iface, newRelayAgentInfo(req), mac)
+
+		return srv.handleRequest(ctx, iface, req, mac)
+	case layers.DHCPMsgTypeRelease:
+		srv.handleRelease(ctx, iface, req, mac)
+	case layers.DHCPMsgTypeDecline:
+		srv.handleDecline(ctx, iface, req, mac)
+	case layers.DHCPMsgTypeInform:
+		host := srv.clientOptions(iface, req, mac)
+
+		return iface.newResponse(req, layers.DHCPMsgTypeAck, netip.Addr{}, 0, host)
+	default:
+		l.DebugContext(ctx, "unsupported message type", "type", typ)
+	}
+
+	return nil
+}
+
+// handleDiscover returns the offer for the client with mac.  resp is nil if
+// there are no addresses to offer.
+func (srv *DHCPServer) handleDiscover(
+	ctx context.Context,
+	iface *dhcpInterfaceV4,
+	req *layers.DHCPv4,
+	mac net.HardwareAddr,
+) (resp *layers.DHCPv4) {
+	ip := srv.offerAddr(ctx, iface, req, mac)
+	if !ip.IsValid() {
+		iface.common.logger.WarnContext(ctx, "no free addresses", "mac", mac)
+
+		return nil
+	}
+
+	host := srv.clientOptions(iface, req, mac)
+
+	return iface.newResponse(req, layers.DHCPMsgTypeOffer, ip, iface.leaseDuration(host), host)
+}
+
+// clientOptions returns the host options for the client with mac sending req.
+func (srv *DHCPServer) clientOptions(
+	iface *dhcpInterfaceV4,
+	req *layers.DHCPv4,
+	mac net.HardwareAddr,
+) (host layers.DHCPOptions) {
+	srv.leasesMu.RLock()
+	defer srv.leasesMu.RUnlock()
+
+	l, _ := iface.common.leaseByMAC(mac)
+
+	return iface.hostOptions(req, l)
+}
+
+// offerAddr returns the address to offer to the client with mac, reserving it
+// for [offerDuration].  ip is invalid if there are no free addresses.
+func (srv *DHCPServer) offerAddr(
+	ctx context.Context,
+	iface *dhcpInterfaceV4,
+	req *layers.DHCPv4,
+	mac net.HardwareAddr,
+) (ip netip.Addr) {
+	now := time.Now()
+	tried := map[netip.Addr]struct{}{}
+	for range maxProbeAttempts {
+		var found bool
+		ip, found = srv.candidateAddr(iface, mac, addrOptionV4(req.Options, layers.DHCPOptRequestIP), tried, now)
+		if found || !ip.IsValid() {
+			return ip
+		}
+
+		if srv.addrAvailable(ctx, iface, ip, mac) {
+			break
+		}
+
+		tried[ip] = struct{}{}
+		ip = netip.Addr{}
+	}
+
+	if !ip.IsValid() {
+		return netip.Addr{}
+	}
+
+	srv.leasesMu.Lock()
+	defer srv.leasesMu.Unlock()
+
+	l := &Lease{
+		IP:       ip,
+		Expiry:   now.Add(offerDuration),
+		Hostname: srv.leaseHostname(req, ip),
+		HWAddr:   mac,
+	}
+
+	err := srv.reclaimAddr(ctx, iface, ip, now)
+	if err == nil {
+		err = srv.leases.add(l, iface.common)
+	}
+
+	if err != nil {
+		// The address has been taken while probing.
+		iface.common.logger.DebugContext(ctx, "reserving offer", slogutil.KeyError, err)
+
+		return netip.Addr{}
+	}
+
+	return ip
+}
+
+// candidateAddr returns the address to offer to the client with mac.  found is
+// true if the client already has a lease, so that ip needs no probing.  reqIP
+// is the address requested by the client, if any.  Addresses in tried are
+// skipped.  ip is invalid if there are no free addresses.
+func (srv *DHCPServer) candidateAddr(
+	iface *dhcpInterfaceV4,
+	mac net.HardwareAddr,
+	reqIP netip.Addr,
+	tried map[netip.Addr]struct{},
+	now time.Time,
+) (ip netip.Addr, found bool) {
+	srv.leasesMu.Lock()
+	defer srv.leasesMu.Unlock()
+
+	if l, ok := iface.common.leaseByMAC(mac); ok {
+		if !l.IsStatic && l.Expiry.Before(now) {
+			l.Expiry = now.Add(offerDuration)
+		}
+
+		return l.IP, true
+	}
+
+	isFree := func(addr netip.Addr) (ok bool) {
+		_, isTried := tried[addr]
+
+		return !isTried && srv.isFreeV4(iface, addr, now)
+	}
+
+	if reqIP.IsValid() && iface.addrSpace.contains(reqIP) && isFree(reqIP) {
+		return reqIP, false
+	}
+
+	return iface.addrSpace.find(isFree), false
+}
+
+// isFreeV4 returns true if ip can be leased on iface at now.  It expects
+// [DHCPServer.leasesMu] to be locked.
+func (srv *DHCPServer) isFreeV4(iface *dhcpInterfaceV4, ip netip.Addr, now time.Time) (ok bool) {
+	if ip == iface.serverID || ip == iface.gateway {
+		return false
+	}
+
+	if c, conflicts := iface.conflicts[ip]; conflicts && now.Before(c.Until) {
+		return false
+	}
+
+	l, leased := srv.leases.leaseByAddr(ip)
+
+	return !leased || (!l.IsStatic && l.Expiry.Before(now))
+}
+
+// reclaimAddr removes the expired dynamic lease for ip, if any, and reports it
+// as released.  It expects [DHCPServer.leasesMu] to be locked.
+func (srv *DHCPServer) reclaimAddr(
+	ctx context.Context,
+	iface *dhcpInterfaceV4,
+	ip netip.Addr,
+	now time.Time,
+) (err error) {
+	l, ok := srv.leases.leaseByAddr(ip)
+	if !ok {
+		return nil
+	} else if l.IsStatic || !l.Expiry.Before(now) {
+		return fmt.Errorf("address %s is leased", ip)
+	}
+
+	err = srv.leases.remove(l, iface.common)
+	if err != nil {
+		// Don't wrap the error, because it's informative enough as is.
+		return err
+	}
+
+	srv.leaseEvent(ctx, LeaseEventReleased, l)
+
+	return nil
+}
+
+// leaseHostname returns the hostname for the lease of ip requested with req.
+// It falls back to the hostname generated from ip if the client's one is
+// invalid or already taken.  It expects [DHCPServer.leasesMu] to be locked.
+func (srv *DHCPServer) leaseHostname(req *layers.DHCPv4, ip netip.Addr) (hostname string) {
+	data, _ := optionV4(req.Options, layers.DHCPOptHostname)
+	hostname = normalizeHostname(string(data))
+	if hostname != "" {
+		if _, taken := srv.leases.leaseByName(hostname); !taken {
+			return hostname
+		}
+	}
+
+	return aghnet.GenerateHostname(ip)
+}
+
+// normalizeHostname returns the valid hostname label made of name or an empty
+// string if that's not possible.
+func normalizeHostname(name string) (norm string) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	name = strings.Map(func(r rune) (res rune) {
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') || r == '-' {
+			return r
+		}
+
+		return '-'
+	}, name)
+	name = strings.Trim(name, "-")
+
+	if netutil.ValidateHostnameLabel(name) != nil {
+		return ""
+	}
+
+	return name
+}
+
+// addrAvailable returns false if ip responds to ICMP echo requests, which
+// means that it's used by another device.  In that case the conflict with the
+// client with mac is recorded.
+func (srv *DHCPServer) addrAvailable(
+	ctx context.Context,
+	iface *dhcpInterfaceV4,
+	ip netip.Addr,
+	mac net.HardwareAddr,
+) (ok bool) {
+	if srv.icmpTimeout == 0 {
+		return true
+	}
+
+	l := iface.common.logger
+
+	pinger, err := ping.NewPinger(ip.String())
+	if err != nil {
+		l.ErrorContext(ctx, "creating pinger", slogutil.KeyError, err)
+
+		return true
+	}
+
+	pinger.SetPrivileged(true)
+	pinger.Timeout = srv.icmpTimeout
+	pinger.Count = 1
+
+	replied := false
+	pinger.OnRecv = func(_ *ping.Packet) { replied = true }
+
+	err = pinger.Run()
+	if err != nil {
+		l.ErrorContext(ctx, "probing address", "ip", ip, slogutil.KeyError, err)
+
+		return true
+	}
+
+	if replied {
+		srv.leasesMu.Lock()
+		defer srv.leasesMu.Unlock()
+
+		srv.addConflict(ctx, iface, ip, mac, ConflictReasonICMP)
+	}
+
+	return !replied
+}
+
+// handleRequest returns the acknowledgement or the negative acknowledgement for
+// the REQUEST message from the client with mac in any of the states described
+// in RFC 2131, section 4.3.2.  resp is nil if req should be left unanswered.
+func (srv *DHCPServer) handleRequest(
+	ctx context.Context,
+	iface *dhcpInterfaceV4,
+	req *layers.DHCPv4,
+	mac net.HardwareAddr,
+) (resp *layers.DHCPv4) {
+	serverID := addrOptionV4(req.Options, layers.DHCPOptServerID)
+	reqIP := addrOptionV4(req.Options, layers.DHCPOptRequestIP)
+	ciaddr := ipFromField(req.ClientIP)
+
+	switch {
+	case serverID.IsValid():
+		// SELECTING state.
+		if serverID != iface.serverID {
+			srv.cancelOffer(ctx, iface, mac)
+
+			return nil
+		} else if !reqIP.IsValid() {
+			return nil
+		}
+
+		return srv.commitLease(ctx, iface, req, mac, reqIP, true)
+	case reqIP.IsValid():
+		// INIT-REBOOT state.
+		if !iface.subnet.Contains(reqIP) {
+			return iface.newResponse(req, layers.DHCPMsgTypeNak, netip.Addr{}, 0, nil)
+		}
+
+		return srv.commitLease(ctx, iface, req, mac, reqIP, false)
+	case ciaddr.IsValid():
+		// RENEWING or REBINDING state.
+		if !iface.subnet.Contains(ciaddr) {
+			return nil
+		}
+
+		return srv.commitLease(ctx, iface, req, mac, ciaddr, true)
+	default:
+		return nil
+	}
+}
+
+// commitLease extends the lease of ip for the client with mac and returns the
+// acknowledgement.  If the client has no lease for ip, it returns the negative
+// acknowledgement if nakUnknown is true and nil otherwise.  A client having a
+// lease for another address is always answered negatively.
+func (srv *DHCPServer) commitLease(
+	ctx context.Context,
+	iface *dhcpInterfaceV4,
+	req *layers.DHCPv4,
+	mac net.HardwareAddr,
+	ip netip.Addr,
+	nakUnknown bool,
+) (resp *layers.DHCPv4) {
+	l := iface.common.logger
+
+	srv.leasesMu.Lock()
+	defer srv.leasesMu.Unlock()
+
+	lease, ok := iface.common.leaseByMAC(mac)
+	switch {
+	case !ok && !nakUnknown:
+		return nil
+	case !ok, lease.IP != ip:
+		l.DebugContext(ctx, "rejecting request", "mac", mac, "ip", ip)
+
+		return iface.newResponse(req, layers.DHCPMsgTypeNak, netip.Addr{}, 0, nil)
+	}
+
+	host := iface.hostOptions(req, lease)
+	leaseTTL := iface.leaseDuration(host)
+	if !lease.IsStatic {
+		lease.Expiry = time.Now().Add(leaseTTL)
+
+		err := srv.dbStore(ctx)
+		if err != nil {
+			l.ErrorContext(ctx, "storing leases", slogutil.KeyError, err)
+		}
+	}
+
+	l.InfoContext(ctx, "leased", "ip", ip, "mac", mac, "hostname", lease.Hostname)
+	srv.leaseEvent(ctx, LeaseEventGranted, lease)
+
+	return iface.newResponse(req, layers.DHCPMsgTypeAck, ip, leaseTTL, host)
+}
+
+// cancelOffer removes the pending offer for the client with mac, which has
+// chosen another server.
+func (srv *DHCPServer) cancelOffer(ctx context.Context, iface *dhcpInterfaceV4, mac net.HardwareAddr) {
+	srv.leasesMu.Lock()
+	defer srv.leasesMu.Unlock()
+
+	l, ok := iface.common.leaseByMAC(mac)
+	if !ok || l.IsStatic || time.Until(l.Expiry) > offerDuration {
+		return
+	}
+
+	err := srv.leases.remove(l, iface.common)
+	if err != nil {
+		iface.common.logger.DebugContext(ctx, "canceling offer", slogutil.KeyError, err)
+	}
+}
+
+// handleRelease removes the dynamic lease released by the client with mac.
+func (srv *DHCPServer) handleRelease(
+	ctx context.Context,
+	iface *dhcpInterfaceV4,
+	req *layers.DHCPv4,
+	mac net.HardwareAddr,
+) {
+	ip := ipFromField(req.ClientIP)
+	if srv.removeClientLease(ctx, iface, mac, ip) {
+		iface.common.logger.InfoContext(ctx, "released", "ip", ip, "mac", mac)
+	}
+}
+
+// handleDecline removes the dynamic lease declined by the client with mac and
+// quarantines its address, since it's used by another device.
+func (srv *DHCPServer) handleDecline(
+	ctx context.Context,
+	iface *dhcpInterfaceV4,
+	req *layers.DHCPv4,
+	mac net.HardwareAddr,
+) {
+	ip := addrOptionV4(req.Options, layers.DHCPOptRequestIP)
+	if !srv.removeClientLease(ctx, iface, mac, ip) {
+		return
+	}
+
+	srv.leasesMu.Lock()
+	defer srv.leasesMu.Unlock()
+
+	srv.addConflict(ctx, iface, ip, mac, ConflictReasonDeclined)
+}
+
+// removeClientLease removes the dynamic lease of ip held by the client with
+// mac and stores the leases.  It returns false if there is no such lease.
+func (srv *DHCPServer) removeClientLease(
+	ctx context.Context,
+	iface *dhcpInterfaceV4,
+	mac net.HardwareAddr,
+	ip netip.Addr,
+) (ok bool) {
+	srv.leasesMu.Lock()
+	defer srv.leasesMu.Unlock()
+
+	l, found := iface.common.leaseByMAC(mac)
+	if !found || l.IsStatic || l.IP != ip {
+		return false
+	}
+
+	err := srv.leases.remove(l, iface.common)
+	if err != nil {
+		iface.common.logger.ErrorContext(ctx, "removing lease", slogutil.KeyError, err)
+
+		return false
+	}
+
+	srv.leaseEvent(ctx, LeaseEventReleased, l)
+
+	err = srv.dbStore(ctx)
+	if err != nil {
+		iface.common.logger.ErrorContext(ctx, "storing leases", slogutil.KeyError, err)
+	}
+
+	return true
+}
+
+// newResponse returns the response of type typ to req.  yiaddr is the address
+// leased to the client, if any.  leaseTTL is the lease duration to report, if
+// positive.  host are the options specific to the client, see
+// [dhcpInterfaceV4.hostOptions].
+func (iface *dhcpInterfaceV4) newResponse(
+	req *layers.DHCPv4,
+	typ layers.DHCPMsgType,
+	yiaddr netip.Addr,
+	leaseTTL time.Duration,
+	host layers.DHCPOptions,
+) (resp *layers.DHCPv4) {
+	resp = &layers.DHCPv4{
+		Operation:    layers.DHCPOpReply,
+		HardwareType: req.HardwareType,
+		Xid:          req.Xid,
+		Flags:        req.Flags,
+		ClientIP:     net.IPv4zero,
+		YourClientIP: net.IPv4zero,
+		NextServerIP: net.IPv4zero,
+		RelayAgentIP: req.RelayAgentIP,
+		ClientHWAddr: req.ClientHWAddr,
+	}
+
+	if yiaddr.IsValid() {
+		resp.YourClientIP = yiaddr.AsSlice()
+	}
+
+	if typ == layers.DHCPMsgTypeAck && !yiaddr.IsValid() {
+		// Response to INFORM.
+		resp.ClientIP = req.ClientIP
+	}
+
+	resp.Options = layers.DHCPOptions{
+		layers.NewDHCPOption(layers.DHCPOptMessageType, []byte{byte(typ)}),
+		layers.NewDHCPOption(layers.DHCPOptServerID, iface.serverID.AsSlice()),
+	}
+
+	if typ != layers.DHCPMsgTypeNak {
+		resp.Options = append(resp.Options, iface.responseOptions(req, leaseTTL, host)...)
+		iface.setBoot(req, resp)
+	}
+
+	// The relay agent information must be echoed in all the responses.
+	//
+	// See https://datatracker.ietf.org/doc/html/rfc3046#section-2.2.
+	if info := newRelayAgentInfo(req); info.raw != nil {
+		resp.Options = append(resp.Options, layers.NewDHCPOption(optRelayAgentInfo, info.raw))
+	}
+
+	padV4(resp)
+
+	return resp
+}
+
+// responseOptions returns the configuration options for the response to req.
+// The implicit options are only included if the client requested them.  The
+// host options replace the ones with the same code.
+func (iface *dhcpInterfaceV4) responseOptions(
+	req *layers.DHCPv4,
+	leaseTTL time.Duration,
+	host layers.DHCPOptions,
+) (opts layers.DHCPOptions) {
+	if leaseTTL > 0 {
+		secs := uint32(leaseTTL.Seconds())
+		opts = append(
+			opts,
+			layers.NewDHCPOption(layers.DHCPOptLeaseTime, binary.BigEndian.AppendUint32(nil, secs)),
+			layers.NewDHCPOption(layers.DHCPOptT1, binary.BigEndian.AppendUint32(nil, secs/2)),
+			layers.NewDHCPOption(layers.DHCPOptT2, binary.BigEndian.AppendUint32(nil, secs/8*7)),
+		)
+	}
+
+	requested, _ := optionV4(req.Options, layers.DHCPOptParamsRequest)
+	for _, o := range iface.implicitOpts {
+		if slices.Contains(requested, byte(o.Type)) {
+			opts = append(opts, o)
+		}
+	}
+
+	opts = append(opts, iface.explicitOpts...)
+
+	return mergeOptions(opts, host)
+}
+
+// padV4 appends the padding options to msg to make it at least
+// [minPacketLenV4] bytes long.
+func padV4(msg *layers.DHCPv4) {
+	for n := int(msg.Len()); n < minPacketLenV4; n++ {
+		msg.Options = append(msg.Options, layers.NewDHCPOption(layers.DHCPOptPad, nil))
+	}
+}
+
+// responseAddrV4 returns the address to send resp to as described in RFC 2131,
+// section 4.1.  hwAddr is not nil if the response should be unicast to the
+// client that has no address yet, which is only possible if canUnicast is true.
+// Otherwise such responses are broadcast.
+func responseAddrV4(
+	req *layers.DHCPv4,
+	resp *layers.DHCPv4,
+	canUnicast bool,
+) (dst netip.AddrPort, hwAddr net.HardwareAddr) {
+	if giaddr := ipFromField(req.RelayAgentIP); giaddr.IsValid() {
+		return netip.AddrPortFrom(giaddr, ServerPortV4), nil
+	}
+
+	bcast := netip.AddrPortFrom(netip.AddrFrom4([4]byte(netutil.IPv4bcast())), ClientPortV4)
+	if msgTypeV4(resp) == layers.DHCPMsgTypeNak || req.Flags&flagBroadcast != 0 {
+		return bcast, nil
+	}
+
+	if ciaddr := ipFromField(req.ClientIP); ciaddr.IsValid() {
+		return netip.AddrPortFrom(ciaddr, ClientPortV4), nil
+	}
+
+	yiaddr := ipFromField(resp.YourClientIP)
+	if !canUnicast || !yiaddr.IsValid() || req.HardwareType != layers.LinkTypeEthernet {
+		return bcast, nil
+	}
+
+	return netip.AddrPortFrom(yiaddr, ClientPortV4), req.ClientHWAddr
+}
@@ -0,0 +1,65 @@
+package dhcpsvc
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/AdguardTeam/golibs/logutil/slogutil"
+	"github.com/AdguardTeam/golibs/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDHCPServer_reclaimAddr(t *testing.T) {
+	common := newNetInterface("eth0", slogutil.NewDiscardLogger(), time.Hour)
+	iface := &dhcpInterfaceV4{common: common}
+
+	var released []*Lease
+	srv := &DHCPServer{
+		leases: newLeaseIndex(),
+		onLeaseEvent: func(_ context.Context, ev LeaseEvent, l *Lease) {
+			if ev == LeaseEventReleased {
+				released = append(released, l)
+			}
+		},
+	}
+
+	now := time.Now()
+	expired := &Lease{
+		Expiry:   now.Add(-time.Minute),
+		IP:       netip.MustParseAddr("10.0.0.100"),
+		Hostname: "expired",
+		HWAddr:   net.HardwareAddr{0xAA, 0xAA, 0xAA, 0xAA, 0xAA, 0xAA},
+	}
+	active := &Lease{
+		Expiry:   now.Add(time.Minute),
+		IP:       netip.MustParseAddr("10.0.0.101"),
+		Hostname: "active",
+		HWAddr:   net.HardwareAddr{0xBB, 0xBB, 0xBB, 0xBB, 0xBB, 0xBB},
+	}
+
+	require.NoError(t, srv.leases.add(expired, common))
+	require.NoError(t, srv.leases.add(active, common))
+
+	ctx := testutil.ContextWithTimeout(t, time.Second)
+
+	err := srv.reclaimAddr(ctx, iface, active.IP, now)
+	require.Error(t, err)
+
+	assert.Empty(t, released)
+
+	err = srv.reclaimAddr(ctx, iface, expired.IP, now)
+	require.NoError(t, err)
+
+	// The previous holder is reported, so that its hostname is withdrawn.
+	require.Len(t, released, 1)
+
+	assert.Equal(t, expired.Hostname, released[0].Hostname)
+	assert.Equal(t, expired.HWAddr, released[0].HWAddr)
+
+	_, ok := srv.leases.leaseByAddr(expired.IP)
+	assert.False(t, ok)
+}
@@ -0,0 +1,711 @@
+package dhcpsvc_test
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"net/netip"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/dhcpsvc"
+	"github.com/AdguardTeam/golibs/testutil"
+	"github.com/AdguardTeam/golibs/testutil/fakenet"
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testPacketListener is a [dhcpsvc.PacketListener] for tests.
+type testPacketListener struct {
+	onListenPacket func(
+		ctx context.Context,
+		ifaceName string,
+		port uint16,
+	) (conn net.PacketConn, err error)
+}
+
+// type check
+var _ dhcpsvc.PacketListener = (*testPacketListener)(nil)
+
+// ListenPacket implements the [dhcpsvc.PacketListener] interface for
+// *testPacketListener.
+func (l *testPacketListener) ListenPacket(
+	ctx context.Context,
+	ifaceName string,
+	port uint16,
+) (conn net.PacketConn, err error) {
+	return l.onListenPacket(ctx, ifaceName, port)
+}
+
+// testPacket is a packet sent or received over the test connection.  hwAddr is
+// only set for the packets unicast on the link layer.
+type testPacket struct {
+	addr   net.Addr
+	hwAddr net.HardwareAddr
+	data   []byte
+}
+
+// testHardwareConn is a [dhcpsvc.HardwareAddrWriter] packet connection for
+// tests.
+type testHardwareConn struct {
+	*fakenet.PacketConn
+
+	onWriteToHardwareAddr func(
+		b []byte,
+		addr *net.UDPAddr,
+		hwAddr net.HardwareAddr,
+	) (n int, err error)
+}
+
+// type check
+var _ dhcpsvc.HardwareAddrWriter = (*testHardwareConn)(nil)
+
+// WriteToHardwareAddr implements the [dhcpsvc.HardwareAddrWriter] interface for
+// *testHardwareConn.
+func (c *testHardwareConn) WriteToHardwareAddr(
+	b []byte,
+	addr *net.UDPAddr,
+	hwAddr net.HardwareAddr,
+) (n int, err error) {
+	return c.onWriteToHardwareAddr(b, addr, hwAddr)
+}
+
+// newTestConn returns the connection reading the packets from reqs and writing
+// the packets to resps.
+func newTestConn(reqs <-chan []byte, resps chan<- testPacket) (conn *fakenet.PacketConn) {
+	closed := make(chan struct{})
+	clientAddr := &net.UDPAddr{IP: net.IPv4zero, Port: int(dhcpsvc.ClientPortV4)}
+
+	return &fakenet.PacketConn{
+		OnClose: func() (err error) {
+			close(closed)
+
+			return nil
+		},
+		OnReadFrom: func(b []byte) (n int, addr net.Addr, err error) {
+			select {
+			case data := <-reqs:
+				return copy(b, data), clientAddr, nil
+			case <-closed:
+				return 0, nil, net.ErrClosed
+			}
+		},
+		OnWriteTo: func(b []byte, addr net.Addr) (n int, err error) {
+			resps <- testPacket{addr: addr, data: append([]byte{}, b...)}
+
+			return len(b), nil
+		},
+	}
+}
+
+// newTestMessage returns the serialized DHCPv4 message of type typ from the
+// client with mac.  opts are appended to the message type option.
+func newTestMessage(
+	t *testing.T,
+	typ layers.DHCPMsgType,
+	mac net.HardwareAddr,
+	ciaddr netip.Addr,
+	opts ...layers.DHCPOption,
+) (data []byte) {
+	t.Helper()
+
+	msg := newTestDHCPv4(typ, mac, opts...)
+	if ciaddr.IsValid() {
+		msg.ClientIP = ciaddr.AsSlice()
+	}
+
+	return serializeMessage(t, msg)
+}
+
+// newTestDHCPv4 returns the DHCPv4 message of type typ from the client with
+// mac.  opts are appended to the message type option.
+func newTestDHCPv4(
+	typ layers.DHCPMsgType,
+	mac net.HardwareAddr,
+	opts ...layers.DHCPOption,
+) (msg *layers.DHCPv4) {
+	return &layers.DHCPv4{
+		Operation:    layers.DHCPOpRequest,
+		HardwareType: layers.LinkTypeEthernet,
+		HardwareLen:  uint8(len(mac)),
+		Xid:          42,
+		ClientIP:     net.IPv4zero,
+		YourClientIP: net.IPv4zero,
+		NextServerIP: net.IPv4zero,
+		RelayAgentIP: net.IPv4zero,
+		ClientHWAddr: mac,
+		Options: append(layers.DHCPOptions{
+			layers.NewDHCPOption(layers.DHCPOptMessageType, []byte{byte(typ)}),
+		}, opts...),
+	}
+}
+
+// serializeMessage returns the serialized msg.
+func serializeMessage(t *testing.T, msg *layers.DHCPv4) (data []byte) {
+	t.Helper()
+
+	buf := gopacket.NewSerializeBuffer()
+	err := msg.SerializeTo(buf, gopacket.SerializeOptions{FixLengths: true})
+	require.NoError(t, err)
+
+	return buf.Bytes()
+}
+
+// decodeResponse decodes the DHCPv4 message from pkt and returns it with its
+// message type.
+func decodeResponse(t *testing.T, pkt testPacket) (msg *layers.DHCPv4, typ layers.DHCPMsgType) {
+	t.Helper()
+
+	msg = &layers.DHCPv4{}
+	err := msg.DecodeFromBytes(pkt.data, gopacket.NilDecodeFeedback)
+	require.NoError(t, err)
+
+	require.Equal(t, layers.DHCPOpReply, msg.Operation)
+	require.NotEmpty(t, msg.Options)
+	require.Equal(t, layers.DHCPOptMessageType, msg.Options[0].Type)
+
+	return msg, layers.DHCPMsgType(msg.Options[0].Data[0])
+}
+
+func TestDHCPServer_serveV4(t *testing.T) {
+	reqs := make(chan []byte)
+	resps := make(chan testPacket, 1)
+
+	listener := &testPacketListener{
+		onListenPacket: func(
+			_ context.Context,
+			ifaceName string,
+			port uint16,
+		) (conn net.PacketConn, err error) {
+			require.Equal(t, "eth0", ifaceName)
+			require.Equal(t, dhcpsvc.ServerPortV4, port)
+
+			return newTestConn(reqs, resps), nil
+		},
+	}
+
+	conf := &dhcpsvc.Config{
+		Enabled:         true,
+		Logger:          discardLog,
+		LocalDomainName: testLocalTLD,
+		Interfaces: map[string]*dhcpsvc.InterfaceConfig{
+			"eth0": {
+				IPv4: testInterfaceConf["eth0"].IPv4,
+				IPv6: &dhcpsvc.IPv6Config{Enabled: false},
+			},
+		},
+		DBFilePath:     filepath.Join(t.TempDir(), "leases.json"),
+		PacketListener: listener,
+	}
+
+	ctx := testutil.ContextWithTimeout(t, testTimeout)
+
+	srv, err := dhcpsvc.New(ctx, conf)
+	require.NoError(t, err)
+
+	err = srv.Start(ctx)
+	require.NoError(t, err)
+	testutil.CleanupAndRequireSuccess(t, func() (err error) { return srv.Shutdown(ctx) })
+
+	exchange := func(t *testing.T, req []byte) (msg *layers.DHCPv4, typ layers.DHCPMsgType) {
+		t.Helper()
+
+		testutil.RequireSend(t, reqs, req, testTimeout)
+		pkt, ok := testutil.RequireReceive(t, resps, testTimeout)
+		require.True(t, ok)
+
+		return decodeResponse(t, pkt)
+	}
+
+	mac := mustParseMAC(t, "AA:AA:AA:AA:AA:AA")
+	serverID := layers.NewDHCPOption(layers.DHCPOptServerID, []byte{192, 168, 0, 1})
+	hostname := layers.NewDHCPOption(layers.DHCPOptHostname, []byte("Test Host"))
+
+	var leased netip.Addr
+	t.Run("discover", func(t *testing.T) {
+		msg, typ := exchange(t, newTestMessage(t, layers.DHCPMsgTypeDiscover, mac, netip.Addr{}, hostname))
+		require.Equal(t, layers.DHCPMsgTypeOffer, typ)
+
+		var ok bool
+		leased, ok = netip.AddrFromSlice(msg.YourClientIP.To4())
+		require.True(t, ok)
+
+		assert.Equal(t, netip.MustParseAddr("192.168.0.2"), leased)
+		assert.Equal(t, mac, msg.ClientHWAddr)
+	})
+
+	t.Run("request", func(t *testing.T) {
+		reqIP := layers.NewDHCPOption(layers.DHCPOptRequestIP, leased.AsSlice())
+		msg, typ := exchange(t, newTestMessage(
+			t,
+			layers.DHCPMsgTypeRequest,
+			mac,
+			netip.Addr{},
+			serverID,
+			reqIP,
+		))
+		require.Equal(t, layers.DHCPMsgTypeAck, typ)
+		assert.Equal(t, net.IP(leased.AsSlice()), msg.YourClientIP.To4())
+
+		assert.Equal(t, leased, srv.IPByHost("test-host"))
+		assert.Equal(t, mac, srv.MACByIP(leased))
+	})
+
+	t.Run("renew", func(t *testing.T) {
+		testutil.RequireSend(t, reqs, newTestMessage(t, layers.DHCPMsgTypeRequest, mac, leased), testTimeout)
+		pkt, ok := testutil.RequireReceive(t, resps, testTimeout)
+		require.True(t, ok)
+
+		_, typ := decodeResponse(t, pkt)
+		assert.Equal(t, layers.DHCPMsgTypeAck, typ)
+
+		wantAddr := net.UDPAddrFromAddrPort(netip.AddrPortFrom(leased, dhcpsvc.ClientPortV4))
+		assert.Equal(t, wantAddr, pkt.addr)
+	})
+
+	t.Run("wrong_address", func(t *testing.T) {
+		reqIP := layers.NewDHCPOption(layers.DHCPOptRequestIP, []byte{192, 168, 0, 100})
+		_, typ := exchange(t, newTestMessage(t, layers.DHCPMsgTypeRequest, mac, netip.Addr{}, reqIP))
+		assert.Equal(t, layers.DHCPMsgTypeNak, typ)
+	})
+
+	t.Run("inform", func(t *testing.T) {
+		msg, typ := exchange(t, newTestMessage(t, layers.DHCPMsgTypeInform, mac, leased))
+		require.Equal(t, layers.DHCPMsgTypeAck, typ)
+		assert.True(t, msg.YourClientIP.Equal(net.IPv4zero))
+	})
+
+	t.Run("release", func(t *testing.T) {
+		testutil.RequireSend(t, reqs, newTestMessage(t, layers.DHCPMsgTypeRelease, mac, leased), testTimeout)
+
+		require.Eventually(t, func() (ok bool) {
+			return len(srv.Leases()) == 0
+		}, testTimeout, testTimeout/100)
+	})
+
+	t.Run("decline", func(t *testing.T) {
+		_, typ := exchange(t, newTestMessage(t, layers.DHCPMsgTypeDiscover, mac, netip.Addr{}))
+		require.Equal(t, layers.DHCPMsgTypeOffer, typ)
+
+		reqIP := layers.NewDHCPOption(layers.DHCPOptRequestIP, leased.AsSlice())
+		testutil.RequireSend(
+			t,
+			reqs,
+			newTestMessage(t, layers.DHCPMsgTypeDecline, mac, netip.Addr{}, serverID, reqIP),
+			testTimeout,
+		)
+
+		msg, typ := exchange(t, newTestMessage(t, layers.DHCPMsgTypeDiscover, mac, netip.Addr{}))
+		require.Equal(t, layers.DHCPMsgTypeOffer, typ)
+		assert.NotEqual(t, net.IP(leased.AsSlice()), msg.YourClientIP.To4())
+
+		conflicts := srv.Conflicts()
+		require.Len(t, conflicts, 1)
+
+		c := conflicts[0]
+		assert.Equal(t, leased, c.IP)
+		assert.Equal(t, mac, c.ClientHWAddr)
+		assert.Equal(t, dhcpsvc.ConflictReasonDeclined, c.Reason)
+		assert.True(t, c.Until.After(c.Detected))
+	})
+
+	t.Run("other_server", func(t *testing.T) {
+		otherMAC := mustParseMAC(t, "BB:BB:BB:BB:BB:BB")
+		_, typ := exchange(t, newTestMessage(t, layers.DHCPMsgTypeDiscover, otherMAC, netip.Addr{}))
+		require.Equal(t, layers.DHCPMsgTypeOffer, typ)
+
+		otherID := layers.NewDHCPOption(layers.DHCPOptServerID, []byte{192, 168, 0, 254})
+		testutil.RequireSend(
+			t,
+			reqs,
+			newTestMessage(t, layers.DHCPMsgTypeRequest, otherMAC, netip.Addr{}, otherID),
+			testTimeout,
+		)
+
+		require.Eventually(t, func() (ok bool) {
+			return len(srv.Leases()) == 1
+		}, testTimeout, testTimeout/100)
+	})
+}
+
+func TestDHCPServer_serveV4_broadcastFlag(t *testing.T) {
+	// flagBroadcast is the BROADCAST flag of the BOOTP message.
+	const flagBroadcast uint16 = 1 << 15
+
+	mac := mustParseMAC(t, "AA:AA:AA:AA:AA:AA")
+	bcastAddr := &net.UDPAddr{IP: net.IPv4bcast.To4(), Port: int(dhcpsvc.ClientPortV4)}
+	leasedAddr := &net.UDPAddr{IP: net.IP{192, 168, 0, 2}, Port: int(dhcpsvc.ClientPortV4)}
+
+	testCases := []struct {
+		wantAddr   net.Addr
+		wantHWAddr net.HardwareAddr
+		name       string
+		flags      uint16
+		canUnicast bool
+	}{{
+		wantAddr:   bcastAddr,
+		wantHWAddr: nil,
+		name:       "broadcast",
+		flags:      flagBroadcast,
+		canUnicast: true,
+	}, {
+		wantAddr:   leasedAddr,
+		wantHWAddr: mac,
+		name:       "unicast",
+		flags:      0,
+		canUnicast: true,
+	}, {
+		wantAddr:   bcastAddr,
+		wantHWAddr: nil,
+		name:       "unicast_unsupported",
+		flags:      0,
+		canUnicast: false,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			reqs := make(chan []byte)
+			resps := make(chan testPacket, 1)
+
+			listener := &testPacketListener{
+				onListenPacket: func(
+					_ context.Context,
+					_ string,
+					_ uint16,
+				) (conn net.PacketConn, err error) {
+					pc := newTestConn(reqs, resps)
+					if !tc.canUnicast {
+						return pc, nil
+					}
+
+					return &testHardwareConn{
+						PacketConn: pc,
+						onWriteToHardwareAddr: func(
+							b []byte,
+							addr *net.UDPAddr,
+							hwAddr net.HardwareAddr,
+						) (n int, err error) {
+							resps <- testPacket{
+								addr:   addr,
+								hwAddr: hwAddr,
+								data:   append([]byte{}, b...),
+							}
+
+							return len(b), nil
+						},
+					}, nil
+				},
+			}
+
+			conf := &dhcpsvc.Config{
+				Enabled:         true,
+				Logger:          discardLog,
+				LocalDomainName: testLocalTLD,
+				Interfaces: map[string]*dhcpsvc.InterfaceConfig{
+					"eth0": {
+						IPv4: testInterfaceConf["eth0"].IPv4,
+						IPv6: &dhcpsvc.IPv6Config{Enabled: false},
+					},
+				},
+				DBFilePath:     filepath.Join(t.TempDir(), "leases.json"),
+				PacketListener: listener,
+			}
+
+			ctx := testutil.ContextWithTimeout(t, testTimeout)
+
+			srv, err := dhcpsvc.New(ctx, conf)
+			require.NoError(t, err)
+
+			err = srv.Start(ctx)
+			require.NoError(t, err)
+			testutil.CleanupAndRequireSuccess(t, func() (err error) { return srv.Shutdown(ctx) })
+
+			msg := newTestDHCPv4(layers.DHCPMsgTypeDiscover, mac)
+			msg.Flags = tc.flags
+
+			testutil.RequireSend(t, reqs, serializeMessage(t, msg), testTimeout)
+			pkt, ok := testutil.RequireReceive(t, resps, testTimeout)
+			require.True(t, ok)
+
+			_, typ := decodeResponse(t, pkt)
+			require.Equal(t, layers.DHCPMsgTypeOffer, typ)
+
+			assert.Equal(t, tc.wantAddr, pkt.addr)
+			assert.Equal(t, tc.wantHWAddr, pkt.hwAddr)
+		})
+	}
+}
+
+func TestDHCPServer_serveV4_relay(t *testing.T) {
+	localReqs, relayReqs := make(chan []byte), make(chan []byte)
+	resps := make(chan testPacket, 1)
+
+	listener := &testPacketListener{
+		onListenPacket: func(
+			_ context.Context,
+			ifaceName string,
+			_ uint16,
+		) (conn net.PacketConn, err error) {
+			switch ifaceName {
+			case "eth0":
+				return newTestConn(localReqs, resps), nil
+			case "":
+				return newTestConn(relayReqs, resps), nil
+			default:
+				panic(fmt.Errorf("unexpected interface %q", ifaceName))
+			}
+		},
+	}
+
+	serverIP := netip.MustParseAddr("192.168.0.1")
+	conf := &dhcpsvc.Config{
+		Enabled:         true,
+		Logger:          discardLog,
+		LocalDomainName: testLocalTLD,
+		Interfaces: map[string]*dhcpsvc.InterfaceConfig{
+			"eth0": {
+				IPv4: testInterfaceConf["eth0"].IPv4,
+				IPv6: &dhcpsvc.IPv6Config{Enabled: false},
+			},
+			"remote": {
+				IPv4: &dhcpsvc.IPv4Config{
+					Enabled:       true,
+					GatewayIP:     netip.MustParseAddr("10.0.0.1"),
+					SubnetMask:    netip.MustParseAddr("255.255.255.0"),
+					RangeStart:    netip.MustParseAddr("10.0.0.100"),
+					RangeEnd:      netip.MustParseAddr("10.0.0.200"),
+					ServerIP:      serverIP,
+					LeaseDuration: 1 * time.Hour,
+					Relayed:       true,
+				},
+				IPv6: &dhcpsvc.IPv6Config{Enabled: false},
+			},
+		},
+		DBFilePath:     filepath.Join(t.TempDir(), "leases.json"),
+		PacketListener: listener,
+	}
+
+	ctx := testutil.ContextWithTimeout(t, testTimeout)
+
+	srv, err := dhcpsvc.New(ctx, conf)
+	require.NoError(t, err)
+
+	staticIP := netip.MustParseAddr("10.0.0.50")
+	err = srv.AddLease(ctx, &dhcpsvc.Lease{
+		IP:        staticIP,
+		Hostname:  "port-1",
+		HWAddr:    mustParseMAC(t, "CC:CC:CC:CC:CC:CC"),
+		CircuitID: "port-1",
+		IsStatic:  true,
+	})
+	require.NoError(t, err)
+
+	err = srv.Start(ctx)
+	require.NoError(t, err)
+	testutil.CleanupAndRequireSuccess(t, func() (err error) { return srv.Shutdown(ctx) })
+
+	giaddr := netip.MustParseAddr("10.0.0.1")
+	wantDst := net.UDPAddrFromAddrPort(netip.AddrPortFrom(giaddr, dhcpsvc.ServerPortV4))
+
+	relayed := func(
+		t *testing.T,
+		reqs chan<- []byte,
+		mac net.HardwareAddr,
+		circuitID string,
+	) (msg *layers.DHCPv4) {
+		t.Helper()
+
+		info := append([]byte{1, byte(len(circuitID))}, circuitID...)
+		req := newTestDHCPv4(
+			layers.DHCPMsgTypeDiscover,
+			mac,
+			layers.NewDHCPOption(82, info),
+		)
+		req.RelayAgentIP = giaddr.AsSlice()
+
+		testutil.RequireSend(t, reqs, serializeMessage(t, req), testTimeout)
+		pkt, ok := testutil.RequireReceive(t, resps, testTimeout)
+		require.True(t, ok)
+
+		assert.Equal(t, wantDst, pkt.addr)
+
+		msg, typ := decodeResponse(t, pkt)
+		require.Equal(t, layers.DHCPMsgTypeOffer, typ)
+
+		assert.Equal(t, net.IP(giaddr.AsSlice()), msg.RelayAgentIP.To4())
+		assert.Contains(t, msg.Options, layers.NewDHCPOption(82, info))
+		assert.Contains(t, msg.Options, layers.NewDHCPOption(layers.DHCPOptServerID, serverIP.AsSlice()))
+
+		return msg
+	}
+
+	t.Run("dynamic", func(t *testing.T) {
+		msg := relayed(t, relayReqs, mustParseMAC(t, "AA:AA:AA:AA:AA:AA"), "port-2")
+		assert.Equal(t, net.IP{10, 0, 0, 100}, msg.YourClientIP.To4())
+	})
+
+	t.Run("circuit_id", func(t *testing.T) {
+		mac := mustParseMAC(t, "BB:BB:BB:BB:BB:BB")
+		msg := relayed(t, localReqs, mac, "port-1")
+		assert.Equal(t, net.IP(staticIP.AsSlice()), msg.YourClientIP.To4())
+
+		assert.Equal(t, mac, srv.MACByIP(staticIP))
+	})
+
+	t.Run("other_server", func(t *testing.T) {
+		mac := mustParseMAC(t, "DD:DD:DD:DD:DD:DD")
+		req := newTestDHCPv4(layers.DHCPMsgTypeDiscover, mac)
+		req.RelayAgentIP = giaddr.AsSlice()
+
+		conn := newTestConn(nil, resps)
+		err = srv.HandleRelayedV4(ctx, conn, serializeMessage(t, req))
+		require.NoError(t, err)
+
+		pkt, ok := testutil.RequireReceive(t, resps, testTimeout)
+		require.True(t, ok)
+
+		assert.Equal(t, wantDst, pkt.addr)
+
+		msg, typ := decodeResponse(t, pkt)
+		require.Equal(t, layers.DHCPMsgTypeOffer, typ)
+
+		assert.Equal(t, net.IP{10, 0, 0, 101}, msg.YourClientIP.To4())
+	})
+
+	t.Run("other_server_not_relayed", func(t *testing.T) {
+		data := newTestMessage(t, layers.DHCPMsgTypeDiscover, mustParseMAC(t, "DD:DD:DD:DD:DD:DD"), netip.Addr{})
+
+		err = srv.HandleRelayedV4(ctx, newTestConn(nil, resps), data)
+		testutil.AssertErrorMsg(t, "not a relayed request", err)
+	})
+}
+
+func TestDHCPServer_serveV4_hostOptions(t *testing.T) {
+	reqs := make(chan []byte)
+	resps := make(chan testPacket, 1)
+
+	listener := &testPacketListener{
+		onListenPacket: func(
+			_ context.Context,
+			_ string,
+			_ uint16,
+		) (conn net.PacketConn, err error) {
+			return newTestConn(reqs, resps), nil
+		},
+	}
+
+	bootFile := layers.NewDHCPOption(67, []byte("pxelinux.0"))
+	classDNS := layers.NewDHCPOption(layers.DHCPOptDNS, []byte{192, 168, 0, 53})
+	hostDNS := layers.NewDHCPOption(layers.DHCPOptDNS, []byte{1, 1, 1, 1})
+	shortLease := layers.NewDHCPOption(layers.DHCPOptLeaseTime, []byte{0, 0, 0x02, 0x58})
+
+	bootServer := netip.MustParseAddr("192.168.0.10")
+
+	v4Conf := *testInterfaceConf["eth0"].IPv4
+	v4Conf.Boot = &dhcpsvc.BootConfig{
+		ArchFilenames: map[dhcpsvc.ClientArch]string{
+			dhcpsvc.ArchUEFIx64: "ipxe.efi",
+		},
+		Filename: "undionly.kpxe",
+	}
+	v4Conf.Classes = []*dhcpsvc.ClientClass{{
+		Name:        "pxe",
+		VendorClass: "PXEClient",
+		Options:     layers.DHCPOptions{bootFile, classDNS},
+		Boot:        &dhcpsvc.BootConfig{NextServer: bootServer},
+	}, {
+		Name:            "printers",
+		HostnamePattern: "printer-*",
+		Options:         layers.DHCPOptions{shortLease},
+	}}
+
+	conf := &dhcpsvc.Config{
+		Enabled:         true,
+		Logger:          discardLog,
+		LocalDomainName: testLocalTLD,
+		Interfaces: map[string]*dhcpsvc.InterfaceConfig{
+			"eth0": {
+				IPv4: &v4Conf,
+				IPv6: &dhcpsvc.IPv6Config{Enabled: false},
+			},
+		},
+		DBFilePath:     filepath.Join(t.TempDir(), "leases.json"),
+		PacketListener: listener,
+	}
+
+	ctx := testutil.ContextWithTimeout(t, testTimeout)
+
+	srv, err := dhcpsvc.New(ctx, conf)
+	require.NoError(t, err)
+
+	staticMAC := mustParseMAC(t, "CC:CC:CC:CC:CC:CC")
+	err = srv.AddLease(ctx, &dhcpsvc.Lease{
+		IP:       netip.MustParseAddr("192.168.0.50"),
+		Hostname: "static",
+		HWAddr:   staticMAC,
+		Options:  layers.DHCPOptions{hostDNS},
+		IsStatic: true,
+	})
+	require.NoError(t, err)
+
+	err = srv.Start(ctx)
+	require.NoError(t, err)
+	testutil.CleanupAndRequireSuccess(t, func() (err error) { return srv.Shutdown(ctx) })
+
+	discover := func(t *testing.T, mac net.HardwareAddr, opts ...layers.DHCPOption) (msg *layers.DHCPv4) {
+		t.Helper()
+
+		req := newTestMessage(t, layers.DHCPMsgTypeDiscover, mac, netip.Addr{}, opts...)
+		testutil.RequireSend(t, reqs, req, testTimeout)
+		pkt, ok := testutil.RequireReceive(t, resps, testTimeout)
+		require.True(t, ok)
+
+		msg, typ := decodeResponse(t, pkt)
+		require.Equal(t, layers.DHCPMsgTypeOffer, typ)
+
+		return msg
+	}
+
+	pxe := layers.NewDHCPOption(layers.DHCPOptClassID, []byte("PXEClient:Arch:00007"))
+
+	t.Run("no_class", func(t *testing.T) {
+		msg := discover(t, mustParseMAC(t, "AA:AA:AA:AA:AA:AA"))
+		assert.NotContains(t, msg.Options, bootFile)
+		assert.NotContains(t, msg.Options, classDNS)
+
+		assert.Equal(t, net.IP{192, 168, 0, 1}, msg.NextServerIP.To4())
+		assert.Equal(t, "undionly.kpxe", string(bytes.TrimRight(msg.File, "\x00")))
+	})
+
+	t.Run("vendor_class", func(t *testing.T) {
+		msg := discover(t, mustParseMAC(t, "AA:AA:AA:AA:AA:AB"), pxe)
+		assert.Contains(t, msg.Options, bootFile)
+		assert.Contains(t, msg.Options, classDNS)
+	})
+
+	t.Run("boot_arch", func(t *testing.T) {
+		arch := layers.NewDHCPOption(93, []byte{0, byte(dhcpsvc.ArchUEFIx64)})
+		msg := discover(t, mustParseMAC(t, "AA:AA:AA:AA:AA:AD"), pxe, arch)
+
+		assert.Equal(t, net.IP(bootServer.AsSlice()), msg.NextServerIP.To4())
+		assert.Equal(t, "ipxe.efi", string(bytes.TrimRight(msg.File, "\x00")))
+		assert.Contains(t, msg.Options, layers.NewDHCPOption(layers.DHCPOptClassID, []byte("PXEClient")))
+	})
+
+	t.Run("static_lease", func(t *testing.T) {
+		msg := discover(t, staticMAC, pxe)
+		assert.Contains(t, msg.Options, bootFile)
+		assert.Contains(t, msg.Options, hostDNS)
+		assert.NotContains(t, msg.Options, classDNS)
+	})
+
+	t.Run("lease_time", func(t *testing.T) {
+		hostname := layers.NewDHCPOption(layers.DHCPOptHostname, []byte("Printer-1"))
+		msg := discover(t, mustParseMAC(t, "AA:AA:AA:AA:AA:AC"), hostname)
+		assert.Contains(t, msg.Options, shortLease)
+	})
+}
@@ -15,8 +15,8 @@ import (
 var _ proxy.BeforeRequestHandler = (*Server)(nil)
 
 // HandleBefore is the handler that is called before any other processing,
-// including logs.  It performs access checks and puts the client ID, if there
-// is one, into the server's cache.
+// including logs.  It performs access checks and rate limiting and puts the
+// client ID, if there is one, into the server's cache.
 //
 // TODO(d.kolyshev): Extract to separate package.
 func (s *Server) HandleBefore(
@@ -47,6 +47,12 @@ func (s *Server) HandleBefore(
 		}
 	}
 
+	err = s.checkRatelimit(pctx, clientID)
+	if err != nil {
+		// Don't wrap the error, because it's informative enough as is.
+		return err
+	}
+
 	if clientID != "" {
 		key := [8]byte{}
 		binary.BigEndian.PutUint64(key[:], pctx.RequestID)
@@ -109,8 +115,11 @@ func (s *Server) preBlockedResponse(pctx *proxy.DNSContext) (err error) {
 		return errAccessBlocked
 	}
 
+	resp := s.makeResponseREFUSED(pctx.Req)
+	s.addProhibitedEDE(pctx.Req, resp)
+
 	return &proxy.BeforeRequestError{
 		Err:      errAccessBlocked,
-		Response: s.makeResponseREFUSED(pctx.Req),
+		Response: resp,
 	}
 }
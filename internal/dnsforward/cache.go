@@ -0,0 +1,545 @@
+package dnsforward
+
+import (
+	"cmp"
+	"container/list"
+	"math"
+	"net/netip"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// cacheKey is the key of a cached DNS response.
+type cacheKey struct {
+	// name is the lowercased FQDN from the question.
+	name string
+
+	// subnet is the EDNS Client Subnet the response is valid for.  It's zero if
+	// the response is valid for any client.
+	subnet netip.Prefix
+
+	// qtype is the type of the question.
+	qtype uint16
+
+	// qclass is the class of the question.
+	qclass uint16
+
+	// do is true if the request has the DNSSEC OK bit set, since the DNSSEC
+	// RRs are removed from the responses to the other requests.
+	do bool
+}
+
+// newCacheKey returns the key for the responses to req valid for subnet.  req
+// must have exactly one question.
+func newCacheKey(req *dns.Msg, subnet netip.Prefix) (k cacheKey) {
+	q := req.Question[0]
+
+	return cacheKey{
+		name:   strings.ToLower(q.Name),
+		subnet: subnet,
+		qtype:  q.Qtype,
+		qclass: q.Qclass,
+		do:     hasDO(req),
+	}
+}
+
+// cacheItemOverhead is the approximate number of bytes used by a cached item
+// in addition to the packed message.
+const cacheItemOverhead = 128
+
+// cacheItem is a single cached response.
+type cacheItem struct {
+	// msg is the cached response without OPT RRs.  It must not be modified.
+	msg *dns.Msg
+
+	// upstream is the address of the upstream which has resolved msg.
+	upstream string
+
+	// stored is the time when msg has been cached.
+	stored time.Time
+
+	// expire is the time when the TTL of msg expires.
+	expire time.Time
+
+	// hits is the number of lookups which found the item before it expired.
+	hits atomic.Uint32
+
+	// key is the key of the item.
+	key cacheKey
+
+	// size is the approximate number of bytes the item takes.
+	size int
+}
+
+// ttl returns the remaining TTL of ci at now in seconds.  It's at least 1,
+// since ci is only used if it hasn't yet expired.
+func (ci *cacheItem) ttl(now time.Time) (ttl uint32) {
+	return max(uint32(ci.expire.Sub(now)/time.Second), 1)
+}
+
+// reply returns the response to req from ci with all the TTLs set to ttl.
+func (ci *cacheItem) reply(req *dns.Msg, ttl uint32) (resp *dns.Msg) {
+	m := ci.msg
+	resp = (&dns.Msg{}).SetRcode(req, m.Rcode)
+	resp.AuthenticatedData = m.AuthenticatedData
+	resp.RecursionAvailable = m.RecursionAvailable
+	resp.Answer = copyRRsWithTTL(m.Answer, ttl)
+	resp.Ns = copyRRsWithTTL(m.Ns, ttl)
+	resp.Extra = copyRRsWithTTL(m.Extra, ttl)
+
+	return resp
+}
+
+// copyRRsWithTTL returns deep copies of rrs with TTLs set to ttl.
+func copyRRsWithTTL(rrs []dns.RR, ttl uint32) (copied []dns.RR) {
+	if len(rrs) == 0 {
+		return nil
+	}
+
+	copied = make([]dns.RR, 0, len(rrs))
+	for _, rr := range rrs {
+		c := dns.Copy(rr)
+		c.Header().Ttl = ttl
+		copied = append(copied, c)
+	}
+
+	return copied
+}
+
+// cacheRefresh is an in-flight update of a cached item.
+type cacheRefresh struct {
+	// done is closed when the update is finished.
+	done chan struct{}
+}
+
+// dnsCacheConfig is the configuration of a [dnsCache].
+type dnsCacheConfig struct {
+	// MaxSize is the maximum approximate size of the cache in bytes.  It must
+	// be positive.
+	MaxSize int
+
+	// StaleMaxAge is the maximum time after the expiration during which the
+	// expired items are still served.  Zero means no limit.  It has no effect
+	// unless Optimistic is true.
+	StaleMaxAge time.Duration
+
+	// MinTTL is the minimum TTL of the cached items in seconds.  Zero means
+	// no limit.
+	MinTTL uint32
+
+	// MaxTTL is the maximum TTL of the cached items in seconds.  Zero means no
+	// limit.
+	MaxTTL uint32
+
+	// Optimistic defines if the expired items should be served.
+	Optimistic bool
+}
+
+// dnsCache is an LRU cache of DNS responses.  It's able to serve the expired
+// responses as described by [RFC 8767].
+//
+// [RFC 8767]: https://datatracker.ietf.org/doc/html/rfc8767
+type dnsCache struct {
+	// mu protects items, lru, size, and refreshes.
+	mu *sync.Mutex
+
+	// items are the cached items by their keys.  The values are elements of
+	// lru.
+	items map[cacheKey]*list.Element
+
+	// lru is the list of *cacheItem from the most to the least recently used.
+	lru *list.List
+
+	// refreshes are the in-flight updates of the cached items.
+	refreshes map[cacheKey]*cacheRefresh
+
+	// hits is the number of lookups which found an item, including the stale
+	// ones.
+	hits atomic.Uint64
+
+	// misses is the number of lookups which found no item.
+	misses atomic.Uint64
+
+	// staleServed is the number of responses served from the expired items.
+	staleServed atomic.Uint64
+
+	// size is the current approximate size of the cache in bytes.
+	size int
+
+	// maxSize is the maximum approximate size of the cache in bytes.
+	maxSize int
+
+	// staleMaxAge is the maximum time after the expiration during which the
+	// expired items are still served.
+	staleMaxAge time.Duration
+
+	// minTTL is the minimum TTL of the cached items in seconds.
+	minTTL uint32
+
+	// maxTTL is the maximum TTL of the cached items in seconds.
+	maxTTL uint32
+
+	// optimistic defines if the expired items should be served.
+	optimistic bool
+}
+
+// newDNSCache returns a new properly initialized *dnsCache.  conf must not be
+// nil.
+func newDNSCache(conf *dnsCacheConfig) (c *dnsCache) {
+	return &dnsCache{
+		mu:          &sync.Mutex{},
+		items:       map[cacheKey]*list.Element{},
+		lru:         list.New(),
+		refreshes:   map[cacheKey]*cacheRefresh{},
+		maxSize:     conf.MaxSize,
+		staleMaxAge: conf.StaleMaxAge,
+		minTTL:      conf.MinTTL,
+		maxTTL:      conf.MaxTTL,
+		optimistic:  conf.Optimistic,
+	}
+}
+
+// get returns the item for k, if any.  expired is true if the item's TTL has
+// expired at now, but it still may be served.
+func (c *dnsCache) get(k cacheKey, now time.Time) (item *cacheItem, expired bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.items[k]
+	if !ok {
+		c.misses.Add(1)
+
+		return nil, false
+	}
+
+	item = e.Value.(*cacheItem)
+	if expired = !now.Before(item.expire); expired && !c.canServeStale(item, now) {
+		c.removeElement(e)
+		c.misses.Add(1)
+
+		return nil, false
+	}
+
+	c.lru.MoveToFront(e)
+	c.hits.Add(1)
+	if !expired {
+		item.hits.Add(1)
+	}
+
+	return item, expired
+}
+
+// canServeStale returns true if the expired item may still be served at now.
+// c.mu is expected to be locked.
+func (c *dnsCache) canServeStale(item *cacheItem, now time.Time) (ok bool) {
+	if !c.optimistic {
+		return false
+	}
+
+	return c.staleMaxAge == 0 || now.Sub(item.expire) <= c.staleMaxAge
+}
+
+// set stores resp resolved by the upstream with address ups under k, if it's
+// cacheable.
+func (c *dnsCache) set(k cacheKey, resp *dns.Msg, ups string, now time.Time) {
+	ttl := cacheTTL(resp)
+	if ttl == 0 {
+		return
+	}
+
+	if c.minTTL > 0 {
+		ttl = max(ttl, c.minTTL)
+	}
+
+	if c.maxTTL > 0 {
+		ttl = min(ttl, c.maxTTL)
+	}
+
+	m := resp.Copy()
+	m.Extra = removeOPT(m.Extra)
+
+	item := &cacheItem{
+		msg:      m,
+		upstream: ups,
+		stored:   now,
+		expire:   now.Add(time.Duration(ttl) * time.Second),
+		key:      k,
+		size:     m.Len() + len(k.name) + len(ups) + cacheItemOverhead,
+	}
+	if item.size > c.maxSize {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if e, ok := c.items[k]; ok {
+		c.removeElement(e)
+	}
+
+	c.items[k] = c.lru.PushFront(item)
+	c.size += item.size
+
+	for c.size > c.maxSize {
+		c.removeElement(c.lru.Back())
+	}
+}
+
+// removeElement removes e from c.  c.mu is expected to be locked.
+func (c *dnsCache) removeElement(e *list.Element) {
+	item := c.lru.Remove(e).(*cacheItem)
+	delete(c.items, item.key)
+	c.size -= item.size
+}
+
+// clear removes all the items from c.
+func (c *dnsCache) clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	clear(c.items)
+	c.lru.Init()
+	c.size = 0
+}
+
+// cacheEntry is the information about a single cached item.
+type cacheEntry struct {
+	// subnet is the EDNS Client Subnet the item is valid for, if any.
+	subnet netip.Prefix
+
+	// upstream is the address of the upstream which has resolved the item.
+	upstream string
+
+	// ttl is the remaining TTL of the item in seconds.  It's zero if the item
+	// has expired.
+	ttl uint32
+
+	// qtype is the type of the question.
+	qtype uint16
+
+	// expired is true if the TTL of the item has expired, but the item is
+	// still served.
+	expired bool
+}
+
+// lookup returns the information about the items for the lowercased FQDN name
+// at now.  If qtype is not zero, only the items for the questions of that type
+// are returned.  Unlike [dnsCache.get], it doesn't affect the order of the
+// items and the statistics.
+func (c *dnsCache) lookup(name string, qtype uint16, now time.Time) (entries []*cacheEntry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for k, e := range c.items {
+		if k.name != name || (qtype != 0 && k.qtype != qtype) {
+			continue
+		}
+
+		item := e.Value.(*cacheItem)
+		entry := &cacheEntry{
+			subnet:   k.subnet,
+			upstream: item.upstream,
+			qtype:    k.qtype,
+			expired:  !now.Before(item.expire),
+		}
+		if !entry.expired {
+			entry.ttl = item.ttl(now)
+		}
+
+		entries = append(entries, entry)
+	}
+
+	slices.SortFunc(entries, func(a, b *cacheEntry) (res int) {
+		return cmp.Or(cmp.Compare(a.qtype, b.qtype), strings.Compare(a.subnet.String(), b.subnet.String()))
+	})
+
+	return entries
+}
+
+// purge removes the items which names match and returns the number of removed
+// items.  match must not be nil.
+func (c *dnsCache) purge(match func(name string) (ok bool)) (n int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for e := c.lru.Front(); e != nil; {
+		next := e.Next()
+		if match(e.Value.(*cacheItem).key.name) {
+			c.removeElement(e)
+			n++
+		}
+
+		e = next
+	}
+
+	return n
+}
+
+// cacheStats is the statistics of a [dnsCache].
+type cacheStats struct {
+	// hits is the number of lookups which found an item.
+	hits uint64
+
+	// misses is the number of lookups which found no item.
+	misses uint64
+
+	// staleServed is the number of responses served from the expired items.
+	staleServed uint64
+
+	// entries is the number of cached items.
+	entries int
+
+	// size is the approximate size of the cache in bytes.
+	size int
+
+	// maxSize is the maximum approximate size of the cache in bytes.
+	maxSize int
+}
+
+// stats returns the current statistics of c.
+func (c *dnsCache) stats() (s *cacheStats) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return &cacheStats{
+		hits:        c.hits.Load(),
+		misses:      c.misses.Load(),
+		staleServed: c.staleServed.Load(),
+		entries:     len(c.items),
+		size:        c.size,
+		maxSize:     c.maxSize,
+	}
+}
+
+// startRefresh returns the in-flight update of the item for k.  started is
+// true if there was no such update, so the caller must perform it and then call
+// [dnsCache.finishRefresh].
+func (c *dnsCache) startRefresh(k cacheKey) (r *cacheRefresh, started bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if r, ok := c.refreshes[k]; ok {
+		return r, false
+	}
+
+	r = &cacheRefresh{
+		done: make(chan struct{}),
+	}
+	c.refreshes[k] = r
+
+	return r, true
+}
+
+// finishRefresh marks the update of the item for k as finished.
+func (c *dnsCache) finishRefresh(k cacheKey) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if r, ok := c.refreshes[k]; ok {
+		delete(c.refreshes, k)
+		close(r.done)
+	}
+}
+
+// removeOPT returns rrs without OPT RRs.  It modifies rrs.
+func removeOPT(rrs []dns.RR) (filtered []dns.RR) {
+	filtered = rrs[:0]
+	for _, rr := range rrs {
+		if rr.Header().Rrtype != dns.TypeOPT {
+			filtered = append(filtered, rr)
+		}
+	}
+
+	return filtered
+}
+
+// servFailMaxCacheTTL is the maximum TTL of the cached SERVFAIL responses in
+// seconds.  It's consistent with the upper limit of 5 minutes given by RFC
+// 2308.
+//
+// See https://datatracker.ietf.org/doc/html/rfc2308#section-7.1.
+const servFailMaxCacheTTL = 30
+
+// cacheTTL returns the number of seconds for which m could be cached.  It
+// returns 0 if m isn't cacheable.  For negative answers it follows RFC 2308.
+//
+// See https://datatracker.ietf.org/doc/html/rfc2308#section-2.1 and
+// https://datatracker.ietf.org/doc/html/rfc2308#section-2.2.
+//
+// NOTE: Keep in sync with dnsproxy.
+func cacheTTL(m *dns.Msg) (ttl uint32) {
+	if m == nil || m.Truncated || m.CheckingDisabled || len(m.Question) != 1 {
+		return 0
+	}
+
+	// Use the maximum value as a guard value.  If the loop isn't entered, catch
+	// that and return zero.
+	ttl = math.MaxUint32
+	for _, rrs := range [...][]dns.RR{m.Answer, m.Ns, m.Extra} {
+		for _, rr := range rrs {
+			if h := rr.Header(); h.Rrtype != dns.TypeOPT {
+				ttl = min(ttl, h.Ttl)
+			}
+		}
+	}
+
+	if ttl == 0 || ttl == math.MaxUint32 {
+		return 0
+	}
+
+	switch m.Rcode {
+	case dns.RcodeSuccess:
+		if isCacheableSuccess(m) {
+			return ttl
+		}
+	case dns.RcodeNameError:
+		if isCacheableNegative(m) {
+			return ttl
+		}
+	case dns.RcodeServerFailure:
+		return min(ttl, servFailMaxCacheTTL)
+	}
+
+	return 0
+}
+
+// isCacheableSuccess returns true if m contains useful data to be cached as a
+// successful response.
+func isCacheableSuccess(m *dns.Msg) (ok bool) {
+	qt := m.Question[0].Qtype
+	if qt != dns.TypeA && qt != dns.TypeAAAA {
+		return true
+	}
+
+	for _, rr := range m.Answer {
+		if t := rr.Header().Rrtype; t == dns.TypeA || t == dns.TypeAAAA {
+			return true
+		}
+	}
+
+	return isCacheableNegative(m)
+}
+
+// isCacheableNegative returns true if m's authority section has at least a
+// single SOA RR and no NS RRs.
+//
+// See https://datatracker.ietf.org/doc/html/rfc2308#section-5.
+func isCacheableNegative(m *dns.Msg) (ok bool) {
+	for _, rr := range m.Ns {
+		switch rr.Header().Rrtype {
+		case dns.TypeSOA:
+			ok = true
+		case dns.TypeNS:
+			return false
+		default:
+			// Go on.
+		}
+	}
+
+	return ok
+}
@@ -0,0 +1,388 @@
+package dnsforward
+
+import (
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/aghtest"
+	"github.com/AdguardTeam/AdGuardHome/internal/client"
+	"github.com/AdguardTeam/AdGuardHome/internal/filtering"
+	"github.com/AdguardTeam/dnsproxy/proxy"
+	"github.com/AdguardTeam/dnsproxy/upstream"
+	"github.com/AdguardTeam/golibs/testutil"
+	"github.com/AdguardTeam/golibs/timeutil"
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	// testCacheUps is the address of the upstream used in cache tests.
+	testCacheUps = "upstream.example"
+
+	// testCacheSize is the size of the caches used in tests.
+	testCacheSize = 1024 * 1024
+)
+
+// newTestCacheResp returns a cacheable response to the A request for fqdn with
+// the given TTL.
+func newTestCacheResp(tb testing.TB, fqdn string, ttl uint32) (resp *dns.Msg) {
+	tb.Helper()
+
+	resp = aghtest.MatchedResponse(createTestMessage(fqdn), dns.TypeA, fqdn, "1.2.3.4")
+	require.NotNil(tb, resp)
+
+	resp.Answer[0].Header().Ttl = ttl
+
+	return resp
+}
+
+func TestDNSCache_get(t *testing.T) {
+	t.Parallel()
+
+	const (
+		fqdn = "cached.example."
+		ttl  = 60
+	)
+
+	now := time.Now()
+	expiredAt := now.Add(ttl * time.Second)
+
+	testCases := []struct {
+		at          time.Time
+		name        string
+		staleMaxAge time.Duration
+		optimistic  bool
+		wantItem    bool
+		wantExpired bool
+	}{{
+		at:          now,
+		name:        "fresh",
+		staleMaxAge: 0,
+		optimistic:  false,
+		wantItem:    true,
+		wantExpired: false,
+	}, {
+		at:          expiredAt,
+		name:        "expired",
+		staleMaxAge: 0,
+		optimistic:  false,
+		wantItem:    false,
+		wantExpired: false,
+	}, {
+		at:          expiredAt.Add(time.Hour),
+		name:        "stale_unlimited",
+		staleMaxAge: 0,
+		optimistic:  true,
+		wantItem:    true,
+		wantExpired: true,
+	}, {
+		at:          expiredAt.Add(time.Minute),
+		name:        "stale_within_max_age",
+		staleMaxAge: time.Hour,
+		optimistic:  true,
+		wantItem:    true,
+		wantExpired: true,
+	}, {
+		at:          expiredAt.Add(2 * time.Hour),
+		name:        "stale_beyond_max_age",
+		staleMaxAge: time.Hour,
+		optimistic:  true,
+		wantItem:    false,
+		wantExpired: false,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			c := newDNSCache(&dnsCacheConfig{
+				MaxSize:     testCacheSize,
+				StaleMaxAge: tc.staleMaxAge,
+				Optimistic:  tc.optimistic,
+			})
+
+			req := createTestMessage(fqdn)
+			key := newCacheKey(req, netip.Prefix{})
+			c.set(key, newTestCacheResp(t, fqdn, ttl), testCacheUps, now)
+
+			item, expired := c.get(key, tc.at)
+			assert.Equal(t, tc.wantExpired, expired)
+			if !tc.wantItem {
+				assert.Nil(t, item)
+
+				return
+			}
+
+			require.NotNil(t, item)
+
+			resp := item.reply(req, 10)
+			require.Len(t, resp.Answer, 1)
+
+			assert.Equal(t, req.Id, resp.Id)
+			assert.Equal(t, uint32(10), resp.Answer[0].Header().Ttl)
+			assert.Equal(t, testCacheUps, item.upstream)
+		})
+	}
+}
+
+func TestDNSCache_set(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now()
+
+	t.Run("not_cacheable", func(t *testing.T) {
+		t.Parallel()
+
+		c := newDNSCache(&dnsCacheConfig{
+			MaxSize: testCacheSize,
+		})
+
+		const fqdn = "zero-ttl.example."
+		key := newCacheKey(createTestMessage(fqdn), netip.Prefix{})
+		c.set(key, newTestCacheResp(t, fqdn, 0), testCacheUps, now)
+
+		item, _ := c.get(key, now)
+		assert.Nil(t, item)
+	})
+
+	t.Run("ttl_override", func(t *testing.T) {
+		t.Parallel()
+
+		c := newDNSCache(&dnsCacheConfig{
+			MaxSize: testCacheSize,
+			MinTTL:  30,
+			MaxTTL:  300,
+		})
+
+		for _, tc := range []struct {
+			fqdn    string
+			ttl     uint32
+			wantTTL uint32
+		}{{
+			fqdn:    "low.example.",
+			ttl:     10,
+			wantTTL: 30,
+		}, {
+			fqdn:    "mid.example.",
+			ttl:     60,
+			wantTTL: 60,
+		}, {
+			fqdn:    "high.example.",
+			ttl:     3600,
+			wantTTL: 300,
+		}} {
+			key := newCacheKey(createTestMessage(tc.fqdn), netip.Prefix{})
+			c.set(key, newTestCacheResp(t, tc.fqdn, tc.ttl), testCacheUps, now)
+
+			item, _ := c.get(key, now)
+			require.NotNil(t, item)
+
+			assert.Equal(t, tc.wantTTL, item.ttl(now), tc.fqdn)
+		}
+	})
+
+	t.Run("eviction", func(t *testing.T) {
+		t.Parallel()
+
+		resp := newTestCacheResp(t, "first.example.", 60)
+		itemSize := resp.Len() + len("first.example.") + len(testCacheUps) + cacheItemOverhead
+
+		c := newDNSCache(&dnsCacheConfig{
+			MaxSize: 2*itemSize + 1,
+		})
+
+		keys := make([]cacheKey, 0, 3)
+		for _, fqdn := range []string{"first.example.", "secnd.example.", "third.example."} {
+			k := newCacheKey(createTestMessage(fqdn), netip.Prefix{})
+			keys = append(keys, k)
+
+			c.set(k, newTestCacheResp(t, fqdn, 60), testCacheUps, now)
+		}
+
+		item, _ := c.get(keys[0], now)
+		assert.Nil(t, item)
+
+		for _, k := range keys[1:] {
+			item, _ = c.get(k, now)
+			assert.NotNil(t, item)
+		}
+	})
+
+	t.Run("key", func(t *testing.T) {
+		t.Parallel()
+
+		c := newDNSCache(&dnsCacheConfig{
+			MaxSize: testCacheSize,
+		})
+
+		const fqdn = "Key.Example."
+		req := createTestMessage(fqdn)
+		c.set(newCacheKey(req, netip.Prefix{}), newTestCacheResp(t, fqdn, 60), testCacheUps, now)
+
+		item, _ := c.get(newCacheKey(createTestMessage("key.example."), netip.Prefix{}), now)
+		assert.NotNil(t, item)
+
+		subnet := netip.MustParsePrefix("1.2.3.0/24")
+		item, _ = c.get(newCacheKey(req, subnet), now)
+		assert.Nil(t, item)
+
+		doReq := createTestMessage(fqdn)
+		doReq.SetEdns0(ednsUDPSize, true)
+		item, _ = c.get(newCacheKey(doReq, netip.Prefix{}), now)
+		assert.Nil(t, item)
+	})
+}
+
+func TestServer_resolve_stale(t *testing.T) {
+	t.Parallel()
+
+	const (
+		fqdn       = "stale.example."
+		excluded   = "excluded.example."
+		freshAddr  = "5.6.7.8"
+		staleTTL   = 42
+		timeoutDur = time.Second
+	)
+
+	testCases := []struct {
+		name          string
+		host          string
+		wantAddr      net.IP
+		wantTTL       uint32
+		clientTimeout time.Duration
+		upsFails      bool
+		wantStale     bool
+	}{{
+		name:          "immediate",
+		host:          fqdn,
+		wantAddr:      net.IP{1, 2, 3, 4},
+		wantTTL:       staleTTL,
+		clientTimeout: 0,
+		upsFails:      false,
+		wantStale:     true,
+	}, {
+		name:          "upstream_in_time",
+		host:          fqdn,
+		wantAddr:      net.ParseIP(freshAddr).To4(),
+		wantTTL:       60,
+		clientTimeout: timeoutDur,
+		upsFails:      false,
+		wantStale:     false,
+	}, {
+		name:          "upstream_fails",
+		host:          fqdn,
+		wantAddr:      net.IP{1, 2, 3, 4},
+		wantTTL:       staleTTL,
+		clientTimeout: timeoutDur,
+		upsFails:      true,
+		wantStale:     true,
+	}, {
+		name:          "excluded",
+		host:          excluded,
+		wantAddr:      net.ParseIP(freshAddr).To4(),
+		wantTTL:       60,
+		clientTimeout: 0,
+		upsFails:      false,
+		wantStale:     false,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			s := createTestServer(t, &filtering.Config{
+				BlockingMode: filtering.BlockingModeDefault,
+			}, ServerConfig{
+				UDPListenAddrs: []*net.UDPAddr{{}},
+				TCPListenAddrs: []*net.TCPAddr{{}},
+				Config: Config{
+					UpstreamMode: UpstreamModeLoadBalance,
+					EDNSClientSubnet: &EDNSClientSubnet{
+						Enabled: false,
+					},
+					CacheSize:       testCacheSize,
+					CacheOptimistic: true,
+					CacheOptimisticAnswerTTL: timeutil.Duration{
+						Duration: staleTTL * time.Second,
+					},
+					CacheOptimisticClientTimeout: timeutil.Duration{
+						Duration: tc.clientTimeout,
+					},
+					CacheOptimisticExclusions: []string{"excluded.example"},
+				},
+				ServePlainDNS: true,
+			})
+
+			ups := aghtest.NewUpstreamMock(func(req *dns.Msg) (resp *dns.Msg, err error) {
+				if tc.upsFails {
+					return nil, assert.AnError
+				}
+
+				return aghtest.MatchedResponse(req, dns.TypeA, tc.host, freshAddr), nil
+			})
+			s.dnsProxy.UpstreamConfig.Upstreams = []upstream.Upstream{ups}
+
+			req := createTestMessage(tc.host)
+			key := newCacheKey(req, netip.Prefix{})
+			s.cache.set(key, newTestCacheResp(t, tc.host, 60), testCacheUps, time.Now().Add(-time.Hour))
+
+			dctx := &dnsContext{
+				proxyCtx: &proxy.DNSContext{
+					Req:   req,
+					Addr:  testClientAddrPort,
+					Proto: proxy.ProtoUDP,
+				},
+				cache: s.cache,
+			}
+
+			err := s.resolve(s.dnsProxy, dctx)
+			require.NoError(t, err)
+
+			resp := dctx.proxyCtx.Res
+			require.NotNil(t, resp)
+			require.Len(t, resp.Answer, 1)
+
+			a := testutil.RequireTypeAssert[*dns.A](t, resp.Answer[0])
+			assert.Equal(t, tc.wantAddr, a.A.To4())
+			assert.InDelta(t, tc.wantTTL, a.Hdr.Ttl, 1)
+			assert.Equal(t, tc.wantStale, dctx.isStale)
+		})
+	}
+}
+
+func TestServer_RemoveClientCache(t *testing.T) {
+	t.Parallel()
+
+	s := createTestServer(t, &filtering.Config{
+		BlockingMode: filtering.BlockingModeDefault,
+	}, ServerConfig{
+		UDPListenAddrs: []*net.UDPAddr{{}},
+		TCPListenAddrs: []*net.TCPAddr{{}},
+		Config: Config{
+			UpstreamMode: UpstreamModeLoadBalance,
+			EDNSClientSubnet: &EDNSClientSubnet{
+				Enabled: false,
+			},
+		},
+		ServePlainDNS: true,
+	})
+
+	ups := proxy.NewCustomUpstreamConfig(&proxy.UpstreamConfig{}, false, 0, false)
+	info := &client.Info{
+		Name:      "client",
+		UID:       client.UID{1},
+		CacheSize: testCacheSize,
+	}
+
+	c := s.cacheForUpstreams(info, ups)
+	require.NotNil(t, c)
+
+	s.RemoveClientCache(client.UID{2})
+	assert.Same(t, c, s.cacheForUpstreams(info, ups))
+
+	s.RemoveClientCache(info.UID)
+	assert.NotSame(t, c, s.cacheForUpstreams(info, ups))
+}
@@ -0,0 +1,300 @@
+package dnsforward
+
+import (
+	"encoding/json"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/aghhttp"
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/miekg/dns"
+)
+
+// namedCache is a DNS cache along with the name of the client it belongs to.
+type namedCache struct {
+	// cache is the cache itself.  It must not be nil.
+	cache *dnsCache
+
+	// client is the name of the persistent client the cache belongs to.  It's
+	// empty for the global cache.
+	client string
+}
+
+// namedCaches returns all the DNS caches of s, starting with the global one, if
+// any, followed by the clients' ones sorted by the client names.
+func (s *Server) namedCaches() (caches []*namedCache) {
+	s.serverLock.RLock()
+	defer s.serverLock.RUnlock()
+
+	if s.cache != nil {
+		caches = append(caches, &namedCache{
+			cache: s.cache,
+		})
+	}
+
+	ccs := s.clientCaches
+	if ccs == nil {
+		return caches
+	}
+
+	ccs.mu.Lock()
+	defer ccs.mu.Unlock()
+
+	clientCaches := make([]*namedCache, 0, len(ccs.caches))
+	for _, cc := range ccs.caches {
+		clientCaches = append(clientCaches, &namedCache{
+			cache:  cc.cache,
+			client: cc.name,
+		})
+	}
+
+	slices.SortFunc(clientCaches, func(a, b *namedCache) (res int) {
+		return strings.Compare(a.client, b.client)
+	})
+
+	return append(caches, clientCaches...)
+}
+
+// cacheEntryJSON is the JSON representation of a single cached item.
+type cacheEntryJSON struct {
+	// Client is the name of the persistent client which cache contains the
+	// item.  It's empty for the global cache.
+	Client string `json:"client"`
+
+	// Name is the FQDN of the question.
+	Name string `json:"name"`
+
+	// QType is the type of the question.
+	QType string `json:"qtype"`
+
+	// Subnet is the EDNS Client Subnet the item is valid for, if any.
+	Subnet string `json:"subnet,omitempty"`
+
+	// Upstream is the address of the upstream which has resolved the item.
+	Upstream string `json:"upstream"`
+
+	// TTL is the remaining TTL of the item in seconds.
+	TTL uint32 `json:"ttl"`
+
+	// Expired is true if the TTL of the item has expired, but the item is
+	// still served.
+	Expired bool `json:"expired"`
+}
+
+// cacheLookupResp is the response to the GET /control/cache/lookup HTTP API.
+type cacheLookupResp struct {
+	Entries []*cacheEntryJSON `json:"entries"`
+}
+
+// handleCacheLookup is the handler for the GET /control/cache/lookup HTTP API.
+func (s *Server) handleCacheLookup(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+
+	name := normalizeCacheName(q.Get("name"))
+	if name == "" {
+		aghhttp.Error(r, w, http.StatusBadRequest, "name: %s", errors.ErrEmptyValue)
+
+		return
+	}
+
+	var qtype uint16
+	if qtStr := q.Get("qtype"); qtStr != "" {
+		var ok bool
+		qtype, ok = dns.StringToType[strings.ToUpper(qtStr)]
+		if !ok {
+			aghhttp.Error(r, w, http.StatusBadRequest, "qtype: bad type %q", qtStr)
+
+			return
+		}
+	}
+
+	resp := &cacheLookupResp{
+		Entries: []*cacheEntryJSON{},
+	}
+
+	now := time.Now()
+	for _, nc := range s.namedCaches() {
+		for _, e := range nc.cache.lookup(name, qtype, now) {
+			ej := &cacheEntryJSON{
+				Client:   nc.client,
+				Name:     name,
+				QType:    dns.Type(e.qtype).String(),
+				Upstream: e.upstream,
+				TTL:      e.ttl,
+				Expired:  e.expired,
+			}
+			if e.subnet.IsValid() {
+				ej.Subnet = e.subnet.String()
+			}
+
+			resp.Entries = append(resp.Entries, ej)
+		}
+	}
+
+	aghhttp.WriteJSONResponseOK(w, r, resp)
+}
+
+// cachePurgeReq is the request to the POST /control/cache/purge HTTP API.
+type cachePurgeReq struct {
+	// Name is the exact domain name to purge.  It must not be set along with
+	// Suffix.
+	Name string `json:"name"`
+
+	// Suffix is the domain name to purge along with all its subdomains.  It
+	// must not be set along with Name.
+	Suffix string `json:"suffix"`
+
+	// Client is the name of the persistent client which cache should be
+	// purged.  If empty, all the caches are purged.
+	Client string `json:"client"`
+}
+
+// validate returns an error if req is invalid.
+func (req *cachePurgeReq) validate() (err error) {
+	switch {
+	case req.Name != "" && req.Suffix != "":
+		return errors.Error("name and suffix cannot be set at the same time")
+	case req.Name == "" && req.Suffix == "" && req.Client == "":
+		return errors.Error("name, suffix, or client must be set")
+	default:
+		return nil
+	}
+}
+
+// matcher returns the function matching the names of the cached items to be
+// purged.
+func (req *cachePurgeReq) matcher() (match func(name string) (ok bool)) {
+	switch {
+	case req.Name != "":
+		name := normalizeCacheName(req.Name)
+
+		return func(n string) (ok bool) { return n == name }
+	case req.Suffix != "":
+		suffix := normalizeCacheName(req.Suffix)
+
+		return func(n string) (ok bool) {
+			return n == suffix || strings.HasSuffix(n, "."+suffix)
+		}
+	default:
+		return func(_ string) (ok bool) { return true }
+	}
+}
+
+// cachePurgeResp is the response to the POST /control/cache/purge HTTP API.
+type cachePurgeResp struct {
+	// Removed is the number of removed items.
+	Removed int `json:"removed"`
+}
+
+// handleCachePurge is the handler for the POST /control/cache/purge HTTP API.
+func (s *Server) handleCachePurge(w http.ResponseWriter, r *http.Request) {
+	req := &cachePurgeReq{}
+	err := json.NewDecoder(r.Body).Decode(req)
+	if err != nil {
+		aghhttp.Error(r, w, http.StatusBadRequest, "reading req: %s", err)
+
+		return
+	}
+
+	err = req.validate()
+	if err != nil {
+		aghhttp.Error(r, w, http.StatusBadRequest, "%s", err)
+
+		return
+	}
+
+	match := req.matcher()
+	found := false
+	resp := &cachePurgeResp{}
+	for _, nc := range s.namedCaches() {
+		if req.Client != "" && nc.client != req.Client {
+			continue
+		}
+
+		found = true
+		resp.Removed += nc.cache.purge(match)
+	}
+
+	if req.Client != "" && !found {
+		aghhttp.Error(r, w, http.StatusNotFound, "no cache for client %q", req.Client)
+
+		return
+	}
+
+	aghhttp.WriteJSONResponseOK(w, r, resp)
+}
+
+// normalizeCacheName returns the lowercased FQDN for the domain name, as used
+// in the cache keys, or an empty string if name is empty.
+func normalizeCacheName(name string) (fqdn string) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return ""
+	}
+
+	return dns.Fqdn(strings.ToLower(name))
+}
+
+// cacheStatsJSON is the JSON representation of the statistics of a single DNS
+// cache.
+type cacheStatsJSON struct {
+	// Client is the name of the persistent client the cache belongs to.  It's
+	// empty for the global cache.
+	Client string `json:"client"`
+
+	// Entries is the number of cached items.
+	Entries int `json:"entries"`
+
+	// Size is the approximate size of the cache in bytes.
+	Size int `json:"size"`
+
+	// MaxSize is the maximum approximate size of the cache in bytes.
+	MaxSize int `json:"max_size"`
+
+	// Hits is the number of lookups which found an item.
+	Hits uint64 `json:"hits"`
+
+	// Misses is the number of lookups which found no item.
+	Misses uint64 `json:"misses"`
+
+	// StaleAnswers is the number of responses served from the expired items.
+	StaleAnswers uint64 `json:"stale_answers"`
+
+	// HitRatio is the ratio of Hits to all lookups.  It's zero if there were
+	// no lookups.
+	HitRatio float64 `json:"hit_ratio"`
+}
+
+// cacheStatsResp is the response to the GET /control/cache/stats HTTP API.
+type cacheStatsResp struct {
+	Caches []*cacheStatsJSON `json:"caches"`
+}
+
+// handleCacheStats is the handler for the GET /control/cache/stats HTTP API.
+func (s *Server) handleCacheStats(w http.ResponseWriter, r *http.Request) {
+	resp := &cacheStatsResp{
+		Caches: []*cacheStatsJSON{},
+	}
+
+	for _, nc := range s.namedCaches() {
+		st := nc.cache.stats()
+		sj := &cacheStatsJSON{
+			Client:       nc.client,
+			Entries:      st.entries,
+			Size:         st.size,
+			MaxSize:      st.maxSize,
+			Hits:         st.hits,
+			Misses:       st.misses,
+			StaleAnswers: st.staleServed,
+		}
+		if total := st.hits + st.misses; total > 0 {
+			sj.HitRatio = float64(st.hits) / float64(total)
+		}
+
+		resp.Caches = append(resp.Caches, sj)
+	}
+
+	aghhttp.WriteJSONResponseOK(w, r, resp)
+}
@@ -0,0 +1,274 @@
+package dnsforward
+
+import (
+	"bytes"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/client"
+	"github.com/AdguardTeam/AdGuardHome/internal/filtering"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testCacheClientName is the name of the persistent client with its own cache
+// used in cache tests.
+const testCacheClientName = "client"
+
+// newTestCacheServer returns a new *Server with the global cache containing the
+// items for globalFQDNs and the cache of the client named
+// [testCacheClientName] containing the items for clientFQDNs.
+func newTestCacheServer(t *testing.T, globalFQDNs, clientFQDNs []string) (s *Server) {
+	t.Helper()
+
+	s = createTestServer(t, &filtering.Config{
+		BlockingMode: filtering.BlockingModeDefault,
+	}, ServerConfig{
+		UDPListenAddrs: []*net.UDPAddr{{}},
+		TCPListenAddrs: []*net.TCPAddr{{}},
+		Config: Config{
+			UpstreamMode: UpstreamModeLoadBalance,
+			EDNSClientSubnet: &EDNSClientSubnet{
+				Enabled: false,
+			},
+			CacheSize: testCacheSize,
+		},
+		ServePlainDNS: true,
+	})
+
+	now := time.Now()
+	for _, fqdn := range globalFQDNs {
+		key := newCacheKey(createTestMessage(fqdn), netip.Prefix{})
+		s.cache.set(key, newTestCacheResp(t, fqdn, 60), testCacheUps, now)
+	}
+
+	cc := s.cacheForUpstreams(&client.Info{
+		Name:      testCacheClientName,
+		UID:       client.UID{1},
+		CacheSize: testCacheSize,
+	}, nil)
+	require.NotNil(t, cc)
+
+	for _, fqdn := range clientFQDNs {
+		key := newCacheKey(createTestMessage(fqdn), netip.Prefix{})
+		cc.set(key, newTestCacheResp(t, fqdn, 60), testCacheUps, now)
+	}
+
+	return s
+}
+
+func TestServer_HandleCacheLookup(t *testing.T) {
+	t.Parallel()
+
+	const fqdn = "lookup.example."
+
+	s := newTestCacheServer(t, []string{fqdn}, []string{fqdn})
+
+	testCases := []struct {
+		name        string
+		query       string
+		wantClients []string
+		wantCode    int
+	}{{
+		name:        "both_caches",
+		query:       "?name=Lookup.Example",
+		wantClients: []string{"", testCacheClientName},
+		wantCode:    http.StatusOK,
+	}, {
+		name:        "qtype",
+		query:       "?name=lookup.example&qtype=a",
+		wantClients: []string{"", testCacheClientName},
+		wantCode:    http.StatusOK,
+	}, {
+		name:        "other_qtype",
+		query:       "?name=lookup.example&qtype=AAAA",
+		wantClients: []string{},
+		wantCode:    http.StatusOK,
+	}, {
+		name:        "bad_qtype",
+		query:       "?name=lookup.example&qtype=bad",
+		wantClients: nil,
+		wantCode:    http.StatusBadRequest,
+	}, {
+		name:        "no_name",
+		query:       "",
+		wantClients: nil,
+		wantCode:    http.StatusBadRequest,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodGet, "/control/cache/lookup"+tc.query, nil)
+
+			s.handleCacheLookup(w, r)
+			require.Equal(t, tc.wantCode, w.Code)
+
+			if tc.wantCode != http.StatusOK {
+				return
+			}
+
+			resp := &cacheLookupResp{}
+			err := json.NewDecoder(w.Body).Decode(resp)
+			require.NoError(t, err)
+
+			clients := make([]string, 0, len(resp.Entries))
+			for _, e := range resp.Entries {
+				assert.Equal(t, fqdn, e.Name)
+				assert.Equal(t, "A", e.QType)
+				assert.Equal(t, testCacheUps, e.Upstream)
+				assert.False(t, e.Expired)
+				assert.Positive(t, e.TTL)
+
+				clients = append(clients, e.Client)
+			}
+
+			assert.Equal(t, tc.wantClients, clients)
+		})
+	}
+}
+
+func TestServer_HandleCachePurge(t *testing.T) {
+	t.Parallel()
+
+	globalFQDNs := []string{"host.example.", "sub.host.example.", "other.example."}
+	clientFQDNs := []string{"host.example.", "client.example."}
+
+	testCases := []struct {
+		req         map[string]any
+		name        string
+		wantGlobal  int
+		wantClient  int
+		wantRemoved int
+		wantCode    int
+	}{{
+		req:         map[string]any{"name": "HOST.example"},
+		name:        "name",
+		wantGlobal:  2,
+		wantClient:  1,
+		wantRemoved: 2,
+		wantCode:    http.StatusOK,
+	}, {
+		req:         map[string]any{"suffix": "host.example"},
+		name:        "suffix",
+		wantGlobal:  1,
+		wantClient:  1,
+		wantRemoved: 3,
+		wantCode:    http.StatusOK,
+	}, {
+		req:         map[string]any{"client": testCacheClientName},
+		name:        "client",
+		wantGlobal:  3,
+		wantClient:  0,
+		wantRemoved: 2,
+		wantCode:    http.StatusOK,
+	}, {
+		req: map[string]any{
+			"name":   "host.example",
+			"client": testCacheClientName,
+		},
+		name:        "client_name",
+		wantGlobal:  3,
+		wantClient:  1,
+		wantRemoved: 1,
+		wantCode:    http.StatusOK,
+	}, {
+		req:         map[string]any{"client": "unknown"},
+		name:        "unknown_client",
+		wantGlobal:  3,
+		wantClient:  2,
+		wantRemoved: 0,
+		wantCode:    http.StatusNotFound,
+	}, {
+		req:         map[string]any{"name": "a.example", "suffix": "example"},
+		name:        "name_and_suffix",
+		wantGlobal:  3,
+		wantClient:  2,
+		wantRemoved: 0,
+		wantCode:    http.StatusBadRequest,
+	}, {
+		req:         map[string]any{},
+		name:        "empty",
+		wantGlobal:  3,
+		wantClient:  2,
+		wantRemoved: 0,
+		wantCode:    http.StatusBadRequest,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			s := newTestCacheServer(t, globalFQDNs, clientFQDNs)
+
+			body, err := json.Marshal(tc.req)
+			require.NoError(t, err)
+
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodPost, "/control/cache/purge", bytes.NewReader(body))
+
+			s.handleCachePurge(w, r)
+			require.Equal(t, tc.wantCode, w.Code)
+
+			caches := s.namedCaches()
+			require.Len(t, caches, 2)
+
+			assert.Equal(t, tc.wantGlobal, caches[0].cache.stats().entries)
+			assert.Equal(t, tc.wantClient, caches[1].cache.stats().entries)
+
+			if tc.wantCode != http.StatusOK {
+				return
+			}
+
+			resp := &cachePurgeResp{}
+			err = json.NewDecoder(w.Body).Decode(resp)
+			require.NoError(t, err)
+
+			assert.Equal(t, tc.wantRemoved, resp.Removed)
+		})
+	}
+}
+
+func TestServer_HandleCacheStats(t *testing.T) {
+	t.Parallel()
+
+	const fqdn = "stats.example."
+
+	s := newTestCacheServer(t, []string{fqdn}, nil)
+
+	now := time.Now()
+	_, _ = s.cache.get(newCacheKey(createTestMessage(fqdn), netip.Prefix{}), now)
+	_, _ = s.cache.get(newCacheKey(createTestMessage("missing.example."), netip.Prefix{}), now)
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/control/cache/stats", nil)
+
+	s.handleCacheStats(w, r)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	resp := &cacheStatsResp{}
+	err := json.NewDecoder(w.Body).Decode(resp)
+	require.NoError(t, err)
+	require.Len(t, resp.Caches, 2)
+
+	global := resp.Caches[0]
+	assert.Empty(t, global.Client)
+	assert.Equal(t, 1, global.Entries)
+	assert.Positive(t, global.Size)
+	assert.Equal(t, testCacheSize, global.MaxSize)
+	assert.Equal(t, uint64(1), global.Hits)
+	assert.Equal(t, uint64(1), global.Misses)
+	assert.InDelta(t, 0.5, global.HitRatio, 0.001)
+
+	clientStats := resp.Caches[1]
+	assert.Equal(t, testCacheClientName, clientStats.Client)
+	assert.Zero(t, clientStats.Entries)
+	assert.Zero(t, clientStats.HitRatio)
+}
@@ -0,0 +1,87 @@
+package dnsforward
+
+import (
+	"cmp"
+	"net/netip"
+	"strings"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/client"
+	"github.com/AdguardTeam/AdGuardHome/internal/filtering"
+	"github.com/AdguardTeam/golibs/log"
+	"github.com/AdguardTeam/golibs/netutil"
+)
+
+// setClientMode sets the temporary mode of the persistent client sending the
+// request from dctx, if any, and adjusts the filtering settings accordingly.
+// dctx.setts must be set.
+func (s *Server) setClientMode(dctx *dnsContext) {
+	if s.conf.ClientInfoProvider == nil {
+		return
+	}
+
+	id := cmp.Or(dctx.clientID, dctx.proxyCtx.Addr.Addr().String())
+	info := s.conf.ClientInfoProvider.ClientInfoByID(id)
+	if info == nil {
+		return
+	}
+
+	dctx.clientInfo = info
+	dctx.clientMode = info.Mode
+	switch info.Mode {
+	case client.ModePaused:
+		log.Debug("dnsforward: protection is paused for client %q", info.Name)
+
+		dctx.protectionEnabled = false
+		dctx.setts.ProtectionEnabled = false
+	case client.ModeAllowlistOnly:
+		dctx.allowedDomains = info.AllowedDomains
+	default:
+		// Go on.
+	}
+}
+
+// filterAllowlistOnly blocks the request from dctx, unless its host is one of
+// the allowed domains of the client, a subdomain of one, or is allowlisted by
+// the filtering rules.  The requests for locally served ARPA domains are never
+// blocked.  It returns true if the request has been blocked.
+func (s *Server) filterAllowlistOnly(dctx *dnsContext) (blocked bool) {
+	pctx := dctx.proxyCtx
+	if pctx.RequestedPrivateRDNS != (netip.Prefix{}) {
+		return false
+	}
+
+	q := pctx.Req.Question[0]
+	host := strings.ToLower(strings.TrimSuffix(q.Name, "."))
+	if isAllowedDomain(dctx.allowedDomains, host) {
+		return false
+	}
+
+	res, err := s.dnsFilter.CheckHostRules(host, q.Qtype, dctx.setts)
+	if err != nil {
+		log.Debug("dnsforward: checking allowlist for host %q: %s", host, err)
+	} else if res.Reason == filtering.NotFilteredAllowList {
+		return false
+	}
+
+	log.Debug("dnsforward: host %q is not allowlisted for client", host)
+
+	dctx.result = &filtering.Result{
+		IsFiltered: true,
+		Reason:     filtering.FilteredAllowlistOnly,
+	}
+	pctx.Res = s.genDNSFilterMessage(pctx, dctx.result)
+
+	return true
+}
+
+// isAllowedDomain returns true if host is one of domains or a subdomain of one.
+// host and domains must be lowercased.
+func isAllowedDomain(domains []string, host string) (ok bool) {
+	for _, d := range domains {
+		if host == d || netutil.IsSubdomain(host, d) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,141 @@
+package dnsforward
+
+import (
+	"net"
+	"testing"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/client"
+	"github.com/AdguardTeam/AdGuardHome/internal/filtering"
+	"github.com/AdguardTeam/dnsproxy/proxy"
+	"github.com/AdguardTeam/golibs/testutil"
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestServer_ClientMode(t *testing.T) {
+	t.Parallel()
+
+	const (
+		allowedFQDN   = "allowed.example."
+		subFQDN       = "sub.allowed.example."
+		allowlistFQDN = "whitelist.example.org."
+		blockedFQDN   = "nxdomain.example.org."
+		otherFQDN     = "other.example."
+	)
+
+	testCases := []struct {
+		name       string
+		fqdn       string
+		wantReason filtering.Reason
+		mode       client.Mode
+		wantResp   bool
+	}{{
+		name:       "normal_other",
+		fqdn:       otherFQDN,
+		wantReason: filtering.NotFilteredNotFound,
+		mode:       client.ModeNormal,
+		wantResp:   false,
+	}, {
+		name:       "normal_blocked",
+		fqdn:       blockedFQDN,
+		wantReason: filtering.FilteredBlockList,
+		mode:       client.ModeNormal,
+		wantResp:   true,
+	}, {
+		name:       "paused_blocked",
+		fqdn:       blockedFQDN,
+		wantReason: filtering.NotFilteredNotFound,
+		mode:       client.ModePaused,
+		wantResp:   false,
+	}, {
+		name:       "allowlist_only_other",
+		fqdn:       otherFQDN,
+		wantReason: filtering.FilteredAllowlistOnly,
+		mode:       client.ModeAllowlistOnly,
+		wantResp:   true,
+	}, {
+		name:       "allowlist_only_allowed",
+		fqdn:       allowedFQDN,
+		wantReason: filtering.NotFilteredNotFound,
+		mode:       client.ModeAllowlistOnly,
+		wantResp:   false,
+	}, {
+		name:       "allowlist_only_subdomain",
+		fqdn:       subFQDN,
+		wantReason: filtering.NotFilteredNotFound,
+		mode:       client.ModeAllowlistOnly,
+		wantResp:   false,
+	}, {
+		name:       "allowlist_only_rule",
+		fqdn:       allowlistFQDN,
+		wantReason: filtering.NotFilteredAllowList,
+		mode:       client.ModeAllowlistOnly,
+		wantResp:   false,
+	}, {
+		name:       "allowlist_only_blocked",
+		fqdn:       blockedFQDN,
+		wantReason: filtering.FilteredAllowlistOnly,
+		mode:       client.ModeAllowlistOnly,
+		wantResp:   true,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			s := createTestServer(t, &filtering.Config{
+				BlockingMode:      filtering.BlockingModeDefault,
+				ProtectionEnabled: true,
+				FilteringEnabled:  true,
+			}, ServerConfig{
+				UDPListenAddrs: []*net.UDPAddr{{}},
+				TCPListenAddrs: []*net.TCPAddr{{}},
+				Config: Config{
+					UpstreamMode: UpstreamModeLoadBalance,
+					EDNSClientSubnet: &EDNSClientSubnet{
+						Enabled: false,
+					},
+					ClientInfoProvider: &fakeClientsContainer{
+						OnClientInfoByID: func(_ string) (info *client.Info) {
+							return &client.Info{
+								Name:           "client",
+								AllowedDomains: []string{"allowed.example"},
+								Mode:           tc.mode,
+							}
+						},
+					},
+				},
+				ServePlainDNS: true,
+			})
+
+			dctx := &dnsContext{
+				proxyCtx: &proxy.DNSContext{
+					Req:   createTestMessage(tc.fqdn),
+					Addr:  testClientAddrPort,
+					Proto: proxy.ProtoUDP,
+				},
+			}
+
+			require.Equal(t, resultCodeSuccess, s.processInitial(dctx))
+			require.Equal(t, resultCodeSuccess, s.processFilteringBeforeRequest(dctx))
+			require.NotNil(t, dctx.result)
+
+			assert.Equal(t, tc.wantReason, dctx.result.Reason)
+			assert.Equal(t, tc.mode, dctx.clientMode)
+
+			res := dctx.proxyCtx.Res
+			if !tc.wantResp {
+				assert.Nil(t, res)
+
+				return
+			}
+
+			require.NotNil(t, res)
+			require.Len(t, res.Answer, 1)
+
+			a := testutil.RequireTypeAssert[*dns.A](t, res.Answer[0])
+			assert.True(t, a.A.IsUnspecified())
+		})
+	}
+}
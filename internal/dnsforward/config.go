@@ -42,6 +42,14 @@ type ClientsContainer interface {
 	) (conf *proxy.CustomUpstreamConfig, err error)
 }
 
+// ClientInfoProvider provides the information about persistent clients.
+type ClientInfoProvider interface {
+	// ClientInfoByID returns the information about the persistent client
+	// having id, or nil if there is no such client.  The id is expected to be
+	// either a string representation of an IP address or the ClientID.
+	ClientInfoByID(id string) (info *client.Info)
+}
+
 // Config represents the DNS filtering configuration of AdGuard Home.  The zero
 // Config is empty and ready for use.
 type Config struct {
@@ -54,6 +62,10 @@ type Config struct {
 	// DNS clients.
 	ClientsContainer ClientsContainer `yaml:"-"`
 
+	// ClientInfoProvider provides the information about the persistent
+	// clients, such as the settings of their own DNS caches.  It may be nil.
+	ClientInfoProvider ClientInfoProvider `yaml:"-"`
+
 	// Anti-DNS amplification
 
 	// Ratelimit is the maximum number of requests per second from a given IP
@@ -71,6 +83,11 @@ type Config struct {
 	// RatelimitWhitelist is the list of whitelisted client IP addresses.
 	RatelimitWhitelist []netip.Addr `yaml:"ratelimit_whitelist"`
 
+	// RatelimitProfiles are the rate limit policies for the persistent
+	// clients, their tags, and subnets.  They take precedence over Ratelimit,
+	// which is only applied to plain UDP requests.
+	RatelimitProfiles []*RatelimitProfile `yaml:"ratelimit_profiles"`
+
 	// RefuseAny, if true, refuse ANY requests.
 	RefuseAny bool `yaml:"refuse_any"`
 
@@ -133,6 +150,52 @@ type Config struct {
 	// CacheOptimistic defines if optimistic cache mechanism should be used.
 	CacheOptimistic bool `yaml:"cache_optimistic"`
 
+	// CacheOptimisticAnswerTTL is the TTL of the responses served from the
+	// expired cache items.  If zero, [defaultStaleAnswerTTL] is used.
+	CacheOptimisticAnswerTTL timeutil.Duration `yaml:"cache_optimistic_answer_ttl"`
+
+	// CacheOptimisticMaxAge is the maximum time after the expiration during
+	// which the expired cache items are still served.  Zero means no limit.
+	CacheOptimisticMaxAge timeutil.Duration `yaml:"cache_optimistic_max_age"`
+
+	// CacheOptimisticClientTimeout is the time to wait for the upstream to
+	// refresh an expired cache item before serving it to the client.  If zero,
+	// the expired item is served immediately.
+	CacheOptimisticClientTimeout timeutil.Duration `yaml:"cache_optimistic_client_timeout"`
+
+	// CacheOptimisticExclusions are the rules matching the domain names which
+	// expired cache items are never served.
+	CacheOptimisticExclusions []string `yaml:"cache_optimistic_exclusions"`
+
+	// CachePrefetch defines if the popular cache items should be refreshed
+	// before they expire.
+	CachePrefetch bool `yaml:"cache_prefetch"`
+
+	// CachePrefetchMinHits is the number of requests for a cache item within
+	// its TTL after which it's considered popular.  If zero,
+	// [defaultPrefetchMinHits] is used.
+	CachePrefetchMinHits uint32 `yaml:"cache_prefetch_min_hits"`
+
+	// CachePrefetchThreshold is the remaining part of the TTL of a popular
+	// cache item, in percent, at which it's refreshed.  If zero,
+	// [defaultPrefetchThreshold] is used.
+	CachePrefetchThreshold uint32 `yaml:"cache_prefetch_threshold"`
+
+	// CachePrefetchMaxConcurrent is the maximum number of the cache items
+	// refreshed or warmed up at the same time.  If zero,
+	// [defaultPrefetchMaxConcurrent] is used.
+	CachePrefetchMaxConcurrent uint32 `yaml:"cache_prefetch_max_concurrent"`
+
+	// CachePrefetchExclusions are the rules matching the domain names which
+	// cache items are never prefetched or warmed up.
+	CachePrefetchExclusions []string `yaml:"cache_prefetch_exclusions"`
+
+	// CacheWarmupDomains is the number of the most requested domain names
+	// from the statistics to resolve into the cache on the first start.  Zero
+	// disables the warm-up.  The warm-up is also skipped if EDNS Client Subnet
+	// is enabled.
+	CacheWarmupDomains uint32 `yaml:"cache_warmup_domains"`
+
 	// Other settings
 
 	// BogusNXDomain is the list of IP addresses, responses with them will be
@@ -149,6 +212,20 @@ type Config struct {
 	// EDNSClientSubnet is the settings list for EDNS Client Subnet.
 	EDNSClientSubnet *EDNSClientSubnet `yaml:"edns_client_subnet"`
 
+	// EDEEnabled defines if Extended DNS Errors (RFC 8914) should be added to
+	// the blocked, refused, stale, and failed responses.
+	EDEEnabled bool `yaml:"ede_enabled"`
+
+	// EDEBlockingModes are the blocking modes for which the blocked responses
+	// carry Extended DNS Errors.  If empty, all blocking modes are used.  It
+	// has no effect unless [Config.EDEEnabled] is true.
+	EDEBlockingModes []filtering.BlockingMode `yaml:"ede_blocking_modes"`
+
+	// SynthesizePTR defines if the responses to PTR requests should be
+	// synthesized from the rewrites, the $dnsrewrite rules of the custom
+	// filtering rules, and the static DHCP leases.
+	SynthesizePTR bool `yaml:"synthesize_ptr"`
+
 	// MaxGoroutines is the max number of parallel goroutines for processing
 	// incoming requests.
 	MaxGoroutines uint `yaml:"max_goroutines"`
@@ -282,6 +359,14 @@ type ServerConfig struct {
 	// DNS64Prefixes is a slice of NAT64 prefixes to be used for DNS64.
 	DNS64Prefixes []netip.Prefix
 
+	// DNS64Exclusions are the IPv4 ranges, the addresses within which are never
+	// synthesized into the AAAA records by DNS64.
+	DNS64Exclusions []netip.Prefix
+
+	// DNS64Profiles are the DNS64 policies for the persistent clients, their
+	// tags, and the listener subnets.
+	DNS64Profiles []*DNS64Profile
+
 	// UsePrivateRDNS defines if the PTR requests for unknown addresses from
 	// locally-served networks should be resolved via private PTR resolvers.
 	UsePrivateRDNS bool
@@ -289,6 +374,11 @@ type ServerConfig struct {
 	// UseDNS64 defines if DNS64 is enabled for incoming requests.
 	UseDNS64 bool
 
+	// DNS64Discovery defines if the NAT64 prefix should be discovered from the
+	// upstream servers using the ipv4only.arpa name, see RFC 7050.  It's only
+	// used when DNS64Prefixes is empty.
+	DNS64Discovery bool
+
 	// ServeHTTP3 defines if HTTP/3 is be allowed for incoming requests.
 	ServeHTTP3 bool
 
@@ -320,10 +410,6 @@ func (s *Server) newProxyConfig() (conf *proxy.Config, err error) {
 	conf = &proxy.Config{
 		Logger:                    s.baseLogger.With(slogutil.KeyPrefix, "dnsproxy"),
 		HTTP3:                     srvConf.ServeHTTP3,
-		Ratelimit:                 int(srvConf.Ratelimit),
-		RatelimitSubnetLenIPv4:    srvConf.RatelimitSubnetLenIPv4,
-		RatelimitSubnetLenIPv6:    srvConf.RatelimitSubnetLenIPv6,
-		RatelimitWhitelist:        srvConf.RatelimitWhitelist,
 		RefuseAny:                 srvConf.RefuseAny,
 		TrustedProxies:            netutil.SliceSubnetSet(trustedPrefixes),
 		CacheMinTTL:               srvConf.CacheMinTTL,
@@ -336,8 +422,6 @@ func (s *Server) newProxyConfig() (conf *proxy.Config, err error) {
 		HTTPSServerName:           aghhttp.UserAgent(),
 		EnableEDNSClientSubnet:    srvConf.EDNSClientSubnet.Enabled,
 		MaxGoroutines:             srvConf.MaxGoroutines,
-		UseDNS64:                  srvConf.UseDNS64,
-		DNS64Prefs:                srvConf.DNS64Prefixes,
 		UsePrivateRDNS:            srvConf.UsePrivateRDNS,
 		PrivateSubnets:            s.privateNets,
 		MessageConstructor:        s,
@@ -375,11 +459,7 @@ func (s *Server) newProxyConfig() (conf *proxy.Config, err error) {
 		conf.DNSCryptResolverCert = c.ResolverCert
 	}
 
-	conf, err = prepareCacheConfig(conf,
-		srvConf.CacheSize,
-		srvConf.CacheMinTTL,
-		srvConf.CacheMaxTTL,
-	)
+	err = s.prepareCacheConfig(conf)
 	if err != nil {
 		// Don't wrap the error since it's informative enough as is.
 		return nil, err
@@ -388,27 +468,6 @@ func (s *Server) newProxyConfig() (conf *proxy.Config, err error) {
 	return conf, nil
 }
 
-// prepareCacheConfig prepares the cache configuration and returns an error if
-// there is one.
-func prepareCacheConfig(
-	conf *proxy.Config,
-	size uint32,
-	minTTL uint32,
-	maxTTL uint32,
-) (prepared *proxy.Config, err error) {
-	if size != 0 {
-		conf.CacheEnabled = true
-		conf.CacheSizeBytes = int(size)
-	}
-
-	err = validateCacheTTL(minTTL, maxTTL)
-	if err != nil {
-		return nil, fmt.Errorf("validating cache ttl: %w", err)
-	}
-
-	return conf, nil
-}
-
 // parseBogusNXDOMAIN parses the bogus NXDOMAIN strings into valid subnets.
 func parseBogusNXDOMAIN(confBogusNXDOMAIN []string) (subnets []netip.Prefix, err error) {
 	for i, s := range confBogusNXDOMAIN {
@@ -1,47 +1,750 @@
 package dnsforward
 
 import (
+	"cmp"
+	"fmt"
 	"net"
+	"net/http"
 	"net/netip"
+	"slices"
+	"sync"
+	"time"
 
+	"github.com/AdguardTeam/AdGuardHome/internal/client"
 	"github.com/AdguardTeam/dnsproxy/proxy"
+	"github.com/AdguardTeam/dnsproxy/upstream"
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/AdguardTeam/golibs/log"
+	"github.com/AdguardTeam/golibs/netutil"
+	"github.com/miekg/dns"
 )
 
-// setupDNS64 initializes DNS64 settings, the NAT64 prefixes in particular.  If
-// the DNS64 feature is enabled and no prefixes are configured, the default
-// Well-Known Prefix is used, just like Section 5.2 of RFC 6147 prescribes.  Any
-// configured set of prefixes discards the default Well-Known prefix unless it
-// is specified explicitly.  Each prefix also validated to be a valid IPv6
-// CIDR with a maximum length of 96 bits.  The first specified prefix is then
-// used to synthesize AAAA records.
-func (s *Server) setupDNS64() {
-	if !s.conf.UseDNS64 {
+const (
+	// maxNAT64PrefixBitLen is the maximum length of a NAT64 prefix in bits.
+	//
+	// See https://datatracker.ietf.org/doc/html/rfc6147#section-5.2.
+	maxNAT64PrefixBitLen = 96
+
+	// maxDNS64SynTTL is the maximum TTL for synthesized DNS64 responses with no
+	// SOA records in seconds.
+	//
+	// See https://datatracker.ietf.org/doc/html/rfc6147#section-5.1.7.
+	maxDNS64SynTTL uint32 = 600
+)
+
+// dns64WellKnownPref is the default prefix to use in an algorithmic mapping for
+// DNS64.
+//
+// See https://datatracker.ietf.org/doc/html/rfc6052#section-2.1.
+var dns64WellKnownPref = netip.MustParsePrefix("64:ff9b::/96")
+
+// DNS64Profile is a DNS64 policy for a group of clients.
+type DNS64Profile struct {
+	// Name is the unique name of the profile.
+	Name string `yaml:"name"`
+
+	// Prefix is the NAT64 prefix used to synthesize the AAAA records for the
+	// requests the profile applies to.  If not set, the general prefix is
+	// used.
+	Prefix netip.Prefix `yaml:"prefix"`
+
+	// Clients are the names of the persistent clients the profile applies to.
+	Clients []string `yaml:"clients"`
+
+	// Tags are the tags of the persistent clients the profile applies to.
+	Tags []string `yaml:"tags"`
+
+	// ListenSubnets are the subnets of the local addresses the profile applies
+	// to, i.e. the requests received on such addresses use the profile.  For
+	// plain DNS-over-UDP listeners bound to an unspecified address the local
+	// address is unknown, so only the clients and tags are considered.
+	ListenSubnets []netip.Prefix `yaml:"listen_subnets"`
+
+	// Disabled, if true, turns DNS64 off for the requests the profile applies
+	// to.
+	Disabled bool `yaml:"disabled"`
+}
+
+// validate returns an error if p is invalid.
+func (p *DNS64Profile) validate() (err error) {
+	switch {
+	case p == nil:
+		return errors.ErrNoValue
+	case p.Name == "":
+		return fmt.Errorf("name: %w", errors.ErrEmptyValue)
+	case p.Disabled && p.Prefix.IsValid():
+		return errors.Error("prefix: must not be set for disabled profile")
+	}
+
+	if p.Prefix.IsValid() {
+		err = validateNAT64Prefix(p.Prefix)
+		if err != nil {
+			return fmt.Errorf("prefix: %w", err)
+		}
+	}
+
+	for i, subnet := range p.ListenSubnets {
+		if !subnet.IsValid() {
+			return fmt.Errorf("listen_subnets: at index %d: bad subnet", i)
+		}
+	}
+
+	return nil
+}
+
+// validateNAT64Prefix returns an error if pref can't be used for DNS64.
+func validateNAT64Prefix(pref netip.Prefix) (err error) {
+	switch {
+	case !pref.Addr().Is6():
+		return fmt.Errorf("%s is not an IPv6 prefix", pref)
+	case pref.Bits() > maxNAT64PrefixBitLen:
+		return fmt.Errorf("%s is too long for DNS64", pref)
+	default:
+		return nil
+	}
+}
+
+// validateDNS64 returns an error if the DNS64 settings of conf are invalid.
+func validateDNS64(conf *ServerConfig) (err error) {
+	for i, pref := range conf.DNS64Prefixes {
+		if err = validateNAT64Prefix(pref); err != nil {
+			return fmt.Errorf("dns64 prefixes: at index %d: %w", i, err)
+		}
+	}
+
+	for i, excl := range conf.DNS64Exclusions {
+		if !excl.IsValid() || !excl.Addr().Is4() {
+			return fmt.Errorf("dns64 exclusions: at index %d: %s is not an IPv4 prefix", i, excl)
+		}
+	}
+
+	names := map[string]struct{}{}
+	for i, p := range conf.DNS64Profiles {
+		err = p.validate()
+		if err != nil {
+			return fmt.Errorf("dns64 profiles: at index %d: %w", i, err)
+		}
+
+		if _, ok := names[p.Name]; ok {
+			return fmt.Errorf("dns64 profiles: at index %d: duplicate name %q", i, p.Name)
+		}
+
+		names[p.Name] = struct{}{}
+	}
+
+	return nil
+}
+
+// dns64Profile is a DNS64 profile in use.
+type dns64Profile struct {
+	// pref is the NAT64 prefix of the profile.  If not valid, the general
+	// prefix is used.
+	pref netip.Prefix
+
+	// disabled shows if DNS64 is disabled for the profile.
+	disabled bool
+}
+
+// taggedDNS64Profile is a DNS64 profile applied to the persistent clients
+// having any of its tags.
+type taggedDNS64Profile struct {
+	// profile is the profile itself.
+	profile *dns64Profile
+
+	// tags are the tags of the clients the profile applies to.
+	tags []string
+}
+
+// subnetDNS64Profile is a DNS64 profile applied to a subnet of local
+// addresses.
+type subnetDNS64Profile struct {
+	// profile is the profile itself.
+	profile *dns64Profile
+
+	// subnet is the subnet the profile applies to.
+	subnet netip.Prefix
+}
+
+// dns64Config is the DNS64 configuration in use.
+type dns64Config struct {
+	// discovery discovers the NAT64 prefix of the network.  It's nil if the
+	// discovery is disabled.
+	discovery *dns64Discovery
+
+	// byClient are the profiles by the names of the persistent clients.
+	byClient map[string]*dns64Profile
+
+	// tagged are the profiles with tags in the configuration order along with
+	// their tags.
+	tagged []*taggedDNS64Profile
+
+	// subnets are the profiles applied to the listener subnets, from the
+	// longest to the shortest one.
+	subnets []*subnetDNS64Profile
+
+	// prefixes are all the configured NAT64 prefixes along with the
+	// Well-Known Prefix.  The AAAA records within them are considered
+	// synthesized.
+	prefixes netutil.SliceSubnetSet
+
+	// exclusions are the IPv4 ranges that are never synthesized.
+	exclusions []netip.Prefix
+
+	// pref is the general NAT64 prefix.
+	pref netip.Prefix
+}
+
+// newDNS64Config returns a new *dns64Config built from conf.  conf is expected
+// to be valid.
+func newDNS64Config(conf *ServerConfig) (c *dns64Config) {
+	c = &dns64Config{
+		byClient:   map[string]*dns64Profile{},
+		prefixes:   netutil.SliceSubnetSet{dns64WellKnownPref},
+		exclusions: slices.Clone(conf.DNS64Exclusions),
+		pref:       dns64WellKnownPref,
+	}
+
+	if len(conf.DNS64Prefixes) > 0 {
+		c.pref = conf.DNS64Prefixes[0].Masked()
+	}
+
+	for _, pref := range conf.DNS64Prefixes {
+		c.prefixes = append(c.prefixes, pref.Masked())
+	}
+
+	for _, pc := range conf.DNS64Profiles {
+		p := &dns64Profile{
+			pref:     pc.Prefix.Masked(),
+			disabled: pc.Disabled,
+		}
+
+		if p.pref.IsValid() {
+			c.prefixes = append(c.prefixes, p.pref)
+		}
+
+		for _, name := range pc.Clients {
+			if _, ok := c.byClient[name]; !ok {
+				c.byClient[name] = p
+			}
+		}
+
+		if len(pc.Tags) > 0 {
+			c.tagged = append(c.tagged, &taggedDNS64Profile{
+				profile: p,
+				tags:    pc.Tags,
+			})
+		}
+
+		for _, subnet := range pc.ListenSubnets {
+			c.subnets = append(c.subnets, &subnetDNS64Profile{
+				profile: p,
+				subnet:  subnet.Masked(),
+			})
+		}
+	}
+
+	slices.SortStableFunc(c.subnets, func(a, b *subnetDNS64Profile) (res int) {
+		return cmp.Compare(b.subnet.Bits(), a.subnet.Bits())
+	})
+
+	if conf.DNS64Discovery && len(conf.DNS64Prefixes) == 0 {
+		c.discovery = &dns64Discovery{
+			mu:      &sync.Mutex{},
+			upsConf: conf.UpstreamConfig,
+		}
+	}
+
+	return c
+}
+
+// profileFor returns the profile for the persistent client described by info
+// sending the request to the local address, if any.
+func (c *dns64Config) profileFor(info *client.Info, local netip.Addr) (p *dns64Profile) {
+	if info != nil {
+		if p = c.byClient[info.Name]; p != nil {
+			return p
+		}
+
+		for _, tp := range c.tagged {
+			for _, tag := range info.Tags {
+				if slices.Contains(tp.tags, tag) {
+					return tp.profile
+				}
+			}
+		}
+	}
+
+	if !local.IsValid() || local.IsUnspecified() {
+		return nil
+	}
+
+	local = local.Unmap()
+	for _, sp := range c.subnets {
+		if sp.subnet.Contains(local) {
+			return sp.profile
+		}
+	}
+
+	return nil
+}
+
+// prefixFor returns the NAT64 prefix for the persistent client described by
+// info sending the request to the local address.  ok is false if DNS64
+// shouldn't be performed for the request.
+func (c *dns64Config) prefixFor(
+	info *client.Info,
+	local netip.Addr,
+	now time.Time,
+) (pref netip.Prefix, ok bool) {
+	if p := c.profileFor(info, local); p != nil {
+		if p.disabled {
+			return netip.Prefix{}, false
+		} else if p.pref.IsValid() {
+			return p.pref, true
+		}
+	}
+
+	return c.generalPrefix(now), true
+}
+
+// generalPrefix returns the discovered NAT64 prefix, if any, or the configured
+// one.
+func (c *dns64Config) generalPrefix(now time.Time) (pref netip.Prefix) {
+	if c.discovery != nil {
+		if pref = c.discovery.prefix(now); pref.IsValid() {
+			return pref
+		}
+	}
+
+	return c.pref
+}
+
+// isNAT64 returns true if addr is within any of the known NAT64 prefixes.
+func (c *dns64Config) isNAT64(addr netip.Addr, now time.Time) (ok bool) {
+	if c.prefixes.Contains(addr) {
+		return true
+	}
+
+	return c.discovery != nil && c.discovery.prefix(now).Contains(addr)
+}
+
+// isExcluded returns true if addr mustn't be synthesized.
+func (c *dns64Config) isExcluded(addr netip.Addr) (ok bool) {
+	return slices.ContainsFunc(c.exclusions, func(p netip.Prefix) (ok bool) {
+		return p.Contains(addr)
+	})
+}
+
+// dns64Discovery discovers the NAT64 prefix used by the upstream servers by
+// querying the well-known name ipv4only.arpa.  The discovery is performed in
+// background, so the general prefix is used until it's finished.  Only the
+// 96-bit prefixes are detected.
+//
+// See https://datatracker.ietf.org/doc/html/rfc7050.
+type dns64Discovery struct {
+	// mu protects all the fields below.
+	mu *sync.Mutex
+
+	// upsConf is the upstream configuration to query.
+	upsConf *proxy.UpstreamConfig
+
+	// refreshAt is the time after which the prefix should be discovered
+	// again.
+	refreshAt time.Time
+
+	// pref is the discovered prefix.  It's not valid if the discovery hasn't
+	// been finished yet or no prefix has been found.
+	pref netip.Prefix
+
+	// refreshing is true while the discovery is in progress.
+	refreshing bool
+}
+
+const (
+	// dns64DiscoveryHost is the well-known name used to discover the NAT64
+	// prefix.
+	//
+	// See https://datatracker.ietf.org/doc/html/rfc7050#section-2.
+	dns64DiscoveryHost = "ipv4only.arpa."
+
+	// dns64DiscoveryMinIvl is the minimum interval between the discoveries.
+	dns64DiscoveryMinIvl = 1 * time.Minute
+
+	// dns64DiscoveryMaxIvl is the maximum interval between the discoveries.
+	dns64DiscoveryMaxIvl = 24 * time.Hour
+
+	// dns64DiscoveryNotFoundIvl is the interval after which the discovery is
+	// repeated if no prefix has been found.
+	dns64DiscoveryNotFoundIvl = 1 * time.Hour
+)
+
+// dns64WellKnownIPv4 are the well-known IPv4 addresses of ipv4only.arpa.
+//
+// See https://datatracker.ietf.org/doc/html/rfc7050#section-2.2.
+var dns64WellKnownIPv4 = [...]netip.Addr{
+	netip.AddrFrom4([4]byte{192, 0, 0, 170}),
+	netip.AddrFrom4([4]byte{192, 0, 0, 171}),
+}
+
+// prefix returns the discovered prefix and starts a new discovery if it's
+// outdated.
+func (d *dns64Discovery) prefix(now time.Time) (pref netip.Prefix) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if !d.refreshing && !now.Before(d.refreshAt) {
+		d.refreshing = true
+
+		go d.refresh()
+	}
+
+	return d.pref
+}
+
+// refresh discovers the prefix and stores the result.  It's intended to be
+// used as a goroutine.
+func (d *dns64Discovery) refresh() {
+	defer log.OnPanic("dnsforward: discovering nat64 prefix")
+
+	pref, ttl, err := d.discover()
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.refreshing = false
+
+	now := time.Now()
+	if err != nil {
+		log.Debug("dnsforward: discovering nat64 prefix: %s", err)
+		d.refreshAt = now.Add(dns64DiscoveryMinIvl)
+
+		return
+	}
+
+	d.pref = pref
+	if !pref.IsValid() {
+		log.Debug("dnsforward: no nat64 prefix discovered")
+		d.refreshAt = now.Add(dns64DiscoveryNotFoundIvl)
+
 		return
 	}
 
-	if len(s.conf.DNS64Prefixes) == 0 {
-		// dns64WellKnownPref is the default prefix to use in an algorithmic
-		// mapping for DNS64.
-		//
-		// See https://datatracker.ietf.org/doc/html/rfc6052#section-2.1.
-		dns64WellKnownPref := netip.MustParsePrefix("64:ff9b::/96")
+	log.Debug("dnsforward: discovered nat64 prefix %s", pref)
+	d.refreshAt = now.Add(min(max(ttl, dns64DiscoveryMinIvl), dns64DiscoveryMaxIvl))
+}
+
+// discover queries the upstreams for the AAAA records of ipv4only.arpa and
+// returns the NAT64 prefix found in the response along with its TTL.  pref is
+// not valid if there is none.
+func (d *dns64Discovery) discover() (pref netip.Prefix, ttl time.Duration, err error) {
+	if d.upsConf == nil || len(d.upsConf.Upstreams) == 0 {
+		return netip.Prefix{}, 0, upstream.ErrNoUpstreams
+	}
+
+	req := (&dns.Msg{}).SetQuestion(dns64DiscoveryHost, dns.TypeAAAA)
+	resp, _, err := upstream.ExchangeParallel(d.upsConf.Upstreams, req)
+	if err != nil {
+		// Don't wrap the error since it's informative enough as is.
+		return netip.Prefix{}, 0, err
+	}
+
+	pref, ttl = nat64PrefixFromResponse(resp)
+
+	return pref, ttl, nil
+}
+
+// nat64PrefixFromResponse returns the 96-bit NAT64 prefix from the first AAAA
+// record in resp embedding any of the well-known IPv4 addresses of
+// ipv4only.arpa, as well as the TTL of such record.
+func nat64PrefixFromResponse(resp *dns.Msg) (pref netip.Prefix, ttl time.Duration) {
+	if resp == nil || resp.Rcode != dns.RcodeSuccess {
+		return netip.Prefix{}, 0
+	}
+
+	for _, rr := range resp.Answer {
+		aaaa, ok := rr.(*dns.AAAA)
+		if !ok {
+			continue
+		}
+
+		addr, err := netutil.IPToAddrNoMapped(aaaa.AAAA)
+		if err != nil || !addr.Is6() {
+			continue
+		}
+
+		data := addr.As16()
+		embedded := netip.AddrFrom4([4]byte(data[proxy.NAT64PrefixLength:]))
+		if !slices.Contains(dns64WellKnownIPv4[:], embedded) {
+			continue
+		}
+
+		pref = netip.PrefixFrom(addr, maxNAT64PrefixBitLen).Masked()
+
+		return pref, time.Duration(aaaa.Hdr.Ttl) * time.Second
+	}
+
+	return netip.Prefix{}, 0
+}
+
+// setupDNS64 validates and initializes DNS64 settings, the NAT64 prefixes in
+// particular.  If the DNS64 feature is enabled and no prefixes are configured,
+// the default Well-Known Prefix is used, just like Section 5.2 of RFC 6147
+// prescribes, unless the prefix is discovered.  Any configured set of prefixes
+// discards the default Well-Known prefix unless it is specified explicitly.
+// The first specified prefix is then used to synthesize AAAA records for the
+// clients without a DNS64 profile.
+func (s *Server) setupDNS64() (err error) {
+	s.dns64 = nil
+	if !s.conf.UseDNS64 {
+		return nil
+	}
+
+	err = validateDNS64(&s.conf)
+	if err != nil {
+		// Don't wrap the error since it's informative enough as is.
+		return err
+	}
+
+	s.dns64 = newDNS64Config(&s.conf)
+
+	return nil
+}
+
+// dns64PrefFor returns the NAT64 prefix to use for the request from dctx.  ok
+// is false if DNS64 is disabled for the request.
+func (s *Server) dns64PrefFor(dctx *dnsContext) (pref netip.Prefix, ok bool) {
+	if s.dns64 == nil {
+		return netip.Prefix{}, false
+	}
+
+	return s.dns64.prefixFor(dctx.clientInfo, localAddr(dctx.proxyCtx), time.Now())
+}
 
-		s.dns64Pref = dns64WellKnownPref
-	} else {
-		s.dns64Pref = s.conf.DNS64Prefixes[0]
+// localAddr returns the local address the request from pctx has been received
+// on, if it's known.
+func localAddr(pctx *proxy.DNSContext) (addr netip.Addr) {
+	var netAddr net.Addr
+	switch {
+	case pctx.Conn != nil:
+		netAddr = pctx.Conn.LocalAddr()
+	case pctx.QUICConnection != nil:
+		netAddr = pctx.QUICConnection.LocalAddr()
+	case pctx.HTTPRequest != nil:
+		netAddr, _ = pctx.HTTPRequest.Context().Value(http.LocalAddrContextKey).(net.Addr)
+	default:
+		// Go on.
 	}
+
+	if netAddr == nil {
+		return netip.Addr{}
+	}
+
+	return netutil.NetAddrToAddrPort(netAddr).Addr()
 }
 
-// mapDNS64 maps ip to IPv6 address using configured DNS64 prefix.  ip must be a
-// valid IPv4.  It panics, if there are no configured DNS64 prefixes, because
-// synthesis should not be performed unless DNS64 function enabled.
-func (s *Server) mapDNS64(ip netip.Addr) (mapped net.IP) {
-	pref := s.dns64Pref.Masked().Addr().As16()
+// mapDNS64 maps ip to IPv6 address using pref.  ip must be a valid IPv4.
+func mapDNS64(pref netip.Prefix, ip netip.Addr) (mapped net.IP) {
+	prefData := pref.Masked().Addr().As16()
 	ipData := ip.As4()
 
 	mapped = make(net.IP, net.IPv6len)
-	copy(mapped[:proxy.NAT64PrefixLength], pref[:])
+	copy(mapped[:proxy.NAT64PrefixLength], prefData[:])
 	copy(mapped[proxy.NAT64PrefixLength:], ipData[:])
 
 	return mapped
 }
+
+// setDNS64PTR marks the PTR request from pctx for an address within any of the
+// NAT64 prefixes as a private one, so that it's only resolved by the private
+// upstreams.
+//
+// See https://datatracker.ietf.org/doc/html/rfc6147#section-5.3.1.
+func (s *Server) setDNS64PTR(pctx *proxy.DNSContext) {
+	if s.dns64 == nil || pctx.RequestedPrivateRDNS != (netip.Prefix{}) {
+		return
+	}
+
+	q := pctx.Req.Question[0]
+	if q.Qtype != dns.TypePTR {
+		return
+	}
+
+	addr, err := netutil.IPFromReversedAddr(q.Name)
+	if err != nil {
+		log.Debug("dnsforward: parsing ip from ptr request: %s", err)
+
+		return
+	}
+
+	if s.dns64.isNAT64(addr, time.Now()) {
+		log.Debug("dnsforward: %s is within dns64 prefixes", addr)
+
+		pctx.RequestedPrivateRDNS = netip.PrefixFrom(addr, addr.BitLen())
+	}
+}
+
+// performDNS64 synthesizes the AAAA records for the request from dctx if its
+// response contains none, using the NAT64 prefix selected for the client.  The
+// A request is resolved using prx.
+//
+// See https://datatracker.ietf.org/doc/html/rfc6147.
+func (s *Server) performDNS64(prx *proxy.Proxy, dctx *dnsContext) {
+	pctx := dctx.proxyCtx
+	req, resp := pctx.Req, pctx.Res
+	if resp == nil {
+		return
+	}
+
+	q := req.Question[0]
+	if q.Qtype != dns.TypeAAAA || q.Qclass != dns.ClassINET {
+		// DNS64 operation for classes other than IN is undefined, and a DNS64
+		// MUST behave as though no DNS64 function is configured.
+		return
+	}
+
+	pref, ok := s.dns64PrefFor(dctx)
+	if !ok {
+		return
+	}
+
+	switch resp.Rcode {
+	case dns.RcodeNameError:
+		// A result with RCODE=3 (Name Error) is handled according to normal DNS
+		// operation.
+		return
+	case dns.RcodeSuccess:
+		var hasAnswers bool
+		if resp.Answer, hasAnswers = s.filterNAT64Answers(resp.Answer); hasAnswers {
+			return
+		}
+	default:
+		// Any other RCODE is treated as though the RCODE were 0 and the answer
+		// section were empty.
+	}
+
+	aReq := req.Copy()
+	aReq.Id = dns.Id()
+	aReq.Question[0].Qtype = dns.TypeA
+
+	aCtx := &dnsContext{
+		proxyCtx: &proxy.DNSContext{
+			Proto:                pctx.Proto,
+			Req:                  aReq,
+			Addr:                 pctx.Addr,
+			CustomUpstreamConfig: pctx.CustomUpstreamConfig,
+			IsPrivateClient:      pctx.IsPrivateClient,
+		},
+		cache: dctx.cache,
+	}
+
+	err := s.resolve(prx, aCtx)
+	if err != nil {
+		log.Debug("dnsforward: dns64 request for %q: %s", q.Name, err)
+
+		return
+	}
+
+	if s.synthDNS64(pref, req, resp, aCtx.proxyCtx.Res) {
+		log.Debug("dnsforward: synthesized aaaa response for %q with %s", q.Name, pref)
+
+		dctx.dns64Synthesized = true
+		if ups := aCtx.proxyCtx.Upstream; ups != nil {
+			pctx.Upstream = ups
+		}
+	}
+}
+
+// filterNAT64Answers filters out the AAAA records within the NAT64 prefixes.
+// hasAnswers is true if the filtered slice contains at least a single AAAA
+// record outside the prefixes or a CNAME.
+func (s *Server) filterNAT64Answers(rrs []dns.RR) (filtered []dns.RR, hasAnswers bool) {
+	now := time.Now()
+
+	filtered = make([]dns.RR, 0, len(rrs))
+	for _, ans := range rrs {
+		switch ans := ans.(type) {
+		case *dns.AAAA:
+			addr, err := netutil.IPToAddrNoMapped(ans.AAAA)
+			if err != nil {
+				log.Debug("dnsforward: bad aaaa record: %s", err)
+			} else if s.dns64.isNAT64(addr, now) {
+				// Filter the record.
+				continue
+			} else {
+				filtered, hasAnswers = append(filtered, ans), true
+			}
+		case *dns.CNAME, *dns.DNAME:
+			// Just treat CNAME and DNAME responses as passable answers since
+			// AdGuard Home doesn't follow any of these chains except the
+			// dnsrewrite-defined ones.
+			filtered, hasAnswers = append(filtered, ans), true
+		default:
+			filtered = append(filtered, ans)
+		}
+	}
+
+	return filtered, hasAnswers
+}
+
+// synthDNS64 modifies origResp to contain the AAAA records synthesized from the
+// A records of aResp using pref.  The A records within the excluded ranges are
+// skipped.  It returns true if the response was actually modified.
+func (s *Server) synthDNS64(pref netip.Prefix, origReq, origResp, aResp *dns.Msg) (ok bool) {
+	if aResp == nil || aResp.Rcode != dns.RcodeSuccess {
+		return false
+	}
+
+	// The Time to Live (TTL) field is set to the minimum of the TTL of the
+	// original A RR and the SOA RR for the queried domain.
+	soaTTL := maxDNS64SynTTL
+	for _, rr := range origResp.Ns {
+		if hdr := rr.Header(); hdr.Rrtype == dns.TypeSOA && hdr.Name == origReq.Question[0].Name {
+			soaTTL = hdr.Ttl
+
+			break
+		}
+	}
+
+	newAns := make([]dns.RR, 0, len(aResp.Answer))
+	for _, rr := range aResp.Answer {
+		a, isA := rr.(*dns.A)
+		if !isA {
+			newAns = append(newAns, rr)
+
+			continue
+		}
+
+		addr, err := netutil.IPToAddr(a.A, netutil.AddrFamilyIPv4)
+		if err != nil {
+			log.Debug("dnsforward: bad a record: %s", err)
+
+			return false
+		} else if s.dns64.isExcluded(addr) {
+			continue
+		}
+
+		ok = true
+		newAns = append(newAns, &dns.AAAA{
+			Hdr: dns.RR_Header{
+				Name:   a.Hdr.Name,
+				Rrtype: dns.TypeAAAA,
+				Class:  a.Hdr.Class,
+				Ttl:    min(a.Hdr.Ttl, soaTTL),
+			},
+			AAAA: mapDNS64(pref, addr),
+		})
+	}
+
+	if !ok {
+		// If there is no A record to synthesize, then the DNS64 responds to the
+		// original querying client with the answer the DNS64 received to the
+		// original query.
+		return false
+	}
+
+	origResp.Answer = newAns
+	origResp.Ns = aResp.Ns
+	origResp.Extra = aResp.Extra
+
+	return true
+}
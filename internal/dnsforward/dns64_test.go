@@ -2,9 +2,12 @@ package dnsforward
 
 import (
 	"net"
+	"net/netip"
 	"testing"
+	"time"
 
 	"github.com/AdguardTeam/AdGuardHome/internal/aghtest"
+	"github.com/AdguardTeam/AdGuardHome/internal/client"
 	"github.com/AdguardTeam/AdGuardHome/internal/filtering"
 	"github.com/AdguardTeam/dnsproxy/proxy"
 	"github.com/AdguardTeam/golibs/netutil"
@@ -14,16 +17,6 @@ import (
 	"github.com/stretchr/testify/require"
 )
 
-// maxDNS64SynTTL is the maximum TTL for synthesized DNS64 responses with no SOA
-// records in seconds.
-//
-// If the SOA RR was not delivered with the negative response to the AAAA query,
-// then the DNS64 SHOULD use the TTL of the original A RR or 600 seconds,
-// whichever is shorter.
-//
-// See https://datatracker.ietf.org/doc/html/rfc6147#section-5.1.7.
-const maxDNS64SynTTL uint32 = 600
-
 // newRR is a helper that creates a new dns.RR with the given name, qtype, ttl
 // and value.  It fails the test if the qtype is not supported or the type of
 // value doesn't match the qtype.
@@ -361,3 +354,192 @@ func TestServer_dns64WithDisabledRDNS(t *testing.T) {
 
 	assert.Equal(t, dns.RcodeNameError, resp.Rcode)
 }
+
+func TestServer_HandleDNSRequest_dns64Profiles(t *testing.T) {
+	t.Parallel()
+
+	const domain = "ipv4.only."
+
+	excludedIPv4 := net.IP{10, 0, 0, 1}
+	someIPv4 := net.IP{1, 2, 3, 4}
+
+	upsHdlr := dns.HandlerFunc(func(w dns.ResponseWriter, req *dns.Msg) {
+		resp := (&dns.Msg{}).SetReply(req)
+		if req.Question[0].Qtype == dns.TypeA {
+			resp.Answer = []dns.RR{
+				newRR(t, domain, dns.TypeA, 3600, excludedIPv4),
+				newRR(t, domain, dns.TypeA, 3600, someIPv4),
+			}
+		}
+
+		require.NoError(testutil.PanicT{}, w.WriteMsg(resp))
+	})
+	upsAddr := aghtest.StartLocalhostUpstream(t, upsHdlr).String()
+
+	s := createTestServer(t, &filtering.Config{
+		BlockingMode: filtering.BlockingModeDefault,
+	}, ServerConfig{
+		UDPListenAddrs:  []*net.UDPAddr{{}},
+		TCPListenAddrs:  []*net.TCPAddr{{IP: net.IP{127, 0, 0, 1}}},
+		UseDNS64:        true,
+		DNS64Exclusions: []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")},
+		DNS64Profiles: []*DNS64Profile{{
+			Name:          "local",
+			Prefix:        netip.MustParsePrefix("2001:db8:64::/96"),
+			ListenSubnets: []netip.Prefix{netip.MustParsePrefix("127.0.0.0/8")},
+		}},
+		Config: Config{
+			UpstreamMode:     UpstreamModeLoadBalance,
+			EDNSClientSubnet: &EDNSClientSubnet{Enabled: false},
+			UpstreamDNS:      []string{upsAddr},
+		},
+		ServePlainDNS: true,
+	})
+	startDeferStop(t, s)
+
+	cli := &dns.Client{
+		Net:     string(proxy.ProtoTCP),
+		Timeout: testTimeout,
+	}
+
+	req := (&dns.Msg{}).SetQuestion(domain, dns.TypeAAAA)
+	resp, _, err := cli.Exchange(req, s.proxy().Addr(proxy.ProtoTCP).String())
+	require.NoError(t, err)
+	require.Len(t, resp.Answer, 1)
+
+	aaaa := testutil.RequireTypeAssert[*dns.AAAA](t, resp.Answer[0])
+	assert.Equal(t, net.ParseIP("2001:db8:64::102:304"), aaaa.AAAA)
+}
+
+func TestDNS64Config_prefixFor(t *testing.T) {
+	t.Parallel()
+
+	clientPref := netip.MustParsePrefix("2001:db8:1::/96")
+	tagPref := netip.MustParsePrefix("2001:db8:2::/96")
+	subnetPref := netip.MustParsePrefix("2001:db8:3::/96")
+	generalPref := netip.MustParsePrefix("2001:db8:4::/96")
+
+	c := newDNS64Config(&ServerConfig{
+		DNS64Prefixes: []netip.Prefix{generalPref},
+		DNS64Profiles: []*DNS64Profile{{
+			Name:    "client",
+			Prefix:  clientPref,
+			Clients: []string{"laptop"},
+		}, {
+			Name:   "tag",
+			Prefix: tagPref,
+			Tags:   []string{"device_phone"},
+		}, {
+			Name:          "subnet",
+			Prefix:        subnetPref,
+			ListenSubnets: []netip.Prefix{netip.MustParsePrefix("192.168.1.0/24")},
+		}, {
+			Name:          "disabled",
+			Clients:       []string{"tv"},
+			ListenSubnets: []netip.Prefix{netip.MustParsePrefix("192.168.1.1/32")},
+			Disabled:      true,
+		}},
+	})
+
+	subnetAddr := netip.MustParseAddr("192.168.1.2")
+
+	testCases := []struct {
+		info     *client.Info
+		local    netip.Addr
+		name     string
+		wantPref netip.Prefix
+		wantOK   bool
+	}{{
+		info:     &client.Info{Name: "laptop"},
+		local:    subnetAddr,
+		name:     "client",
+		wantPref: clientPref,
+		wantOK:   true,
+	}, {
+		info:     &client.Info{Name: "phone", Tags: []string{"device_phone"}},
+		local:    netip.Addr{},
+		name:     "tag",
+		wantPref: tagPref,
+		wantOK:   true,
+	}, {
+		info:     nil,
+		local:    subnetAddr,
+		name:     "subnet",
+		wantPref: subnetPref,
+		wantOK:   true,
+	}, {
+		info:     &client.Info{Name: "tv"},
+		local:    subnetAddr,
+		name:     "disabled_client",
+		wantPref: netip.Prefix{},
+		wantOK:   false,
+	}, {
+		info:     nil,
+		local:    netip.MustParseAddr("192.168.1.1"),
+		name:     "disabled_longest_subnet",
+		wantPref: netip.Prefix{},
+		wantOK:   false,
+	}, {
+		info:     nil,
+		local:    netip.IPv4Unspecified(),
+		name:     "general",
+		wantPref: generalPref,
+		wantOK:   true,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			pref, ok := c.prefixFor(tc.info, tc.local, time.Now())
+			assert.Equal(t, tc.wantOK, ok)
+			assert.Equal(t, tc.wantPref, pref)
+		})
+	}
+}
+
+func TestNAT64PrefixFromResponse(t *testing.T) {
+	t.Parallel()
+
+	const host = dns64DiscoveryHost
+
+	testCases := []struct {
+		name     string
+		answer   []dns.RR
+		wantPref netip.Prefix
+		wantTTL  time.Duration
+	}{{
+		name:     "well_known",
+		answer:   []dns.RR{newRR(t, host, dns.TypeAAAA, 3600, net.ParseIP("64:ff9b::c000:aa"))},
+		wantPref: dns64WellKnownPref,
+		wantTTL:  time.Hour,
+	}, {
+		name: "custom_second",
+		answer: []dns.RR{
+			newRR(t, host, dns.TypeAAAA, 60, net.ParseIP("2001:db8::1")),
+			newRR(t, host, dns.TypeAAAA, 60, net.ParseIP("2001:db8:64::c000:ab")),
+		},
+		wantPref: netip.MustParsePrefix("2001:db8:64::/96"),
+		wantTTL:  time.Minute,
+	}, {
+		name:     "no_nat64",
+		answer:   []dns.RR{newRR(t, host, dns.TypeAAAA, 60, net.ParseIP("2001:db8::1"))},
+		wantPref: netip.Prefix{},
+		wantTTL:  0,
+	}, {
+		name:     "empty",
+		answer:   nil,
+		wantPref: netip.Prefix{},
+		wantTTL:  0,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			resp := (&dns.Msg{}).SetQuestion(host, dns.TypeAAAA)
+			resp.Response = true
+			resp.Answer = tc.answer
+
+			pref, ttl := nat64PrefixFromResponse(resp)
+			assert.Equal(t, tc.wantPref, pref)
+			assert.Equal(t, tc.wantTTL, ttl)
+		})
+	}
+}
@@ -73,6 +73,10 @@ type DHCP interface {
 	// due to an assumption that a DHCP client must always have an IP address.
 	HostByIP(ip netip.Addr) (host string)
 
+	// StaticHostByIP is like [DHCP.HostByIP] but only considers the static
+	// leases.
+	StaticHostByIP(ip netip.Addr) (host string)
+
 	// IPByHost returns the IP address of the DHCP client with the given
 	// hostname.  The hostname will be an empty string if there is no such
 	// client, due to an assumption that a DHCP client must always have a
@@ -159,10 +163,8 @@ type Server struct {
 	// [upstream.Resolver] interface.
 	bootResolvers []*upstream.UpstreamResolver
 
-	// dns64Pref is the NAT64 prefix used for DNS64 response mapping.  The major
-	// part of DNS64 happens inside the [proxy] package, but there still are
-	// some places where response mapping is needed (e.g. DHCP).
-	dns64Pref netip.Prefix
+	// dns64 is the DNS64 configuration.  It's nil if DNS64 is disabled.
+	dns64 *dns64Config
 
 	// anonymizer masks the client's IP addresses if needed.
 	anonymizer *aghnet.IPMut
@@ -171,6 +173,30 @@ type Server struct {
 	// during the BeforeRequestHandler stage.
 	clientIDCache cache.Cache
 
+	// cache is the cache of the responses resolved with the general upstream
+	// configuration.  It's nil if caching is disabled.
+	cache *dnsCache
+
+	// clientCaches are the caches of the responses resolved with the custom
+	// upstream configurations of the persistent clients.
+	clientCaches *clientCaches
+
+	// staleExclusions matches the domain names which expired cached responses
+	// are never served.
+	staleExclusions *aghnet.IgnoreEngine
+
+	// prefetchExclusions matches the domain names which cached responses are
+	// never prefetched or warmed up.
+	prefetchExclusions *aghnet.IgnoreEngine
+
+	// ratelimiter limits the rate of requests from clients.  It's nil if
+	// rate limiting is disabled.
+	ratelimiter *rateLimiter
+
+	// prefetchSem limits the number of the cached responses refreshed or
+	// warmed up at the same time.
+	prefetchSem chan struct{}
+
 	// internalProxy resolves internal requests from the application itself.  It
 	// isn't started and so no listen ports are required.
 	internalProxy *proxy.Proxy
@@ -178,6 +204,10 @@ type Server struct {
 	// isRunning is true if the DNS server is running.
 	isRunning bool
 
+	// cacheWarmedUp is true if the cache warm-up has already been started, so
+	// that it's only performed once per process start.
+	cacheWarmedUp bool
+
 	// protectionUpdateInProgress is used to make sure that only one goroutine
 	// updating the protection configuration after a pause is running at a time.
 	protectionUpdateInProgress atomic.Bool
@@ -299,6 +329,7 @@ func (s *Server) WriteDiskConfig(c *Config) {
 	sc := s.conf.Config
 	*c = sc
 	c.RatelimitWhitelist = slices.Clone(sc.RatelimitWhitelist)
+	c.RatelimitProfiles = slices.Clone(sc.RatelimitProfiles)
 	c.BootstrapDNS = slices.Clone(sc.BootstrapDNS)
 	c.FallbackDNS = slices.Clone(sc.FallbackDNS)
 	c.AllowedClients = slices.Clone(sc.AllowedClients)
@@ -306,6 +337,9 @@ func (s *Server) WriteDiskConfig(c *Config) {
 	c.BlockedHosts = slices.Clone(sc.BlockedHosts)
 	c.TrustedProxies = slices.Clone(sc.TrustedProxies)
 	c.UpstreamDNS = slices.Clone(sc.UpstreamDNS)
+	c.EDEBlockingModes = slices.Clone(sc.EDEBlockingModes)
+	c.CacheOptimisticExclusions = slices.Clone(sc.CacheOptimisticExclusions)
+	c.CachePrefetchExclusions = slices.Clone(sc.CachePrefetchExclusions)
 }
 
 // LocalPTRResolvers returns the current local PTR resolver configuration.
@@ -458,6 +492,7 @@ func (s *Server) startLocked() error {
 	err := s.dnsProxy.Start(context.Background())
 	if err == nil {
 		s.isRunning = true
+		s.startCacheWarmup()
 	}
 
 	return err
@@ -477,6 +512,17 @@ func (s *Server) Prepare(conf *ServerConfig) (err error) {
 		}
 	}
 
+	err = validateEDEBlockingModes(s.conf.EDEBlockingModes)
+	if err != nil {
+		return fmt.Errorf("checking extended dns errors: %w", err)
+	}
+
+	err = s.prepareRatelimit()
+	if err != nil {
+		// Don't wrap the error, because it's informative enough as is.
+		return err
+	}
+
 	s.initDefaultSettings()
 
 	err = s.prepareInternalDNS()
@@ -490,7 +536,10 @@ func (s *Server) Prepare(conf *ServerConfig) (err error) {
 		return fmt.Errorf("preparing proxy: %w", err)
 	}
 
-	s.setupDNS64()
+	err = s.setupDNS64()
+	if err != nil {
+		return fmt.Errorf("preparing dns64: %w", err)
+	}
 
 	s.access, err = newAccessCtx(
 		s.conf.AllowedClients,
@@ -23,6 +23,7 @@ import (
 
 	"github.com/AdguardTeam/AdGuardHome/internal/aghnet"
 	"github.com/AdguardTeam/AdGuardHome/internal/aghtest"
+	"github.com/AdguardTeam/AdGuardHome/internal/client"
 	"github.com/AdguardTeam/AdGuardHome/internal/filtering"
 	"github.com/AdguardTeam/AdGuardHome/internal/filtering/hashprefix"
 	"github.com/AdguardTeam/AdGuardHome/internal/filtering/safesearch"
@@ -729,6 +730,14 @@ func TestServerCustomClientUpstream(t *testing.T) {
 			return customUpsConf, nil
 		},
 	}
+	s.conf.ClientInfoProvider = &fakeClientsContainer{
+		OnClientInfoByID: func(_ string) (info *client.Info) {
+			return &client.Info{
+				Name:      "client",
+				CacheSize: defaultCacheSize,
+			}
+		},
+	}
 
 	startDeferStop(t, s)
 
@@ -1135,6 +1144,11 @@ func TestRewrite(t *testing.T) {
 			Domain: "my.alias.example.org",
 			Answer: "example.org",
 			Type:   dns.TypeCNAME,
+		}, {
+			Domain: "mail.test.com",
+			Answer: "10 mx.test.com.",
+			RRType: "MX",
+			TTL:    42,
 		}},
 	}
 	f, err := filtering.New(c, nil)
@@ -1219,6 +1233,19 @@ func TestRewrite(t *testing.T) {
 
 		assert.Equal(t, "example.org.", reply.Answer[0].(*dns.CNAME).Target)
 		assert.Equal(t, dns.TypeA, reply.Answer[1].Header().Rrtype)
+
+		req = createTestMessageWithType("mail.test.com.", dns.TypeMX)
+		reply, eerr = dns.Exchange(req, addr.String())
+		require.NoError(t, eerr)
+
+		require.Len(t, reply.Answer, 1)
+
+		mx, ok := reply.Answer[0].(*dns.MX)
+		require.True(t, ok)
+
+		assert.Equal(t, "mx.test.com.", mx.Mx)
+		assert.Equal(t, uint16(10), mx.Preference)
+		assert.Equal(t, uint32(42), mx.Hdr.Ttl)
 	}
 
 	for _, protect := range []bool{true, false} {
@@ -1244,11 +1271,43 @@ func publicKey(priv any) any {
 	}
 }
 
+// fakeClientsContainer is a fake [ClientsContainer] and [ClientInfoProvider]
+// implementation for tests.
+type fakeClientsContainer struct {
+	OnUpstreamConfigByID func(
+		id string,
+		boot upstream.Resolver,
+	) (conf *proxy.CustomUpstreamConfig, err error)
+	OnClientInfoByID func(id string) (info *client.Info)
+}
+
+// type check
+var _ ClientsContainer = (*fakeClientsContainer)(nil)
+
+// UpstreamConfigByID implements the [ClientsContainer] interface for
+// *fakeClientsContainer.
+func (c *fakeClientsContainer) UpstreamConfigByID(
+	id string,
+	boot upstream.Resolver,
+) (conf *proxy.CustomUpstreamConfig, err error) {
+	return c.OnUpstreamConfigByID(id, boot)
+}
+
+// type check
+var _ ClientInfoProvider = (*fakeClientsContainer)(nil)
+
+// ClientInfoByID implements the [ClientInfoProvider] interface for
+// *fakeClientsContainer.
+func (c *fakeClientsContainer) ClientInfoByID(id string) (info *client.Info) {
+	return c.OnClientInfoByID(id)
+}
+
 // testDHCP is a mock implementation of the [DHCP] interface.
 type testDHCP struct {
-	OnHostByIP func(ip netip.Addr) (host string)
-	OnIPByHost func(host string) (ip netip.Addr)
-	OnEnabled  func() (ok bool)
+	OnHostByIP       func(ip netip.Addr) (host string)
+	OnStaticHostByIP func(ip netip.Addr) (host string)
+	OnIPByHost       func(host string) (ip netip.Addr)
+	OnEnabled        func() (ok bool)
 }
 
 // type check
@@ -1257,6 +1316,9 @@ var _ DHCP = (*testDHCP)(nil)
 // HostByIP implements the [DHCP] interface for *testDHCP.
 func (d *testDHCP) HostByIP(ip netip.Addr) (host string) { return d.OnHostByIP(ip) }
 
+// StaticHostByIP implements the [DHCP] interface for *testDHCP.
+func (d *testDHCP) StaticHostByIP(ip netip.Addr) (host string) { return d.OnStaticHostByIP(ip) }
+
 // IPByHost implements the [DHCP] interface for *testDHCP.
 func (d *testDHCP) IPByHost(host string) (ip netip.Addr) { return d.OnIPByHost(host) }
 
@@ -30,6 +30,8 @@ func (s *Server) filterDNSRewriteResponse(
 		return s.ansFromDNSRewriteSVCB(v, rr, req)
 	case dns.TypeSRV:
 		return s.ansFromDNSRewriteSRV(v, rr, req)
+	case dns.TypeCAA:
+		return s.ansFromDNSRewriteCAA(v, rr, req)
 	default:
 		log.Debug("don't know how to handle dns rr type %d, skipping", rr)
 
@@ -136,6 +138,26 @@ func (s *Server) ansFromDNSRewriteSRV(
 	return s.genAnswerSRV(req, srv), nil
 }
 
+// ansFromDNSRewriteCAA creates a new answer resource record from the CAA
+// rewrite data.
+func (s *Server) ansFromDNSRewriteCAA(
+	v rules.RRValue,
+	rr rules.RRType,
+	req *dns.Msg,
+) (ans dns.RR, err error) {
+	caa, ok := v.(*dns.CAA)
+	if !ok {
+		return nil, fmt.Errorf("value for rr type %s has type %T, not *dns.CAA", dns.Type(rr), v)
+	}
+
+	return &dns.CAA{
+		Hdr:   s.hdr(req, dns.TypeCAA),
+		Flag:  caa.Flag,
+		Tag:   caa.Tag,
+		Value: caa.Value,
+	}, nil
+}
+
 // filterDNSRewrite handles dnsrewrite filters.  It constructs a DNS response
 // and sets it into pctx.Res.  All parameters must not be nil.
 func (s *Server) filterDNSRewrite(
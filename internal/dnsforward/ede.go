@@ -0,0 +1,201 @@
+package dnsforward
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"slices"
+	"unicode/utf8"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/filtering"
+	"github.com/AdguardTeam/dnsproxy/proxy"
+	"github.com/AdguardTeam/dnsproxy/upstream"
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/miekg/dns"
+)
+
+// maxEDEExtraTextLen is the maximum length of the EXTRA-TEXT field of an
+// Extended DNS Error option, in bytes.  It's chosen to keep the responses
+// reasonably small, since the field is only intended for humans.
+const maxEDEExtraTextLen = 256
+
+// ednsUDPSize is the UDP payload size advertised in OPT RRs added to the
+// responses, which didn't have one.
+//
+// See [RFC 6891].
+//
+// [RFC 6891]: https://datatracker.ietf.org/doc/html/rfc6891#section-6.2.5
+const ednsUDPSize = 1232
+
+// addEDE adds an Extended DNS Error option with code and text to resp.  It does
+// nothing if req has no OPT RR, since the response must not contain one in
+// that case.
+//
+// See [RFC 8914].
+//
+// [RFC 8914]: https://datatracker.ietf.org/doc/html/rfc8914
+func addEDE(req, resp *dns.Msg, code uint16, text string) {
+	reqOpt := req.IsEdns0()
+	if reqOpt == nil || resp == nil {
+		return
+	}
+
+	opt := resp.IsEdns0()
+	if opt == nil {
+		resp.SetEdns0(ednsUDPSize, reqOpt.Do())
+		opt = resp.IsEdns0()
+	}
+
+	opt.Option = append(opt.Option, &dns.EDNS0_EDE{
+		InfoCode:  code,
+		ExtraText: truncateEDEText(text),
+	})
+}
+
+// truncateEDEText returns text truncated to at most [maxEDEExtraTextLen] bytes
+// without splitting the UTF-8 encoded characters.
+func truncateEDEText(text string) (truncated string) {
+	if len(text) <= maxEDEExtraTextLen {
+		return text
+	}
+
+	n := maxEDEExtraTextLen
+	for n > 0 && !utf8.RuneStart(text[n]) {
+		n--
+	}
+
+	return text[:n]
+}
+
+// validateEDEBlockingModes returns an error if any of modes isn't a valid
+// blocking mode.
+func validateEDEBlockingModes(modes []filtering.BlockingMode) (err error) {
+	for i, mode := range modes {
+		switch mode {
+		case
+			filtering.BlockingModeCustomIP,
+			filtering.BlockingModeDefault,
+			filtering.BlockingModeNullIP,
+			filtering.BlockingModeNXDOMAIN,
+			filtering.BlockingModeREFUSED:
+			// Go on.
+		default:
+			return fmt.Errorf("ede_blocking_modes: at index %d: bad blocking mode %q", i, mode)
+		}
+	}
+
+	return nil
+}
+
+// edeCodeForReason returns the Extended DNS Error code for the blocked
+// response filtered with reason.  ok is false if the reason doesn't describe a
+// blocked response.
+func edeCodeForReason(reason filtering.Reason) (code uint16, ok bool) {
+	switch reason {
+	case filtering.FilteredBlockList, filtering.FilteredSafeBrowsing:
+		// The operator's policy, including the security one.
+		return dns.ExtendedErrorCodeBlocked, true
+	case filtering.FilteredBlockedService, filtering.FilteredAllowlistOnly:
+		// The services and the client modes are explicitly chosen by the user.
+		return dns.ExtendedErrorCodeFiltered, true
+	case filtering.FilteredParental:
+		// The categories are defined by the external parental control service.
+		return dns.ExtendedErrorCodeCensored, true
+	default:
+		return 0, false
+	}
+}
+
+// edeEnabledForMode returns true if the blocked responses constructed with mode
+// should carry Extended DNS Errors.
+func (s *Server) edeEnabledForMode(mode filtering.BlockingMode) (ok bool) {
+	if !s.conf.EDEEnabled {
+		return false
+	}
+
+	return len(s.conf.EDEBlockingModes) == 0 || slices.Contains(s.conf.EDEBlockingModes, mode)
+}
+
+// addFilteringEDE adds the Extended DNS Error describing res to resp, which is
+// the response to req, if configured.
+func (s *Server) addFilteringEDE(req, resp *dns.Msg, res *filtering.Result) {
+	code, ok := edeCodeForReason(res.Reason)
+	if !ok {
+		return
+	}
+
+	mode, _, _ := s.dnsFilter.BlockingMode()
+	if !s.edeEnabledForMode(mode) {
+		return
+	}
+
+	addEDE(req, resp, code, s.filteringEDEText(res))
+}
+
+// filteringEDEText returns the extra text for the Extended DNS Error describing
+// the filtering result res.  The text contains the name of the filter list and
+// the matched rule, if any.
+func (s *Server) filteringEDEText(res *filtering.Result) (text string) {
+	if len(res.Rules) == 0 {
+		return res.Reason.String()
+	}
+
+	rule := res.Rules[0]
+	name := s.dnsFilter.FilterListName(rule.FilterListID)
+	if res.Reason == filtering.FilteredBlockedService && res.ServiceName != "" {
+		name = fmt.Sprintf("%s: %s", name, res.ServiceName)
+	}
+
+	if name == "" {
+		return rule.Text
+	}
+
+	return fmt.Sprintf("%s: %s", name, rule.Text)
+}
+
+// addUpstreamEDE adds the Extended DNS Error describing the upstream failure
+// err to the response in pctx, if configured.
+func (s *Server) addUpstreamEDE(pctx *proxy.DNSContext, err error) {
+	if !s.conf.EDEEnabled || err == nil {
+		return
+	}
+
+	code := dns.ExtendedErrorCodeNetworkError
+	if isUnreachableErr(err) {
+		code = dns.ExtendedErrorCodeNoReachableAuthority
+	}
+
+	addEDE(pctx.Req, pctx.Res, code, "")
+}
+
+// isUnreachableErr returns true if err means that none of the upstreams could
+// be reached or have responded in time.
+func isUnreachableErr(err error) (ok bool) {
+	if errors.Is(err, upstream.ErrNoUpstreams) || errors.Is(err, os.ErrDeadlineExceeded) {
+		return true
+	}
+
+	var netErr net.Error
+
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// addProhibitedEDE adds the Extended DNS Error to the response refusing the
+// request by the access settings, if configured.
+func (s *Server) addProhibitedEDE(req, resp *dns.Msg) {
+	if !s.conf.EDEEnabled {
+		return
+	}
+
+	addEDE(req, resp, dns.ExtendedErrorCodeProhibited, "")
+}
+
+// addStaleEDE adds the Extended DNS Error to the response in pctx served from
+// an expired cache item, if configured.
+func (s *Server) addStaleEDE(pctx *proxy.DNSContext) {
+	if !s.conf.EDEEnabled {
+		return
+	}
+
+	addEDE(pctx.Req, pctx.Res, dns.ExtendedErrorCodeStaleAnswer, "")
+}
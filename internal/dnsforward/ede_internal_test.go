@@ -0,0 +1,234 @@
+package dnsforward
+
+import (
+	"net"
+	"os"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/filtering"
+	"github.com/AdguardTeam/dnsproxy/proxy"
+	"github.com/AdguardTeam/dnsproxy/upstream"
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// findEDE returns the first Extended DNS Error option of msg, if any.
+func findEDE(msg *dns.Msg) (ede *dns.EDNS0_EDE) {
+	opt := msg.IsEdns0()
+	if opt == nil {
+		return nil
+	}
+
+	for _, o := range opt.Option {
+		if ede, ok := o.(*dns.EDNS0_EDE); ok {
+			return ede
+		}
+	}
+
+	return nil
+}
+
+func TestServer_genDNSFilterMessage_ede(t *testing.T) {
+	t.Parallel()
+
+	const blockedFQDN = "nxdomain.example.org."
+
+	testCases := []struct {
+		wantEDE *dns.EDNS0_EDE
+		name    string
+		modes   []filtering.BlockingMode
+		enabled bool
+		edns    bool
+	}{{
+		wantEDE: nil,
+		name:    "disabled",
+		modes:   nil,
+		enabled: false,
+		edns:    true,
+	}, {
+		wantEDE: nil,
+		name:    "no_edns",
+		modes:   nil,
+		enabled: true,
+		edns:    false,
+	}, {
+		wantEDE: &dns.EDNS0_EDE{
+			InfoCode:  dns.ExtendedErrorCodeBlocked,
+			ExtraText: "Custom filtering rules: ||nxdomain.example.org",
+		},
+		name:    "all_modes",
+		modes:   nil,
+		enabled: true,
+		edns:    true,
+	}, {
+		wantEDE: &dns.EDNS0_EDE{
+			InfoCode:  dns.ExtendedErrorCodeBlocked,
+			ExtraText: "Custom filtering rules: ||nxdomain.example.org",
+		},
+		name:    "matching_mode",
+		modes:   []filtering.BlockingMode{filtering.BlockingModeNXDOMAIN},
+		enabled: true,
+		edns:    true,
+	}, {
+		wantEDE: nil,
+		name:    "other_mode",
+		modes:   []filtering.BlockingMode{filtering.BlockingModeREFUSED},
+		enabled: true,
+		edns:    true,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			s := createTestServer(t, &filtering.Config{
+				BlockingMode: filtering.BlockingModeNXDOMAIN,
+			}, ServerConfig{
+				UDPListenAddrs: []*net.UDPAddr{{}},
+				TCPListenAddrs: []*net.TCPAddr{{}},
+				Config: Config{
+					UpstreamMode: UpstreamModeLoadBalance,
+					EDNSClientSubnet: &EDNSClientSubnet{
+						Enabled: false,
+					},
+					EDEEnabled:       tc.enabled,
+					EDEBlockingModes: tc.modes,
+				},
+				ServePlainDNS: true,
+			})
+
+			req := createTestMessage(blockedFQDN)
+			if tc.edns {
+				req.SetEdns0(ednsUDPSize, false)
+			}
+
+			dctx := &dnsContext{
+				proxyCtx: &proxy.DNSContext{
+					Req: req,
+				},
+				setts: &filtering.Settings{
+					ProtectionEnabled: true,
+					FilteringEnabled:  true,
+				},
+			}
+
+			res, err := s.filterDNSRequest(dctx)
+			require.NoError(t, err)
+			require.NotNil(t, res)
+
+			resp := dctx.proxyCtx.Res
+			require.NotNil(t, resp)
+
+			assert.Equal(t, dns.RcodeNameError, resp.Rcode)
+			assert.Equal(t, tc.wantEDE, findEDE(resp))
+		})
+	}
+}
+
+func TestAddEDE(t *testing.T) {
+	t.Parallel()
+
+	t.Run("no_opt", func(t *testing.T) {
+		t.Parallel()
+
+		req := createTestMessage(testFQDN)
+		resp := (&dns.Msg{}).SetRcode(req, dns.RcodeServerFailure)
+
+		addEDE(req, resp, dns.ExtendedErrorCodeNetworkError, "")
+
+		assert.Nil(t, resp.IsEdns0())
+	})
+
+	t.Run("adds_opt", func(t *testing.T) {
+		t.Parallel()
+
+		req := createTestMessage(testFQDN)
+		req.SetEdns0(4096, true)
+		resp := (&dns.Msg{}).SetRcode(req, dns.RcodeServerFailure)
+
+		addEDE(req, resp, dns.ExtendedErrorCodeNetworkError, "")
+
+		opt := resp.IsEdns0()
+		require.NotNil(t, opt)
+
+		assert.True(t, opt.Do())
+		assert.Equal(t, &dns.EDNS0_EDE{
+			InfoCode: dns.ExtendedErrorCodeNetworkError,
+		}, findEDE(resp))
+	})
+
+	t.Run("long_text", func(t *testing.T) {
+		t.Parallel()
+
+		req := createTestMessage(testFQDN)
+		req.SetEdns0(ednsUDPSize, false)
+		resp := (&dns.Msg{}).SetReply(req)
+		resp.SetEdns0(ednsUDPSize, false)
+
+		text := string(make([]byte, maxEDEExtraTextLen+1))
+		addEDE(req, resp, dns.ExtendedErrorCodeBlocked, text)
+
+		ede := findEDE(resp)
+		require.NotNil(t, ede)
+
+		assert.Len(t, ede.ExtraText, maxEDEExtraTextLen)
+		assert.Len(t, resp.IsEdns0().Option, 1)
+	})
+
+	t.Run("long_text_utf8", func(t *testing.T) {
+		t.Parallel()
+
+		req := createTestMessage(testFQDN)
+		req.SetEdns0(ednsUDPSize, false)
+		resp := (&dns.Msg{}).SetReply(req)
+
+		// Each character takes 3 bytes, so the limit falls within one.
+		text := strings.Repeat("過濾器", maxEDEExtraTextLen)
+		addEDE(req, resp, dns.ExtendedErrorCodeBlocked, text)
+
+		ede := findEDE(resp)
+		require.NotNil(t, ede)
+
+		assert.True(t, utf8.ValidString(ede.ExtraText))
+		assert.LessOrEqual(t, len(ede.ExtraText), maxEDEExtraTextLen)
+		assert.Greater(t, len(ede.ExtraText), maxEDEExtraTextLen-utf8.UTFMax)
+	})
+}
+
+func TestIsUnreachableErr(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		err  error
+		name string
+		want bool
+	}{{
+		err:  upstream.ErrNoUpstreams,
+		name: "no_upstreams",
+		want: true,
+	}, {
+		err:  errors.Annotate(os.ErrDeadlineExceeded, "exchanging: %w"),
+		name: "deadline",
+		want: true,
+	}, {
+		err:  &net.DNSError{IsTimeout: true},
+		name: "timeout",
+		want: true,
+	}, {
+		err:  errors.Error("connection refused"),
+		name: "other",
+		want: false,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			assert.Equal(t, tc.want, isUnreachableErr(tc.err))
+		})
+	}
+}
@@ -48,6 +48,11 @@ func (s *Server) filterDNSRequest(dctx *dnsContext) (res *filtering.Result, err
 	case res.IsFiltered:
 		log.Debug("dnsforward: host %q is filtered, reason: %q", host, res.Reason)
 		pctx.Res = s.genDNSFilterMessage(pctx, res)
+	case res.Reason == filtering.Rewritten && res.DNSRewriteResult != nil:
+		// Typed rewrites from the rewrites table.
+		if err = s.filterDNSRewrite(req, res, pctx); err != nil {
+			return nil, err
+		}
 	case res.Reason.In(filtering.Rewritten, filtering.FilteredSafeSearch):
 		pctx.Res = s.getCNAMEWithIPs(req, res.IPList, res.CanonName)
 	case res.Reason.In(filtering.RewrittenRule, filtering.RewrittenAutoHosts):
@@ -56,9 +61,25 @@ func (s *Server) filterDNSRequest(dctx *dnsContext) (res *filtering.Result, err
 		}
 	}
 
+	if res.Reason == filtering.Rewritten && pctx.Res != nil {
+		setRewriteTTL(pctx.Res.Answer, res.TTL)
+	}
+
 	return res, err
 }
 
+// setRewriteTTL sets the TTL of each of rrs to ttl, unless it's zero, which
+// means that the rewrite has no TTL set.
+func setRewriteTTL(rrs []dns.RR, ttl uint32) {
+	if ttl == 0 {
+		return
+	}
+
+	for _, rr := range rrs {
+		rr.Header().Ttl = ttl
+	}
+}
+
 // isRewrittenCNAME returns true if the request considered to be rewritten with
 // CNAME and has no resolved IPs.
 func isRewrittenCNAME(res *filtering.Result) (ok bool) {
@@ -11,6 +11,7 @@ import (
 	"time"
 
 	"github.com/AdguardTeam/AdGuardHome/internal/aghhttp"
+	"github.com/AdguardTeam/AdGuardHome/internal/aghnet"
 	"github.com/AdguardTeam/AdGuardHome/internal/filtering"
 	"github.com/AdguardTeam/dnsproxy/proxy"
 	"github.com/AdguardTeam/dnsproxy/upstream"
@@ -18,6 +19,7 @@ import (
 	"github.com/AdguardTeam/golibs/log"
 	"github.com/AdguardTeam/golibs/netutil"
 	"github.com/AdguardTeam/golibs/stringutil"
+	"github.com/AdguardTeam/golibs/timeutil"
 )
 
 // jsonDNSConfig is the JSON representation of the DNS server configuration.
@@ -89,6 +91,29 @@ type jsonDNSConfig struct {
 	// CacheOptimistic defines if expired entries should be served.
 	CacheOptimistic *bool `json:"cache_optimistic"`
 
+	// CacheOptimisticAnswerTTL is the TTL of the responses served from expired
+	// entries, in seconds.
+	CacheOptimisticAnswerTTL *uint32 `json:"cache_optimistic_answer_ttl"`
+
+	// CacheOptimisticMaxAge is the maximum time during which expired entries
+	// are served, in seconds.
+	CacheOptimisticMaxAge *uint32 `json:"cache_optimistic_max_age"`
+
+	// CacheOptimisticClientTimeout is the time to wait for the upstream before
+	// serving an expired entry, in milliseconds.
+	CacheOptimisticClientTimeout *uint32 `json:"cache_optimistic_client_timeout"`
+
+	// CacheOptimisticExclusions are the rules for domain names which expired
+	// entries are never served.
+	CacheOptimisticExclusions *[]string `json:"cache_optimistic_exclusions"`
+
+	// EDEEnabled defines if Extended DNS Errors should be added to responses.
+	EDEEnabled *bool `json:"ede_enabled"`
+
+	// EDEBlockingModes are the blocking modes for which the blocked responses
+	// carry Extended DNS Errors.
+	EDEBlockingModes *[]filtering.BlockingMode `json:"ede_blocking_modes"`
+
 	// ResolveClients defines if clients IPs should be resolved into hostnames.
 	ResolveClients *bool `json:"resolve_clients"`
 
@@ -98,6 +123,10 @@ type jsonDNSConfig struct {
 	// LocalPTRUpstreams is the list of local private DNS resolvers.
 	LocalPTRUpstreams *[]string `json:"local_ptr_upstreams"`
 
+	// SynthesizePTR defines if the responses to PTR requests should be
+	// synthesized from the rewrites, the rules, and the DHCP leases.
+	SynthesizePTR *bool `json:"synthesize_ptr"`
+
 	// BlockingIPv4 is custom IPv4 address for blocked A requests.
 	BlockingIPv4 netip.Addr `json:"blocking_ipv4"`
 
@@ -158,9 +187,16 @@ func (s *Server) getDNSConfig() (c *jsonDNSConfig) {
 	cacheMinTTL := s.conf.CacheMinTTL
 	cacheMaxTTL := s.conf.CacheMaxTTL
 	cacheOptimistic := s.conf.CacheOptimistic
+	staleAnswerTTL := uint32(s.conf.CacheOptimisticAnswerTTL.Seconds())
+	staleMaxAge := uint32(s.conf.CacheOptimisticMaxAge.Seconds())
+	staleClientTimeout := uint32(s.conf.CacheOptimisticClientTimeout.Milliseconds())
+	staleExclusions := stringutil.CloneSliceOrEmpty(s.conf.CacheOptimisticExclusions)
+	edeEnabled := s.conf.EDEEnabled
+	edeBlockingModes := append([]filtering.BlockingMode{}, s.conf.EDEBlockingModes...)
 	resolveClients := s.conf.AddrProcConf.UseRDNS
 	usePrivateRDNS := s.conf.UsePrivateRDNS
 	localPTRUpstreams := stringutil.CloneSliceOrEmpty(s.conf.LocalPTRResolvers)
+	synthesizePTR := s.conf.SynthesizePTR
 
 	var upstreamMode jsonUpstreamMode
 	switch s.conf.UpstreamMode {
@@ -180,34 +216,41 @@ func (s *Server) getDNSConfig() (c *jsonDNSConfig) {
 	}
 
 	return &jsonDNSConfig{
-		Upstreams:                &upstreams,
-		UpstreamsFile:            &upstreamFile,
-		Bootstraps:               &bootstraps,
-		Fallbacks:                &fallbacks,
-		ProtectionEnabled:        &protectionEnabled,
-		BlockingMode:             &blockingMode,
-		BlockingIPv4:             blockingIPv4,
-		BlockingIPv6:             blockingIPv6,
-		Ratelimit:                &ratelimit,
-		RatelimitSubnetLenIPv4:   &ratelimitSubnetLenIPv4,
-		RatelimitSubnetLenIPv6:   &ratelimitSubnetLenIPv6,
-		RatelimitWhitelist:       &ratelimitWhitelist,
-		EDNSCSCustomIP:           customIP,
-		EDNSCSEnabled:            &enableEDNSClientSubnet,
-		EDNSCSUseCustom:          &useCustom,
-		DNSSECEnabled:            &enableDNSSEC,
-		DisableIPv6:              &aaaaDisabled,
-		BlockedResponseTTL:       &blockedResponseTTL,
-		CacheSize:                &cacheSize,
-		CacheMinTTL:              &cacheMinTTL,
-		CacheMaxTTL:              &cacheMaxTTL,
-		CacheOptimistic:          &cacheOptimistic,
-		UpstreamMode:             &upstreamMode,
-		ResolveClients:           &resolveClients,
-		UsePrivateRDNS:           &usePrivateRDNS,
-		LocalPTRUpstreams:        &localPTRUpstreams,
-		DefaultLocalPTRUpstreams: defPTRUps,
-		DisabledUntil:            protectionDisabledUntil,
+		Upstreams:                    &upstreams,
+		UpstreamsFile:                &upstreamFile,
+		Bootstraps:                   &bootstraps,
+		Fallbacks:                    &fallbacks,
+		ProtectionEnabled:            &protectionEnabled,
+		BlockingMode:                 &blockingMode,
+		BlockingIPv4:                 blockingIPv4,
+		BlockingIPv6:                 blockingIPv6,
+		Ratelimit:                    &ratelimit,
+		RatelimitSubnetLenIPv4:       &ratelimitSubnetLenIPv4,
+		RatelimitSubnetLenIPv6:       &ratelimitSubnetLenIPv6,
+		RatelimitWhitelist:           &ratelimitWhitelist,
+		EDNSCSCustomIP:               customIP,
+		EDNSCSEnabled:                &enableEDNSClientSubnet,
+		EDNSCSUseCustom:              &useCustom,
+		DNSSECEnabled:                &enableDNSSEC,
+		DisableIPv6:                  &aaaaDisabled,
+		BlockedResponseTTL:           &blockedResponseTTL,
+		CacheSize:                    &cacheSize,
+		CacheMinTTL:                  &cacheMinTTL,
+		CacheMaxTTL:                  &cacheMaxTTL,
+		CacheOptimistic:              &cacheOptimistic,
+		CacheOptimisticAnswerTTL:     &staleAnswerTTL,
+		CacheOptimisticMaxAge:        &staleMaxAge,
+		CacheOptimisticClientTimeout: &staleClientTimeout,
+		CacheOptimisticExclusions:    &staleExclusions,
+		EDEEnabled:                   &edeEnabled,
+		EDEBlockingModes:             &edeBlockingModes,
+		UpstreamMode:                 &upstreamMode,
+		ResolveClients:               &resolveClients,
+		UsePrivateRDNS:               &usePrivateRDNS,
+		LocalPTRUpstreams:            &localPTRUpstreams,
+		SynthesizePTR:                &synthesizePTR,
+		DefaultLocalPTRUpstreams:     defPTRUps,
+		DisabledUntil:                protectionDisabledUntil,
 	}
 }
 
@@ -302,6 +345,20 @@ func (req *jsonDNSConfig) validate(
 		return err
 	}
 
+	err = req.checkCacheOptimisticExclusions()
+	if err != nil {
+		// Don't wrap the error since it's informative enough as is.
+		return err
+	}
+
+	if req.EDEBlockingModes != nil {
+		err = validateEDEBlockingModes(*req.EDEBlockingModes)
+		if err != nil {
+			// Don't wrap the error since it's informative enough as is.
+			return err
+		}
+	}
+
 	return nil
 }
 
@@ -402,6 +459,21 @@ func (req *jsonDNSConfig) validateUpstreamDNSServers(
 	return nil
 }
 
+// checkCacheOptimisticExclusions returns an error if any of the optimistic
+// cache exclusion rules is invalid.
+func (req *jsonDNSConfig) checkCacheOptimisticExclusions() (err error) {
+	if req.CacheOptimisticExclusions == nil {
+		return nil
+	}
+
+	_, err = aghnet.NewIgnoreEngine(*req.CacheOptimisticExclusions)
+	if err != nil {
+		return fmt.Errorf("cache_optimistic_exclusions: %w", err)
+	}
+
+	return nil
+}
+
 // checkCacheTTL returns an error if the configuration of the cache TTL is
 // invalid.
 func (req *jsonDNSConfig) checkCacheTTL() (err error) {
@@ -520,6 +592,9 @@ func (s *Server) setConfig(dc *jsonDNSConfig) (shouldRestart bool) {
 
 	setIfNotNil(&s.conf.EnableDNSSEC, dc.DNSSECEnabled)
 	setIfNotNil(&s.conf.AAAADisabled, dc.DisableIPv6)
+	setIfNotNil(&s.conf.EDEEnabled, dc.EDEEnabled)
+	setIfNotNil(&s.conf.EDEBlockingModes, dc.EDEBlockingModes)
+	setIfNotNil(&s.conf.SynthesizePTR, dc.SynthesizePTR)
 
 	return s.setConfigRestartable(dc)
 }
@@ -552,6 +627,22 @@ func setIfNotNil[T any](currentPtr, newPtr *T) (hasSet bool) {
 	return true
 }
 
+// setDurationIfNotNil sets the value pointed at by currentPtr to the number of
+// units pointed at by newPtr if newPtr is not nil.  currentPtr must not be nil.
+func setDurationIfNotNil(
+	currentPtr *timeutil.Duration,
+	newPtr *uint32,
+	unit time.Duration,
+) (hasSet bool) {
+	if newPtr == nil {
+		return false
+	}
+
+	currentPtr.Duration = time.Duration(*newPtr) * unit
+
+	return true
+}
+
 // setConfigRestartable sets the parameters which trigger a restart.
 // shouldRestart is true if the server should be restarted to apply changes.
 // s.serverLock is expected to be locked.
@@ -571,6 +662,14 @@ func (s *Server) setConfigRestartable(dc *jsonDNSConfig) (shouldRestart bool) {
 		setIfNotNil(&s.conf.CacheMinTTL, dc.CacheMinTTL),
 		setIfNotNil(&s.conf.CacheMaxTTL, dc.CacheMaxTTL),
 		setIfNotNil(&s.conf.CacheOptimistic, dc.CacheOptimistic),
+		setDurationIfNotNil(&s.conf.CacheOptimisticAnswerTTL, dc.CacheOptimisticAnswerTTL, time.Second),
+		setDurationIfNotNil(&s.conf.CacheOptimisticMaxAge, dc.CacheOptimisticMaxAge, time.Second),
+		setDurationIfNotNil(
+			&s.conf.CacheOptimisticClientTimeout,
+			dc.CacheOptimisticClientTimeout,
+			time.Millisecond,
+		),
+		setIfNotNil(&s.conf.CacheOptimisticExclusions, dc.CacheOptimisticExclusions),
 		setIfNotNil(&s.conf.AddrProcConf.UseRDNS, dc.ResolveClients),
 		setIfNotNil(&s.conf.UsePrivateRDNS, dc.UsePrivateRDNS),
 		setIfNotNil(&s.conf.RatelimitSubnetLenIPv4, dc.RatelimitSubnetLenIPv4),
@@ -642,7 +741,7 @@ func (s *Server) handleTestUpstreamDNS(w http.ResponseWriter, r *http.Request) {
 
 // handleCacheClear is the handler for the POST /control/cache_clear HTTP API.
 func (s *Server) handleCacheClear(w http.ResponseWriter, _ *http.Request) {
-	s.dnsProxy.ClearCache()
+	s.clearCache()
 	_, _ = io.WriteString(w, "OK")
 }
 
@@ -731,6 +830,11 @@ func (s *Server) registerHandlers() {
 	s.conf.HTTPRegister(http.MethodPost, "/control/access/set", s.handleAccessSet)
 
 	s.conf.HTTPRegister(http.MethodPost, "/control/cache_clear", s.handleCacheClear)
+	s.conf.HTTPRegister(http.MethodGet, "/control/cache/lookup", s.handleCacheLookup)
+	s.conf.HTTPRegister(http.MethodPost, "/control/cache/purge", s.handleCachePurge)
+	s.conf.HTTPRegister(http.MethodGet, "/control/cache/stats", s.handleCacheStats)
+
+	s.conf.HTTPRegister(http.MethodGet, "/control/ratelimit/status", s.handleRatelimitStatus)
 
 	// Register both versions, with and without the trailing slash, to
 	// prevent a 301 Moved Permanently redirect when clients request the
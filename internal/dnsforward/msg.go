@@ -49,6 +49,18 @@ func ipsFromRules(resRules []*filtering.ResultRule) (ips []netip.Addr) {
 func (s *Server) genDNSFilterMessage(
 	dctx *proxy.DNSContext,
 	res *filtering.Result,
+) (resp *dns.Msg) {
+	resp = s.genDNSFilterResponse(dctx, res)
+	s.addFilteringEDE(dctx.Req, resp, res)
+
+	return resp
+}
+
+// genDNSFilterResponse generates a filtered response to req for the filtering
+// result res without any Extended DNS Errors.
+func (s *Server) genDNSFilterResponse(
+	dctx *proxy.DNSContext,
+	res *filtering.Result,
 ) (resp *dns.Msg) {
 	req := dctx.Req
 	qt := req.Question[0].Qtype
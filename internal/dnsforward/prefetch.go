@@ -0,0 +1,225 @@
+package dnsforward
+
+import (
+	"fmt"
+	"net/netip"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/aghnet"
+	"github.com/AdguardTeam/AdGuardHome/internal/stats"
+	"github.com/AdguardTeam/dnsproxy/proxy"
+	"github.com/AdguardTeam/golibs/log"
+	"github.com/AdguardTeam/golibs/netutil"
+	"github.com/miekg/dns"
+)
+
+const (
+	// defaultPrefetchMinHits is the default number of requests for a cache
+	// item within its TTL after which it's prefetched.
+	defaultPrefetchMinHits = 3
+
+	// defaultPrefetchThreshold is the default remaining part of the TTL, in
+	// percent, at which the popular cache items are prefetched.  It's the value
+	// used by Unbound.
+	defaultPrefetchThreshold = 10
+
+	// defaultPrefetchMaxConcurrent is the default maximum number of the cache
+	// items prefetched or warmed up at the same time.
+	defaultPrefetchMaxConcurrent = 10
+)
+
+// preparePrefetch validates the prefetching configuration and initializes the
+// prefetching-related fields of s.
+func (s *Server) preparePrefetch() (err error) {
+	srvConf := &s.conf
+	if srvConf.CachePrefetchThreshold > 100 {
+		return fmt.Errorf(
+			"cache_prefetch_threshold: must be no greater than 100, got %d",
+			srvConf.CachePrefetchThreshold,
+		)
+	}
+
+	s.prefetchExclusions, err = aghnet.NewIgnoreEngine(srvConf.CachePrefetchExclusions)
+	if err != nil {
+		return fmt.Errorf("cache_prefetch_exclusions: %w", err)
+	}
+
+	maxConcurrent := srvConf.CachePrefetchMaxConcurrent
+	if maxConcurrent == 0 {
+		maxConcurrent = defaultPrefetchMaxConcurrent
+	}
+
+	s.prefetchSem = make(chan struct{}, maxConcurrent)
+
+	return nil
+}
+
+// shouldPrefetch returns true if the fresh cached item should be refreshed at
+// now.
+func (s *Server) shouldPrefetch(item *cacheItem, now time.Time) (ok bool) {
+	conf := &s.conf
+	if !conf.CachePrefetch {
+		return false
+	}
+
+	minHits := conf.CachePrefetchMinHits
+	if minHits == 0 {
+		minHits = defaultPrefetchMinHits
+	}
+
+	if item.hits.Load() < minHits {
+		return false
+	}
+
+	threshold := conf.CachePrefetchThreshold
+	if threshold == 0 {
+		threshold = defaultPrefetchThreshold
+	}
+
+	remaining := item.expire.Sub(now)
+	total := item.expire.Sub(item.stored)
+
+	return remaining*100 <= total*time.Duration(threshold)
+}
+
+// prefetch starts a background update of the fresh cached item for key in c
+// using the request from pctx, if the item is popular and about to expire.  It
+// does nothing if the limit of concurrent prefetches is reached.
+func (s *Server) prefetch(
+	prx *proxy.Proxy,
+	c *dnsCache,
+	key cacheKey,
+	item *cacheItem,
+	pctx *proxy.DNSContext,
+	now time.Time,
+) {
+	if !s.shouldPrefetch(item, now) ||
+		s.prefetchExclusions.Has(strings.TrimSuffix(key.name, ".")) {
+		return
+	}
+
+	sem := s.prefetchSem
+	select {
+	case sem <- struct{}{}:
+		// Go on.
+	default:
+		log.Debug("dnsforward: too many prefetches, skipping %q", key.name)
+
+		return
+	}
+
+	_, started := c.startRefresh(key)
+	if !started {
+		<-sem
+
+		return
+	}
+
+	clone := cloneForRefresh(pctx)
+
+	go func() {
+		defer log.OnPanic("dnsforward: prefetching")
+		defer func() { <-sem }()
+		defer c.finishRefresh(key)
+
+		log.Debug("dnsforward: prefetching %q", key.name)
+
+		refresh(prx, c, key, clone)
+	}()
+}
+
+// cacheWarmup resolves the most requested domain names into a cache.
+type cacheWarmup struct {
+	// prx resolves the requests.  It must not be nil.
+	prx *proxy.Proxy
+
+	// cache stores the responses.  It must not be nil.
+	cache *dnsCache
+
+	// stats provides the most requested domain names.  It must not be nil.
+	stats stats.Interface
+
+	// exclusions matches the domain names which aren't resolved.
+	exclusions *aghnet.IgnoreEngine
+
+	// sem limits the number of the requests resolved at the same time.
+	sem chan struct{}
+}
+
+// warmupAddr is the address the warm-up requests are sent as if from, so that
+// the client's subnet isn't used.
+var warmupAddr = netip.AddrPortFrom(netutil.IPv4Localhost(), 0)
+
+// startCacheWarmup starts resolving the most requested domain names from the
+// statistics into the general cache, if configured and not done yet.  The
+// responses are cached without the client subnet, so the warm-up is skipped
+// when EDNS Client Subnet is enabled, since such entries would never be used.
+// s.serverLock is expected to be locked.
+func (s *Server) startCacheWarmup() {
+	n := s.conf.CacheWarmupDomains
+	if s.cacheWarmedUp || n == 0 || s.cache == nil || s.stats == nil {
+		return
+	}
+
+	if s.conf.EDNSClientSubnet.Enabled {
+		log.Info("dnsforward: edns client subnet is enabled, not warming cache")
+
+		return
+	}
+
+	s.cacheWarmedUp = true
+
+	w := &cacheWarmup{
+		prx:        s.dnsProxy,
+		cache:      s.cache,
+		stats:      s.stats,
+		exclusions: s.prefetchExclusions,
+		sem:        s.prefetchSem,
+	}
+
+	go w.run(uint(n))
+}
+
+// run resolves at most n most requested domain names.
+func (w *cacheWarmup) run(n uint) {
+	defer log.OnPanic("dnsforward: warming cache")
+
+	wg := &sync.WaitGroup{}
+	warmed := 0
+	for _, domain := range w.stats.TopDomains(n) {
+		if w.exclusions.Has(domain) {
+			continue
+		}
+
+		warmed++
+		for _, qt := range []uint16{dns.TypeA, dns.TypeAAAA} {
+			w.sem <- struct{}{}
+			wg.Add(1)
+
+			go func() {
+				defer log.OnPanic("dnsforward: warming cache")
+				defer wg.Done()
+				defer func() { <-w.sem }()
+
+				w.resolve(domain, qt)
+			}()
+		}
+	}
+
+	wg.Wait()
+
+	log.Info("dnsforward: finished warming cache for %d domains", warmed)
+}
+
+// resolve resolves the request for domain of type qt into the cache.
+func (w *cacheWarmup) resolve(domain string, qt uint16) {
+	req := (&dns.Msg{}).SetQuestion(dns.Fqdn(domain), qt)
+	pctx := &proxy.DNSContext{
+		Req:  req,
+		Addr: warmupAddr,
+	}
+
+	refresh(w.prx, w.cache, newCacheKey(req, netip.Prefix{}), pctx)
+}
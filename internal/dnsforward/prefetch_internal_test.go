@@ -0,0 +1,247 @@
+package dnsforward
+
+import (
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/aghnet"
+	"github.com/AdguardTeam/AdGuardHome/internal/aghtest"
+	"github.com/AdguardTeam/AdGuardHome/internal/filtering"
+	"github.com/AdguardTeam/dnsproxy/proxy"
+	"github.com/AdguardTeam/dnsproxy/upstream"
+	"github.com/AdguardTeam/golibs/testutil"
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testPrefetchAddr is the address returned by the upstream in prefetching
+// tests.
+const testPrefetchAddr = "5.6.7.8"
+
+// newTestPrefetchServer returns a new *Server with the general cache and the
+// upstream answering any A request with [testPrefetchAddr].
+func newTestPrefetchServer(t *testing.T, conf Config) (s *Server) {
+	t.Helper()
+
+	conf.UpstreamMode = UpstreamModeLoadBalance
+	conf.EDNSClientSubnet = &EDNSClientSubnet{
+		Enabled: false,
+	}
+	conf.CacheSize = testCacheSize
+
+	s = createTestServer(t, &filtering.Config{
+		BlockingMode: filtering.BlockingModeDefault,
+	}, ServerConfig{
+		UDPListenAddrs: []*net.UDPAddr{{}},
+		TCPListenAddrs: []*net.TCPAddr{{}},
+		Config:         conf,
+		ServePlainDNS:  true,
+	})
+
+	ups := aghtest.NewUpstreamMock(func(req *dns.Msg) (resp *dns.Msg, err error) {
+		q := req.Question[0]
+		if q.Qtype != dns.TypeA {
+			return (&dns.Msg{}).SetRcode(req, dns.RcodeServerFailure), nil
+		}
+
+		return aghtest.MatchedResponse(req, dns.TypeA, q.Name, testPrefetchAddr), nil
+	})
+	s.dnsProxy.UpstreamConfig.Upstreams = []upstream.Upstream{ups}
+
+	return s
+}
+
+func TestServer_shouldPrefetch(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now()
+
+	testCases := []struct {
+		name      string
+		stored    time.Time
+		hits      uint32
+		threshold uint32
+		enabled   bool
+		want      bool
+	}{{
+		name:      "disabled",
+		stored:    now.Add(-55 * time.Second),
+		hits:      10,
+		threshold: 0,
+		enabled:   false,
+		want:      false,
+	}, {
+		name:      "popular_expiring",
+		stored:    now.Add(-55 * time.Second),
+		hits:      defaultPrefetchMinHits,
+		threshold: 0,
+		enabled:   true,
+		want:      true,
+	}, {
+		name:      "not_popular",
+		stored:    now.Add(-55 * time.Second),
+		hits:      defaultPrefetchMinHits - 1,
+		threshold: 0,
+		enabled:   true,
+		want:      false,
+	}, {
+		name:      "not_expiring",
+		stored:    now.Add(-30 * time.Second),
+		hits:      defaultPrefetchMinHits,
+		threshold: 0,
+		enabled:   true,
+		want:      false,
+	}, {
+		name:      "custom_threshold",
+		stored:    now.Add(-30 * time.Second),
+		hits:      defaultPrefetchMinHits,
+		threshold: 50,
+		enabled:   true,
+		want:      true,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			s := &Server{
+				conf: ServerConfig{
+					Config: Config{
+						CachePrefetch:          tc.enabled,
+						CachePrefetchThreshold: tc.threshold,
+					},
+				},
+			}
+
+			item := &cacheItem{
+				stored: tc.stored,
+				expire: tc.stored.Add(time.Minute),
+			}
+			item.hits.Store(tc.hits)
+
+			assert.Equal(t, tc.want, s.shouldPrefetch(item, now))
+		})
+	}
+}
+
+func TestServer_resolve_prefetch(t *testing.T) {
+	t.Parallel()
+
+	const fqdn = "prefetch.example."
+
+	s := newTestPrefetchServer(t, Config{
+		CachePrefetch:        true,
+		CachePrefetchMinHits: 1,
+	})
+
+	req := createTestMessage(fqdn)
+	key := newCacheKey(req, netip.Prefix{})
+	s.cache.set(key, newTestCacheResp(t, fqdn, 60), testCacheUps, time.Now().Add(-55*time.Second))
+
+	dctx := &dnsContext{
+		proxyCtx: &proxy.DNSContext{
+			Req:   req,
+			Addr:  testClientAddrPort,
+			Proto: proxy.ProtoUDP,
+		},
+		cache: s.cache,
+	}
+
+	err := s.resolve(s.dnsProxy, dctx)
+	require.NoError(t, err)
+
+	resp := dctx.proxyCtx.Res
+	require.NotNil(t, resp)
+	require.Len(t, resp.Answer, 1)
+
+	a := testutil.RequireTypeAssert[*dns.A](t, resp.Answer[0])
+	assert.Equal(t, net.IP{1, 2, 3, 4}, a.A.To4())
+
+	wantAddr := net.ParseIP(testPrefetchAddr).To4()
+	assert.Eventually(t, func() (ok bool) {
+		entries := s.cache.lookup(fqdn, dns.TypeA, time.Now())
+
+		return len(entries) == 1 && entries[0].ttl > 30
+	}, time.Second, 10*time.Millisecond)
+
+	item, _ := s.cache.get(key, time.Now())
+	require.NotNil(t, item)
+	require.Len(t, item.msg.Answer, 1)
+
+	a = testutil.RequireTypeAssert[*dns.A](t, item.msg.Answer[0])
+	assert.Equal(t, wantAddr, a.A.To4())
+}
+
+func TestCacheWarmup_run(t *testing.T) {
+	t.Parallel()
+
+	const (
+		popular  = "popular.example"
+		excluded = "excluded.example"
+		rare     = "rare.example"
+	)
+
+	s := newTestPrefetchServer(t, Config{})
+
+	excl, err := aghnet.NewIgnoreEngine([]string{excluded})
+	require.NoError(t, err)
+
+	w := &cacheWarmup{
+		prx:   s.dnsProxy,
+		cache: s.cache,
+		stats: &testStats{
+			topDomains: []string{popular, excluded, rare},
+		},
+		exclusions: excl,
+		sem:        make(chan struct{}, 1),
+	}
+
+	w.run(2)
+
+	now := time.Now()
+	assert.Len(t, s.cache.lookup(popular+".", 0, now), 1)
+	assert.Empty(t, s.cache.lookup(excluded+".", 0, now))
+	assert.Empty(t, s.cache.lookup(rare+".", 0, now))
+}
+
+func TestServer_startCacheWarmup(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name       string
+		domains    uint32
+		ecsEnabled bool
+		want       bool
+	}{{
+		name:       "enabled",
+		domains:    1,
+		ecsEnabled: false,
+		want:       true,
+	}, {
+		name:       "disabled",
+		domains:    0,
+		ecsEnabled: false,
+		want:       false,
+	}, {
+		name:       "ecs",
+		domains:    1,
+		ecsEnabled: true,
+		want:       false,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			s := newTestPrefetchServer(t, Config{CacheWarmupDomains: tc.domains})
+			s.stats = &testStats{}
+			s.conf.EDNSClientSubnet.Enabled = tc.ecsEnabled
+
+			s.startCacheWarmup()
+			assert.Equal(t, tc.want, s.cacheWarmedUp)
+		})
+	}
+}
@@ -4,11 +4,13 @@ import (
 	"cmp"
 	"context"
 	"encoding/binary"
+	"fmt"
 	"net"
 	"net/netip"
 	"strings"
 	"time"
 
+	"github.com/AdguardTeam/AdGuardHome/internal/client"
 	"github.com/AdguardTeam/AdGuardHome/internal/filtering"
 	"github.com/AdguardTeam/dnsproxy/proxy"
 	"github.com/AdguardTeam/golibs/log"
@@ -38,6 +40,10 @@ type dnsContext struct {
 	// clientID is the ClientID from DoH, DoQ, or DoT, if provided.
 	clientID string
 
+	// allowedDomains are the domains resolved for the persistent client in
+	// [client.ModeAllowlistOnly].
+	allowedDomains []string
+
 	// startTime is the time at which the processing of the request has started.
 	startTime time.Time
 
@@ -59,6 +65,24 @@ type dnsContext struct {
 	// isDHCPHost is true if the request for a local domain name and the DHCP is
 	// available for this request.
 	isDHCPHost bool
+
+	// cache is the cache to use for the request.  It's nil if the response
+	// shouldn't be cached.
+	cache *dnsCache
+
+	// isStale is true if the response has been served from an expired cache
+	// item.
+	isStale bool
+
+	// clientMode is the temporary mode of the persistent client, if any.
+	clientMode client.Mode
+
+	// clientInfo is the information about the persistent client, if any.
+	clientInfo *client.Info
+
+	// dns64Synthesized is true if the response contains the AAAA records
+	// synthesized by DNS64.
+	dns64Synthesized bool
 }
 
 // resultCode is the result of a request processing function.
@@ -101,6 +125,7 @@ func (s *Server) handleDNSRequest(_ *proxy.Proxy, pctx *proxy.DNSContext) error
 		s.processInitial,
 		s.processDDRQuery,
 		s.processDHCPHosts,
+		s.processSynthPTR,
 		s.processDHCPAddrs,
 		s.processFilteringBeforeRequest,
 		s.processUpstream,
@@ -187,6 +212,7 @@ func (s *Server) processInitial(dctx *dnsContext) (rc resultCode) {
 	// Get the client-specific filtering settings.
 	dctx.protectionEnabled, _ = s.UpdatedProtectionStatus()
 	dctx.setts = s.clientRequestFilteringSettings(dctx)
+	s.setClientMode(dctx)
 
 	return resultCodeSuccess
 }
@@ -353,12 +379,12 @@ func (s *Server) processDHCPHosts(dctx *dnsContext) (rc resultCode) {
 		}
 		resp.Answer = append(resp.Answer, a)
 	case dns.TypeAAAA:
-		if s.dns64Pref != (netip.Prefix{}) {
+		if pref, ok := s.dns64PrefFor(dctx); ok {
 			// Respond with DNS64-mapped address for IPv4 host if DNS64 is
 			// enabled.
 			aaaa := &dns.AAAA{
 				Hdr:  s.hdr(req, dns.TypeAAAA),
-				AAAA: s.mapDNS64(ip),
+				AAAA: mapDNS64(pref, ip),
 			}
 			resp.Answer = append(resp.Answer, aaaa)
 		}
@@ -372,7 +398,9 @@ func (s *Server) processDHCPHosts(dctx *dnsContext) (rc resultCode) {
 }
 
 // processDHCPAddrs responds to PTR requests if the target IP is leased by the
-// DHCP server.
+// DHCP server.  The addresses outside of the locally served networks are only
+// resolved for the private clients if [Config.SynthesizePTR] is enabled, and
+// only from the static leases.
 func (s *Server) processDHCPAddrs(dctx *dnsContext) (rc resultCode) {
 	log.Debug("dnsforward: started processing dhcp addrs")
 	defer log.Debug("dnsforward: finished processing dhcp addrs")
@@ -384,25 +412,39 @@ func (s *Server) processDHCPAddrs(dctx *dnsContext) (rc resultCode) {
 
 	req := pctx.Req
 	q := req.Question[0]
-	pref := pctx.RequestedPrivateRDNS
 	// TODO(e.burkov):  Consider answering authoritatively for SOA and NS
 	// queries.
-	if pref == (netip.Prefix{}) || q.Qtype != dns.TypePTR {
+	if q.Qtype != dns.TypePTR {
 		return resultCodeSuccess
 	}
 
-	addr := pref.Addr()
-	host := s.dhcpServer.HostByIP(addr)
+	var addr netip.Addr
+	var host string
+	if pref := pctx.RequestedPrivateRDNS; pref != (netip.Prefix{}) {
+		addr = pref.Addr()
+		host = s.dhcpServer.HostByIP(addr)
+	} else if addr = s.synthPTRAddr(pctx); addr.IsValid() {
+		host = s.dhcpServer.StaticHostByIP(addr)
+	}
+
 	if host == "" {
 		return resultCodeSuccess
 	}
 
 	log.Debug("dnsforward: dhcp client %s is %q", addr, host)
 
-	resp := s.replyCompressed(req)
+	pctx.Res = s.makeDHCPPTRResponse(req, host)
+
+	return resultCodeSuccess
+}
+
+// makeDHCPPTRResponse returns the response to the PTR request req for the
+// address leased to the DHCP client with host.
+func (s *Server) makeDHCPPTRResponse(req *dns.Msg, host string) (resp *dns.Msg) {
+	resp = s.replyCompressed(req)
 	ptr := &dns.PTR{
 		Hdr: dns.RR_Header{
-			Name:   q.Name,
+			Name:   req.Question[0].Name,
 			Rrtype: dns.TypePTR,
 			// TODO(e.burkov):  Use [dhcpsvc.Lease.Expiry].  See
 			// https://github.com/AdguardTeam/AdGuardHome/issues/3932.
@@ -412,7 +454,85 @@ func (s *Server) processDHCPAddrs(dctx *dnsContext) (rc resultCode) {
 		Ptr: dns.Fqdn(strings.Join([]string{host, s.localDomainSuffix}, ".")),
 	}
 	resp.Answer = append(resp.Answer, ptr)
-	pctx.Res = resp
+
+	return resp
+}
+
+// synthPTRAddr returns the address from the PTR request of the private client
+// from pctx, if [Config.SynthesizePTR] is enabled and the DHCP server provides
+// information about clients.  Otherwise, it returns an empty address.
+func (s *Server) synthPTRAddr(pctx *proxy.DNSContext) (addr netip.Addr) {
+	s.serverLock.RLock()
+	enabled := s.conf.SynthesizePTR
+	s.serverLock.RUnlock()
+
+	if !enabled || !pctx.IsPrivateClient || !s.dhcpServer.Enabled() {
+		return netip.Addr{}
+	}
+
+	name := strings.TrimSuffix(pctx.Req.Question[0].Name, ".")
+	addr, err := netutil.IPFromReversedAddr(name)
+	if err != nil {
+		log.Debug("dnsforward: synthesizing ptr for %q: %s", name, err)
+
+		return netip.Addr{}
+	}
+
+	return addr
+}
+
+// processSynthPTR responds to PTR requests with the records synthesized from
+// the rewrites and the $dnsrewrite rules of the custom filtering rules, if
+// [Config.SynthesizePTR] is enabled.  The rewrites take precedence over the
+// rules, see [filtering.DNSFilter.ReversePTR].  Nothing is synthesized if the
+// protection is disabled for the request and for the clients in the
+// allowlist-only mode, unless the address is locally served.  The static DHCP
+// leases are handled by [Server.processDHCPAddrs].
+func (s *Server) processSynthPTR(dctx *dnsContext) (rc resultCode) {
+	log.Debug("dnsforward: started processing ptr synthesis")
+	defer log.Debug("dnsforward: finished processing ptr synthesis")
+
+	pctx := dctx.proxyCtx
+	req := pctx.Req
+	q := req.Question[0]
+	if pctx.Res != nil || q.Qtype != dns.TypePTR || !dctx.protectionEnabled {
+		return resultCodeSuccess
+	}
+
+	isLocal := pctx.RequestedPrivateRDNS != (netip.Prefix{})
+	if dctx.clientMode == client.ModeAllowlistOnly && !isLocal {
+		return resultCodeSuccess
+	}
+
+	s.serverLock.RLock()
+	enabled := s.conf.SynthesizePTR
+	s.serverLock.RUnlock()
+
+	if !enabled {
+		return resultCodeSuccess
+	}
+
+	addr, err := netutil.IPFromReversedAddr(strings.TrimSuffix(q.Name, "."))
+	if err != nil {
+		log.Debug("dnsforward: synthesizing ptr for %q: %s", q.Name, err)
+
+		return resultCodeSuccess
+	}
+
+	res := s.dnsFilter.ReversePTR(addr, dctx.setts)
+	if res.DNSRewriteResult == nil {
+		return resultCodeSuccess
+	}
+
+	err = s.filterDNSRewrite(req, &res, pctx)
+	if err != nil {
+		dctx.err = fmt.Errorf("synthesizing ptr: %w", err)
+
+		return resultCodeError
+	}
+
+	setRewriteTTL(pctx.Res.Answer, res.TTL)
+	dctx.result = &res
 
 	return resultCodeSuccess
 }
@@ -439,6 +559,10 @@ func (s *Server) processFilteringBeforeRequest(dctx *dnsContext) (rc resultCode)
 	s.serverLock.RLock()
 	defer s.serverLock.RUnlock()
 
+	if dctx.clientMode == client.ModeAllowlistOnly && s.filterAllowlistOnly(dctx) {
+		return resultCodeSuccess
+	}
+
 	var err error
 	if dctx.result, err = s.filterDNSRequest(dctx); err != nil {
 		dctx.err = err
@@ -482,7 +606,8 @@ func (s *Server) processUpstream(dctx *dnsContext) (rc resultCode) {
 		return resultCodeFinish
 	}
 
-	s.setCustomUpstream(pctx, dctx.clientID)
+	s.setCustomUpstream(dctx)
+	s.setDNS64PTR(pctx)
 
 	reqWantsDNSSEC := s.setReqAD(req)
 
@@ -494,10 +619,18 @@ func (s *Server) processUpstream(dctx *dnsContext) (rc resultCode) {
 		return resultCodeError
 	}
 
-	if dctx.err = prx.Resolve(pctx); dctx.err != nil {
+	if dctx.err = s.resolve(prx, dctx); dctx.err != nil {
+		s.addUpstreamEDE(pctx, dctx.err)
+
 		return resultCodeError
 	}
 
+	if dctx.isStale {
+		s.addStaleEDE(pctx)
+	}
+
+	s.performDNS64(prx, dctx)
+
 	dctx.responseFromUpstream = true
 	dctx.responseAD = pctx.Res.AuthenticatedData
 
@@ -571,14 +704,17 @@ func (s *Server) dhcpHostFromRequest(q *dns.Question) (reqHost string) {
 	return reqHost[:len(reqHost)-len(s.localDomainSuffix)-1]
 }
 
-// setCustomUpstream sets custom upstream settings in pctx, if necessary.
-func (s *Server) setCustomUpstream(pctx *proxy.DNSContext, clientID string) {
+// setCustomUpstream sets custom upstream settings and the corresponding cache
+// in dctx, if necessary.
+func (s *Server) setCustomUpstream(dctx *dnsContext) {
+	pctx := dctx.proxyCtx
+	dctx.cache = s.cache
 	if !pctx.Addr.IsValid() || s.conf.ClientsContainer == nil {
 		return
 	}
 
 	// Use the ClientID first, since it has a higher priority.
-	id := cmp.Or(clientID, pctx.Addr.Addr().String())
+	id := cmp.Or(dctx.clientID, pctx.Addr.Addr().String())
 	upsConf, err := s.conf.ClientsContainer.UpstreamConfigByID(id, s.bootstrap)
 	if err != nil {
 		log.Error("dnsforward: getting custom upstreams for client %s: %s", id, err)
@@ -586,11 +722,20 @@ func (s *Server) setCustomUpstream(pctx *proxy.DNSContext, clientID string) {
 		return
 	}
 
-	if upsConf != nil {
-		log.Debug("dnsforward: using custom upstreams for client %s", id)
+	if upsConf == nil {
+		return
+	}
+
+	log.Debug("dnsforward: using custom upstreams for client %s", id)
 
-		pctx.CustomUpstreamConfig = upsConf
+	pctx.CustomUpstreamConfig = upsConf
+
+	var info *client.Info
+	if s.conf.ClientInfoProvider != nil {
+		info = s.conf.ClientInfoProvider.ClientInfoByID(id)
 	}
+
+	dctx.cache = s.cacheForUpstreams(info, upsConf)
 }
 
 // Apply filtering logic after we have received response from upstream servers
@@ -616,6 +761,10 @@ func (s *Server) processFilteringAfterResponse(dctx *dnsContext) (rc resultCode)
 		pctx.Req.Question[0], pctx.Res.Question[0] = dctx.origQuestion, dctx.origQuestion
 
 		rr := s.genAnswerCNAME(pctx.Req, res.CanonName)
+		if res.Reason == filtering.Rewritten {
+			setRewriteTTL([]dns.RR{rr}, res.TTL)
+		}
+
 		answer := append([]dns.RR{rr}, pctx.Res.Answer...)
 		pctx.Res.Answer = answer
 
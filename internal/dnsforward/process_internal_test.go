@@ -8,6 +8,7 @@ import (
 	"testing"
 
 	"github.com/AdguardTeam/AdGuardHome/internal/aghtest"
+	"github.com/AdguardTeam/AdGuardHome/internal/client"
 	"github.com/AdguardTeam/AdGuardHome/internal/filtering"
 	"github.com/AdguardTeam/dnsproxy/proxy"
 	"github.com/AdguardTeam/dnsproxy/upstream"
@@ -830,6 +831,259 @@ func TestServer_ProcessUpstream_localPTR(t *testing.T) {
 	})
 }
 
+func TestServer_ProcessSynthPTR(t *testing.T) {
+	const (
+		localDomainSuffix = "lan"
+		rulesText         = "|rule.example^$dnsrewrite=192.168.1.20\n"
+	)
+
+	f, err := filtering.New(&filtering.Config{
+		BlockingMode: filtering.BlockingModeDefault,
+		Rewrites: []*filtering.LegacyRewrite{{
+			Domain: "nas.lan",
+			Answer: "192.168.1.10",
+		}, {
+			Domain: "public.example",
+			Answer: "203.0.113.1",
+			TTL:    60,
+		}},
+	}, []filtering.Filter{{ID: 0, Data: []byte(rulesText)}})
+	require.NoError(t, err)
+
+	var (
+		staticPublicIP  = netip.MustParseAddr("203.0.113.50")
+		dynamicPublicIP = netip.MustParseAddr("203.0.113.60")
+	)
+
+	dhcp := &testDHCP{
+		OnEnabled: func() (_ bool) { return true },
+		OnHostByIP: func(ip netip.Addr) (host string) {
+			switch ip {
+			case
+				netip.MustParseAddr("192.168.1.10"),
+				netip.MustParseAddr("192.168.1.30"),
+				staticPublicIP,
+				dynamicPublicIP:
+				return "lease"
+			default:
+				return ""
+			}
+		},
+		OnStaticHostByIP: func(ip netip.Addr) (host string) {
+			if ip == staticPublicIP {
+				return "lease"
+			}
+
+			return ""
+		},
+	}
+
+	testCases := []struct {
+		name       string
+		host       string
+		wantPTR    string
+		wantReason filtering.Reason
+		clientMode client.Mode
+		wantTTL    uint32
+		enabled    bool
+		isLocalCli bool
+		protected  bool
+	}{{
+		name:       "rewrite_over_lease",
+		host:       "10.1.168.192.in-addr.arpa",
+		wantPTR:    "nas.lan.",
+		wantReason: filtering.Rewritten,
+		clientMode: client.ModeNormal,
+		wantTTL:    0,
+		enabled:    true,
+		isLocalCli: true,
+		protected:  true,
+	}, {
+		name:       "rewrite_public",
+		host:       "1.113.0.203.in-addr.arpa",
+		wantPTR:    "public.example.",
+		wantReason: filtering.Rewritten,
+		clientMode: client.ModeNormal,
+		wantTTL:    60,
+		enabled:    true,
+		isLocalCli: false,
+		protected:  true,
+	}, {
+		name:       "rule",
+		host:       "20.1.168.192.in-addr.arpa",
+		wantPTR:    "rule.example.",
+		wantReason: filtering.RewrittenRule,
+		clientMode: client.ModeNormal,
+		wantTTL:    0,
+		enabled:    true,
+		isLocalCli: true,
+		protected:  true,
+	}, {
+		name:       "lease",
+		host:       "30.1.168.192.in-addr.arpa",
+		wantPTR:    "lease." + localDomainSuffix + ".",
+		wantReason: filtering.NotFilteredNotFound,
+		clientMode: client.ModeNormal,
+		wantTTL:    0,
+		enabled:    true,
+		isLocalCli: true,
+		protected:  true,
+	}, {
+		name:       "lease_static_public",
+		host:       "50.113.0.203.in-addr.arpa",
+		wantPTR:    "lease." + localDomainSuffix + ".",
+		wantReason: filtering.NotFilteredNotFound,
+		clientMode: client.ModeNormal,
+		wantTTL:    0,
+		enabled:    true,
+		isLocalCli: true,
+		protected:  true,
+	}, {
+		name:       "lease_static_public_external_client",
+		host:       "50.113.0.203.in-addr.arpa",
+		wantPTR:    "",
+		wantReason: filtering.NotFilteredNotFound,
+		clientMode: client.ModeNormal,
+		wantTTL:    0,
+		enabled:    true,
+		isLocalCli: false,
+		protected:  true,
+	}, {
+		name:       "lease_static_public_disabled",
+		host:       "50.113.0.203.in-addr.arpa",
+		wantPTR:    "",
+		wantReason: filtering.NotFilteredNotFound,
+		clientMode: client.ModeNormal,
+		wantTTL:    0,
+		enabled:    false,
+		isLocalCli: true,
+		protected:  true,
+	}, {
+		name:       "lease_dynamic_public",
+		host:       "60.113.0.203.in-addr.arpa",
+		wantPTR:    "",
+		wantReason: filtering.NotFilteredNotFound,
+		clientMode: client.ModeNormal,
+		wantTTL:    0,
+		enabled:    true,
+		isLocalCli: true,
+		protected:  true,
+	}, {
+		name:       "unknown",
+		host:       "40.1.168.192.in-addr.arpa",
+		wantPTR:    "",
+		wantReason: filtering.NotFilteredNotFound,
+		clientMode: client.ModeNormal,
+		wantTTL:    0,
+		enabled:    true,
+		isLocalCli: true,
+		protected:  true,
+	}, {
+		name:       "disabled",
+		host:       "20.1.168.192.in-addr.arpa",
+		wantPTR:    "",
+		wantReason: filtering.NotFilteredNotFound,
+		clientMode: client.ModeNormal,
+		wantTTL:    0,
+		enabled:    false,
+		isLocalCli: true,
+		protected:  true,
+	}, {
+		name:       "protection_disabled",
+		host:       "10.1.168.192.in-addr.arpa",
+		wantPTR:    "lease." + localDomainSuffix + ".",
+		wantReason: filtering.NotFilteredNotFound,
+		clientMode: client.ModePaused,
+		wantTTL:    0,
+		enabled:    true,
+		isLocalCli: true,
+		protected:  false,
+	}, {
+		name:       "allowlist_only_public",
+		host:       "1.113.0.203.in-addr.arpa",
+		wantPTR:    "",
+		wantReason: filtering.NotFilteredNotFound,
+		clientMode: client.ModeAllowlistOnly,
+		wantTTL:    0,
+		enabled:    true,
+		isLocalCli: true,
+		protected:  true,
+	}, {
+		name:       "allowlist_only_local",
+		host:       "10.1.168.192.in-addr.arpa",
+		wantPTR:    "nas.lan.",
+		wantReason: filtering.Rewritten,
+		clientMode: client.ModeAllowlistOnly,
+		wantTTL:    0,
+		enabled:    true,
+		isLocalCli: true,
+		protected:  true,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			s := &Server{
+				dnsFilter:         f,
+				dhcpServer:        dhcp,
+				localDomainSuffix: localDomainSuffix,
+				baseLogger:        slogutil.NewDiscardLogger(),
+				conf: ServerConfig{
+					Config: Config{
+						SynthesizePTR: tc.enabled,
+					},
+				},
+			}
+
+			pctx := &proxy.DNSContext{
+				Req:             createTestMessageWithType(dns.Fqdn(tc.host), dns.TypePTR),
+				IsPrivateClient: tc.isLocalCli,
+			}
+
+			addr, addrErr := netutil.IPFromReversedAddr(tc.host)
+			require.NoError(t, addrErr)
+
+			if addr.IsPrivate() {
+				pctx.RequestedPrivateRDNS = netip.PrefixFrom(addr, addr.BitLen())
+			}
+
+			dctx := &dnsContext{
+				proxyCtx:          pctx,
+				result:            &filtering.Result{},
+				protectionEnabled: tc.protected,
+				clientMode:        tc.clientMode,
+				setts: &filtering.Settings{
+					ProtectionEnabled: tc.protected,
+					FilteringEnabled:  true,
+				},
+			}
+
+			rc := s.processSynthPTR(dctx)
+			require.Equal(t, resultCodeSuccess, rc)
+
+			rc = s.processDHCPAddrs(dctx)
+			require.Equal(t, resultCodeSuccess, rc)
+
+			assert.Equal(t, tc.wantReason, dctx.result.Reason)
+
+			if tc.wantPTR == "" {
+				assert.Nil(t, pctx.Res)
+
+				return
+			}
+
+			require.NotNil(t, pctx.Res)
+			require.Len(t, pctx.Res.Answer, 1)
+
+			ptr := testutil.RequireTypeAssert[*dns.PTR](t, pctx.Res.Answer[0])
+			assert.Equal(t, tc.wantPTR, ptr.Ptr)
+
+			if tc.wantTTL != 0 {
+				assert.Equal(t, tc.wantTTL, ptr.Hdr.Ttl)
+			}
+		})
+	}
+}
+
 func TestIPStringFromAddr(t *testing.T) {
 	t.Run("not_nil", func(t *testing.T) {
 		addr := net.UDPAddr{
@@ -0,0 +1,543 @@
+package dnsforward
+
+import (
+	"cmp"
+	"fmt"
+	"net/netip"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/client"
+	"github.com/AdguardTeam/dnsproxy/proxy"
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/AdguardTeam/golibs/log"
+	"github.com/miekg/dns"
+)
+
+// RatelimitAction is the action performed on the requests exceeding the rate
+// limit.
+type RatelimitAction string
+
+// RatelimitAction constants.
+const (
+	// RatelimitActionDrop means that no response is sent.
+	RatelimitActionDrop RatelimitAction = "drop"
+
+	// RatelimitActionRefused means that a REFUSED response is sent.
+	RatelimitActionRefused RatelimitAction = "refused"
+
+	// RatelimitActionTruncate means that an empty response with the TC bit set
+	// is sent, so that a legitimate client retries over TCP.  It's only used
+	// for plain UDP requests, the other ones are refused.
+	RatelimitActionTruncate RatelimitAction = "truncate"
+)
+
+// defaultRatelimitProfileName is the name of the rate limit profile built from
+// the general rate limit settings.
+const defaultRatelimitProfileName = "default"
+
+// RatelimitProfile is a rate limit policy for a group of clients.
+type RatelimitProfile struct {
+	// Name is the unique name of the profile.
+	Name string `yaml:"name"`
+
+	// Action is the action performed on the requests exceeding the limit.  If
+	// empty, [RatelimitActionDrop] is used.
+	Action RatelimitAction `yaml:"action"`
+
+	// Clients are the names of the persistent clients the profile applies to.
+	// The requests from all IDs of such a client share the limit.
+	Clients []string `yaml:"clients"`
+
+	// Tags are the tags of the persistent clients the profile applies to.  The
+	// requests from all IDs of such a client share the limit.
+	Tags []string `yaml:"tags"`
+
+	// Subnets are the subnets the profile applies to.  The limit is applied
+	// to the addresses within them the same way as the general one.
+	Subnets []netip.Prefix `yaml:"subnets"`
+
+	// Rate is the number of requests per second allowed.  It must be
+	// positive.
+	Rate uint32 `yaml:"rate"`
+
+	// Burst is the number of requests allowed to exceed the rate at once.  If
+	// zero, Rate is used.
+	Burst uint32 `yaml:"burst"`
+}
+
+// validate returns an error if p is invalid.
+func (p *RatelimitProfile) validate() (err error) {
+	if p == nil {
+		return errors.ErrNoValue
+	}
+
+	switch {
+	case p.Name == "":
+		return fmt.Errorf("name: %w", errors.ErrEmptyValue)
+	case p.Name == defaultRatelimitProfileName:
+		return fmt.Errorf("name: %q is reserved", p.Name)
+	case p.Rate == 0:
+		return fmt.Errorf("rate: %w", errors.ErrNotPositive)
+	}
+
+	switch p.Action {
+	case "", RatelimitActionDrop, RatelimitActionRefused, RatelimitActionTruncate:
+		// Go on.
+	default:
+		return fmt.Errorf("action: bad value %q", p.Action)
+	}
+
+	for i, subnet := range p.Subnets {
+		if !subnet.IsValid() {
+			return fmt.Errorf("subnets: at index %d: bad subnet", i)
+		}
+	}
+
+	return nil
+}
+
+// validateRatelimitProfiles returns an error if any of profiles is invalid or
+// their names aren't unique.
+func validateRatelimitProfiles(profiles []*RatelimitProfile) (err error) {
+	names := map[string]struct{}{}
+	for i, p := range profiles {
+		err = p.validate()
+		if err != nil {
+			return fmt.Errorf("ratelimit_profiles: at index %d: %w", i, err)
+		}
+
+		if _, ok := names[p.Name]; ok {
+			return fmt.Errorf("ratelimit_profiles: at index %d: duplicate name %q", i, p.Name)
+		}
+
+		names[p.Name] = struct{}{}
+	}
+
+	return nil
+}
+
+// ratelimitProfile is a rate limit profile in use.
+type ratelimitProfile struct {
+	// limited is the number of requests exceeding the limit.
+	limited atomic.Uint64
+
+	// name is the name of the profile.
+	name string
+
+	// action is the action performed on the requests exceeding the limit.
+	action RatelimitAction
+
+	// rate is the number of requests per second allowed.
+	rate float64
+
+	// burst is the maximum number of tokens in a bucket.
+	burst float64
+}
+
+// newRatelimitProfile returns a new *ratelimitProfile.
+func newRatelimitProfile(name string, action RatelimitAction, rate, burst uint32) (p *ratelimitProfile) {
+	return &ratelimitProfile{
+		name:   name,
+		action: cmp.Or(action, RatelimitActionDrop),
+		rate:   float64(rate),
+		burst:  float64(cmp.Or(burst, rate)),
+	}
+}
+
+// ratelimitBucket is the token bucket of a single client.
+type ratelimitBucket struct {
+	// profile is the profile the bucket belongs to.
+	profile *ratelimitProfile
+
+	// last is the time when tokens were updated.
+	last time.Time
+
+	// lastLimited is the time of the last request exceeding the limit.
+	lastLimited time.Time
+
+	// client is the address or the name of the client.
+	client string
+
+	// tokens is the number of requests currently allowed.
+	tokens float64
+
+	// limited is the number of requests exceeding the limit.
+	limited uint64
+}
+
+// allow returns true if the request at now doesn't exceed the limit.
+func (b *ratelimitBucket) allow(now time.Time) (ok bool) {
+	p := b.profile
+	b.tokens = min(p.burst, b.tokens+now.Sub(b.last).Seconds()*p.rate)
+	b.last = now
+
+	if b.tokens >= 1 {
+		b.tokens--
+
+		return true
+	}
+
+	b.limited++
+	b.lastLimited = now
+
+	return false
+}
+
+// subnetRatelimitProfile is a rate limit profile applied to a subnet.
+type subnetRatelimitProfile struct {
+	// profile is the profile itself.
+	profile *ratelimitProfile
+
+	// subnet is the subnet the profile applies to.
+	subnet netip.Prefix
+}
+
+const (
+	// ratelimitSweepIvl is the interval between the removals of the idle
+	// buckets.
+	ratelimitSweepIvl = time.Minute
+
+	// ratelimitActiveIvl is the time during which a client is considered
+	// limited after its last request exceeding the limit.
+	ratelimitActiveIvl = time.Minute
+)
+
+// rateLimiter limits the rate of requests from clients according to the
+// profiles.
+type rateLimiter struct {
+	// mu protects buckets and lastSweep.
+	mu *sync.Mutex
+
+	// buckets are the token buckets by the profile names and client keys.
+	buckets map[string]*ratelimitBucket
+
+	// lastSweep is the time of the last removal of the idle buckets.
+	lastSweep time.Time
+
+	// profiles are all the profiles, starting with the default one, if any.
+	profiles []*ratelimitProfile
+
+	// byClient are the profiles by the names of the persistent clients.
+	byClient map[string]*ratelimitProfile
+
+	// tagged are the profiles with tags in the configuration order along with
+	// their tags.
+	tagged []*taggedRatelimitProfile
+
+	// subnets are the profiles applied to subnets, from the longest to the
+	// shortest one.
+	subnets []*subnetRatelimitProfile
+
+	// defaultProfile is the profile applied to the plain UDP requests from the
+	// other clients.  It's nil if the general rate limit is disabled.
+	defaultProfile *ratelimitProfile
+
+	// allowlist are the sorted addresses never limited.
+	allowlist []netip.Addr
+
+	// subnetLenIPv4 is the length of the IPv4 subnets, which addresses share
+	// the limit.
+	subnetLenIPv4 int
+
+	// subnetLenIPv6 is the length of the IPv6 subnets, which addresses share
+	// the limit.
+	subnetLenIPv6 int
+}
+
+// taggedRatelimitProfile is a rate limit profile applied to the persistent
+// clients having any of its tags.
+type taggedRatelimitProfile struct {
+	// profile is the profile itself.
+	profile *ratelimitProfile
+
+	// tags are the tags of the clients the profile applies to.
+	tags []string
+}
+
+// newRateLimiter returns a new *rateLimiter built from conf.  conf is expected
+// to be valid.
+func newRateLimiter(conf *Config) (l *rateLimiter) {
+	l = &rateLimiter{
+		mu:            &sync.Mutex{},
+		buckets:       map[string]*ratelimitBucket{},
+		byClient:      map[string]*ratelimitProfile{},
+		allowlist:     slices.Clone(conf.RatelimitWhitelist),
+		subnetLenIPv4: conf.RatelimitSubnetLenIPv4,
+		subnetLenIPv6: conf.RatelimitSubnetLenIPv6,
+	}
+
+	for i, addr := range l.allowlist {
+		l.allowlist[i] = addr.Unmap()
+	}
+
+	slices.SortFunc(l.allowlist, netip.Addr.Compare)
+
+	if conf.Ratelimit > 0 {
+		l.defaultProfile = newRatelimitProfile(
+			defaultRatelimitProfileName,
+			RatelimitActionDrop,
+			conf.Ratelimit,
+			0,
+		)
+		l.profiles = append(l.profiles, l.defaultProfile)
+	}
+
+	for _, pc := range conf.RatelimitProfiles {
+		p := newRatelimitProfile(pc.Name, pc.Action, pc.Rate, pc.Burst)
+		l.profiles = append(l.profiles, p)
+
+		for _, name := range pc.Clients {
+			if _, ok := l.byClient[name]; !ok {
+				l.byClient[name] = p
+			}
+		}
+
+		if len(pc.Tags) > 0 {
+			l.tagged = append(l.tagged, &taggedRatelimitProfile{
+				profile: p,
+				tags:    pc.Tags,
+			})
+		}
+
+		for _, subnet := range pc.Subnets {
+			l.subnets = append(l.subnets, &subnetRatelimitProfile{
+				profile: p,
+				subnet:  subnet.Masked(),
+			})
+		}
+	}
+
+	slices.SortStableFunc(l.subnets, func(a, b *subnetRatelimitProfile) (res int) {
+		return cmp.Compare(b.subnet.Bits(), a.subnet.Bits())
+	})
+
+	return l
+}
+
+// profileFor returns the profile applicable to the request from addr sent
+// over proto by the persistent client described by info, if any, as well as the
+// key of the client's bucket.  p is nil if the request isn't limited.
+func (l *rateLimiter) profileFor(
+	addr netip.Addr,
+	info *client.Info,
+	proto proxy.Proto,
+) (p *ratelimitProfile, client string) {
+	addr = addr.Unmap()
+	if _, ok := slices.BinarySearchFunc(l.allowlist, addr, netip.Addr.Compare); ok {
+		return nil, ""
+	}
+
+	if info != nil {
+		if p = l.profileForClient(info); p != nil {
+			return p, info.Name
+		}
+	}
+
+	for _, sp := range l.subnets {
+		if sp.subnet.Contains(addr) {
+			return sp.profile, l.clientSubnet(addr).String()
+		}
+	}
+
+	if proto == proxy.ProtoUDP && l.defaultProfile != nil {
+		// The general rate limit only protects from the amplification attacks.
+		return l.defaultProfile, l.clientSubnet(addr).String()
+	}
+
+	return nil, ""
+}
+
+// profileForClient returns the profile for the persistent client described by
+// info, if any.
+func (l *rateLimiter) profileForClient(info *client.Info) (p *ratelimitProfile) {
+	if p = l.byClient[info.Name]; p != nil {
+		return p
+	}
+
+	for _, tp := range l.tagged {
+		for _, tag := range info.Tags {
+			if slices.Contains(tp.tags, tag) {
+				return tp.profile
+			}
+		}
+	}
+
+	return nil
+}
+
+// clientSubnet returns the subnet of addr sharing the limit.
+func (l *rateLimiter) clientSubnet(addr netip.Addr) (subnet netip.Prefix) {
+	bits := l.subnetLenIPv6
+	if addr.Is4() {
+		bits = l.subnetLenIPv4
+	}
+
+	subnet, _ = addr.Prefix(bits)
+
+	return subnet
+}
+
+// isLimited returns the profile which limit the request from addr sent over
+// proto by the persistent client described by info exceeds at now, if any.
+func (l *rateLimiter) isLimited(
+	addr netip.Addr,
+	info *client.Info,
+	proto proxy.Proto,
+	now time.Time,
+) (p *ratelimitProfile) {
+	p, client := l.profileFor(addr, info, proto)
+	if p == nil {
+		return nil
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.sweep(now)
+
+	key := p.name + "|" + client
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &ratelimitBucket{
+			profile: p,
+			last:    now,
+			client:  client,
+			tokens:  p.burst,
+		}
+		l.buckets[key] = b
+	}
+
+	if b.allow(now) {
+		return nil
+	}
+
+	p.limited.Add(1)
+
+	return p
+}
+
+// sweep removes the idle buckets, which are full and so equal to the new
+// ones.  l.mu is expected to be locked.
+func (l *rateLimiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < ratelimitSweepIvl {
+		return
+	}
+
+	l.lastSweep = now
+	for key, b := range l.buckets {
+		idle := now.Sub(b.last)
+		if idle >= ratelimitSweepIvl && b.tokens+idle.Seconds()*b.profile.rate >= b.profile.burst {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// limitedClient is the information about a client which requests have recently
+// exceeded the limit.
+type limitedClient struct {
+	// lastLimited is the time of the last request exceeding the limit.
+	lastLimited time.Time
+
+	// client is the address or the name of the client.
+	client string
+
+	// profile is the name of the profile.
+	profile string
+
+	// limited is the number of requests exceeding the limit.
+	limited uint64
+}
+
+// limitedClients returns the clients which requests have exceeded the limit
+// within [ratelimitActiveIvl] before now, sorted by the number of such
+// requests.
+func (l *rateLimiter) limitedClients(now time.Time) (clients []*limitedClient) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for _, b := range l.buckets {
+		if b.limited == 0 || now.Sub(b.lastLimited) > ratelimitActiveIvl {
+			continue
+		}
+
+		clients = append(clients, &limitedClient{
+			lastLimited: b.lastLimited,
+			client:      b.client,
+			profile:     b.profile.name,
+			limited:     b.limited,
+		})
+	}
+
+	slices.SortFunc(clients, func(a, b *limitedClient) (res int) {
+		return cmp.Or(cmp.Compare(b.limited, a.limited), cmp.Compare(a.client, b.client))
+	})
+
+	return clients
+}
+
+// prepareRatelimit validates the rate limit configuration and initializes the
+// rate limiter of s.
+func (s *Server) prepareRatelimit() (err error) {
+	err = validateRatelimitProfiles(s.conf.RatelimitProfiles)
+	if err != nil {
+		return fmt.Errorf("checking rate limit: %w", err)
+	}
+
+	s.ratelimiter = nil
+	if s.conf.Ratelimit > 0 || len(s.conf.RatelimitProfiles) > 0 {
+		s.ratelimiter = newRateLimiter(&s.conf.Config)
+	}
+
+	return nil
+}
+
+// errRatelimited is returned when a request exceeds the rate limit.
+const errRatelimited errors.Error = "rate limit exceeded"
+
+// checkRatelimit returns an error for [proxy.BeforeRequestHandler] if the
+// request from pctx sent by the client with clientID exceeds its rate limit.
+func (s *Server) checkRatelimit(pctx *proxy.DNSContext, clientID string) (err error) {
+	l := s.ratelimiter
+	if l == nil {
+		return nil
+	}
+
+	addr := pctx.Addr.Addr()
+
+	var info *client.Info
+	if s.conf.ClientInfoProvider != nil && (len(l.byClient) > 0 || len(l.tagged) > 0) {
+		info = s.conf.ClientInfoProvider.ClientInfoByID(cmp.Or(clientID, addr.String()))
+	}
+
+	p := l.isLimited(addr, info, pctx.Proto, time.Now())
+	if p == nil {
+		return nil
+	}
+
+	log.Debug("dnsforward: request from %s is ratelimited by profile %q", addr, p.name)
+
+	var resp *dns.Msg
+	switch p.action {
+	case RatelimitActionDrop:
+		return errRatelimited
+	case RatelimitActionTruncate:
+		if pctx.Proto == proxy.ProtoUDP {
+			resp = (&dns.Msg{}).SetReply(pctx.Req)
+			resp.Truncated = true
+
+			break
+		}
+
+		resp = s.makeResponseREFUSED(pctx.Req)
+	default:
+		resp = s.makeResponseREFUSED(pctx.Req)
+	}
+
+	return &proxy.BeforeRequestError{
+		Err:      errRatelimited,
+		Response: resp,
+	}
+}
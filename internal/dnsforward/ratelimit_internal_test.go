@@ -0,0 +1,368 @@
+package dnsforward
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/client"
+	"github.com/AdguardTeam/AdGuardHome/internal/filtering"
+	"github.com/AdguardTeam/dnsproxy/proxy"
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/AdguardTeam/golibs/testutil"
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateRatelimitProfiles(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name       string
+		wantErrMsg string
+		profiles   []*RatelimitProfile
+	}{{
+		name:       "valid",
+		wantErrMsg: "",
+		profiles: []*RatelimitProfile{{
+			Name:   "iot",
+			Action: RatelimitActionTruncate,
+			Rate:   5,
+		}, {
+			Name: "guest",
+			Rate: 50,
+		}},
+	}, {
+		name:       "nil",
+		wantErrMsg: "ratelimit_profiles: at index 0: no value",
+		profiles:   []*RatelimitProfile{nil},
+	}, {
+		name:       "no_name",
+		wantErrMsg: "ratelimit_profiles: at index 0: name: empty value",
+		profiles: []*RatelimitProfile{{
+			Rate: 5,
+		}},
+	}, {
+		name:       "reserved_name",
+		wantErrMsg: `ratelimit_profiles: at index 0: name: "default" is reserved`,
+		profiles: []*RatelimitProfile{{
+			Name: defaultRatelimitProfileName,
+			Rate: 5,
+		}},
+	}, {
+		name:       "no_rate",
+		wantErrMsg: "ratelimit_profiles: at index 0: rate: not positive",
+		profiles: []*RatelimitProfile{{
+			Name: "iot",
+		}},
+	}, {
+		name:       "bad_action",
+		wantErrMsg: `ratelimit_profiles: at index 0: action: bad value "block"`,
+		profiles: []*RatelimitProfile{{
+			Name:   "iot",
+			Action: "block",
+			Rate:   5,
+		}},
+	}, {
+		name:       "duplicate",
+		wantErrMsg: `ratelimit_profiles: at index 1: duplicate name "iot"`,
+		profiles: []*RatelimitProfile{{
+			Name: "iot",
+			Rate: 5,
+		}, {
+			Name: "iot",
+			Rate: 10,
+		}},
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			err := validateRatelimitProfiles(tc.profiles)
+			testutil.AssertErrorMsg(t, tc.wantErrMsg, err)
+		})
+	}
+}
+
+func TestRateLimiter_isLimited(t *testing.T) {
+	t.Parallel()
+
+	var (
+		allowedAddr = netip.MustParseAddr("192.0.2.1")
+		guestAddr   = netip.MustParseAddr("192.0.2.130")
+		otherAddr   = netip.MustParseAddr("198.51.100.1")
+	)
+
+	conf := &Config{
+		Ratelimit:              2,
+		RatelimitSubnetLenIPv4: 24,
+		RatelimitSubnetLenIPv6: 56,
+		RatelimitWhitelist:     []netip.Addr{allowedAddr},
+		RatelimitProfiles: []*RatelimitProfile{{
+			Name:    "iot",
+			Action:  RatelimitActionRefused,
+			Clients: []string{"camera"},
+			Tags:    []string{"device_camera"},
+			Rate:    1,
+		}, {
+			Name:    "guest",
+			Subnets: []netip.Prefix{netip.MustParsePrefix("192.0.2.128/25")},
+			Rate:    1,
+			Burst:   3,
+		}},
+	}
+
+	testCases := []struct {
+		info        *client.Info
+		addr        netip.Addr
+		name        string
+		wantProfile string
+		proto       proxy.Proto
+		allowed     int
+	}{{
+		info:        nil,
+		addr:        allowedAddr,
+		name:        "allowlist",
+		wantProfile: "",
+		proto:       proxy.ProtoUDP,
+		allowed:     10,
+	}, {
+		info: &client.Info{
+			Name: "camera",
+		},
+		addr:        guestAddr,
+		name:        "client",
+		wantProfile: "iot",
+		proto:       proxy.ProtoTCP,
+		allowed:     1,
+	}, {
+		info: &client.Info{
+			Name: "doorbell",
+			Tags: []string{"device_other", "device_camera"},
+		},
+		addr:        guestAddr,
+		name:        "tag",
+		wantProfile: "iot",
+		proto:       proxy.ProtoHTTPS,
+		allowed:     1,
+	}, {
+		info: &client.Info{
+			Name: "laptop",
+		},
+		addr:        guestAddr,
+		name:        "subnet",
+		wantProfile: "guest",
+		proto:       proxy.ProtoTCP,
+		allowed:     3,
+	}, {
+		info:        nil,
+		addr:        otherAddr,
+		name:        "default_udp",
+		wantProfile: defaultRatelimitProfileName,
+		proto:       proxy.ProtoUDP,
+		allowed:     2,
+	}, {
+		info:        nil,
+		addr:        otherAddr,
+		name:        "default_tcp",
+		wantProfile: "",
+		proto:       proxy.ProtoTCP,
+		allowed:     10,
+	}}
+
+	now := time.Now()
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			l := newRateLimiter(conf)
+
+			for range tc.allowed {
+				require.Nil(t, l.isLimited(tc.addr, tc.info, tc.proto, now))
+			}
+
+			p := l.isLimited(tc.addr, tc.info, tc.proto, now)
+			if tc.wantProfile == "" {
+				assert.Nil(t, p)
+
+				return
+			}
+
+			require.NotNil(t, p)
+
+			assert.Equal(t, tc.wantProfile, p.name)
+			assert.Equal(t, uint64(1), p.limited.Load())
+
+			// The tokens are refilled over time.
+			assert.Nil(t, l.isLimited(tc.addr, tc.info, tc.proto, now.Add(time.Second)))
+
+			limited := l.limitedClients(now.Add(time.Second))
+			require.Len(t, limited, 1)
+
+			assert.Equal(t, tc.wantProfile, limited[0].profile)
+			assert.Empty(t, l.limitedClients(now.Add(time.Hour)))
+		})
+	}
+}
+
+func TestServer_checkRatelimit(t *testing.T) {
+	t.Parallel()
+
+	const fqdn = "limited.example."
+
+	testCases := []struct {
+		name      string
+		action    RatelimitAction
+		proto     proxy.Proto
+		wantRcode int
+		wantTC    bool
+		wantResp  bool
+	}{{
+		name:      "drop",
+		action:    RatelimitActionDrop,
+		proto:     proxy.ProtoUDP,
+		wantRcode: 0,
+		wantTC:    false,
+		wantResp:  false,
+	}, {
+		name:      "refused",
+		action:    RatelimitActionRefused,
+		proto:     proxy.ProtoUDP,
+		wantRcode: dns.RcodeRefused,
+		wantTC:    false,
+		wantResp:  true,
+	}, {
+		name:      "truncate_udp",
+		action:    RatelimitActionTruncate,
+		proto:     proxy.ProtoUDP,
+		wantRcode: dns.RcodeSuccess,
+		wantTC:    true,
+		wantResp:  true,
+	}, {
+		name:      "truncate_tcp",
+		action:    RatelimitActionTruncate,
+		proto:     proxy.ProtoTCP,
+		wantRcode: dns.RcodeRefused,
+		wantTC:    false,
+		wantResp:  true,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			s := createTestServer(t, &filtering.Config{
+				BlockingMode: filtering.BlockingModeDefault,
+			}, ServerConfig{
+				UDPListenAddrs: []*net.UDPAddr{{}},
+				TCPListenAddrs: []*net.TCPAddr{{}},
+				Config: Config{
+					UpstreamMode: UpstreamModeLoadBalance,
+					EDNSClientSubnet: &EDNSClientSubnet{
+						Enabled: false,
+					},
+					RatelimitProfiles: []*RatelimitProfile{{
+						Name:    "limited",
+						Action:  tc.action,
+						Clients: []string{"client"},
+						Rate:    1,
+					}},
+					ClientInfoProvider: &fakeClientsContainer{
+						OnClientInfoByID: func(id string) (info *client.Info) {
+							return &client.Info{
+								Name: "client",
+							}
+						},
+					},
+				},
+				ServePlainDNS: true,
+			})
+
+			pctx := &proxy.DNSContext{
+				Req:   createTestMessage(fqdn),
+				Addr:  testClientAddrPort,
+				Proto: tc.proto,
+			}
+
+			require.NoError(t, s.checkRatelimit(pctx, ""))
+
+			err := s.checkRatelimit(pctx, "")
+			require.ErrorIs(t, err, errRatelimited)
+
+			befReqErr := &proxy.BeforeRequestError{}
+			if !tc.wantResp {
+				assert.False(t, errors.As(err, &befReqErr))
+
+				return
+			}
+
+			require.ErrorAs(t, err, &befReqErr)
+
+			resp := befReqErr.Response
+			require.NotNil(t, resp)
+
+			assert.Equal(t, tc.wantRcode, resp.Rcode)
+			assert.Equal(t, tc.wantTC, resp.Truncated)
+		})
+	}
+}
+
+func TestServer_HandleRatelimitStatus(t *testing.T) {
+	t.Parallel()
+
+	s := createTestServer(t, &filtering.Config{
+		BlockingMode: filtering.BlockingModeDefault,
+	}, ServerConfig{
+		UDPListenAddrs: []*net.UDPAddr{{}},
+		TCPListenAddrs: []*net.TCPAddr{{}},
+		Config: Config{
+			UpstreamMode: UpstreamModeLoadBalance,
+			EDNSClientSubnet: &EDNSClientSubnet{
+				Enabled: false,
+			},
+			Ratelimit:              1,
+			RatelimitSubnetLenIPv4: 24,
+			RatelimitSubnetLenIPv6: 56,
+		},
+		ServePlainDNS: true,
+	})
+
+	pctx := &proxy.DNSContext{
+		Req:   createTestMessage("status.example."),
+		Addr:  testClientAddrPort,
+		Proto: proxy.ProtoUDP,
+	}
+
+	for range 3 {
+		_ = s.checkRatelimit(pctx, "")
+	}
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/control/ratelimit/status", nil)
+
+	s.handleRatelimitStatus(w, r)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	resp := &ratelimitStatusResp{}
+	err := json.NewDecoder(w.Body).Decode(resp)
+	require.NoError(t, err)
+
+	assert.Equal(t, []*ratelimitProfileJSON{{
+		Name:    defaultRatelimitProfileName,
+		Action:  RatelimitActionDrop,
+		Limited: 2,
+	}}, resp.Profiles)
+
+	require.Len(t, resp.LimitedClients, 1)
+
+	c := resp.LimitedClients[0]
+	assert.Equal(t, "1.2.3.0/24", c.Client)
+	assert.Equal(t, defaultRatelimitProfileName, c.Profile)
+	assert.Equal(t, uint64(2), c.Limited)
+}
@@ -0,0 +1,85 @@
+package dnsforward
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/aghhttp"
+)
+
+// ratelimitProfileJSON is the JSON representation of the counters of a rate
+// limit profile.
+type ratelimitProfileJSON struct {
+	// Name is the name of the profile.
+	Name string `json:"name"`
+
+	// Action is the action performed on the requests exceeding the limit.
+	Action RatelimitAction `json:"action"`
+
+	// Limited is the number of requests exceeding the limit.
+	Limited uint64 `json:"limited"`
+}
+
+// limitedClientJSON is the JSON representation of a client which requests
+// have recently exceeded the limit.
+type limitedClientJSON struct {
+	// LastLimited is the time of the last request exceeding the limit.
+	LastLimited time.Time `json:"last_limited"`
+
+	// Client is the subnet or the name of the persistent client.
+	Client string `json:"client"`
+
+	// Profile is the name of the profile.
+	Profile string `json:"profile"`
+
+	// Limited is the number of requests exceeding the limit.
+	Limited uint64 `json:"limited"`
+}
+
+// ratelimitStatusResp is the response to the GET /control/ratelimit/status
+// HTTP API.
+type ratelimitStatusResp struct {
+	Profiles       []*ratelimitProfileJSON `json:"profiles"`
+	LimitedClients []*limitedClientJSON    `json:"limited_clients"`
+}
+
+// handleRatelimitStatus is the handler for the GET /control/ratelimit/status
+// HTTP API.
+func (s *Server) handleRatelimitStatus(w http.ResponseWriter, r *http.Request) {
+	resp := &ratelimitStatusResp{
+		Profiles:       []*ratelimitProfileJSON{},
+		LimitedClients: []*limitedClientJSON{},
+	}
+
+	l := func() (l *rateLimiter) {
+		s.serverLock.RLock()
+		defer s.serverLock.RUnlock()
+
+		return s.ratelimiter
+	}()
+
+	if l == nil {
+		aghhttp.WriteJSONResponseOK(w, r, resp)
+
+		return
+	}
+
+	for _, p := range l.profiles {
+		resp.Profiles = append(resp.Profiles, &ratelimitProfileJSON{
+			Name:    p.name,
+			Action:  p.action,
+			Limited: p.limited.Load(),
+		})
+	}
+
+	for _, c := range l.limitedClients(time.Now()) {
+		resp.LimitedClients = append(resp.LimitedClients, &limitedClientJSON{
+			LastLimited: c.lastLimited,
+			Client:      c.client,
+			Profile:     c.profile,
+			Limited:     c.limited,
+		})
+	}
+
+	aghhttp.WriteJSONResponseOK(w, r, resp)
+}
@@ -0,0 +1,418 @@
+package dnsforward
+
+import (
+	"fmt"
+	"net/netip"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/aghnet"
+	"github.com/AdguardTeam/AdGuardHome/internal/client"
+	"github.com/AdguardTeam/dnsproxy/proxy"
+	"github.com/AdguardTeam/golibs/log"
+	"github.com/AdguardTeam/golibs/netutil"
+	"github.com/miekg/dns"
+)
+
+// defaultStaleAnswerTTL is the default TTL of the responses served from the
+// expired cache items.  It's the value recommended by RFC 8767.
+//
+// See https://datatracker.ietf.org/doc/html/rfc8767#section-4.
+const defaultStaleAnswerTTL = 30 * time.Second
+
+// clientCache is the DNS cache of a persistent client with custom upstreams.
+type clientCache struct {
+	// cache is the cache itself.  It must not be nil.
+	cache *dnsCache
+
+	// upstreams is the upstream configuration the cached responses have been
+	// resolved with.
+	upstreams *proxy.CustomUpstreamConfig
+
+	// name is the name of the client.
+	name string
+}
+
+// clientCaches are the DNS caches of the persistent clients.
+type clientCaches struct {
+	// mu protects caches.
+	mu *sync.Mutex
+
+	// caches are the caches by the clients' unique identifiers.
+	caches map[client.UID]*clientCache
+}
+
+// newClientCaches returns a new properly initialized *clientCaches.
+func newClientCaches() (cc *clientCaches) {
+	return &clientCaches{
+		mu:     &sync.Mutex{},
+		caches: map[client.UID]*clientCache{},
+	}
+}
+
+// prepareCacheConfig prepares the caching of the responses of the main proxy
+// and returns an error if there is one.  The responses are cached by s itself,
+// so the proxy's own cache is disabled.
+func (s *Server) prepareCacheConfig(conf *proxy.Config) (err error) {
+	srvConf := s.conf
+
+	err = validateCacheTTL(srvConf.CacheMinTTL, srvConf.CacheMaxTTL)
+	if err != nil {
+		return fmt.Errorf("validating cache ttl: %w", err)
+	}
+
+	conf.CacheEnabled = false
+
+	s.staleExclusions, err = aghnet.NewIgnoreEngine(srvConf.CacheOptimisticExclusions)
+	if err != nil {
+		return fmt.Errorf("cache_optimistic_exclusions: %w", err)
+	}
+
+	err = s.preparePrefetch()
+	if err != nil {
+		// Don't wrap the error, because it's informative enough as is.
+		return err
+	}
+
+	s.cache = nil
+	if srvConf.CacheSize != 0 {
+		s.cache = s.newDNSCache(srvConf.CacheSize)
+	}
+
+	s.clientCaches = newClientCaches()
+
+	return nil
+}
+
+// newDNSCache returns a new DNS cache of size bytes configured according to
+// s's configuration.
+func (s *Server) newDNSCache(size uint32) (c *dnsCache) {
+	return newDNSCache(&dnsCacheConfig{
+		MaxSize:     int(size),
+		StaleMaxAge: s.conf.CacheOptimisticMaxAge.Duration,
+		MinTTL:      s.conf.CacheMinTTL,
+		MaxTTL:      s.conf.CacheMaxTTL,
+		Optimistic:  s.conf.CacheOptimistic,
+	})
+}
+
+// cacheForUpstreams returns the cache for the responses resolved with the
+// custom upstream configuration ups of the persistent client described by info.
+// c is nil if such responses shouldn't be cached.
+func (s *Server) cacheForUpstreams(
+	info *client.Info,
+	ups *proxy.CustomUpstreamConfig,
+) (c *dnsCache) {
+	if info == nil || info.CacheSize == 0 {
+		return nil
+	}
+
+	ccs := s.clientCaches
+	ccs.mu.Lock()
+	defer ccs.mu.Unlock()
+
+	cc, ok := ccs.caches[info.UID]
+	if !ok || cc.upstreams != ups {
+		// The client's upstreams have changed, so the previously cached
+		// responses are no longer valid.
+		cc = &clientCache{
+			cache:     s.newDNSCache(info.CacheSize),
+			upstreams: ups,
+		}
+		ccs.caches[info.UID] = cc
+	}
+
+	cc.name = info.Name
+
+	return cc.cache
+}
+
+// RemoveClientCache removes the DNS cache of the persistent client with uid, if
+// any.  It should be called when the client is removed or renamed, so that the
+// caches of the removed clients don't occupy the memory.
+func (s *Server) RemoveClientCache(uid client.UID) {
+	s.serverLock.RLock()
+	defer s.serverLock.RUnlock()
+
+	ccs := s.clientCaches
+	if ccs == nil {
+		return
+	}
+
+	ccs.mu.Lock()
+	defer ccs.mu.Unlock()
+
+	delete(ccs.caches, uid)
+}
+
+// cacheSubnet returns the subnet of the client for the cache key of the
+// responses to pctx.  It mirrors the way the proxy chooses the EDNS Client
+// Subnet option for the upstream request.
+func (s *Server) cacheSubnet(pctx *proxy.DNSContext) (subnet netip.Prefix) {
+	const (
+		// ecsBitsIPv4 is the length of the subnet for IPv4 addresses.
+		ecsBitsIPv4 = 24
+
+		// ecsBitsIPv6 is the length of the subnet for IPv6 addresses.
+		ecsBitsIPv6 = 56
+	)
+
+	ecsConf := s.conf.EDNSClientSubnet
+	if !ecsConf.Enabled {
+		return netip.Prefix{}
+	}
+
+	if subnet = ecsFromReq(pctx.Req); subnet.Bits() > 0 {
+		return subnet.Masked()
+	}
+
+	addr := pctx.Addr.Addr()
+	if ecsConf.UseCustom {
+		addr = ecsConf.CustomIP
+	}
+
+	addr = addr.Unmap()
+	if !addr.IsValid() || netutil.IsSpecialPurpose(addr) {
+		return netip.Prefix{}
+	}
+
+	bits := ecsBitsIPv6
+	if addr.Is4() {
+		bits = ecsBitsIPv4
+	}
+
+	subnet, _ = addr.Prefix(bits)
+
+	return subnet
+}
+
+// ecsFromReq returns the subnet from the EDNS Client Subnet option of req, if
+// any.
+func ecsFromReq(req *dns.Msg) (subnet netip.Prefix) {
+	opt := req.IsEdns0()
+	if opt == nil {
+		return netip.Prefix{}
+	}
+
+	for _, o := range opt.Option {
+		ecs, ok := o.(*dns.EDNS0_SUBNET)
+		if !ok {
+			continue
+		}
+
+		addr, ok := netip.AddrFromSlice(ecs.Address)
+		if !ok {
+			return netip.Prefix{}
+		}
+
+		return netip.PrefixFrom(addr.Unmap(), int(ecs.SourceNetmask))
+	}
+
+	return netip.Prefix{}
+}
+
+// resolve resolves the request from dctx using prx and the cache of dctx, if
+// any.
+func (s *Server) resolve(prx *proxy.Proxy, dctx *dnsContext) (err error) {
+	pctx := dctx.proxyCtx
+	c := dctx.cache
+	if c == nil || pctx.RequestedPrivateRDNS != (netip.Prefix{}) || pctx.Req.CheckingDisabled {
+		// Don't cache the responses from local upstreams, since those should
+		// be fast enough, and the responses with DNSSEC checking disabled,
+		// since those may differ from the validated ones.
+		return prx.Resolve(pctx)
+	}
+
+	key := newCacheKey(pctx.Req, s.cacheSubnet(pctx))
+	now := time.Now()
+	item, expired := c.get(key, now)
+	switch {
+	case item == nil:
+		return s.resolveAndCache(prx, c, key, pctx)
+	case !expired:
+		s.replyFromCache(pctx, item, item.ttl(now))
+		s.prefetch(prx, c, key, item, pctx, now)
+
+		return nil
+	case s.staleExclusions.Has(strings.TrimSuffix(key.name, ".")):
+		log.Debug("dnsforward: not serving stale response for excluded %q", key.name)
+
+		return s.resolveAndCache(prx, c, key, pctx)
+	default:
+		return s.resolveStale(prx, dctx, c, key, item)
+	}
+}
+
+// resolveAndCache resolves the request from pctx using prx and caches the
+// response in c.
+func (s *Server) resolveAndCache(
+	prx *proxy.Proxy,
+	c *dnsCache,
+	key cacheKey,
+	pctx *proxy.DNSContext,
+) (err error) {
+	err = prx.Resolve(pctx)
+	if err != nil {
+		// Don't wrap the error since it's informative enough as is.
+		return err
+	}
+
+	c.set(key, pctx.Res, upstreamAddr(pctx), time.Now())
+
+	return nil
+}
+
+// resolveStale handles the request from dctx which expired cached item has
+// been found in c.  It starts an update of the item and waits for it for the
+// configured client response timeout.  If the update isn't successful within
+// the timeout, the expired item is served.
+//
+// See https://datatracker.ietf.org/doc/html/rfc8767#section-5.
+func (s *Server) resolveStale(
+	prx *proxy.Proxy,
+	dctx *dnsContext,
+	c *dnsCache,
+	key cacheKey,
+	item *cacheItem,
+) (err error) {
+	pctx := dctx.proxyCtx
+	r := s.refreshCached(prx, c, key, pctx)
+
+	if timeout := s.conf.CacheOptimisticClientTimeout.Duration; timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+
+		select {
+		case <-r.done:
+			now := time.Now()
+			if updated, expired := c.get(key, now); updated != nil && !expired {
+				s.replyFromCache(pctx, updated, updated.ttl(now))
+
+				return nil
+			}
+		case <-timer.C:
+			// Go on and serve the expired item.
+		}
+	}
+
+	ttl := uint32(s.conf.CacheOptimisticAnswerTTL.Duration / time.Second)
+	if ttl == 0 {
+		ttl = uint32(defaultStaleAnswerTTL / time.Second)
+	}
+
+	s.replyFromCache(pctx, item, ttl)
+	dctx.isStale = true
+	c.staleServed.Add(1)
+
+	return nil
+}
+
+// refreshCached starts an update of the cached item for key in c using the
+// request from pctx, unless it's already in progress.  The update isn't stored
+// if it's failed, so that the expired item is still served.
+func (s *Server) refreshCached(
+	prx *proxy.Proxy,
+	c *dnsCache,
+	key cacheKey,
+	pctx *proxy.DNSContext,
+) (r *cacheRefresh) {
+	r, started := c.startRefresh(key)
+	if !started {
+		return r
+	}
+
+	clone := cloneForRefresh(pctx)
+
+	go func() {
+		defer log.OnPanic("dnsforward: refreshing cache")
+		defer c.finishRefresh(key)
+
+		refresh(prx, c, key, clone)
+	}()
+
+	return r
+}
+
+// cloneForRefresh returns a reduced clone of pctx to resolve the request from it
+// in background without data races.  The protocol isn't set, since the
+// response mustn't be truncated.
+func cloneForRefresh(pctx *proxy.DNSContext) (clone *proxy.DNSContext) {
+	return &proxy.DNSContext{
+		Req:                  pctx.Req.Copy(),
+		Addr:                 pctx.Addr,
+		CustomUpstreamConfig: pctx.CustomUpstreamConfig,
+		IsPrivateClient:      pctx.IsPrivateClient,
+	}
+}
+
+// refresh resolves the request from pctx using prx and stores the response in
+// c under key, unless it's failed.
+func refresh(prx *proxy.Proxy, c *dnsCache, key cacheKey, pctx *proxy.DNSContext) {
+	err := prx.Resolve(pctx)
+	if err != nil {
+		log.Debug("dnsforward: refreshing cached %q: %s", key.name, err)
+
+		return
+	} else if pctx.Res.Rcode == dns.RcodeServerFailure {
+		log.Debug("dnsforward: refreshing cached %q: got servfail", key.name)
+
+		return
+	}
+
+	c.set(key, pctx.Res, upstreamAddr(pctx), time.Now())
+}
+
+// replyFromCache sets the response to the request from pctx constructed from
+// the cached item with all the TTLs set to ttl.
+func (s *Server) replyFromCache(pctx *proxy.DNSContext, item *cacheItem, ttl uint32) {
+	req := pctx.Req
+
+	resp := item.reply(req, ttl)
+	if reqOpt := req.IsEdns0(); reqOpt != nil {
+		resp.SetEdns0(reqOpt.UDPSize(), reqOpt.Do())
+	}
+
+	size := dns.MaxMsgSize
+	if pctx.Proto == proxy.ProtoUDP {
+		size = dns.MinMsgSize
+		if reqOpt := req.IsEdns0(); reqOpt != nil {
+			size = max(size, int(reqOpt.UDPSize()))
+		}
+	}
+
+	resp.Truncate(size)
+	resp.Compress = true
+
+	pctx.Res = resp
+	pctx.CachedUpstreamAddr = item.upstream
+}
+
+// upstreamAddr returns the address of the upstream which has resolved the
+// request from pctx, if any.
+func upstreamAddr(pctx *proxy.DNSContext) (addr string) {
+	if pctx.Upstream == nil {
+		return ""
+	}
+
+	return pctx.Upstream.Address()
+}
+
+// clearCache removes all the cached responses.
+func (s *Server) clearCache() {
+	if s.cache != nil {
+		s.cache.clear()
+	}
+
+	ccs := s.clientCaches
+	if ccs == nil {
+		return
+	}
+
+	ccs.mu.Lock()
+	defer ccs.mu.Unlock()
+
+	for _, cc := range ccs.caches {
+		cc.cache.clear()
+	}
+}
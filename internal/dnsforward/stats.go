@@ -105,6 +105,7 @@ func (s *Server) logQuery(dctx *dnsContext, ip net.IP, processingTime time.Durat
 		ClientIP:          ip,
 		Elapsed:           processingTime,
 		AuthenticatedData: dctx.responseAD,
+		DNS64:             dctx.dns64Synthesized,
 	}
 
 	switch pctx.Proto {
@@ -139,6 +140,7 @@ func (s *Server) updateStats(dctx *dnsContext, clientIP string, processingTime t
 		Result:         stats.RNotFiltered,
 		ProcessingTime: processingTime,
 		UpstreamTime:   pctx.QueryDuration,
+		Stale:          dctx.isStale,
 	}
 
 	if pctx.Upstream != nil {
@@ -161,7 +163,8 @@ func (s *Server) updateStats(dctx *dnsContext, clientIP string, processingTime t
 	case
 		filtering.FilteredBlockList,
 		filtering.FilteredInvalid,
-		filtering.FilteredBlockedService:
+		filtering.FilteredBlockedService,
+		filtering.FilteredAllowlistOnly:
 		e.Result = stats.RFiltered
 	}
 
@@ -43,6 +43,8 @@ type testStats struct {
 	stats.Interface
 
 	lastEntry *stats.Entry
+
+	topDomains []string
 }
 
 // Update implements the [stats.Interface] interface for *testStats.
@@ -59,6 +61,11 @@ func (l *testStats) ShouldCount(string, uint16, uint16, []string) bool {
 	return true
 }
 
+// TopDomains implements the [stats.Interface] interface for *testStats.
+func (l *testStats) TopDomains(limit uint) (domains []string) {
+	return l.topDomains[:min(limit, uint(len(l.topDomains)))]
+}
+
 func TestServer_ProcessQueryLogsAndStats(t *testing.T) {
 	const domain = "example.com."
 
@@ -3,51 +3,109 @@ package filtering
 import (
 	"encoding/json"
 	"fmt"
+	"maps"
 	"net/http"
 	"slices"
+	"sync/atomic"
 	"time"
 
 	"github.com/AdguardTeam/AdGuardHome/internal/aghhttp"
 	"github.com/AdguardTeam/AdGuardHome/internal/filtering/rulelist"
 	"github.com/AdguardTeam/AdGuardHome/internal/schedule"
+	"github.com/AdguardTeam/golibs/errors"
 	"github.com/AdguardTeam/golibs/log"
 	"github.com/AdguardTeam/urlfilter/rules"
 )
 
-// serviceRules maps a service ID to its filtering rules.
-var serviceRules map[string][]*rules.NetworkRule
+// serviceCatalogue is the catalogue of the blocked services: the built-in
+// ones merged with the ones from the remote catalogue and the custom ones.
+type serviceCatalogue struct {
+	// rules maps a service ID to its filtering rules.
+	rules map[string][]*rules.NetworkRule
 
-// serviceIDs contains service IDs sorted alphabetically.
-var serviceIDs []string
+	// ids contains service IDs sorted alphabetically.
+	ids []string
+
+	// services contains the raw service data sorted by ID.
+	services []blockedService
+}
+
+// services is the current catalogue of the blocked services.  Use
+// [currentServices] to get it.
+var services atomic.Pointer[serviceCatalogue]
+
+// currentServices returns the current catalogue of the blocked services.  The
+// catalogue is empty if [initBlockedServices] hasn't been called.
+func currentServices() (c *serviceCatalogue) {
+	c = services.Load()
+	if c == nil {
+		return &serviceCatalogue{}
+	}
+
+	return c
+}
 
 // initBlockedServices initializes package-level blocked service data.
 func initBlockedServices() {
-	l := len(blockedServices)
-	serviceIDs = make([]string, l)
-	serviceRules = make(map[string][]*rules.NetworkRule, l)
-
-	for i, s := range blockedServices {
-		netRules := make([]*rules.NetworkRule, 0, len(s.Rules))
-		for _, text := range s.Rules {
-			rule, err := rules.NewNetworkRule(text, rulelist.URLFilterIDBlockedService)
-			if err != nil {
-				log.Error("parsing blocked service %q rule %q: %s", s.ID, text, err)
+	servicesMu.Lock()
+	defer servicesMu.Unlock()
 
-				continue
-			}
+	customServices, remoteServices = nil, nil
+	rebuildServices()
+}
 
-			netRules = append(netRules, rule)
+// rebuildServices merges the built-in, remote, and custom services into a new
+// catalogue and sets it.  The remote services override the built-in ones with
+// the same IDs, and the custom ones override both.  servicesMu is expected to
+// be locked.
+func rebuildServices() {
+	byID := make(map[string]blockedService, len(blockedServices))
+	for _, svcs := range [][]blockedService{blockedServices, remoteServices, customServices} {
+		for _, s := range svcs {
+			byID[s.ID] = s
 		}
+	}
 
-		serviceIDs[i] = s.ID
-		serviceRules[s.ID] = netRules
+	l := len(byID)
+	c := &serviceCatalogue{
+		rules:    make(map[string][]*rules.NetworkRule, l),
+		ids:      make([]string, 0, l),
+		services: make([]blockedService, 0, l),
 	}
 
-	slices.Sort(serviceIDs)
+	for id, s := range byID {
+		c.rules[id] = parseServiceRules(s)
+		c.ids = append(c.ids, id)
+	}
+
+	slices.Sort(c.ids)
+	for _, id := range c.ids {
+		c.services = append(c.services, byID[id])
+	}
+
+	services.Store(c)
 
 	log.Debug("filtering: initialized %d services", l)
 }
 
+// parseServiceRules returns the filtering rules of s.  Invalid rules are logged
+// and skipped.
+func parseServiceRules(s blockedService) (netRules []*rules.NetworkRule) {
+	netRules = make([]*rules.NetworkRule, 0, len(s.Rules))
+	for _, text := range s.Rules {
+		rule, err := rules.NewNetworkRule(text, rulelist.URLFilterIDBlockedService)
+		if err != nil {
+			log.Error("parsing blocked service %q rule %q: %s", s.ID, text, err)
+
+			continue
+		}
+
+		netRules = append(netRules, rule)
+	}
+
+	return netRules
+}
+
 // BlockedServices is the configuration of blocked services.
 type BlockedServices struct {
 	// Schedule is blocked services schedule for every day of the week.
@@ -55,6 +113,11 @@ type BlockedServices struct {
 
 	// IDs is the names of blocked services.
 	IDs []string `json:"ids" yaml:"ids"`
+
+	// ServiceSchedules are the schedules of individual services from IDs.  Like
+	// Schedule, each of them pauses the blocking of its service within it.
+	// Schedule still pauses the blocking of all services.
+	ServiceSchedules map[string]*schedule.Weekly `json:"service_schedules,omitempty" yaml:"service_schedules,omitempty"`
 }
 
 // Clone returns a deep copy of blocked services.
@@ -63,25 +126,60 @@ func (s *BlockedServices) Clone() (c *BlockedServices) {
 		return nil
 	}
 
-	return &BlockedServices{
+	c = &BlockedServices{
 		Schedule: s.Schedule.Clone(),
 		IDs:      slices.Clone(s.IDs),
 	}
+
+	if s.ServiceSchedules != nil {
+		c.ServiceSchedules = make(map[string]*schedule.Weekly, len(s.ServiceSchedules))
+		for id, sch := range s.ServiceSchedules {
+			c.ServiceSchedules[id] = sch.Clone()
+		}
+	}
+
+	return c
 }
 
 // Validate returns an error if blocked services contain unknown service ID.  s
 // must not be nil.
 func (s *BlockedServices) Validate() (err error) {
+	svcRules := currentServices().rules
 	for _, id := range s.IDs {
-		_, ok := serviceRules[id]
+		_, ok := svcRules[id]
 		if !ok {
 			return fmt.Errorf("unknown blocked-service %q", id)
 		}
 	}
 
+	for id, sch := range s.ServiceSchedules {
+		if !slices.Contains(s.IDs, id) {
+			return fmt.Errorf("service_schedules: service %q is not blocked", id)
+		} else if sch == nil {
+			return fmt.Errorf("service_schedules: service %q: %w", id, errors.ErrNoValue)
+		}
+	}
+
 	return nil
 }
 
+// ActiveIDs returns the IDs of the services which should be blocked at now.
+// s must not be nil.
+func (s *BlockedServices) ActiveIDs(now time.Time) (ids []string) {
+	if s.Schedule.Contains(now) {
+		return nil
+	}
+
+	for _, id := range s.IDs {
+		sch := s.ServiceSchedules[id]
+		if sch == nil || !sch.Contains(now) {
+			ids = append(ids, id)
+		}
+	}
+
+	return ids
+}
+
 // ApplyBlockedServices - set blocked services settings for this DNS request
 func (d *DNSFilter) ApplyBlockedServices(setts *Settings) {
 	d.confMu.RLock()
@@ -92,15 +190,14 @@ func (d *DNSFilter) ApplyBlockedServices(setts *Settings) {
 	bsvc := d.conf.BlockedServices
 
 	// TODO(s.chzhen):  Use startTime from [dnsforward.dnsContext].
-	if !bsvc.Schedule.Contains(time.Now()) {
-		d.ApplyBlockedServicesList(setts, bsvc.IDs)
-	}
+	d.ApplyBlockedServicesList(setts, bsvc.ActiveIDs(time.Now()))
 }
 
 // ApplyBlockedServicesList appends filtering rules to the settings.
 func (d *DNSFilter) ApplyBlockedServicesList(setts *Settings, list []string) {
+	svcRules := currentServices().rules
 	for _, name := range list {
-		rules, ok := serviceRules[name]
+		rules, ok := svcRules[name]
 		if !ok {
 			log.Error("unknown service name: %s", name)
 
@@ -115,14 +212,14 @@ func (d *DNSFilter) ApplyBlockedServicesList(setts *Settings, list []string) {
 }
 
 func (d *DNSFilter) handleBlockedServicesIDs(w http.ResponseWriter, r *http.Request) {
-	aghhttp.WriteJSONResponseOK(w, r, serviceIDs)
+	aghhttp.WriteJSONResponseOK(w, r, currentServices().ids)
 }
 
 func (d *DNSFilter) handleBlockedServicesAll(w http.ResponseWriter, r *http.Request) {
 	aghhttp.WriteJSONResponseOK(w, r, struct {
 		BlockedServices []blockedService `json:"blocked_services"`
 	}{
-		BlockedServices: blockedServices,
+		BlockedServices: currentServices().services,
 	})
 }
 
@@ -160,6 +257,11 @@ func (d *DNSFilter) handleBlockedServicesSet(w http.ResponseWriter, r *http.Requ
 		defer d.confMu.Unlock()
 
 		d.conf.BlockedServices.IDs = list
+		schedules := d.conf.BlockedServices.ServiceSchedules
+		maps.DeleteFunc(schedules, func(id string, _ *schedule.Weekly) (del bool) {
+			return !slices.Contains(list, id)
+		})
+
 		log.Debug("Updated blocked services list: %d", len(list))
 	}()
 
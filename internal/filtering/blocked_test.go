@@ -0,0 +1,93 @@
+package filtering_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/filtering"
+	"github.com/AdguardTeam/AdGuardHome/internal/schedule"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
+)
+
+func TestBlockedServices_ActiveIDs(t *testing.T) {
+	const (
+		pauseYAML = `
+mon:
+    start: 12h
+    end: 13h
+time_zone: UTC
+`
+		gamesYAML = `
+mon:
+    start: 8h
+    end: 15h
+    ranges:
+        - start: 21h
+          end: 24h
+exceptions:
+    - date: "2024-12-02"
+      ranges:
+        - start: 10h
+          end: 11h
+time_zone: UTC
+`
+	)
+
+	pause := &schedule.Weekly{}
+	err := yaml.Unmarshal([]byte(pauseYAML), pause)
+	require.NoError(t, err)
+
+	games := &schedule.Weekly{}
+	err = yaml.Unmarshal([]byte(gamesYAML), games)
+	require.NoError(t, err)
+
+	bsvc := &filtering.BlockedServices{
+		Schedule: pause,
+		IDs:      []string{"youtube", "steam"},
+		ServiceSchedules: map[string]*schedule.Weekly{
+			"steam": games,
+		},
+	}
+
+	// Both are Mondays, but the first one is an exception.
+	exceptionDay := time.Date(2024, time.December, 2, 0, 0, 0, 0, time.UTC)
+	regularDay := exceptionDay.Add(7 * 24 * time.Hour)
+
+	testCases := []struct {
+		now  time.Time
+		name string
+		want []string
+	}{{
+		now:  regularDay.Add(9 * time.Hour),
+		name: "within_service_schedule",
+		want: []string{"youtube"},
+	}, {
+		now:  regularDay.Add(16 * time.Hour),
+		name: "outside_service_schedule",
+		want: []string{"youtube", "steam"},
+	}, {
+		now:  regularDay.Add(22 * time.Hour),
+		name: "within_additional_range",
+		want: []string{"youtube"},
+	}, {
+		now:  regularDay.Add(12*time.Hour + 30*time.Minute),
+		name: "paused",
+		want: nil,
+	}, {
+		now:  exceptionDay.Add(9 * time.Hour),
+		name: "outside_exception",
+		want: []string{"youtube", "steam"},
+	}, {
+		now:  exceptionDay.Add(10*time.Hour + 30*time.Minute),
+		name: "within_exception",
+		want: []string{"youtube"},
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.want, bsvc.ActiveIDs(tc.now))
+		})
+	}
+}
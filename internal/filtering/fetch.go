@@ -0,0 +1,187 @@
+package filtering
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/AdguardTeam/golibs/log"
+	"github.com/andybalholm/brotli"
+)
+
+// errNotModified is returned by [DNSFilter.reader] when the filter list hasn't
+// changed since the previous download.
+const errNotModified errors.Error = "not modified"
+
+// httpValidators are the validators of the downloaded version of a filter list
+// used to make conditional requests.
+type httpValidators struct {
+	// url is the URL the validators are received from.
+	url string
+
+	// etag is the value of the ETag header.
+	etag string
+
+	// lastModified is the value of the Last-Modified header.
+	lastModified string
+}
+
+// isEmpty returns true if v contains no validators.
+func (v httpValidators) isEmpty() (ok bool) {
+	return v.etag == "" && v.lastModified == ""
+}
+
+// readCloser combines a decoding reader with the closer of the underlying
+// response body.
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// sources returns the URLs to download flt from, in the order they should be
+// tried.
+func (filter *FilterYAML) sources() (urls []string) {
+	return append([]string{filter.URL}, filter.Mirrors...)
+}
+
+// reader returns an io.ReadCloser reading filtering-rule list data form either
+// a file on the filesystem or the filter's HTTP URL, trying the mirrors of flt
+// in order if the previous source fails.  It also records the HTTP status code
+// into the health of flt and returns the validators of the HTTP response, if
+// any.  err is [errNotModified] if the list hasn't changed since the previous
+// download.
+func (d *DNSFilter) reader(flt *FilterYAML) (r io.ReadCloser, v httpValidators, err error) {
+	srcs := flt.sources()
+
+	var errs []error
+	for _, src := range srcs {
+		r, v, err = d.readerFromSource(flt, src)
+		if err == nil || errors.Is(err, errNotModified) {
+			return r, v, err
+		}
+
+		log.Debug("filtering: reading filter %d from %q: %s", flt.ID, src, err)
+
+		errs = append(errs, err)
+	}
+
+	if len(errs) == 1 {
+		return nil, httpValidators{}, errs[0]
+	}
+
+	return nil, httpValidators{}, fmt.Errorf("all %d sources failed: %w", len(errs), errors.Join(errs...))
+}
+
+// readerFromSource returns an io.ReadCloser reading filtering-rule list data
+// from src, which is either a file path or an HTTP URL.
+func (d *DNSFilter) readerFromSource(
+	flt *FilterYAML,
+	src string,
+) (r io.ReadCloser, v httpValidators, err error) {
+	flt.health.httpStatus = 0
+	if !filepath.IsAbs(src) {
+		r, v, err = d.readerFromURL(flt, src)
+		if err != nil && !errors.Is(err, errNotModified) {
+			return nil, v, fmt.Errorf("reading from url: %w", err)
+		}
+
+		return r, v, err
+	}
+
+	src = filepath.Clean(src)
+	if !pathMatchesAny(d.safeFSPatterns, src) {
+		return nil, v, fmt.Errorf("path %q does not match safe patterns", src)
+	}
+
+	r, err = os.Open(src)
+	if err != nil {
+		return nil, v, fmt.Errorf("opening file: %w", err)
+	}
+
+	return r, v, nil
+}
+
+// readerFromURL returns an io.ReadCloser reading the decoded filtering-rule
+// list data from fltURL.  The request is conditional if flt has the validators
+// received from fltURL and its contents are loaded.
+func (d *DNSFilter) readerFromURL(
+	flt *FilterYAML,
+	fltURL string,
+) (r io.ReadCloser, v httpValidators, err error) {
+	req, err := http.NewRequest(http.MethodGet, fltURL, nil)
+	if err != nil {
+		return nil, v, fmt.Errorf("making request: %w", err)
+	}
+
+	// Setting the header explicitly disables the transparent decompression of
+	// gzip by the transport, so the body is decoded in [decodedBody].
+	req.Header.Set("Accept-Encoding", "gzip, br")
+
+	prev := flt.validators
+	if prev.url == fltURL && flt.checksum != 0 {
+		if prev.etag != "" {
+			req.Header.Set("If-None-Match", prev.etag)
+		}
+
+		if prev.lastModified != "" {
+			req.Header.Set("If-Modified-Since", prev.lastModified)
+		}
+	}
+
+	resp, err := d.conf.HTTPClient.Do(req)
+	if err != nil {
+		// Don't wrap the error since it's informative enough as is.
+		return nil, v, err
+	}
+
+	flt.health.httpStatus = resp.StatusCode
+	switch resp.StatusCode {
+	case http.StatusOK:
+		// Go on.
+	case http.StatusNotModified:
+		return nil, prev, errors.WithDeferred(errNotModified, resp.Body.Close())
+	default:
+		err = fmt.Errorf("got status code %d, want %d", resp.StatusCode, http.StatusOK)
+
+		return nil, v, errors.WithDeferred(err, resp.Body.Close())
+	}
+
+	r, err = decodedBody(resp)
+	if err != nil {
+		return nil, v, errors.WithDeferred(err, resp.Body.Close())
+	}
+
+	v = httpValidators{
+		url:          fltURL,
+		etag:         resp.Header.Get("ETag"),
+		lastModified: resp.Header.Get("Last-Modified"),
+	}
+
+	return r, v, nil
+}
+
+// decodedBody returns the body of resp decoded according to its
+// Content-Encoding header.
+func decodedBody(resp *http.Response) (r io.ReadCloser, err error) {
+	switch enc := strings.ToLower(resp.Header.Get("Content-Encoding")); enc {
+	case "", "identity":
+		return resp.Body, nil
+	case "gzip":
+		var gr *gzip.Reader
+		gr, err = gzip.NewReader(resp.Body)
+		if err != nil {
+			return nil, fmt.Errorf("decoding gzip: %w", err)
+		}
+
+		return &readCloser{Reader: gr, Closer: resp.Body}, nil
+	case "br":
+		return &readCloser{Reader: brotli.NewReader(resp.Body), Closer: resp.Body}, nil
+	default:
+		return nil, fmt.Errorf("unsupported content encoding %q", enc)
+	}
+}
@@ -3,7 +3,6 @@ package filtering
 import (
 	"fmt"
 	"io"
-	"net/http"
 	"os"
 	"path/filepath"
 	"slices"
@@ -14,6 +13,7 @@ import (
 	"github.com/AdguardTeam/AdGuardHome/internal/aghos"
 	"github.com/AdguardTeam/AdGuardHome/internal/aghrenameio"
 	"github.com/AdguardTeam/AdGuardHome/internal/filtering/rulelist"
+	"github.com/AdguardTeam/AdGuardHome/internal/schedule"
 	"github.com/AdguardTeam/golibs/container"
 	"github.com/AdguardTeam/golibs/errors"
 	"github.com/AdguardTeam/golibs/log"
@@ -35,9 +35,39 @@ type FilterYAML struct {
 	checksum    uint32    // checksum of the file data
 	white       bool
 
+	// Schedule is the schedule during which the enabled filter is paused, like
+	// [BlockedServices.Schedule].  If it's nil, the enabled filter is always
+	// applied.
+	Schedule *schedule.Weekly `yaml:"schedule,omitempty"`
+
+	// Pinned is the version of the filter list from its history the list is
+	// pinned to.  Pinned filter lists aren't updated.  If it's empty, the list
+	// isn't pinned.
+	Pinned string `yaml:"pinned,omitempty"`
+
+	// Mirrors are the additional URLs or file paths of the filter list tried
+	// in order if downloading from URL fails.
+	Mirrors []string `yaml:"mirrors,omitempty"`
+
+	// UpdateIntervalHours is the update interval of the filter list in hours.
+	// If 0, [Config.FiltersUpdateIntervalHours] is used.
+	UpdateIntervalHours uint32 `yaml:"update_interval,omitempty"`
+
+	// validators are used to make conditional requests to download the filter
+	// list.
+	validators httpValidators
+
+	// health is the status of the latest updates of the filter list.
+	health filterHealth
+
 	Filter `yaml:",inline"`
 }
 
+// isActive returns true if the filter should be applied at now.
+func (filter *FilterYAML) isActive(now time.Time) (ok bool) {
+	return filter.Enabled && (filter.Schedule == nil || !filter.Schedule.Contains(now))
+}
+
 // Clear filter rules
 func (filter *FilterYAML) unload() {
 	filter.RulesCount = 0
@@ -83,12 +113,16 @@ const (
 )
 
 // filterSetProperties searches for the particular filter list by url and sets
-// the values of newList to it, updating afterwards if needed.  It returns true
-// if the update was performed and the filtering engine restart is required.
+// the values of newList to it, updating afterwards if needed.  If the mirrors of
+// newList are nil, the previous ones are kept, as well as the update interval if
+// updateIvl is nil and the schedule if it's nil.  An empty schedule removes the
+// previous one.  It returns true if the update was performed and the
+// filtering engine restart is required.
 func (d *DNSFilter) filterSetProperties(
 	listURL string,
 	newList FilterYAML,
 	isAllowlist bool,
+	updateIvl *uint32,
 ) (shouldRestart bool, err error) {
 	d.conf.filtersMu.Lock()
 	defer d.conf.filtersMu.Unlock()
@@ -122,6 +156,40 @@ func (d *DNSFilter) filterSetProperties(
 		}
 	}(flt.URL, flt.Name, flt.Enabled, flt.LastUpdated, flt.RulesCount)
 
+	// Changing the schedule doesn't require downloading the filter again, but
+	// it requires restarting the filtering engine.
+	if newList.Schedule != nil {
+		defer func(oldSchedule *schedule.Weekly) {
+			if err != nil {
+				flt.Schedule = oldSchedule
+			} else if flt.Enabled {
+				shouldRestart = true
+			}
+		}(flt.Schedule)
+
+		flt.Schedule = newList.Schedule
+		if flt.Schedule.IsEmpty() {
+			flt.Schedule = nil
+		}
+	}
+
+	if newList.Mirrors != nil || updateIvl != nil {
+		defer func(oldMirrors []string, oldIvl uint32) {
+			if err != nil {
+				flt.Mirrors = oldMirrors
+				flt.UpdateIntervalHours = oldIvl
+			}
+		}(flt.Mirrors, flt.UpdateIntervalHours)
+
+		if newList.Mirrors != nil {
+			flt.Mirrors = newList.Mirrors
+		}
+
+		if updateIvl != nil {
+			flt.UpdateIntervalHours = *updateIvl
+		}
+	}
+
 	flt.Name = newList.Name
 
 	if flt.URL != newList.URL {
@@ -133,7 +201,9 @@ func (d *DNSFilter) filterSetProperties(
 
 		flt.URL = newList.URL
 		flt.LastUpdated = time.Time{}
+		flt.Pinned = ""
 		flt.unload()
+		d.removeHistory(flt)
 	}
 
 	if flt.Enabled != newList.Enabled {
@@ -187,6 +257,38 @@ func (d *DNSFilter) filterExistsLocked(url string) (ok bool) {
 	return false
 }
 
+// FilterListName returns the human-readable name of the filter list with the
+// given ID, including the built-in ones.  name is empty if there is no such
+// list.  It's safe for concurrent use.
+func (d *DNSFilter) FilterListName(id rulelist.URLFilterID) (name string) {
+	switch id {
+	case rulelist.URLFilterIDCustom:
+		return "Custom filtering rules"
+	case rulelist.URLFilterIDEtcHosts:
+		return "System hosts file"
+	case rulelist.URLFilterIDBlockedService:
+		return "Blocked services"
+	case rulelist.URLFilterIDParentalControl:
+		return "Parental control"
+	case rulelist.URLFilterIDSafeBrowsing:
+		return "Safe browsing"
+	case rulelist.URLFilterIDSafeSearch:
+		return "Safe search"
+	}
+
+	d.conf.filtersMu.RLock()
+	defer d.conf.filtersMu.RUnlock()
+
+	for _, filters := range [][]FilterYAML{d.conf.Filters, d.conf.WhitelistFilters} {
+		i := slices.IndexFunc(filters, func(flt FilterYAML) (ok bool) { return flt.ID == id })
+		if i != -1 {
+			return filters[i].Name
+		}
+	}
+
+	return ""
+}
+
 // Add a filter
 // Return FALSE if a filter with this URL exists
 func (d *DNSFilter) filterAdd(flt FilterYAML) (err error) {
@@ -265,6 +367,32 @@ func (d *DNSFilter) tryRefreshFilters(block, allow, force bool) (updated int, is
 	return updated, isNetworkErr, ok
 }
 
+// updateInterval returns the update interval of flt.  ivl is 0 if neither flt
+// nor the configuration set it.
+func (d *DNSFilter) updateInterval(flt *FilterYAML) (ivl time.Duration) {
+	hours := flt.UpdateIntervalHours
+	if hours == 0 {
+		hours = d.conf.FiltersUpdateIntervalHours
+	}
+
+	return time.Duration(hours) * time.Hour
+}
+
+// hasUpdateIntervals returns true if any filter list is updated automatically.
+func (d *DNSFilter) hasUpdateIntervals() (ok bool) {
+	if d.conf.FiltersUpdateIntervalHours != 0 {
+		return true
+	}
+
+	d.conf.filtersMu.RLock()
+	defer d.conf.filtersMu.RUnlock()
+
+	hasIvl := func(flt FilterYAML) (ok bool) { return flt.UpdateIntervalHours != 0 }
+
+	return slices.ContainsFunc(d.conf.Filters, hasIvl) ||
+		slices.ContainsFunc(d.conf.WhitelistFilters, hasIvl)
+}
+
 // listsToUpdate returns the slice of filter lists that could be updated.
 func (d *DNSFilter) listsToUpdate(filters *[]FilterYAML, force bool) (toUpd []FilterYAML) {
 	now := time.Now()
@@ -275,13 +403,12 @@ func (d *DNSFilter) listsToUpdate(filters *[]FilterYAML, force bool) (toUpd []Fi
 	for i := range *filters {
 		flt := &(*filters)[i] // otherwise we will be operating on a copy
 
-		if !flt.Enabled {
+		if !flt.Enabled || flt.Pinned != "" {
 			continue
 		}
 
 		if !force {
-			exp := flt.LastUpdated.Add(time.Duration(d.conf.FiltersUpdateIntervalHours) * time.Hour)
-			if now.Before(exp) {
+			if now.Before(flt.LastUpdated.Add(d.updateInterval(flt))) {
 				continue
 			}
 		}
@@ -290,9 +417,13 @@ func (d *DNSFilter) listsToUpdate(filters *[]FilterYAML, force bool) (toUpd []Fi
 			Filter: Filter{
 				ID: flt.ID,
 			},
-			URL:      flt.URL,
-			Name:     flt.Name,
-			checksum: flt.checksum,
+			URL:        flt.URL,
+			Name:       flt.Name,
+			Mirrors:    flt.Mirrors,
+			RulesCount: flt.RulesCount,
+			checksum:   flt.checksum,
+			validators: flt.validators,
+			health:     flt.health,
 		})
 	}
 
@@ -321,6 +452,8 @@ func (d *DNSFilter) refreshFiltersArray(filters *[]FilterYAML, force bool) (int,
 	}
 
 	if failNum == len(updateFilters) {
+		d.setHealth(filters, updateFilters)
+
 		return 0, nil, nil, true
 	}
 
@@ -340,6 +473,8 @@ func (d *DNSFilter) refreshFiltersArray(filters *[]FilterYAML, force bool) (int,
 			}
 
 			f.LastUpdated = uf.LastUpdated
+			f.validators = uf.validators
+			f.health = uf.health
 			if !updated {
 				continue
 			}
@@ -361,6 +496,23 @@ func (d *DNSFilter) refreshFiltersArray(filters *[]FilterYAML, force bool) (int,
 	return updateCount, updateFilters, updateFlags, false
 }
 
+// setHealth sets the health of the filter lists in filters from the matching
+// updated ones.
+func (d *DNSFilter) setHealth(filters *[]FilterYAML, updated []FilterYAML) {
+	d.conf.filtersMu.Lock()
+	defer d.conf.filtersMu.Unlock()
+
+	for i := range updated {
+		uf := &updated[i]
+		for k := range *filters {
+			f := &(*filters)[k]
+			if f.ID == uf.ID && f.URL == uf.URL {
+				f.health = uf.health
+			}
+		}
+	}
+}
+
 // refreshFiltersIntl checks filters and updates them if necessary.  If force is
 // true, it ignores the filter.LastUpdated field value.
 //
@@ -428,6 +580,7 @@ func (d *DNSFilter) refreshFiltersIntl(block, allow, force bool) (int, bool) {
 func (d *DNSFilter) update(filter *FilterYAML) (b bool, err error) {
 	b, err = d.updateIntl(filter)
 	filter.LastUpdated = time.Now()
+	filter.health.setResult(filter.LastUpdated, err)
 	if !b {
 		chErr := os.Chtimes(
 			filter.Path(d.conf.DataDir),
@@ -455,8 +608,10 @@ func (d *DNSFilter) updateIntl(flt *FilterYAML) (ok bool, err error) {
 	}
 	defer func() { err = d.finalizeUpdate(tmpFile, flt, res, err, ok) }()
 
-	r, err := d.reader(flt.URL)
-	if err != nil {
+	r, validators, err := d.reader(flt)
+	if errors.Is(err, errNotModified) {
+		return false, nil
+	} else if err != nil {
 		// Don't wrap the error since it's informative enough as is.
 		return false, err
 	}
@@ -465,10 +620,28 @@ func (d *DNSFilter) updateIntl(flt *FilterYAML) (ok bool, err error) {
 	bufPtr := d.bufPool.Get()
 	defer d.bufPool.Put(bufPtr)
 
-	p := rulelist.NewParser()
+	p := rulelist.NewValidatingParser()
 	res, err = p.Parse(tmpFile, r, *bufPtr)
+	flt.health.setParseResult(res)
+	if err != nil {
+		// Don't wrap the error since it's informative enough as is.
+		return false, err
+	}
+
+	if res.Checksum == flt.checksum {
+		flt.validators = validators
 
-	return res.Checksum != flt.checksum && err == nil, err
+		return false, nil
+	}
+
+	err = d.checkShrink(flt, res)
+	if err != nil {
+		return false, err
+	}
+
+	flt.validators = validators
+
+	return true, nil
 }
 
 // finalizeUpdate closes and gets rid of temporary file f with filter's content
@@ -492,11 +665,26 @@ func (d *DNSFilter) finalizeUpdate(
 
 	log.Info("filtering: saving contents of filter %d into %q", id, flt.Path(d.conf.DataDir))
 
+	var version string
+	if d.conf.FiltersHistorySize > 0 {
+		version, err = d.archiveFilter(flt)
+		if err != nil {
+			log.Error("filtering: archiving filter %d: %s", id, err)
+		}
+	}
+
 	err = file.CloseReplace()
 	if err != nil {
 		return fmt.Errorf("finalizing update: %w", err)
 	}
 
+	if version != "" {
+		err = d.recordUpdate(flt, version)
+		if err != nil {
+			log.Error("filtering: recording update of filter %d: %s", id, err)
+		}
+	}
+
 	rulesCount := res.RulesCount
 	log.Info("filtering: updated filter %d: %d bytes, %d rules", id, res.BytesWritten, rulesCount)
 
@@ -507,47 +695,6 @@ func (d *DNSFilter) finalizeUpdate(
 	return nil
 }
 
-// reader returns an io.ReadCloser reading filtering-rule list data form either
-// a file on the filesystem or the filter's HTTP URL.
-func (d *DNSFilter) reader(fltURL string) (r io.ReadCloser, err error) {
-	if !filepath.IsAbs(fltURL) {
-		r, err = d.readerFromURL(fltURL)
-		if err != nil {
-			return nil, fmt.Errorf("reading from url: %w", err)
-		}
-
-		return r, nil
-	}
-
-	fltURL = filepath.Clean(fltURL)
-	if !pathMatchesAny(d.safeFSPatterns, fltURL) {
-		return nil, fmt.Errorf("path %q does not match safe patterns", fltURL)
-	}
-
-	r, err = os.Open(fltURL)
-	if err != nil {
-		return nil, fmt.Errorf("opening file: %w", err)
-	}
-
-	return r, nil
-}
-
-// readerFromURL returns an io.ReadCloser reading filtering-rule list data form
-// the filter's URL.
-func (d *DNSFilter) readerFromURL(fltURL string) (r io.ReadCloser, err error) {
-	resp, err := d.conf.HTTPClient.Get(fltURL)
-	if err != nil {
-		// Don't wrap the error since it's informative enough as is.
-		return nil, err
-	}
-
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("got status code %d, want %d", resp.StatusCode, http.StatusOK)
-	}
-
-	return resp.Body, nil
-}
-
 // loads filter contents from the file in dataDir
 func (d *DNSFilter) load(flt *FilterYAML) (err error) {
 	fileName := flt.Path(d.conf.DataDir)
@@ -599,8 +746,9 @@ func (d *DNSFilter) enableFiltersLocked(async bool) {
 		Data: []byte(strings.Join(d.conf.UserRules, "\n")),
 	}
 
+	now := time.Now()
 	for _, filter := range d.conf.Filters {
-		if !filter.Enabled {
+		if !filter.isActive(now) {
 			continue
 		}
 
@@ -612,7 +760,7 @@ func (d *DNSFilter) enableFiltersLocked(async bool) {
 
 	var allowFilters []Filter
 	for _, filter := range d.conf.WhitelistFilters {
-		if !filter.Enabled {
+		if !filter.isActive(now) {
 			continue
 		}
 
@@ -622,6 +770,8 @@ func (d *DNSFilter) enableFiltersLocked(async bool) {
 		})
 	}
 
+	d.scheduledFilters.Store(d.activeScheduledFiltersLocked(now))
+
 	err := d.setFilters(filters, allowFilters, async)
 	if err != nil {
 		log.Error("filtering: enabling filters: %s", err)
@@ -629,3 +779,36 @@ func (d *DNSFilter) enableFiltersLocked(async bool) {
 
 	d.SetEnabled(d.conf.FilteringEnabled)
 }
+
+// activeScheduledFiltersLocked returns the IDs of the enabled filters with a
+// schedule which are active at now.  d.conf.filtersMu is expected to be
+// locked.
+func (d *DNSFilter) activeScheduledFiltersLocked(now time.Time) (ids *[]rulelist.URLFilterID) {
+	active := []rulelist.URLFilterID{}
+	for _, fltSet := range [][]FilterYAML{d.conf.Filters, d.conf.WhitelistFilters} {
+		for _, flt := range fltSet {
+			if flt.Schedule != nil && flt.isActive(now) {
+				active = append(active, flt.ID)
+			}
+		}
+	}
+
+	return &active
+}
+
+// checkFilterSchedules re-enables the filters if the set of the active
+// scheduled filters has changed since the last time they were enabled.
+func (d *DNSFilter) checkFilterSchedules() {
+	d.conf.filtersMu.RLock()
+	defer d.conf.filtersMu.RUnlock()
+
+	active := d.activeScheduledFiltersLocked(time.Now())
+	prev := d.scheduledFilters.Load()
+	if prev != nil && slices.Equal(*prev, *active) {
+		return
+	}
+
+	log.Debug("filtering: scheduled filters changed, enabling filters")
+
+	d.enableFiltersLocked(true)
+}
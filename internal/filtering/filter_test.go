@@ -1,18 +1,26 @@
 package filtering
 
 import (
+	"compress/gzip"
 	"net"
 	"net/http"
 	"net/url"
 	"os"
 	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	"github.com/AdguardTeam/AdGuardHome/internal/filtering/rulelist"
+	"github.com/AdguardTeam/AdGuardHome/internal/schedule"
 	"github.com/AdguardTeam/golibs/netutil/urlutil"
 	"github.com/AdguardTeam/golibs/testutil"
+	"github.com/andybalholm/brotli"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
 )
 
 // testTimeout is the common timeout for tests.
@@ -136,6 +144,192 @@ func TestDNSFilter_Update(t *testing.T) {
 	})
 }
 
+func TestDNSFilter_Update_health(t *testing.T) {
+	const (
+		fullContent = "||first.example^\n" +
+			"||second.example^\n" +
+			"||third.example^\n" +
+			"||fourth.example^\n"
+		brokenContent = "||first.example^$unknown-modifier\n"
+	)
+
+	var content atomic.Pointer[string]
+	addr := serveHTTPLocally(t, http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		c := content.Load()
+		if c == nil {
+			w.WriteHeader(http.StatusNotFound)
+
+			return
+		}
+
+		_, _ = w.Write([]byte(*c))
+	}))
+
+	dnsFilter := newDNSFilter(t)
+	dnsFilter.conf.FiltersShrinkLimit = 50
+
+	f := &FilterYAML{
+		URL:  addr,
+		Name: "test-filter",
+	}
+
+	t.Run("not_found", func(t *testing.T) {
+		_, err := dnsFilter.update(f)
+		require.Error(t, err)
+
+		h := f.health.toJSON()
+		require.NotNil(t, h)
+
+		assert.Equal(t, http.StatusNotFound, h.HTTPStatus)
+		assert.Equal(t, "reading from url: got status code 404, want 200", h.LastError)
+		assert.Empty(t, h.LastSuccess)
+	})
+
+	t.Run("success", func(t *testing.T) {
+		c := fullContent
+		content.Store(&c)
+
+		updateAndAssert(t, dnsFilter, f, require.True, 4)
+
+		h := f.health.toJSON()
+		require.NotNil(t, h)
+
+		assert.Equal(t, http.StatusOK, h.HTTPStatus)
+		assert.Equal(t, len(fullContent), h.Bytes)
+		assert.Empty(t, h.LastError)
+		assert.NotEmpty(t, h.LastSuccess)
+	})
+
+	t.Run("shrunk", func(t *testing.T) {
+		c := brokenContent
+		content.Store(&c)
+
+		ok, err := dnsFilter.update(f)
+		require.Error(t, err)
+		require.False(t, ok)
+
+		assert.Equal(t, 4, f.RulesCount)
+
+		h := f.health.toJSON()
+		require.NotNil(t, h)
+
+		assert.Equal(t, 1, h.InvalidCount)
+		assert.Equal(t, []string{strings.TrimSpace(brokenContent)}, h.InvalidLines)
+		assert.Equal(t, err.Error(), h.LastError)
+
+		data, err := os.ReadFile(f.Path(dnsFilter.conf.DataDir))
+		require.NoError(t, err)
+
+		assert.Equal(t, fullContent, string(data))
+	})
+}
+
+func TestDNSFilter_Update_conditional(t *testing.T) {
+	const (
+		content = "||first.example^\n||second.example^\n"
+		etag    = `"v1"`
+	)
+
+	var reqNum atomic.Int32
+	addr := serveHTTPLocally(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		pt := testutil.PanicT{}
+
+		reqNum.Add(1)
+		if r.Header.Get("If-None-Match") == etag {
+			w.WriteHeader(http.StatusNotModified)
+
+			return
+		}
+
+		w.Header().Set("ETag", etag)
+
+		var werr error
+		switch r.URL.Path {
+		case "/gzip":
+			w.Header().Set("Content-Encoding", "gzip")
+			gw := gzip.NewWriter(w)
+			_, werr = gw.Write([]byte(content))
+			require.NoError(pt, werr)
+
+			werr = gw.Close()
+		case "/br":
+			w.Header().Set("Content-Encoding", "br")
+			bw := brotli.NewWriter(w)
+			_, werr = bw.Write([]byte(content))
+			require.NoError(pt, werr)
+
+			werr = bw.Close()
+		default:
+			_, werr = w.Write([]byte(content))
+		}
+		require.NoError(pt, werr)
+	}))
+
+	for _, enc := range []string{"gzip", "br"} {
+		t.Run(enc, func(t *testing.T) {
+			dnsFilter := newDNSFilter(t)
+			f := &FilterYAML{
+				URL: addr + "/" + enc,
+			}
+
+			updateAndAssert(t, dnsFilter, f, require.True, 2)
+
+			before := reqNum.Load()
+			updateAndAssert(t, dnsFilter, f, require.False, 2)
+
+			assert.Equal(t, before+1, reqNum.Load())
+			assert.Equal(t, http.StatusNotModified, f.health.httpStatus)
+		})
+	}
+
+	t.Run("mirror", func(t *testing.T) {
+		dnsFilter := newDNSFilter(t)
+		badAddr := serveHTTPLocally(t, http.NotFoundHandler())
+		f := &FilterYAML{
+			URL:     badAddr,
+			Mirrors: []string{addr},
+		}
+
+		updateAndAssert(t, dnsFilter, f, require.True, 2)
+
+		assert.Equal(t, addr, f.validators.url)
+	})
+}
+
+func TestDNSFilter_listsToUpdate(t *testing.T) {
+	dnsFilter := newDNSFilter(t)
+	dnsFilter.conf.FiltersUpdateIntervalHours = 24
+
+	now := time.Now()
+	filters := []FilterYAML{{
+		Enabled:     true,
+		URL:         "https://global.example",
+		LastUpdated: now.Add(-2 * time.Hour),
+	}, {
+		Enabled:             true,
+		URL:                 "https://hourly.example",
+		LastUpdated:         now.Add(-2 * time.Hour),
+		UpdateIntervalHours: 1,
+	}, {
+		Enabled:             true,
+		URL:                 "https://weekly.example",
+		LastUpdated:         now.Add(-48 * time.Hour),
+		UpdateIntervalHours: 7 * 24,
+	}, {
+		Enabled:     true,
+		URL:         "https://pinned.example",
+		Pinned:      "1",
+		LastUpdated: now.Add(-48 * time.Hour),
+	}}
+
+	toUpd := dnsFilter.listsToUpdate(&filters, false)
+	require.Len(t, toUpd, 1)
+
+	assert.Equal(t, "https://hourly.example", toUpd[0].URL)
+
+	assert.Len(t, dnsFilter.listsToUpdate(&filters, true), 3)
+}
+
 func TestFilterYAML_EnsureName(t *testing.T) {
 	dnsFilter := newDNSFilter(t)
 
@@ -173,3 +367,98 @@ func TestFilterYAML_EnsureName(t *testing.T) {
 		assert.Equal(t, "List 0", f.Name)
 	})
 }
+
+func TestDNSFilter_activeScheduledFiltersLocked(t *testing.T) {
+	const schYAML = `
+mon:
+    start: 8h
+    end: 15h
+time_zone: UTC
+`
+
+	sch := &schedule.Weekly{}
+	err := yaml.Unmarshal([]byte(schYAML), sch)
+	require.NoError(t, err)
+
+	d := &DNSFilter{
+		conf: &Config{
+			Filters: []FilterYAML{{
+				Enabled: true,
+				Filter:  Filter{ID: 1},
+			}, {
+				Enabled:  true,
+				Schedule: sch,
+				Filter:   Filter{ID: 2},
+			}, {
+				Enabled:  false,
+				Schedule: sch,
+				Filter:   Filter{ID: 3},
+			}},
+			WhitelistFilters: []FilterYAML{{
+				Enabled:  true,
+				Schedule: sch,
+				Filter:   Filter{ID: 4},
+			}},
+		},
+	}
+
+	monday := time.Date(2024, time.December, 2, 0, 0, 0, 0, time.UTC)
+
+	got := d.activeScheduledFiltersLocked(monday.Add(9 * time.Hour))
+	require.NotNil(t, got)
+
+	assert.Empty(t, *got)
+	assert.False(t, d.conf.Filters[1].isActive(monday.Add(9*time.Hour)))
+	assert.True(t, d.conf.Filters[0].isActive(monday.Add(9*time.Hour)))
+
+	got = d.activeScheduledFiltersLocked(monday.Add(16 * time.Hour))
+	require.NotNil(t, got)
+
+	assert.Equal(t, []rulelist.URLFilterID{2, 4}, *got)
+	assert.True(t, d.conf.Filters[1].isActive(monday.Add(16*time.Hour)))
+}
+
+func TestDNSFilter_filterSetProperties_schedule(t *testing.T) {
+	const fltURL = "https://filters.example/list.txt"
+
+	sch := schedule.FullWeekly()
+
+	d := &DNSFilter{
+		conf: &Config{
+			Filters: []FilterYAML{{
+				URL: fltURL,
+			}},
+			filtersMu: &sync.RWMutex{},
+		},
+	}
+
+	_, err := d.filterSetProperties(fltURL, FilterYAML{
+		URL: fltURL,
+	}, false, nil)
+	require.NoError(t, err)
+
+	assert.Nil(t, d.conf.Filters[0].Schedule)
+
+	_, err = d.filterSetProperties(fltURL, FilterYAML{
+		Schedule: sch,
+		URL:      fltURL,
+	}, false, nil)
+	require.NoError(t, err)
+
+	assert.Same(t, sch, d.conf.Filters[0].Schedule)
+
+	_, err = d.filterSetProperties(fltURL, FilterYAML{
+		URL: fltURL,
+	}, false, nil)
+	require.NoError(t, err)
+
+	assert.Same(t, sch, d.conf.Filters[0].Schedule)
+
+	_, err = d.filterSetProperties(fltURL, FilterYAML{
+		Schedule: schedule.EmptyWeekly(),
+		URL:      fltURL,
+	}, false, nil)
+	require.NoError(t, err)
+
+	assert.Nil(t, d.conf.Filters[0].Schedule)
+}
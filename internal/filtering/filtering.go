@@ -82,6 +82,14 @@ type Config struct {
 	// Per-client settings can override this configuration.
 	BlockedServices *BlockedServices `yaml:"blocked_services"`
 
+	// CustomBlockedServices are the user-defined blocked services.  Their IDs
+	// can be used in BlockedServices in the same way as the built-in ones.
+	CustomBlockedServices []*CustomBlockedService `yaml:"custom_blocked_services"`
+
+	// BlockedServicesURL is the URL of the remote blocked services catalogue
+	// in the Hostlists Registry format.  It's not used if empty.
+	BlockedServicesURL string `yaml:"blocked_services_url"`
+
 	// EtcHosts is a container of IP-hostname pairs taken from the operating
 	// system configuration files (e.g. /etc/hosts).
 	//
@@ -106,6 +114,14 @@ type Config struct {
 
 	SafeSearchConf SafeSearchConfig `yaml:"safe_search"`
 
+	// SafeSearchProviders are the user-defined safe search providers in
+	// addition to the built-in ones.
+	SafeSearchProviders []*SafeSearchProvider `yaml:"safe_search_providers"`
+
+	// SafeSearchProvidersURL is the URL of a JSON document with additional
+	// safe search provider definitions.  It's not used if empty.
+	SafeSearchProvidersURL string `yaml:"safe_search_providers_url"`
+
 	// DataDir is used to store filters' contents.
 	DataDir string `yaml:"-"`
 
@@ -152,6 +168,17 @@ type Config struct {
 	// (in hours).
 	FiltersUpdateIntervalHours uint32 `yaml:"filters_update_interval"`
 
+	// FiltersHistorySize is the number of the previous versions of each filter
+	// list kept to view the changes and to pin the list to.  If 0, the history
+	// isn't kept.
+	FiltersHistorySize uint32 `yaml:"filters_history_size"`
+
+	// FiltersShrinkLimit is the maximum percentage by which the rules count of
+	// a filter list may drop during an update.  Updates exceeding it are
+	// rejected and the previous version is kept.  If 0, the updates are never
+	// rejected.
+	FiltersShrinkLimit uint32 `yaml:"filters_shrink_limit"`
+
 	// BlockedResponseTTL is the time-to-live value for blocked responses.  If
 	// 0, then default value is used (3600).
 	BlockedResponseTTL uint32 `yaml:"blocked_response_ttl"`
@@ -236,6 +263,11 @@ type DNSFilter struct {
 	rulesStorageAllow    *filterlist.RuleStorage
 	filteringEngineAllow *urlfilter.DNSEngine
 
+	// ptrRewrites is the index of the $dnsrewrite rules from the custom
+	// filtering rules used to synthesize PTR responses.  It's protected by
+	// engineLock.
+	ptrRewrites ptrRewrites
+
 	safeSearch SafeSearch
 
 	// safeBrowsingChecker is the safe browsing hash-prefix checker.
@@ -261,6 +293,10 @@ type DNSFilter struct {
 
 	refreshLock *sync.Mutex
 
+	// scheduledFilters are the IDs of the scheduled filters which were active
+	// when the filters were enabled the last time.
+	scheduledFilters atomic.Pointer[[]rulelist.URLFilterID]
+
 	hostCheckers []hostChecker
 
 	safeFSPatterns []string
@@ -322,6 +358,13 @@ const (
 	//
 	// See https://github.com/AdguardTeam/AdGuardHome/issues/2499.
 	RewrittenRule
+
+	// FilteredAllowlistOnly is returned when the host isn't allowlisted and the
+	// client is in the allowlist-only mode.
+	//
+	// NOTE:  It's placed after RewrittenRule to keep the values of the
+	// previous reasons, since they are stored in the query log.
+	FilteredAllowlistOnly
 )
 
 // TODO(a.garipov): Resync with actual code names or replace completely
@@ -341,6 +384,8 @@ var reasonNames = []string{
 	Rewritten:          "Rewrite",
 	RewrittenAutoHosts: "RewriteEtcHosts",
 	RewrittenRule:      "RewriteRule",
+
+	FilteredAllowlistOnly: "FilteredAllowlistOnly",
 }
 
 func (r Reason) String() string {
@@ -562,6 +607,10 @@ type Result struct {
 	// Rules are applied rules.  If Rules are not empty, each rule is not nil.
 	Rules []*ResultRule `json:",omitempty"`
 
+	// TTL is the TTL of the rewritten answer records in seconds.  It is zero
+	// unless Reason is set to Rewritten and the rewrite has a TTL.
+	TTL uint32 `json:"-"`
+
 	// Reason is the reason for blocking or unblocking the request.
 	Reason Reason `json:",omitempty"`
 
@@ -598,7 +647,7 @@ func (d *DNSFilter) CheckHost(
 	host = strings.ToLower(host)
 
 	if setts.FilteringEnabled {
-		res = d.processRewrites(host, qtype)
+		res = d.processRewrites(host, qtype, setts)
 		if res.Reason == Rewritten {
 			return res, nil
 		}
@@ -626,12 +675,16 @@ func (d *DNSFilter) CheckHost(
 //
 // Secondly, it finds A or AAAA rewrites for host and, if found, sets res.IPList
 // accordingly.  If the found rewrite has a special value of "A" or "AAAA", the
-// result is an exception.
-func (d *DNSFilter) processRewrites(host string, qtype uint16) (res Result) {
+// result is an exception.  The rewrites of the other types are set into
+// res.DNSRewriteResult.
+//
+// Only the enabled rewrites applicable to the client described by setts are
+// considered.
+func (d *DNSFilter) processRewrites(host string, qtype uint16, setts *Settings) (res Result) {
 	d.confMu.RLock()
 	defer d.confMu.RUnlock()
 
-	rewrites, matched := findRewrites(d.conf.Rewrites, host, qtype)
+	rewrites, matched := findRewrites(d.conf.Rewrites, host, qtype, setts)
 	if !matched {
 		return Result{}
 	}
@@ -671,7 +724,8 @@ func (d *DNSFilter) processRewrites(host string, qtype uint16) (res Result) {
 
 		cnames.Add(host)
 		res.CanonName = host
-		rewrites, matched = findRewrites(d.conf.Rewrites, host, qtype)
+		res.TTL = minRewriteTTL(res.TTL, rw.TTL)
+		rewrites, matched = findRewrites(d.conf.Rewrites, host, qtype, setts)
 	}
 
 	setRewriteResult(&res, host, rewrites, qtype)
@@ -788,6 +842,7 @@ func (d *DNSFilter) initFiltering(allowFilters, blockFilters []Filter) (err erro
 
 	filteringEngine := urlfilter.NewDNSEngine(rulesStorage)
 	filteringEngineAllow := urlfilter.NewDNSEngine(rulesStorageAllow)
+	ptrRws := newPTRRewrites(blockFilters)
 
 	func() {
 		d.engineLock.Lock()
@@ -798,6 +853,7 @@ func (d *DNSFilter) initFiltering(allowFilters, blockFilters []Filter) (err erro
 		d.filteringEngine = filteringEngine
 		d.rulesStorageAllow = rulesStorageAllow
 		d.filteringEngineAllow = filteringEngineAllow
+		d.ptrRewrites = ptrRws
 	}()
 
 	// Make sure that the OS reclaims memory as soon as possible.
@@ -1086,6 +1142,10 @@ func (d *DNSFilter) Start() {
 	go d.updatesLoop()
 }
 
+// filterSchedulesCheckIvl is the interval between the checks of the schedules
+// of the filter lists.
+const filterSchedulesCheckIvl = 1 * time.Minute
+
 // updatesLoop initializes new filters and checks for filters updates in a loop.
 func (d *DNSFilter) updatesLoop() {
 	defer log.OnPanic("filtering: updates loop")
@@ -1093,6 +1153,8 @@ func (d *DNSFilter) updatesLoop() {
 	ivl := time.Second * 5
 	t := time.NewTimer(ivl)
 
+	schedTicker := time.NewTicker(filterSchedulesCheckIvl)
+
 	for {
 		select {
 		case params := <-d.filtersInitializerChan:
@@ -1105,8 +1167,11 @@ func (d *DNSFilter) updatesLoop() {
 		case <-t.C:
 			ivl = d.periodicallyRefreshFilters(ivl)
 			t.Reset(ivl)
+		case <-schedTicker.C:
+			d.checkFilterSchedules()
 		case <-d.done:
 			t.Stop()
+			schedTicker.Stop()
 
 			return
 		}
@@ -1118,13 +1183,15 @@ func (d *DNSFilter) updatesLoop() {
 func (d *DNSFilter) periodicallyRefreshFilters(ivl time.Duration) (nextIvl time.Duration) {
 	const maxInterval = time.Hour
 
-	if d.conf.FiltersUpdateIntervalHours == 0 {
+	if !d.hasUpdateIntervals() {
 		return ivl
 	}
 
 	isNetErr, ok := false, false
 	_, isNetErr, ok = d.tryRefreshFilters(true, true, false)
 
+	isNetErr = d.refreshBlockedServices(false) || isNetErr
+
 	if ok && !isNetErr {
 		ivl = maxInterval
 	} else if isNetErr {
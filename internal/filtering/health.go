@@ -0,0 +1,135 @@
+package filtering
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/filtering/rulelist"
+)
+
+// filterHealth is the status of the latest updates of a filter list.
+type filterHealth struct {
+	// lastAttempt is the time of the latest update attempt.
+	lastAttempt time.Time
+
+	// lastSuccess is the time of the latest successful update attempt,
+	// including the ones that found no changes.
+	lastSuccess time.Time
+
+	// lastError is the error of the latest update attempt, if any.
+	lastError error
+
+	// invalidLines are the samples of the invalid lines of the latest
+	// downloaded version of the list.
+	invalidLines []string
+
+	// httpStatus is the HTTP status code of the latest download, if the list
+	// is downloaded over HTTP.
+	httpStatus int
+
+	// bytes is the number of bytes of rules in the latest downloaded version of
+	// the list.
+	bytes int
+
+	// invalidCount is the number of invalid lines in the latest downloaded
+	// version of the list.
+	invalidCount int
+}
+
+// setParseResult sets the properties of the downloaded list from res, if any.
+func (h *filterHealth) setParseResult(res *rulelist.ParseResult) {
+	if res == nil {
+		return
+	}
+
+	h.bytes = res.BytesWritten
+	h.invalidCount = res.InvalidLinesCount
+	h.invalidLines = res.InvalidLines
+}
+
+// setResult records the result of the update attempt made at now.
+func (h *filterHealth) setResult(now time.Time, err error) {
+	h.lastAttempt = now
+	h.lastError = err
+	if err == nil {
+		h.lastSuccess = now
+	}
+}
+
+// filterHealthJSON is the JSON representation of [filterHealth].
+type filterHealthJSON struct {
+	LastAttempt  string   `json:"last_attempt,omitempty"`
+	LastSuccess  string   `json:"last_success,omitempty"`
+	LastError    string   `json:"last_error,omitempty"`
+	InvalidLines []string `json:"invalid_lines,omitempty"`
+	HTTPStatus   int      `json:"http_status,omitempty"`
+	Bytes        int      `json:"bytes"`
+	InvalidCount int      `json:"invalid_count"`
+}
+
+// toJSON returns the JSON representation of h.  j is nil if no updates were
+// attempted yet.
+func (h *filterHealth) toJSON() (j *filterHealthJSON) {
+	if h.lastAttempt.IsZero() {
+		return nil
+	}
+
+	j = &filterHealthJSON{
+		LastAttempt:  h.lastAttempt.Format(time.RFC3339),
+		InvalidLines: h.invalidLines,
+		HTTPStatus:   h.httpStatus,
+		Bytes:        h.bytes,
+		InvalidCount: h.invalidCount,
+	}
+
+	if !h.lastSuccess.IsZero() {
+		j.LastSuccess = h.lastSuccess.Format(time.RFC3339)
+	}
+
+	if h.lastError != nil {
+		j.LastError = h.lastError.Error()
+	}
+
+	return j
+}
+
+// errShrunk is returned when the new version of a filter list is rejected,
+// because it has too few rules compared to the previous one.
+type errShrunk struct {
+	prev  int
+	cur   int
+	limit uint32
+}
+
+// type check
+var _ error = (*errShrunk)(nil)
+
+// Error implements the error interface for *errShrunk.
+func (err *errShrunk) Error() (msg string) {
+	return fmt.Sprintf(
+		"rules count dropped from %d to %d, more than %d%%; keeping previous version",
+		err.prev,
+		err.cur,
+		err.limit,
+	)
+}
+
+// checkShrink returns an error if the rules count of the new version of flt
+// dropped by more than the configured limit.
+func (d *DNSFilter) checkShrink(flt *FilterYAML, res *rulelist.ParseResult) (err error) {
+	limit := d.conf.FiltersShrinkLimit
+	if limit == 0 || limit >= 100 || flt.RulesCount == 0 {
+		return nil
+	}
+
+	minCount := flt.RulesCount * int(100-limit) / 100
+	if res.RulesCount >= minCount {
+		return nil
+	}
+
+	return &errShrunk{
+		prev:  flt.RulesCount,
+		cur:   res.RulesCount,
+		limit: limit,
+	}
+}
@@ -0,0 +1,388 @@
+package filtering
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/aghos"
+	"github.com/AdguardTeam/AdGuardHome/internal/aghrenameio"
+	"github.com/AdguardTeam/golibs/container"
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/AdguardTeam/golibs/log"
+)
+
+const (
+	// historyDir is the subdirectory of the filters directory to store the
+	// previous versions of the filter lists and their update history.
+	historyDir = "history"
+
+	// historyFileName is the name of the file with the update history of a
+	// filter list within its history directory.
+	historyFileName = "history.json"
+
+	// maxDiffRules is the maximum number of the added and removed rules each
+	// returned in a diff of a filter list update.
+	maxDiffRules = 1000
+)
+
+const (
+	// errNoVersion is returned when the requested version of a filter list
+	// isn't found in its history.
+	errNoVersion errors.Error = "version not found"
+
+	// errNoHistory is returned when the history of filter lists is disabled.
+	errNoHistory errors.Error = "filter lists history is disabled"
+)
+
+// filterUpdate is a single update of a filter list stored in its history.
+type filterUpdate struct {
+	// Time is the time of the update.
+	Time time.Time `json:"time"`
+
+	// Version is the identifier of the version of the filter list replaced by
+	// the update.  Its contents are kept in the history directory.
+	Version string `json:"version"`
+
+	// RulesCount is the number of rules in the filter list after the update.
+	RulesCount int `json:"rules_count"`
+
+	// PrevRulesCount is the number of rules in the replaced version.
+	PrevRulesCount int `json:"prev_rules_count"`
+
+	// Added is the number of rules added by the update.
+	Added int `json:"added"`
+
+	// Removed is the number of rules removed by the update.
+	Removed int `json:"removed"`
+}
+
+// historyPath returns the path to the directory with the history of filter.
+func (filter *FilterYAML) historyPath(dataDir string) (dir string) {
+	return filepath.Join(dataDir, filterDir, historyDir, strconv.FormatInt(int64(filter.ID), 10))
+}
+
+// versionPath returns the path to the file with the contents of the version of
+// filter.
+func (filter *FilterYAML) versionPath(dataDir, version string) (p string) {
+	return filepath.Join(filter.historyPath(dataDir), version+".txt")
+}
+
+// removeHistory removes the history of flt.  Errors are only logged.
+func (d *DNSFilter) removeHistory(flt *FilterYAML) {
+	err := os.RemoveAll(flt.historyPath(d.conf.DataDir))
+	if err != nil {
+		log.Error("filtering: removing history of filter %d: %s", flt.ID, err)
+	}
+}
+
+// archiveFilter saves the current contents of flt into its history and returns
+// the identifier of the saved version.  version is empty if there is nothing to
+// save.
+func (d *DNSFilter) archiveFilter(flt *FilterYAML) (version string, err error) {
+	src, err := os.Open(flt.Path(d.conf.DataDir))
+	if errors.Is(err, os.ErrNotExist) {
+		return "", nil
+	} else if err != nil {
+		return "", fmt.Errorf("opening filter file: %w", err)
+	}
+	defer func() { err = errors.WithDeferred(err, src.Close()) }()
+
+	err = aghos.MkdirAll(flt.historyPath(d.conf.DataDir), aghos.DefaultPermDir)
+	if err != nil {
+		return "", fmt.Errorf("creating history dir: %w", err)
+	}
+
+	version = strconv.FormatInt(time.Now().UnixNano(), 10)
+	dst, err := aghrenameio.NewPendingFile(
+		flt.versionPath(d.conf.DataDir, version),
+		aghos.DefaultPermFile,
+	)
+	if err != nil {
+		return "", fmt.Errorf("creating version file: %w", err)
+	}
+	defer func() { err = aghrenameio.WithDeferredCleanup(err, dst) }()
+
+	_, err = io.Copy(dst, src)
+	if err != nil {
+		return "", fmt.Errorf("copying filter file: %w", err)
+	}
+
+	return version, nil
+}
+
+// recordUpdate adds the update of flt replacing version to its history and
+// removes the versions exceeding the history size.
+func (d *DNSFilter) recordUpdate(flt *FilterYAML, version string) (err error) {
+	dir := flt.historyPath(d.conf.DataDir)
+	hist, err := loadHistory(dir)
+	if err != nil {
+		return err
+	}
+
+	prev, err := readRuleSet(flt.versionPath(d.conf.DataDir, version))
+	if err != nil {
+		return fmt.Errorf("reading previous version: %w", err)
+	}
+
+	cur, err := readRuleSet(flt.Path(d.conf.DataDir))
+	if err != nil {
+		return fmt.Errorf("reading current version: %w", err)
+	}
+
+	added, removed := diffRuleSets(prev, cur)
+	upd := &filterUpdate{
+		Time:           time.Now(),
+		Version:        version,
+		RulesCount:     cur.Len(),
+		PrevRulesCount: prev.Len(),
+		Added:          len(added),
+		Removed:        len(removed),
+	}
+
+	log.Info(
+		"filtering: filter %d update: %d rules added, %d removed",
+		flt.ID,
+		upd.Added,
+		upd.Removed,
+	)
+
+	hist = append(hist, upd)
+	if size := int(d.conf.FiltersHistorySize); len(hist) > size {
+		for _, old := range hist[:len(hist)-size] {
+			rmErr := os.Remove(flt.versionPath(d.conf.DataDir, old.Version))
+			if rmErr != nil && !errors.Is(rmErr, os.ErrNotExist) {
+				log.Error("filtering: removing version %s of filter %d: %s", old.Version, flt.ID, rmErr)
+			}
+		}
+
+		hist = slices.Clone(hist[len(hist)-size:])
+	}
+
+	return saveHistory(dir, hist)
+}
+
+// loadHistory returns the update history stored in dir.
+func loadHistory(dir string) (hist []*filterUpdate, err error) {
+	data, err := os.ReadFile(filepath.Join(dir, historyFileName))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("reading history: %w", err)
+	}
+
+	err = json.Unmarshal(data, &hist)
+	if err != nil {
+		return nil, fmt.Errorf("decoding history: %w", err)
+	}
+
+	return hist, nil
+}
+
+// saveHistory writes the update history into dir.
+func saveHistory(dir string, hist []*filterUpdate) (err error) {
+	data, err := json.Marshal(hist)
+	if err != nil {
+		return fmt.Errorf("encoding history: %w", err)
+	}
+
+	f, err := aghrenameio.NewPendingFile(filepath.Join(dir, historyFileName), aghos.DefaultPermFile)
+	if err != nil {
+		return fmt.Errorf("creating history file: %w", err)
+	}
+	defer func() { err = aghrenameio.WithDeferredCleanup(err, f) }()
+
+	_, err = f.Write(data)
+	if err != nil {
+		return fmt.Errorf("writing history file: %w", err)
+	}
+
+	return nil
+}
+
+// readRuleSet returns the set of rules from the filter list file at p.  Empty
+// lines and comments are skipped.
+func readRuleSet(p string) (set *container.MapSet[string], err error) {
+	f, err := os.Open(p)
+	if err != nil {
+		// Don't wrap the error since it's informative enough as is.
+		return nil, err
+	}
+	defer func() { err = errors.WithDeferred(err, f.Close()) }()
+
+	set = container.NewMapSet[string]()
+	s := bufio.NewScanner(f)
+	s.Buffer(nil, bufio.MaxScanTokenSize)
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || line[0] == '!' || line[0] == '#' {
+			continue
+		}
+
+		set.Add(line)
+	}
+
+	return set, s.Err()
+}
+
+// diffRuleSets returns the sorted rules present in cur but not in prev and the
+// ones present in prev but not in cur.
+func diffRuleSets(prev, cur *container.MapSet[string]) (added, removed []string) {
+	cur.Range(func(r string) (cont bool) {
+		if !prev.Has(r) {
+			added = append(added, r)
+		}
+
+		return true
+	})
+
+	prev.Range(func(r string) (cont bool) {
+		if !cur.Has(r) {
+			removed = append(removed, r)
+		}
+
+		return true
+	})
+
+	slices.Sort(added)
+	slices.Sort(removed)
+
+	return added, removed
+}
+
+// filterDiff is the difference between a version of a filter list and the next
+// one.
+type filterDiff struct {
+	Added   []string `json:"added"`
+	Removed []string `json:"removed"`
+
+	// AddedCount is the total number of rules added, which may be greater
+	// than the length of Added, since the latter is truncated.
+	AddedCount int `json:"added_count"`
+
+	// RemovedCount is the total number of rules removed, which may be greater
+	// than the length of Removed, since the latter is truncated.
+	RemovedCount int `json:"removed_count"`
+}
+
+// filterVersionDiff returns the changes made to the version of flt by the
+// update replacing it.
+func (d *DNSFilter) filterVersionDiff(flt *FilterYAML, version string) (diff *filterDiff, err error) {
+	hist, err := loadHistory(flt.historyPath(d.conf.DataDir))
+	if err != nil {
+		return nil, err
+	}
+
+	i := slices.IndexFunc(hist, func(u *filterUpdate) (ok bool) { return u.Version == version })
+	if i == -1 {
+		return nil, errNoVersion
+	}
+
+	nextPath := flt.Path(d.conf.DataDir)
+	if i+1 < len(hist) {
+		nextPath = flt.versionPath(d.conf.DataDir, hist[i+1].Version)
+	}
+
+	prev, err := readRuleSet(flt.versionPath(d.conf.DataDir, version))
+	if err != nil {
+		return nil, fmt.Errorf("reading version: %w", err)
+	}
+
+	next, err := readRuleSet(nextPath)
+	if err != nil {
+		return nil, fmt.Errorf("reading next version: %w", err)
+	}
+
+	added, removed := diffRuleSets(prev, next)
+
+	return &filterDiff{
+		Added:        added[:min(len(added), maxDiffRules)],
+		Removed:      removed[:min(len(removed), maxDiffRules)],
+		AddedCount:   len(added),
+		RemovedCount: len(removed),
+	}, nil
+}
+
+// pinFilter restores the version of the filter list with listURL and stops
+// updating it.  If version is empty, the list is unpinned and updated again on
+// the next refresh.
+func (d *DNSFilter) pinFilter(listURL string, isAllowlist bool, version string) (err error) {
+	// Don't let the refresh replace the list file concurrently.
+	d.refreshLock.Lock()
+	defer d.refreshLock.Unlock()
+
+	d.conf.filtersMu.Lock()
+	defer d.conf.filtersMu.Unlock()
+
+	filters := d.conf.Filters
+	if isAllowlist {
+		filters = d.conf.WhitelistFilters
+	}
+
+	i := slices.IndexFunc(filters, func(flt FilterYAML) bool { return flt.URL == listURL })
+	if i == -1 {
+		return errFilterNotExist
+	}
+
+	flt := &filters[i]
+	if version == "" {
+		log.Info("filtering: unpinned filter %d", flt.ID)
+
+		flt.Pinned = ""
+		flt.LastUpdated = time.Time{}
+
+		return nil
+	} else if d.conf.FiltersHistorySize == 0 {
+		return errNoHistory
+	}
+
+	hist, err := loadHistory(flt.historyPath(d.conf.DataDir))
+	if err != nil {
+		return err
+	}
+
+	if !slices.ContainsFunc(hist, func(u *filterUpdate) (ok bool) { return u.Version == version }) {
+		return errNoVersion
+	}
+
+	err = d.restoreVersion(flt, version)
+	if err != nil {
+		return fmt.Errorf("restoring version %s: %w", version, err)
+	}
+
+	flt.Pinned = version
+
+	log.Info("filtering: pinned filter %d to version %s", flt.ID, version)
+
+	return d.load(flt)
+}
+
+// restoreVersion replaces the contents of flt with its version.
+func (d *DNSFilter) restoreVersion(flt *FilterYAML, version string) (err error) {
+	src, err := os.Open(flt.versionPath(d.conf.DataDir, version))
+	if err != nil {
+		// Don't wrap the error since it's informative enough as is.
+		return err
+	}
+	defer func() { err = errors.WithDeferred(err, src.Close()) }()
+
+	dst, err := aghrenameio.NewPendingFile(flt.Path(d.conf.DataDir), aghos.DefaultPermFile)
+	if err != nil {
+		return fmt.Errorf("creating filter file: %w", err)
+	}
+	defer func() { err = aghrenameio.WithDeferredCleanup(err, dst) }()
+
+	_, err = io.Copy(dst, src)
+	if err != nil {
+		return fmt.Errorf("copying version file: %w", err)
+	}
+
+	return nil
+}
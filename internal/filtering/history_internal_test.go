@@ -0,0 +1,83 @@
+package filtering
+
+import (
+	"net/http"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDNSFilter_History(t *testing.T) {
+	contents := []string{
+		"||first.example^\n||common.example^\n",
+		"! Comment\n||second.example^\n||common.example^\n",
+		"||third.example^\n||common.example^\n||another.example^\n",
+	}
+
+	var cur atomic.Int32
+	addr := serveHTTPLocally(t, http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte(contents[cur.Load()]))
+	}))
+
+	d := newDNSFilter(t)
+	d.conf.FiltersHistorySize = 1
+	d.conf.Filters = []FilterYAML{{
+		Enabled: true,
+		URL:     addr,
+		Name:    "test-filter",
+	}}
+
+	flt := &d.conf.Filters[0]
+	for i := range contents {
+		cur.Store(int32(i))
+
+		ok, err := d.update(flt)
+		require.NoError(t, err)
+		require.True(t, ok)
+	}
+
+	hist, err := loadHistory(flt.historyPath(d.conf.DataDir))
+	require.NoError(t, err)
+	require.Len(t, hist, 1)
+
+	upd := hist[0]
+	assert.Equal(t, 3, upd.RulesCount)
+	assert.Equal(t, 2, upd.PrevRulesCount)
+	assert.Equal(t, 2, upd.Added)
+	assert.Equal(t, 1, upd.Removed)
+
+	t.Run("diff", func(t *testing.T) {
+		diff, diffErr := d.filterVersionDiff(flt, upd.Version)
+		require.NoError(t, diffErr)
+
+		assert.Equal(t, &filterDiff{
+			Added:        []string{"||another.example^", "||third.example^"},
+			Removed:      []string{"||second.example^"},
+			AddedCount:   2,
+			RemovedCount: 1,
+		}, diff)
+
+		_, diffErr = d.filterVersionDiff(flt, "unknown")
+		assert.ErrorIs(t, diffErr, errNoVersion)
+	})
+
+	t.Run("pin", func(t *testing.T) {
+		pinErr := d.pinFilter(addr, false, "unknown")
+		assert.ErrorIs(t, pinErr, errNoVersion)
+
+		pinErr = d.pinFilter(addr, false, upd.Version)
+		require.NoError(t, pinErr)
+
+		assert.Equal(t, upd.Version, flt.Pinned)
+		assert.Equal(t, 2, flt.RulesCount)
+		assert.Empty(t, d.listsToUpdate(&d.conf.Filters, true))
+
+		pinErr = d.pinFilter(addr, false, "")
+		require.NoError(t, pinErr)
+
+		assert.Empty(t, flt.Pinned)
+		assert.Len(t, d.listsToUpdate(&d.conf.Filters, false), 1)
+	})
+}
@@ -0,0 +1,144 @@
+package filtering
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/aghhttp"
+	"github.com/AdguardTeam/golibs/errors"
+)
+
+// filterHistoryJSON is the response to the GET /control/filtering/history HTTP
+// API.
+type filterHistoryJSON struct {
+	// Updates are the recorded updates of the filter list, oldest first.
+	Updates []*filterUpdate `json:"updates"`
+
+	// Pinned is the version the filter list is pinned to, if any.
+	Pinned string `json:"pinned,omitempty"`
+}
+
+// filterPinJSON is the request to the POST /control/filtering/pin HTTP API.
+type filterPinJSON struct {
+	URL string `json:"url"`
+
+	// Version is the version to pin the filter list to.  If it's empty, the
+	// filter list is unpinned.
+	Version string `json:"version"`
+
+	Whitelist bool `json:"whitelist"`
+}
+
+// filterFromQuery returns a copy of the filter list identified by the url and
+// whitelist query parameters of r.
+func (d *DNSFilter) filterFromQuery(r *http.Request) (flt FilterYAML, err error) {
+	q := r.URL.Query()
+	listURL := q.Get("url")
+
+	var isAllowlist bool
+	if v := q.Get("whitelist"); v != "" {
+		isAllowlist, err = strconv.ParseBool(v)
+		if err != nil {
+			return FilterYAML{}, fmt.Errorf("whitelist: %w", err)
+		}
+	}
+
+	d.conf.filtersMu.RLock()
+	defer d.conf.filtersMu.RUnlock()
+
+	filters := d.conf.Filters
+	if isAllowlist {
+		filters = d.conf.WhitelistFilters
+	}
+
+	i := slices.IndexFunc(filters, func(f FilterYAML) bool { return f.URL == listURL })
+	if i == -1 {
+		return FilterYAML{}, errFilterNotExist
+	}
+
+	return filters[i], nil
+}
+
+// handleFilteringHistory is the handler for the GET /control/filtering/history
+// HTTP API.
+func (d *DNSFilter) handleFilteringHistory(w http.ResponseWriter, r *http.Request) {
+	flt, err := d.filterFromQuery(r)
+	if err != nil {
+		aghhttp.Error(r, w, http.StatusBadRequest, "%s", err)
+
+		return
+	}
+
+	hist, err := loadHistory(flt.historyPath(d.conf.DataDir))
+	if err != nil {
+		aghhttp.Error(r, w, http.StatusInternalServerError, "loading history: %s", err)
+
+		return
+	}
+
+	if hist == nil {
+		hist = []*filterUpdate{}
+	}
+
+	aghhttp.WriteJSONResponseOK(w, r, &filterHistoryJSON{
+		Updates: hist,
+		Pinned:  flt.Pinned,
+	})
+}
+
+// handleFilteringDiff is the handler for the GET /control/filtering/diff HTTP
+// API.
+func (d *DNSFilter) handleFilteringDiff(w http.ResponseWriter, r *http.Request) {
+	flt, err := d.filterFromQuery(r)
+	if err != nil {
+		aghhttp.Error(r, w, http.StatusBadRequest, "%s", err)
+
+		return
+	}
+
+	diff, err := d.filterVersionDiff(&flt, r.URL.Query().Get("version"))
+	if errors.Is(err, errNoVersion) {
+		aghhttp.Error(r, w, http.StatusNotFound, "%s", err)
+
+		return
+	} else if err != nil {
+		aghhttp.Error(r, w, http.StatusInternalServerError, "computing diff: %s", err)
+
+		return
+	}
+
+	aghhttp.WriteJSONResponseOK(w, r, diff)
+}
+
+// handleFilteringPin is the handler for the POST /control/filtering/pin HTTP
+// API.
+func (d *DNSFilter) handleFilteringPin(w http.ResponseWriter, r *http.Request) {
+	req := &filterPinJSON{}
+	err := json.NewDecoder(r.Body).Decode(req)
+	if err != nil {
+		aghhttp.Error(r, w, http.StatusBadRequest, "json.Decode: %s", err)
+
+		return
+	}
+
+	err = d.pinFilter(req.URL, req.Whitelist, req.Version)
+	if errors.Is(err, errNoVersion) {
+		aghhttp.Error(r, w, http.StatusNotFound, "%s", err)
+
+		return
+	} else if errors.Is(err, errFilterNotExist) || errors.Is(err, errNoHistory) {
+		aghhttp.Error(r, w, http.StatusBadRequest, "%s", err)
+
+		return
+	} else if err != nil {
+		aghhttp.Error(r, w, http.StatusInternalServerError, "pinning filter: %s", err)
+
+		return
+	}
+
+	d.conf.ConfigModified()
+	d.EnableFilters(true)
+}
@@ -15,6 +15,7 @@ import (
 	"github.com/AdguardTeam/AdGuardHome/internal/aghhttp"
 	"github.com/AdguardTeam/AdGuardHome/internal/aghos"
 	"github.com/AdguardTeam/AdGuardHome/internal/filtering/rulelist"
+	"github.com/AdguardTeam/AdGuardHome/internal/schedule"
 	"github.com/AdguardTeam/golibs/errors"
 	"github.com/AdguardTeam/golibs/log"
 	"github.com/AdguardTeam/golibs/netutil/urlutil"
@@ -56,9 +57,34 @@ func (d *DNSFilter) validateFilterURL(urlStr string) (err error) {
 }
 
 type filterAddJSON struct {
-	Name      string `json:"name"`
-	URL       string `json:"url"`
-	Whitelist bool   `json:"whitelist"`
+	Name string `json:"name"`
+	URL  string `json:"url"`
+
+	// Mirrors are the additional URLs of the filter list tried in order.
+	Mirrors []string `json:"mirrors,omitempty"`
+
+	// UpdateInterval is the update interval of the filter list in hours.  If
+	// 0, the global one is used.
+	UpdateInterval uint32 `json:"update_interval,omitempty"`
+
+	Whitelist bool `json:"whitelist"`
+}
+
+// validateFilterSources returns an error if any of mirrors is invalid or ivl
+// isn't a valid update interval.  ivl may be nil.
+func (d *DNSFilter) validateFilterSources(mirrors []string, ivl *uint32) (err error) {
+	for i, m := range mirrors {
+		err = d.validateFilterURL(m)
+		if err != nil {
+			return fmt.Errorf("mirrors: at index %d: %w", i, err)
+		}
+	}
+
+	if ivl != nil && !ValidateUpdateIvl(*ivl) {
+		return fmt.Errorf("update_interval: bad value %d", *ivl)
+	}
+
+	return nil
 }
 
 func (d *DNSFilter) handleFilteringAddURL(w http.ResponseWriter, r *http.Request) {
@@ -77,6 +103,13 @@ func (d *DNSFilter) handleFilteringAddURL(w http.ResponseWriter, r *http.Request
 		return
 	}
 
+	err = d.validateFilterSources(fj.Mirrors, &fj.UpdateInterval)
+	if err != nil {
+		aghhttp.Error(r, w, http.StatusBadRequest, "%s", err)
+
+		return
+	}
+
 	// Check for duplicates
 	if d.filterExists(fj.URL) {
 		err = errFilterExists
@@ -87,10 +120,12 @@ func (d *DNSFilter) handleFilteringAddURL(w http.ResponseWriter, r *http.Request
 
 	// Set necessary properties
 	filt := FilterYAML{
-		Enabled: true,
-		URL:     fj.URL,
-		Name:    fj.Name,
-		white:   fj.Whitelist,
+		Enabled:             true,
+		URL:                 fj.URL,
+		Name:                fj.Name,
+		Mirrors:             fj.Mirrors,
+		UpdateIntervalHours: fj.UpdateInterval,
+		white:               fj.Whitelist,
 		Filter: Filter{
 			ID: d.idGen.next(),
 		},
@@ -184,6 +219,7 @@ func (d *DNSFilter) handleFilteringRemoveURL(w http.ResponseWriter, r *http.Requ
 		}
 
 		*filters = slices.Delete(*filters, delIdx, delIdx+1)
+		d.removeHistory(&deleted)
 
 		log.Info("deleted filter %d", deleted.ID)
 	}()
@@ -204,9 +240,23 @@ func (d *DNSFilter) handleFilteringRemoveURL(w http.ResponseWriter, r *http.Requ
 }
 
 type filterURLReqData struct {
-	Name    string `json:"name"`
-	URL     string `json:"url"`
-	Enabled bool   `json:"enabled"`
+	// Schedule is the schedule during which the filter is paused.  If it's nil,
+	// the previous schedule is kept.  An empty schedule removes the previous
+	// one.
+	Schedule *schedule.Weekly `json:"schedule,omitempty"`
+
+	// UpdateInterval is the update interval of the filter in hours.  If it's
+	// nil, the previous interval is kept.
+	UpdateInterval *uint32 `json:"update_interval,omitempty"`
+
+	Name string `json:"name"`
+	URL  string `json:"url"`
+
+	// Mirrors are the additional URLs of the filter tried in order.  If it's
+	// nil, the previous mirrors are kept.
+	Mirrors []string `json:"mirrors"`
+
+	Enabled bool `json:"enabled"`
 }
 
 type filterURLReq struct {
@@ -237,13 +287,22 @@ func (d *DNSFilter) handleFilteringSetURL(w http.ResponseWriter, r *http.Request
 		return
 	}
 
+	err = d.validateFilterSources(fj.Data.Mirrors, fj.Data.UpdateInterval)
+	if err != nil {
+		aghhttp.Error(r, w, http.StatusBadRequest, "%s", err)
+
+		return
+	}
+
 	filt := FilterYAML{
-		Enabled: fj.Data.Enabled,
-		Name:    fj.Data.Name,
-		URL:     fj.Data.URL,
+		Enabled:  fj.Data.Enabled,
+		Name:     fj.Data.Name,
+		URL:      fj.Data.URL,
+		Schedule: fj.Data.Schedule,
+		Mirrors:  fj.Data.Mirrors,
 	}
 
-	restart, err := d.filterSetProperties(fj.URL, filt, fj.Whitelist)
+	restart, err := d.filterSetProperties(fj.URL, filt, fj.Whitelist, fj.Data.UpdateInterval)
 	if err != nil {
 		aghhttp.Error(r, w, http.StatusBadRequest, err.Error())
 
@@ -313,11 +372,16 @@ func (d *DNSFilter) handleFilteringRefresh(w http.ResponseWriter, r *http.Reques
 }
 
 type filterJSON struct {
+	Schedule    *schedule.Weekly     `json:"schedule,omitempty"`
 	URL         string               `json:"url"`
 	Name        string               `json:"name"`
 	LastUpdated string               `json:"last_updated,omitempty"`
+	Pinned      string               `json:"pinned,omitempty"`
+	Mirrors     []string             `json:"mirrors,omitempty"`
+	Health      *filterHealthJSON    `json:"health,omitempty"`
 	ID          rulelist.URLFilterID `json:"id"`
 	RulesCount  uint32               `json:"rules_count"`
+	UpdateIvl   uint32               `json:"update_interval,omitempty"`
 	Enabled     bool                 `json:"enabled"`
 }
 
@@ -331,11 +395,16 @@ type filteringConfig struct {
 
 func filterToJSON(f FilterYAML) filterJSON {
 	fj := filterJSON{
+		Schedule:   f.Schedule,
 		ID:         f.ID,
 		Enabled:    f.Enabled,
 		URL:        f.URL,
 		Name:       f.Name,
 		RulesCount: uint32(f.RulesCount),
+		Pinned:     f.Pinned,
+		Mirrors:    f.Mirrors,
+		UpdateIvl:  f.UpdateIntervalHours,
+		Health:     f.health.toJSON(),
 	}
 
 	if !f.LastUpdated.IsZero() {
@@ -565,6 +634,8 @@ func (d *DNSFilter) RegisterFilteringHandlers() {
 	registerHTTP(http.MethodPost, "/control/rewrite/add", d.handleRewriteAdd)
 	registerHTTP(http.MethodPut, "/control/rewrite/update", d.handleRewriteUpdate)
 	registerHTTP(http.MethodPost, "/control/rewrite/delete", d.handleRewriteDelete)
+	registerHTTP(http.MethodGet, "/control/rewrite/export", d.handleRewriteExport)
+	registerHTTP(http.MethodPost, "/control/rewrite/import", d.handleRewriteImport)
 
 	registerHTTP(http.MethodGet, "/control/blocked_services/services", d.handleBlockedServicesIDs)
 	registerHTTP(http.MethodGet, "/control/blocked_services/all", d.handleBlockedServicesAll)
@@ -583,6 +654,9 @@ func (d *DNSFilter) RegisterFilteringHandlers() {
 	registerHTTP(http.MethodPost, "/control/filtering/set_url", d.handleFilteringSetURL)
 	registerHTTP(http.MethodPost, "/control/filtering/refresh", d.handleFilteringRefresh)
 	registerHTTP(http.MethodPost, "/control/filtering/set_rules", d.handleFilteringSetRules)
+	registerHTTP(http.MethodGet, "/control/filtering/history", d.handleFilteringHistory)
+	registerHTTP(http.MethodGet, "/control/filtering/diff", d.handleFilteringDiff)
+	registerHTTP(http.MethodPost, "/control/filtering/pin", d.handleFilteringPin)
 	registerHTTP(http.MethodGet, "/control/filtering/check_host", d.handleCheckHost)
 }
 
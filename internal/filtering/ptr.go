@@ -0,0 +1,178 @@
+package filtering
+
+import (
+	"net/netip"
+	"slices"
+	"strings"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/filtering/rulelist"
+	"github.com/AdguardTeam/golibs/log"
+	"github.com/AdguardTeam/golibs/netutil"
+	"github.com/AdguardTeam/urlfilter/rules"
+	"github.com/miekg/dns"
+)
+
+// ptrRewrite is a hostname resolved to an address by a $dnsrewrite rule.  It's
+// used to synthesize PTR responses.
+type ptrRewrite struct {
+	// rule is the $dnsrewrite rule resolving host.
+	rule *ResultRule
+
+	// host is the hostname resolved by rule.
+	host string
+}
+
+// ptrRewrites is the index of the $dnsrewrite rules by the addresses they
+// resolve hostnames to.
+type ptrRewrites map[netip.Addr][]*ptrRewrite
+
+// newPTRRewrites returns the index of the A and AAAA $dnsrewrite rules with
+// exact hostname patterns from the filters having their data in memory, such as
+// the custom filtering rules.  Filters stored in files aren't indexed to avoid
+// reading them once again.
+func newPTRRewrites(filters []Filter) (idx ptrRewrites) {
+	idx = ptrRewrites{}
+	for _, f := range filters {
+		if len(f.Data) == 0 {
+			continue
+		}
+
+		for _, line := range strings.Split(string(f.Data), "\n") {
+			line = strings.TrimSpace(line)
+			host, addr, ok := ptrRewriteFromRule(line, f.ID)
+			if !ok {
+				continue
+			}
+
+			idx[addr] = append(idx[addr], &ptrRewrite{
+				rule: &ResultRule{
+					Text:         line,
+					FilterListID: f.ID,
+				},
+				host: host,
+			})
+		}
+	}
+
+	return idx
+}
+
+// ptrRewriteFromRule returns the hostname and the address from the rule text if
+// it's a $dnsrewrite rule resolving an exact hostname to an IP address and has
+// no other modifiers, such as $client, since those can't be taken into account
+// for PTR requests.
+func ptrRewriteFromRule(text string, id rulelist.URLFilterID) (host string, addr netip.Addr, ok bool) {
+	pat, opts, found := strings.Cut(text, "$")
+	if !found || !strings.HasPrefix(opts, "dnsrewrite=") || strings.Contains(opts, ",") {
+		return "", netip.Addr{}, false
+	}
+
+	if !strings.HasPrefix(pat, "|") || !strings.HasSuffix(pat, "^") {
+		return "", netip.Addr{}, false
+	}
+
+	host = strings.ToLower(strings.TrimSuffix(strings.TrimLeft(pat, "|"), "^"))
+	if netutil.ValidateDomainName(host) != nil {
+		return "", netip.Addr{}, false
+	}
+
+	nr, err := rules.NewNetworkRule(text, id)
+	if err != nil {
+		log.Debug("filtering: parsing rule %q for ptr: %s", text, err)
+
+		return "", netip.Addr{}, false
+	}
+
+	rw := nr.DNSRewrite
+	if nr.Whitelist || rw == nil || rw.RCode != dns.RcodeSuccess || rw.NewCNAME != "" {
+		return "", netip.Addr{}, false
+	}
+
+	addr, ok = rw.Value.(netip.Addr)
+	if !ok || (rw.RRType != dns.TypeA && rw.RRType != dns.TypeAAAA) {
+		return "", netip.Addr{}, false
+	}
+
+	return host, addr.Unmap(), true
+}
+
+// ReversePTR returns the result with the PTR records for addr synthesized from
+// the rewrites and the A and AAAA $dnsrewrite rules of the custom filtering
+// rules, if the filtering is enabled in setts.  setts must not be nil.
+//
+// If several sources resolve hostnames to addr, the rewrites take precedence
+// over the rules.  The wildcard, disabled, and exception rewrites, as well as
+// the ones not applying to the client, are ignored.  All the hostnames of the
+// source are returned in the order of their appearance.
+func (d *DNSFilter) ReversePTR(addr netip.Addr, setts *Settings) (res Result) {
+	if !setts.FilteringEnabled {
+		return Result{}
+	}
+
+	addr = addr.Unmap()
+
+	res = d.reverseRewrites(addr, setts)
+	if res.Reason == Rewritten {
+		return res
+	}
+
+	d.engineLock.RLock()
+	defer d.engineLock.RUnlock()
+
+	var names []rules.RRValue
+	for _, pr := range d.ptrRewrites[addr] {
+		name := dns.Fqdn(pr.host)
+		if slices.Contains(names, rules.RRValue(name)) {
+			continue
+		}
+
+		names = append(names, name)
+		res.Rules = append(res.Rules, pr.rule)
+	}
+
+	if len(names) == 0 {
+		return Result{}
+	}
+
+	res.Reason = RewrittenRule
+	res.DNSRewriteResult = &DNSRewriteResult{
+		Response: DNSRewriteResultResponse{dns.TypePTR: names},
+		RCode:    dns.RcodeSuccess,
+	}
+
+	return res
+}
+
+// reverseRewrites returns the result with the PTR records for addr synthesized
+// from the rewrites.
+func (d *DNSFilter) reverseRewrites(addr netip.Addr, setts *Settings) (res Result) {
+	d.confMu.RLock()
+	defer d.confMu.RUnlock()
+
+	var names []rules.RRValue
+	for _, rw := range d.conf.Rewrites {
+		if rw.IP.Unmap() != addr || isWildcard(rw.Domain) || !rw.appliesTo(setts) {
+			continue
+		}
+
+		name := dns.Fqdn(rw.Domain)
+		if slices.Contains(names, rules.RRValue(name)) {
+			continue
+		}
+
+		names = append(names, name)
+		res.TTL = minRewriteTTL(res.TTL, rw.TTL)
+	}
+
+	if len(names) == 0 {
+		return Result{}
+	}
+
+	res.Reason = Rewritten
+	res.DNSRewriteResult = &DNSRewriteResult{
+		Response: DNSRewriteResultResponse{dns.TypePTR: names},
+		RCode:    dns.RcodeSuccess,
+	}
+
+	return res
+}
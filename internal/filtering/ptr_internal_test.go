@@ -0,0 +1,139 @@
+package filtering
+
+import (
+	"net/netip"
+	"testing"
+
+	"github.com/AdguardTeam/urlfilter/rules"
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDNSFilter_ReversePTR(t *testing.T) {
+	const text = `
+|rule.example^$dnsrewrite=192.168.1.20
+||rule6.example^$dnsrewrite=NOERROR;AAAA;fd00::20
+|both.example^$dnsrewrite=192.168.1.10
+|client.example^$dnsrewrite=192.168.1.30,client=127.0.0.1
+*.wild.example^$dnsrewrite=192.168.1.40
+@@|rule.example^$dnsrewrite
+`
+
+	c := &Config{
+		Rewrites: []*LegacyRewrite{{
+			Domain: "nas.lan",
+			Answer: "192.168.1.10",
+			TTL:    60,
+		}, {
+			Domain: "nas-alias.lan",
+			Answer: "192.168.1.10",
+			TTL:    30,
+		}, {
+			Domain: "*.wild.lan",
+			Answer: "192.168.1.50",
+		}, {
+			Domain:   "off.lan",
+			Answer:   "192.168.1.60",
+			Disabled: true,
+		}, {
+			Domain:  "scoped.lan",
+			Answer:  "192.168.1.70",
+			Clients: []string{"client"},
+		}},
+	}
+
+	f, setts := newForTest(t, c, []Filter{{ID: 0, Data: []byte(text)}})
+	t.Cleanup(f.Close)
+
+	testCases := []struct {
+		setts      *Settings
+		name       string
+		addr       netip.Addr
+		wantNames  []rules.RRValue
+		wantReason Reason
+		wantTTL    uint32
+	}{{
+		setts:      setts,
+		name:       "rewrites",
+		addr:       netip.MustParseAddr("192.168.1.10"),
+		wantNames:  []rules.RRValue{"nas.lan.", "nas-alias.lan."},
+		wantReason: Rewritten,
+		wantTTL:    30,
+	}, {
+		setts:      setts,
+		name:       "rule",
+		addr:       netip.MustParseAddr("192.168.1.20"),
+		wantNames:  []rules.RRValue{"rule.example."},
+		wantReason: RewrittenRule,
+	}, {
+		setts:      setts,
+		name:       "rule_ipv6",
+		addr:       netip.MustParseAddr("fd00::20"),
+		wantNames:  []rules.RRValue{"rule6.example."},
+		wantReason: RewrittenRule,
+	}, {
+		setts:      setts,
+		name:       "rule_with_client",
+		addr:       netip.MustParseAddr("192.168.1.30"),
+		wantNames:  nil,
+		wantReason: NotFilteredNotFound,
+	}, {
+		setts:      setts,
+		name:       "wildcard_rule",
+		addr:       netip.MustParseAddr("192.168.1.40"),
+		wantNames:  nil,
+		wantReason: NotFilteredNotFound,
+	}, {
+		setts:      setts,
+		name:       "wildcard_rewrite",
+		addr:       netip.MustParseAddr("192.168.1.50"),
+		wantNames:  nil,
+		wantReason: NotFilteredNotFound,
+	}, {
+		setts:      setts,
+		name:       "disabled_rewrite",
+		addr:       netip.MustParseAddr("192.168.1.60"),
+		wantNames:  nil,
+		wantReason: NotFilteredNotFound,
+	}, {
+		setts:      setts,
+		name:       "scoped_rewrite_other_client",
+		addr:       netip.MustParseAddr("192.168.1.70"),
+		wantNames:  nil,
+		wantReason: NotFilteredNotFound,
+	}, {
+		setts: &Settings{
+			ClientName:       "client",
+			FilteringEnabled: true,
+		},
+		name:       "scoped_rewrite",
+		addr:       netip.MustParseAddr("192.168.1.70"),
+		wantNames:  []rules.RRValue{"scoped.lan."},
+		wantReason: Rewritten,
+	}, {
+		setts:      &Settings{FilteringEnabled: false},
+		name:       "filtering_disabled",
+		addr:       netip.MustParseAddr("192.168.1.10"),
+		wantNames:  nil,
+		wantReason: NotFilteredNotFound,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			res := f.ReversePTR(tc.addr, tc.setts)
+			require.Equal(t, tc.wantReason, res.Reason)
+
+			if tc.wantNames == nil {
+				assert.Nil(t, res.DNSRewriteResult)
+
+				return
+			}
+
+			require.NotNil(t, res.DNSRewriteResult)
+
+			assert.Equal(t, tc.wantNames, res.DNSRewriteResult.Response[dns.TypePTR])
+			assert.Equal(t, tc.wantTTL, res.TTL)
+		})
+	}
+}
@@ -14,8 +14,16 @@ type Item struct {
 	Domain string `yaml:"domain"`
 
 	// Answer is the IP address, canonical name, or one of the special
-	// values: "A" or "AAAA".
+	// values: "A" or "AAAA".  If Type is set, it's the value of the record of
+	// that type in the dnsrewrite rule syntax.
 	Answer string `yaml:"answer"`
+
+	// Type is the explicit DNS record type of the answer, for example "MX".
+	// If empty, the type is inferred from Answer.
+	Type string `yaml:"type,omitempty"`
+
+	// Disabled, if true, means that the rewrite isn't applied.
+	Disabled bool `yaml:"disabled,omitempty"`
 }
 
 // equal returns true if rw is equal to other.
@@ -29,13 +37,22 @@ func (rw *Item) equal(other *Item) (ok bool) {
 	return *rw == *other
 }
 
-// toRule converts rw to a filter rule.
+// toRule converts rw to a filter rule.  It returns an empty string if rw is
+// nil or disabled.
 func (rw *Item) toRule() (res string) {
-	if rw == nil {
+	if rw == nil || rw.Disabled {
 		return ""
 	}
 
 	domain := strings.ToLower(rw.Domain)
+	if rw.Type != "" && rw.Answer != strings.ToUpper(rw.Type) {
+		return fmt.Sprintf(
+			"|%s^$dnsrewrite=NOERROR;%s;%s",
+			domain,
+			strings.ToUpper(rw.Type),
+			rw.Answer,
+		)
+	}
 
 	dType, exception := rw.rewriteParams()
 	dTypeKey := dns.TypeToString[dType]
@@ -113,6 +113,30 @@ func TestItem_toRule(t *testing.T) {
 			Answer: "AAAA",
 		},
 		want: "@@||example.org^$dnstype=AAAA,dnsrewrite",
+	}, {
+		name: "typed_mx_rule",
+		item: &Item{
+			Domain: testDomain,
+			Answer: "10 mail.example.org",
+			Type:   "mx",
+		},
+		want: "|example.org^$dnsrewrite=NOERROR;MX;10 mail.example.org",
+	}, {
+		name: "typed_a_exception",
+		item: &Item{
+			Domain: testDomain,
+			Answer: "A",
+			Type:   "A",
+		},
+		want: "@@||example.org^$dnstype=A,dnsrewrite",
+	}, {
+		name: "disabled",
+		item: &Item{
+			Domain:   testDomain,
+			Answer:   "1.1.1.1",
+			Disabled: true,
+		},
+		want: "",
 	}}
 
 	for _, tc := range testCases {
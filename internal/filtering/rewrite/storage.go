@@ -198,7 +198,10 @@ func (s *DefaultStorage) resetRules() (err error) {
 	// TODO(a.garipov): Use strings.Builder.
 	var rulesText []string
 	for _, rewrite := range s.rewrites {
-		rulesText = append(rulesText, rewrite.toRule())
+		rule := rewrite.toRule()
+		if rule != "" {
+			rulesText = append(rulesText, rule)
+		}
 	}
 
 	strList := &filterlist.StringRuleList{
@@ -227,11 +230,6 @@ func matchesQType(dnsrr *rules.DNSRewrite, qt uint16) (ok bool) {
 		return true
 	}
 
-	// Reject types other than A and AAAA.
-	if qt != dns.TypeA && qt != dns.TypeAAAA {
-		return false
-	}
-
 	return dnsrr.RRType == qt
 }
 
@@ -2,6 +2,7 @@ package filtering
 
 import (
 	"encoding/json"
+	"fmt"
 	"net/http"
 	"slices"
 
@@ -11,28 +12,77 @@ import (
 
 // TODO(d.kolyshev): Use [rewrite.Item] instead.
 type rewriteEntryJSON struct {
+	// Enabled shows if the rewrite is applied.  If absent, the rewrite is
+	// considered enabled for the new ones and keeps the previous state for
+	// the updated ones.
+	Enabled *bool `json:"enabled,omitempty"`
+
 	Domain string `json:"domain"`
 	Answer string `json:"answer"`
+
+	// Type is the explicit DNS record type of the answer.
+	Type string `json:"type,omitempty"`
+
+	Comment string   `json:"comment,omitempty"`
+	Clients []string `json:"clients,omitempty"`
+	Tags    []string `json:"tags,omitempty"`
+
+	// TTL is the TTL of the answer records in seconds.
+	TTL uint32 `json:"ttl,omitempty"`
+}
+
+// newRewriteEntryJSON returns the JSON representation of rw.
+func newRewriteEntryJSON(rw *LegacyRewrite) (j *rewriteEntryJSON) {
+	enabled := !rw.Disabled
+
+	return &rewriteEntryJSON{
+		Enabled: &enabled,
+		Domain:  rw.Domain,
+		Answer:  rw.Answer,
+		Type:    rw.RRType,
+		Comment: rw.Comment,
+		Clients: rw.Clients,
+		Tags:    rw.Tags,
+		TTL:     rw.TTL,
+	}
+}
+
+// toLegacyRewrite returns the rewrite built from j.  If j.Enabled is absent,
+// enabled is used.
+func (j *rewriteEntryJSON) toLegacyRewrite(enabled bool) (rw *LegacyRewrite) {
+	if j.Enabled != nil {
+		enabled = *j.Enabled
+	}
+
+	return &LegacyRewrite{
+		Domain:   j.Domain,
+		Answer:   j.Answer,
+		RRType:   j.Type,
+		Comment:  j.Comment,
+		Clients:  j.Clients,
+		Tags:     j.Tags,
+		TTL:      j.TTL,
+		Disabled: !enabled,
+	}
 }
 
 // handleRewriteList is the handler for the GET /control/rewrite/list HTTP API.
 func (d *DNSFilter) handleRewriteList(w http.ResponseWriter, r *http.Request) {
-	arr := []*rewriteEntryJSON{}
+	aghhttp.WriteJSONResponseOK(w, r, d.rewritesJSON())
+}
 
-	func() {
-		d.confMu.RLock()
-		defer d.confMu.RUnlock()
+// rewritesJSON returns the JSON representation of the rewrites.
+func (d *DNSFilter) rewritesJSON() (arr []*rewriteEntryJSON) {
+	arr = []*rewriteEntryJSON{}
 
-		for _, ent := range d.conf.Rewrites {
-			jsonEnt := rewriteEntryJSON{
-				Domain: ent.Domain,
-				Answer: ent.Answer,
-			}
-			arr = append(arr, &jsonEnt)
-		}
-	}()
+	d.confMu.RLock()
+	defer d.confMu.RUnlock()
+
+	for _, ent := range d.conf.Rewrites {
+		arr = append(arr, newRewriteEntryJSON(ent))
+	}
 
-	aghhttp.WriteJSONResponseOK(w, r, arr)
+	return arr
 }
 
 // handleRewriteAdd is the handler for the POST /control/rewrite/add HTTP API.
@@ -45,15 +95,10 @@ func (d *DNSFilter) handleRewriteAdd(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	rw := &LegacyRewrite{
-		Domain: rwJSON.Domain,
-		Answer: rwJSON.Answer,
-	}
+	rw := rwJSON.toLegacyRewrite(true)
 
 	err = rw.normalize()
 	if err != nil {
-		// Shouldn't happen currently, since normalize only returns a non-nil
-		// error when a rewrite is nil, but be change-proof.
 		aghhttp.Error(r, w, http.StatusBadRequest, "normalizing: %s", err)
 
 		return
@@ -86,10 +131,7 @@ func (d *DNSFilter) handleRewriteDelete(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	entDel := &LegacyRewrite{
-		Domain: jsent.Domain,
-		Answer: jsent.Answer,
-	}
+	entDel := jsent.toLegacyRewrite(true)
 	arr := []*LegacyRewrite{}
 
 	func() {
@@ -128,24 +170,7 @@ func (d *DNSFilter) handleRewriteUpdate(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	rwDel := &LegacyRewrite{
-		Domain: updateJSON.Target.Domain,
-		Answer: updateJSON.Target.Answer,
-	}
-
-	rwAdd := &LegacyRewrite{
-		Domain: updateJSON.Update.Domain,
-		Answer: updateJSON.Update.Answer,
-	}
-
-	err = rwAdd.normalize()
-	if err != nil {
-		// Shouldn't happen currently, since normalize only returns a non-nil
-		// error when a rewrite is nil, but be change-proof.
-		aghhttp.Error(r, w, http.StatusBadRequest, "normalizing: %s", err)
-
-		return
-	}
+	rwDel := updateJSON.Target.toLegacyRewrite(true)
 
 	index := -1
 	defer func() {
@@ -157,15 +182,123 @@ func (d *DNSFilter) handleRewriteUpdate(w http.ResponseWriter, r *http.Request)
 	d.confMu.Lock()
 	defer d.confMu.Unlock()
 
-	index = slices.IndexFunc(d.conf.Rewrites, rwDel.equal)
-	if index == -1 {
+	i := slices.IndexFunc(d.conf.Rewrites, rwDel.equal)
+	if i == -1 {
 		aghhttp.Error(r, w, http.StatusBadRequest, "target rule not found")
 
 		return
 	}
 
+	rwAdd := updateJSON.Update.toLegacyRewrite(!d.conf.Rewrites[i].Disabled)
+	err = rwAdd.normalize()
+	if err != nil {
+		aghhttp.Error(r, w, http.StatusBadRequest, "normalizing: %s", err)
+
+		return
+	}
+
+	index = i
 	d.conf.Rewrites = slices.Replace(d.conf.Rewrites, index, index+1, rwAdd)
 
 	log.Debug("rewrite: removed element: %s -> %s", rwDel.Domain, rwDel.Answer)
 	log.Debug("rewrite: added element: %s -> %s", rwAdd.Domain, rwAdd.Answer)
 }
+
+// handleRewriteExport is the handler for the GET /control/rewrite/export HTTP
+// API.
+func (d *DNSFilter) handleRewriteExport(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Disposition", `attachment; filename="rewrites.json"`)
+
+	aghhttp.WriteJSONResponseOK(w, r, &rewriteImportJSON{
+		Rewrites: d.rewritesJSON(),
+	})
+}
+
+// rewriteImportJSON is the JSON object for the bulk import and export of the
+// rewrites.
+type rewriteImportJSON struct {
+	Rewrites []*rewriteEntryJSON `json:"rewrites"`
+
+	// Replace, if true, makes the imported rewrites replace the existing ones
+	// instead of being appended to them.
+	Replace bool `json:"replace,omitempty"`
+}
+
+// rewriteImportRespJSON is the response to the bulk import of the rewrites.
+type rewriteImportRespJSON struct {
+	// Added is the number of the rewrites added.
+	Added int `json:"added"`
+
+	// Skipped is the number of the rewrites skipped, because they already
+	// exist.
+	Skipped int `json:"skipped"`
+}
+
+// handleRewriteImport is the handler for the POST /control/rewrite/import HTTP
+// API.
+func (d *DNSFilter) handleRewriteImport(w http.ResponseWriter, r *http.Request) {
+	req := &rewriteImportJSON{}
+	err := json.NewDecoder(r.Body).Decode(req)
+	if err != nil {
+		aghhttp.Error(r, w, http.StatusBadRequest, "json.Decode: %s", err)
+
+		return
+	}
+
+	rws, err := importedRewrites(req.Rewrites)
+	if err != nil {
+		aghhttp.Error(r, w, http.StatusBadRequest, "%s", err)
+
+		return
+	}
+
+	resp := &rewriteImportRespJSON{}
+	func() {
+		d.confMu.Lock()
+		defer d.confMu.Unlock()
+
+		var existing []*LegacyRewrite
+		if !req.Replace {
+			existing = d.conf.Rewrites
+		}
+
+		for _, rw := range rws {
+			if slices.ContainsFunc(existing, rw.equal) {
+				resp.Skipped++
+
+				continue
+			}
+
+			existing = append(existing, rw)
+			resp.Added++
+		}
+
+		d.conf.Rewrites = existing
+	}()
+
+	log.Debug("rewrite: imported %d elements, skipped %d", resp.Added, resp.Skipped)
+
+	d.conf.ConfigModified()
+
+	aghhttp.WriteJSONResponseOK(w, r, resp)
+}
+
+// importedRewrites returns the normalized rewrites built from entries.
+func importedRewrites(entries []*rewriteEntryJSON) (rws []*LegacyRewrite, err error) {
+	rws = make([]*LegacyRewrite, 0, len(entries))
+	for i, j := range entries {
+		if j == nil {
+			return nil, fmt.Errorf("rewrites: at index %d: no value", i)
+		}
+
+		rw := j.toLegacyRewrite(true)
+		err = rw.normalize()
+		if err != nil {
+			return nil, fmt.Errorf("rewrites: at index %d: %w", i, err)
+		}
+
+		rws = append(rws, rw)
+	}
+
+	return rws, nil
+}
@@ -17,8 +17,15 @@ import (
 
 // TODO(d.kolyshev): Use [rewrite.Item] instead.
 type rewriteJSON struct {
-	Domain string `json:"domain"`
-	Answer string `json:"answer"`
+	Enabled bool   `json:"enabled"`
+	Domain  string `json:"domain"`
+	Answer  string `json:"answer"`
+	Type    string `json:"type,omitempty"`
+}
+
+type rewriteImportJSON struct {
+	Rewrites []*rewriteJSON `json:"rewrites"`
+	Replace  bool           `json:"replace"`
 }
 
 type rewriteUpdateJSON struct {
@@ -34,6 +41,7 @@ const (
 	addURL    = "/control/rewrite/add"
 	deleteURL = "/control/rewrite/delete"
 	updateURL = "/control/rewrite/update"
+	importURL = "/control/rewrite/import"
 
 	decodeErrorMsg = "json.Decode: json: cannot unmarshal string into Go value of type" +
 		" filtering.rewriteEntryJSON\n"
@@ -43,8 +51,8 @@ func TestDNSFilter_handleRewriteHTTP(t *testing.T) {
 	confModCh := make(chan struct{})
 	reqCh := make(chan struct{})
 	testRewrites := []*rewriteJSON{
-		{Domain: "example.local", Answer: "example.rewrite"},
-		{Domain: "one.local", Answer: "one.rewrite"},
+		{Domain: "example.local", Answer: "example.rewrite", Enabled: true},
+		{Domain: "one.local", Answer: "one.rewrite", Enabled: true},
 	}
 
 	testRewritesJSON, mErr := json.Marshal(testRewrites)
@@ -72,13 +80,13 @@ func TestDNSFilter_handleRewriteHTTP(t *testing.T) {
 		name:        "add",
 		url:         addURL,
 		method:      http.MethodPost,
-		reqData:     rewriteJSON{Domain: "add.local", Answer: "add.rewrite"},
+		reqData:     rewriteJSON{Domain: "add.local", Answer: "add.rewrite", Enabled: true},
 		wantConfMod: true,
 		wantStatus:  http.StatusOK,
 		wantBody:    "",
 		wantList: append(
 			testRewrites,
-			&rewriteJSON{Domain: "add.local", Answer: "add.rewrite"},
+			&rewriteJSON{Domain: "add.local", Answer: "add.rewrite", Enabled: true},
 		),
 	}, {
 		name:        "add_error",
@@ -93,11 +101,11 @@ func TestDNSFilter_handleRewriteHTTP(t *testing.T) {
 		name:        "delete",
 		url:         deleteURL,
 		method:      http.MethodPost,
-		reqData:     rewriteJSON{Domain: "one.local", Answer: "one.rewrite"},
+		reqData:     rewriteJSON{Domain: "one.local", Answer: "one.rewrite", Enabled: true},
 		wantConfMod: true,
 		wantStatus:  http.StatusOK,
 		wantBody:    "",
-		wantList:    []*rewriteJSON{{Domain: "example.local", Answer: "example.rewrite"}},
+		wantList:    []*rewriteJSON{{Domain: "example.local", Answer: "example.rewrite", Enabled: true}},
 	}, {
 		name:        "delete_error",
 		url:         deleteURL,
@@ -112,15 +120,15 @@ func TestDNSFilter_handleRewriteHTTP(t *testing.T) {
 		url:    updateURL,
 		method: http.MethodPut,
 		reqData: rewriteUpdateJSON{
-			Target: rewriteJSON{Domain: "one.local", Answer: "one.rewrite"},
-			Update: rewriteJSON{Domain: "upd.local", Answer: "upd.rewrite"},
+			Target: rewriteJSON{Domain: "one.local", Answer: "one.rewrite", Enabled: true},
+			Update: rewriteJSON{Domain: "upd.local", Answer: "upd.rewrite", Enabled: true},
 		},
 		wantConfMod: true,
 		wantStatus:  http.StatusOK,
 		wantBody:    "",
 		wantList: []*rewriteJSON{
-			{Domain: "example.local", Answer: "example.rewrite"},
-			{Domain: "upd.local", Answer: "upd.rewrite"},
+			{Domain: "example.local", Answer: "example.rewrite", Enabled: true},
+			{Domain: "upd.local", Answer: "upd.rewrite", Enabled: true},
 		},
 	}, {
 		name:        "update_error",
@@ -137,13 +145,58 @@ func TestDNSFilter_handleRewriteHTTP(t *testing.T) {
 		url:    updateURL,
 		method: http.MethodPut,
 		reqData: rewriteUpdateJSON{
-			Target: rewriteJSON{Domain: "inv.local", Answer: "inv.rewrite"},
-			Update: rewriteJSON{Domain: "upd.local", Answer: "upd.rewrite"},
+			Target: rewriteJSON{Domain: "inv.local", Answer: "inv.rewrite", Enabled: true},
+			Update: rewriteJSON{Domain: "upd.local", Answer: "upd.rewrite", Enabled: true},
 		},
 		wantConfMod: false,
 		wantStatus:  http.StatusBadRequest,
 		wantBody:    "target rule not found\n",
 		wantList:    testRewrites,
+	}, {
+		name:   "import",
+		url:    importURL,
+		method: http.MethodPost,
+		reqData: rewriteImportJSON{
+			Rewrites: []*rewriteJSON{
+				{Domain: "one.local", Answer: "one.rewrite", Enabled: true},
+				{Domain: "imp.local", Answer: "imp.rewrite", Enabled: false},
+			},
+		},
+		wantConfMod: true,
+		wantStatus:  http.StatusOK,
+		wantBody:    `{"added":1,"skipped":1}` + "\n",
+		wantList: append(
+			testRewrites,
+			&rewriteJSON{Domain: "imp.local", Answer: "imp.rewrite", Enabled: false},
+		),
+	}, {
+		name:   "import_replace",
+		url:    importURL,
+		method: http.MethodPost,
+		reqData: rewriteImportJSON{
+			Rewrites: []*rewriteJSON{
+				{Domain: "imp.local", Answer: "imp.rewrite", Enabled: true},
+			},
+			Replace: true,
+		},
+		wantConfMod: true,
+		wantStatus:  http.StatusOK,
+		wantBody:    `{"added":1,"skipped":0}` + "\n",
+		wantList:    []*rewriteJSON{{Domain: "imp.local", Answer: "imp.rewrite", Enabled: true}},
+	}, {
+		name:   "import_error",
+		url:    importURL,
+		method: http.MethodPost,
+		reqData: rewriteImportJSON{
+			Rewrites: []*rewriteJSON{
+				{Domain: "imp.local", Answer: "imp.rewrite", Enabled: true},
+				{Domain: "bad.local", Answer: "imp.rewrite", Type: "BAD", Enabled: true},
+			},
+		},
+		wantConfMod: false,
+		wantStatus:  http.StatusBadRequest,
+		wantBody:    `rewrites: at index 1: type: bad enum value: "BAD"` + "\n",
+		wantList:    testRewrites,
 	}}
 
 	for _, tc := range testCases {
@@ -228,8 +281,10 @@ func assertRewritesList(t *testing.T, handler http.Handler, wantList []*rewriteJ
 func rewriteEntriesToLegacyRewrites(entries []*rewriteJSON) (rw []*filtering.LegacyRewrite) {
 	for _, entry := range entries {
 		rw = append(rw, &filtering.LegacyRewrite{
-			Domain: entry.Domain,
-			Answer: entry.Answer,
+			Domain:   entry.Domain,
+			Answer:   entry.Answer,
+			RRType:   entry.Type,
+			Disabled: !entry.Enabled,
 		})
 	}
 
@@ -8,6 +8,8 @@ import (
 
 	"github.com/AdguardTeam/golibs/errors"
 	"github.com/AdguardTeam/golibs/log"
+	"github.com/AdguardTeam/golibs/netutil"
+	"github.com/AdguardTeam/urlfilter/rules"
 	"github.com/miekg/dns"
 )
 
@@ -21,20 +23,80 @@ type LegacyRewrite struct {
 	Domain string `yaml:"domain"`
 
 	// Answer is the IP address, canonical name, or one of the special
-	// values: "A" or "AAAA".
+	// values: "A" or "AAAA".  For the other record types, it's the record
+	// data in the zone file format, e.g. "10 mail.example.com" for MX.
 	Answer string `yaml:"answer"`
 
+	// RRType is the explicit DNS record type of the answer, e.g. "MX".  If
+	// empty, the type is inferred from Answer: A, AAAA, or CNAME.
+	RRType string `yaml:"type,omitempty"`
+
+	// Comment is an arbitrary description of the rewrite.
+	Comment string `yaml:"comment,omitempty"`
+
+	// Clients are the names or IP addresses of the clients the rewrite applies
+	// to.  If both Clients and Tags are empty, the rewrite applies to all
+	// clients.
+	Clients []string `yaml:"clients,omitempty"`
+
+	// Tags are the tags of the persistent clients the rewrite applies to.
+	Tags []string `yaml:"tags,omitempty"`
+
 	// IP is the IP address that should be used in the response if Type is
 	// dns.TypeA or dns.TypeAAAA.
 	IP netip.Addr `yaml:"-"`
 
-	// Type is the DNS record type: A, AAAA, or CNAME.
+	// Value is the parsed answer for the record types other than A, AAAA, and
+	// CNAME.  Its type is the same as the type of the values of the
+	// corresponding $dnsrewrite rules, except *dns.CAA for CAA records.
+	Value rules.RRValue `yaml:"-"`
+
+	// TTL is the TTL of the answer records in seconds.  If zero, the TTL of
+	// the blocked responses is used.
+	TTL uint32 `yaml:"ttl,omitempty"`
+
+	// Type is the DNS record type.
 	Type uint16 `yaml:"-"`
+
+	// Disabled, if true, means that the rewrite isn't applied.
+	Disabled bool `yaml:"disabled,omitempty"`
 }
 
-// equal returns true if the rw is equal to the other.
+// equal returns true if the rw is equal to the other.  Only the fields
+// identifying the rewrite are compared, so Comment, TTL, and Disabled are
+// ignored.
 func (rw *LegacyRewrite) equal(other *LegacyRewrite) (ok bool) {
-	return rw.Domain == other.Domain && rw.Answer == other.Answer
+	return rw.Domain == other.Domain &&
+		rw.Answer == other.Answer &&
+		strings.EqualFold(rw.RRType, other.RRType) &&
+		slices.Equal(rw.Clients, other.Clients) &&
+		slices.Equal(rw.Tags, other.Tags)
+}
+
+// appliesTo returns true if rw is enabled and applies to the client described
+// by setts.  setts may be nil.
+func (rw *LegacyRewrite) appliesTo(setts *Settings) (ok bool) {
+	if rw.Disabled {
+		return false
+	} else if len(rw.Clients) == 0 && len(rw.Tags) == 0 {
+		return true
+	} else if setts == nil {
+		return false
+	}
+
+	for _, c := range rw.Clients {
+		if c == setts.ClientName || (setts.ClientIP.IsValid() && c == setts.ClientIP.String()) {
+			return true
+		}
+	}
+
+	for _, tag := range setts.ClientTags {
+		if slices.Contains(rw.Tags, tag) {
+			return true
+		}
+	}
+
+	return false
 }
 
 // matchesQType returns true if the entry matches the question type qt.
@@ -44,6 +106,10 @@ func (rw *LegacyRewrite) matchesQType(qt uint16) (ok bool) {
 		return true
 	}
 
+	if rw.Value != nil {
+		return rw.Type == qt
+	}
+
 	// Reject types other than A and AAAA.
 	if qt != dns.TypeA && qt != dns.TypeAAAA {
 		return false
@@ -67,6 +133,11 @@ func (rw *LegacyRewrite) normalize() (err error) {
 	// use it in matchDomainWildcard instead of using strings.ToLower
 	// everywhere.
 	rw.Domain = strings.ToLower(rw.Domain)
+	rw.IP, rw.Value = netip.Addr{}, nil
+
+	if rw.RRType != "" {
+		return rw.normalizeTyped()
+	}
 
 	switch rw.Answer {
 	case "AAAA":
@@ -101,6 +172,125 @@ func (rw *LegacyRewrite) normalize() (err error) {
 	return nil
 }
 
+// normalizeTyped normalizes the rewrite with an explicit record type.
+func (rw *LegacyRewrite) normalizeTyped() (err error) {
+	rw.RRType = strings.ToUpper(rw.RRType)
+
+	var ok bool
+	rw.Type, ok = dns.StringToType[rw.RRType]
+	if !ok {
+		return fmt.Errorf("type: %w: %q", errors.ErrBadEnumValue, rw.RRType)
+	}
+
+	switch rw.Type {
+	case dns.TypeA, dns.TypeAAAA:
+		return rw.normalizeIP()
+	case dns.TypeCNAME:
+		err = netutil.ValidateDomainName(rw.Answer)
+		if err != nil {
+			return fmt.Errorf("answer: %w", err)
+		}
+
+		return nil
+	default:
+		rw.Value, err = parseRewriteValue(rw.Type, rw.Answer)
+		if err != nil {
+			return fmt.Errorf("answer: %w", err)
+		}
+
+		return nil
+	}
+}
+
+// normalizeIP normalizes the A or AAAA rewrite with an explicit record type.
+func (rw *LegacyRewrite) normalizeIP() (err error) {
+	if rw.Answer == rw.RRType {
+		// The "A"/"AAAA" exception.
+		return nil
+	}
+
+	rw.IP, err = netip.ParseAddr(rw.Answer)
+	if err != nil {
+		return fmt.Errorf("answer: %w", err)
+	}
+
+	if rw.IP.Is4() != (rw.Type == dns.TypeA) {
+		return fmt.Errorf("answer: %s is not a valid address for type %s", rw.IP, rw.RRType)
+	}
+
+	return nil
+}
+
+// parseRewriteValue parses the answer of a rewrite with the record type rrType
+// other than A, AAAA, and CNAME.
+func parseRewriteValue(rrType uint16, ans string) (v rules.RRValue, err error) {
+	switch rrType {
+	case dns.TypeTXT:
+		return ans, nil
+	case dns.TypePTR:
+		err = netutil.ValidateDomainName(strings.TrimSuffix(ans, "."))
+		if err != nil {
+			// Don't wrap the error since it's informative enough as is.
+			return nil, err
+		}
+
+		return dns.Fqdn(ans), nil
+	case dns.TypeMX, dns.TypeSRV, dns.TypeCAA, dns.TypeHTTPS, dns.TypeSVCB:
+		// Go on.
+	default:
+		return nil, fmt.Errorf("type %s is not supported", dns.Type(rrType))
+	}
+
+	rr, err := dns.NewRR(fmt.Sprintf(". IN %s %s", dns.Type(rrType), ans))
+	if err != nil {
+		// Don't wrap the error since it's informative enough as is.
+		return nil, err
+	} else if rr == nil {
+		return nil, errors.ErrEmptyValue
+	}
+
+	return rewriteValueFromRR(rr), nil
+}
+
+// rewriteValueFromRR converts rr into the value of the corresponding type.
+func rewriteValueFromRR(rr dns.RR) (v rules.RRValue) {
+	switch rr := rr.(type) {
+	case *dns.MX:
+		return &rules.DNSMX{
+			Exchange:   rr.Mx,
+			Preference: rr.Preference,
+		}
+	case *dns.SRV:
+		return &rules.DNSSRV{
+			Target:   rr.Target,
+			Priority: rr.Priority,
+			Weight:   rr.Weight,
+			Port:     rr.Port,
+		}
+	case *dns.HTTPS:
+		return svcbValue(&rr.SVCB)
+	case *dns.SVCB:
+		return svcbValue(rr)
+	default:
+		return rr
+	}
+}
+
+// svcbValue converts rr into the value of an HTTPS or SVCB rewrite.
+func svcbValue(rr *dns.SVCB) (v *rules.DNSSVCB) {
+	v = &rules.DNSSVCB{
+		Params:   make(map[string]string, len(rr.Value)),
+		Target:   rr.Target,
+		Priority: rr.Priority,
+	}
+
+	for _, kv := range rr.Value {
+		v.Params[kv.Key().String()] = kv.String()
+	}
+
+	return v
+}
+
 // isWildcard returns true if pat is a wildcard domain pattern.
 func isWildcard(pat string) bool {
 	return len(pat) > 1 && pat[0] == '*' && pat[1] == '.'
@@ -158,8 +348,13 @@ func findRewrites(
 	entries []*LegacyRewrite,
 	host string,
 	qtype uint16,
+	setts *Settings,
 ) (rewrites []*LegacyRewrite, matched bool) {
 	for _, e := range entries {
+		if !e.appliesTo(setts) {
+			continue
+		}
+
 		if e.Domain != host && !matchDomainWildcard(host, e.Domain) {
 			continue
 		}
@@ -189,11 +384,27 @@ func findRewrites(
 	return rewrites, matched
 }
 
-// setRewriteResult sets the Reason or IPList of res if necessary.  res must not
-// be nil.
+// setRewriteResult sets the Reason, IPList, DNSRewriteResult, or TTL of res if
+// necessary.  res must not be nil.
 func setRewriteResult(res *Result, host string, rewrites []*LegacyRewrite, qtype uint16) {
 	for _, rw := range rewrites {
-		if rw.Type == qtype && (qtype == dns.TypeA || qtype == dns.TypeAAAA) {
+		if rw.Type == qtype {
+			res.TTL = minRewriteTTL(res.TTL, rw.TTL)
+		}
+
+		if rw.Value != nil && rw.Type == qtype {
+			if res.DNSRewriteResult == nil {
+				res.DNSRewriteResult = &DNSRewriteResult{
+					Response: DNSRewriteResultResponse{},
+					RCode:    dns.RcodeSuccess,
+				}
+			}
+
+			resp := res.DNSRewriteResult.Response
+			resp[qtype] = append(resp[qtype], rw.Value)
+
+			log.Debug("rewrite: %s for %s is %q", rw.RRType, host, rw.Answer)
+		} else if rw.Type == qtype && (qtype == dns.TypeA || qtype == dns.TypeAAAA) {
 			if rw.IP == (netip.Addr{}) {
 				// "A"/"AAAA" exception: allow getting from upstream.
 				res.Reason = NotFilteredNotFound
@@ -208,15 +419,35 @@ func setRewriteResult(res *Result, host string, rewrites []*LegacyRewrite, qtype
 	}
 }
 
+// minRewriteTTL returns the minimum of the non-zero TTLs a and b, or zero if
+// both are zero.
+func minRewriteTTL(a, b uint32) (ttl uint32) {
+	switch {
+	case a == 0:
+		return b
+	case b == 0:
+		return a
+	default:
+		return min(a, b)
+	}
+}
+
 // cloneRewrites returns a deep copy of entries.
 func cloneRewrites(entries []*LegacyRewrite) (clone []*LegacyRewrite) {
 	clone = make([]*LegacyRewrite, len(entries))
 	for i, rw := range entries {
 		clone[i] = &LegacyRewrite{
-			Domain: rw.Domain,
-			Answer: rw.Answer,
-			IP:     rw.IP,
-			Type:   rw.Type,
+			Domain:   rw.Domain,
+			Answer:   rw.Answer,
+			RRType:   rw.RRType,
+			Comment:  rw.Comment,
+			Clients:  slices.Clone(rw.Clients),
+			Tags:     slices.Clone(rw.Tags),
+			IP:       rw.IP,
+			Value:    rw.Value,
+			TTL:      rw.TTL,
+			Type:     rw.Type,
+			Disabled: rw.Disabled,
 		}
 	}
 
@@ -5,9 +5,12 @@ import (
 	"net/netip"
 	"testing"
 
+	"github.com/AdguardTeam/golibs/testutil"
+	"github.com/AdguardTeam/urlfilter/rules"
 	"github.com/miekg/dns"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
 )
 
 // TODO(e.burkov): All the tests in this file may and should me merged together.
@@ -206,7 +209,7 @@ func TestRewrites(t *testing.T) {
 
 	for _, tc := range testCases {
 		t.Run(tc.name, func(t *testing.T) {
-			r := d.processRewrites(tc.host, tc.dtyp)
+			r := d.processRewrites(tc.host, tc.dtyp, nil)
 			require.Equalf(t, tc.wantReason, r.Reason, "got %s", r.Reason)
 
 			if tc.wantCName != "" {
@@ -258,7 +261,7 @@ func TestRewritesLevels(t *testing.T) {
 
 	for _, tc := range testCases {
 		t.Run(tc.name, func(t *testing.T) {
-			r := d.processRewrites(tc.host, dns.TypeA)
+			r := d.processRewrites(tc.host, dns.TypeA, nil)
 			assert.Equal(t, Rewritten, r.Reason)
 			require.Len(t, r.IPList, 1)
 		})
@@ -302,7 +305,7 @@ func TestRewritesExceptionCNAME(t *testing.T) {
 
 	for _, tc := range testCases {
 		t.Run(tc.name, func(t *testing.T) {
-			r := d.processRewrites(tc.host, dns.TypeA)
+			r := d.processRewrites(tc.host, dns.TypeA, nil)
 			if tc.want == (netip.Addr{}) {
 				assert.Equal(t, NotFilteredNotFound, r.Reason, "got %s", r.Reason)
 
@@ -394,9 +397,250 @@ func TestRewritesExceptionIP(t *testing.T) {
 				t.SkipNow()
 			}
 
-			r := d.processRewrites(tc.host, tc.dtyp)
+			r := d.processRewrites(tc.host, tc.dtyp, nil)
 			assert.Equal(t, tc.want, r.IPList)
 			assert.Equal(t, tc.wantReason, r.Reason)
 		})
 	}
 }
+
+func TestRewrites_typed(t *testing.T) {
+	d, _ := newForTest(t, nil, nil)
+	t.Cleanup(d.Close)
+
+	d.conf.Rewrites = []*LegacyRewrite{{
+		Domain: "mail.example",
+		Answer: "10 mx.mail.example.",
+		RRType: "MX",
+		TTL:    60,
+	}, {
+		Domain: "mail.example",
+		Answer: "v=spf1 -all",
+		RRType: "txt",
+		TTL:    30,
+	}, {
+		Domain: "mail.example",
+		Answer: `0 issue "ca.example"`,
+		RRType: "CAA",
+	}, {
+		Domain:   "off.example",
+		Answer:   "1.2.3.4",
+		RRType:   "A",
+		Disabled: true,
+	}, {
+		Domain:  "scoped.example",
+		Answer:  "1.2.3.4",
+		RRType:  "A",
+		Clients: []string{"client"},
+		Tags:    []string{"device_pc"},
+	}}
+
+	require.NoError(t, d.prepareRewrites())
+
+	t.Run("mx", func(t *testing.T) {
+		r := d.processRewrites("mail.example", dns.TypeMX, nil)
+		require.Equal(t, Rewritten, r.Reason)
+		require.NotNil(t, r.DNSRewriteResult)
+
+		assert.Equal(t, uint32(60), r.TTL)
+		assert.Equal(t, DNSRewriteResultResponse{
+			dns.TypeMX: {&rules.DNSMX{Exchange: "mx.mail.example.", Preference: 10}},
+		}, r.DNSRewriteResult.Response)
+	})
+
+	t.Run("txt", func(t *testing.T) {
+		r := d.processRewrites("mail.example", dns.TypeTXT, nil)
+		require.Equal(t, Rewritten, r.Reason)
+		require.NotNil(t, r.DNSRewriteResult)
+
+		assert.Equal(t, uint32(30), r.TTL)
+		assert.Equal(t, DNSRewriteResultResponse{
+			dns.TypeTXT: {"v=spf1 -all"},
+		}, r.DNSRewriteResult.Response)
+	})
+
+	t.Run("caa", func(t *testing.T) {
+		r := d.processRewrites("mail.example", dns.TypeCAA, nil)
+		require.Equal(t, Rewritten, r.Reason)
+		require.NotNil(t, r.DNSRewriteResult)
+
+		vals := r.DNSRewriteResult.Response[dns.TypeCAA]
+		require.Len(t, vals, 1)
+		require.IsType(t, &dns.CAA{}, vals[0])
+
+		caa := vals[0].(*dns.CAA)
+		assert.Equal(t, "issue", caa.Tag)
+		assert.Equal(t, "ca.example", caa.Value)
+	})
+
+	t.Run("other_type", func(t *testing.T) {
+		r := d.processRewrites("mail.example", dns.TypeA, nil)
+		assert.Equal(t, Rewritten, r.Reason)
+		assert.Nil(t, r.DNSRewriteResult)
+		assert.Empty(t, r.IPList)
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		r := d.processRewrites("off.example", dns.TypeA, nil)
+		assert.Equal(t, NotFilteredNotFound, r.Reason)
+	})
+
+	want := []netip.Addr{netip.MustParseAddr("1.2.3.4")}
+
+	scopedCases := []struct {
+		setts      *Settings
+		name       string
+		wantReason Reason
+	}{{
+		setts:      nil,
+		name:       "no_settings",
+		wantReason: NotFilteredNotFound,
+	}, {
+		setts:      &Settings{ClientName: "other"},
+		name:       "other_client",
+		wantReason: NotFilteredNotFound,
+	}, {
+		setts:      &Settings{ClientName: "client"},
+		name:       "client_name",
+		wantReason: Rewritten,
+	}, {
+		setts:      &Settings{ClientTags: []string{"device_pc"}},
+		name:       "client_tag",
+		wantReason: Rewritten,
+	}}
+
+	for _, tc := range scopedCases {
+		t.Run(tc.name, func(t *testing.T) {
+			r := d.processRewrites("scoped.example", dns.TypeA, tc.setts)
+			require.Equal(t, tc.wantReason, r.Reason)
+
+			if tc.wantReason == Rewritten {
+				assert.Equal(t, want, r.IPList)
+			}
+		})
+	}
+}
+
+func TestLegacyRewrite_normalize_typed(t *testing.T) {
+	testCases := []struct {
+		rw         *LegacyRewrite
+		name       string
+		wantErrMsg string
+	}{{
+		rw:         &LegacyRewrite{Domain: "a.example", Answer: "1.2.3.4", RRType: "A"},
+		name:       "a",
+		wantErrMsg: "",
+	}, {
+		rw:         &LegacyRewrite{Domain: "a.example", Answer: "::1", RRType: "A"},
+		name:       "a_bad_family",
+		wantErrMsg: "answer: ::1 is not a valid address for type A",
+	}, {
+		rw:         &LegacyRewrite{Domain: "a.example", Answer: "x", RRType: "BAD"},
+		name:       "bad_type",
+		wantErrMsg: `type: bad enum value: "BAD"`,
+	}, {
+		rw: &LegacyRewrite{
+			Domain: "_sip._udp.example",
+			Answer: "10 5 5060 sip.example.",
+			RRType: "SRV",
+		},
+		name:       "srv",
+		wantErrMsg: "",
+	}, {
+		rw:         &LegacyRewrite{Domain: "4.3.2.1.in-addr.arpa", Answer: "host.example", RRType: "PTR"},
+		name:       "ptr",
+		wantErrMsg: "",
+	}, {
+		rw:         &LegacyRewrite{Domain: "a.example", Answer: "1 . alpn=h2", RRType: "HTTPS"},
+		name:       "https",
+		wantErrMsg: "",
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := tc.rw.normalize()
+			testutil.AssertErrorMsg(t, tc.wantErrMsg, err)
+		})
+	}
+}
+
+func TestLegacyRewrite_equal(t *testing.T) {
+	rw := &LegacyRewrite{
+		Domain:  "host.example",
+		Answer:  "1.2.3.4",
+		RRType:  "A",
+		Clients: []string{"1.1.1.1"},
+		Tags:    []string{"user_child"},
+	}
+
+	testCases := []struct {
+		other *LegacyRewrite
+		name  string
+		want  bool
+	}{{
+		other: &LegacyRewrite{
+			Domain:   "host.example",
+			Answer:   "1.2.3.4",
+			RRType:   "a",
+			Comment:  "other comment",
+			Clients:  []string{"1.1.1.1"},
+			Tags:     []string{"user_child"},
+			TTL:      60,
+			Disabled: true,
+		},
+		name: "same",
+		want: true,
+	}, {
+		other: &LegacyRewrite{
+			Domain: "host.example",
+			Answer: "1.2.3.4",
+			RRType: "A",
+			Tags:   []string{"user_child"},
+		},
+		name: "other_clients",
+		want: false,
+	}, {
+		other: &LegacyRewrite{
+			Domain:  "host.example",
+			Answer:  "1.2.3.4",
+			RRType:  "A",
+			Clients: []string{"1.1.1.1"},
+			Tags:    []string{"user_admin"},
+		},
+		name: "other_tags",
+		want: false,
+	}, {
+		other: &LegacyRewrite{
+			Domain:  "host.example",
+			Answer:  "1.2.3.4",
+			Clients: []string{"1.1.1.1"},
+			Tags:    []string{"user_child"},
+		},
+		name: "other_type",
+		want: false,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.want, rw.equal(tc.other))
+		})
+	}
+}
+
+func TestLegacyRewrite_yaml(t *testing.T) {
+	const data = `
+- domain: enabled.example
+  answer: 1.2.3.4
+- domain: disabled.example
+  answer: 1.2.3.4
+  disabled: true
+`
+
+	var rws []*LegacyRewrite
+	err := yaml.Unmarshal([]byte(data), &rws)
+	require.NoError(t, err)
+	require.Len(t, rws, 2)
+
+	assert.True(t, rws[0].appliesTo(nil))
+	assert.False(t, rws[1].appliesTo(nil))
+}
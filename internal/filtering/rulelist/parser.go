@@ -9,23 +9,43 @@ import (
 	"slices"
 
 	"github.com/AdguardTeam/golibs/errors"
+	"github.com/AdguardTeam/urlfilter/rules"
 )
 
+// MaxInvalidLinesSample is the maximum number of invalid lines kept in
+// [ParseResult.InvalidLines].
+const MaxInvalidLinesSample = 5
+
 // Parser is a filtering-rule parser that collects data, such as the checksum
 // and the title, as well as counts rules and removes comments.
 type Parser struct {
-	title      string
-	rulesCount int
-	written    int
-	checksum   uint32
-	titleFound bool
+	title        string
+	invalidLines []string
+	rulesCount   int
+	invalidCount int
+	written      int
+	checksum     uint32
+	titleFound   bool
+
+	// validate, if true, makes the parser validate the rules.
+	validate bool
 }
 
-// NewParser returns a new filtering-rule parser.
+// NewParser returns a new filtering-rule parser.  It doesn't validate the
+// rules, see [NewValidatingParser].
 func NewParser() (p *Parser) {
 	return &Parser{}
 }
 
+// NewValidatingParser returns a new filtering-rule parser that also validates
+// the rules and reports the invalid ones in [ParseResult].  Validation is
+// costly, so it should only be used for the newly downloaded lists.
+func NewValidatingParser() (p *Parser) {
+	return &Parser{
+		validate: true,
+	}
+}
+
 // ParseResult contains information about the results of parsing a
 // filtering-rule list by [Parser.Parse].
 type ParseResult struct {
@@ -39,6 +59,17 @@ type ParseResult struct {
 	// BytesWritten is the number of bytes written to dst.
 	BytesWritten int
 
+	// InvalidLines are the first invalid lines of the list, up to
+	// [MaxInvalidLinesSample].  It's only filled by the parsers returned by
+	// [NewValidatingParser].
+	InvalidLines []string
+
+	// InvalidLinesCount is the number of lines in the list that look like
+	// rules but can't be parsed as such.  These lines are included into
+	// RulesCount.  It's only set by the parsers returned by
+	// [NewValidatingParser].
+	InvalidLinesCount int
+
 	// Checksum is the CRC-32 checksum of the rules content.  That is, excluding
 	// empty lines and comments.
 	Checksum uint32
@@ -82,10 +113,12 @@ func (p *Parser) Parse(dst io.Writer, src io.Reader, buf []byte) (r *ParseResult
 // result returns the current parsing result.
 func (p *Parser) result() (r *ParseResult) {
 	return &ParseResult{
-		Title:        p.title,
-		RulesCount:   p.rulesCount,
-		BytesWritten: p.written,
-		Checksum:     p.checksum,
+		Title:             p.title,
+		InvalidLines:      slices.Clone(p.invalidLines),
+		RulesCount:        p.rulesCount,
+		InvalidLinesCount: p.invalidCount,
+		BytesWritten:      p.written,
+		Checksum:          p.checksum,
 	}
 }
 
@@ -117,6 +150,9 @@ func (p *Parser) processLine(dst io.Writer, line []byte, lineNum int) (n int, er
 	}
 
 	p.rulesCount++
+	if p.validate {
+		p.validateRule(trimmed)
+	}
 	p.checksum = crc32.Update(p.checksum, crc32.IEEETable, trimmed)
 
 	// Assume that there is generally enough space in the buffer to add a
@@ -126,6 +162,34 @@ func (p *Parser) processLine(dst io.Writer, line []byte, lineNum int) (n int, er
 	return n, errors.Annotate(err, "writing rule line: %w")
 }
 
+// validateRule counts the rule as invalid if it can't be parsed.  line is
+// assumed to be trimmed of whitespace characters.  Lines that look like
+// cosmetic or HTML filtering rules aren't validated, since these are ignored by
+// the DNS filtering anyway.
+func (p *Parser) validateRule(line []byte) {
+	if isCosmeticLine(line) {
+		return
+	}
+
+	_, err := rules.NewRule(string(line), 0)
+	if err == nil {
+		return
+	}
+
+	p.invalidCount++
+	if len(p.invalidLines) < MaxInvalidLinesSample {
+		p.invalidLines = append(p.invalidLines, string(line))
+	}
+}
+
+// isCosmeticLine returns true if line likely contains a marker of a cosmetic or
+// an HTML filtering rule.
+func isCosmeticLine(line []byte) (ok bool) {
+	return bytes.IndexByte(line, '#') != -1 ||
+		bytes.Contains(line, []byte("$$")) ||
+		bytes.Contains(line, []byte("$@$"))
+}
+
 // isHTMLLine returns true if line is likely an HTML line.  line is assumed to
 // be trimmed of whitespace characters.
 func isHTMLLine(line []byte) (isHTML bool) {
@@ -26,6 +26,7 @@ func TestParser_Parse(t *testing.T) {
 		wantDst      string
 		wantErrMsg   string
 		wantTitle    string
+		wantInvalid  []string
 		wantRulesNum int
 		wantWritten  int
 	}{{
@@ -129,6 +130,15 @@ func TestParser_Parse(t *testing.T) {
 		wantTitle:    "",
 		wantRulesNum: 1,
 		wantWritten:  len(testRuleTextEtcHostsTab),
+	}, {
+		name:         "invalid",
+		in:           testRuleTextBlocked + testRuleTextInvalid,
+		wantDst:      testRuleTextBlocked + testRuleTextInvalid,
+		wantErrMsg:   "",
+		wantTitle:    "",
+		wantInvalid:  []string{strings.TrimSpace(testRuleTextInvalid)},
+		wantRulesNum: 2,
+		wantWritten:  len(testRuleTextBlocked) + len(testRuleTextInvalid),
 	}}
 
 	for _, tc := range testCases {
@@ -138,7 +148,7 @@ func TestParser_Parse(t *testing.T) {
 			dst := &bytes.Buffer{}
 			buf := make([]byte, rulelist.DefaultRuleBufSize)
 
-			p := rulelist.NewParser()
+			p := rulelist.NewValidatingParser()
 			r, err := p.Parse(dst, strings.NewReader(tc.in), buf)
 			require.NotNil(t, r)
 
@@ -147,6 +157,8 @@ func TestParser_Parse(t *testing.T) {
 			assert.Equal(t, tc.wantTitle, r.Title)
 			assert.Equal(t, tc.wantRulesNum, r.RulesCount)
 			assert.Equal(t, tc.wantWritten, r.BytesWritten)
+			assert.Equal(t, tc.wantInvalid, r.InvalidLines)
+			assert.Equal(t, len(tc.wantInvalid), r.InvalidLinesCount)
 
 			if tc.wantWritten > 0 {
 				assert.NotZero(t, r.Checksum)
@@ -155,6 +167,25 @@ func TestParser_Parse(t *testing.T) {
 	}
 }
 
+func TestParser_Parse_noValidation(t *testing.T) {
+	t.Parallel()
+
+	const in = testRuleTextBlocked + testRuleTextInvalid
+
+	dst := &bytes.Buffer{}
+	buf := make([]byte, rulelist.DefaultRuleBufSize)
+
+	p := rulelist.NewParser()
+	r, err := p.Parse(dst, strings.NewReader(in), buf)
+	require.NoError(t, err)
+	require.NotNil(t, r)
+
+	assert.Equal(t, in, dst.String())
+	assert.Equal(t, 2, r.RulesCount)
+	assert.Empty(t, r.InvalidLines)
+	assert.Zero(t, r.InvalidLinesCount)
+}
+
 func TestParser_Parse_writeError(t *testing.T) {
 	t.Parallel()
 
@@ -33,6 +33,7 @@ const (
 	testRuleTextBlocked2    = "||blocked-2.example^\n"
 	testRuleTextEtcHostsTab = "0.0.0.0 tab..example^\t# A comment.\n"
 	testRuleTextHTML        = "<!DOCTYPE html>\n"
+	testRuleTextInvalid     = "||invalid.example^$unknown-modifier\n"
 	testRuleTextTitle       = "! Title:  " + testTitle + " \n"
 
 	// testRuleTextCosmetic is a cosmetic rule with a zero-width non-joiner.
@@ -1,6 +1,14 @@
 package filtering
 
-import "context"
+import (
+	"context"
+	"fmt"
+	"net/netip"
+	"strings"
+
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/AdguardTeam/golibs/netutil"
+)
 
 // SafeSearch interface describes a service for search engines hosts rewrites.
 type SafeSearch interface {
@@ -29,6 +37,69 @@ type SafeSearchConfig struct {
 	Pixabay    bool `yaml:"pixabay" json:"pixabay"`
 	Yandex     bool `yaml:"yandex" json:"yandex"`
 	YouTube    bool `yaml:"youtube" json:"youtube"`
+
+	// Providers contains the flags for the user-defined providers by their
+	// names.  The flags for unknown providers are ignored.
+	Providers map[string]bool `yaml:"providers,omitempty" json:"providers,omitempty"`
+}
+
+// SafeSearchProvider is the definition of a safe search provider.
+type SafeSearchProvider struct {
+	// Name is the unique name of the provider used to enable it in
+	// [SafeSearchConfig].
+	Name string `yaml:"name" json:"name"`
+
+	// Hosts are the host patterns of the search engine.  A pattern is either a
+	// hostname or a hostname prefixed with "*." that matches all its
+	// subdomains.
+	Hosts []string `yaml:"hosts" json:"hosts"`
+
+	// CNAME is the safe search hostname of the search engine.  Either CNAME or
+	// IPs must be set.
+	CNAME string `yaml:"cname,omitempty" json:"cname,omitempty"`
+
+	// IPs are the addresses of the safe search servers of the search engine.
+	// Either CNAME or IPs must be set.
+	IPs []netip.Addr `yaml:"ips,omitempty" json:"ips,omitempty"`
+}
+
+// Validate returns an error if p is not a valid safe search provider
+// definition.
+func (p *SafeSearchProvider) Validate() (err error) {
+	if p == nil {
+		return errors.ErrNoValue
+	} else if p.Name == "" {
+		return fmt.Errorf("name: %w", errors.ErrEmptyValue)
+	} else if len(p.Hosts) == 0 {
+		return fmt.Errorf("hosts: %w", errors.ErrEmptyValue)
+	}
+
+	for i, h := range p.Hosts {
+		err = netutil.ValidateHostname(strings.TrimPrefix(h, "*."))
+		if err != nil {
+			return fmt.Errorf("hosts: at index %d: %w", i, err)
+		}
+	}
+
+	switch {
+	case p.CNAME != "" && len(p.IPs) > 0:
+		return errors.Error("cname and ips are mutually exclusive")
+	case p.CNAME != "":
+		err = netutil.ValidateHostname(p.CNAME)
+		if err != nil {
+			return fmt.Errorf("cname: %w", err)
+		}
+	case len(p.IPs) > 0:
+		for i, ip := range p.IPs {
+			if !ip.IsValid() {
+				return fmt.Errorf("ips: at index %d: %w", i, errors.ErrNoValue)
+			}
+		}
+	default:
+		return errors.Error("either cname or ips must be set")
+	}
+
+	return nil
 }
 
 // checkSafeSearch checks host with safe search engine.  Matches
@@ -0,0 +1,191 @@
+package safesearch
+
+import (
+	"context"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/filtering"
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/AdguardTeam/golibs/ioutil"
+	"github.com/c2h5oh/datasize"
+)
+
+// builtinProvidersData is the JSON document with the definitions of the
+// built-in providers.  Source rules downloaded from:
+// https://adguardteam.github.io/HostlistsRegistry/assets/engines_safe_search.txt,
+// https://adguardteam.github.io/HostlistsRegistry/assets/youtube_safe_search.txt.
+//
+//go:embed providers.json
+var builtinProvidersData []byte
+
+// builtinProviders returns the definitions of the built-in providers.  Their
+// names are the [Service] values.
+func builtinProviders() (ps []*filtering.SafeSearchProvider) {
+	err := json.Unmarshal(builtinProvidersData, &ps)
+	if err != nil {
+		panic(fmt.Errorf("safesearch: decoding built-in providers: %w", err))
+	}
+
+	return ps
+}
+
+// maxProvidersSize is the maximum size of the provider definitions document
+// loaded from a URL.
+const maxProvidersSize datasize.ByteSize = 1 * datasize.MB
+
+// Providers is the registry of safe search provider definitions.  It contains
+// the built-in providers, the ones from the configuration file, and the ones
+// loaded from a URL.  Providers is safe for concurrent use.
+type Providers struct {
+	// mu protects remote and version.
+	mu *sync.RWMutex
+
+	// builtin are the definitions of the built-in providers.
+	builtin []*filtering.SafeSearchProvider
+
+	// custom are the definitions from the configuration file.
+	custom []*filtering.SafeSearchProvider
+
+	// remote are the definitions loaded from a URL.
+	remote []*filtering.SafeSearchProvider
+
+	// version is incremented each time the definitions change, so that the
+	// filters using them know that they have to be rebuilt.
+	version uint64
+}
+
+// NewProviders returns a new registry with the built-in providers and the
+// user-defined ones from custom.  custom must not be modified after calling
+// NewProviders.
+func NewProviders(custom []*filtering.SafeSearchProvider) (p *Providers, err error) {
+	p = &Providers{
+		mu:      &sync.RWMutex{},
+		builtin: builtinProviders(),
+		custom:  custom,
+	}
+
+	err = validateProviders(p.builtin, custom)
+	if err != nil {
+		return nil, fmt.Errorf("safe_search_providers: %w", err)
+	}
+
+	return p, nil
+}
+
+// validateProviders returns an error if any of the definitions in ps is
+// invalid or if its name is the same as the name of any other definition in ps
+// or in known.
+func validateProviders(known, ps []*filtering.SafeSearchProvider) (err error) {
+	names := make(map[string]struct{}, len(known)+len(ps))
+	for _, p := range known {
+		names[p.Name] = struct{}{}
+	}
+
+	for i, p := range ps {
+		err = p.Validate()
+		if err != nil {
+			return fmt.Errorf("at index %d: %w", i, err)
+		}
+
+		if _, ok := names[p.Name]; ok {
+			return fmt.Errorf("at index %d: name: %w: %q", i, errors.ErrDuplicated, p.Name)
+		}
+
+		names[p.Name] = struct{}{}
+	}
+
+	return nil
+}
+
+// Load loads the provider definitions from the JSON document at u using cli
+// and replaces the previously loaded ones with them.  Their names must not be
+// the same as the ones of the built-in and configured providers.
+func (p *Providers) Load(ctx context.Context, cli *http.Client, u string) (err error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return fmt.Errorf("making request for http url %q: %w", u, err)
+	}
+
+	resp, err := cli.Do(req)
+	if err != nil {
+		return fmt.Errorf("requesting from http url: %w", err)
+	}
+	defer func() { err = errors.WithDeferred(err, resp.Body.Close()) }()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("got status code %d, want %d", resp.StatusCode, http.StatusOK)
+	}
+
+	var ps []*filtering.SafeSearchProvider
+	err = json.NewDecoder(ioutil.LimitReader(resp.Body, maxProvidersSize.Bytes())).Decode(&ps)
+	if err != nil {
+		return fmt.Errorf("decoding providers: %w", err)
+	}
+
+	err = validateProviders(slices.Concat(p.builtin, p.custom), ps)
+	if err != nil {
+		return fmt.Errorf("validating providers: %w", err)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.remote = ps
+	p.version++
+
+	return nil
+}
+
+// currentVersion returns the current version of the definitions.
+func (p *Providers) currentVersion() (version uint64) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	return p.version
+}
+
+// rulesText returns the filtering rules for the providers enabled in conf as
+// well as the version of the definitions used.
+func (p *Providers) rulesText(conf filtering.SafeSearchConfig) (text string, version uint64) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	sb := &strings.Builder{}
+	for _, ps := range [][]*filtering.SafeSearchProvider{p.builtin, p.custom, p.remote} {
+		for _, prov := range ps {
+			if isServiceProtected(conf, prov.Name) {
+				writeProviderRules(sb, prov)
+			}
+		}
+	}
+
+	return sb.String(), p.version
+}
+
+// writeProviderRules writes the filtering rules with the dnsrewrite modifier
+// for the hosts of prov into sb.
+func writeProviderRules(sb *strings.Builder, prov *filtering.SafeSearchProvider) {
+	for _, h := range prov.Hosts {
+		pattern := "|" + strings.ToLower(h) + "^"
+		if prov.CNAME != "" {
+			_, _ = fmt.Fprintf(sb, "%s$dnsrewrite=NOERROR;CNAME;%s\n", pattern, prov.CNAME)
+
+			continue
+		}
+
+		for _, ip := range prov.IPs {
+			rrType := "A"
+			if ip.Is6() {
+				rrType = "AAAA"
+			}
+
+			_, _ = fmt.Fprintf(sb, "%s$dnsrewrite=NOERROR;%s;%s\n", pattern, rrType, ip)
+		}
+	}
+}
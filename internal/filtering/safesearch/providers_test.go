@@ -0,0 +1,181 @@
+package safesearch_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/filtering"
+	"github.com/AdguardTeam/AdGuardHome/internal/filtering/safesearch"
+	"github.com/AdguardTeam/golibs/logutil/slogutil"
+	"github.com/AdguardTeam/golibs/testutil"
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewProviders(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name       string
+		wantErrMsg string
+		custom     []*filtering.SafeSearchProvider
+	}{{
+		name:       "valid",
+		wantErrMsg: "",
+		custom: []*filtering.SafeSearchProvider{{
+			Name:  "brave",
+			Hosts: []string{"search.brave.com"},
+			CNAME: "safesearch.brave.com",
+		}},
+	}, {
+		name: "builtin_name",
+		wantErrMsg: "safe_search_providers: at index 0: name: " +
+			`duplicated value: "google"`,
+		custom: []*filtering.SafeSearchProvider{{
+			Name:  "google",
+			Hosts: []string{"www.google.example"},
+			CNAME: "safe.google.example",
+		}},
+	}, {
+		name:       "no_hosts",
+		wantErrMsg: "safe_search_providers: at index 0: hosts: empty value",
+		custom: []*filtering.SafeSearchProvider{{
+			Name:  "brave",
+			CNAME: "safesearch.brave.com",
+		}},
+	}, {
+		name:       "no_target",
+		wantErrMsg: "safe_search_providers: at index 0: either cname or ips must be set",
+		custom: []*filtering.SafeSearchProvider{{
+			Name:  "brave",
+			Hosts: []string{"search.brave.com"},
+		}},
+	}, {
+		name:       "both_targets",
+		wantErrMsg: "safe_search_providers: at index 0: cname and ips are mutually exclusive",
+		custom: []*filtering.SafeSearchProvider{{
+			Name:  "brave",
+			Hosts: []string{"search.brave.com"},
+			CNAME: "safesearch.brave.com",
+			IPs:   []netip.Addr{netip.MustParseAddr("192.0.2.1")},
+		}},
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			_, err := safesearch.NewProviders(tc.custom)
+			testutil.AssertErrorMsg(t, tc.wantErrMsg, err)
+		})
+	}
+}
+
+func TestDefault_CheckHost_providers(t *testing.T) {
+	const remoteData = `[{` +
+		`"name":"regional",` +
+		`"hosts":["*.search.example"],` +
+		`"ips":["192.0.2.2","2001:db8::2"]` +
+		`}]`
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(remoteData))
+	}))
+	t.Cleanup(srv.Close)
+
+	providers, err := safesearch.NewProviders([]*filtering.SafeSearchProvider{{
+		Name:  "brave",
+		Hosts: []string{"search.brave.com"},
+		CNAME: "safesearch.brave.com",
+	}})
+	require.NoError(t, err)
+
+	ctx := testutil.ContextWithTimeout(t, testTimeout)
+	ss, err := safesearch.NewDefault(ctx, &safesearch.DefaultConfig{
+		Logger: slogutil.NewDiscardLogger(),
+		ServicesConfig: filtering.SafeSearchConfig{
+			Enabled: true,
+			Providers: map[string]bool{
+				"brave":    true,
+				"regional": true,
+			},
+		},
+		Providers: providers,
+		CacheSize: testCacheSize,
+		CacheTTL:  testCacheTTL,
+	})
+	require.NoError(t, err)
+
+	res, err := ss.CheckHost(ctx, "Search.Brave.com", testQType)
+	require.NoError(t, err)
+
+	assert.True(t, res.IsFiltered)
+	assert.Equal(t, "safesearch.brave.com", res.CanonName)
+
+	// The built-in providers aren't enabled.
+	res, err = ss.CheckHost(ctx, "www.google.com", testQType)
+	require.NoError(t, err)
+
+	assert.False(t, res.IsFiltered)
+
+	// The remote providers aren't loaded yet.
+	res, err = ss.CheckHost(ctx, "www.search.example", testQType)
+	require.NoError(t, err)
+
+	assert.False(t, res.IsFiltered)
+
+	err = providers.Load(ctx, srv.Client(), srv.URL)
+	require.NoError(t, err)
+
+	testCases := []struct {
+		want netip.Addr
+		name string
+		host string
+		qt   uint16
+	}{{
+		want: netip.MustParseAddr("192.0.2.2"),
+		name: "a",
+		host: "www.search.example",
+		qt:   dns.TypeA,
+	}, {
+		want: netip.MustParseAddr("2001:db8::2"),
+		name: "aaaa",
+		host: "news.search.example",
+		qt:   dns.TypeAAAA,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			res, err = ss.CheckHost(ctx, tc.host, tc.qt)
+			require.NoError(t, err)
+			require.Len(t, res.Rules, 1)
+
+			assert.Equal(t, tc.want, res.Rules[0].IP)
+		})
+	}
+
+	t.Run("not_subdomain", func(t *testing.T) {
+		res, err = ss.CheckHost(ctx, "search.example", testQType)
+		require.NoError(t, err)
+
+		assert.False(t, res.IsFiltered)
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		err = ss.Update(ctx, filtering.SafeSearchConfig{
+			Enabled: true,
+			Providers: map[string]bool{
+				"brave": true,
+			},
+		})
+		require.NoError(t, err)
+
+		res, err = ss.CheckHost(ctx, "www.search.example", testQType)
+		require.NoError(t, err)
+
+		assert.False(t, res.IsFiltered)
+	})
+}
@@ -44,9 +44,10 @@ const (
 	YouTube    Service = "youtube"
 )
 
-// isServiceProtected returns true if the service safe search is active.
-func isServiceProtected(s filtering.SafeSearchConfig, service Service) (ok bool) {
-	switch service {
+// isServiceProtected returns true if safe search is active for the provider
+// named name.
+func isServiceProtected(s filtering.SafeSearchConfig, name string) (ok bool) {
+	switch Service(name) {
 	case Bing:
 		return s.Bing
 	case DuckDuckGo:
@@ -62,7 +63,7 @@ func isServiceProtected(s filtering.SafeSearchConfig, service Service) (ok bool)
 	case YouTube:
 		return s.YouTube
 	default:
-		panic(fmt.Errorf("safesearch: invalid sources: not found service %q", service))
+		return s.Providers[name]
 	}
 }
 
@@ -84,6 +85,10 @@ type DefaultConfig struct {
 	// ServicesConfig contains safe search settings for services.  It must not
 	// be nil.
 	ServicesConfig filtering.SafeSearchConfig
+
+	// Providers are the definitions of the safe search providers.  If nil,
+	// only the built-in providers are used.
+	Providers *Providers
 }
 
 // Default is the default safe search filter that uses filtering rules with the
@@ -92,13 +97,23 @@ type Default struct {
 	// logger is used for logging the operation of the safe search filter.
 	logger *slog.Logger
 
-	// mu protects engine.
+	// mu protects engine, conf, and version.
 	mu *sync.RWMutex
 
 	// engine is the filtering engine that contains the DNS rewrite rules.
 	// engine may be nil, which means that this safe search filter is disabled.
 	engine *urlfilter.DNSEngine
 
+	// providers are the definitions of the safe search providers.
+	providers *Providers
+
+	// conf is the current safe search configuration.
+	conf filtering.SafeSearchConfig
+
+	// version is the version of the provider definitions engine is built
+	// from.
+	version uint64
+
 	// cache stores safe search filtering results.
 	cache cache.Cache
 
@@ -109,6 +124,15 @@ type Default struct {
 // NewDefault returns an initialized default safe search filter.  ctx is used
 // to log the initial refresh.
 func NewDefault(ctx context.Context, conf *DefaultConfig) (ss *Default, err error) {
+	providers := conf.Providers
+	if providers == nil {
+		providers, err = NewProviders(nil)
+		if err != nil {
+			// Don't wrap the error, because it's informative enough as is.
+			return nil, err
+		}
+	}
+
 	ss = &Default{
 		logger: conf.Logger,
 		mu:     &sync.RWMutex{},
@@ -116,7 +140,9 @@ func NewDefault(ctx context.Context, conf *DefaultConfig) (ss *Default, err erro
 			EnableLRU: true,
 			MaxSize:   conf.CacheSize,
 		}),
-		cacheTTL: conf.CacheTTL,
+		providers: providers,
+		conf:      conf.ServicesConfig,
+		cacheTTL:  conf.CacheTTL,
 	}
 
 	// TODO(s.chzhen):  Move to [Default.InitialRefresh].
@@ -130,7 +156,7 @@ func NewDefault(ctx context.Context, conf *DefaultConfig) (ss *Default, err erro
 }
 
 // resetEngine creates new engine for provided safe search configuration and
-// sets it in ss.
+// sets it in ss.  ss.mu is expected to be locked.
 func (ss *Default) resetEngine(
 	ctx context.Context,
 	listID int,
@@ -138,20 +164,15 @@ func (ss *Default) resetEngine(
 ) (err error) {
 	if !conf.Enabled {
 		ss.logger.DebugContext(ctx, "disabled")
+		ss.version = ss.providers.currentVersion()
 
 		return nil
 	}
 
-	var sb strings.Builder
-	for service, serviceRules := range safeSearchRules {
-		if isServiceProtected(conf, service) {
-			sb.WriteString(serviceRules)
-		}
-	}
-
+	text, version := ss.providers.rulesText(conf)
 	strList := &filterlist.StringRuleList{
 		ID:             listID,
-		RulesText:      sb.String(),
+		RulesText:      text,
 		IgnoreCosmetic: true,
 	}
 
@@ -161,6 +182,7 @@ func (ss *Default) resetEngine(
 	}
 
 	ss.engine = urlfilter.NewDNSEngine(rs)
+	ss.version = version
 
 	ss.logger.InfoContext(ctx, "reset rules", "count", ss.engine.RulesCount)
 
@@ -196,6 +218,8 @@ func (ss *Default) CheckHost(
 		return cachedValue, nil
 	}
 
+	ss.refreshEngine(ctx)
+
 	rewrite := ss.searchHost(host, qtype)
 	if rewrite == nil {
 		return filtering.Result{}, nil
@@ -217,7 +241,38 @@ func (ss *Default) CheckHost(
 	return res, nil
 }
 
-// searchHost looks up DNS rewrites in the internal DNS filtering engine.
+// refreshEngine rebuilds the engine and clears the cache if the provider
+// definitions have changed since the engine was built.
+func (ss *Default) refreshEngine(ctx context.Context) {
+	version := ss.providers.currentVersion()
+
+	ss.mu.RLock()
+	upToDate := ss.version == version
+	ss.mu.RUnlock()
+
+	if upToDate {
+		return
+	}
+
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+
+	if ss.version == version {
+		return
+	}
+
+	err := ss.resetEngine(ctx, rulelist.URLFilterIDSafeSearch, ss.conf)
+	if err != nil {
+		ss.logger.ErrorContext(ctx, "rebuilding engine", slogutil.KeyError, err)
+
+		return
+	}
+
+	ss.cache.Clear()
+}
+
+// searchHost looks up DNS rewrites in the internal DNS filtering engine.  The
+// rewrite of the type qtype is preferred.
 func (ss *Default) searchHost(host string, qtype rules.RRType) (res *rules.DNSRewrite) {
 	ss.mu.RLock()
 	defer ss.mu.RUnlock()
@@ -232,11 +287,17 @@ func (ss *Default) searchHost(host string, qtype rules.RRType) (res *rules.DNSRe
 	})
 
 	rewritesRules := r.DNSRewrites()
-	if len(rewritesRules) > 0 {
-		return rewritesRules[0].DNSRewrite
+	if len(rewritesRules) == 0 {
+		return nil
 	}
 
-	return nil
+	for _, rr := range rewritesRules {
+		if rr.DNSRewrite.RRType == qtype {
+			return rr.DNSRewrite
+		}
+	}
+
+	return rewritesRules[0].DNSRewrite
 }
 
 // newResult creates Result object from rewrite rule.  qtype must be either
@@ -346,6 +407,7 @@ func (ss *Default) Update(ctx context.Context, conf filtering.SafeSearchConfig)
 		return err
 	}
 
+	ss.conf = conf
 	ss.cache.Clear()
 
 	return nil
@@ -0,0 +1,280 @@
+package filtering
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/aghos"
+	"github.com/AdguardTeam/AdGuardHome/internal/aghrenameio"
+	"github.com/AdguardTeam/AdGuardHome/internal/filtering/rulelist"
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/AdguardTeam/golibs/ioutil"
+	"github.com/AdguardTeam/golibs/log"
+	"github.com/AdguardTeam/urlfilter/rules"
+	"github.com/c2h5oh/datasize"
+)
+
+// CustomBlockedService is the definition of a user-defined blocked service or
+// of a service from the remote catalogue.
+type CustomBlockedService struct {
+	// ID is the unique identifier of the service used in
+	// [BlockedServices.IDs].
+	ID string `yaml:"id" json:"id"`
+
+	// Name is the human-readable name of the service.
+	Name string `yaml:"name" json:"name"`
+
+	// IconSVG is the SVG icon of the service.  It may be empty.
+	IconSVG string `yaml:"icon_svg" json:"icon_svg"`
+
+	// Rules are the filtering rules blocking the service.
+	Rules []string `yaml:"rules" json:"rules"`
+}
+
+// Validate returns an error if s is not a valid blocked service definition.
+func (s *CustomBlockedService) Validate() (err error) {
+	if s == nil {
+		return errors.ErrNoValue
+	} else if s.ID == "" {
+		return fmt.Errorf("id: %w", errors.ErrEmptyValue)
+	} else if s.Name == "" {
+		return fmt.Errorf("name: %w", errors.ErrEmptyValue)
+	} else if len(s.Rules) == 0 {
+		return fmt.Errorf("rules: %w", errors.ErrEmptyValue)
+	}
+
+	for i, text := range s.Rules {
+		_, err = rules.NewNetworkRule(text, rulelist.URLFilterIDBlockedService)
+		if err != nil {
+			return fmt.Errorf("rules: at index %d: %w", i, err)
+		}
+	}
+
+	return nil
+}
+
+// toInternal converts s into the internal representation.
+func (s *CustomBlockedService) toInternal() (svc blockedService) {
+	return blockedService{
+		ID:      s.ID,
+		Name:    s.Name,
+		IconSVG: []byte(s.IconSVG),
+		Rules:   s.Rules,
+	}
+}
+
+// blockedServicesIndex is the JSON structure of a remote blocked services
+// catalogue.  It's the same as the one of the Hostlists Registry blocked
+// service index.
+type blockedServicesIndex struct {
+	BlockedServices []*CustomBlockedService `json:"blocked_services"`
+}
+
+// maxBlockedServicesSize is the maximum size of the remote blocked services
+// catalogue.
+const maxBlockedServicesSize datasize.ByteSize = 16 * datasize.MB
+
+// blockedServicesFileName is the name of the file within the data directory
+// containing the last downloaded remote blocked services catalogue.
+const blockedServicesFileName = "blocked_services.json"
+
+var (
+	// servicesMu protects customServices, remoteServices, and remoteUpdated,
+	// and serializes the updates of services.
+	servicesMu = &sync.Mutex{}
+
+	// customServices are the services from the configuration file.
+	customServices []blockedService
+
+	// remoteServices are the services from the remote catalogue.
+	remoteServices []blockedService
+
+	// remoteUpdated is the time of the last update of remoteServices.
+	remoteUpdated time.Time
+)
+
+// InitCustomBlockedServices validates the custom blocked services from conf
+// and merges them and the previously downloaded remote catalogue, if
+// configured, with the built-in services.  It must be called after
+// [InitModule] and before validating any [BlockedServices].
+func InitCustomBlockedServices(conf *Config) (err error) {
+	custom := make([]blockedService, 0, len(conf.CustomBlockedServices))
+	ids := make(map[string]struct{}, len(conf.CustomBlockedServices))
+	for i, s := range conf.CustomBlockedServices {
+		err = s.Validate()
+		if err != nil {
+			return fmt.Errorf("custom_blocked_services: at index %d: %w", i, err)
+		}
+
+		if _, ok := ids[s.ID]; ok {
+			return fmt.Errorf(
+				"custom_blocked_services: at index %d: id: %w: %q",
+				i,
+				errors.ErrDuplicated,
+				s.ID,
+			)
+		}
+
+		ids[s.ID] = struct{}{}
+		custom = append(custom, s.toInternal())
+	}
+
+	var remote []blockedService
+	var updated time.Time
+	if conf.BlockedServicesURL != "" {
+		remote, updated, err = loadBlockedServices(conf.DataDir)
+		if err != nil {
+			// Don't fail the start because of a broken cache.
+			log.Error("filtering: loading remote blocked services: %s", err)
+		}
+	}
+
+	servicesMu.Lock()
+	defer servicesMu.Unlock()
+
+	customServices, remoteServices, remoteUpdated = custom, remote, updated
+	rebuildServices()
+
+	return nil
+}
+
+// loadBlockedServices reads the previously downloaded remote catalogue from
+// dataDir.  svcs is nil if there is no such file.
+func loadBlockedServices(
+	dataDir string,
+) (svcs []blockedService, updated time.Time, err error) {
+	fileName := filepath.Join(dataDir, blockedServicesFileName)
+	fi, err := os.Stat(fileName)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, time.Time{}, nil
+	} else if err != nil {
+		return nil, time.Time{}, fmt.Errorf("getting file stat: %w", err)
+	}
+
+	// #nosec G304 -- Trust the path, since it's constructed from the data
+	// directory.
+	data, err := os.ReadFile(fileName)
+	if err != nil {
+		return nil, time.Time{}, fmt.Errorf("reading file: %w", err)
+	}
+
+	svcs, err = parseBlockedServices(data)
+	if err != nil {
+		return nil, time.Time{}, fmt.Errorf("parsing %q: %w", fileName, err)
+	}
+
+	return svcs, fi.ModTime(), nil
+}
+
+// parseBlockedServices parses and validates the blocked services catalogue
+// from data.
+func parseBlockedServices(data []byte) (svcs []blockedService, err error) {
+	idx := &blockedServicesIndex{}
+	err = json.Unmarshal(data, idx)
+	if err != nil {
+		return nil, fmt.Errorf("decoding catalogue: %w", err)
+	}
+
+	svcs = make([]blockedService, 0, len(idx.BlockedServices))
+	for i, s := range idx.BlockedServices {
+		err = s.Validate()
+		if err != nil {
+			return nil, fmt.Errorf("blocked_services: at index %d: %w", i, err)
+		}
+
+		svcs = append(svcs, s.toInternal())
+	}
+
+	return svcs, nil
+}
+
+// refreshBlockedServices downloads the remote blocked services catalogue, if
+// it's configured and outdated or if force is true, and merges it with the
+// other services.  isNetErr is true if the download failed.
+func (d *DNSFilter) refreshBlockedServices(force bool) (isNetErr bool) {
+	u := d.conf.BlockedServicesURL
+	if u == "" {
+		return false
+	}
+
+	servicesMu.Lock()
+	defer servicesMu.Unlock()
+
+	ivl := time.Duration(d.conf.FiltersUpdateIntervalHours) * time.Hour
+	if !force && time.Since(remoteUpdated) < ivl {
+		return false
+	}
+
+	log.Debug("filtering: downloading blocked services from %q", u)
+
+	data, err := d.downloadBlockedServices(u)
+	if err != nil {
+		log.Error("filtering: downloading blocked services from %q: %s", u, err)
+
+		return true
+	}
+
+	svcs, err := parseBlockedServices(data)
+	if err != nil {
+		log.Error("filtering: blocked services from %q: %s", u, err)
+
+		return false
+	}
+
+	err = d.saveBlockedServices(data)
+	if err != nil {
+		log.Error("filtering: saving blocked services: %s", err)
+	}
+
+	remoteServices, remoteUpdated = svcs, time.Now()
+	rebuildServices()
+
+	log.Info("filtering: updated %d blocked services from %q", len(svcs), u)
+
+	return false
+}
+
+// downloadBlockedServices returns the contents of the remote blocked services
+// catalogue at u.
+func (d *DNSFilter) downloadBlockedServices(u string) (data []byte, err error) {
+	resp, err := d.conf.HTTPClient.Get(u)
+	if err != nil {
+		// Don't wrap the error since it's informative enough as is.
+		return nil, err
+	}
+	defer func() { err = errors.WithDeferred(err, resp.Body.Close()) }()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("got status code %d, want %d", resp.StatusCode, http.StatusOK)
+	}
+
+	r := ioutil.LimitReader(resp.Body, maxBlockedServicesSize.Bytes())
+
+	// This use of ReadAll is safe, because we just limited the appropriate
+	// ReadCloser.
+	return io.ReadAll(r)
+}
+
+// saveBlockedServices writes data into the blocked services catalogue file in
+// the data directory.
+func (d *DNSFilter) saveBlockedServices(data []byte) (err error) {
+	fileName := filepath.Join(d.conf.DataDir, blockedServicesFileName)
+	f, err := aghrenameio.NewPendingFile(fileName, aghos.DefaultPermFile)
+	if err != nil {
+		return fmt.Errorf("creating file: %w", err)
+	}
+	defer func() { err = aghrenameio.WithDeferredCleanup(err, f) }()
+
+	_, err = f.Write(data)
+	if err != nil {
+		return fmt.Errorf("writing file: %w", err)
+	}
+
+	return nil
+}
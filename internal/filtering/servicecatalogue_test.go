@@ -0,0 +1,145 @@
+package filtering
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/schedule"
+	"github.com/AdguardTeam/golibs/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInitCustomBlockedServices(t *testing.T) {
+	initBlockedServices()
+	t.Cleanup(initBlockedServices)
+
+	testCases := []struct {
+		name       string
+		wantErrMsg string
+		custom     []*CustomBlockedService
+	}{{
+		name:       "valid",
+		wantErrMsg: "",
+		custom: []*CustomBlockedService{{
+			ID:    "game_servers",
+			Name:  "Game servers",
+			Rules: []string{"||game.example^"},
+		}},
+	}, {
+		name:       "no_id",
+		wantErrMsg: "custom_blocked_services: at index 0: id: empty value",
+		custom: []*CustomBlockedService{{
+			Name:  "Game servers",
+			Rules: []string{"||game.example^"},
+		}},
+	}, {
+		name:       "no_rules",
+		wantErrMsg: "custom_blocked_services: at index 0: rules: empty value",
+		custom: []*CustomBlockedService{{
+			ID:   "game_servers",
+			Name: "Game servers",
+		}},
+	}, {
+		name: "duplicate",
+		wantErrMsg: "custom_blocked_services: at index 1: id: duplicated value: " +
+			`"game_servers"`,
+		custom: []*CustomBlockedService{{
+			ID:    "game_servers",
+			Name:  "Game servers",
+			Rules: []string{"||game.example^"},
+		}, {
+			ID:    "game_servers",
+			Name:  "Other game servers",
+			Rules: []string{"||other-game.example^"},
+		}},
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := InitCustomBlockedServices(&Config{
+				CustomBlockedServices: tc.custom,
+			})
+			testutil.AssertErrorMsg(t, tc.wantErrMsg, err)
+		})
+	}
+}
+
+func TestDNSFilter_refreshBlockedServices(t *testing.T) {
+	initBlockedServices()
+	t.Cleanup(initBlockedServices)
+
+	const catalogue = `{"blocked_services":[{` +
+		`"id":"remote_service",` +
+		`"name":"Remote service",` +
+		`"icon_svg":"<svg></svg>",` +
+		`"rules":["||remote.example^"]` +
+		`},{` +
+		`"id":"4chan",` +
+		`"name":"4chan",` +
+		`"icon_svg":"<svg></svg>",` +
+		`"rules":["||4chan.example^"]` +
+		`}]}`
+
+	var reqNum atomic.Uint32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reqNum.Add(1)
+		_, _ = w.Write([]byte(catalogue))
+	}))
+	t.Cleanup(srv.Close)
+
+	conf := &Config{
+		CustomBlockedServices: []*CustomBlockedService{{
+			ID:    "game_servers",
+			Name:  "Game servers",
+			Rules: []string{"||game.example^"},
+		}},
+		BlockedServicesURL:         srv.URL,
+		HTTPClient:                 srv.Client(),
+		DataDir:                    t.TempDir(),
+		FiltersUpdateIntervalHours: 24,
+	}
+
+	err := InitCustomBlockedServices(conf)
+	require.NoError(t, err)
+
+	bsvc := &BlockedServices{
+		Schedule: schedule.EmptyWeekly(),
+		IDs:      []string{"game_servers", "remote_service"},
+	}
+	testutil.AssertErrorMsg(t, `unknown blocked-service "remote_service"`, bsvc.Validate())
+
+	d := &DNSFilter{
+		conf: conf,
+	}
+
+	require.False(t, d.refreshBlockedServices(false))
+	require.NoError(t, bsvc.Validate())
+
+	assert.Equal(t, uint32(1), reqNum.Load())
+	assert.FileExists(t, filepath.Join(conf.DataDir, blockedServicesFileName))
+
+	// The catalogue isn't outdated yet.
+	require.False(t, d.refreshBlockedServices(false))
+
+	assert.Equal(t, uint32(1), reqNum.Load())
+
+	// The remote services override the built-in ones.
+	svcRules := currentServices().rules
+	require.Len(t, svcRules["4chan"], 1)
+
+	assert.Equal(t, "||4chan.example^", svcRules["4chan"][0].Text())
+
+	// The catalogue is loaded from the data directory on start.
+	initBlockedServices()
+	require.Error(t, bsvc.Validate())
+
+	err = InitCustomBlockedServices(conf)
+	require.NoError(t, err)
+	require.NoError(t, bsvc.Validate())
+
+	assert.Equal(t, uint32(1), reqNum.Load())
+}
@@ -38,6 +38,10 @@ type clientsContainer struct {
 	// settings.
 	clientChecker BlockedClientChecker
 
+	// cacheRemover removes the DNS caches of the removed and renamed clients.
+	// It may be nil.
+	cacheRemover ClientCacheRemover
+
 	// lock protects all fields.
 	//
 	// TODO(a.garipov): Use a pointer and describe which fields are protected in
@@ -52,6 +56,10 @@ type clientsContainer struct {
 	// persistent clients.
 	safeSearchCacheTTL time.Duration
 
+	// safeSearchProviders are the definitions of the safe search providers to
+	// use for persistent clients.
+	safeSearchProviders *safesearch.Providers
+
 	// testing is a flag that disables some features for internal tests.
 	//
 	// TODO(a.garipov): Awful.  Remove.
@@ -64,6 +72,19 @@ type BlockedClientChecker interface {
 	IsBlockedClient(ip netip.Addr, clientID string) (blocked bool, rule string)
 }
 
+// ClientCacheRemover removes the DNS cache of a persistent client.
+type ClientCacheRemover interface {
+	RemoveClientCache(uid client.UID)
+}
+
+// removeCache removes the DNS cache of the persistent client with uid, if
+// there is one.
+func (clients *clientsContainer) removeCache(uid client.UID) {
+	if clients.cacheRemover != nil {
+		clients.cacheRemover.RemoveClientCache(uid)
+	}
+}
+
 // Init initializes clients container
 // dhcpServer: optional
 // Note: this function must be called only once
@@ -75,6 +96,7 @@ func (clients *clientsContainer) Init(
 	etcHosts *aghnet.HostsContainer,
 	arpDB arpdb.Interface,
 	filteringConf *filtering.Config,
+	safeSearchProviders *safesearch.Providers,
 ) (err error) {
 	// TODO(s.chzhen):  Refactor it.
 	if clients.storage != nil {
@@ -84,11 +106,18 @@ func (clients *clientsContainer) Init(
 	clients.baseLogger = baseLogger
 	clients.safeSearchCacheSize = filteringConf.SafeSearchCacheSize
 	clients.safeSearchCacheTTL = time.Minute * time.Duration(filteringConf.CacheTime)
+	clients.safeSearchProviders = safeSearchProviders
 
 	confClients := make([]*client.Persistent, 0, len(objects))
 	for i, o := range objects {
 		var p *client.Persistent
-		p, err = o.toPersistent(ctx, baseLogger, clients.safeSearchCacheSize, clients.safeSearchCacheTTL)
+		p, err = o.toPersistent(
+			ctx,
+			baseLogger,
+			clients.safeSearchCacheSize,
+			clients.safeSearchCacheTTL,
+			clients.safeSearchProviders,
+		)
 		if err != nil {
 			return fmt.Errorf("init persistent client at index %d: %w", i, err)
 		}
@@ -150,12 +179,32 @@ type clientObject struct {
 	// BlockedServices is the configuration of blocked services of a client.
 	BlockedServices *filtering.BlockedServices `yaml:"blocked_services"`
 
+	// FilteringSchedule is the schedule during which filtering is paused for
+	// the client.
+	FilteringSchedule *schedule.Weekly `yaml:"filtering_schedule,omitempty"`
+
+	// Bedtime is the recurring schedule during which only the allowlisted
+	// domains are resolved for the client.
+	Bedtime *schedule.Weekly `yaml:"bedtime_schedule,omitempty"`
+
+	// PausedUntil is the time until which the protection is paused for the
+	// client.
+	PausedUntil time.Time `yaml:"paused_until,omitempty"`
+
+	// AllowlistOnlyUntil is the time until which only the allowlisted domains
+	// are resolved for the client.
+	AllowlistOnlyUntil time.Time `yaml:"allowlist_only_until,omitempty"`
+
 	Name string `yaml:"name"`
 
 	IDs       []string `yaml:"ids"`
 	Tags      []string `yaml:"tags"`
 	Upstreams []string `yaml:"upstreams"`
 
+	// AllowedDomains are the domains resolved for the client in the
+	// allowlist-only mode.
+	AllowedDomains []string `yaml:"allowed_domains,omitempty"`
+
 	// UID is the unique identifier of the persistent client.
 	UID client.UID `yaml:"uid"`
 
@@ -183,12 +232,18 @@ func (o *clientObject) toPersistent(
 	baseLogger *slog.Logger,
 	safeSearchCacheSize uint,
 	safeSearchCacheTTL time.Duration,
+	safeSearchProviders *safesearch.Providers,
 ) (cli *client.Persistent, err error) {
 	cli = &client.Persistent{
 		Name: o.Name,
 
 		Upstreams: o.Upstreams,
 
+		Bedtime:            o.Bedtime.Clone(),
+		AllowedDomains:     slices.Clone(o.AllowedDomains),
+		PausedUntil:        o.PausedUntil,
+		AllowlistOnlyUntil: o.AllowlistOnlyUntil,
+
 		UID: o.UID,
 
 		UseOwnSettings:        !o.UseGlobalSettings,
@@ -225,6 +280,7 @@ func (o *clientObject) toPersistent(
 			Logger:         logger,
 			ServicesConfig: o.SafeSearchConf,
 			ClientName:     cli.Name,
+			Providers:      safeSearchProviders,
 			CacheSize:      safeSearchCacheSize,
 			CacheTTL:       safeSearchCacheTTL,
 		})
@@ -247,6 +303,7 @@ func (o *clientObject) toPersistent(
 	}
 
 	cli.BlockedServices = o.BlockedServices.Clone()
+	cli.FilteringSchedule = o.FilteringSchedule.Clone()
 
 	cli.Tags = slices.Clone(o.Tags)
 
@@ -264,7 +321,13 @@ func (clients *clientsContainer) forConfig() (objs []*clientObject) {
 		objs = append(objs, &clientObject{
 			Name: cli.Name,
 
-			BlockedServices: cli.BlockedServices.Clone(),
+			BlockedServices:   cli.BlockedServices.Clone(),
+			FilteringSchedule: cli.FilteringSchedule.Clone(),
+
+			Bedtime:            cli.Bedtime.Clone(),
+			PausedUntil:        cli.PausedUntil,
+			AllowlistOnlyUntil: cli.AllowlistOnlyUntil,
+			AllowedDomains:     slices.Clone(cli.AllowedDomains),
 
 			IDs:       cli.IDs(),
 			Tags:      slices.Clone(cli.Tags),
@@ -410,10 +473,12 @@ func (clients *clientsContainer) UpstreamConfigByID(
 		return nil, err
 	}
 
+	// Don't enable the proxy's cache, since the responses are cached by the
+	// DNS server itself.
 	conf = proxy.NewCustomUpstreamConfig(
 		upsConf,
-		c.UpstreamsCacheEnabled,
-		int(c.UpstreamsCacheSize),
+		false,
+		0,
 		config.DNS.EDNSClientSubnet.Enabled,
 	)
 	c.UpstreamConfig = conf
@@ -427,6 +492,17 @@ func (clients *clientsContainer) UpstreamConfigByID(
 	return conf, nil
 }
 
+// type check
+var _ dnsforward.ClientInfoProvider = (*clientsContainer)(nil)
+
+// ClientInfoByID implements the [dnsforward.ClientInfoProvider] interface for
+// *clientsContainer.
+func (clients *clientsContainer) ClientInfoByID(id string) (info *client.Info) {
+	info, _ = clients.storage.FindInfo(id)
+
+	return info
+}
+
 // type check
 var _ client.AddressUpdater = (*clientsContainer)(nil)
 
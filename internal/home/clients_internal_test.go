@@ -31,6 +31,7 @@ func newClientsContainer(t *testing.T) (c *clientsContainer) {
 		nil,
 		nil,
 		&filtering.Config{},
+		nil,
 	)
 
 	require.NoError(t, err)
@@ -62,3 +63,24 @@ func TestClientsCustomUpstream(t *testing.T) {
 	require.NotNil(t, upsConf)
 	assert.NoError(t, err)
 }
+
+func TestClientsContainer_ClientInfoByID(t *testing.T) {
+	clients := newClientsContainer(t)
+	ctx := testutil.ContextWithTimeout(t, testTimeout)
+
+	uid := client.MustNewUID()
+	err := clients.storage.Add(ctx, &client.Persistent{
+		Name: "client1",
+		UID:  uid,
+		IPs:  []netip.Addr{netip.MustParseAddr("1.1.1.1")},
+		Tags: []string{"device_phone"},
+	})
+	require.NoError(t, err)
+
+	assert.Nil(t, clients.ClientInfoByID("1.2.3.4"))
+	assert.Equal(t, &client.Info{
+		Name: "client1",
+		Tags: []string{"device_phone"},
+		UID:  uid,
+	}, clients.ClientInfoByID("1.1.1.1"))
+}
@@ -4,8 +4,11 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"maps"
 	"net/http"
 	"net/netip"
+	"slices"
+	"time"
 
 	"github.com/AdguardTeam/AdGuardHome/internal/aghalg"
 	"github.com/AdguardTeam/AdGuardHome/internal/aghhttp"
@@ -14,6 +17,7 @@ import (
 	"github.com/AdguardTeam/AdGuardHome/internal/filtering/safesearch"
 	"github.com/AdguardTeam/AdGuardHome/internal/schedule"
 	"github.com/AdguardTeam/AdGuardHome/internal/whois"
+	"github.com/AdguardTeam/golibs/errors"
 	"github.com/AdguardTeam/golibs/logutil/slogutil"
 )
 
@@ -41,6 +45,30 @@ type clientJSON struct {
 	// Schedule is blocked services schedule for every day of the week.
 	Schedule *schedule.Weekly `json:"blocked_services_schedule"`
 
+	// ServiceSchedules are the schedules of individual blocked services.
+	ServiceSchedules map[string]*schedule.Weekly `json:"blocked_services_schedules,omitempty"`
+
+	// FilteringSchedule is the schedule during which filtering is paused for
+	// the client.  If it's nil or empty, filtering is never paused.
+	FilteringSchedule *schedule.Weekly `json:"filtering_schedule,omitempty"`
+
+	// Bedtime is the recurring schedule during which only the allowlisted
+	// domains are resolved for the client.
+	Bedtime *schedule.Weekly `json:"bedtime_schedule,omitempty"`
+
+	// PausedUntil is the time until which the protection is paused for the
+	// client.  It's ignored in requests, see [clientModeJSON].
+	PausedUntil *time.Time `json:"paused_until,omitempty"`
+
+	// AllowlistOnlyUntil is the time until which only the allowlisted domains
+	// are resolved for the client.  It's ignored in requests, see
+	// [clientModeJSON].
+	AllowlistOnlyUntil *time.Time `json:"allowlist_only_until,omitempty"`
+
+	// Mode is the current temporary mode of the client.  It's ignored in
+	// requests.
+	Mode string `json:"mode,omitempty"`
+
 	Name string `json:"name"`
 
 	// BlockedServices is the names of blocked services.
@@ -49,6 +77,10 @@ type clientJSON struct {
 	Tags            []string `json:"tags"`
 	Upstreams       []string `json:"upstreams"`
 
+	// AllowedDomains are the domains resolved for the client in the
+	// allowlist-only mode.
+	AllowedDomains []string `json:"allowed_domains"`
+
 	FilteringEnabled    bool `json:"filtering_enabled"`
 	ParentalEnabled     bool `json:"parental_enabled"`
 	SafeBrowsingEnabled bool `json:"safebrowsing_enabled"`
@@ -159,11 +191,21 @@ func initPrev(cj clientJSON, prev *client.Persistent) (c *client.Persistent, err
 		upsCacheSize = cj.UpstreamsCacheSize
 	}
 
-	svcs, err := copyBlockedServices(cj.Schedule, cj.BlockedServices, prev)
+	svcs, err := copyBlockedServices(cj.Schedule, cj.ServiceSchedules, cj.BlockedServices, prev)
 	if err != nil {
 		return nil, fmt.Errorf("invalid blocked services: %w", err)
 	}
 
+	c = &client.Persistent{
+		FilteringSchedule: cj.FilteringSchedule.Clone(),
+		Bedtime:           cj.Bedtime.Clone(),
+		AllowedDomains:    slices.Clone(cj.AllowedDomains),
+	}
+
+	if c.FilteringSchedule.IsEmpty() {
+		c.FilteringSchedule = nil
+	}
+
 	if (uid == client.UID{}) {
 		uid, err = client.NewUID()
 		if err != nil {
@@ -171,14 +213,14 @@ func initPrev(cj clientJSON, prev *client.Persistent) (c *client.Persistent, err
 		}
 	}
 
-	return &client.Persistent{
-		BlockedServices:       svcs,
-		UID:                   uid,
-		IgnoreQueryLog:        ignoreQueryLog,
-		IgnoreStatistics:      ignoreStatistics,
-		UpstreamsCacheEnabled: upsCacheEnabled,
-		UpstreamsCacheSize:    upsCacheSize,
-	}, nil
+	c.BlockedServices = svcs
+	c.UID = uid
+	c.IgnoreQueryLog = ignoreQueryLog
+	c.IgnoreStatistics = ignoreStatistics
+	c.UpstreamsCacheEnabled = upsCacheEnabled
+	c.UpstreamsCacheSize = upsCacheSize
+
+	return c, nil
 }
 
 // jsonToClient converts JSON object to persistent client object if there are no
@@ -220,6 +262,7 @@ func (clients *clientsContainer) jsonToClient(
 			Logger:         logger,
 			ServicesConfig: c.SafeSearchConf,
 			ClientName:     c.Name,
+			Providers:      clients.safeSearchProviders,
 			CacheSize:      clients.safeSearchCacheSize,
 			CacheTTL:       clients.safeSearchCacheTTL,
 		})
@@ -266,6 +309,7 @@ func copySafeSearch(
 // services.
 func copyBlockedServices(
 	sch *schedule.Weekly,
+	svcSchs map[string]*schedule.Weekly,
 	svcStrs []string,
 	prev *client.Persistent,
 ) (svcs *filtering.BlockedServices, err error) {
@@ -279,8 +323,16 @@ func copyBlockedServices(
 	}
 
 	svcs = &filtering.BlockedServices{
-		Schedule: weekly,
-		IDs:      svcStrs,
+		Schedule:         weekly,
+		IDs:              svcStrs,
+		ServiceSchedules: svcSchs,
+	}
+
+	if svcSchs == nil && prev != nil {
+		svcs.ServiceSchedules = prev.BlockedServices.Clone().ServiceSchedules
+		maps.DeleteFunc(svcs.ServiceSchedules, func(id string, _ *schedule.Weekly) (del bool) {
+			return !slices.Contains(svcStrs, id)
+		})
 	}
 
 	err = svcs.Validate()
@@ -311,8 +363,16 @@ func clientToJSON(c *client.Persistent) (cj *clientJSON) {
 
 		UseGlobalBlockedServices: !c.UseOwnBlockedServices,
 
-		Schedule:        c.BlockedServices.Schedule,
-		BlockedServices: c.BlockedServices.IDs,
+		Bedtime:            c.Bedtime,
+		PausedUntil:        timeOrNil(c.PausedUntil),
+		AllowlistOnlyUntil: timeOrNil(c.AllowlistOnlyUntil),
+		Mode:               c.ModeAt(time.Now()).String(),
+		AllowedDomains:     c.AllowedDomains,
+
+		Schedule:          c.BlockedServices.Schedule,
+		ServiceSchedules:  c.BlockedServices.ServiceSchedules,
+		FilteringSchedule: c.FilteringSchedule,
+		BlockedServices:   c.BlockedServices.IDs,
 
 		Upstreams: c.Upstreams,
 
@@ -324,6 +384,15 @@ func clientToJSON(c *client.Persistent) (cj *clientJSON) {
 	}
 }
 
+// timeOrNil returns a pointer to t, or nil if t is zero.
+func timeOrNil(t time.Time) (p *time.Time) {
+	if t.IsZero() {
+		return nil
+	}
+
+	return &t
+}
+
 // handleAddClient is the handler for POST /control/clients/add HTTP API.
 func (clients *clientsContainer) handleAddClient(w http.ResponseWriter, r *http.Request) {
 	cj := clientJSON{}
@@ -369,12 +438,15 @@ func (clients *clientsContainer) handleDelClient(w http.ResponseWriter, r *http.
 		return
 	}
 
-	if !clients.storage.RemoveByName(r.Context(), cj.Name) {
+	c, ok := clients.storage.FindByName(cj.Name)
+	if !ok || !clients.storage.RemoveByName(r.Context(), cj.Name) {
 		aghhttp.Error(r, w, http.StatusBadRequest, "Client not found")
 
 		return
 	}
 
+	clients.removeCache(c.UID)
+
 	if !clients.testing {
 		onConfigModified()
 	}
@@ -411,6 +483,13 @@ func (clients *clientsContainer) handleUpdateClient(w http.ResponseWriter, r *ht
 		return
 	}
 
+	// The temporary mode isn't a part of the request, since it's set with
+	// POST /control/clients/set_mode, so keep it.
+	if prev, ok := clients.storage.FindByName(dj.Name); ok {
+		c.PausedUntil = prev.PausedUntil
+		c.AllowlistOnlyUntil = prev.AllowlistOnlyUntil
+	}
+
 	err = clients.storage.Update(r.Context(), dj.Name, c)
 	if err != nil {
 		aghhttp.Error(r, w, http.StatusBadRequest, "%s", err)
@@ -418,6 +497,12 @@ func (clients *clientsContainer) handleUpdateClient(w http.ResponseWriter, r *ht
 		return
 	}
 
+	if c.Name != dj.Name {
+		// The cache of the client is listed under its name, so don't keep the
+		// one of the previous name.
+		clients.removeCache(c.UID)
+	}
+
 	if !clients.testing {
 		onConfigModified()
 	}
@@ -494,4 +579,81 @@ func (clients *clientsContainer) registerWebHandlers() {
 	httpRegister(http.MethodPost, "/control/clients/delete", clients.handleDelClient)
 	httpRegister(http.MethodPost, "/control/clients/update", clients.handleUpdateClient)
 	httpRegister(http.MethodGet, "/control/clients/find", clients.handleFindClient)
+	httpRegister(http.MethodPost, "/control/clients/set_mode", clients.handleSetClientMode)
+}
+
+// clientModeJSON is the JSON structure for the POST /control/clients/set_mode
+// HTTP API.
+type clientModeJSON struct {
+	// Name is the name of the persistent client.
+	Name string `json:"name"`
+
+	// Mode is the temporary mode to set.  It must be one of "normal",
+	// "paused", and "allowlist_only".
+	Mode string `json:"mode"`
+
+	// Duration is the duration of the mode in milliseconds.  It must be
+	// positive unless Mode is "normal".
+	Duration uint `json:"duration"`
+}
+
+// handleSetClientMode is the handler for the POST /control/clients/set_mode
+// HTTP API.
+func (clients *clientsContainer) handleSetClientMode(w http.ResponseWriter, r *http.Request) {
+	req := &clientModeJSON{}
+	err := json.NewDecoder(r.Body).Decode(req)
+	if err != nil {
+		aghhttp.Error(r, w, http.StatusBadRequest, "reading req: %s", err)
+
+		return
+	}
+
+	c, ok := clients.storage.FindByName(req.Name)
+	if !ok {
+		aghhttp.Error(r, w, http.StatusBadRequest, "client %q is not found", req.Name)
+
+		return
+	}
+
+	err = setClientMode(c, req, time.Now())
+	if err != nil {
+		aghhttp.Error(r, w, http.StatusBadRequest, "%s", err)
+
+		return
+	}
+
+	err = clients.storage.Update(r.Context(), req.Name, c)
+	if err != nil {
+		aghhttp.Error(r, w, http.StatusBadRequest, "%s", err)
+
+		return
+	}
+
+	if !clients.testing {
+		onConfigModified()
+	}
+}
+
+// setClientMode sets the temporary mode from req to c.  Setting a temporary
+// mode replaces the other one.
+func setClientMode(c *client.Persistent, req *clientModeJSON, now time.Time) (err error) {
+	until := now.Add(time.Duration(req.Duration) * time.Millisecond)
+	if req.Mode != client.ModeNormal.String() && req.Duration == 0 {
+		return fmt.Errorf("duration: %w", errors.ErrNotPositive)
+	}
+
+	c.PausedUntil, c.AllowlistOnlyUntil = time.Time{}, time.Time{}
+
+	switch req.Mode {
+	case client.ModeNormal.String():
+		// Go on.
+	case client.ModePaused.String():
+		c.PausedUntil = until
+	case client.ModeAllowlistOnly.String():
+		c.AllowlistOnlyUntil = until
+	default:
+		return fmt.Errorf("mode: %w: %q", errors.ErrBadEnumValue, req.Mode)
+	}
+
+	return nil
 }
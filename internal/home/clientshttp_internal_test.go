@@ -342,6 +342,45 @@ func TestClientsContainer_HandleUpdateClient(t *testing.T) {
 	}
 }
 
+func TestClientsContainer_HandleUpdateClient_replace(t *testing.T) {
+	clients := newClientsContainer(t)
+	ctx := testutil.ContextWithTimeout(t, testTimeout)
+
+	pausedUntil := time.Now().Add(time.Hour).Round(0)
+
+	cli := newPersistentClientWithIDs(t, "client1", []string{testClientIP1})
+	cli.AllowedDomains = []string{"school.example"}
+	cli.Bedtime = schedule.FullWeekly()
+	cli.FilteringSchedule = schedule.FullWeekly()
+	cli.PausedUntil = pausedUntil
+	err := clients.storage.Add(ctx, cli)
+	require.NoError(t, err)
+
+	cj := clientToJSON(cli)
+	cj.AllowedDomains = nil
+	cj.Bedtime = nil
+	cj.FilteringSchedule = nil
+
+	body, err := json.Marshal(&updateJSON{
+		Name: cli.Name,
+		Data: *cj,
+	})
+	require.NoError(t, err)
+
+	r := httptest.NewRequest(http.MethodPost, "/control/clients/update", bytes.NewReader(body))
+	rw := httptest.NewRecorder()
+	clients.handleUpdateClient(rw, r)
+	require.Equal(t, http.StatusOK, rw.Code)
+
+	got, ok := clients.storage.FindByName(cli.Name)
+	require.True(t, ok)
+
+	assert.Empty(t, got.AllowedDomains)
+	assert.Nil(t, got.Bedtime)
+	assert.Nil(t, got.FilteringSchedule)
+	assert.Equal(t, pausedUntil, got.PausedUntil)
+}
+
 func TestClientsContainer_HandleFindClient(t *testing.T) {
 	clients := newClientsContainer(t)
 	clients.clientChecker = &testBlockedClientChecker{
@@ -408,3 +447,95 @@ func TestClientsContainer_HandleFindClient(t *testing.T) {
 		})
 	}
 }
+
+func TestClientsContainer_HandleSetClientMode(t *testing.T) {
+	clients := newClientsContainer(t)
+	ctx := testutil.ContextWithTimeout(t, testTimeout)
+
+	cli := newPersistentClientWithIDs(t, "client1", []string{testClientIP1})
+	cli.AllowedDomains = []string{"school.example"}
+	err := clients.storage.Add(ctx, cli)
+	require.NoError(t, err)
+
+	testCases := []struct {
+		name     string
+		req      *clientModeJSON
+		wantMode client.Mode
+		wantCode int
+	}{{
+		name: "paused",
+		req: &clientModeJSON{
+			Name:     cli.Name,
+			Mode:     "paused",
+			Duration: 30 * 60 * 1000,
+		},
+		wantMode: client.ModePaused,
+		wantCode: http.StatusOK,
+	}, {
+		name: "allowlist_only",
+		req: &clientModeJSON{
+			Name:     cli.Name,
+			Mode:     "allowlist_only",
+			Duration: 60 * 60 * 1000,
+		},
+		wantMode: client.ModeAllowlistOnly,
+		wantCode: http.StatusOK,
+	}, {
+		name: "no_duration",
+		req: &clientModeJSON{
+			Name: cli.Name,
+			Mode: "paused",
+		},
+		wantMode: client.ModeAllowlistOnly,
+		wantCode: http.StatusBadRequest,
+	}, {
+		name: "bad_mode",
+		req: &clientModeJSON{
+			Name:     cli.Name,
+			Mode:     "bad",
+			Duration: 1000,
+		},
+		wantMode: client.ModeAllowlistOnly,
+		wantCode: http.StatusBadRequest,
+	}, {
+		name: "not_found",
+		req: &clientModeJSON{
+			Name:     "client_not_found",
+			Mode:     "paused",
+			Duration: 1000,
+		},
+		wantMode: client.ModeAllowlistOnly,
+		wantCode: http.StatusBadRequest,
+	}, {
+		name: "normal",
+		req: &clientModeJSON{
+			Name: cli.Name,
+			Mode: "normal",
+		},
+		wantMode: client.ModeNormal,
+		wantCode: http.StatusOK,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var body []byte
+			body, err = json.Marshal(tc.req)
+			require.NoError(t, err)
+
+			r := httptest.NewRequest(
+				http.MethodPost,
+				"/control/clients/set_mode",
+				bytes.NewReader(body),
+			)
+			rw := httptest.NewRecorder()
+			clients.handleSetClientMode(rw, r)
+			require.Equal(t, tc.wantCode, rw.Code)
+
+			got, ok := clients.storage.FindByName(cli.Name)
+			require.True(t, ok)
+
+			assert.Equal(t, tc.wantMode, got.ModeAt(time.Now()))
+			assert.Equal(t, cli.AllowedDomains, got.AllowedDomains)
+		})
+	}
+}
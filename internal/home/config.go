@@ -7,6 +7,7 @@ import (
 	"os"
 	"path/filepath"
 	"sync"
+	"time"
 
 	"github.com/AdguardTeam/AdGuardHome/internal/aghalg"
 	"github.com/AdguardTeam/AdGuardHome/internal/aghos"
@@ -242,6 +243,20 @@ type dnsConfig struct {
 	// DNS64Prefixes is the list of NAT64 prefixes to be used for DNS64.
 	DNS64Prefixes []netip.Prefix `yaml:"dns64_prefixes"`
 
+	// DNS64Exclusions is the list of IPv4 ranges, the addresses within which
+	// are never synthesized by DNS64.
+	DNS64Exclusions []netip.Prefix `yaml:"dns64_exclusions"`
+
+	// DNS64Profiles are the DNS64 policies for the persistent clients, their
+	// tags, and the listener subnets.  The matching profile may select another
+	// NAT64 prefix or disable DNS64 for the request.
+	DNS64Profiles []*dnsforward.DNS64Profile `yaml:"dns64_profiles"`
+
+	// DNS64Discovery defines if the NAT64 prefix should be discovered from the
+	// upstream servers using the ipv4only.arpa name when DNS64Prefixes is
+	// empty.
+	DNS64Discovery bool `yaml:"dns64_discovery"`
+
 	// ServeHTTP3 defines if HTTP/3 is allowed for incoming requests.
 	//
 	// TODO(a.garipov): Add to the UI when HTTP/3 support is no longer
@@ -284,6 +299,10 @@ type tlsConfigSettings struct {
 	// Allow DoH queries via unencrypted HTTP (e.g. for reverse proxying)
 	AllowUnencryptedDoH bool `yaml:"allow_unencrypted_doh" json:"allow_unencrypted_doh"`
 
+	// ACME is the configuration of obtaining the certificate for ServerName
+	// automatically.  If nil, the certificate is configured manually.
+	ACME *acmeConfig `yaml:"acme,omitempty" json:"-"`
+
 	dnsforward.TLSConfig `yaml:",inline" json:",inline"`
 }
 
@@ -365,6 +384,12 @@ var config = &configuration{
 				Prefix: netip.MustParsePrefix("::1/128"),
 			}},
 			CacheSize: 4 * 1024 * 1024,
+			CacheOptimisticAnswerTTL: timeutil.Duration{
+				Duration: 30 * time.Second,
+			},
+			CacheOptimisticMaxAge: timeutil.Duration{
+				Duration: 12 * time.Hour,
+			},
 
 			EDNSClientSubnet: &dnsforward.EDNSClientSubnet{
 				CustomIP:  netip.Addr{},
@@ -423,6 +448,7 @@ var config = &configuration{
 
 		FilteringEnabled:           true,
 		FiltersUpdateIntervalHours: 24,
+		FiltersHistorySize:         5,
 
 		ParentalEnabled:     false,
 		SafeBrowsingEnabled: false,
@@ -460,6 +486,9 @@ var config = &configuration{
 		Conf6: dhcpd.V6ServerConf{
 			LeaseDuration: dhcpd.DefaultDHCPLeaseTTL,
 		},
+		TFTP: dhcpd.TFTPConfig{
+			Root: dhcpd.DefaultTFTPRoot,
+		},
 	},
 	Clients: &clientsConfig{
 		Sources: &clientSourcesConfig{
@@ -155,6 +155,7 @@ func initDNSServer(
 	}
 
 	Context.clients.clientChecker = Context.dnsServer
+	Context.clients.cacheRemover = Context.dnsServer
 
 	dnsConf, err := newServerConfig(&config.DNS, config.Clients.Sources, tlsConf, httpReg)
 	if err != nil {
@@ -236,6 +237,7 @@ func newServerConfig(
 	fwdConf := dnsConf.Config
 	fwdConf.FilterHandler = applyAdditionalFiltering
 	fwdConf.ClientsContainer = &Context.clients
+	fwdConf.ClientInfoProvider = &Context.clients
 
 	newConf = &dnsforward.ServerConfig{
 		UDPListenAddrs:         ipsToUDPAddrs(hosts, dnsConf.Port),
@@ -250,6 +252,9 @@ func newServerConfig(
 		LocalPTRResolvers:      dnsConf.PrivateRDNSResolvers,
 		UseDNS64:               dnsConf.UseDNS64,
 		DNS64Prefixes:          dnsConf.DNS64Prefixes,
+		DNS64Exclusions:        dnsConf.DNS64Exclusions,
+		DNS64Profiles:          dnsConf.DNS64Profiles,
+		DNS64Discovery:         dnsConf.DNS64Discovery,
 		UsePrivateRDNS:         dnsConf.UsePrivateRDNS,
 		ServeHTTP3:             dnsConf.ServeHTTP3,
 		UseHTTP3Upstreams:      dnsConf.UseHTTP3Upstreams,
@@ -424,14 +429,15 @@ func applyAdditionalFiltering(clientIP netip.Addr, clientID string, setts *filte
 
 	log.Debug("%s: using settings for client %q (%s; %q)", pref, c.Name, clientIP, clientID)
 
+	// TODO(s.chzhen):  Use startTime from [dnsforward.dnsContext].
+	now := time.Now()
+
 	if c.UseOwnBlockedServices {
 		// TODO(e.burkov):  Get rid of this crutch.
 		setts.ServicesRules = nil
-		svcs := c.BlockedServices.IDs
-		if !c.BlockedServices.Schedule.Contains(time.Now()) {
-			Context.filters.ApplyBlockedServicesList(setts, svcs)
-			log.Debug("%s: services for client %q set: %s", pref, c.Name, svcs)
-		}
+		svcs := c.BlockedServices.ActiveIDs(now)
+		Context.filters.ApplyBlockedServicesList(setts, svcs)
+		log.Debug("%s: services for client %q set: %s", pref, c.Name, svcs)
 	}
 
 	setts.ClientName = c.Name
@@ -440,7 +446,8 @@ func applyAdditionalFiltering(clientIP netip.Addr, clientID string, setts *filte
 		return
 	}
 
-	setts.FilteringEnabled = c.FilteringEnabled
+	setts.FilteringEnabled = c.FilteringEnabled &&
+		(c.FilteringSchedule == nil || !c.FilteringSchedule.Contains(now))
 	setts.SafeSearchEnabled = c.SafeSearchConf.Enabled
 	setts.ClientSafeSearch = c.SafeSearch
 	setts.SafeBrowsingEnabled = c.SafeBrowsingEnabled
@@ -472,6 +479,8 @@ func startDNSServer() error {
 	Context.filters.Start()
 	Context.stats.Start()
 
+	startSafeSearchProvidersLoad()
+
 	err = Context.queryLog.Start(ctx)
 	if err != nil {
 		return fmt.Errorf("starting query log: %w", err)
@@ -480,6 +489,31 @@ func startDNSServer() error {
 	return nil
 }
 
+// startSafeSearchProvidersLoad starts loading the safe search provider
+// definitions from the configured URL, if any.  It must be called after the
+// DNS server has been started, since the HTTP client resolves hostnames using
+// it.
+func startSafeSearchProvidersLoad() {
+	u := config.Filtering.SafeSearchProvidersURL
+	if u == "" || Context.safeSearchProviders == nil {
+		return
+	}
+
+	cli := config.Filtering.HTTPClient
+	go func() {
+		defer log.OnPanic("safesearch: loading providers")
+
+		err := Context.safeSearchProviders.Load(context.Background(), cli, u)
+		if err != nil {
+			log.Error("safesearch: loading providers from %q: %s", u, err)
+
+			return
+		}
+
+		log.Info("safesearch: loaded providers from %q", u)
+	}()
+}
+
 func reconfigureDNSServer() (err error) {
 	tlsConf := &tlsConfigSettings{}
 	Context.tls.WriteDiskConfig(tlsConf)
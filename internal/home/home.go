@@ -63,6 +63,10 @@ type homeContext struct {
 	// configuration files, for example /etc/hosts.
 	etcHosts *aghnet.HostsContainer
 
+	// safeSearchProviders are the definitions of the safe search providers
+	// used by the global and the persistent clients' safe search filters.
+	safeSearchProviders *safesearch.Providers
+
 	// mux is our custom http.ServeMux.
 	mux *http.ServeMux
 
@@ -290,6 +294,12 @@ func initContextClients(ctx context.Context, logger *slog.Logger) (err error) {
 	config.DHCP.DataDir = Context.getDataDir()
 	config.DHCP.HTTPRegister = httpRegister
 	config.DHCP.ConfigModified = onConfigModified
+	config.DHCP.Logger = logger.With(slogutil.KeyPrefix, "dhcp")
+
+	// The DHCP server looks up the devices using the conflicting addresses
+	// regardless of the client sources.
+	sysARPDB := arpdb.New(logger.With(slogutil.KeyError, "arpdb"))
+	config.DHCP.ARPDB = sysARPDB
 
 	Context.dhcpServer, err = dhcpd.Create(config.DHCP)
 	if Context.dhcpServer == nil || err != nil {
@@ -302,7 +312,7 @@ func initContextClients(ctx context.Context, logger *slog.Logger) (err error) {
 
 	var arpDB arpdb.Interface
 	if config.Clients.Sources.ARP {
-		arpDB = arpdb.New(logger.With(slogutil.KeyError, "arpdb"))
+		arpDB = sysARPDB
 	}
 
 	return Context.clients.Init(
@@ -313,6 +323,7 @@ func initContextClients(ctx context.Context, logger *slog.Logger) (err error) {
 		Context.etcHosts,
 		arpDB,
 		config.Filtering,
+		Context.safeSearchProviders,
 	)
 }
 
@@ -388,6 +399,11 @@ func setupDNSFilteringConf(
 	conf.UserRules = slices.Clone(config.UserRules)
 	conf.HTTPClient = httpClient()
 
+	err = filtering.InitCustomBlockedServices(conf)
+	if err != nil {
+		return fmt.Errorf("initializing blocked services: %w", err)
+	}
+
 	cacheTime := time.Duration(conf.CacheTime) * time.Minute
 
 	upsOpts := &upstream.Options{
@@ -452,10 +468,16 @@ func setupDNSFilteringConf(
 		conf.ParentalBlockHost = host
 	}
 
+	Context.safeSearchProviders, err = safesearch.NewProviders(conf.SafeSearchProviders)
+	if err != nil {
+		return fmt.Errorf("initializing safesearch providers: %w", err)
+	}
+
 	logger := baseLogger.With(slogutil.KeyPrefix, safesearch.LogPrefix)
 	conf.SafeSearch, err = safesearch.NewDefault(ctx, &safesearch.DefaultConfig{
 		Logger:         logger,
 		ServicesConfig: conf.SafeSearchConf,
+		Providers:      Context.safeSearchProviders,
 		CacheSize:      conf.SafeSearchCacheSize,
 		CacheTTL:       cacheTime,
 	})
@@ -654,7 +676,7 @@ func run(opts options, clientBuildFS fs.FS, done chan struct{}) {
 	Context.auth, err = initUsers()
 	fatalOnError(err)
 
-	Context.tls, err = newTLSManager(config.TLS, config.DNS.ServePlainDNS)
+	Context.tls, err = newTLSManager(slogLogger, config.TLS, config.DNS.ServePlainDNS)
 	if err != nil {
 		log.Error("initializing tls: %s", err)
 		onConfigModified()
@@ -678,6 +700,8 @@ func run(opts options, clientBuildFS fs.FS, done chan struct{}) {
 				closeDNSServer()
 				fatalOnError(startErr)
 			}
+
+			Context.tls.startACME()
 		}()
 
 		if Context.dhcpServer != nil {
@@ -815,6 +839,8 @@ func startMods(l *slog.Logger) (err error) {
 		return err
 	}
 
+	Context.tls.startACME()
+
 	return nil
 }
 
@@ -938,6 +964,7 @@ func cleanup(ctx context.Context) {
 	}
 
 	if Context.tls != nil {
+		Context.tls.shutdownACME(ctx)
 		Context.tls = nil
 	}
 }
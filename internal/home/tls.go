@@ -12,12 +12,14 @@ import (
 	"encoding/json"
 	"encoding/pem"
 	"fmt"
+	"log/slog"
 	"net/http"
 	"os"
 	"strings"
 	"sync"
 	"time"
 
+	"github.com/AdguardTeam/AdGuardHome/internal/acme"
 	"github.com/AdguardTeam/AdGuardHome/internal/aghalg"
 	"github.com/AdguardTeam/AdGuardHome/internal/aghhttp"
 	"github.com/AdguardTeam/AdGuardHome/internal/aghtls"
@@ -25,6 +27,7 @@ import (
 	"github.com/AdguardTeam/golibs/errors"
 	"github.com/AdguardTeam/golibs/log"
 	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
 )
 
 // tlsManager contains the current configuration and state of AdGuard Home TLS
@@ -39,6 +42,10 @@ type tlsManager struct {
 	confLock sync.Mutex
 	conf     tlsConfigSettings
 
+	// acme obtains and renews the certificate automatically.  It's nil if
+	// ACME isn't enabled.
+	acme *acme.Manager
+
 	// servePlainDNS defines if plain DNS is allowed for incoming requests.
 	servePlainDNS bool
 }
@@ -46,17 +53,31 @@ type tlsManager struct {
 // newTLSManager initializes the manager of TLS configuration.  m is always
 // non-nil while any returned error indicates that the TLS configuration isn't
 // valid.  Thus TLS may be initialized later, e.g. via the web UI.
-func newTLSManager(conf tlsConfigSettings, servePlainDNS bool) (m *tlsManager, err error) {
+func newTLSManager(
+	l *slog.Logger,
+	conf tlsConfigSettings,
+	servePlainDNS bool,
+) (m *tlsManager, err error) {
 	m = &tlsManager{
 		status:        &tlsConfigStatus{},
 		conf:          conf,
 		servePlainDNS: servePlainDNS,
 	}
 
+	err = m.initACME(l)
+	if err != nil {
+		return m, err
+	}
+
 	if m.conf.Enabled {
 		err = m.load()
 		if err != nil {
 			m.conf.Enabled = false
+			if m.acmeCertMissing() {
+				log.Info("tls: waiting for acme certificate for %q", m.conf.ServerName)
+
+				return m, nil
+			}
 
 			return m, err
 		}
@@ -101,7 +122,8 @@ func (m *tlsManager) setCertFileTime() {
 	m.certLastMod = fi.ModTime().UTC()
 }
 
-// start updates the configuration of t and starts it.
+// start updates the configuration of t and starts it.  The ACME manager, if
+// any, is started separately, see [tlsManager.startACME].
 func (m *tlsManager) start() {
 	m.registerWebHandlers()
 
@@ -331,6 +353,12 @@ func (m *tlsManager) handleTLSValidate(w http.ResponseWriter, r *http.Request) {
 		setts.PrivateKey = m.conf.PrivateKey
 	}
 
+	if err = m.checkACMESettings(&setts.tlsConfigSettings); err != nil {
+		aghhttp.Error(r, w, http.StatusBadRequest, "%s", err)
+
+		return
+	}
+
 	if err = validateTLSSettings(setts); err != nil {
 		aghhttp.Error(r, w, http.StatusBadRequest, "%s", err)
 
@@ -364,7 +392,13 @@ func (m *tlsManager) setConfig(
 	// TODO(a.garipov): Define a custom comparer for dnsforward.TLSConfig.
 	newConf.DNSCryptConfigFile = m.conf.DNSCryptConfigFile
 	newConf.PortDNSCrypt = m.conf.PortDNSCrypt
-	if !cmp.Equal(m.conf, newConf, cmp.AllowUnexported(dnsforward.TLSConfig{})) {
+	newConf.ACME = m.conf.ACME
+	if !cmp.Equal(
+		m.conf,
+		newConf,
+		cmp.AllowUnexported(dnsforward.TLSConfig{}),
+		cmpopts.IgnoreFields(tlsConfigSettings{}, "ACME"),
+	) {
 		log.Info("tls config has changed, restarting https server")
 		restartHTTPS = true
 	} else {
@@ -407,6 +441,12 @@ func (m *tlsManager) handleTLSConfigure(w http.ResponseWriter, r *http.Request)
 		req.PrivateKey = m.conf.PrivateKey
 	}
 
+	if err = m.checkACMESettings(&req.tlsConfigSettings); err != nil {
+		aghhttp.Error(r, w, http.StatusBadRequest, "%s", err)
+
+		return
+	}
+
 	if err = validateTLSSettings(req); err != nil {
 		aghhttp.Error(r, w, http.StatusBadRequest, "%s", err)
 
@@ -794,4 +834,10 @@ func (m *tlsManager) registerWebHandlers() {
 	httpRegister(http.MethodGet, "/control/tls/status", m.handleTLSStatus)
 	httpRegister(http.MethodPost, "/control/tls/configure", m.handleTLSConfigure)
 	httpRegister(http.MethodPost, "/control/tls/validate", m.handleTLSValidate)
+
+	if m.acme != nil {
+		// Serve the challenges without authentication and HTTPS redirection,
+		// since the ACME server requests them over plain HTTP.
+		Context.mux.Handle(acmeChallengePath, m.acme)
+	}
 }
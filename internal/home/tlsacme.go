@@ -0,0 +1,263 @@
+package home
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/netip"
+	"os"
+	"path/filepath"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/acme"
+	"github.com/AdguardTeam/AdGuardHome/internal/ddns"
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/AdguardTeam/golibs/log"
+	"github.com/AdguardTeam/golibs/logutil/slogutil"
+	"github.com/AdguardTeam/golibs/timeutil"
+)
+
+// acmeDir is the name of the directory within the data directory, where the
+// ACME account key and the obtained certificate are stored.
+const acmeDir = "acme"
+
+// acmeChallengePath is the path prefix of the HTTP-01 challenges.
+const acmeChallengePath = "/.well-known/acme-challenge/"
+
+// acmeConfig is the configuration of obtaining the certificate for
+// [tlsConfigSettings.ServerName] automatically.  When enabled, the certificate
+// and private key paths are managed by AdGuard Home and the encryption is
+// enabled once the certificate is obtained.
+type acmeConfig struct {
+	// DNS is the configuration of the DNS-01 challenge.  It must not be nil if
+	// Challenge is [acme.ChallengeTypeDNS01].
+	DNS *acmeDNSConfig `yaml:"dns,omitempty"`
+
+	// DirectoryURL is the URL of the ACME directory.  If empty,
+	// [acme.DefaultDirectoryURL] is used.
+	DirectoryURL string `yaml:"directory_url"`
+
+	// Email is the contact address of the ACME account.
+	Email string `yaml:"email"`
+
+	// Challenge is the type of the challenge to use.  HTTP-01 challenges are
+	// served by the web interface, which must be reachable on port 80.
+	Challenge acme.ChallengeType `yaml:"challenge"`
+
+	// RenewBefore is the period before the expiration of the certificate,
+	// within which it's renewed.  If zero, [acme.DefaultRenewBefore] is used.
+	RenewBefore timeutil.Duration `yaml:"renew_before"`
+
+	// Enabled defines if the certificate is obtained automatically.
+	Enabled bool `yaml:"enabled"`
+}
+
+// acmeDNSConfig is the configuration of publishing the DNS-01 challenges using
+// the dynamic DNS updates.
+type acmeDNSConfig struct {
+	// Server is the address of the primary authoritative server of Zone, e.g.
+	// "192.0.2.53:53".
+	Server netip.AddrPort `yaml:"server"`
+
+	// Zone is the zone containing the server name.
+	Zone string `yaml:"zone"`
+
+	// TSIGKeyName is the name of the key to sign the updates with.  If empty,
+	// the updates aren't signed.
+	TSIGKeyName string `yaml:"tsig_key_name"`
+
+	// TSIGAlgorithm is the HMAC algorithm of the key.  If empty, hmac-sha256
+	// is used.
+	TSIGAlgorithm string `yaml:"tsig_algorithm"`
+
+	// TSIGSecret is the base64-encoded secret of the key.
+	TSIGSecret string `yaml:"tsig_secret"`
+}
+
+// newACMEManager returns a new ACME manager obtaining the certificate for
+// serverName into the data directory and calling onCert for each new one.
+func newACMEManager(
+	l *slog.Logger,
+	conf *acmeConfig,
+	serverName string,
+	onCert func(ctx context.Context),
+) (m *acme.Manager, err error) {
+	var txt acme.TXTUpdater
+	if conf.Challenge == acme.ChallengeTypeDNS01 {
+		if conf.DNS == nil {
+			return nil, errors.Error("dns-01 challenge requires dns configuration")
+		}
+
+		txt, err = newACMETXTUpdater(l, conf.DNS)
+		if err != nil {
+			// Don't wrap the error, because it's informative enough as is.
+			return nil, err
+		}
+	}
+
+	return acme.New(&acme.Config{
+		Logger:        l,
+		TXTUpdater:    txt,
+		OnCertificate: onCert,
+		DirectoryURL:  conf.DirectoryURL,
+		Email:         conf.Email,
+		Dir:           filepath.Join(Context.getDataDir(), acmeDir),
+		Domain:        serverName,
+		ChallengeType: conf.Challenge,
+		RenewBefore:   conf.RenewBefore.Duration,
+	})
+}
+
+// newACMETXTUpdater returns the updater publishing the DNS-01 challenges into
+// the zone configured by conf.
+func newACMETXTUpdater(l *slog.Logger, conf *acmeDNSConfig) (u *ddns.Updater, err error) {
+	ddnsConf := &ddns.Config{
+		Logger: l.With(slogutil.KeyPrefix, "ddns"),
+		Server: conf.Server,
+		Zone:   conf.Zone,
+	}
+
+	if conf.TSIGKeyName != "" {
+		ddnsConf.TSIG = &ddns.TSIGKey{
+			Name:      conf.TSIGKeyName,
+			Algorithm: conf.TSIGAlgorithm,
+			Secret:    conf.TSIGSecret,
+		}
+	}
+
+	return ddns.New(ddnsConf)
+}
+
+// initACME creates the ACME manager, if it's enabled in m.conf, and sets the
+// certificate paths to the ones managed by it.
+func (m *tlsManager) initACME(l *slog.Logger) (err error) {
+	conf := m.conf.ACME
+	if conf == nil || !conf.Enabled {
+		return nil
+	}
+
+	m.acme, err = newACMEManager(
+		l.With(slogutil.KeyPrefix, "acme"),
+		conf,
+		m.conf.ServerName,
+		m.onACMECertificate,
+	)
+	if err != nil {
+		return fmt.Errorf("initializing acme: %w", err)
+	}
+
+	m.useACMECert()
+
+	return nil
+}
+
+// useACMECert sets the certificate of the ACME manager in m.conf and enables
+// the encryption.  m.confLock is expected to be locked, if needed.
+func (m *tlsManager) useACMECert() {
+	m.conf.Enabled = true
+	m.conf.CertificateChain = ""
+	m.conf.CertificatePath = m.acme.CertificatePath()
+	m.conf.PrivateKey = ""
+	m.conf.PrivateKeyPath = m.acme.PrivateKeyPath()
+}
+
+// errACMEManaged is returned when the settings managed by the ACME manager are
+// changed using the HTTP API.
+const errACMEManaged errors.Error = "server name, certificate, and encryption " +
+	"are managed by acme; change them in the configuration file"
+
+// checkACMESettings returns [errACMEManaged] if the ACME manager is used and
+// setts change the server name, the certificate, or the encryption status,
+// since the manager is created for the server name from the configuration file
+// and overwrites the others with each new certificate.
+func (m *tlsManager) checkACMESettings(setts *tlsConfigSettings) (err error) {
+	if m.acme == nil {
+		return nil
+	}
+
+	m.confLock.Lock()
+	defer m.confLock.Unlock()
+
+	if setts.Enabled != m.conf.Enabled ||
+		setts.ServerName != m.conf.ServerName ||
+		setts.CertificateChain != "" ||
+		setts.CertificatePath != m.conf.CertificatePath ||
+		setts.PrivateKey != "" ||
+		setts.PrivateKeyPath != m.conf.PrivateKeyPath {
+		return errACMEManaged
+	}
+
+	return nil
+}
+
+// acmeCertMissing returns true if the ACME manager is used and there is no
+// certificate obtained yet.
+func (m *tlsManager) acmeCertMissing() (ok bool) {
+	if m.acme == nil {
+		return false
+	}
+
+	_, err := os.Stat(m.acme.CertificatePath())
+
+	return errors.Is(err, os.ErrNotExist)
+}
+
+// startACME starts obtaining and renewing the certificate, if the ACME manager
+// is used.  It must be called after the DNS server is started, since the new
+// certificates are applied by reconfiguring it.
+func (m *tlsManager) startACME() {
+	if m.acme == nil {
+		return
+	}
+
+	err := m.acme.Start(context.Background())
+	if err != nil {
+		log.Error("tls: starting acme: %s", err)
+	}
+}
+
+// shutdownACME stops renewing the certificate, if the ACME manager is used.
+func (m *tlsManager) shutdownACME(ctx context.Context) {
+	if m.acme == nil {
+		return
+	}
+
+	err := m.acme.Shutdown(ctx)
+	if err != nil {
+		log.Error("tls: stopping acme: %s", err)
+	}
+}
+
+// onACMECertificate applies the certificate obtained by the ACME manager to the
+// DNS and web servers.
+func (m *tlsManager) onACMECertificate(ctx context.Context) {
+	m.confLock.Lock()
+	m.useACMECert()
+	err := m.load()
+	if err != nil {
+		m.conf.Enabled = false
+	}
+	m.confLock.Unlock()
+
+	if err != nil {
+		log.Error("tls: loading acme certificate: %s", err)
+
+		return
+	}
+
+	m.setCertFileTime()
+
+	onConfigModified()
+
+	err = reconfigureDNSServer()
+	if err != nil {
+		log.Error("tls: applying acme certificate: %s", err)
+	}
+
+	m.confLock.Lock()
+	tlsConf := m.conf
+	m.confLock.Unlock()
+
+	// Don't cancel the context along with the refresh, since tlsConfigChanged
+	// wraps it with timeout on its own.
+	Context.web.tlsConfigChanged(context.WithoutCancel(ctx), tlsConf)
+}
@@ -0,0 +1,74 @@
+package home
+
+import (
+	"context"
+	"testing"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/acme"
+	"github.com/AdguardTeam/golibs/logutil/slogutil"
+	"github.com/AdguardTeam/golibs/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTLSManager_checkACMESettings(t *testing.T) {
+	const testServerName = "agh.example"
+
+	m := &tlsManager{
+		conf: tlsConfigSettings{
+			ServerName: testServerName,
+		},
+	}
+
+	err := m.checkACMESettings(&tlsConfigSettings{ServerName: "other.example"})
+	require.NoError(t, err)
+
+	m.acme, err = acme.New(&acme.Config{
+		Logger:        slogutil.NewDiscardLogger(),
+		OnCertificate: func(_ context.Context) {},
+		Dir:           t.TempDir(),
+		Domain:        testServerName,
+		ChallengeType: acme.ChallengeTypeHTTP01,
+	})
+	require.NoError(t, err)
+
+	m.useACMECert()
+
+	testCases := []struct {
+		modify     func(setts *tlsConfigSettings)
+		name       string
+		wantErrMsg string
+	}{{
+		modify:     func(_ *tlsConfigSettings) {},
+		name:       "unchanged",
+		wantErrMsg: "",
+	}, {
+		modify:     func(setts *tlsConfigSettings) { setts.ServerName = "other.example" },
+		name:       "server_name",
+		wantErrMsg: errACMEManaged.Error(),
+	}, {
+		modify:     func(setts *tlsConfigSettings) { setts.Enabled = false },
+		name:       "disabled",
+		wantErrMsg: errACMEManaged.Error(),
+	}, {
+		modify: func(setts *tlsConfigSettings) {
+			setts.CertificatePath = ""
+			setts.CertificateChain = string(testCertChainData)
+		},
+		name:       "certificate",
+		wantErrMsg: errACMEManaged.Error(),
+	}, {
+		modify:     func(setts *tlsConfigSettings) { setts.PrivateKeyPath = "/tmp/other.key" },
+		name:       "private_key",
+		wantErrMsg: errACMEManaged.Error(),
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			setts := m.conf
+			tc.modify(&setts)
+
+			err = m.checkACMESettings(&setts)
+			testutil.AssertErrorMsg(t, tc.wantErrMsg, err)
+		})
+	}
+}
@@ -137,6 +137,16 @@ var logEntryHandlers = map[string]logEntryHandler{
 
 		return nil
 	},
+	"DNS64": func(t json.Token, ent *logEntry) error {
+		v, ok := t.(bool)
+		if !ok {
+			return nil
+		}
+
+		ent.DNS64 = v
+
+		return nil
+	},
 	"AD": func(t json.Token, ent *logEntry) error {
 		v, ok := t.(bool)
 		if !ok {
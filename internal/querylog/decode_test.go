@@ -46,6 +46,7 @@ func TestDecodeLogEntry(t *testing.T) {
 			`"Answer":"` + ansStr + `",` +
 			`"Cached":true,` +
 			`"AD":true,` +
+			`"DNS64":true,` +
 			`"Result":{` +
 			`"IsFiltered":true,` +
 			`"Reason":3,` +
@@ -97,6 +98,7 @@ func TestDecodeLogEntry(t *testing.T) {
 			Upstream:          "https://some.upstream",
 			Elapsed:           837429,
 			AuthenticatedData: true,
+			DNS64:             true,
 		}
 
 		got := &logEntry{}
@@ -42,6 +42,7 @@ type logEntry struct {
 
 	Cached            bool `json:",omitempty"`
 	AuthenticatedData bool `json:"AD,omitempty"`
+	DNS64             bool `json:",omitempty"`
 }
 
 // shallowClone returns a shallow clone of e.
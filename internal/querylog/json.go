@@ -96,6 +96,10 @@ func (l *queryLog) entryToJSON(
 		jsonEntry["ecs"] = entry.ReqECS
 	}
 
+	if entry.DNS64 {
+		jsonEntry["dns64_synthesized"] = true
+	}
+
 	if len(entry.Result.Rules) > 0 {
 		if r := entry.Result.Rules[0]; len(r.Text) > 0 {
 			jsonEntry["rule"] = r.Text
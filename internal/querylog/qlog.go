@@ -203,6 +203,7 @@ func newLogEntry(ctx context.Context, logger *slog.Logger, params *AddParams) (e
 
 		Cached:            params.Cached,
 		AuthenticatedData: params.AuthenticatedData,
+		DNS64:             params.DNS64,
 	}
 
 	if params.ReqECS != nil {
@@ -115,6 +115,10 @@ type AddParams struct {
 
 	// AuthenticatedData shows if the response had the AD bit set.
 	AuthenticatedData bool
+
+	// DNS64 shows if the response contains the AAAA records synthesized by
+	// DNS64.
+	DNS64 bool
 }
 
 // validate returns an error if the parameters aren't valid.
@@ -183,6 +183,7 @@ func (c *searchCriterion) ctFilteringStatusCase(
 		return !reason.In(
 			filtering.FilteredBlockList,
 			filtering.FilteredBlockedService,
+			filtering.FilteredAllowlistOnly,
 			filtering.NotFilteredAllowList,
 		)
 	default:
@@ -201,7 +202,11 @@ func (c *searchCriterion) ctFilteringStatusCase(
 func (c *searchCriterion) isFilteredWithReason(reason filtering.Reason) (matched bool) {
 	switch c.value {
 	case filteringStatusBlocked:
-		return reason.In(filtering.FilteredBlockList, filtering.FilteredBlockedService)
+		return reason.In(
+			filtering.FilteredBlockList,
+			filtering.FilteredBlockedService,
+			filtering.FilteredAllowlistOnly,
+		)
 	case filteringStatusBlockedParental:
 		return reason == filtering.FilteredParental
 	case filteringStatusBlockedSafebrowsing:
@@ -4,6 +4,8 @@ package schedule
 import (
 	"encoding/json"
 	"fmt"
+	"maps"
+	"slices"
 	"time"
 
 	"github.com/AdguardTeam/AdGuardHome/internal/aghhttp"
@@ -12,15 +14,26 @@ import (
 	"gopkg.in/yaml.v3"
 )
 
-// Weekly is a schedule for one week.  Each day of the week has one range with
-// a beginning and an end.
+// Weekly is a schedule for one week.  Each day of the week has one or more
+// ranges with a beginning and an end.  The additional ranges may wrap past
+// midnight into the next day.  The ranges of particular dates may be replaced
+// by exceptions, for example on holidays.
 type Weekly struct {
 	// location is used to calculate the offsets of the day ranges.
 	location *time.Location
 
+	// exceptions are the day ranges of particular dates replacing the ones of
+	// the corresponding weekdays.  The keys are dates in the [time.DateOnly]
+	// format.
+	exceptions map[string][]dayRange
+
 	// days are the day ranges of this schedule.  The indexes of this array are
 	// the [time.Weekday] values.
 	days [7]dayRange
+
+	// more are the additional day ranges of this schedule.  The indexes of
+	// this array are the [time.Weekday] values.
+	more [7][]dayRange
 }
 
 // EmptyWeekly creates empty weekly schedule with local time zone.
@@ -58,18 +71,52 @@ func (w *Weekly) Clone() (c *Weekly) {
 
 	// NOTE:  Do not use time.LoadLocation, because the results will be
 	// different on time zone database update.
-	return &Weekly{
+	c = &Weekly{
 		location: w.location,
 		days:     w.days,
 	}
+
+	for i, rs := range w.more {
+		c.more[i] = slices.Clone(rs)
+	}
+
+	if w.exceptions != nil {
+		c.exceptions = make(map[string][]dayRange, len(w.exceptions))
+		for date, rs := range w.exceptions {
+			c.exceptions[date] = slices.Clone(rs)
+		}
+	}
+
+	return c
 }
 
-// Contains returns true if t is within the corresponding day range of the
-// schedule in the schedule's time zone.
+// IsEmpty returns true if w doesn't contain any time.  w may be nil.
+func (w *Weekly) IsEmpty() (ok bool) {
+	if w == nil {
+		return true
+	}
+
+	for wd, r := range w.days {
+		if (r != dayRange{}) || len(w.more[wd]) > 0 {
+			return false
+		}
+	}
+
+	for _, rs := range w.exceptions {
+		if len(rs) > 0 {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Contains returns true if t is within any of the corresponding day ranges of
+// the schedule in the schedule's time zone, including the ranges of the
+// previous day wrapping past midnight.  If there is an exception for a date,
+// only its ranges are considered for it.
 func (w *Weekly) Contains(t time.Time) (ok bool) {
 	t = t.In(w.location)
-	wd := t.Weekday()
-	dr := w.days[wd]
 
 	// Calculate the offset of the day range.
 	//
@@ -78,7 +125,23 @@ func (w *Weekly) Contains(t time.Time) (ok bool) {
 	day := time.Date(y, m, d, 0, 0, 0, 0, w.location)
 	offset := t.Sub(day)
 
-	return dr.contains(offset)
+	inDay := func(r dayRange) (ok bool) { return r.contains(offset) }
+	inPrevDay := func(r dayRange) (ok bool) { return r.containsNextDay(offset) }
+
+	return w.anyRange(day, inDay) || w.anyRange(day.AddDate(0, 0, -1), inPrevDay)
+}
+
+// anyRange returns true if f returns true for any of the day ranges of the date
+// of day, which are either the ranges of the exception for it or the ones of
+// its weekday.
+func (w *Weekly) anyRange(day time.Time, f func(r dayRange) (ok bool)) (ok bool) {
+	if rs, isExc := w.exceptions[day.Format(time.DateOnly)]; isExc {
+		return slices.ContainsFunc(rs, f)
+	}
+
+	wd := day.Weekday()
+
+	return f(w.days[wd]) || slices.ContainsFunc(w.more[wd], f)
 }
 
 // type check
@@ -110,20 +173,29 @@ func (w *Weekly) UnmarshalJSON(data []byte) (err error) {
 	}
 	for i, d := range days {
 		var r dayRange
+		var more []dayRange
 
 		if d != nil {
 			r = dayRange{
 				start: time.Duration(d.Start),
 				end:   time.Duration(d.End),
 			}
+			more = rangesFromJSON(d.Ranges)
 		}
 
-		err = w.validate(r)
+		err = w.validateDay(r, more)
 		if err != nil {
 			return fmt.Errorf("weekday %s: %w", time.Weekday(i), err)
 		}
 
 		weekly.days[i] = r
+		weekly.more[i] = more
+	}
+
+	weekly.exceptions, err = w.exceptionsFromJSON(conf.Exceptions)
+	if err != nil {
+		// Don't wrap the error since it's informative enough as is.
+		return err
 	}
 
 	*w = weekly
@@ -166,13 +238,21 @@ func (w *Weekly) UnmarshalYAML(value *yaml.Node) (err error) {
 			start: d.Start.Duration,
 			end:   d.End.Duration,
 		}
+		more := rangesFromYAML(d.Ranges)
 
-		err = w.validate(r)
+		err = w.validateDay(r, more)
 		if err != nil {
 			return fmt.Errorf("weekday %s: %w", time.Weekday(i), err)
 		}
 
 		weekly.days[i] = r
+		weekly.more[i] = more
+	}
+
+	weekly.exceptions, err = w.exceptionsFromYAML(conf.Exceptions)
+	if err != nil {
+		// Don't wrap the error since it's informative enough as is.
+		return err
 	}
 
 	*w = weekly
@@ -194,17 +274,137 @@ type weeklyConfigYAML struct {
 	Thursday  dayConfigYAML `yaml:"thu,omitempty"`
 	Friday    dayConfigYAML `yaml:"fri,omitempty"`
 	Saturday  dayConfigYAML `yaml:"sat,omitempty"`
+
+	// Exceptions are the dates with their own day ranges.
+	Exceptions []*exceptionConfigYAML `yaml:"exceptions,omitempty"`
 }
 
-// dayConfigYAML is the YAML configuration structure of dayRange.
+// dayConfigYAML is the YAML configuration structure of the day ranges of a
+// weekday.
 type dayConfigYAML struct {
 	Start timeutil.Duration `yaml:"start"`
 	End   timeutil.Duration `yaml:"end"`
+
+	// Ranges are the additional day ranges.
+	Ranges []*rangeConfigYAML `yaml:"ranges,omitempty"`
+}
+
+// rangeConfigYAML is the YAML configuration structure of dayRange.
+type rangeConfigYAML struct {
+	Start timeutil.Duration `yaml:"start"`
+	End   timeutil.Duration `yaml:"end"`
+}
+
+// exceptionConfigYAML is the YAML configuration structure of an exception.
+type exceptionConfigYAML struct {
+	// Date is the date in the [time.DateOnly] format.
+	Date string `yaml:"date"`
+
+	// Ranges are the day ranges of the date.  If empty, the schedule doesn't
+	// contain any time of the date.
+	Ranges []*rangeConfigYAML `yaml:"ranges,omitempty"`
+}
+
+// rangesFromYAML converts the YAML configuration of the day ranges.
+func rangesFromYAML(confs []*rangeConfigYAML) (rs []dayRange) {
+	for _, c := range confs {
+		if c != nil {
+			rs = append(rs, dayRange{start: c.Start.Duration, end: c.End.Duration})
+		}
+	}
+
+	return rs
+}
+
+// rangesToYAML converts the day ranges into the YAML configuration.
+func rangesToYAML(rs []dayRange) (confs []*rangeConfigYAML) {
+	for _, r := range rs {
+		confs = append(confs, &rangeConfigYAML{
+			Start: timeutil.Duration{Duration: r.start},
+			End:   timeutil.Duration{Duration: r.end},
+		})
+	}
+
+	return confs
+}
+
+// exceptionsFromYAML converts and validates the YAML configuration of the
+// exceptions.
+func (w *Weekly) exceptionsFromYAML(
+	confs []*exceptionConfigYAML,
+) (excs map[string][]dayRange, err error) {
+	for i, c := range confs {
+		if c == nil {
+			return nil, fmt.Errorf("exception at index %d: %w", i, errors.ErrNoValue)
+		}
+
+		excs, err = w.addException(excs, c.Date, rangesFromYAML(c.Ranges))
+		if err != nil {
+			return nil, fmt.Errorf("exception at index %d: %w", i, err)
+		}
+	}
+
+	return excs, nil
+}
+
+// addException validates the exception and adds it to excs, which is
+// allocated if nil.
+func (w *Weekly) addException(
+	excs map[string][]dayRange,
+	date string,
+	rs []dayRange,
+) (res map[string][]dayRange, err error) {
+	_, err = time.Parse(time.DateOnly, date)
+	if err != nil {
+		return nil, fmt.Errorf("bad date: %w", err)
+	}
+
+	if _, ok := excs[date]; ok {
+		return nil, fmt.Errorf("date %s: %w", date, errors.ErrDuplicated)
+	}
+
+	for _, r := range rs {
+		err = w.validateOvernight(r)
+		if err != nil {
+			return nil, fmt.Errorf("date %s: %w", date, err)
+		}
+	}
+
+	if excs == nil {
+		excs = map[string][]dayRange{}
+	}
+
+	excs[date] = rs
+
+	return excs, nil
 }
 
 // maxDayRange is the maximum value for day range end.
 const maxDayRange = 24 * time.Hour
 
+// validateDay returns the validation errors of the main day range r and the
+// additional ones, if any.
+func (w *Weekly) validateDay(r dayRange, more []dayRange) (err error) {
+	err = w.validate(r)
+	if err != nil {
+		// Don't wrap the error since it's informative enough as is.
+		return err
+	}
+
+	for i, mr := range more {
+		if (mr == dayRange{}) {
+			return fmt.Errorf("range at index %d: %w", i, errors.ErrEmptyValue)
+		}
+
+		err = w.validateOvernight(mr)
+		if err != nil {
+			return fmt.Errorf("range at index %d: %w", i, err)
+		}
+	}
+
+	return nil
+}
+
 // validate returns the day range rounding errors, if any.
 func (w *Weekly) validate(r dayRange) (err error) {
 	defer func() { err = errors.Annotate(err, "bad day range: %w") }()
@@ -215,6 +415,25 @@ func (w *Weekly) validate(r dayRange) (err error) {
 		return err
 	}
 
+	return r.validateRounding()
+}
+
+// validateOvernight is like [Weekly.validate], but also allows r to wrap past
+// midnight.
+func (w *Weekly) validateOvernight(r dayRange) (err error) {
+	defer func() { err = errors.Annotate(err, "bad day range: %w") }()
+
+	err = r.validateOvernight()
+	if err != nil {
+		// Don't wrap the error since it's informative enough as is.
+		return err
+	}
+
+	return r.validateRounding()
+}
+
+// validateRounding returns an error if r isn't rounded to minutes.
+func (r dayRange) validateRounding() (err error) {
 	start := r.start.Truncate(time.Minute)
 	end := r.end.Truncate(time.Minute)
 
@@ -235,13 +454,20 @@ var _ json.Marshaler = (*Weekly)(nil)
 func (w *Weekly) MarshalJSON() (data []byte, err error) {
 	c := &weeklyConfigJSON{
 		TimeZone:  w.location.String(),
-		Sunday:    w.days[time.Sunday].toDayConfigJSON(),
-		Monday:    w.days[time.Monday].toDayConfigJSON(),
-		Tuesday:   w.days[time.Tuesday].toDayConfigJSON(),
-		Wednesday: w.days[time.Wednesday].toDayConfigJSON(),
-		Thursday:  w.days[time.Thursday].toDayConfigJSON(),
-		Friday:    w.days[time.Friday].toDayConfigJSON(),
-		Saturday:  w.days[time.Saturday].toDayConfigJSON(),
+		Sunday:    w.dayConfigJSON(time.Sunday),
+		Monday:    w.dayConfigJSON(time.Monday),
+		Tuesday:   w.dayConfigJSON(time.Tuesday),
+		Wednesday: w.dayConfigJSON(time.Wednesday),
+		Thursday:  w.dayConfigJSON(time.Thursday),
+		Friday:    w.dayConfigJSON(time.Friday),
+		Saturday:  w.dayConfigJSON(time.Saturday),
+	}
+
+	for _, date := range slices.Sorted(maps.Keys(w.exceptions)) {
+		c.Exceptions = append(c.Exceptions, &exceptionConfigJSON{
+			Date:   date,
+			Ranges: rangesToJSON(w.exceptions[date]),
+		})
 	}
 
 	return json.Marshal(c)
@@ -252,42 +478,41 @@ var _ yaml.Marshaler = (*Weekly)(nil)
 
 // MarshalYAML implements the [yaml.Marshaler] interface for *Weekly.
 func (w *Weekly) MarshalYAML() (v any, err error) {
-	return weeklyConfigYAML{
-		TimeZone: w.location.String(),
-		Sunday: dayConfigYAML{
-			Start: timeutil.Duration{Duration: w.days[time.Sunday].start},
-			End:   timeutil.Duration{Duration: w.days[time.Sunday].end},
-		},
-		Monday: dayConfigYAML{
-			Start: timeutil.Duration{Duration: w.days[time.Monday].start},
-			End:   timeutil.Duration{Duration: w.days[time.Monday].end},
-		},
-		Tuesday: dayConfigYAML{
-			Start: timeutil.Duration{Duration: w.days[time.Tuesday].start},
-			End:   timeutil.Duration{Duration: w.days[time.Tuesday].end},
-		},
-		Wednesday: dayConfigYAML{
-			Start: timeutil.Duration{Duration: w.days[time.Wednesday].start},
-			End:   timeutil.Duration{Duration: w.days[time.Wednesday].end},
-		},
-		Thursday: dayConfigYAML{
-			Start: timeutil.Duration{Duration: w.days[time.Thursday].start},
-			End:   timeutil.Duration{Duration: w.days[time.Thursday].end},
-		},
-		Friday: dayConfigYAML{
-			Start: timeutil.Duration{Duration: w.days[time.Friday].start},
-			End:   timeutil.Duration{Duration: w.days[time.Friday].end},
-		},
-		Saturday: dayConfigYAML{
-			Start: timeutil.Duration{Duration: w.days[time.Saturday].start},
-			End:   timeutil.Duration{Duration: w.days[time.Saturday].end},
-		},
-	}, nil
+	c := weeklyConfigYAML{
+		TimeZone:  w.location.String(),
+		Sunday:    w.dayConfigYAML(time.Sunday),
+		Monday:    w.dayConfigYAML(time.Monday),
+		Tuesday:   w.dayConfigYAML(time.Tuesday),
+		Wednesday: w.dayConfigYAML(time.Wednesday),
+		Thursday:  w.dayConfigYAML(time.Thursday),
+		Friday:    w.dayConfigYAML(time.Friday),
+		Saturday:  w.dayConfigYAML(time.Saturday),
+	}
+
+	for _, date := range slices.Sorted(maps.Keys(w.exceptions)) {
+		c.Exceptions = append(c.Exceptions, &exceptionConfigYAML{
+			Date:   date,
+			Ranges: rangesToYAML(w.exceptions[date]),
+		})
+	}
+
+	return c, nil
+}
+
+// dayConfigYAML returns the YAML configuration of the day ranges of wd.
+func (w *Weekly) dayConfigYAML(wd time.Weekday) (c dayConfigYAML) {
+	return dayConfigYAML{
+		Start:  timeutil.Duration{Duration: w.days[wd].start},
+		End:    timeutil.Duration{Duration: w.days[wd].end},
+		Ranges: rangesToYAML(w.more[wd]),
+	}
 }
 
 // dayRange represents a single interval within a day.  The interval begins at
 // start and ends before end.  That is, it contains a time point T if start <=
-// T < end.
+// T < end.  If end is less than start, the interval wraps past midnight, so it
+// contains the time points from start till the end of the day and the ones
+// before end of the next day.
 type dayRange struct {
 	// start is an offset from the beginning of the day.  It must be greater
 	// than or equal to zero and less than 24h.
@@ -318,23 +543,100 @@ func (r dayRange) validate() (err error) {
 	}
 }
 
+// validateOvernight is like [dayRange.validate], but also allows the range to
+// wrap past midnight, that is to have start greater than end.
+func (r dayRange) validateOvernight() (err error) {
+	if r.start <= r.end {
+		return r.validate()
+	}
+
+	switch {
+	case r.end < 0:
+		return fmt.Errorf("end %s is negative", r.end)
+	case r.start >= maxDayRange:
+		return fmt.Errorf("start %s is greater or equal to %s", r.start, maxDayRange)
+	default:
+		return nil
+	}
+}
+
+// wraps returns true if r wraps past midnight.
+func (r *dayRange) wraps() (ok bool) {
+	return r.end < r.start
+}
+
 // contains returns true if start <= offset < end, where offset is the time
-// duration from the beginning of the day.
+// duration from the beginning of the day.  If r wraps past midnight, only the
+// part of it before midnight is considered.
 func (r *dayRange) contains(offset time.Duration) (ok bool) {
+	if r.wraps() {
+		return r.start <= offset
+	}
+
 	return r.start <= offset && offset < r.end
 }
 
-// toDayConfigJSON returns nil if the day range is empty, otherwise returns
-// initialized JSON configuration of the day range.
-func (r dayRange) toDayConfigJSON() (j *dayConfigJSON) {
-	if (r == dayRange{}) {
+// containsNextDay returns true if r wraps past midnight and offset, the time
+// duration from the beginning of the next day, is before its end.
+func (r *dayRange) containsNextDay(offset time.Duration) (ok bool) {
+	return r.wraps() && offset < r.end
+}
+
+// dayConfigJSON returns nil if the day ranges of wd are empty, otherwise
+// returns initialized JSON configuration of the day ranges.
+func (w *Weekly) dayConfigJSON(wd time.Weekday) (j *dayConfigJSON) {
+	r := w.days[wd]
+	if (r == dayRange{}) && len(w.more[wd]) == 0 {
 		return nil
 	}
 
 	return &dayConfigJSON{
-		Start: aghhttp.JSONDuration(r.start),
-		End:   aghhttp.JSONDuration(r.end),
+		Start:  aghhttp.JSONDuration(r.start),
+		End:    aghhttp.JSONDuration(r.end),
+		Ranges: rangesToJSON(w.more[wd]),
+	}
+}
+
+// rangesToJSON converts the day ranges into the JSON configuration.
+func rangesToJSON(rs []dayRange) (confs []*rangeConfigJSON) {
+	for _, r := range rs {
+		confs = append(confs, &rangeConfigJSON{
+			Start: aghhttp.JSONDuration(r.start),
+			End:   aghhttp.JSONDuration(r.end),
+		})
+	}
+
+	return confs
+}
+
+// rangesFromJSON converts the JSON configuration of the day ranges.
+func rangesFromJSON(confs []*rangeConfigJSON) (rs []dayRange) {
+	for _, c := range confs {
+		if c != nil {
+			rs = append(rs, dayRange{start: time.Duration(c.Start), end: time.Duration(c.End)})
+		}
+	}
+
+	return rs
+}
+
+// exceptionsFromJSON converts and validates the JSON configuration of the
+// exceptions.
+func (w *Weekly) exceptionsFromJSON(
+	confs []*exceptionConfigJSON,
+) (excs map[string][]dayRange, err error) {
+	for i, c := range confs {
+		if c == nil {
+			return nil, fmt.Errorf("exception at index %d: %w", i, errors.ErrNoValue)
+		}
+
+		excs, err = w.addException(excs, c.Date, rangesFromJSON(c.Ranges))
+		if err != nil {
+			return nil, fmt.Errorf("exception at index %d: %w", i, err)
+		}
 	}
+
+	return excs, nil
 }
 
 // weeklyConfigJSON is the JSON configuration structure of Weekly.
@@ -351,10 +653,33 @@ type weeklyConfigJSON struct {
 
 	// TimeZone is the local time zone.
 	TimeZone string `json:"time_zone"`
+
+	// Exceptions are the dates with their own day ranges.
+	Exceptions []*exceptionConfigJSON `json:"exceptions,omitempty"`
 }
 
-// dayConfigJSON is the JSON configuration structure of dayRange.
+// dayConfigJSON is the JSON configuration structure of the day ranges of a
+// weekday.
 type dayConfigJSON struct {
 	Start aghhttp.JSONDuration `json:"start"`
 	End   aghhttp.JSONDuration `json:"end"`
+
+	// Ranges are the additional day ranges.
+	Ranges []*rangeConfigJSON `json:"ranges,omitempty"`
+}
+
+// rangeConfigJSON is the JSON configuration structure of dayRange.
+type rangeConfigJSON struct {
+	Start aghhttp.JSONDuration `json:"start"`
+	End   aghhttp.JSONDuration `json:"end"`
+}
+
+// exceptionConfigJSON is the JSON configuration structure of an exception.
+type exceptionConfigJSON struct {
+	// Date is the date in the [time.DateOnly] format.
+	Date string `json:"date"`
+
+	// Ranges are the day ranges of the date.  If empty, the schedule doesn't
+	// contain any time of the date.
+	Ranges []*rangeConfigJSON `json:"ranges,omitempty"`
 }
@@ -509,3 +509,296 @@ func TestWeekly_MarshalJSON(t *testing.T) {
 		})
 	}
 }
+
+func TestWeekly_Contains_ranges(t *testing.T) {
+	// baseTime is a Friday.
+	baseTime := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	holiday := baseTime.Add(7 * timeutil.Day)
+
+	w := &Weekly{
+		location: time.UTC,
+		days: [7]dayRange{
+			time.Friday: {start: 8 * time.Hour, end: 15 * time.Hour},
+		},
+		more: [7][]dayRange{
+			time.Friday: {
+				{start: 0, end: 7 * time.Hour},
+				{start: 21 * time.Hour, end: 24 * time.Hour},
+			},
+		},
+		exceptions: map[string][]dayRange{
+			holiday.Format(time.DateOnly): {{start: 10 * time.Hour, end: 11 * time.Hour}},
+		},
+	}
+
+	testCases := []struct {
+		assert assert.BoolAssertionFunc
+		t      time.Time
+		name   string
+	}{{
+		assert: assert.True,
+		t:      baseTime.Add(6 * time.Hour),
+		name:   "morning",
+	}, {
+		assert: assert.False,
+		t:      baseTime.Add(7 * time.Hour),
+		name:   "between_ranges",
+	}, {
+		assert: assert.True,
+		t:      baseTime.Add(9 * time.Hour),
+		name:   "main_range",
+	}, {
+		assert: assert.True,
+		t:      baseTime.Add(22 * time.Hour),
+		name:   "night",
+	}, {
+		assert: assert.False,
+		t:      holiday.Add(9 * time.Hour),
+		name:   "holiday_main_range",
+	}, {
+		assert: assert.True,
+		t:      holiday.Add(10 * time.Hour),
+		name:   "holiday_range",
+	}, {
+		assert: assert.True,
+		t:      holiday.Add(-7*timeutil.Day + 10*time.Hour),
+		name:   "not_holiday",
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			tc.assert(t, w.Contains(tc.t))
+		})
+	}
+}
+
+func TestWeekly_Contains_overnight(t *testing.T) {
+	// baseTime is a Friday.
+	baseTime := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	holiday := baseTime.Add(7 * timeutil.Day)
+
+	w := &Weekly{
+		location: time.UTC,
+		more: [7][]dayRange{
+			time.Thursday: {{start: 21 * time.Hour, end: 7 * time.Hour}},
+			time.Friday:   {{start: 21 * time.Hour, end: 7 * time.Hour}},
+		},
+		exceptions: map[string][]dayRange{
+			holiday.Add(-timeutil.Day).Format(time.DateOnly): nil,
+		},
+	}
+
+	testCases := []struct {
+		assert assert.BoolAssertionFunc
+		t      time.Time
+		name   string
+	}{{
+		assert: assert.True,
+		t:      baseTime.Add(6 * time.Hour),
+		name:   "previous_night",
+	}, {
+		assert: assert.False,
+		t:      baseTime.Add(7 * time.Hour),
+		name:   "previous_night_end",
+	}, {
+		assert: assert.False,
+		t:      baseTime.Add(20 * time.Hour),
+		name:   "day",
+	}, {
+		assert: assert.True,
+		t:      baseTime.Add(21 * time.Hour),
+		name:   "night_start",
+	}, {
+		assert: assert.True,
+		t:      baseTime.Add(30 * time.Hour),
+		name:   "next_day",
+	}, {
+		assert: assert.False,
+		t:      baseTime.Add(31 * time.Hour),
+		name:   "next_day_end",
+	}, {
+		assert: assert.False,
+		t:      holiday.Add(6 * time.Hour),
+		name:   "after_exception",
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			tc.assert(t, w.Contains(tc.t))
+		})
+	}
+}
+
+func TestWeekly_IsEmpty(t *testing.T) {
+	testCases := []struct {
+		w    *Weekly
+		want assert.BoolAssertionFunc
+		name string
+	}{{
+		w:    nil,
+		want: assert.True,
+		name: "nil",
+	}, {
+		w:    EmptyWeekly(),
+		want: assert.True,
+		name: "empty",
+	}, {
+		w: &Weekly{
+			location:   time.UTC,
+			exceptions: map[string][]dayRange{"2024-12-25": nil},
+		},
+		want: assert.True,
+		name: "empty_exception",
+	}, {
+		w:    FullWeekly(),
+		want: assert.False,
+		name: "full",
+	}, {
+		w: &Weekly{
+			location: time.UTC,
+			more: [7][]dayRange{
+				time.Monday: {{start: 21 * time.Hour, end: 7 * time.Hour}},
+			},
+		},
+		want: assert.False,
+		name: "additional_range",
+	}, {
+		w: &Weekly{
+			location: time.UTC,
+			exceptions: map[string][]dayRange{
+				"2024-12-25": {{start: 0, end: time.Hour}},
+			},
+		},
+		want: assert.False,
+		name: "exception",
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			tc.want(t, tc.w.IsEmpty())
+		})
+	}
+}
+
+func TestWeekly_ranges(t *testing.T) {
+	const (
+		rangesYAML = `
+mon:
+    start: 8h
+    end: 15h
+    ranges:
+        - start: 0s
+          end: 7h
+        - start: 21h
+          end: 24h
+        - start: 23h
+          end: 2h
+exceptions:
+    - date: "2024-12-25"
+    - date: "2024-12-31"
+      ranges:
+        - start: 8h
+          end: 12h
+time_zone: UTC
+`
+		badDateYAML = `
+exceptions:
+    - date: "25.12.2024"
+`
+		duplicateDateYAML = `
+exceptions:
+    - date: "2024-12-25"
+    - date: "2024-12-25"
+`
+		emptyRangeYAML = `
+mon:
+    ranges:
+        - start: 0s
+          end: 0s
+`
+		equalRangeYAML = `
+mon:
+    ranges:
+        - start: 21h
+          end: 21h
+`
+	)
+
+	want := &Weekly{
+		location: time.UTC,
+		days: [7]dayRange{
+			time.Monday: {start: 8 * time.Hour, end: 15 * time.Hour},
+		},
+		more: [7][]dayRange{
+			time.Monday: {
+				{start: 0, end: 7 * time.Hour},
+				{start: 21 * time.Hour, end: 24 * time.Hour},
+				{start: 23 * time.Hour, end: 2 * time.Hour},
+			},
+		},
+		exceptions: map[string][]dayRange{
+			"2024-12-25": nil,
+			"2024-12-31": {{start: 8 * time.Hour, end: 12 * time.Hour}},
+		},
+	}
+
+	w := &Weekly{}
+	err := yaml.Unmarshal([]byte(rangesYAML), w)
+	require.NoError(t, err)
+
+	assert.Equal(t, want, w)
+	assert.Equal(t, want, w.Clone())
+
+	t.Run("yaml", func(t *testing.T) {
+		data, mErr := yaml.Marshal(want)
+		require.NoError(t, mErr)
+
+		got := &Weekly{}
+		err = yaml.Unmarshal(data, got)
+		require.NoError(t, err)
+
+		assert.Equal(t, want, got)
+	})
+
+	t.Run("json", func(t *testing.T) {
+		data, mErr := json.Marshal(want)
+		require.NoError(t, mErr)
+
+		got := &Weekly{}
+		err = json.Unmarshal(data, got)
+		require.NoError(t, err)
+
+		assert.Equal(t, want, got)
+	})
+
+	testCases := []struct {
+		name       string
+		wantErrMsg string
+		data       string
+	}{{
+		name: "bad_date",
+		wantErrMsg: `exception at index 0: bad date: parsing time "25.12.2024" ` +
+			`as "2006-01-02": cannot parse "25.12.2024" as "2006"`,
+		data: badDateYAML,
+	}, {
+		name:       "duplicate_date",
+		wantErrMsg: "exception at index 1: date 2024-12-25: duplicated value",
+		data:       duplicateDateYAML,
+	}, {
+		name:       "empty_range",
+		wantErrMsg: "weekday Monday: range at index 0: empty value",
+		data:       emptyRangeYAML,
+	}, {
+		name: "equal_range",
+		wantErrMsg: "weekday Monday: range at index 0: bad day range: " +
+			"start 21h0m0s is greater or equal to end 21h0m0s",
+		data: equalRangeYAML,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err = yaml.Unmarshal([]byte(tc.data), &Weekly{})
+			testutil.AssertErrorMsg(t, tc.wantErrMsg, err)
+		})
+	}
+}
@@ -42,6 +42,7 @@ type StatsResp struct {
 	NumReplacedSafebrowsing uint64 `json:"num_replaced_safebrowsing"`
 	NumReplacedSafesearch   uint64 `json:"num_replaced_safesearch"`
 	NumReplacedParental     uint64 `json:"num_replaced_parental"`
+	NumStaleAnswers         uint64 `json:"num_stale_answers"`
 
 	AvgProcessingTime float64 `json:"avg_processing_time"`
 }
@@ -93,6 +93,10 @@ type Interface interface {
 	// clients with the most number of requests.
 	TopClientsIP(limit uint) []netip.Addr
 
+	// TopDomains returns at most limit domain names with the most number of
+	// requests, which weren't filtered.
+	TopDomains(limit uint) []string
+
 	// WriteDiskConfig puts the Interface's configuration to the dc.
 	WriteDiskConfig(dc *Config)
 
@@ -341,6 +345,38 @@ func (s *StatsCtx) TopClientsIP(maxCount uint) (ips []netip.Addr) {
 	return ips
 }
 
+// TopDomains implements the [Interface] interface for *StatsCtx.
+func (s *StatsCtx) TopDomains(maxCount uint) (domains []string) {
+	s.confMu.RLock()
+	defer s.confMu.RUnlock()
+
+	limit := uint32(s.limit.Hours())
+	if !s.enabled || limit == 0 {
+		return nil
+	}
+
+	units, _ := s.loadUnits(limit)
+	if units == nil {
+		return nil
+	}
+
+	// Collect data for all the domains to sort and crop it afterwards.
+	m := map[string]uint64{}
+	for _, u := range units {
+		for _, it := range u.Domains {
+			m[it.Name] += it.Count
+		}
+	}
+
+	a := convertMapToSlice(m, int(maxCount))
+	domains = make([]string, 0, len(a))
+	for _, it := range a {
+		domains = append(domains, it.Name)
+	}
+
+	return domains
+}
+
 // deleteOldUnits walks the buckets available to tx and deletes old units.  It
 // returns the number of deletions performed.
 func (s *StatsCtx) deleteOldUnits(tx *bbolt.Tx, firstID uint32) (deleted int) {
@@ -136,6 +136,8 @@ func TestStats(t *testing.T) {
 		require.NotEmpty(t, topClients)
 
 		assert.Equal(t, cliIP, topClients[0])
+
+		assert.Equal(t, []string{"domain"}, s.TopDomains(2))
 	})
 
 	t.Run("reset", func(t *testing.T) {
@@ -74,6 +74,10 @@ type Entry struct {
 
 	// UpstreamTime is the duration of the successful request to the upstream.
 	UpstreamTime time.Duration
+
+	// Stale is true if the response has been served from an expired cache
+	// item.
+	Stale bool
 }
 
 // validate returns an error if entry is not valid.
@@ -127,6 +131,9 @@ type unit struct {
 	// timeSum stores the sum of processing time in microseconds of each request
 	// written by the unit.
 	timeSum uint64
+
+	// nStale stores the number of responses served from expired cache items.
+	nStale uint64
 }
 
 // newUnit allocates the new *unit.
@@ -179,6 +186,9 @@ type unitDB struct {
 	// TimeAvg is the average of processing times in microseconds of all the
 	// requests in the unit.
 	TimeAvg uint32
+
+	// NStale is the number of responses served from expired cache items.
+	NStale uint64
 }
 
 // newUnitID is the default UnitIDGenFunc that generates the unique id hourly.
@@ -271,6 +281,7 @@ func (u *unit) serialize() (udb *unitDB) {
 		UpstreamsResponses: convertMapToSlice(u.upstreamsResponses, maxUpstreams),
 		UpstreamsTimeSum:   convertMapToSlice(u.upstreamsTimeSum, maxUpstreams),
 		TimeAvg:            timeAvg,
+		NStale:             u.nStale,
 	}
 }
 
@@ -313,6 +324,7 @@ func (u *unit) deserialize(udb *unitDB) {
 	u.upstreamsResponses = convertSliceToMap(udb.UpstreamsResponses)
 	u.upstreamsTimeSum = convertSliceToMap(udb.UpstreamsTimeSum)
 	u.timeSum = uint64(udb.TimeAvg) * udb.NTotal
+	u.nStale = udb.NStale
 }
 
 // add adds new data to u.  It's safe for concurrent use.
@@ -328,6 +340,9 @@ func (u *unit) add(e *Entry) {
 	pt := uint64(e.ProcessingTime.Microseconds())
 	u.timeSum += pt
 	u.nTotal++
+	if e.Stale {
+		u.nStale++
+	}
 
 	if e.Upstream != "" {
 		u.upstreamsResponses[e.Upstream]++
@@ -461,6 +476,7 @@ func (s *StatsCtx) dataFromUnits(units []*unitDB, curID uint32) (resp *StatsResp
 		sum.NResult[RSafeBrowsing] += u.NResult[RSafeBrowsing]
 		sum.NResult[RSafeSearch] += u.NResult[RSafeSearch]
 		sum.NResult[RParental] += u.NResult[RParental]
+		sum.NStale += u.NStale
 	}
 
 	resp.NumDNSQueries = sum.NTotal
@@ -468,6 +484,7 @@ func (s *StatsCtx) dataFromUnits(units []*unitDB, curID uint32) (resp *StatsResp
 	resp.NumReplacedSafebrowsing = sum.NResult[RSafeBrowsing]
 	resp.NumReplacedSafesearch = sum.NResult[RSafeSearch]
 	resp.NumReplacedParental = sum.NResult[RParental]
+	resp.NumStaleAnswers = sum.NStale
 
 	if timeN != 0 {
 		resp.AvgProcessingTime = microsecondsToSeconds(float64(sum.TimeAvg / timeN))
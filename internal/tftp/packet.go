@@ -0,0 +1,239 @@
+package tftp
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/AdguardTeam/golibs/errors"
+)
+
+// opcode is the type of a TFTP packet.
+//
+// See https://datatracker.ietf.org/doc/html/rfc1350#section-5.
+type opcode uint16
+
+// The opcodes of the TFTP packets.
+const (
+	opRRQ   opcode = 1
+	opWRQ   opcode = 2
+	opData  opcode = 3
+	opAck   opcode = 4
+	opError opcode = 5
+
+	// opOACK is the option acknowledgment.
+	//
+	// See https://datatracker.ietf.org/doc/html/rfc2347.
+	opOACK opcode = 6
+)
+
+// errCode is the code of a TFTP error packet.
+//
+// See https://datatracker.ietf.org/doc/html/rfc1350#appendix-I.
+type errCode uint16
+
+// The codes of the TFTP errors.
+const (
+	errCodeUndefined  errCode = 0
+	errCodeNotFound   errCode = 1
+	errCodeAccess     errCode = 2
+	errCodeIllegalOp  errCode = 4
+	errCodeUnknownTID errCode = 5
+)
+
+// The names of the supported options.
+const (
+	// optBlockSize is the block size option.
+	//
+	// See https://datatracker.ietf.org/doc/html/rfc2348.
+	optBlockSize = "blksize"
+
+	// optTimeout is the timeout interval option.
+	//
+	// See https://datatracker.ietf.org/doc/html/rfc2349#section-2.
+	optTimeout = "timeout"
+
+	// optTransferSize is the transfer size option.
+	//
+	// See https://datatracker.ietf.org/doc/html/rfc2349#section-3.
+	optTransferSize = "tsize"
+)
+
+// Limits of the option values.
+const (
+	minBlockSize = 8
+	maxTimeout   = 255
+)
+
+// modeOctet is the only supported transfer mode.
+const modeOctet = "octet"
+
+// headerLen is the length of the header of the data and acknowledgment
+// packets.
+const headerLen = 4
+
+// option is a single option of a read request.
+type option struct {
+	name  string
+	value string
+}
+
+// readRequest is a parsed read request.
+type readRequest struct {
+	// filename is the requested file name as sent by the client.
+	filename string
+
+	// mode is the lower-cased transfer mode.
+	mode string
+
+	// options are the options of the request in the order of appearance.
+	options []option
+}
+
+// parseRequest parses the request packet data.  op is the opcode of the
+// packet, and req is nil unless op is [opRRQ].
+func parseRequest(data []byte) (op opcode, req *readRequest, err error) {
+	if len(data) < 2 {
+		return 0, nil, errors.Error("packet too short")
+	}
+
+	op = opcode(binary.BigEndian.Uint16(data))
+	if op != opRRQ {
+		return op, nil, nil
+	}
+
+	fields := bytes.Split(data[2:], []byte{0})
+	// The packet ends with a zero byte, so the last field must be empty.
+	if len(fields) < 3 || len(fields[len(fields)-1]) != 0 {
+		return op, nil, errors.Error("malformed request")
+	}
+
+	fields = fields[:len(fields)-1]
+	if len(fields)%2 != 0 {
+		return op, nil, errors.Error("malformed options")
+	}
+
+	req = &readRequest{
+		filename: string(fields[0]),
+		mode:     strings.ToLower(string(fields[1])),
+	}
+
+	for i := 2; i < len(fields); i += 2 {
+		req.options = append(req.options, option{
+			name:  strings.ToLower(string(fields[i])),
+			value: string(fields[i+1]),
+		})
+	}
+
+	return op, req, nil
+}
+
+// negotiated are the parameters of a transfer negotiated with the client.
+type negotiated struct {
+	// acked are the options to acknowledge, if any.
+	acked []option
+
+	// blockSize is the size of the data blocks.
+	blockSize int
+
+	// timeoutSec is the retransmission timeout in seconds.
+	timeoutSec int
+}
+
+// negotiate returns the transfer parameters for req considering the defaults,
+// the maximum block size, and the size of the requested file.  The unknown and
+// invalid options are ignored as described in RFC 2347.
+func (req *readRequest) negotiate(maxBlockSize, defTimeoutSec int, size int64) (n *negotiated) {
+	n = &negotiated{
+		blockSize:  defaultBlockSize,
+		timeoutSec: defTimeoutSec,
+	}
+
+	for _, o := range req.options {
+		val, err := strconv.Atoi(o.value)
+		if err != nil {
+			continue
+		}
+
+		switch o.name {
+		case optBlockSize:
+			if val < minBlockSize {
+				continue
+			}
+
+			n.blockSize = min(val, maxBlockSize)
+			n.acked = append(n.acked, option{name: o.name, value: strconv.Itoa(n.blockSize)})
+		case optTimeout:
+			if val < 1 || val > maxTimeout {
+				continue
+			}
+
+			n.timeoutSec = val
+			n.acked = append(n.acked, o)
+		case optTransferSize:
+			// The client sends zero in a read request to ask for the size.
+			n.acked = append(n.acked, option{name: o.name, value: strconv.FormatInt(size, 10)})
+		default:
+			// Go on.
+		}
+	}
+
+	return n
+}
+
+// appendOACK appends the option acknowledgment packet for opts to b.
+func appendOACK(b []byte, opts []option) (res []byte) {
+	res = binary.BigEndian.AppendUint16(b, uint16(opOACK))
+	for _, o := range opts {
+		res = append(res, o.name...)
+		res = append(res, 0)
+		res = append(res, o.value...)
+		res = append(res, 0)
+	}
+
+	return res
+}
+
+// appendError appends the error packet with code and msg to b.
+func appendError(b []byte, code errCode, msg string) (res []byte) {
+	res = binary.BigEndian.AppendUint16(b, uint16(opError))
+	res = binary.BigEndian.AppendUint16(res, uint16(code))
+	res = append(res, msg...)
+
+	return append(res, 0)
+}
+
+// putDataHeader writes the header of the data packet for block into b, which
+// must be at least [headerLen] bytes long.
+func putDataHeader(b []byte, block uint16) {
+	binary.BigEndian.PutUint16(b, uint16(opData))
+	binary.BigEndian.PutUint16(b[2:], block)
+}
+
+// ackBlock returns the block number of the acknowledgment packet data.  ok is
+// false if data isn't an acknowledgment.
+func ackBlock(data []byte) (block uint16, ok bool) {
+	if len(data) < headerLen || opcode(binary.BigEndian.Uint16(data)) != opAck {
+		return 0, false
+	}
+
+	return binary.BigEndian.Uint16(data[2:]), true
+}
+
+// errPeer is returned when the peer aborts the transfer with an error packet.
+const errPeer errors.Error = "peer error"
+
+// peerError returns the error sent by the peer within data, if data is an error
+// packet.  err wraps [errPeer].
+func peerError(data []byte) (err error) {
+	if len(data) < headerLen || opcode(binary.BigEndian.Uint16(data)) != opError {
+		return nil
+	}
+
+	code := binary.BigEndian.Uint16(data[2:])
+	msg, _, _ := bytes.Cut(data[headerLen:], []byte{0})
+
+	return fmt.Errorf("%w %d: %q", errPeer, code, msg)
+}
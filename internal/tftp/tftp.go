@@ -0,0 +1,372 @@
+// Package tftp implements a read-only TFTP server for network boot.
+//
+// See https://datatracker.ietf.org/doc/html/rfc1350.
+package tftp
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/AdguardTeam/golibs/logutil/slogutil"
+	"github.com/AdguardTeam/golibs/netutil"
+	"github.com/AdguardTeam/golibs/service"
+)
+
+// DefaultPort is the default port of the TFTP server.
+const DefaultPort uint16 = 69
+
+// Transfer parameters.
+const (
+	// defaultBlockSize is the block size used unless the client negotiates
+	// another one.
+	defaultBlockSize = 512
+
+	// maxBlockSize is the maximum block size to negotiate.  It fits the
+	// packet into the Ethernet MTU to avoid the IP fragmentation.
+	maxBlockSize = 1468
+
+	// defaultTimeoutSec is the retransmission timeout used unless the client
+	// negotiates another one.
+	defaultTimeoutSec = 2
+
+	// maxRetransmits is the number of times a packet is resent before the
+	// transfer is aborted.
+	maxRetransmits = 5
+
+	// maxRequestLen is the maximum length of a request packet.
+	maxRequestLen = 512
+)
+
+// Default limits of the concurrent transfers.
+const (
+	// DefaultMaxTransfers is the default maximum number of concurrent
+	// transfers.
+	DefaultMaxTransfers uint = 64
+
+	// DefaultMaxPeerTransfers is the default maximum number of concurrent
+	// transfers to a single peer address.
+	DefaultMaxPeerTransfers uint = 4
+)
+
+// dirPerm is the permissions for the root directory created on start.
+const dirPerm os.FileMode = 0o755
+
+// Config is the configuration of the TFTP server.
+type Config struct {
+	// Logger is used to log the server events.  It must not be nil.
+	Logger *slog.Logger
+
+	// Root is the directory to serve the files from.  It's created on start if
+	// it doesn't exist.  It must not be empty.
+	Root string
+
+	// Addrs are the addresses to listen on.  It must not be empty.
+	Addrs []netip.AddrPort
+
+	// MaxTransfers is the maximum number of concurrent transfers.  If zero,
+	// [DefaultMaxTransfers] is used.
+	MaxTransfers uint
+
+	// MaxPeerTransfers is the maximum number of concurrent transfers to a
+	// single peer address.  If zero, [DefaultMaxPeerTransfers] is used.
+	MaxPeerTransfers uint
+}
+
+// Server is a read-only TFTP server.
+type Server struct {
+	logger *slog.Logger
+
+	// mu protects conns, transfers, and peerTransfers.
+	mu *sync.Mutex
+
+	// conns are the connections receiving the requests.  It's empty when the
+	// server isn't running.
+	conns []net.PacketConn
+
+	// transfers are the connections of the ongoing transfers mapped to the
+	// addresses of their peers.
+	transfers map[net.PacketConn]netip.Addr
+
+	// peerTransfers are the numbers of the ongoing transfers per peer address.
+	peerTransfers map[netip.Addr]uint
+
+	// wg tracks the serving goroutines.
+	wg *sync.WaitGroup
+
+	// root is the directory to serve the files from.
+	root string
+
+	// realRoot is root with the symbolic links resolved.  It's set on start.
+	realRoot string
+
+	// addrs are the addresses to listen on.
+	addrs []netip.AddrPort
+
+	// maxTransfers is the maximum number of concurrent transfers.
+	maxTransfers uint
+
+	// maxPeerTransfers is the maximum number of concurrent transfers to a
+	// single peer address.
+	maxPeerTransfers uint
+}
+
+// New returns a new properly initialized *Server.
+func New(conf *Config) (srv *Server, err error) {
+	if conf.Root == "" {
+		return nil, errors.Error("tftp: empty root directory")
+	} else if len(conf.Addrs) == 0 {
+		return nil, errors.Error("tftp: no addresses")
+	}
+
+	for i, addr := range conf.Addrs {
+		if !addr.IsValid() {
+			return nil, fmt.Errorf("tftp: invalid address at index %d", i)
+		}
+	}
+
+	srv = &Server{
+		logger:           conf.Logger,
+		mu:               &sync.Mutex{},
+		transfers:        map[net.PacketConn]netip.Addr{},
+		peerTransfers:    map[netip.Addr]uint{},
+		wg:               &sync.WaitGroup{},
+		root:             conf.Root,
+		addrs:            conf.Addrs,
+		maxTransfers:     conf.MaxTransfers,
+		maxPeerTransfers: conf.MaxPeerTransfers,
+	}
+
+	if srv.maxTransfers == 0 {
+		srv.maxTransfers = DefaultMaxTransfers
+	}
+
+	if srv.maxPeerTransfers == 0 {
+		srv.maxPeerTransfers = DefaultMaxPeerTransfers
+	}
+
+	return srv, nil
+}
+
+// type check
+var _ service.Interface = (*Server)(nil)
+
+// Start implements the [service.Interface] interface for *Server.  It creates
+// the root directory, if needed, and starts serving the requests.
+func (srv *Server) Start(ctx context.Context) (err error) {
+	defer func() { err = errors.Annotate(err, "starting tftp server: %w") }()
+
+	err = os.MkdirAll(srv.root, dirPerm)
+	if err != nil {
+		return fmt.Errorf("creating root: %w", err)
+	}
+
+	srv.realRoot, err = filepath.EvalSymlinks(srv.root)
+	if err != nil {
+		return fmt.Errorf("resolving root: %w", err)
+	}
+
+	conns := make([]net.PacketConn, 0, len(srv.addrs))
+	for _, addr := range srv.addrs {
+		var conn net.PacketConn
+		conn, err = net.ListenPacket("udp", addr.String())
+		if err != nil {
+			for _, c := range conns {
+				err = errors.WithDeferred(err, c.Close())
+			}
+
+			// Don't wrap the error, because it's informative enough as is.
+			return err
+		}
+
+		conns = append(conns, conn)
+	}
+
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+
+	srv.conns = conns
+
+	for i, conn := range conns {
+		srv.wg.Add(1)
+		go srv.serve(context.WithoutCancel(ctx), conn, srv.addrs[i].Addr())
+
+		srv.logger.InfoContext(ctx, "started", "addr", conn.LocalAddr(), "root", srv.root)
+	}
+
+	return nil
+}
+
+// Shutdown implements the [service.Interface] interface for *Server.  It
+// closes the connections, aborting the ongoing transfers, and waits for the
+// serving goroutines to finish.
+func (srv *Server) Shutdown(ctx context.Context) (err error) {
+	srv.mu.Lock()
+	if len(srv.conns) == 0 {
+		srv.mu.Unlock()
+
+		return nil
+	}
+
+	var errs []error
+	for _, conn := range srv.conns {
+		errs = append(errs, conn.Close())
+	}
+
+	for conn := range srv.transfers {
+		errs = append(errs, conn.Close())
+	}
+
+	srv.conns = nil
+	srv.mu.Unlock()
+
+	srv.wg.Wait()
+
+	srv.logger.InfoContext(ctx, "stopped")
+
+	return errors.Annotate(errors.Join(errs...), "shutting down tftp server: %w")
+}
+
+// LocalAddrs returns the addresses the server listens on.  It returns nil if
+// the server isn't running.
+func (srv *Server) LocalAddrs() (addrs []net.Addr) {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+
+	for _, conn := range srv.conns {
+		addrs = append(addrs, conn.LocalAddr())
+	}
+
+	return addrs
+}
+
+// serve handles the requests received on conn, listening on laddr, until it's
+// closed.
+func (srv *Server) serve(ctx context.Context, conn net.PacketConn, laddr netip.Addr) {
+	defer srv.wg.Done()
+	defer slogutil.RecoverAndLog(ctx, srv.logger)
+
+	buf := make([]byte, maxRequestLen)
+	for {
+		n, peer, err := conn.ReadFrom(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+
+			srv.logger.WarnContext(ctx, "reading request", slogutil.KeyError, err)
+
+			continue
+		}
+
+		srv.handle(ctx, conn, laddr, peer, buf[:n])
+	}
+}
+
+// handle handles the request packet data received from peer on conn, listening
+// on laddr.
+func (srv *Server) handle(
+	ctx context.Context,
+	conn net.PacketConn,
+	laddr netip.Addr,
+	peer net.Addr,
+	data []byte,
+) {
+	op, req, err := parseRequest(data)
+	switch {
+	case err != nil:
+		srv.logger.DebugContext(ctx, "parsing request", "peer", peer, slogutil.KeyError, err)
+		srv.sendError(ctx, conn, peer, errCodeIllegalOp, err.Error())
+	case op == opWRQ:
+		srv.sendError(ctx, conn, peer, errCodeAccess, "server is read-only")
+	case op != opRRQ:
+		srv.sendError(ctx, conn, peer, errCodeIllegalOp, "unexpected packet")
+	case req.mode != modeOctet:
+		srv.sendError(ctx, conn, peer, errCodeUndefined, "only octet mode is supported")
+	default:
+		srv.startTransfer(ctx, conn, laddr, peer, req)
+	}
+}
+
+// sendError sends the error packet with code and msg to peer using conn.
+func (srv *Server) sendError(
+	ctx context.Context,
+	conn net.PacketConn,
+	peer net.Addr,
+	code errCode,
+	msg string,
+) {
+	_, err := conn.WriteTo(appendError(nil, code, msg), peer)
+	if err != nil {
+		srv.logger.DebugContext(ctx, "sending error", "peer", peer, slogutil.KeyError, err)
+	}
+}
+
+// startTransfer opens the connection on laddr for the transfer requested by
+// peer and starts it in a separate goroutine.  If there are too many ongoing
+// transfers, the error is sent to peer using reqConn instead.
+func (srv *Server) startTransfer(
+	ctx context.Context,
+	reqConn net.PacketConn,
+	laddr netip.Addr,
+	peer net.Addr,
+	req *readRequest,
+) {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+
+	if len(srv.conns) == 0 {
+		// The server is shutting down.
+		return
+	}
+
+	peerAddr := netutil.NetAddrToAddrPort(peer).Addr()
+	if uint(len(srv.transfers)) >= srv.maxTransfers ||
+		srv.peerTransfers[peerAddr] >= srv.maxPeerTransfers {
+		srv.logger.DebugContext(ctx, "too many transfers", "peer", peer, "file", req.filename)
+		srv.sendError(ctx, reqConn, peer, errCodeUndefined, "too many transfers")
+
+		return
+	}
+
+	// Each transfer uses its own port, which identifies it.
+	//
+	// See https://datatracker.ietf.org/doc/html/rfc1350#section-4.
+	conn, err := net.ListenPacket("udp", netip.AddrPortFrom(laddr, 0).String())
+	if err != nil {
+		srv.logger.ErrorContext(ctx, "opening transfer connection", slogutil.KeyError, err)
+
+		return
+	}
+
+	srv.transfers[conn] = peerAddr
+	srv.peerTransfers[peerAddr]++
+
+	srv.wg.Add(1)
+	go srv.transfer(ctx, conn, peer, req)
+}
+
+// finishTransfer closes conn of the finished transfer.
+func (srv *Server) finishTransfer(ctx context.Context, conn net.PacketConn) {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+
+	peerAddr := srv.transfers[conn]
+	delete(srv.transfers, conn)
+
+	srv.peerTransfers[peerAddr]--
+	if srv.peerTransfers[peerAddr] == 0 {
+		delete(srv.peerTransfers, peerAddr)
+	}
+
+	err := conn.Close()
+	if err != nil && !errors.Is(err, net.ErrClosed) {
+		srv.logger.DebugContext(ctx, "closing transfer connection", slogutil.KeyError, err)
+	}
+}
@@ -0,0 +1,202 @@
+package tftp_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/tftp"
+	"github.com/AdguardTeam/golibs/logutil/slogutil"
+	"github.com/AdguardTeam/golibs/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testTimeout is the common timeout for tests.
+const testTimeout = 1 * time.Second
+
+// Opcodes of the TFTP packets used in tests.
+const (
+	opRRQ   uint16 = 1
+	opWRQ   uint16 = 2
+	opData  uint16 = 3
+	opAck   uint16 = 4
+	opError uint16 = 5
+	opOACK  uint16 = 6
+)
+
+// newRequest returns the request packet of type op for the file with name and
+// the options in the name-value form.
+func newRequest(op uint16, name string, opts ...string) (pkt []byte) {
+	pkt = binary.BigEndian.AppendUint16(nil, op)
+	for _, f := range append([]string{name, "octet"}, opts...) {
+		pkt = append(pkt, f...)
+		pkt = append(pkt, 0)
+	}
+
+	return pkt
+}
+
+// newAck returns the acknowledgment packet for block.
+func newAck(block uint16) (pkt []byte) {
+	pkt = binary.BigEndian.AppendUint16(nil, opAck)
+
+	return binary.BigEndian.AppendUint16(pkt, block)
+}
+
+// newTestServer starts the server serving the files from root with the limit
+// of transfers per peer and returns its address.
+func newTestServer(t *testing.T, root string, maxPeerTransfers uint) (addr net.Addr) {
+	t.Helper()
+
+	srv, err := tftp.New(&tftp.Config{
+		Logger:           slogutil.NewDiscardLogger(),
+		Root:             root,
+		Addrs:            []netip.AddrPort{netip.MustParseAddrPort("127.0.0.1:0")},
+		MaxPeerTransfers: maxPeerTransfers,
+	})
+	require.NoError(t, err)
+
+	ctx := testutil.ContextWithTimeout(t, testTimeout)
+	err = srv.Start(ctx)
+	require.NoError(t, err)
+	testutil.CleanupAndRequireSuccess(t, func() (err error) { return srv.Shutdown(ctx) })
+
+	addrs := srv.LocalAddrs()
+	require.Len(t, addrs, 1)
+
+	return addrs[0]
+}
+
+// exchange sends pkt to addr using conn and returns the response and the
+// address it came from.
+func exchange(t *testing.T, conn net.PacketConn, addr net.Addr, pkt []byte) (resp []byte, from net.Addr) {
+	t.Helper()
+
+	_, err := conn.WriteTo(pkt, addr)
+	require.NoError(t, err)
+
+	err = conn.SetReadDeadline(time.Now().Add(testTimeout))
+	require.NoError(t, err)
+
+	buf := make([]byte, 2048)
+	n, from, err := conn.ReadFrom(buf)
+	require.NoError(t, err)
+	require.GreaterOrEqual(t, n, 4)
+
+	return buf[:n], from
+}
+
+func TestServer(t *testing.T) {
+	root := t.TempDir()
+
+	content := bytes.Repeat([]byte("0123456789"), 150)
+	err := os.WriteFile(filepath.Join(root, "boot.efi"), content, 0o644)
+	require.NoError(t, err)
+
+	err = os.WriteFile(filepath.Join(t.TempDir(), "secret"), []byte("secret"), 0o644)
+	require.NoError(t, err)
+
+	addr := newTestServer(t, root, 0)
+
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	testutil.CleanupAndRequireSuccess(t, conn.Close)
+
+	t.Run("options", func(t *testing.T) {
+		resp, tid := exchange(t, conn, addr, newRequest(opRRQ, "/boot.efi", "blksize", "1024", "tsize", "0"))
+		require.Equal(t, opOACK, binary.BigEndian.Uint16(resp))
+		assert.Equal(t, "blksize\x001024\x00tsize\x001500\x00", string(resp[2:]))
+
+		var got []byte
+		for block := uint16(0); ; block++ {
+			resp, _ = exchange(t, conn, tid, newAck(block))
+			require.Equal(t, opData, binary.BigEndian.Uint16(resp))
+			require.Equal(t, block+1, binary.BigEndian.Uint16(resp[2:]))
+
+			got = append(got, resp[4:]...)
+			if len(resp[4:]) < 1024 {
+				_, err = conn.WriteTo(newAck(block+1), tid)
+				require.NoError(t, err)
+
+				break
+			}
+		}
+
+		assert.Equal(t, content, got)
+	})
+
+	t.Run("default", func(t *testing.T) {
+		resp, tid := exchange(t, conn, addr, newRequest(opRRQ, `\boot.efi`))
+		require.Equal(t, opData, binary.BigEndian.Uint16(resp))
+		assert.Len(t, resp[4:], 512)
+
+		// Abort the transfer.
+		_, err = conn.WriteTo(append(binary.BigEndian.AppendUint16(nil, opError), 0, 0, 0), tid)
+		require.NoError(t, err)
+	})
+
+	testCases := []struct {
+		name     string
+		req      []byte
+		wantCode uint16
+	}{{
+		name:     "not_found",
+		req:      newRequest(opRRQ, "missing.efi"),
+		wantCode: 1,
+	}, {
+		name:     "outside_root",
+		req:      newRequest(opRRQ, "../"+filepath.Base(root)+"/../secret"),
+		wantCode: 2,
+	}, {
+		name:     "write",
+		req:      newRequest(opWRQ, "boot.efi"),
+		wantCode: 2,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			resp, _ := exchange(t, conn, addr, tc.req)
+			require.Equal(t, opError, binary.BigEndian.Uint16(resp))
+
+			assert.Equal(t, tc.wantCode, binary.BigEndian.Uint16(resp[2:]))
+		})
+	}
+}
+
+func TestServer_peerTransfers(t *testing.T) {
+	root := t.TempDir()
+
+	content := bytes.Repeat([]byte("0123456789"), 150)
+	err := os.WriteFile(filepath.Join(root, "boot.efi"), content, 0o644)
+	require.NoError(t, err)
+
+	addr := newTestServer(t, root, 1)
+
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	testutil.CleanupAndRequireSuccess(t, conn.Close)
+
+	resp, tid := exchange(t, conn, addr, newRequest(opRRQ, "boot.efi"))
+	require.Equal(t, opData, binary.BigEndian.Uint16(resp))
+
+	// The first transfer is still ongoing.
+	resp, _ = exchange(t, conn, addr, newRequest(opRRQ, "boot.efi"))
+	require.Equal(t, opError, binary.BigEndian.Uint16(resp))
+
+	assert.Equal(t, "too many transfers\x00", string(resp[4:]))
+
+	// Abort the first transfer.
+	_, err = conn.WriteTo(append(binary.BigEndian.AppendUint16(nil, opError), 0, 0, 0), tid)
+	require.NoError(t, err)
+
+	require.EventuallyWithT(t, func(ct *assert.CollectT) {
+		resp, _ = exchange(t, conn, addr, newRequest(opRRQ, "boot.efi"))
+		assert.Equal(ct, opData, binary.BigEndian.Uint16(resp))
+	}, testTimeout, testTimeout/10)
+}
@@ -0,0 +1,224 @@
+package tftp
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"net"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/AdguardTeam/golibs/logutil/slogutil"
+)
+
+// errAccess is returned when the requested file is outside of the root
+// directory or isn't a regular file.
+const errAccess errors.Error = "access violation"
+
+// transfer sends the file requested by peer within req using conn.
+func (srv *Server) transfer(ctx context.Context, conn net.PacketConn, peer net.Addr, req *readRequest) {
+	defer srv.wg.Done()
+	defer slogutil.RecoverAndLog(ctx, srv.logger)
+	defer srv.finishTransfer(ctx, conn)
+
+	l := srv.logger.With("peer", peer, "file", req.filename)
+
+	f, size, err := srv.open(req.filename)
+	if err != nil {
+		l.DebugContext(ctx, "opening file", slogutil.KeyError, err)
+
+		code, msg := errCodeNotFound, "file not found"
+		if errors.Is(err, errAccess) {
+			code, msg = errCodeAccess, errAccess.Error()
+		}
+
+		srv.sendError(ctx, conn, peer, code, msg)
+
+		return
+	}
+	defer slogutil.CloseAndLog(ctx, l, f, slog.LevelDebug)
+
+	n := req.negotiate(maxBlockSize, defaultTimeoutSec, size)
+	t := &transferState{
+		conn:    conn,
+		peer:    peer,
+		timeout: time.Duration(n.timeoutSec) * time.Second,
+		buf:     make([]byte, headerLen+n.blockSize),
+	}
+
+	err = t.send(f, n)
+	if err != nil {
+		l.WarnContext(ctx, "transfer failed", slogutil.KeyError, err)
+
+		// Don't respond to the peer that has aborted the transfer itself, see
+		// RFC 1350, section 7.
+		if !errors.Is(err, net.ErrClosed) && !errors.Is(err, errPeer) {
+			srv.sendError(ctx, conn, peer, errCodeUndefined, "transfer failed")
+		}
+
+		return
+	}
+
+	l.InfoContext(ctx, "sent file", "size", size)
+}
+
+// open opens the file with name within the root directory and returns its
+// size.  name is treated as a slash- or backslash-separated path relative to
+// the root directory.
+func (srv *Server) open(name string) (f *os.File, size int64, err error) {
+	name = strings.ReplaceAll(name, `\`, "/")
+	name = path.Clean(strings.TrimLeft(name, "/"))
+
+	localName := filepath.FromSlash(name)
+	if !filepath.IsLocal(localName) {
+		return nil, 0, errAccess
+	}
+
+	// Resolve the symbolic links to make sure they don't lead outside of the
+	// root directory.
+	realName, err := filepath.EvalSymlinks(filepath.Join(srv.realRoot, localName))
+	if err != nil {
+		// Don't wrap the error, because it's informative enough as is.
+		return nil, 0, err
+	}
+
+	rel, err := filepath.Rel(srv.realRoot, realName)
+	if err != nil || !filepath.IsLocal(rel) {
+		return nil, 0, errAccess
+	}
+
+	f, err = os.Open(realName)
+	if err != nil {
+		// Don't wrap the error, because it's informative enough as is.
+		return nil, 0, err
+	}
+
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, 0, errors.WithDeferred(err, f.Close())
+	} else if fi.Mode()&fs.ModeType != 0 {
+		return nil, 0, errors.WithDeferred(errAccess, f.Close())
+	}
+
+	return f, fi.Size(), nil
+}
+
+// transferState is the state of a single transfer.
+type transferState struct {
+	// conn is the connection of the transfer.
+	conn net.PacketConn
+
+	// peer is the address of the client.
+	peer net.Addr
+
+	// buf is the buffer for the data packets.
+	buf []byte
+
+	// timeout is the retransmission timeout.
+	timeout time.Duration
+}
+
+// send sends the data from r in blocks of the negotiated size.
+func (t *transferState) send(r io.Reader, n *negotiated) (err error) {
+	if len(n.acked) > 0 {
+		// The client acknowledges the options with block number zero.
+		err = t.sendAndWait(appendOACK(nil, n.acked), 0)
+		if err != nil {
+			return fmt.Errorf("acknowledging options: %w", err)
+		}
+	}
+
+	// The block number wraps around for the files larger than 65535 blocks,
+	// which is supported by most of the clients.
+	for block := uint16(1); ; block++ {
+		var read int
+		read, err = io.ReadFull(r, t.buf[headerLen:])
+		last := errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF)
+		if err != nil && !last {
+			return fmt.Errorf("reading block %d: %w", block, err)
+		}
+
+		putDataHeader(t.buf, block)
+		err = t.sendAndWait(t.buf[:headerLen+read], block)
+		if err != nil {
+			return fmt.Errorf("sending block %d: %w", block, err)
+		}
+
+		// A block shorter than the negotiated size, including the empty
+		// one, terminates the transfer.
+		if last {
+			return nil
+		}
+	}
+}
+
+// sendAndWait sends pkt to the peer and waits for the acknowledgment of block,
+// resending pkt on timeouts.
+func (t *transferState) sendAndWait(pkt []byte, block uint16) (err error) {
+	resp := make([]byte, maxRequestLen)
+	for range maxRetransmits + 1 {
+		_, err = t.conn.WriteTo(pkt, t.peer)
+		if err != nil {
+			// Don't wrap the error, because it's informative enough as is.
+			return err
+		}
+
+		var acked bool
+		acked, err = t.waitAck(resp, block)
+		if err != nil {
+			// Don't wrap the error, because it's informative enough as is.
+			return err
+		} else if acked {
+			return nil
+		}
+	}
+
+	return errors.Error("timed out")
+}
+
+// waitAck waits for the acknowledgment of block until the timeout.  acked is
+// false if the timeout is reached.  The duplicate acknowledgments of the
+// previous blocks are ignored to avoid the Sorcerer's Apprentice Syndrome.
+//
+// See https://datatracker.ietf.org/doc/html/rfc1123#page-45.
+func (t *transferState) waitAck(buf []byte, block uint16) (acked bool, err error) {
+	err = t.conn.SetReadDeadline(time.Now().Add(t.timeout))
+	if err != nil {
+		return false, fmt.Errorf("setting deadline: %w", err)
+	}
+
+	for {
+		n, addr, readErr := t.conn.ReadFrom(buf)
+		if readErr != nil {
+			if errors.Is(readErr, os.ErrDeadlineExceeded) {
+				return false, nil
+			}
+
+			// Don't wrap the error, because it's informative enough as is.
+			return false, readErr
+		}
+
+		if addr.String() != t.peer.String() {
+			// Tell the stranger that it's not its transfer, see RFC 1350,
+			// section 4.
+			_, _ = t.conn.WriteTo(appendError(nil, errCodeUnknownTID, "unknown transfer id"), addr)
+
+			continue
+		}
+
+		if err = peerError(buf[:n]); err != nil {
+			// Don't wrap the error, because it's informative enough as is.
+			return false, err
+		}
+
+		if got, ok := ackBlock(buf[:n]); ok && got == block {
+			return true, nil
+		}
+	}
+}